@@ -0,0 +1,89 @@
+package capabilities
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/internal/poolfile"
+	"github.com/drone-runners/drone-runner-aws/store/database"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const runnerName = "capabilities"
+
+type capabilitiesCommand struct {
+	envFile  string
+	poolFile string
+	json     bool
+}
+
+// Register registers the command showing the features supported by the drivers of the pools.
+func Register(app *kingpin.Application) {
+	c := new(capabilitiesCommand)
+
+	cmd := app.Command("capabilities", "shows the features supported by the drivers of the pools").
+		Action(c.run)
+	cmd.Flag("envfile", "load the environment variable file").
+		StringVar(&c.envFile)
+	cmd.Flag("pool", "the pool file").
+		StringVar(&c.poolFile)
+	cmd.Flag("json", "print the capabilities as json").
+		BoolVar(&c.json)
+}
+
+func (c *capabilitiesCommand) run(*kingpin.ParseContext) error {
+	// load environment variables from file.
+	if err := godotenv.Load(c.envFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	env, err := config.FromEnviron()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	store, _, _, _, _, _, _, err := database.ProvideStore(database.SingleInstance, "")
+	if err != nil {
+		return err
+	}
+	poolManager := drivers.New(ctx, store, &env)
+
+	configPool, err := poolfile.ConfigPoolFile(c.poolFile, &env)
+	if err != nil {
+		return fmt.Errorf("capabilities: unable to load the pool file: %w", err)
+	}
+	pools, err := poolfile.ProcessPool(configPool, runnerName)
+	if err != nil {
+		return fmt.Errorf("capabilities: unable to process the pool file: %w", err)
+	}
+	if err = poolManager.Add(pools...); err != nil {
+		return fmt.Errorf("capabilities: unable to add the pools: %w", err)
+	}
+
+	capabilities := poolManager.Capabilities()
+	if c.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(capabilities)
+	}
+	return printTable(capabilities)
+}
+
+func printTable(capabilities []drivers.PoolCapabilities) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint:gomnd
+	fmt.Fprintln(w, "POOL\tDRIVER\tHIBERNATE\tCONSOLE LOGS\tTAGS\tSPOT\tRESIZE\tZONES\tUNTRUSTED\tCAPACITY")
+	for i := range capabilities {
+		p := &capabilities[i]
+		c := p.Capabilities
+		fmt.Fprintf(w, "%s\t%s\t%t\t%t\t%t\t%t\t%t\t%t\t%t\t%t\n",
+			p.Name, p.Driver, c.Hibernate, c.ConsoleLogs, c.Tags, c.Spot, c.Resize, c.Zones, c.Untrusted, c.Capacity)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,101 @@
+package capacity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/internal/poolfile"
+	"github.com/drone-runners/drone-runner-aws/store/database"
+
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const runnerName = "capacity"
+
+type capacityCommand struct {
+	envFile  string
+	poolFile string
+	json     bool
+}
+
+// Register registers the command showing the free and the reserved resources of the nodes of the pools.
+func Register(app *kingpin.Application) {
+	c := new(capacityCommand)
+
+	cmd := app.Command("capacity", "shows the reserved and free resources of the nodes of the pools").
+		Action(c.run)
+	cmd.Flag("envfile", "load the environment variable file").
+		StringVar(&c.envFile)
+	cmd.Flag("pool", "the pool file").
+		StringVar(&c.poolFile)
+	cmd.Flag("json", "print the capacity as json").
+		BoolVar(&c.json)
+}
+
+func (c *capacityCommand) run(*kingpin.ParseContext) error {
+	// load environment variables from file.
+	if err := godotenv.Load(c.envFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	env, err := config.FromEnviron()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	store, _, _, _, _, _, _, err := database.ProvideStore(database.SingleInstance, "")
+	if err != nil {
+		return err
+	}
+	poolManager := drivers.New(ctx, store, &env)
+
+	configPool, err := poolfile.ConfigPoolFile(c.poolFile, &env)
+	if err != nil {
+		return fmt.Errorf("capacity: unable to load the pool file: %w", err)
+	}
+	pools, err := poolfile.ProcessPool(configPool, runnerName)
+	if err != nil {
+		return fmt.Errorf("capacity: unable to process the pool file: %w", err)
+	}
+	if err = poolManager.Add(pools...); err != nil {
+		return fmt.Errorf("capacity: unable to add the pools: %w", err)
+	}
+
+	capacities, err := poolManager.Capacity(ctx)
+	if err != nil {
+		return err
+	}
+	if len(capacities) == 0 {
+		logrus.Warnln("capacity: none of the pools is scheduled on a fleet of nodes")
+		return nil
+	}
+
+	if c.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(capacities)
+	}
+	return printTable(capacities)
+}
+
+func printTable(capacities []drivers.PoolCapacity) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint:gomnd
+	fmt.Fprintln(w, "POOL\tNODE\tSTATUS\tELIGIBLE\tCPU TOTAL\tCPU RESERVED\tCPU RUNNER\tCPU FREE\tMEM TOTAL\tMEM RESERVED\tMEM RUNNER\tMEM FREE")
+	for _, pool := range capacities {
+		for i := range pool.Nodes {
+			n := &pool.Nodes[i]
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%dMHz\t%dMHz\t%dMHz\t%dMHz\t%dMB\t%dMB\t%dMB\t%dMB\n",
+				pool.Name, n.Name, n.Status, n.Eligible,
+				n.CPUTotalMhz, n.CPUReservedMhz, n.CPURunnerMhz, n.CPUFreeMhz,
+				n.MemoryTotalMB, n.MemoryReservedMB, n.MemoryRunnerMB, n.MemoryFreeMB)
+		}
+	}
+	return w.Flush()
+}
@@ -8,11 +8,17 @@ import (
 	"context"
 	"os"
 
+	"github.com/drone-runners/drone-runner-aws/command/capabilities"
+	"github.com/drone-runners/drone-runner-aws/command/capacity"
 	"github.com/drone-runners/drone-runner-aws/command/daemon"
+	"github.com/drone-runners/drone-runner-aws/command/db"
 	"github.com/drone-runners/drone-runner-aws/command/harness/delegate"
 	"github.com/drone-runners/drone-runner-aws/command/harness/delegate/tester"
 	"github.com/drone-runners/drone-runner-aws/command/harness/dlite"
+	"github.com/drone-runners/drone-runner-aws/command/instances"
+	"github.com/drone-runners/drone-runner-aws/command/pool"
 	"github.com/drone-runners/drone-runner-aws/command/setup"
+	"github.com/drone-runners/drone-runner-aws/command/simulate"
 
 	"gopkg.in/alecthomas/kingpin.v2"
 )
@@ -32,7 +38,13 @@ func Command() {
 	delegate.RegisterDelegate(app)
 	dlite.RegisterDlite(app)
 	setup.Register(app)
+	capacity.Register(app)
+	capabilities.Register(app)
+	db.Register(app)
+	pool.Register(app)
+	instances.Register(app)
 	tester.Register(app)
+	simulate.Register(app)
 
 	kingpin.Version(version)
 	kingpin.MustParse(app.Parse(os.Args[1:]))
@@ -5,26 +5,68 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/drone-runners/drone-runner-aws/internal/fips"
 	"github.com/drone-runners/drone-runner-aws/types"
 
 	"github.com/joho/godotenv"
-	"github.com/kelseyhightower/envconfig"
+	"github.com/sirupsen/logrus"
 )
 
 type (
 	PoolFile struct {
 		Version   string     `json:"version" yaml:"version"`
 		Instances []Instance `json:"instances" yaml:"instances"`
+		// Routes select the pools of the setup requests without a pool, by platform and resource class.
+		Routes []Route `json:"routes,omitempty" yaml:"routes,omitempty"`
+	}
+
+	// Route selects the pool of the setup requests of a platform and a resource class which do not
+	// name a pool, the empty fields match any value. The most specific matching route is used.
+	Route struct {
+		OS            string   `json:"os,omitempty" yaml:"os,omitempty"`
+		Arch          string   `json:"arch,omitempty" yaml:"arch,omitempty"`
+		ResourceClass string   `json:"resource_class,omitempty" yaml:"resource_class,omitempty"` // e.g. small or large, declared by the caller
+		Pool          string   `json:"pool" yaml:"pool"`
+		FallbackPools []string `json:"fallback_pools,omitempty" yaml:"fallback_pools,omitempty"`
 	}
 
 	Instance struct {
-		Name     string         `json:"name"`
-		Default  bool           `json:"default"`
-		Type     string         `json:"type"`
-		Pool     int            `json:"pool"`
-		Limit    int            `json:"limit"`
-		Platform types.Platform `json:"platform,omitempty" yaml:"platform,omitempty"`
-		Spec     interface{}    `json:"spec,omitempty"`
+		Name          string                 `json:"name"`
+		Extends       string                 `json:"extends,omitempty" yaml:"extends,omitempty"` // the template or the pool whose settings the pool inherits
+		Default       bool                   `json:"default"`
+		Type          string                 `json:"type"`
+		Pool          int                    `json:"pool"`
+		Limit         int                    `json:"limit"`
+		Overflow      int                    `json:"overflow,omitempty" yaml:"overflow,omitempty"` // temporary instances created over the limit, destroyed after use
+		Platform      types.Platform         `json:"platform,omitempty" yaml:"platform,omitempty"`
+		Untrusted     types.UntrustedProfile `json:"untrusted,omitempty" yaml:"untrusted,omitempty"`
+		StartupScript string                 `json:"startup_script,omitempty" yaml:"startup_script,omitempty"` // cloud-init (default), shell or ignition
+		Bootstrap     types.Bootstrap        `json:"bootstrap,omitempty" yaml:"bootstrap,omitempty"`
+		LiteEngine    types.LiteEngine       `json:"lite_engine,omitempty" yaml:"lite_engine,omitempty"`
+		Preflight     bool                   `json:"preflight,omitempty" yaml:"preflight,omitempty"`                 // check the runner reaches a test instance before building the pool
+		NameTemplate  string                 `json:"name_template,omitempty" yaml:"name_template,omitempty"`         // e.g. ci-{{.Pool}}-{{.Stage}}-{{.Random}}
+		Rollout       types.RolloutPolicy    `json:"rollout,omitempty" yaml:"rollout,omitempty"`                     // rollout of the image changes of the pools defined as kubernetes resources
+		Schedule      types.PoolSchedule     `json:"schedule,omitempty" yaml:"schedule,omitempty"`                   // off-hours of the pool
+		StepTimeout   int64                  `json:"step_timeout_secs,omitempty" yaml:"step_timeout_secs,omitempty"` // timeout of the steps without a timeout of their own
+		Sizes         map[string]string      `json:"sizes,omitempty" yaml:"sizes,omitempty"`                         // the machine types of the resource classes the instances are resized to for the retried stages
+		Timeouts      types.Timeouts         `json:"timeouts,omitempty" yaml:"timeouts,omitempty"`                   // the timeouts of the operations of the driver
+		Spec          interface{}            `json:"spec,omitempty"`
+
+		// DestroyGracePeriod delays the destroy of the instances of the completed stages, e.g. for the uploads of the artifacts
+		// and of the caches started by the last steps. The destroy waits until the finalize script exits, if set, at most the
+		// grace period.
+		DestroyGracePeriod int64  `json:"destroy_grace_period_secs,omitempty" yaml:"destroy_grace_period_secs,omitempty"`
+		FinalizeScript     string `json:"finalize_script,omitempty" yaml:"finalize_script,omitempty"`
+		// DestroyHooks run on the instances of the completed stages right before they are destroyed, after the grace period.
+		DestroyHooks []types.DestroyHook `json:"destroy_hooks,omitempty" yaml:"destroy_hooks,omitempty"`
+		// Services are started on the instances for every stage, before its steps.
+		Services []types.PoolService `json:"services,omitempty" yaml:"services,omitempty"`
+		// WorkspaceSnapshot is extracted into the workspace for every stage, before its steps.
+		WorkspaceSnapshot types.WorkspaceSnapshot `json:"workspace_snapshot,omitempty" yaml:"workspace_snapshot,omitempty"`
+		// Credentials are the short-lived cloud credentials passed to the steps.
+		Credentials types.PoolCredentials `json:"credentials,omitempty" yaml:"credentials,omitempty"`
+		// Profile is the cloud identity attached to the instances.
+		Profile types.InstanceProfile `json:"profile,omitempty" yaml:"profile,omitempty"`
 	}
 
 	// Amazon specifies the configuration for an AWS instance.
@@ -34,6 +76,7 @@ type (
 		Size          string            `json:"size,omitempty"`
 		SizeAlt       string            `json:"size_alt,omitempty" yaml:"size_alt,omitempty"`
 		AMI           string            `json:"ami,omitempty"`
+		AMIFilter     AmazonAMIFilter   `json:"ami_filter,omitempty" yaml:"ami_filter,omitempty"` // the latest AMI matching the filter, instead of a fixed AMI
 		VPC           string            `json:"vpc,omitempty" yaml:"vpc,omitempty"`
 		Tags          map[string]string `json:"tags,omitempty" yaml:"tags,omitempty"`
 		Type          string            `json:"type,omitempty" yaml:"type,omitempty"`
@@ -46,7 +89,26 @@ type (
 		MarketType    string            `json:"market_type,omitempty" yaml:"market_type,omitempty"`
 		RootDirectory string            `json:"root_directory,omitempty" yaml:"root_directory,omitempty"`
 		Hibernate     bool              `json:"hibernate,omitempty"`
+		Standby       bool              `json:"standby,omitempty" yaml:"standby,omitempty"`
 		User          string            `json:"user,omitempty" yaml:"user,omitempty"`
+		Regions       []AmazonRegion    `json:"regions,omitempty" yaml:"regions,omitempty"`
+	}
+
+	// AmazonAMIFilter selects the latest available AMI whose name matches the pattern, e.g.
+	// ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-amd64-server-*, owned by one of the owners.
+	AmazonAMIFilter struct {
+		Name   string   `json:"name,omitempty" yaml:"name,omitempty"`
+		Owners []string `json:"owners,omitempty" yaml:"owners,omitempty"`
+	}
+
+	// AmazonRegion specifies an additional region of a multi-region pool.
+	AmazonRegion struct {
+		Region           string   `json:"region,omitempty"`
+		AvailabilityZone string   `json:"availability_zone,omitempty" yaml:"availability_zone,omitempty"`
+		AMI              string   `json:"ami,omitempty"`
+		SubnetID         string   `json:"subnet_id,omitempty" yaml:"subnet_id,omitempty"`
+		SecurityGroups   []string `json:"security_groups,omitempty" yaml:"security_groups,omitempty"`
+		KeyPairName      string   `json:"key_pair_name,omitempty" yaml:"key_pair_name,omitempty"`
 	}
 
 	AmazonAccount struct {
@@ -65,6 +127,8 @@ type (
 		SecurityGroups    []string `json:"security_groups,omitempty" yaml:"security_groups"`
 		SubnetID          string   `json:"subnet_id,omitempty" yaml:"subnet_id"`
 		PrivateIP         bool     `json:"private_ip,omitempty" yaml:"private_ip"`
+		// IPFamily is ipv4 (the default), dual for dual-stack or ipv6 for instances without a public IPv4 address.
+		IPFamily string `json:"ip_family,omitempty" yaml:"ip_family,omitempty"`
 	}
 
 	// Anka specifies the configuration for an Anka instance.
@@ -101,7 +165,13 @@ type (
 	}
 
 	NomadServer struct {
-		Address        string `json:"address" yaml:"address"`
+		Address string `json:"address" yaml:"address"`
+		// Addresses are the servers the runner fails over to when a server is unreachable, after
+		// the address if it is set.
+		Addresses []string `json:"addresses,omitempty" yaml:"addresses"`
+		// TLSServerName is the name the certificates of the servers are verified against and sent
+		// as SNI, e.g. server.global.nomad when the addresses are IPs or a load balancer.
+		TLSServerName  string `json:"tls_server_name,omitempty" yaml:"tls_server_name"`
 		Insecure       bool   `json:"insecure,omitempty" yaml:"insecure" default:"false"`
 		CaCertPath     string `json:"ca_cert_path,omitempty" yaml:"ca_cert_path"`
 		ClientKeyPath  string `json:"client_key_path,omitempty" yaml:"client_key_path"`
@@ -109,11 +179,21 @@ type (
 	}
 
 	NomadVM struct {
-		Image    string `json:"image" yaml:"image"`
-		MemoryGB string `json:"mem_gb" yaml:"mem_gb"`
-		Cpus     string `json:"cpus" yaml:"cpus"`
-		DiskSize string `json:"disk_size" yaml:"disk_size"`
-		Noop     bool   `json:"noop" yaml:"noop"`
+		Image         string `json:"image" yaml:"image"`
+		MemoryGB      string `json:"mem_gb" yaml:"mem_gb"`
+		Cpus          string `json:"cpus" yaml:"cpus"`
+		DiskSize      string `json:"disk_size" yaml:"disk_size"`
+		Noop          bool   `json:"noop" yaml:"noop"`
+		EnforceLimits bool   `json:"enforce_limits" yaml:"enforce_limits"`
+		User          string `json:"user" yaml:"user"`
+		// Timeouts of the jobs finding a node, starting and removing the VMs, the defaults if not set.
+		Timeouts NomadTimeouts `json:"timeouts,omitempty" yaml:"timeouts,omitempty"`
+	}
+
+	NomadTimeouts struct {
+		ResourceSecs int64 `json:"resource_secs,omitempty" yaml:"resource_secs,omitempty"` // 3 minutes by default
+		InitSecs     int64 `json:"init_secs,omitempty" yaml:"init_secs,omitempty"`         // 5 minutes by default
+		DestroySecs  int64 `json:"destroy_secs,omitempty" yaml:"destroy_secs,omitempty"`   // 10 minutes by default
 	}
 
 	// Azure specifies the configuration for an Azure instance.
@@ -171,6 +251,7 @@ type (
 	Google struct {
 		Account      GoogleAccount     `json:"account,omitempty"  yaml:"account"`
 		Image        string            `json:"image,omitempty" yaml:"image,omitempty"`
+		ImageFamily  string            `json:"image_family,omitempty" yaml:"image_family,omitempty"` // project/family, the latest image of the family instead of a fixed image
 		Name         string            `json:"name,omitempty" yaml:"name,omitempty"`
 		Tags         []string          `json:"tags,omitempty" yaml:"tags,omitempty"`
 		Size         string            `json:"size,omitempty" yaml:"size,omitempty"`
@@ -182,10 +263,20 @@ type (
 		Network      string            `json:"network,omitempty" yaml:"network,omitempty"`
 		Subnetwork   string            `json:"subnetwork,omitempty" yaml:"subnetwork,omitempty"`
 		PrivateIP    bool              `json:"private_ip,omitempty" yaml:"private_ip,omitempty"`
+		IPFamily     string            `json:"ip_family,omitempty" yaml:"ip_family,omitempty"` // ipv4 (the default), dual or ipv6
 		Zone         []string          `json:"zone,omitempty" yaml:"zone,omitempty"`
 		Labels       map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
 		Scopes       []string          `json:"scopes,omitempty" yaml:"scopes,omitempty"`
 		Hibernate    bool              `json:"hibernate,omitempty"`
+		Standby      bool              `json:"standby,omitempty" yaml:"standby,omitempty"`
+		Regions      []GoogleRegion    `json:"regions,omitempty" yaml:"regions,omitempty"`
+	}
+
+	// GoogleRegion specifies an additional region of a multi-region pool.
+	GoogleRegion struct {
+		Zone       []string `json:"zone,omitempty" yaml:"zone,omitempty"`
+		Network    string   `json:"network,omitempty" yaml:"network,omitempty"`
+		Subnetwork string   `json:"subnetwork,omitempty" yaml:"subnetwork,omitempty"`
 	}
 
 	GoogleAccount struct {
@@ -218,6 +309,21 @@ type (
 		Hibernate bool `json:"hibernate,omitempty" yaml:"hibernate,omitempty"`
 	}
 
+	// Static specifies a pool of pre-existing machines running the lite-engine.
+	Static struct {
+		Machines []StaticMachine `json:"machines" yaml:"machines"`
+	}
+
+	// StaticMachine specifies a pre-existing machine and the certificates used to connect to its lite-engine.
+	StaticMachine struct {
+		Name       string `json:"name" yaml:"name"`
+		Address    string `json:"address" yaml:"address"`
+		Port       int64  `json:"port,omitempty" yaml:"port,omitempty"`
+		CACertPath string `json:"ca_cert_path" yaml:"ca_cert_path"`
+		CertPath   string `json:"cert_path" yaml:"cert_path"`
+		KeyPath    string `json:"key_path" yaml:"key_path"`
+	}
+
 	// disk provides disk size and type.
 	disk struct {
 		Size     int64  `json:"size,omitempty" yaml:"size,omitempty"`
@@ -230,6 +336,9 @@ type (
 type EnvConfig struct {
 	Debug bool `envconfig:"DRONE_DEBUG"`
 	Trace bool `envconfig:"DRONE_TRACE"`
+	// LogFormat is text or json, the JSON logs name the fields of the stages, pools, drivers,
+	// instances and accounts consistently for the log pipelines.
+	LogFormat string `envconfig:"DRONE_LOG_FORMAT" default:"text"`
 
 	Anka struct {
 		VMName string `envconfig:"ANKA_VM_NAME"`
@@ -307,6 +416,16 @@ type EnvConfig struct {
 		ParallelWorkers       int                 `envconfig:"DLITE_PARALLEL_WORKERS" default:"100"`
 		PollIntervalMilliSecs int                 `envconfig:"DLITE_POLL_INTERVAL_MILLISECS" default:"3000"`
 		PoolMapByAccount      PoolMapperByAccount `envconfig:"DLITE_POOL_MAP_BY_ACCOUNT_ID"`
+		// PollConcurrency is the number of the concurrent polls of the task events, spread over the poll interval.
+		PollConcurrency int `envconfig:"DLITE_POLL_CONCURRENCY" default:"1"`
+		// SetupWorkers, StepWorkers and DestroyWorkers are the workers dedicated to the setup, the step and the destroy
+		// tasks, on top of the parallel workers shared by all the tasks.
+		SetupWorkers   int `envconfig:"DLITE_SETUP_WORKERS" default:"0"`
+		StepWorkers    int `envconfig:"DLITE_STEP_WORKERS" default:"0"`
+		DestroyWorkers int `envconfig:"DLITE_DESTROY_WORKERS" default:"1"`
+		// QueueSize is the number of the tasks of every type waiting for a worker, the tasks polled once the queue of
+		// their type is full are left for the next poll.
+		QueueSize int `envconfig:"DLITE_QUEUE_SIZE" default:"100"`
 	}
 
 	Settings struct {
@@ -319,6 +438,47 @@ type EnvConfig struct {
 		EnableAutoPool       bool   `envconfig:"DRONE_ENABLE_AUTO_POOL" default:"false"`
 		HarnessTestBinaryURI string `envconfig:"DRONE_HARNESS_TEST_BINARY_URI"`
 		PluginBinaryURI      string `envconfig:"DRONE_PLUGIN_BINARY_URI" default:"https://github.com/drone/plugin/releases/download/v0.1.6-beta"`
+		// DestroyRetryAlertThreshold is the number of failed destroys of an instance after which an alert is logged.
+		DestroyRetryAlertThreshold int `envconfig:"DRONE_SETTINGS_DESTROY_RETRY_ALERT_THRESHOLD" default:"5"`
+		// StepCPU and StepMemory are the default limits of the containers of the steps, e.g. 2 and 4g, unlimited if empty.
+		StepCPU    string `envconfig:"DRONE_SETTINGS_STEP_CPU"`
+		StepMemory string `envconfig:"DRONE_SETTINGS_STEP_MEMORY"`
+		// JanitorIntervalMins is how often the drivers clean the leftovers of destroyed instances on their hosts, 0 disables the janitor.
+		JanitorIntervalMins int64 `envconfig:"DRONE_SETTINGS_JANITOR_INTERVAL_MINS" default:"0"`
+		// MaintenanceCheckSecs is how often the drivers are asked for the scheduled maintenance and the interruptions of the
+		// instances, e.g. the scheduled events of EC2 or the spot interruption notices, 0 disables the checks.
+		MaintenanceCheckSecs int64 `envconfig:"DRONE_SETTINGS_MAINTENANCE_CHECK_SECS" default:"60"`
+		// ZombieProbeSecs is how often the lite-engines of the busy instances are probed, an instance whose lite-engine misses
+		// ZombieProbeFailures probes in a row is reported as unreachable. 0 disables the probes.
+		ZombieProbeSecs     int64 `envconfig:"DRONE_SETTINGS_ZOMBIE_PROBE_SECS" default:"0"`
+		ZombieProbeFailures int   `envconfig:"DRONE_SETTINGS_ZOMBIE_PROBE_FAILURES" default:"3"`
+		// ZombieRecycle interrupts the steps of the unreachable instances, so their stages can be retried, and destroys them.
+		ZombieRecycle bool `envconfig:"DRONE_SETTINGS_ZOMBIE_RECYCLE" default:"false"`
+		// ImagePrefetchIntervalMins is how often the drivers import the images of the pools on their hosts, e.g. the VM image
+		// on the Nomad nodes, the images are also imported when the runner starts. 0 disables the prefetch.
+		ImagePrefetchIntervalMins int64 `envconfig:"DRONE_SETTINGS_IMAGE_PREFETCH_INTERVAL_MINS" default:"0"`
+		// ImageResolveIntervalMins is how often the images of the pools selected by a filter or a family are looked up again, 0 only looks them up when the pools are built.
+		ImageResolveIntervalMins int64 `envconfig:"DRONE_SETTINGS_IMAGE_RESOLVE_INTERVAL_MINS" default:"360"`
+		// LeaseMaxAge is the hard max age, in hours, of the busy instances whose lease is extended past the busy max age.
+		LeaseMaxAge int64 `envconfig:"DRONE_SETTINGS_LEASE_MAX_AGE" default:"48"`
+		// LeaseHeartbeatMins is how often the lease of the instance running a step is extended while its lite-engine is healthy, 0 disables the heartbeat.
+		LeaseHeartbeatMins int64 `envconfig:"DRONE_SETTINGS_LEASE_HEARTBEAT_MINS" default:"5"`
+		// DiskCheckMins is how often the disk usage of the instances of the stages is probed, 0 disables the probes. The probes
+		// also sample the utilization of the CPUs and of the memory of the sizing recommendations.
+		DiskCheckMins int64 `envconfig:"DRONE_SETTINGS_DISK_CHECK_MINS" default:"5"`
+		// DiskWarnPercent is the disk usage above which the stage logs get a warning.
+		DiskWarnPercent int `envconfig:"DRONE_SETTINGS_DISK_WARN_PERCENT" default:"90"`
+		// DrainTimeoutMins is how long the deletion of a draining pool waits for its busy instances before destroying them.
+		DrainTimeoutMins int64 `envconfig:"DRONE_SETTINGS_DRAIN_TIMEOUT_MINS" default:"120"`
+		// ClaimTTLMins is how long an instance claimed by a setup may wait for the setup to complete, e.g. when the runner
+		// crashed during the setup, before it is returned to the pool or destroyed. 0 disables the release of the claims.
+		ClaimTTLMins int64 `envconfig:"DRONE_SETTINGS_CLAIM_TTL_MINS" default:"30"`
+		// PoolMappingReloadSecs is how often the account pool mappings changed at runtime are reloaded from the database, so
+		// the changes made on another replica apply. 0 only loads them on startup.
+		PoolMappingReloadSecs int64 `envconfig:"DRONE_SETTINGS_POOL_MAPPING_RELOAD_SECS" default:"60"`
+		// ReadinessWarmPools makes the readiness probe fail until every pool reached its min size, so the traffic is not
+		// routed to a fresh runner whose pools are still booting.
+		ReadinessWarmPools bool `envconfig:"DRONE_SETTINGS_READINESS_WARM_POOLS" default:"false"`
 	}
 
 	LiteEngine struct {
@@ -332,6 +492,18 @@ type EnvConfig struct {
 		Proto string `envconfig:"DRONE_HTTP_PROTO"`
 		Host  string `envconfig:"DRONE_HTTP_HOST"`
 		Acme  bool   `envconfig:"DRONE_HTTP_ACME"`
+		// TLSCert and TLSKey are the files of the certificate of the delegate API, it is served over
+		// plain HTTP without them.
+		TLSCert string `envconfig:"DRONE_HTTP_TLS_CERT"`
+		TLSKey  string `envconfig:"DRONE_HTTP_TLS_KEY"`
+	}
+
+	// Admin is the listener of the liveness, readiness and metrics endpoints of the delegate, apart
+	// from the delegate API. The endpoints are served by the delegate API listener if it is unset.
+	Admin struct {
+		Port    string `envconfig:"DRONE_ADMIN_HTTP_BIND"`
+		TLSCert string `envconfig:"DRONE_ADMIN_HTTP_TLS_CERT"`
+		TLSKey  string `envconfig:"DRONE_ADMIN_HTTP_TLS_KEY"`
 	}
 
 	Environ struct {
@@ -359,7 +531,155 @@ type EnvConfig struct {
 
 	Database struct {
 		Driver     string `envconfig:"DRONE_DATABASE_DRIVER" default:"sqlite3"`
-		Datasource string `envconfig:"DRONE_DATABASE_DATASOURCE" default:"database.sqlite3"`
+		Datasource string `envconfig:"DRONE_DATABASE_DATASOURCE" default:"database.sqlite3"` // the snapshot file of the memory driver
+		// SnapshotIntervalMs is how often the changes of the memory driver are written to its snapshot file.
+		SnapshotIntervalMs int `envconfig:"DRONE_DATABASE_SNAPSHOT_INTERVAL_MS" default:"1000"`
+		// ReplicaDatasource is a read replica of the postgres database the heavy list queries go to, e.g. the
+		// analytics of the stage records.
+		ReplicaDatasource string `envconfig:"DRONE_DATABASE_REPLICA_DATASOURCE"`
+		// StatementTimeoutMs makes postgres abort the statements running longer, none if zero.
+		StatementTimeoutMs int `envconfig:"DRONE_DATABASE_STATEMENT_TIMEOUT_MS"`
+		// SerializationRetries is how many times the writes aborted by a serialization failure or a deadlock
+		// of postgres are retried.
+		SerializationRetries int `envconfig:"DRONE_DATABASE_SERIALIZATION_RETRIES" default:"3"`
+	}
+
+	// Kubernetes enables pools defined as VMPool resources.
+	Kubernetes struct {
+		Pools     bool   `envconfig:"DRONE_KUBERNETES_POOLS"`
+		Namespace string `envconfig:"DRONE_KUBERNETES_NAMESPACE"` // the namespace of the runner by default
+	}
+
+	// HA configures running multiple replicas against a shared postgres database.
+	HA struct {
+		Mode              string `envconfig:"DRONE_HA_MODE"` // empty (single replica), election or follower
+		LockID            int64  `envconfig:"DRONE_HA_LOCK_ID" default:"7331"`
+		ElectionIntervalS int64  `envconfig:"DRONE_HA_ELECTION_INTERVAL_SECS" default:"10"`
+	}
+
+	// LogSink is where the setup logs go when the request has no Harness log config.
+	LogSink struct {
+		Type   string            `envconfig:"DRONE_LOG_SINK"`        // stdout (default), stdout-json, file, s3, gcs or loki
+		Path   string            `envconfig:"DRONE_LOG_SINK_PATH"`   // the directory of the file sink
+		Bucket string            `envconfig:"DRONE_LOG_SINK_BUCKET"` // the bucket of the s3 and gcs sinks
+		Prefix string            `envconfig:"DRONE_LOG_SINK_PREFIX"`
+		Region string            `envconfig:"DRONE_LOG_SINK_REGION"`
+		URL    string            `envconfig:"DRONE_LOG_SINK_URL"` // the push endpoint of the loki sink
+		Labels map[string]string `envconfig:"DRONE_LOG_SINK_LABELS"`
+		// the remote sinks, the Harness log service included, send the lines in batches from the background.
+		FlushIntervalMs int  `envconfig:"DRONE_LOG_SINK_FLUSH_INTERVAL_MS" default:"1000"`
+		BatchSize       int  `envconfig:"DRONE_LOG_SINK_BATCH_SIZE" default:"500"`
+		MaxInFlight     int  `envconfig:"DRONE_LOG_SINK_MAX_IN_FLIGHT" default:"2"`
+		MaxBuffered     int  `envconfig:"DRONE_LOG_SINK_MAX_BUFFERED" default:"10000"` // the oldest lines are dropped beyond
+		Gzip            bool `envconfig:"DRONE_LOG_SINK_GZIP" default:"true"`
+	}
+
+	// Cache stores the caches of the stages, restored on their instances at the setup and saved
+	// from them at the destroy.
+	Cache struct {
+		Type              string `envconfig:"DRONE_CACHE"`        // s3, gcs or azure, disabled if empty
+		Bucket            string `envconfig:"DRONE_CACHE_BUCKET"` // the container with azure
+		Prefix            string `envconfig:"DRONE_CACHE_PREFIX"`
+		Region            string `envconfig:"DRONE_CACHE_REGION"`
+		GCSServiceAccount string `envconfig:"DRONE_CACHE_GCS_SERVICE_ACCOUNT"` // signs the urls of the gcs entries
+		AzureAccount      string `envconfig:"DRONE_CACHE_AZURE_ACCOUNT"`
+		AzureKey          string `envconfig:"DRONE_CACHE_AZURE_KEY"`
+		MaxSizeMB         int64  `envconfig:"DRONE_CACHE_MAX_SIZE_MB"` // the oldest entries are evicted beyond, no limit if zero
+		URLTTLSecs        int64  `envconfig:"DRONE_CACHE_URL_TTL_SECS" default:"900"`
+	}
+
+	// Artifacts lets the steps upload their artifacts to a bucket through the urls signed by the
+	// runner, requested from the endpoint of the runner with the token of their stage.
+	Artifacts struct {
+		Type              string `envconfig:"DRONE_ARTIFACTS"`        // s3, gcs or azure, disabled if empty
+		Bucket            string `envconfig:"DRONE_ARTIFACTS_BUCKET"` // the container with azure
+		Prefix            string `envconfig:"DRONE_ARTIFACTS_PREFIX"`
+		Region            string `envconfig:"DRONE_ARTIFACTS_REGION"`
+		GCSServiceAccount string `envconfig:"DRONE_ARTIFACTS_GCS_SERVICE_ACCOUNT"` // signs the urls of the gcs artifacts
+		AzureAccount      string `envconfig:"DRONE_ARTIFACTS_AZURE_ACCOUNT"`
+		AzureKey          string `envconfig:"DRONE_ARTIFACTS_AZURE_KEY"`
+		Endpoint          string `envconfig:"DRONE_ARTIFACTS_ENDPOINT"` // the url of the runner the instances reach
+		Secret            string `envconfig:"DRONE_ARTIFACTS_SECRET"`   // signs the tokens of the stages
+		URLTTLSecs        int64  `envconfig:"DRONE_ARTIFACTS_URL_TTL_SECS" default:"900"`
+		TokenTTLMins      int64  `envconfig:"DRONE_ARTIFACTS_TOKEN_TTL_MINS" default:"720"`
+	}
+
+	// OIDC issues the identity tokens of the stages, exchanged for the credentials of the pools.
+	OIDC struct {
+		Issuer         string `envconfig:"DRONE_OIDC_ISSUER"`           // the public url of the runner the clouds fetch the keys from
+		PrivateKeyPath string `envconfig:"DRONE_OIDC_PRIVATE_KEY_PATH"` // the RSA key signing the tokens, random if empty
+	}
+
+	// StepSecrets resolves the references to the secrets of the stores in the environment of the
+	// steps, e.g. ${vault://secret/data/ci#token}, before the steps are sent to the lite-engine.
+	StepSecrets struct {
+		Enabled      bool     `envconfig:"DRONE_STEP_SECRETS"`
+		Providers    []string `envconfig:"DRONE_STEP_SECRETS_PROVIDERS" default:"vault,awssm,gcpsm"` // file and sops read the files of the runner
+		CacheTTLSecs int64    `envconfig:"DRONE_STEP_SECRETS_CACHE_TTL_SECS" default:"300"`          // not cached if zero
+	}
+
+	// Events publishes the lifecycle events of the instances and of the stages to a message bus.
+	Events struct {
+		Type     string `envconfig:"DRONE_EVENTS"`          // kafka, nats, sqs or pubsub, disabled if empty
+		Endpoint string `envconfig:"DRONE_EVENTS_ENDPOINT"` // the brokers of kafka, comma separated, or the url of nats
+		// Topic is the topic of kafka or of pubsub (projects/<project>/topics/<topic>), the subject of
+		// nats or the url of the sqs queue. {type} is replaced with the type of the event.
+		Topic  string `envconfig:"DRONE_EVENTS_TOPIC"`
+		Format string `envconfig:"DRONE_EVENTS_FORMAT" default:"json"` // json or cloudevents
+		Region string `envconfig:"DRONE_EVENTS_REGION"`                // the region of the sqs queue
+		Buffer int    `envconfig:"DRONE_EVENTS_BUFFER" default:"1000"` // the events beyond are dropped
+	}
+
+	// Tunnel is the ssh server the instances of the pools with the lite-engine tunnel dial.
+	Tunnel struct {
+		Bind        string `envconfig:"DRONE_TUNNEL_BIND"`    // disabled if empty
+		Address     string `envconfig:"DRONE_TUNNEL_ADDRESS"` // host:port the instances dial
+		HostKeyPath string `envconfig:"DRONE_TUNNEL_HOST_KEY_PATH"`
+	}
+
+	// KeyServer serves the TLS keys of the instances of the pools with the url key delivery, every key is
+	// fetched once by its instance while it boots.
+	KeyServer struct {
+		Bind     string `envconfig:"DRONE_KEY_SERVER_BIND"` // disabled if empty
+		URL      string `envconfig:"DRONE_KEY_SERVER_URL"`  // the base URL the instances fetch the keys from
+		CertFile string `envconfig:"DRONE_KEY_SERVER_CERT_FILE"`
+		KeyFile  string `envconfig:"DRONE_KEY_SERVER_KEY_FILE"`
+	}
+
+	// Alerts notify the operators of the errors needing them, e.g. a pool failing to create
+	// instances. The identical alerts raised within a window are sent once with their count.
+	Alerts struct {
+		WebhookURL          string `envconfig:"DRONE_ALERT_WEBHOOK_URL"`           // the notifications are posted as JSON
+		PagerDutyRoutingKey string `envconfig:"DRONE_ALERT_PAGERDUTY_ROUTING_KEY"` // the integration key of a PagerDuty service
+		WindowSecs          int64  `envconfig:"DRONE_ALERT_WINDOW_SECS" default:"300"`
+		MaxPerWindow        int    `envconfig:"DRONE_ALERT_MAX_PER_WINDOW" default:"20"`
+	}
+
+	// Crypto restricts the TLS connections of the runner, the clients of the lite-engine, of Nomad
+	// and of the cloud SDKs and the listeners of the runner.
+	Crypto struct {
+		// FIPS requires the runner to be built with the FIPS validated crypto, GOEXPERIMENT=boringcrypto.
+		FIPS          bool   `envconfig:"DRONE_CRYPTO_FIPS"`
+		TLSMinVersion string `envconfig:"DRONE_CRYPTO_TLS_MIN_VERSION" default:"1.2"` // 1.2 or 1.3
+		// TLSCipherSuites are the TLS 1.2 cipher suites allowed, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		// the defaults of Go if empty.
+		TLSCipherSuites []string `envconfig:"DRONE_CRYPTO_TLS_CIPHER_SUITES"`
+	}
+
+	// DNS is the zone the instances are registered in as <stage>.<pool>.<zone> while they run a stage.
+	DNS struct {
+		Provider string `envconfig:"DRONE_DNS_PROVIDER"` // route53, clouddns or rfc2136, disabled if empty
+		Zone     string `envconfig:"DRONE_DNS_ZONE"`     // e.g. ci.example.com
+		TTL      int    `envconfig:"DRONE_DNS_TTL" default:"60"`
+
+		Route53ZoneID     string `envconfig:"DRONE_DNS_ROUTE53_ZONE_ID"`
+		GoogleProject     string `envconfig:"DRONE_DNS_GOOGLE_PROJECT"`
+		GoogleManagedZone string `envconfig:"DRONE_DNS_GOOGLE_MANAGED_ZONE"`
+		GoogleJSONPath    string `envconfig:"DRONE_DNS_GOOGLE_JSON_PATH"`
+		Server            string `envconfig:"DRONE_DNS_SERVER"` // host:port of the rfc2136 server
+		TSIGKey           string `envconfig:"DRONE_DNS_TSIG_KEY"`
+		TSIGSecret        string `envconfig:"DRONE_DNS_TSIG_SECRET"`
+		TSIGAlgorithm     string `envconfig:"DRONE_DNS_TSIG_ALGORITHM"`
 	}
 
 	Tmate struct {
@@ -388,7 +708,7 @@ func FromEnviron() (EnvConfig, error) {
 	}
 
 	var config EnvConfig
-	err := envconfig.Process("", &config)
+	err := processEnviron(&config)
 	if err != nil {
 		return config, err
 	}
@@ -420,9 +740,26 @@ func FromEnviron() (EnvConfig, error) {
 		}
 	}
 
+	for _, key := range UnknownEnviron(os.Environ()) {
+		logrus.WithField("variable", key).Warnln("config: unknown setting, the variable is ignored")
+	}
+	if err := config.Validate(); err != nil {
+		return config, err
+	}
+	configureCrypto(&config)
 	return config, nil
 }
 
+// configureCrypto applies the TLS policy of the validated settings before any client is created.
+func configureCrypto(c *EnvConfig) {
+	minVersion, _ := fips.ParseVersion(c.Crypto.TLSMinVersion)
+	suites, _ := fips.ParseCipherSuites(c.Crypto.TLSCipherSuites)
+	fips.Configure(fips.Policy{MinVersion: minVersion, CipherSuites: suites})
+	if fips.Enabled() {
+		logrus.Infoln("config: FIPS mode, the TLS connections use the BoringCrypto module")
+	}
+}
+
 // UnmarshalJSON implement the json.Unmarshaler interface.
 func (s *Instance) UnmarshalJSON(data []byte) error {
 	type S Instance
@@ -434,27 +771,38 @@ func (s *Instance) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, obj); err != nil {
 		return err
 	}
-	switch s.Type {
+	spec, err := newSpec(s.Type)
+	if err != nil {
+		return err
+	}
+	s.Spec = spec
+	return json.Unmarshal(obj.Spec, s.Spec)
+}
+
+// newSpec returns the driver specific settings of the pool type.
+func newSpec(typ string) (interface{}, error) {
+	switch typ {
 	case string(types.Amazon), "aws":
-		s.Spec = new(Amazon)
+		return new(Amazon), nil
 	case string(types.Anka):
-		s.Spec = new(Anka)
+		return new(Anka), nil
 	case string(types.AnkaBuild):
-		s.Spec = new(AnkaBuild)
+		return new(AnkaBuild), nil
 	case string(types.Azure):
-		s.Spec = new(Azure)
+		return new(Azure), nil
 	case string(types.DigitalOcean):
-		s.Spec = new(DigitalOcean)
+		return new(DigitalOcean), nil
 	case string(types.Google), "gcp":
-		s.Spec = new(Google)
+		return new(Google), nil
 	case string(types.VMFusion):
-		s.Spec = new(VMFusion)
+		return new(VMFusion), nil
 	case string(types.Noop):
-		s.Spec = new(Noop)
+		return new(Noop), nil
 	case string(types.Nomad):
-		s.Spec = new(Nomad)
+		return new(Nomad), nil
+	case string(types.Static):
+		return new(Static), nil
 	default:
-		return fmt.Errorf("unknown instance type %s", s.Type)
+		return nil, fmt.Errorf("unknown instance type %s", typ)
 	}
-	return json.Unmarshal(obj.Spec, s.Spec)
 }
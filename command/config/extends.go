@@ -0,0 +1,156 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// keys of the pool file resolving the inheritance of the pools.
+const (
+	templatesKey = "templates" // the base pools, which are not created
+	extendsKey   = "extends"   // the template or the pool a pool extends
+)
+
+// notInherited are the settings of a base which are not copied to the pools extending it.
+var notInherited = []string{"name", "default", extendsKey}
+
+// resolveExtends returns the pool file with the settings of the bases merged into the pools
+// extending them. The templates and the top level keys prefixed with x-, which only hold the
+// yaml anchors, are removed. It also returns the types of the templates and of the pools by path,
+// which might be inherited.
+func resolveExtends(doc map[string]interface{}) (out map[string]interface{}, specTypes map[string]string, err error) {
+	v := validator{errs: &ValidationError{Source: "pool file"}}
+	templates, _ := doc[templatesKey].([]interface{})
+	instances, _ := doc["instances"].([]interface{})
+
+	// the bases by name, the names of the templates must be unique among the pools
+	type base struct {
+		path string
+		obj  map[string]interface{}
+	}
+	bases := map[string]base{}
+	add := func(key string, items []interface{}) {
+		for i, item := range items {
+			obj, _ := item.(map[string]interface{})
+			name, _ := obj["name"].(string)
+			path := fmt.Sprintf("%s[%d]", key, i)
+			if name == "" {
+				if key == templatesKey {
+					v.fail(path+".name", "must be set")
+				}
+				continue
+			}
+			if prev, ok := bases[name]; ok {
+				if key == templatesKey || strings.HasPrefix(prev.path, templatesKey) {
+					v.fail(path+".name", "duplicate name %q, already used by %s", name, prev.path)
+				}
+				continue // the duplicate pools are reported by the validation
+			}
+			bases[name] = base{path: path, obj: obj}
+		}
+	}
+	add(templatesKey, templates)
+	add("instances", instances)
+
+	// resolved holds the pools and the bases already resolved by path, so the errors of a base are
+	// reported once.
+	resolved := map[string]map[string]interface{}{}
+	var resolve func(path string, obj map[string]interface{}, chain []string) map[string]interface{}
+	resolve = func(path string, obj map[string]interface{}, chain []string) map[string]interface{} {
+		if r, ok := resolved[path]; ok {
+			return r
+		}
+		r := obj
+		if name, _ := obj[extendsKey].(string); name != "" {
+			b, ok := bases[name]
+			switch {
+			case contains(chain, name):
+				v.fail(path+"."+extendsKey, "circular inheritance %s", strings.Join(append(chain, name), " -> "))
+			case !ok:
+				v.fail(path+"."+extendsKey, "unknown template or pool %q", name)
+			default:
+				r = inherit(resolve(b.path, b.obj, append(chain, name)), obj)
+			}
+		}
+		resolved[path] = r
+		return r
+	}
+
+	out = map[string]interface{}{}
+	for k, val := range doc {
+		if k != templatesKey && !strings.HasPrefix(k, "x-") {
+			out[k] = val
+		}
+	}
+	if instances != nil {
+		merged := make([]interface{}, len(instances))
+		for i, item := range instances {
+			obj, ok := item.(map[string]interface{})
+			if !ok {
+				merged[i] = item // the type mismatches are reported by the decoder
+				continue
+			}
+			name, _ := obj["name"].(string)
+			merged[i] = resolve(fmt.Sprintf("instances[%d]", i), obj, []string{name})
+		}
+		out["instances"] = merged
+	}
+	for i, item := range templates {
+		if obj, ok := item.(map[string]interface{}); ok {
+			name, _ := obj["name"].(string)
+			resolve(fmt.Sprintf("%s[%d]", templatesKey, i), obj, []string{name})
+		}
+	}
+
+	specTypes = map[string]string{}
+	for path, obj := range resolved {
+		specTypes[path], _ = obj["type"].(string)
+	}
+	return out, specTypes, v.err()
+}
+
+// inherit returns the settings of the pool merged over the ones of its base.
+func inherit(base, pool map[string]interface{}) map[string]interface{} {
+	out := merge(base, pool)
+	for _, k := range notInherited {
+		if val, ok := pool[k]; ok {
+			out[k] = val
+		} else {
+			delete(out, k)
+		}
+	}
+	return out
+}
+
+// merge returns the values merged over the base ones, the maps, e.g. the tags, are merged key by
+// key and the other values replace the base ones. A null value clears the key of the base, e.g. the
+// ami of the base of a pool using an ami_filter.
+func merge(base, values map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(base)+len(values))
+	for k, val := range base {
+		out[k] = val
+	}
+	for k, val := range values {
+		if val == nil {
+			delete(out, k)
+			continue
+		}
+		b, baseIsMap := out[k].(map[string]interface{})
+		m, isMap := val.(map[string]interface{})
+		if baseIsMap && isMap {
+			out[k] = merge(b, m)
+		} else {
+			out[k] = val
+		}
+	}
+	return out
+}
+
+func contains(values []string, value string) bool {
+	for _, s := range values {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
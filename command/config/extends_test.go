@@ -0,0 +1,178 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// awsBase is the head of the pool files of the tests, with an amazon template.
+const awsBase = `
+version: "1"
+templates:
+  - name: aws-base
+    type: amazon
+    limit: 20
+    platform:
+      os: linux
+      arch: amd64
+    spec:
+      account:
+        region: us-east-2
+      ami: ami-051197ce9cbb023ea
+      size: t3.large
+      tags:
+        team: ci
+        env: prod
+`
+
+func TestParse_Extends(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		check func(t *testing.T, f *PoolFile)
+		field string // the field of the error, empty if the file is valid
+		msg   string
+	}{
+		{
+			name: "maps merged",
+			file: awsBase + `
+instances:
+  - name: ubuntu
+    extends: aws-base
+    default: true
+    spec:
+      size: m5.2xlarge
+      tags:
+        env: dev
+  - name: ubuntu-big
+    extends: ubuntu
+    limit: 5
+`,
+			check: func(t *testing.T, f *PoolFile) {
+				if len(f.Instances) != 2 {
+					t.Fatalf("expected the 2 pools, got %d", len(f.Instances))
+				}
+				ubuntu, big := f.Instances[0], f.Instances[1]
+				spec := ubuntu.Spec.(*Amazon)
+				if ubuntu.Limit != 20 || spec.Account.Region != "us-east-2" || spec.Size != "m5.2xlarge" {
+					t.Errorf("expected the settings of the template with the size of the pool, got %+v", ubuntu)
+				}
+				if spec.Tags["team"] != "ci" || spec.Tags["env"] != "dev" {
+					t.Errorf("expected the tags merged key by key, got %v", spec.Tags)
+				}
+				if big.Name != "ubuntu-big" || big.Default || big.Extends != "ubuntu" || big.Limit != 5 {
+					t.Errorf("expected the name, default and extends not to be inherited, got %+v", big)
+				}
+				if tags := big.Spec.(*Amazon).Tags; tags["env"] != "dev" {
+					t.Errorf("expected the tags of the pool extended, got %v", tags)
+				}
+			},
+		},
+		{
+			name: "key cleared",
+			file: awsBase + `
+instances:
+  - name: ubuntu
+    extends: aws-base
+    spec:
+      ami: null
+      ami_filter:
+        name: ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-amd64-server-*
+      tags:
+        env: null
+`,
+			check: func(t *testing.T, f *PoolFile) {
+				spec := f.Instances[0].Spec.(*Amazon)
+				if spec.AMI != "" || spec.AMIFilter.Name == "" {
+					t.Errorf("expected the ami of the template replaced by the filter, got %+v", spec)
+				}
+				if _, ok := spec.Tags["env"]; ok || spec.Tags["team"] != "ci" {
+					t.Errorf("expected the env tag cleared, got %v", spec.Tags)
+				}
+			},
+		},
+		{
+			name: "key not cleared",
+			file: awsBase + `
+instances:
+  - name: ubuntu
+    extends: aws-base
+    spec:
+      ami_filter:
+        name: ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-amd64-server-*
+`,
+			field: "instances[0].spec.ami_filter",
+			msg:   "must not be set with ami",
+		},
+		{
+			name: "x- keys stripped",
+			file: `
+version: "1"
+x-spec: &spec
+  account:
+    region: us-east-2
+  ami: ami-051197ce9cbb023ea
+instances:
+  - name: ubuntu
+    type: amazon
+    platform:
+      os: linux
+      arch: amd64
+    spec:
+      <<: *spec
+      size: t3.large
+`,
+			check: func(t *testing.T, f *PoolFile) {
+				spec := f.Instances[0].Spec.(*Amazon)
+				if spec.AMI != "ami-051197ce9cbb023ea" || spec.Size != "t3.large" {
+					t.Errorf("expected the anchored settings, got %+v", spec)
+				}
+			},
+		},
+		{
+			name: "circular",
+			file: awsBase + `
+instances:
+  - name: a
+    extends: b
+  - name: b
+    extends: a
+`,
+			field: "instances[1].extends", // the pool closing the cycle
+			msg:   "circular inheritance a -> b -> a",
+		},
+		{
+			name: "unknown base",
+			file: awsBase + `
+instances:
+  - name: ubuntu
+    extends: aws
+`,
+			field: "instances[0].extends",
+			msg:   `unknown template or pool "aws"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := Parse(strings.NewReader(test.file))
+			if test.field == "" {
+				if err != nil {
+					t.Fatalf("unexpected error %s", err)
+				}
+				test.check(t, f)
+				return
+			}
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("expected a validation error, got %v", err)
+			}
+			for _, fe := range verr.Errors {
+				if fe.Field == test.field && strings.Contains(fe.Message, test.msg) {
+					return
+				}
+			}
+			t.Errorf("expected %s: %s, got %s", test.field, test.msg, err)
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-aws/internal/secrets"
+)
+
+// notInterpolated are the fields of the scripts, their shell variables are left as they are. The fields
+// are matched against the end of the path of the values, with the list indexes left out.
+var notInterpolated = []string{
+	"user_data",
+	"canary_script",
+	"finalize_script",
+	"services[].command",
+	"services[].envs",
+	"destroy_hooks[].script",
+}
+
+var listIndex = regexp.MustCompile(`\[\d+\]`)
+
+// isScript returns true if the value at the path is a script.
+func isScript(path string) bool {
+	path = strings.ToLower(listIndex.ReplaceAllString(path, "[]"))
+	for _, field := range notInterpolated {
+		if path == field || strings.HasSuffix(path, "."+field) {
+			return true
+		}
+	}
+	return false
+}
+
+// interpolate returns the decoded pool file with the references to the environment variables and
+// to the secrets of its strings expanded, see secrets.Expander.
+func interpolate(ctx context.Context, e *secrets.Expander, v validator, value interface{}) interface{} {
+	switch val := value.(type) {
+	case string:
+		s, err := e.Expand(ctx, val)
+		if err != nil {
+			v.errs.Errors = append(v.errs.Errors, FieldError{Field: v.path, Message: err.Error()})
+			return val
+		}
+		return s
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = interpolate(ctx, e, v.index(i), item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			if isScript(v.at(k).path) {
+				out[k] = item
+				continue
+			}
+			out[k] = interpolate(ctx, e, v.at(k), item)
+		}
+		return out
+	default:
+		return value
+	}
+}
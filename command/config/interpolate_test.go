@@ -0,0 +1,75 @@
+package config
+
+import (
+	"context"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/internal/secrets"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("DRONE_TEST_REGION", "us-east-2")
+
+	raw := map[string]interface{}{
+		"instances": []interface{}{
+			map[string]interface{}{
+				"name":            "pool",
+				"canary_script":   "echo ${HOME}",
+				"finalize_script": "echo ${DRONE_UNSET_VARIABLE}",
+				"destroy_hooks": []interface{}{
+					map[string]interface{}{
+						"name":   "usage",
+						"script": "docker system df > $DRONE_UNSET_VARIABLE/${HOSTNAME}-{{ .Stage }}.txt",
+					},
+				},
+				"services": []interface{}{
+					map[string]interface{}{
+						"image":   "${DRONE_TEST_REGION}",
+						"command": []interface{}{"sh", "-c", "echo ${REDIS_PASSWORD}"},
+						"envs":    map[string]interface{}{"URL": "redis://${HOST}"},
+					},
+				},
+				"spec": map[string]interface{}{
+					"region":    "${DRONE_TEST_REGION}",
+					"user_data": "#!/bin/sh\necho ${DRONE_UNSET_VARIABLE} $USER",
+				},
+			},
+		},
+	}
+	want := map[string]interface{}{
+		"instances": []interface{}{
+			map[string]interface{}{
+				"name":            "pool",
+				"canary_script":   "echo ${HOME}",
+				"finalize_script": "echo ${DRONE_UNSET_VARIABLE}",
+				"destroy_hooks": []interface{}{
+					map[string]interface{}{
+						"name":   "usage",
+						"script": "docker system df > $DRONE_UNSET_VARIABLE/${HOSTNAME}-{{ .Stage }}.txt",
+					},
+				},
+				"services": []interface{}{
+					map[string]interface{}{
+						"image":   "us-east-2",
+						"command": []interface{}{"sh", "-c", "echo ${REDIS_PASSWORD}"},
+						"envs":    map[string]interface{}{"URL": "redis://${HOST}"},
+					},
+				},
+				"spec": map[string]interface{}{
+					"region":    "us-east-2",
+					"user_data": "#!/bin/sh\necho ${DRONE_UNSET_VARIABLE} $USER",
+				},
+			},
+		},
+	}
+
+	v := validator{errs: &ValidationError{Source: "pool file"}}
+	got := interpolate(context.Background(), secrets.New(), v, raw)
+	if err := v.err(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("the scripts must be left as they are, diff %s", diff)
+	}
+}
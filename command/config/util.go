@@ -1,10 +1,14 @@
 package config
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"os"
+	"reflect"
+	"strings"
 
+	"github.com/drone-runners/drone-runner-aws/internal/secrets"
 	"github.com/ghodss/yaml"
 )
 
@@ -32,7 +36,39 @@ func Parse(r io.Reader) (*PoolFile, error) {
 	if err != nil {
 		return nil, err
 	}
+	var raw map[string]interface{}
+	if err = json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	iv := validator{errs: &ValidationError{Source: "pool file"}}
+	raw, _ = interpolate(context.Background(), secrets.New(), iv, raw).(map[string]interface{})
+	if err = iv.err(); err != nil {
+		return nil, err
+	}
+	doc, specTypes, err := resolveExtends(raw)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = json.Marshal(doc); err != nil {
+		return nil, err
+	}
 	out := new(PoolFile)
-	err = json.Unmarshal(b, out)
-	return out, err
+	if err = json.Unmarshal(b, out); err != nil {
+		return out, err
+	}
+
+	// the unknown keys are reported where they are set, in the pools or in the templates
+	v := validator{errs: &ValidationError{Source: "pool file"}}
+	for k := range raw {
+		if strings.HasPrefix(k, "x-") {
+			delete(raw, k)
+		}
+	}
+	keys := keyChecker{specTypes: specTypes}
+	keys.unknown(v, raw, reflect.TypeOf(struct {
+		PoolFile
+		Templates []Instance `json:"templates"`
+	}{}))
+	out.validate(v)
+	return out, v.err()
 }
@@ -0,0 +1,1207 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/cloudinit"
+	"github.com/drone-runners/drone-runner-aws/internal/dns"
+	"github.com/drone-runners/drone-runner-aws/internal/events"
+	"github.com/drone-runners/drone-runner-aws/internal/fips"
+	"github.com/drone-runners/drone-runner-aws/internal/leader"
+	"github.com/drone-runners/drone-runner-aws/internal/leasetoken"
+	"github.com/drone-runners/drone-runner-aws/internal/lehelper"
+	"github.com/drone-runners/drone-runner-aws/internal/logformat"
+	"github.com/drone-runners/drone-runner-aws/internal/logsink"
+	"github.com/drone-runners/drone-runner-aws/internal/objectstore"
+	"github.com/drone-runners/drone-runner-aws/internal/oshelp"
+	"github.com/drone-runners/drone-runner-aws/internal/secrets"
+	"github.com/drone-runners/drone-runner-aws/internal/tunnel"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/hashicorp/cronexpr"
+	"github.com/kelseyhightower/envconfig"
+)
+
+// minClaimTTLMins is the minimum time to live of the claims, they must outlive the setups in
+// progress, which wait up to 10 minutes for the lite-engine.
+const minClaimTTLMins = 15
+
+// FieldError is an invalid setting, the field is the environment variable or the path of the key
+// in the pool file.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// ValidationError lists all the invalid settings of a configuration.
+type ValidationError struct {
+	Source string // the environment or the pool file
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid %s, %d error(s):", e.Source, len(e.Errors))
+	for _, fe := range e.Errors {
+		fmt.Fprintf(&b, "\n  %s: %s", fe.Field, fe.Message)
+	}
+	return b.String()
+}
+
+// validator collects the errors of the fields under a path.
+type validator struct {
+	path string
+	errs *ValidationError
+}
+
+func (v validator) at(key string) validator {
+	if key == "" {
+		return v
+	}
+	if v.path == "" {
+		return validator{path: key, errs: v.errs}
+	}
+	return validator{path: v.path + "." + key, errs: v.errs}
+}
+
+func (v validator) index(i int) validator {
+	return validator{path: fmt.Sprintf("%s[%d]", v.path, i), errs: v.errs}
+}
+
+func (v validator) fail(key, format string, args ...interface{}) {
+	v.errs.Errors = append(v.errs.Errors, FieldError{Field: v.at(key).path, Message: fmt.Sprintf(format, args...)})
+}
+
+// file checks the file of the key exists, if it is set.
+func (v validator) file(key, path string) {
+	if path == "" {
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		v.fail(key, "cannot read %s: %s", path, errors.Unwrap(err))
+	}
+}
+
+// pair checks the keys are both set or both unset, e.g. a certificate and its key.
+func (v validator) pair(key1, value1, key2, value2 string) {
+	if value1 != "" && value2 == "" {
+		v.fail(key2, "must be set with %s", key1)
+	} else if value1 == "" && value2 != "" {
+		v.fail(key1, "must be set with %s", key2)
+	}
+}
+
+func (v validator) nonNegative(key string, value int64) {
+	if value < 0 {
+		v.fail(key, "must not be negative, got %d", value)
+	}
+}
+
+// timeout checks the timeout of an operation, zero if not set.
+func (v validator) timeout(key string, secs int64) {
+	if secs < 0 || secs > maxTimeoutSecs {
+		v.fail(key, "must be between 0 and %d seconds, got %d", maxTimeoutSecs, secs)
+	}
+}
+
+func (v validator) oneOf(key, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.fail(key, "unknown value %q, expected one of %s", value, strings.Join(quoted(allowed), ", "))
+}
+
+func (v validator) err() error {
+	if len(v.errs.Errors) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+func quoted(values []string) []string {
+	out := make([]string, 0, len(values))
+	for _, s := range values {
+		if s == "" {
+			s = "empty"
+		} else {
+			s = fmt.Sprintf("%q", s)
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// Validate checks the settings of the runner and returns all the invalid ones at once.
+func (c *EnvConfig) Validate() error {
+	v := validator{errs: &ValidationError{Source: "environment"}}
+
+	v.oneOf("DRONE_LOG_FORMAT", c.LogFormat, "", logformat.FormatText, logformat.FormatJSON)
+	v.oneOf("DRONE_DATABASE_DRIVER", c.Database.Driver, "sqlite3", "postgres", "leveldb", "memory")
+	v.nonNegative("DRONE_DATABASE_SNAPSHOT_INTERVAL_MS", int64(c.Database.SnapshotIntervalMs))
+	v.nonNegative("DRONE_DATABASE_STATEMENT_TIMEOUT_MS", int64(c.Database.StatementTimeoutMs))
+	v.nonNegative("DRONE_DATABASE_SERIALIZATION_RETRIES", int64(c.Database.SerializationRetries))
+	if c.Database.Driver != "postgres" {
+		if c.Database.ReplicaDatasource != "" {
+			v.fail("DRONE_DATABASE_REPLICA_DATASOURCE", "a read replica requires DRONE_DATABASE_DRIVER=postgres, got %q", c.Database.Driver)
+		}
+		if c.Database.StatementTimeoutMs > 0 {
+			v.fail("DRONE_DATABASE_STATEMENT_TIMEOUT_MS", "requires DRONE_DATABASE_DRIVER=postgres, got %q", c.Database.Driver)
+		}
+	}
+	v.oneOf("DRONE_HA_MODE", c.HA.Mode, "", leader.ModeElection, leader.ModeFollower)
+	if c.HA.Mode == leader.ModeElection {
+		if c.Database.Driver != "postgres" {
+			v.fail("DRONE_HA_MODE", "leader election requires DRONE_DATABASE_DRIVER=postgres, got %q", c.Database.Driver)
+		}
+		if c.HA.ElectionIntervalS <= 0 {
+			v.fail("DRONE_HA_ELECTION_INTERVAL_SECS", "must be positive, got %d", c.HA.ElectionIntervalS)
+		}
+	}
+
+	// listeners
+	v.pair("DRONE_HTTP_TLS_CERT", c.Server.TLSCert, "DRONE_HTTP_TLS_KEY", c.Server.TLSKey)
+	v.file("DRONE_HTTP_TLS_CERT", c.Server.TLSCert)
+	v.file("DRONE_HTTP_TLS_KEY", c.Server.TLSKey)
+	if c.Server.Acme && c.Server.TLSCert != "" {
+		v.fail("DRONE_HTTP_ACME", "must not be set with DRONE_HTTP_TLS_CERT")
+	}
+	v.pair("DRONE_ADMIN_HTTP_TLS_CERT", c.Admin.TLSCert, "DRONE_ADMIN_HTTP_TLS_KEY", c.Admin.TLSKey)
+	v.file("DRONE_ADMIN_HTTP_TLS_CERT", c.Admin.TLSCert)
+	v.file("DRONE_ADMIN_HTTP_TLS_KEY", c.Admin.TLSKey)
+	if c.Admin.Port != "" && c.Admin.Port == c.Server.Port {
+		v.fail("DRONE_ADMIN_HTTP_BIND", "must differ from DRONE_HTTP_BIND, unset it to serve the admin endpoints on the delegate API")
+	}
+	if c.Admin.Port == "" && c.Admin.TLSCert != "" {
+		v.fail("DRONE_ADMIN_HTTP_TLS_CERT", "requires DRONE_ADMIN_HTTP_BIND")
+	}
+
+	// instance lifetimes, in hours
+	if c.Settings.BusyMaxAge <= 0 {
+		v.fail("DRONE_SETTINGS_BUSY_MAX_AGE", "must be positive, got %d", c.Settings.BusyMaxAge)
+	}
+	if c.Settings.FreeMaxAge <= 0 {
+		v.fail("DRONE_SETTINGS_FREE_MAX_AGE", "must be positive, got %d", c.Settings.FreeMaxAge)
+	}
+	if c.Settings.LeaseMaxAge < c.Settings.BusyMaxAge {
+		v.fail("DRONE_SETTINGS_LEASE_MAX_AGE", "must not be lower than DRONE_SETTINGS_BUSY_MAX_AGE (%d), got %d", c.Settings.BusyMaxAge, c.Settings.LeaseMaxAge)
+	}
+	v.nonNegative("DRONE_SETTINGS_JANITOR_INTERVAL_MINS", c.Settings.JanitorIntervalMins)
+	v.nonNegative("DRONE_SETTINGS_IMAGE_PREFETCH_INTERVAL_MINS", c.Settings.ImagePrefetchIntervalMins)
+	v.nonNegative("DRONE_SETTINGS_MAINTENANCE_CHECK_SECS", c.Settings.MaintenanceCheckSecs)
+	v.nonNegative("DRONE_SETTINGS_ZOMBIE_PROBE_SECS", c.Settings.ZombieProbeSecs)
+	if c.Settings.ZombieProbeSecs > 0 && c.Settings.ZombieProbeFailures < 1 {
+		v.fail("DRONE_SETTINGS_ZOMBIE_PROBE_FAILURES", "must be at least 1 with the probes, got %d", c.Settings.ZombieProbeFailures)
+	}
+	v.nonNegative("DRONE_SETTINGS_LEASE_HEARTBEAT_MINS", c.Settings.LeaseHeartbeatMins)
+	v.nonNegative("DRONE_SETTINGS_DISK_CHECK_MINS", c.Settings.DiskCheckMins)
+	v.nonNegative("DRONE_SETTINGS_DRAIN_TIMEOUT_MINS", c.Settings.DrainTimeoutMins)
+	if ttl := c.Settings.ClaimTTLMins; ttl != 0 && ttl < minClaimTTLMins {
+		v.fail("DRONE_SETTINGS_CLAIM_TTL_MINS", "must be 0 or at least %d, got %d", minClaimTTLMins, ttl)
+	}
+	v.nonNegative("DRONE_SETTINGS_POOL_MAPPING_RELOAD_SECS", c.Settings.PoolMappingReloadSecs)
+	v.nonNegative("DRONE_SETTINGS_DESTROY_RETRY_ALERT_THRESHOLD", int64(c.Settings.DestroyRetryAlertThreshold))
+	if p := c.Settings.DiskWarnPercent; p <= 0 || p > 100 {
+		v.fail("DRONE_SETTINGS_DISK_WARN_PERCENT", "must be between 1 and 100, got %d", p)
+	}
+	if c.Settings.MinPoolSize > c.Settings.MaxPoolSize {
+		v.fail("DRONE_MIN_POOL_SIZE", "must not be greater than DRONE_MAX_POOL_SIZE (%d), got %d", c.Settings.MaxPoolSize, c.Settings.MinPoolSize)
+	}
+	if c.LiteEngine.EnableMock && c.LiteEngine.MockStepTimeoutSecs <= 0 {
+		v.fail("DRONE_LITE_ENGINE_MOCK_STEP_TIMEOUT_SECS", "must be positive, got %d", c.LiteEngine.MockStepTimeoutSecs)
+	}
+	if c.Dlite.PollIntervalMilliSecs <= 0 {
+		v.fail("DLITE_POLL_INTERVAL_MILLISECS", "must be positive, got %d", c.Dlite.PollIntervalMilliSecs)
+	}
+	if c.Dlite.ParallelWorkers <= 0 {
+		v.fail("DLITE_PARALLEL_WORKERS", "must be positive, got %d", c.Dlite.ParallelWorkers)
+	}
+	if c.Dlite.PollConcurrency <= 0 {
+		v.fail("DLITE_POLL_CONCURRENCY", "must be positive, got %d", c.Dlite.PollConcurrency)
+	}
+	if c.Dlite.QueueSize <= 0 {
+		v.fail("DLITE_QUEUE_SIZE", "must be positive, got %d", c.Dlite.QueueSize)
+	}
+	v.nonNegative("DLITE_SETUP_WORKERS", int64(c.Dlite.SetupWorkers))
+	v.nonNegative("DLITE_STEP_WORKERS", int64(c.Dlite.StepWorkers))
+	v.nonNegative("DLITE_DESTROY_WORKERS", int64(c.Dlite.DestroyWorkers))
+
+	c.validateLogSink(v)
+	c.validateCache(v)
+	c.validateArtifacts(v)
+	c.validateOIDC(v)
+	c.validateStepSecrets(v)
+	c.validateEvents(v)
+	c.validateDNS(v)
+	c.validateCrypto(v)
+	c.validateAlerts(v)
+
+	if c.Tunnel.Bind != "" {
+		if c.Tunnel.Address == "" {
+			v.fail("DRONE_TUNNEL_ADDRESS", "must be set with DRONE_TUNNEL_BIND")
+		} else if _, _, err := tunnel.SplitAddress(c.Tunnel.Address); err != nil {
+			v.fail("DRONE_TUNNEL_ADDRESS", "invalid address %q: %s", c.Tunnel.Address, err)
+		}
+		if c.Tunnel.HostKeyPath == "" {
+			v.fail("DRONE_TUNNEL_HOST_KEY_PATH", "must be set with DRONE_TUNNEL_BIND")
+		}
+		v.file("DRONE_TUNNEL_HOST_KEY_PATH", c.Tunnel.HostKeyPath)
+	}
+	c.validateKeyServer(v)
+	return v.err()
+}
+
+func (c *EnvConfig) validateCache(v validator) {
+	s := &c.Cache
+	if !validateStore(v, "DRONE_CACHE", s.Type, s.Bucket, s.GCSServiceAccount, s.AzureAccount, s.AzureKey) {
+		return
+	}
+	v.nonNegative("DRONE_CACHE_MAX_SIZE_MB", s.MaxSizeMB)
+	if s.URLTTLSecs <= 0 {
+		v.fail("DRONE_CACHE_URL_TTL_SECS", "must be positive, got %d", s.URLTTLSecs)
+	}
+}
+
+func (c *EnvConfig) validateArtifacts(v validator) {
+	s := &c.Artifacts
+	if !validateStore(v, "DRONE_ARTIFACTS", s.Type, s.Bucket, s.GCSServiceAccount, s.AzureAccount, s.AzureKey) {
+		return
+	}
+	if s.Endpoint == "" {
+		v.fail("DRONE_ARTIFACTS_ENDPOINT", "must be set with the %s artifacts", s.Type)
+	} else if u, err := url.Parse(s.Endpoint); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		v.fail("DRONE_ARTIFACTS_ENDPOINT", "invalid url %q", s.Endpoint)
+	}
+	if s.Secret == "" {
+		v.fail("DRONE_ARTIFACTS_SECRET", "must be set with the %s artifacts", s.Type)
+	}
+	if s.URLTTLSecs <= 0 {
+		v.fail("DRONE_ARTIFACTS_URL_TTL_SECS", "must be positive, got %d", s.URLTTLSecs)
+	}
+	if s.TokenTTLMins <= 0 {
+		v.fail("DRONE_ARTIFACTS_TOKEN_TTL_MINS", "must be positive, got %d", s.TokenTTLMins)
+	}
+}
+
+func (c *EnvConfig) validateOIDC(v validator) {
+	s := &c.OIDC
+	if s.Issuer == "" {
+		if s.PrivateKeyPath != "" {
+			v.fail("DRONE_OIDC_ISSUER", "must be set with DRONE_OIDC_PRIVATE_KEY_PATH")
+		}
+		return
+	}
+	// the clouds only fetch the keys of the https issuers
+	if u, err := url.Parse(s.Issuer); err != nil || u.Scheme != "https" || u.Host == "" {
+		v.fail("DRONE_OIDC_ISSUER", "must be an https url, got %q", s.Issuer)
+	}
+	v.file("DRONE_OIDC_PRIVATE_KEY_PATH", s.PrivateKeyPath)
+	// the replicas must sign the tokens with the same key
+	if s.PrivateKeyPath == "" && c.HA.Mode != "" {
+		v.fail("DRONE_OIDC_PRIVATE_KEY_PATH", "must be set with DRONE_HA_MODE")
+	}
+}
+
+func (c *EnvConfig) validateStepSecrets(v validator) {
+	s := &c.StepSecrets
+	if !s.Enabled {
+		return
+	}
+	if len(s.Providers) == 0 {
+		v.fail("DRONE_STEP_SECRETS_PROVIDERS", "must be set with DRONE_STEP_SECRETS")
+	}
+	for _, p := range s.Providers {
+		v.oneOf("DRONE_STEP_SECRETS_PROVIDERS", p, secrets.Schemes...)
+	}
+	v.nonNegative("DRONE_STEP_SECRETS_CACHE_TTL_SECS", s.CacheTTLSecs)
+}
+
+func (c *EnvConfig) validateEvents(v validator) {
+	s := &c.Events
+	v.oneOf("DRONE_EVENTS", s.Type, "", events.TypeKafka, events.TypeNATS, events.TypeSQS, events.TypePubSub)
+	if s.Type == "" {
+		return
+	}
+	if s.Topic == "" {
+		v.fail("DRONE_EVENTS_TOPIC", "must be set with the %s events", s.Type)
+	}
+	switch s.Type {
+	case events.TypeKafka, events.TypeNATS:
+		if s.Endpoint == "" {
+			v.fail("DRONE_EVENTS_ENDPOINT", "must be set with the %s events", s.Type)
+		}
+	case events.TypePubSub:
+		if s.Topic != "" && !strings.HasPrefix(s.Topic, "projects/") {
+			v.fail("DRONE_EVENTS_TOPIC", "must be projects/<project>/topics/<topic> with the pubsub events, got %q", s.Topic)
+		}
+	case events.TypeSQS:
+		if u, err := url.Parse(s.Topic); s.Topic != "" && (err != nil || u.Scheme != "https" || u.Host == "") {
+			v.fail("DRONE_EVENTS_TOPIC", "must be the url of the queue with the sqs events, got %q", s.Topic)
+		}
+	}
+	v.oneOf("DRONE_EVENTS_FORMAT", s.Format, events.FormatJSON, events.FormatCloudEvents)
+	if s.Buffer <= 0 {
+		v.fail("DRONE_EVENTS_BUFFER", "must be positive, got %d", s.Buffer)
+	}
+}
+
+// validateStore checks the bucket of the settings with the prefix, it returns false if the store
+// is disabled.
+func validateStore(v validator, prefix, storeType, bucket, gcsServiceAccount, azureAccount, azureKey string) bool {
+	v.oneOf(prefix, storeType, "", objectstore.TypeS3, objectstore.TypeGCS, objectstore.TypeAzure)
+	if storeType == "" {
+		return false
+	}
+	if bucket == "" {
+		v.fail(prefix+"_BUCKET", "must be set with the %s store", storeType)
+	}
+	switch storeType {
+	case objectstore.TypeGCS:
+		if gcsServiceAccount == "" {
+			v.fail(prefix+"_GCS_SERVICE_ACCOUNT", "must be set with the %s store", storeType)
+		}
+	case objectstore.TypeAzure:
+		if azureAccount == "" {
+			v.fail(prefix+"_AZURE_ACCOUNT", "must be set with the %s store", storeType)
+		}
+		if azureKey == "" {
+			v.fail(prefix+"_AZURE_KEY", "must be set with the %s store", storeType)
+		}
+	}
+	return true
+}
+
+func (c *EnvConfig) validateLogSink(v validator) {
+	s := &c.LogSink
+	v.oneOf("DRONE_LOG_SINK", s.Type, "", logsink.TypeStdout, logsink.TypeStdoutJSON, logsink.TypeFile, logsink.TypeS3, logsink.TypeGCS, logsink.TypeLoki)
+	switch s.Type {
+	case logsink.TypeFile:
+		if s.Path == "" {
+			v.fail("DRONE_LOG_SINK_PATH", "must be set with the %s sink", s.Type)
+		}
+	case logsink.TypeS3, logsink.TypeGCS:
+		if s.Bucket == "" {
+			v.fail("DRONE_LOG_SINK_BUCKET", "must be set with the %s sink", s.Type)
+		}
+	case logsink.TypeLoki:
+		if s.URL == "" {
+			v.fail("DRONE_LOG_SINK_URL", "must be set with the %s sink", s.Type)
+		}
+	}
+	if s.FlushIntervalMs <= 0 {
+		v.fail("DRONE_LOG_SINK_FLUSH_INTERVAL_MS", "must be positive, got %d", s.FlushIntervalMs)
+	}
+	if s.BatchSize <= 0 {
+		v.fail("DRONE_LOG_SINK_BATCH_SIZE", "must be positive, got %d", s.BatchSize)
+	}
+	if s.MaxInFlight <= 0 {
+		v.fail("DRONE_LOG_SINK_MAX_IN_FLIGHT", "must be positive, got %d", s.MaxInFlight)
+	}
+	if s.MaxBuffered < s.BatchSize {
+		v.fail("DRONE_LOG_SINK_MAX_BUFFERED", "must not be lower than DRONE_LOG_SINK_BATCH_SIZE (%d), got %d", s.BatchSize, s.MaxBuffered)
+	}
+}
+
+func (c *EnvConfig) validateDNS(v validator) {
+	d := &c.DNS
+	v.oneOf("DRONE_DNS_PROVIDER", d.Provider, "", dns.ProviderRoute53, dns.ProviderCloudDNS, dns.ProviderRFC2136)
+	if d.Provider == "" {
+		return
+	}
+	if strings.Trim(d.Zone, ".") == "" {
+		v.fail("DRONE_DNS_ZONE", "must be set with DRONE_DNS_PROVIDER")
+	}
+	switch d.Provider {
+	case dns.ProviderRoute53:
+		if d.Route53ZoneID == "" {
+			v.fail("DRONE_DNS_ROUTE53_ZONE_ID", "must be set with the %s provider", d.Provider)
+		}
+	case dns.ProviderCloudDNS:
+		if d.GoogleProject == "" {
+			v.fail("DRONE_DNS_GOOGLE_PROJECT", "must be set with the %s provider", d.Provider)
+		}
+		if d.GoogleManagedZone == "" {
+			v.fail("DRONE_DNS_GOOGLE_MANAGED_ZONE", "must be set with the %s provider", d.Provider)
+		}
+		v.file("DRONE_DNS_GOOGLE_JSON_PATH", d.GoogleJSONPath)
+	case dns.ProviderRFC2136:
+		if d.Server == "" {
+			v.fail("DRONE_DNS_SERVER", "must be set with the %s provider", d.Provider)
+		}
+		v.pair("DRONE_DNS_TSIG_KEY", d.TSIGKey, "DRONE_DNS_TSIG_SECRET", d.TSIGSecret)
+	}
+}
+
+// validateAlerts checks the notifiers of the alerts.
+func (c *EnvConfig) validateAlerts(v validator) {
+	a := &c.Alerts
+	if a.WebhookURL != "" {
+		if u, err := url.Parse(a.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.fail("DRONE_ALERT_WEBHOOK_URL", "must be an http or https URL, got %q", a.WebhookURL)
+		}
+	}
+	if a.WindowSecs <= 0 {
+		v.fail("DRONE_ALERT_WINDOW_SECS", "must be positive, got %d", a.WindowSecs)
+	}
+	if a.MaxPerWindow <= 1 {
+		v.fail("DRONE_ALERT_MAX_PER_WINDOW", "must be greater than 1, got %d", a.MaxPerWindow)
+	}
+}
+
+// validateKeyServer checks the server of the TLS keys of the pools with the url key delivery.
+func (c *EnvConfig) validateKeyServer(v validator) {
+	k := &c.KeyServer
+	if k.Bind == "" {
+		return
+	}
+	if u, err := url.Parse(k.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.fail("DRONE_KEY_SERVER_URL", "must be an http or https URL set with DRONE_KEY_SERVER_BIND, got %q", k.URL)
+	}
+	v.pair("DRONE_KEY_SERVER_CERT_FILE", k.CertFile, "DRONE_KEY_SERVER_KEY_FILE", k.KeyFile)
+	v.file("DRONE_KEY_SERVER_CERT_FILE", k.CertFile)
+	v.file("DRONE_KEY_SERVER_KEY_FILE", k.KeyFile)
+}
+
+// validateCrypto checks the TLS policy of the runner.
+func (c *EnvConfig) validateCrypto(v validator) {
+	if c.Crypto.FIPS && !fips.Enabled() {
+		v.fail("DRONE_CRYPTO_FIPS", "requires a runner built with GOEXPERIMENT=boringcrypto")
+	}
+	if _, err := fips.ParseVersion(c.Crypto.TLSMinVersion); err != nil {
+		v.fail("DRONE_CRYPTO_TLS_MIN_VERSION", "%s", err)
+	}
+	if _, err := fips.ParseCipherSuites(c.Crypto.TLSCipherSuites); err != nil {
+		v.fail("DRONE_CRYPTO_TLS_CIPHER_SUITES", "%s", err)
+	}
+}
+
+// processEnviron loads the settings, if some fail to parse it loads them one by one so all of them
+// are reported, envconfig stops at the first one.
+func processEnviron(config *EnvConfig) error {
+	err := envconfig.Process("", config)
+	if _, ok := err.(*envconfig.ParseError); !ok {
+		return err
+	}
+	v := validator{errs: &ValidationError{Source: "environment"}}
+	parseEnvconfigFields(v, reflect.TypeOf(config).Elem())
+	if len(v.errs.Errors) == 0 {
+		return err
+	}
+	return v.errs
+}
+
+func parseEnvconfigFields(v validator, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Type.Kind() == reflect.Struct && f.Tag.Get("envconfig") == "" {
+			parseEnvconfigFields(v, f.Type)
+			continue
+		}
+		if f.Tag.Get("envconfig") == "" || f.Tag.Get("ignored") == "true" {
+			continue
+		}
+		single := reflect.New(reflect.StructOf([]reflect.StructField{{Name: f.Name, Type: f.Type, Tag: f.Tag}}))
+		var parseErr *envconfig.ParseError
+		if errors.As(envconfig.Process("", single.Interface()), &parseErr) {
+			cause := parseErr.Err
+			if u := errors.Unwrap(cause); u != nil {
+				cause = u
+			}
+			v.fail(parseErr.KeyName, "invalid %s %q: %s", parseErr.TypeName, parseErr.Value, cause)
+		}
+	}
+}
+
+// UnknownEnviron returns the runner variables, prefixed with DRONE_, of the environment which are
+// not settings of the runner, e.g. misspelled ones.
+func UnknownEnviron(environ []string) []string {
+	known := map[string]bool{}
+	collectEnvconfigKeys(reflect.TypeOf(EnvConfig{}), known)
+	for k := range legacy {
+		known[k] = true
+	}
+	var unknown []string
+	for _, kv := range environ {
+		key, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(key, "DRONE_") && !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+func collectEnvconfigKeys(t reflect.Type, keys map[string]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if key := f.Tag.Get("envconfig"); key != "" {
+			keys[key] = true
+		}
+		if f.Type.Kind() == reflect.Struct {
+			collectEnvconfigKeys(f.Type, keys)
+		}
+	}
+}
+
+// Validate checks the pools of the pool file and returns all the invalid settings at once.
+func (p *PoolFile) Validate() error {
+	v := validator{errs: &ValidationError{Source: "pool file"}}
+	p.validate(v)
+	return v.err()
+}
+
+func (p *PoolFile) validate(v validator) {
+	names := map[string]int{}
+	defaultPool := -1
+	for i := range p.Instances {
+		inst := &p.Instances[i]
+		iv := validator{path: "instances", errs: v.errs}.index(i)
+		if j, ok := names[inst.Name]; ok && inst.Name != "" {
+			iv.fail("name", "duplicate pool %q, already defined by instances[%d]", inst.Name, j)
+		}
+		names[inst.Name] = i
+		if inst.Default {
+			if defaultPool >= 0 {
+				iv.fail("default", "only one pool can be the default, instances[%d] already is", defaultPool)
+			} else {
+				defaultPool = i
+			}
+		}
+		inst.validate(iv)
+	}
+
+	routes := map[[3]string]int{}
+	for i := range p.Routes {
+		r := &p.Routes[i]
+		rv := validator{path: "routes", errs: v.errs}.index(i)
+		if r.Pool == "" {
+			rv.fail("pool", "must be set")
+		}
+		rv.oneOf("os", r.OS, "", oshelp.OSLinux, oshelp.OSWindows, oshelp.OSMac)
+		rv.oneOf("arch", r.Arch, "", oshelp.ArchAMD64, oshelp.ArchARM64)
+		key := [3]string{r.OS, r.Arch, r.ResourceClass}
+		if j, ok := routes[key]; ok {
+			rv.fail("", "duplicate route, routes[%d] already matches the same platform and resource class", j)
+		}
+		routes[key] = i
+	}
+}
+
+// Validate checks the settings of a pool and returns all the invalid ones at once.
+func (s *Instance) Validate() error {
+	v := validator{errs: &ValidationError{Source: fmt.Sprintf("pool %q", s.Name)}}
+	s.validate(v)
+	return v.err()
+}
+
+func (s *Instance) validate(v validator) {
+	if s.Name == "" {
+		v.fail("name", "must be set")
+	}
+	v.nonNegative("pool", int64(s.Pool))
+	v.nonNegative("limit", int64(s.Limit))
+	// the overflow does not require the limit to be set, the pools without a limit get the default one.
+	v.nonNegative("overflow", int64(s.Overflow))
+	if s.Limit > 0 && s.Pool > s.Limit {
+		v.fail("pool", "must not be greater than the limit (%d), got %d", s.Limit, s.Pool)
+	}
+	v.oneOf("platform.os", s.Platform.OS, "", oshelp.OSLinux, oshelp.OSWindows, oshelp.OSMac)
+	v.oneOf("platform.arch", s.Platform.Arch, "", oshelp.ArchAMD64, oshelp.ArchARM64)
+	v.oneOf("startup_script", s.StartupScript, "", cloudinit.ProviderCloudInit, cloudinit.ProviderShell, cloudinit.ProviderIgnition)
+
+	v.oneOf("bootstrap.mode", s.Bootstrap.Mode, "", lehelper.BootstrapUserData, lehelper.BootstrapSSH)
+	if s.Bootstrap.Mode == lehelper.BootstrapSSH && s.Bootstrap.PrivateKeyPath == "" {
+		v.fail("bootstrap.private_key_path", "must be set with the %s bootstrap", lehelper.BootstrapSSH)
+	}
+	v.file("bootstrap.private_key_path", s.Bootstrap.PrivateKeyPath)
+	v.file("bootstrap.known_hosts_path", s.Bootstrap.KnownHostsPath)
+	if s.Bootstrap.ConsoleHostKeys {
+		switch {
+		case s.Bootstrap.Mode != lehelper.BootstrapSSH:
+			v.fail("bootstrap.console_host_keys", "must be used with the %s bootstrap", lehelper.BootstrapSSH)
+		case s.Bootstrap.KnownHostsPath != "":
+			v.fail("bootstrap.console_host_keys", "must not be set with bootstrap.known_hosts_path")
+		case s.Type != string(types.Amazon) && s.Type != string(types.Google):
+			v.fail("bootstrap.console_host_keys", "is not supported by the %s driver, it doesn't read the console output", s.Type)
+		}
+	}
+	v.nonNegative("bootstrap.timeout_secs", s.Bootstrap.TimeoutSecs)
+	s.validateTuning(v)
+	s.validateWindowsContainers(v.at("bootstrap.windows_containers"))
+	validateChecksums(v.at("bootstrap.checksums"), s.Bootstrap.Checksums)
+
+	if port := s.LiteEngine.Port; port < 0 || port > 65535 {
+		v.fail("lite_engine.port", "must be between 0 and 65535, got %d", port)
+	}
+	v.pair("lite_engine.ca_cert_path", s.LiteEngine.CACertPath, "lite_engine.ca_key_path", s.LiteEngine.CAKeyPath)
+	v.file("lite_engine.ca_cert_path", s.LiteEngine.CACertPath)
+	v.file("lite_engine.ca_key_path", s.LiteEngine.CAKeyPath)
+	validateLiteEngineEnv(v.at("lite_engine"), s.LiteEngine)
+
+	v.nonNegative("untrusted.max_age_mins", s.Untrusted.MaxAgeMins)
+	v.nonNegative("step_timeout_secs", s.StepTimeout)
+	s.validateTimeouts(v.at("timeouts"))
+	if len(s.Sizes) > 0 && s.Type != string(types.Amazon) && s.Type != string(types.Google) && s.Type != string(types.Azure) {
+		v.fail("sizes", "is not supported by the %s driver", s.Type)
+	}
+	for _, class := range cloudinit.EnvNames(s.Sizes) {
+		if class == "" || s.Sizes[class] == "" {
+			v.fail("sizes", "the resource class %q has no machine type", class)
+		}
+	}
+	v.nonNegative("destroy_grace_period_secs", s.DestroyGracePeriod)
+	if s.FinalizeScript != "" && s.DestroyGracePeriod <= 0 {
+		v.fail("destroy_grace_period_secs", "must be set with a finalize script, it bounds the script")
+	}
+
+	if p := s.Rollout.Percent; p < 0 || p > 100 {
+		v.fail("rollout.percent", "must be between 0 and 100, got %d", p)
+	}
+	v.nonNegative("rollout.min_stages", int64(s.Rollout.MinStages))
+	v.nonNegative("rollout.duration_mins", s.Rollout.DurationMins)
+	if f := s.Rollout.MaxFailureIncrease; f < 0 || f > 1 {
+		v.fail("rollout.max_failure_increase", "must be between 0 and 1, got %g", f)
+	}
+	s.validateSchedule(v.at("schedule"))
+	s.validateServices(v.at("services"))
+	s.validateDestroyHooks(v.at("destroy_hooks"))
+	validateWorkspaceSnapshot(v.at("workspace_snapshot"), &s.WorkspaceSnapshot)
+	validateCredentials(v.at("credentials"), &s.Credentials)
+	s.validateProfile(v.at("profile"))
+
+	s.validateSpec(v.at("spec"))
+}
+
+// hookName is the pattern of the names of the destroy hooks, they are part of the log keys of their output.
+var hookName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// validateDestroyHooks checks the destroy hooks of the pool, their scripts are text/templates.
+func (s *Instance) validateDestroyHooks(v validator) {
+	names := map[string]bool{}
+	for i := range s.DestroyHooks {
+		h := &s.DestroyHooks[i]
+		hv := v.index(i)
+		switch {
+		case h.Name == "":
+			hv.fail("name", "must be set")
+		case !hookName.MatchString(h.Name):
+			hv.fail("name", "%q must only contain lowercase letters, digits, dashes and underscores", h.Name)
+		case names[h.Name]:
+			hv.fail("name", "duplicate destroy hook %q", h.Name)
+		}
+		names[h.Name] = true
+		if strings.TrimSpace(h.Script) == "" {
+			hv.fail("script", "must be set")
+		} else if _, err := template.New(h.Name).Parse(h.Script); err != nil {
+			hv.fail("script", "is not a valid template: %s", err)
+		}
+		hv.timeout("timeout_secs", h.TimeoutSecs)
+	}
+}
+
+// validateServices checks the service containers of the pool, their names are their hostnames
+// on the network of the stage.
+func (s *Instance) validateServices(v validator) {
+	if len(s.Services) > 0 && s.Platform.OS == oshelp.OSMac {
+		v.fail("", "are not supported on %s, the instances run no containers", s.Platform.OS)
+	}
+	names := map[string]bool{}
+	for i := range s.Services {
+		svc := &s.Services[i]
+		sv := v.index(i)
+		if svc.Name == "" {
+			sv.fail("name", "must be set")
+		} else if names[svc.Name] {
+			sv.fail("name", "duplicate service %q", svc.Name)
+		}
+		names[svc.Name] = true
+		if svc.Image == "" {
+			sv.fail("image", "must be set")
+		}
+		hosts := make([]string, 0, len(svc.Ports))
+		for host := range svc.Ports {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		for _, host := range hosts {
+			for _, port := range []string{host, svc.Ports[host]} {
+				if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+					sv.fail("ports", "invalid port %q", port)
+				}
+			}
+		}
+	}
+}
+
+// validateWorkspaceSnapshot checks the workspace snapshot of the pool, its path is required with
+// its url only. The setup requests might set the url of the snapshot of their stage.
+func validateWorkspaceSnapshot(v validator, ws *types.WorkspaceSnapshot) {
+	if ws.URL == "" {
+		return
+	}
+	if _, err := objectstore.Parse(ws.URL); err != nil {
+		v.fail("url", "%s", err)
+	}
+	if ws.Path == "" {
+		v.fail("path", "must be set with the url")
+	}
+}
+
+// validateCredentials checks the cloud credentials of the pool, the aws roles are assumed for 15
+// minutes at least and 12 hours at most.
+func validateCredentials(v validator, c *types.PoolCredentials) {
+	v.nonNegative("ttl_mins", c.TTLMins)
+	if c.AWS != nil {
+		if c.TTLMins != 0 && (c.TTLMins < 15 || c.TTLMins > 720) {
+			v.fail("ttl_mins", "must be between 15 and 720 with the aws credentials, got %d", c.TTLMins)
+		}
+		if !strings.HasPrefix(c.AWS.RoleARN, "arn:") {
+			v.fail("aws.role_arn", "must be the arn of a role, got %q", c.AWS.RoleARN)
+		}
+	}
+	if c.GCP != nil && !strings.HasPrefix(c.GCP.Provider, "//iam.googleapis.com/") {
+		v.fail("gcp.provider", "must be the resource name of a workload identity provider, got %q", c.GCP.Provider)
+	}
+	if c.Azure != nil {
+		if c.Azure.TenantID == "" {
+			v.fail("azure.tenant_id", "must be set")
+		}
+		if c.Azure.ClientID == "" {
+			v.fail("azure.client_id", "must be set")
+		}
+	}
+}
+
+// instanceProfileName is the pattern of the names of the aws instance profiles.
+var instanceProfileName = regexp.MustCompile(`^[\w+=,.@-]{1,128}$`)
+
+// validateProfile checks the identity of the provider of the pool, the identities of the other
+// providers are left to the pools of those providers. It must not be set along with the identity
+// of the spec of the pool.
+func (s *Instance) validateProfile(v validator) {
+	p := s.Profile
+	switch s.Type {
+	case string(types.Amazon):
+		if p.AWS == "" {
+			return
+		}
+		if strings.HasPrefix(p.AWS, "arn:") {
+			if !strings.Contains(p.AWS, ":instance-profile/") {
+				v.fail("aws", "must be the arn of an instance profile, got %q", p.AWS)
+			}
+		} else if !instanceProfileName.MatchString(p.AWS) {
+			v.fail("aws", "must be the name or the arn of an instance profile, got %q", p.AWS)
+		}
+		if spec, ok := s.Spec.(*Amazon); ok && spec.IamProfileArn != "" {
+			v.fail("aws", "must not be set with spec.iam_profile_arn")
+		}
+	case string(types.Google):
+		if p.GCP == "" {
+			return
+		}
+		if !strings.Contains(p.GCP, "@") {
+			v.fail("gcp", "must be the email of a service account, got %q", p.GCP)
+		}
+		if spec, ok := s.Spec.(*Google); ok {
+			if spec.Account.ServiceAccountEmail != "" {
+				v.fail("gcp", "must not be set with spec.account.service_account_email")
+			}
+			if spec.Account.NoServiceAccount {
+				v.fail("gcp", "must not be set with spec.account.no_service_account")
+			}
+		}
+	case string(types.Azure):
+		if p.Azure == "" {
+			return
+		}
+		if !strings.HasPrefix(p.Azure, "/subscriptions/") ||
+			!strings.Contains(strings.ToLower(p.Azure), "/providers/microsoft.managedidentity/userassignedidentities/") {
+			v.fail("azure", "must be the resource ID of a user-assigned managed identity, got %q", p.Azure)
+		}
+	default:
+		if p.AWS != "" || p.GCP != "" || p.Azure != "" {
+			v.fail("", "is not supported by the %s driver", s.Type)
+		}
+	}
+}
+
+// maxTimeoutSecs bounds the timeouts of the operations of the drivers, longer operations are
+// stuck rather than slow.
+const maxTimeoutSecs = 6 * 60 * 60
+
+// The default timeouts of the jobs of the nomad driver, see nomad.WithTimeouts.
+const (
+	nomadResourceSecs = 3 * 60
+	nomadInitSecs     = 5 * 60
+	nomadDestroySecs  = 10 * 60
+)
+
+// validateTimeouts checks the timeouts of the operations of the driver of the pool, the ones of the
+// nomad pools must leave the time for the jobs of the nomad driver.
+func (s *Instance) validateTimeouts(v validator) {
+	t := s.Timeouts
+	v.timeout("create_secs", t.CreateSecs)
+	v.timeout("destroy_secs", t.DestroySecs)
+	v.timeout("start_secs", t.StartSecs)
+	v.timeout("hibernate_secs", t.HibernateSecs)
+	v.timeout("boot_secs", t.BootSecs)
+
+	spec, ok := s.Spec.(*Nomad)
+	if !ok {
+		return
+	}
+	resource, init, destroy := int64(nomadResourceSecs), int64(nomadInitSecs), int64(nomadDestroySecs)
+	if secs := spec.VM.Timeouts.ResourceSecs; secs > 0 {
+		resource = secs
+	}
+	if secs := spec.VM.Timeouts.InitSecs; secs > 0 {
+		init = secs
+	}
+	if secs := spec.VM.Timeouts.DestroySecs; secs > 0 {
+		destroy = secs
+	}
+	if t.CreateSecs > 0 && t.CreateSecs < resource+init {
+		v.fail("create_secs", "must not be shorter than the resource and init jobs of the nomad driver (%ds), got %d", resource+init, t.CreateSecs)
+	}
+	if t.DestroySecs > 0 && t.DestroySecs < destroy {
+		v.fail("destroy_secs", "must not be shorter than the destroy job of the nomad driver (%ds), got %d", destroy, t.DestroySecs)
+	}
+}
+
+// validateSchedule checks the off-hours of the pool, the times are cron expressions.
+func (s *Instance) validateSchedule(v validator) {
+	sc := s.Schedule
+	if !sc.IsSet() {
+		return
+	}
+	v.pair("sleep", sc.Sleep, "wake", sc.Wake)
+	for _, f := range []struct{ key, expr string }{{"sleep", sc.Sleep}, {"wake", sc.Wake}} {
+		if f.expr == "" {
+			continue
+		}
+		if _, err := cronexpr.Parse(f.expr); err != nil {
+			v.fail(f.key, "invalid cron expression %q: %s", f.expr, err)
+		}
+	}
+	if _, err := time.LoadLocation(sc.Timezone); err != nil {
+		v.fail("timezone", "unknown timezone %q", sc.Timezone)
+	}
+	v.oneOf("action", sc.Action, "", "hibernate", "destroy")
+}
+
+// validateTuning checks the kernel settings of the pool, only the linux startup scripts apply them.
+func (s *Instance) validateTuning(v validator) {
+	t := s.Bootstrap.Tuning
+	v.nonNegative("bootstrap.tuning.swap_size_mb", t.SwapSizeMB)
+	v.nonNegative("bootstrap.tuning.max_map_count", t.MaxMapCount)
+	v.nonNegative("bootstrap.tuning.nofile", t.NoFile)
+	v.oneOf("bootstrap.tuning.transparent_hugepages", t.TransparentHugepages, "", "always", "madvise", "never")
+	if !t.IsSet() {
+		return
+	}
+	if s.Platform.OS != "" && s.Platform.OS != oshelp.OSLinux {
+		v.fail("bootstrap.tuning", "is not supported on %s", s.Platform.OS)
+	}
+	if s.StartupScript == cloudinit.ProviderIgnition {
+		v.fail("bootstrap.tuning", "is not supported with the %s startup script", cloudinit.ProviderIgnition)
+	}
+}
+
+var dockerVersion = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+$`)
+
+// validateWindowsContainers checks the settings of the Windows containers, only the windows
+// startup script applies them and docker is only installed on amd64.
+func (s *Instance) validateWindowsContainers(v validator) {
+	wc := s.Bootstrap.WindowsContainers
+	if !wc.Enabled {
+		if wc.Isolation != "" || wc.DockerVersion != "" || len(wc.BaseImages) > 0 {
+			v.fail("enabled", "must be set with the other settings of the Windows containers")
+		}
+		return
+	}
+	if s.Platform.OS != oshelp.OSWindows {
+		v.fail("enabled", "is only supported on %s, got %q", oshelp.OSWindows, s.Platform.OS)
+	}
+	if s.Platform.Arch != "" && s.Platform.Arch != oshelp.ArchAMD64 {
+		v.fail("enabled", "is only supported on %s, got %q", oshelp.ArchAMD64, s.Platform.Arch)
+	}
+	v.oneOf("isolation", wc.Isolation, "", "process", "hyperv")
+	if wc.DockerVersion != "" && !dockerVersion.MatchString(wc.DockerVersion) {
+		v.fail("docker_version", "must be a version like %s, got %q", cloudinit.DefaultDockerVersion, wc.DockerVersion)
+	}
+	for i, image := range wc.BaseImages {
+		if image == "" || strings.ContainsAny(image, " \t\r\n'\"") {
+			v.at("base_images").index(i).fail("", "invalid image %q", image)
+		}
+	}
+}
+
+// liteEngineEnvNames are the variables of the environment of the lite-engine set by the startup scripts.
+var liteEngineEnvNames = []string{"SKIP_PREPARE_SERVER", "HTTPS_BIND", "SERVER_CERT_FILE", "SERVER_KEY_FILE", "CLIENT_CERT_FILE", cloudinit.FeatureFlagsEnv,
+	leasetoken.KeyEnv, leasetoken.KeyFileEnv}
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateLiteEngineEnv checks the variables and the feature flags fit on the lines of the environment
+// file of the lite-engine, and don't override the variables set by the startup scripts.
+func validateLiteEngineEnv(v validator, le types.LiteEngine) {
+	for _, name := range cloudinit.EnvNames(le.Env) {
+		switch {
+		case !envName.MatchString(name):
+			v.fail("env", "%q is not a valid variable name", name)
+		case contains(liteEngineEnvNames, name):
+			v.fail("env", "%s is set by the startup script", name)
+		case strings.ContainsAny(le.Env[name], "\r\n"):
+			v.fail("env."+name, "must be a single line")
+		}
+	}
+	for i, feature := range le.Features {
+		if feature == "" || strings.ContainsAny(feature, ", \t\r\n") {
+			v.fail(fmt.Sprintf("features[%d]", i), "%q is not a valid feature flag", feature)
+		}
+	}
+}
+
+// validateChecksums checks the digests of the downloaded binaries are hex encoded SHA256 digests.
+func validateChecksums(v validator, c types.Checksums) {
+	for _, f := range []struct{ key, sum string }{{"lite_engine", c.LiteEngine}, {"plugin", c.Plugin}, {"split_tests", c.SplitTests}} {
+		if f.sum == "" {
+			continue
+		}
+		if b, err := hex.DecodeString(f.sum); err != nil || len(b) != sha256.Size {
+			v.fail(f.key, "must be a hex encoded sha256 digest")
+		}
+	}
+}
+
+var ipFamilies = []string{"", string(types.IPv4), string(types.DualStack), string(types.IPv6)}
+
+// validateSpec checks the driver specific settings of the pool.
+func (s *Instance) validateSpec(v validator) {
+	switch spec := s.Spec.(type) {
+	case *Amazon:
+		userData(v, spec.UserData, spec.UserDataPath, "user_data_Path")
+		v.oneOf("network.ip_family", spec.Network.IPFamily, ipFamilies...)
+		if spec.Standby && (spec.UserData != "" || spec.UserDataPath != "") {
+			v.fail("standby", "is not supported with custom user data")
+		}
+		if spec.AMI != "" && spec.AMIFilter.Name != "" {
+			v.fail("ami_filter", "must not be set with ami")
+		}
+		if spec.AMIFilter.Name == "" && len(spec.AMIFilter.Owners) > 0 {
+			v.fail("ami_filter.name", "must be set with ami_filter.owners")
+		}
+	case *Google:
+		userData(v, spec.UserData, spec.UserDataPath, "user_data_path")
+		v.oneOf("ip_family", spec.IPFamily, ipFamilies...)
+		if spec.Standby && (spec.UserData != "" || spec.UserDataPath != "") {
+			v.fail("standby", "is not supported with custom user data")
+		}
+		if spec.Image != "" && spec.ImageFamily != "" {
+			v.fail("image_family", "must not be set with image")
+		}
+		if spec.Account.JSONPath != "" && !strings.HasPrefix(spec.Account.JSONPath, "~") {
+			v.file("account.json_path", spec.Account.JSONPath)
+		}
+	case *Azure:
+		userData(v, spec.UserData, spec.UserDataPath, "user_data_path")
+	case *DigitalOcean:
+		userData(v, spec.UserData, spec.UserDataPath, "user_data_Path")
+	case *Anka:
+		userData(v, spec.UserData, spec.UserDataPath, "user_data_Path")
+	case *AnkaBuild:
+		userData(v, spec.UserData, spec.UserDataPath, "user_data_Path")
+	case *VMFusion:
+		userData(v, spec.UserData, spec.UserDataPath, "user_data_Path")
+	case *Nomad:
+		sv := v.at("server")
+		if spec.Server.Address == "" && len(spec.Server.Addresses) == 0 {
+			sv.fail("address", "must be set, or addresses")
+		}
+		for i, address := range spec.Server.Addresses {
+			if u, err := url.Parse(address); err != nil || u.Scheme == "" || u.Host == "" {
+				sv.fail(fmt.Sprintf("addresses[%d]", i), "%q is not a URL", address)
+			}
+		}
+		sv.pair("client_cert_path", spec.Server.ClientCertPath, "client_key_path", spec.Server.ClientKeyPath)
+		sv.file("ca_cert_path", spec.Server.CaCertPath)
+		sv.file("client_cert_path", spec.Server.ClientCertPath)
+		sv.file("client_key_path", spec.Server.ClientKeyPath)
+		tv := v.at("vm.timeouts")
+		tv.timeout("resource_secs", spec.VM.Timeouts.ResourceSecs)
+		tv.timeout("init_secs", spec.VM.Timeouts.InitSecs)
+		tv.timeout("destroy_secs", spec.VM.Timeouts.DestroySecs)
+	case *Static:
+		if s.LiteEngine.LeaseTokens {
+			v.fail("lite_engine.lease_tokens", "is not supported by the static machines, their lite-engine is not started by the runner")
+		}
+		for i := range spec.Machines {
+			m := &spec.Machines[i]
+			mv := v.at("machines").index(i)
+			if m.Address == "" {
+				mv.fail("address", "must be set")
+			}
+			for _, f := range []struct{ key, path string }{
+				{"ca_cert_path", m.CACertPath}, {"cert_path", m.CertPath}, {"key_path", m.KeyPath},
+			} {
+				if f.path == "" {
+					mv.fail(f.key, "must be set")
+				}
+				mv.file(f.key, f.path)
+			}
+		}
+	}
+}
+
+// userData checks the inline user data and the file of the user data are not both set.
+func userData(v validator, data, path, pathKey string) {
+	if data != "" && path != "" {
+		v.fail(pathKey, "must not be set with user_data")
+	}
+	v.file(pathKey, path)
+}
+
+// keyChecker reports the keys of the decoded pool file which are not settings of the pools, the
+// keys match the fields case-insensitively like the decoder does.
+type keyChecker struct {
+	specTypes map[string]string // the types of the pools by path, the spec depends on the type
+}
+
+func (k keyChecker) unknown(v validator, value interface{}, t reflect.Type) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return // the type mismatches are reported by the decoder
+		}
+		fields := map[string]reflect.StructField{}
+		jsonFields(t, fields)
+		for _, key := range sortedKeys(obj) {
+			f, ok := fields[strings.ToLower(key)]
+			if !ok {
+				if suggestion := closest(key, fields); suggestion != "" {
+					v.fail(key, "unknown key, did you mean %q?", suggestion)
+				} else {
+					v.fail(key, "unknown key")
+				}
+				continue
+			}
+			if t == reflect.TypeOf(Instance{}) && f.Name == "Spec" {
+				typ, ok := k.specTypes[v.path]
+				if !ok {
+					typ, _ = obj["type"].(string)
+				}
+				if spec, err := newSpec(typ); err == nil {
+					k.unknown(v.at(key), obj[key], reflect.TypeOf(spec))
+				}
+				continue
+			}
+			k.unknown(v.at(key), obj[key], f.Type)
+		}
+	case reflect.Slice, reflect.Array:
+		items, ok := value.([]interface{})
+		if !ok {
+			return
+		}
+		for i, item := range items {
+			k.unknown(v.index(i), item, t.Elem())
+		}
+	case reflect.Map:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for _, key := range sortedKeys(obj) {
+			k.unknown(v.at(key), obj[key], t.Elem())
+		}
+	}
+}
+
+// jsonFields maps the lower case json names of the fields of the struct to the fields, the fields
+// of the embedded structs included.
+func jsonFields(t reflect.Type, fields map[string]reflect.StructField) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			jsonFields(f.Type, fields)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[strings.ToLower(name)] = f
+	}
+}
+
+// closest returns the known key closest to the unknown one, if it is likely a typo.
+func closest(key string, fields map[string]reflect.StructField) string {
+	const maxDistance = 2
+	best, bestDistance := "", maxDistance+1
+	for name := range fields {
+		if d := levenshtein(strings.ToLower(key), name); d < bestDistance || (d == bestDistance && name < best) {
+			best, bestDistance = name, d
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minOf(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func minOf(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+func sortedKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestInstanceValidate_Overflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		instance Instance
+		invalid  bool
+	}{
+		{name: "default limit", instance: Instance{Name: "pool", Overflow: 5}},
+		{name: "limit", instance: Instance{Name: "pool", Limit: 10, Overflow: 5}},
+		{name: "negative", instance: Instance{Name: "pool", Limit: 10, Overflow: -1}, invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := validator{errs: &ValidationError{Source: "pool file"}}
+			test.instance.validate(v)
+			if err := v.err(); (err != nil) != test.invalid {
+				t.Errorf("expected invalid %v, got %v", test.invalid, err)
+			}
+		})
+	}
+}
+
+func TestEnvConfigValidate_ArtifactsSecret(t *testing.T) {
+	c := &EnvConfig{}
+	c.Artifacts.Type = "s3"
+	c.Artifacts.Bucket = "artifacts"
+	c.Artifacts.Endpoint = "https://runner.example.com"
+	c.Artifacts.URLTTLSecs = 900
+	c.Artifacts.TokenTTLMins = 720
+
+	v := validator{errs: &ValidationError{Source: "environment"}}
+	c.validateArtifacts(v)
+	if errs := v.errs.Errors; len(errs) != 1 || errs[0].Field != "DRONE_ARTIFACTS_SECRET" {
+		t.Fatalf("expected the missing secret to be reported, got %v", errs)
+	}
+
+	c.Artifacts.Secret = "secret"
+	v = validator{errs: &ValidationError{Source: "environment"}}
+	c.validateArtifacts(v)
+	if err := v.err(); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+}
+
+func TestInstanceValidate_Profile(t *testing.T) {
+	identity := "/subscriptions/1/resourceGroups/ci/providers/Microsoft.ManagedIdentity/userAssignedIdentities/runner"
+	tests := []struct {
+		name    string
+		typ     string
+		profile types.InstanceProfile
+		spec    interface{}
+		field   string
+	}{
+		{name: "aws name", typ: "amazon", profile: types.InstanceProfile{AWS: "ci"}},
+		{name: "aws arn", typ: "amazon", profile: types.InstanceProfile{AWS: "arn:aws:iam::123456789012:instance-profile/ci"}},
+		{name: "aws role arn", typ: "amazon", profile: types.InstanceProfile{AWS: "arn:aws:iam::123456789012:role/ci"}, field: "profile.aws"},
+		{name: "aws with arn of spec", typ: "amazon", profile: types.InstanceProfile{AWS: "ci"}, spec: &Amazon{IamProfileArn: "arn:aws:iam::1:instance-profile/x"}, field: "profile.aws"},
+		{name: "gcp", typ: "google", profile: types.InstanceProfile{GCP: "ci@project.iam.gserviceaccount.com"}},
+		{name: "gcp without service account", typ: "google", profile: types.InstanceProfile{GCP: "ci@project.iam.gserviceaccount.com"},
+			spec: &Google{Account: GoogleAccount{NoServiceAccount: true}}, field: "profile.gcp"},
+		{name: "azure", typ: "azure", profile: types.InstanceProfile{Azure: identity}},
+		{name: "azure name", typ: "azure", profile: types.InstanceProfile{Azure: "runner"}, field: "profile.azure"},
+		{name: "template of the clouds", typ: "google", profile: types.InstanceProfile{AWS: "ci", GCP: "ci@project.iam.gserviceaccount.com", Azure: identity}},
+		{name: "unsupported driver", typ: "nomad", profile: types.InstanceProfile{AWS: "ci"}, field: "profile"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := Instance{Name: "pool", Type: test.typ, Profile: test.profile, Spec: test.spec}
+			v := validator{errs: &ValidationError{Source: "pool file"}}
+			instance.validateProfile(v.at("profile"))
+			errs := v.errs.Errors
+			if test.field == "" && len(errs) > 0 {
+				t.Errorf("unexpected errors %v", errs)
+			}
+			if test.field != "" && (len(errs) != 1 || errs[0].Field != test.field) {
+				t.Errorf("expected an error of %s, got %v", test.field, errs)
+			}
+		})
+	}
+}
+
+func TestInstanceValidate_ConsoleHostKeys(t *testing.T) {
+	tests := []struct {
+		name      string
+		typ       string
+		bootstrap types.Bootstrap
+		invalid   bool
+	}{
+		{name: "ssh", typ: "amazon", bootstrap: types.Bootstrap{Mode: "ssh", ConsoleHostKeys: true}},
+		{name: "user data", typ: "amazon", bootstrap: types.Bootstrap{ConsoleHostKeys: true}, invalid: true},
+		{name: "known hosts", typ: "google", bootstrap: types.Bootstrap{Mode: "ssh", ConsoleHostKeys: true, KnownHostsPath: "/dev/null"}, invalid: true},
+		{name: "no console output", typ: "nomad", bootstrap: types.Bootstrap{Mode: "ssh", ConsoleHostKeys: true}, invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := Instance{Name: "pool", Type: test.typ, Bootstrap: test.bootstrap}
+			v := validator{errs: &ValidationError{Source: "pool file"}}
+			instance.validate(v)
+			invalid := false
+			for _, err := range v.errs.Errors {
+				invalid = invalid || err.Field == "bootstrap.console_host_keys"
+			}
+			if invalid != test.invalid {
+				t.Errorf("expected invalid %v, got %v", test.invalid, v.errs.Errors)
+			}
+		})
+	}
+}
+
+// the fields of the errors are checked in the order they are reported, with a part of their message.
+type wantError struct{ field, msg string }
+
+func checkErrors(t *testing.T, err error, want []wantError) {
+	t.Helper()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	if len(verr.Errors) != len(want) {
+		t.Fatalf("expected %d errors reported at once, got %s", len(want), err)
+	}
+	for i, w := range want {
+		if got := verr.Errors[i]; got.Field != w.field || !strings.Contains(got.Message, w.msg) {
+			t.Errorf("expected %s: %s, got %s: %s", w.field, w.msg, got.Field, got.Message)
+		}
+	}
+}
+
+func TestParse_ValidationErrors(t *testing.T) {
+	file := `
+version: "1"
+instances:
+  - name: ubuntu
+    type: amazon
+    limt: 5
+    platform:
+      os: linux
+      arch: amd64
+    step_timeout_secs: -1
+    timeouts:
+      create_secs: 86400
+    lite_engine:
+      ca_cert_path: /nonexistent/ca.crt
+    spec:
+      account:
+        region: us-east-2
+      ami: ami-051197ce9cbb023ea
+      ami_filter:
+        name: ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-amd64-server-*
+      sise: t3.large
+  - name: nomad
+    type: nomad
+    spec:
+      server:
+        address: http://localhost:4646
+        ca_cert_path: /nonexistent/nomad-ca.crt
+      vm:
+        timeouts:
+          init_secs: -5
+`
+	_, err := Parse(strings.NewReader(file))
+	checkErrors(t, err, []wantError{
+		{"instances[0].limt", `unknown key, did you mean "limit"?`},
+		{"instances[0].spec.sise", `unknown key, did you mean "size"?`},
+		{"instances[0].lite_engine.ca_key_path", "must be set with lite_engine.ca_cert_path"},
+		{"instances[0].lite_engine.ca_cert_path", "cannot read /nonexistent/ca.crt"},
+		{"instances[0].step_timeout_secs", "must not be negative"},
+		{"instances[0].timeouts.create_secs", "must be between 0 and 21600 seconds, got 86400"},
+		{"instances[0].spec.ami_filter", "must not be set with ami"},
+		{"instances[1].spec.server.ca_cert_path", "cannot read /nonexistent/nomad-ca.crt"},
+		{"instances[1].spec.vm.timeouts.init_secs", "must be between 0 and 21600 seconds, got -5"},
+	})
+}
+
+func TestEnvConfigValidate_Errors(t *testing.T) {
+	c := new(EnvConfig)
+	if err := processEnviron(c); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("expected the defaults to be valid, got %s", err)
+	}
+
+	c.LogFormat = "xml"
+	c.Server.Acme = true
+	c.Server.TLSCert = "/nonexistent/cert.pem"
+	c.Settings.BusyMaxAge = 0
+	c.Settings.ZombieProbeSecs = -1
+	checkErrors(t, c.Validate(), []wantError{
+		{"DRONE_LOG_FORMAT", `"xml"`},
+		{"DRONE_HTTP_TLS_KEY", "must be set with DRONE_HTTP_TLS_CERT"},
+		{"DRONE_HTTP_TLS_CERT", "cannot read /nonexistent/cert.pem"},
+		{"DRONE_HTTP_ACME", "must not be set with DRONE_HTTP_TLS_CERT"},
+		{"DRONE_SETTINGS_BUSY_MAX_AGE", "must be positive, got 0"},
+		{"DRONE_SETTINGS_ZOMBIE_PROBE_SECS", "must not be negative"},
+	})
+}
+
+func TestProcessEnviron_Errors(t *testing.T) {
+	t.Setenv("DRONE_SETTINGS_BUSY_MAX_AGE", "1d")
+	t.Setenv("DRONE_SETTINGS_FREE_MAX_AGE", "30d")
+	checkErrors(t, processEnviron(new(EnvConfig)), []wantError{
+		{"DRONE_SETTINGS_BUSY_MAX_AGE", "1d"},
+		{"DRONE_SETTINGS_FREE_MAX_AGE", "30d"},
+	})
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/drone-runners/drone-runner-aws/engine/linter"
 	"github.com/drone-runners/drone-runner-aws/engine/resource"
 	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/internal/logformat"
 	"github.com/drone-runners/drone-runner-aws/internal/match"
 	"github.com/drone-runners/drone-runner-aws/internal/poolfile"
 	"github.com/drone-runners/drone-runner-aws/store/database"
@@ -96,12 +97,14 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		),
 	)
 
-	store, _, err := database.ProvideStore(env.Database.Driver, env.Database.Datasource)
+	store, _, destroyRetryStore, _, _, _, closeStore, err := database.ProvideStore(env.Database.Driver, env.Database.Datasource, database.EnvOptions(&env)...)
 	if err != nil {
 		logrus.WithError(err).Fatalln("Unable to start the database")
 	}
+	defer closeStore()
 
 	poolManager := drivers.New(ctx, store, &env)
+	poolManager.SetDestroyRetryStore(destroyRetryStore)
 
 	logrus.Infoln(fmt.Sprintf("Loading pool file '%s'", c.poolFile))
 	configPool, confErr := poolfile.ConfigPoolFile(c.poolFile, &env)
@@ -133,6 +136,20 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		return err
 	}
 
+	err = poolManager.StartTunnel(ctx, &env)
+	if err != nil {
+		logrus.WithError(err).
+			Errorln("daemon: unable to start the tunnel server")
+		return err
+	}
+
+	err = poolManager.StartKeyServer(ctx, &env)
+	if err != nil {
+		logrus.WithError(err).
+			Errorln("daemon: unable to start the key server")
+		return err
+	}
+
 	busyMaxAge := time.Hour * time.Duration(env.Settings.BusyMaxAge) // includes time required to setup an instance
 	freeMaxAge := time.Hour * time.Duration(env.Settings.FreeMaxAge)
 	err = poolManager.StartInstancePurger(ctx, busyMaxAge, freeMaxAge)
@@ -141,6 +158,7 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 			Errorln("delegate: failed to start instance purger")
 		return err
 	}
+	poolManager.StartDestroyRetrier(ctx)
 
 	opts := engine.Opts{
 		Repopulate: true,
@@ -289,6 +307,12 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		}
 	}
 
+	err = poolManager.Preflight(ctx)
+	if err != nil {
+		logrus.WithError(err).
+			Fatalln("daemon: pool preflight failed")
+	}
+
 	err = poolManager.BuildPools(ctx)
 	if err != nil {
 		logrus.WithError(err).
@@ -320,6 +344,9 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 }
 
 func setupLogger(c *config.EnvConfig) {
+	if c.LogFormat == logformat.FormatJSON {
+		logrus.SetFormatter(logformat.NewJSON())
+	}
 	logger.Default = logger.Logrus(
 		logrus.NewEntry(
 			logrus.StandardLogger(),
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/poolfile"
+	"github.com/drone-runners/drone-runner-aws/store/database"
+
+	"github.com/joho/godotenv"
+	"github.com/syndtr/goleveldb/leveldb"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const doctorTimeout = 30 * time.Minute
+
+type doctorCommand struct {
+	envFile  string
+	poolFile string
+	fix      bool
+	json     bool
+}
+
+// Register registers the commands maintaining the database of a runner.
+func Register(app *kingpin.Application) {
+	cmd := app.Command("db", "maintains the database of a runner")
+
+	c := new(doctorCommand)
+	doctorCmd := cmd.Command("doctor", "checks the migrations, the schema, the indexes, the orphaned rows and the bloat of the database").
+		Action(c.run)
+	doctorCmd.Flag("envfile", "load the environment variable file").
+		StringVar(&c.envFile)
+	doctorCmd.Flag("pool", "the pool file, the instances of the pools not in the file are reported").
+		StringVar(&c.poolFile)
+	doctorCmd.Flag("fix", "repair the fixable findings, with the runner stopped").
+		BoolVar(&c.fix)
+	doctorCmd.Flag("json", "print the findings as json").
+		BoolVar(&c.json)
+}
+
+func (c *doctorCommand) run(*kingpin.ParseContext) error {
+	// load environment variables from file.
+	if err := godotenv.Load(c.envFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	env, err := config.FromEnviron()
+	if err != nil {
+		return err
+	}
+	if env.Database.Driver == database.SingleInstance {
+		return fmt.Errorf("db: the %s driver has no database", database.SingleInstance)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+
+	// the pools are only known from a pool file, the defaults of the environment are not the pools of the runner
+	var pools []string
+	if c.poolFile != "" {
+		configPool, poolErr := poolfile.ConfigPoolFile(c.poolFile, &env)
+		if poolErr != nil {
+			return fmt.Errorf("db: unable to load the pool file: %w", poolErr)
+		}
+		pools = []string{}
+		for i := range configPool.Instances {
+			pools = append(pools, configPool.Instances[i].Name)
+		}
+	}
+
+	findings, closeDB, err := diagnose(ctx, env.Database.Driver, env.Database.Datasource, pools)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+	var fixErr error
+	if c.fix {
+		fixErr = database.Fix(ctx, findings)
+	}
+
+	if c.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(findings); err != nil {
+			return err
+		}
+		return fixErr
+	}
+	if len(findings) == 0 {
+		fmt.Println("no problem found")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint:gomnd
+	fmt.Fprintln(w, "CHECK\tTABLE\tSTATUS\tFINDING")
+	for _, f := range findings {
+		status := "-"
+		switch {
+		case f.Fixed:
+			status = "fixed"
+		case f.Fixable:
+			status = "fixable"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", f.Check, f.Table, status, f.Message)
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	return fixErr
+}
+
+// diagnose opens the database of the driver and checks it, the database is closed by the returned function.
+func diagnose(ctx context.Context, driver, datasource string, pools []string) ([]*database.Finding, func(), error) {
+	if driver == "leveldb" {
+		db, err := leveldb.OpenFile(datasource, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("db: unable to open the database: %w", err)
+		}
+		findings, err := database.DiagnoseLevelDB(ctx, db, pools)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return findings, func() { db.Close() }, nil
+	}
+
+	// the database is not migrated on open, the doctor reports the pending migrations
+	db, err := database.OpenSQL(driver, datasource)
+	if err != nil {
+		return nil, nil, fmt.Errorf("db: unable to open the database: %w", err)
+	}
+	findings, err := database.Diagnose(ctx, db, pools)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return findings, func() { db.Close() }, nil
+}
@@ -132,7 +132,7 @@ func (c *execCommand) run(*kingpin.ParseContext) error { //nolint:gocyclo // its
 		return err
 	}
 	// use a single instance db, as we only need one machine
-	store, _, err := database.ProvideStore(database.SingleInstance, "")
+	store, _, _, _, _, _, _, err := database.ProvideStore(database.SingleInstance, "")
 	if err != nil {
 		logrus.WithError(err).Fatalln("Unable to start the database")
 	}
@@ -0,0 +1,170 @@
+package harness
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/objectstore"
+	ierrors "github.com/drone-runners/drone-runner-aws/internal/types"
+	"github.com/drone-runners/drone-runner-aws/store"
+)
+
+// The environment variables of the steps requesting the uploads of their artifacts.
+const (
+	artifactsEndpointVar = "HARNESS_ARTIFACTS_ENDPOINT"
+	artifactsTokenVar    = "HARNESS_ARTIFACTS_TOKEN"
+)
+
+// ArtifactsRequest asks for the uploads of artifacts of a stage, it is sent by the steps of the
+// stage with the token of the stage.
+type ArtifactsRequest struct {
+	Token string   `json:"token"`
+	Names []string `json:"names"` // the paths of the artifacts, relative to the directory of the stage
+}
+
+// ArtifactUpload is the signed request uploading an artifact, its body is the artifact.
+type ArtifactUpload struct {
+	Name   string `json:"name"`
+	Method string `json:"method"`
+	objectstore.Request
+}
+
+type ArtifactsResponse struct {
+	Uploads   []ArtifactUpload `json:"uploads"`
+	ExpiresAt int64            `json:"expires_at"` // the unix time the urls expire at
+}
+
+var (
+	artifactsBackend     objectstore.Backend
+	artifactsBackendErr  error
+	artifactsBackendOnce sync.Once
+)
+
+func runnerArtifactsBackend(ctx context.Context, env *config.EnvConfig) (objectstore.Backend, error) {
+	artifactsBackendOnce.Do(func() {
+		artifactsBackend, artifactsBackendErr = objectstore.NewBackend(ctx, &objectstore.Config{
+			Type:              env.Artifacts.Type,
+			Bucket:            env.Artifacts.Bucket,
+			Region:            env.Artifacts.Region,
+			GCSServiceAccount: env.Artifacts.GCSServiceAccount,
+			AzureAccount:      env.Artifacts.AzureAccount,
+			AzureKey:          env.Artifacts.AzureKey,
+		})
+	})
+	return artifactsBackend, artifactsBackendErr
+}
+
+// tokenSecret returns the secret signing the tokens of the stages.
+func tokenSecret(env *config.EnvConfig) []byte {
+	return []byte(env.Artifacts.Secret)
+}
+
+// artifactsToken returns the token the steps of the stage request the uploads of their artifacts
+// with, valid until the expiry.
+func artifactsToken(env *config.EnvConfig, stageRuntimeID, accountID string, expiry time.Time) string {
+	payload := strings.Join([]string{stageRuntimeID, accountID, strconv.FormatInt(expiry.Unix(), 10)}, "\n")
+	mac := hmac.New(sha256.New, tokenSecret(env))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseArtifactsToken returns the stage and the account of a valid token.
+func parseArtifactsToken(env *config.EnvConfig, token string, now time.Time) (stageRuntimeID, accountID string, err error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", "", fmt.Errorf("malformed token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", fmt.Errorf("malformed token")
+	}
+	mac := hmac.New(sha256.New, tokenSecret(env))
+	mac.Write(payload)
+	if got, decodeErr := base64.RawURLEncoding.DecodeString(sig); decodeErr != nil || !hmac.Equal(got, mac.Sum(nil)) {
+		return "", "", fmt.Errorf("invalid token signature")
+	}
+	fields := strings.Split(string(payload), "\n")
+	if len(fields) != 3 {
+		return "", "", fmt.Errorf("malformed token")
+	}
+	expiry, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || now.Unix() > expiry {
+		return "", "", fmt.Errorf("the token expired")
+	}
+	return fields[0], fields[1], nil
+}
+
+// setArtifactsEnvs passes the endpoint of the runner and the token of the stage to the step, if
+// the artifacts are enabled.
+func setArtifactsEnvs(r *ExecuteVMRequest, env *config.EnvConfig) {
+	if env.Artifacts.Type == "" {
+		return
+	}
+	if r.StartStepRequest.Envs == nil {
+		r.StartStepRequest.Envs = make(map[string]string)
+	}
+	expiry := time.Now().Add(time.Duration(env.Artifacts.TokenTTLMins) * time.Minute)
+	r.StartStepRequest.Envs[artifactsEndpointVar] = strings.TrimSuffix(env.Artifacts.Endpoint, "/") + "/artifacts"
+	r.StartStepRequest.Envs[artifactsTokenVar] = artifactsToken(env, r.StageRuntimeID, r.AccountID, expiry)
+}
+
+// artifactName checks the name of an artifact and returns it cleaned, the artifacts of a stage
+// can't be uploaded out of the directory of the stage.
+func artifactName(name string) (string, error) {
+	cleaned := path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	if name == "" || path.IsAbs(cleaned) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("invalid artifact name %q", name)
+	}
+	return cleaned, nil
+}
+
+// HandleArtifacts returns the signed uploads of the artifacts of the stage of the token, stored
+// under the directory of the account and of the stage. The stage must not be destroyed yet.
+func HandleArtifacts(ctx context.Context, r *ArtifactsRequest, s store.StageOwnerStore, env *config.EnvConfig) (*ArtifactsResponse, error) {
+	if env.Artifacts.Type == "" {
+		return nil, ierrors.NewNotFoundError("the artifacts are not enabled on the runner")
+	}
+	stageRuntimeID, accountID, err := parseArtifactsToken(env, r.Token, time.Now())
+	if err != nil {
+		return nil, ierrors.NewUnauthorizedError(err.Error())
+	}
+	if len(r.Names) == 0 {
+		return nil, ierrors.NewBadRequestError("mandatory field 'names' in the request body is empty")
+	}
+	if entity, findErr := s.Find(ctx, stageRuntimeID); findErr != nil || entity == nil {
+		return nil, ierrors.NewUnauthorizedError(fmt.Sprintf("the stage %s is not running", stageRuntimeID))
+	}
+	backend, err := runnerArtifactsBackend(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := stageRuntimeID
+	if accountID != "" {
+		dir = path.Join(accountID, stageRuntimeID)
+	}
+	dir = path.Join(strings.Trim(env.Artifacts.Prefix, "/"), dir)
+	ttl := time.Duration(env.Artifacts.URLTTLSecs) * time.Second
+	resp := &ArtifactsResponse{ExpiresAt: time.Now().Add(ttl).Unix()}
+	for _, name := range r.Names {
+		cleaned, nameErr := artifactName(name)
+		if nameErr != nil {
+			return nil, ierrors.NewBadRequestError(nameErr.Error())
+		}
+		req, signErr := backend.SignPut(ctx, path.Join(dir, cleaned), ttl)
+		if signErr != nil {
+			return nil, fmt.Errorf("could not sign the upload of the artifact %s: %w", name, signErr)
+		}
+		resp.Uploads = append(resp.Uploads, ArtifactUpload{Name: cleaned, Method: "PUT", Request: *req})
+	}
+	return resp, nil
+}
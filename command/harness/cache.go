@@ -0,0 +1,261 @@
+package harness
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/cache"
+	"github.com/drone-runners/drone-runner-aws/internal/lehelper"
+	"github.com/drone-runners/drone-runner-aws/internal/objectstore"
+	"github.com/drone-runners/drone-runner-aws/internal/oshelp"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/harness/lite-engine/api"
+	lehttp "github.com/harness/lite-engine/cli/client"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CacheRequest is the cache of a stage. The entry of the first of the keys matching one is
+// restored at the setup, see cache.Cache.Restore, and the paths are saved as the entry of the key
+// at the destroy unless the entry of the key was restored.
+type CacheRequest struct {
+	Key         string   `json:"key"`
+	RestoreKeys []string `json:"restore_keys,omitempty"`
+	Paths       []string `json:"paths"` // absolute paths of the instance
+}
+
+// cacheTimeout bounds the download or the upload of an entry with its extraction or its archive.
+var cacheTimeout = 15 * time.Minute
+
+// The entries are gzipped tarballs of the paths relative to the root, the paths which don't exist
+// are skipped. The headers of the upload are lines of CACHE_HEADERS.
+const (
+	restoreCacheScript = `set -e
+archive=$(mktemp)
+trap 'rm -f "$archive"' EXIT
+curl -fsSL --retry 3 -o "$archive" "$CACHE_URL"
+tar -xzf "$archive" -C /`
+
+	saveCacheScript = `set -e
+archive=$(mktemp)
+list=$(mktemp)
+trap 'rm -f "$archive" "$list"' EXIT
+printf '%s\n' "$CACHE_PATHS" | while IFS= read -r p; do
+  if [ -n "$p" ] && [ -e "$p" ]; then printf '%s\n' "${p#/}"; fi
+done > "$list"
+if [ ! -s "$list" ]; then
+  echo "none of the cache paths exist" >&2
+  exit 2
+fi
+tar -czf "$archive" -C / -T "$list"
+set --
+while IFS= read -r h; do
+  if [ -n "$h" ]; then set -- "$@" -H "$h"; fi
+done <<EOF
+$CACHE_HEADERS
+EOF
+curl -fsS --retry 3 -X PUT "$@" --upload-file "$archive" "$CACHE_URL"`
+)
+
+var (
+	runnerCacheInst *cache.Cache
+	runnerCacheErr  error
+	runnerCacheOnce sync.Once
+)
+
+// runnerCache returns the cache configured on the runner, nil if none. It is created once.
+func runnerCache(ctx context.Context, env *config.EnvConfig) (*cache.Cache, error) {
+	if env.Cache.Type == "" {
+		return nil, nil
+	}
+	runnerCacheOnce.Do(func() {
+		runnerCacheInst, runnerCacheErr = cache.New(ctx, &cache.Config{
+			Store: objectstore.Config{
+				Type:              env.Cache.Type,
+				Bucket:            env.Cache.Bucket,
+				Region:            env.Cache.Region,
+				GCSServiceAccount: env.Cache.GCSServiceAccount,
+				AzureAccount:      env.Cache.AzureAccount,
+				AzureKey:          env.Cache.AzureKey,
+			},
+			Prefix:  env.Cache.Prefix,
+			MaxSize: env.Cache.MaxSizeMB << 20,
+		})
+	})
+	return runnerCacheInst, runnerCacheErr
+}
+
+func cacheURLTTL(env *config.EnvConfig) time.Duration {
+	return time.Duration(env.Cache.URLTTLSecs) * time.Second
+}
+
+// validateCacheRequest checks the cache of a setup request.
+func validateCacheRequest(r *CacheRequest) error {
+	if r.Key == "" {
+		return fmt.Errorf("the key of the cache is not set")
+	}
+	if len(r.Paths) == 0 {
+		return fmt.Errorf("the cache has no paths")
+	}
+	for _, p := range r.Paths {
+		if !path.IsAbs(p) {
+			return fmt.Errorf("the cache path %q is not absolute", p)
+		}
+	}
+	return nil
+}
+
+// restoreCache restores the entry of the first key of the cache matching one on the instance, it
+// returns the key of the entry restored, empty if none matches.
+func restoreCache(ctx context.Context, client lehttp.Client, c *cache.Cache, r *CacheRequest, instance *types.Instance, env *config.EnvConfig) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, cacheTimeout)
+	defer cancel()
+
+	keys := append([]string{r.Key}, r.RestoreKeys...)
+	req, key, err := c.Restore(ctx, keys, cacheURLTTL(env))
+	if stderrors.Is(err, cache.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if err = runCacheStep(ctx, client, "cache-restore-"+instance.ID, restoreCacheScript, map[string]string{
+		"CACHE_URL": req.URL,
+	}); err != nil {
+		return "", fmt.Errorf("could not restore the cache %s: %w", key, err)
+	}
+	return key, nil
+}
+
+// saveCache saves the paths of the cache on the instance as the entry of its key, the oldest
+// entries are evicted from the background once the entry is saved.
+func saveCache(ctx context.Context, client lehttp.Client, c *cache.Cache, r *CacheRequest, instance *types.Instance, env *config.EnvConfig) error {
+	ctx, cancel := context.WithTimeout(ctx, cacheTimeout)
+	defer cancel()
+
+	req, unlock, err := c.Save(ctx, r.Key, cacheURLTTL(env))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	headers := make([]string, 0, len(req.Headers))
+	for k, v := range req.Headers {
+		headers = append(headers, k+": "+v)
+	}
+	sort.Strings(headers)
+	if err = runCacheStep(ctx, client, "cache-save-"+instance.ID, saveCacheScript, map[string]string{
+		"CACHE_URL":     req.URL,
+		"CACHE_PATHS":   strings.Join(r.Paths, "\n"),
+		"CACHE_HEADERS": strings.Join(headers, "\n"),
+	}); err != nil {
+		return fmt.Errorf("could not save the cache %s: %w", r.Key, err)
+	}
+
+	go func() {
+		evictCtx, evictCancel := context.WithTimeout(context.Background(), cacheTimeout)
+		defer evictCancel()
+		if n, evictErr := c.Evict(evictCtx); evictErr != nil {
+			logrus.WithError(evictErr).Warnln("cache: failed to evict the oldest entries")
+		} else if n > 0 {
+			logrus.WithField("evicted", n).Infoln("cache: evicted the oldest entries")
+		}
+	}()
+	return nil
+}
+
+// runCacheStep runs the script of a cache step on the instance and waits until it exits.
+func runCacheStep(ctx context.Context, client lehttp.Client, id, script string, envs map[string]string) error {
+	req := &api.StartStepRequest{
+		ID:   id,
+		Name: "cache",
+		Kind: api.Run,
+		Envs: envs,
+	}
+	req.Run.Entrypoint, req.Run.Command = []string{"sh", "-c"}, []string{script}
+	if _, err := client.StartStep(ctx, req); err != nil {
+		return err
+	}
+	resp, err := client.RetryPollStep(ctx, &api.PollStepRequest{ID: req.ID}, cacheTimeout)
+	if err != nil {
+		return err
+	}
+	if resp.Error != "" || resp.ExitCode != 0 {
+		return fmt.Errorf("the cache step failed with exit code %d: %s", resp.ExitCode, resp.Error)
+	}
+	return nil
+}
+
+// cacheSupported reports whether the caches can be restored on and saved from the instance, the
+// cache steps are shell scripts.
+func cacheSupported(instance *types.Instance) bool {
+	return instance.Platform.OS != oshelp.OSWindows
+}
+
+var (
+	caches     *CacheState
+	cachesOnce sync.Once
+)
+
+// CacheState keeps the caches of the stages set up by the runner to save at their destroy.
+type CacheState struct {
+	mu     sync.Mutex
+	stages map[string]*stageCache
+}
+
+type stageCache struct {
+	request *CacheRequest
+	cache   *cache.Cache
+	env     *config.EnvConfig
+}
+
+func cacheState() *CacheState {
+	cachesOnce.Do(func() {
+		caches = &CacheState{stages: map[string]*stageCache{}}
+	})
+	return caches
+}
+
+// Add registers the cache of the stage to save at its destroy.
+func (s *CacheState) Add(stageRuntimeID string, r *CacheRequest, c *cache.Cache, env *config.EnvConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stages[stageRuntimeID] = &stageCache{request: r, cache: c, env: env}
+}
+
+// Save saves the cache of the stage from its instance, once: the retries of the destroy don't
+// save it again. The stages without a cache to save are skipped.
+func (s *CacheState) Save(ctx context.Context, stageRuntimeID string, inst *types.Instance) {
+	s.mu.Lock()
+	sc := s.stages[stageRuntimeID]
+	delete(s.stages, stageRuntimeID)
+	s.mu.Unlock()
+	if sc == nil {
+		return
+	}
+
+	logr := logrus.WithField("stage_runtime_id", stageRuntimeID).
+		WithField("instance_id", inst.ID).
+		WithField("cache_key", sc.request.Key)
+	client, err := lehelper.GetClient(inst, sc.env.Runner.Name, inst.Port, sc.env.LiteEngine.EnableMock, sc.env.LiteEngine.MockStepTimeoutSecs)
+	if err != nil {
+		logr.WithError(err).Warnln("cache: failed to create the lite-engine client, the cache is not saved")
+		return
+	}
+	start := time.Now()
+	switch err = saveCache(ctx, client, sc.cache, sc.request, inst, sc.env); {
+	case stderrors.Is(err, cache.ErrExists), stderrors.Is(err, cache.ErrLocked):
+		logr.WithError(err).Debugln("cache: skipped the save of the cache")
+	case err != nil:
+		logr.WithError(err).Warnln("cache: failed to save the cache")
+	default:
+		logr.WithField("duration", time.Since(start)).Infoln("cache: saved the cache")
+	}
+}
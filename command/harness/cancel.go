@@ -0,0 +1,36 @@
+package harness
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	ierrors "github.com/drone-runners/drone-runner-aws/internal/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+type CancelSetupRequest struct {
+	StageRuntimeID string `json:"id"`
+}
+
+type CancelSetupResponse struct {
+	StageRuntimeID string `json:"id"`
+	Cancelled      bool   `json:"cancelled"`
+}
+
+// HandleCancelSetup aborts the setup of a stage in progress on the runner, e.g. once the pipeline
+// is cancelled: the instance is destroyed, even if it is still booting, and the stage owner is
+// removed. The setups of the stage on the other runners are not cancelled.
+func HandleCancelSetup(_ context.Context, r *CancelSetupRequest, poolManager *drivers.Manager) (*CancelSetupResponse, error) {
+	if r.StageRuntimeID == "" {
+		return nil, ierrors.NewBadRequestError("mandatory field 'id' in the request body is empty")
+	}
+	if !poolManager.CancelSetup(r.StageRuntimeID) {
+		return nil, ierrors.NewNotFoundError(fmt.Sprintf("no setup in progress for stage: %s", r.StageRuntimeID))
+	}
+	logrus.WithField("stage_runtime_id", r.StageRuntimeID).
+		WithField("api", "dlite:cancel_setup").
+		Infoln("cancelled the setup of the stage")
+	return &CancelSetupResponse{StageRuntimeID: r.StageRuntimeID, Cancelled: true}, nil
+}
@@ -0,0 +1,145 @@
+package harness
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/internal/oidc"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// defaultCredentialsTTL is the lifetime of the credentials of the pools without one.
+const defaultCredentialsTTL = time.Hour
+
+var (
+	issuer     *oidc.Issuer
+	issuerErr  error
+	issuerOnce sync.Once
+)
+
+// OIDCIssuer returns the issuer of the identity tokens of the stages, nil if the runner issues
+// none. It is created once.
+func OIDCIssuer(env *config.EnvConfig) (*oidc.Issuer, error) {
+	if env.OIDC.Issuer == "" {
+		return nil, nil
+	}
+	issuerOnce.Do(func() {
+		issuer, issuerErr = oidc.NewIssuer(env.OIDC.Issuer, env.OIDC.PrivateKeyPath)
+	})
+	return issuer, issuerErr
+}
+
+// setCredentialsEnvs passes the cloud credentials of the pool to the step, exchanged for identity
+// tokens of the stage. The credentials are minted for every step, they expire with the ttl of
+// the pool.
+func setCredentialsEnvs(ctx context.Context, r *ExecuteVMRequest, pool string, env *config.EnvConfig, poolManager *drivers.Manager) error {
+	creds := poolManager.Credentials(pool)
+	if !creds.IsSet() {
+		return nil
+	}
+	iss, err := OIDCIssuer(env)
+	if err != nil {
+		return err
+	}
+	if iss == nil {
+		return fmt.Errorf("the pool %s passes cloud credentials to the steps but DRONE_OIDC_ISSUER is not set", pool)
+	}
+	ttl := time.Duration(creds.TTLMins) * time.Minute
+	if ttl <= 0 {
+		ttl = defaultCredentialsTTL
+	}
+	id := oidc.Identity{AccountID: r.AccountID, Pool: pool, StageID: r.StageRuntimeID}
+	envs, err := credentialsEnvs(ctx, iss, id, &creds, ttl)
+	if err != nil {
+		return err
+	}
+	if r.StartStepRequest.Envs == nil {
+		r.StartStepRequest.Envs = make(map[string]string)
+	}
+	for k, v := range envs {
+		r.StartStepRequest.Envs[k] = v
+	}
+	return nil
+}
+
+// credentialsEnvs returns the environment variables of the credentials, the ones the command line
+// tools and the SDKs of the clouds read.
+func credentialsEnvs(ctx context.Context, iss *oidc.Issuer, id oidc.Identity, creds *types.PoolCredentials, ttl time.Duration) (map[string]string, error) {
+	envs := map[string]string{}
+	sign := func(audience, fallback string) (string, error) {
+		if audience == "" {
+			audience = fallback
+		}
+		token, _, err := iss.Sign(id, audience, creds.Claims, ttl)
+		return token, err
+	}
+
+	if creds.Audience != "" {
+		token, err := sign(creds.Audience, "")
+		if err != nil {
+			return nil, err
+		}
+		envs["HARNESS_OIDC_TOKEN"] = token
+	}
+	if c := creds.AWS; c != nil {
+		token, err := sign(c.Audience, oidc.AudienceAWS)
+		if err != nil {
+			return nil, err
+		}
+		aws, err := oidc.AssumeAWSRole(ctx, token, c.RoleARN, sessionName(id.StageID), ttl)
+		if err != nil {
+			return nil, err
+		}
+		envs["AWS_ACCESS_KEY_ID"] = aws.AccessKeyID
+		envs["AWS_SECRET_ACCESS_KEY"] = aws.SecretAccessKey
+		envs["AWS_SESSION_TOKEN"] = aws.SessionToken
+		if c.Region != "" {
+			envs["AWS_REGION"] = c.Region
+			envs["AWS_DEFAULT_REGION"] = c.Region
+		}
+	}
+	if c := creds.GCP; c != nil {
+		token, err := sign(c.Audience, "https:"+c.Provider)
+		if err != nil {
+			return nil, err
+		}
+		accessToken, _, err := oidc.ExchangeGCP(ctx, token, c.Provider, c.ServiceAccount, ttl)
+		if err != nil {
+			return nil, err
+		}
+		envs["CLOUDSDK_AUTH_ACCESS_TOKEN"] = accessToken
+		envs["GOOGLE_OAUTH_ACCESS_TOKEN"] = accessToken
+	}
+	if c := creds.Azure; c != nil {
+		token, err := sign(c.Audience, oidc.AudienceAzure)
+		if err != nil {
+			return nil, err
+		}
+		envs["AZURE_TENANT_ID"] = c.TenantID
+		envs["AZURE_CLIENT_ID"] = c.ClientID
+		envs["AZURE_FEDERATED_TOKEN"] = token
+	}
+	return envs, nil
+}
+
+// sessionName returns the name of the aws role session of the stage, at most 64 characters of
+// the ones aws allows.
+func sessionName(stageID string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune("_+=,.@-", r):
+			return r
+		default:
+			return '-'
+		}
+	}, "stage-"+stageID)
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
@@ -0,0 +1,256 @@
+package delegate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/internal/fips"
+	"github.com/drone-runners/drone-runner-aws/internal/httprender"
+	"github.com/drone-runners/drone-runner-aws/internal/logformat"
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	readinessTimeout  = 10 * time.Second
+	readHeaderTimeout = 30 * time.Second
+)
+
+// adminListener serves the endpoints of the probes and of the monitoring, apart from the delegate
+// API so they can be exposed to the cluster only.
+func (c *delegateCommand) adminListener() http.Handler {
+	mux := chi.NewMux()
+	c.adminRoutes(mux)
+	return mux
+}
+
+func (c *delegateCommand) adminRoutes(mux chi.Router) {
+	mux.Get("/healthz", c.handleHealthz)
+	mux.Get("/healthz/pools", c.handlePoolsHealth)
+	mux.Get("/readyz", c.handleReadyz)
+	mux.Get("/metrics", c.handleMetrics)
+	mux.Get("/analytics/sizing", c.handleSizing)
+	mux.Get("/loglevel", c.handleGetLogLevel)
+	mux.Put("/loglevel", c.handleSetLogLevel)
+}
+
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+// handleGetLogLevel returns the level of the logs of the runner.
+func (c *delegateCommand) handleGetLogLevel(w http.ResponseWriter, _ *http.Request) {
+	httprender.OK(w, logLevel{Level: logrus.GetLevel().String()})
+}
+
+// handleSetLogLevel changes the level of the logs of the runner until it restarts, e.g. to debug
+// an incident without a redeploy. The stage logs are not affected.
+func (c *delegateCommand) handleSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	req := &logLevel{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		httprender.BadRequest(w, "failed to decode the request body", nil)
+		return
+	}
+	level, err := logformat.ParseLevel(req.Level)
+	if err != nil {
+		httprender.BadRequest(w, err.Error(), nil)
+		return
+	}
+	previous := logrus.GetLevel()
+	logrus.SetLevel(level)
+	logrus.WithField("previous", previous.String()).
+		WithField("remote", r.RemoteAddr).
+		Warnf("delegate: the log level is set to %s", level)
+	httprender.OK(w, logLevel{Level: level.String()})
+}
+
+// handleHealthz reports that the delegate is alive, it does not check its dependencies so an
+// outage of the database or of a cloud provider does not get the delegate restarted.
+func (c *delegateCommand) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	io.WriteString(w, "OK") //nolint: errcheck
+}
+
+type poolsHealth struct {
+	Status string               `json:"status"`
+	Warm   bool                 `json:"warm"`
+	Pools  []drivers.PoolHealth `json:"pools"`
+}
+
+// handlePoolsHealth reports whether the drivers of the pools can reach their providers, the
+// degraded pools are reported individually, along with the warm-up of the pools. The status is 200
+// while the runner is up, unless the pool query parameter selects a pool, then it is 503 if the
+// pool is degraded so a load balancer can probe the pool it routes to.
+func (c *delegateCommand) handlePoolsHealth(w http.ResponseWriter, r *http.Request) {
+	pools := c.poolManager.PoolsHealth(r.Context())
+	if name := r.URL.Query().Get("pool"); name != "" {
+		for i := range pools {
+			if pools[i].Name != name {
+				continue
+			}
+			status := http.StatusOK
+			if pools[i].Status != drivers.HealthOK {
+				status = http.StatusServiceUnavailable
+			}
+			httprender.JSON(w, pools[i], status)
+			return
+		}
+		httprender.NotFound(w, fmt.Sprintf("pool %q not found", name), nil)
+		return
+	}
+
+	resp := poolsHealth{Status: drivers.HealthOK, Warm: true, Pools: pools}
+	for i := range pools {
+		if pools[i].WarmUp == nil || !pools[i].WarmUp.Warm {
+			resp.Warm = false
+		}
+		if pools[i].Status != drivers.HealthOK {
+			resp.Status = drivers.HealthDegraded
+			logrus.WithField("pool", pools[i].Name).WithField("error", pools[i].Error).Debugln("delegate: the pool is degraded")
+		}
+	}
+	httprender.OK(w, resp)
+}
+
+// handleReadyz reports whether the delegate can set up stages, that is whether the database and
+// the drivers of the pools are reachable, and whether the pools are warm if the readiness waits
+// for them.
+func (c *delegateCommand) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	if err := c.poolManager.Ready(ctx); err != nil {
+		logrus.WithError(err).Warnln("delegate: not ready")
+		httprender.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	io.WriteString(w, "OK") //nolint: errcheck
+}
+
+// handleSizing returns the right-sizing recommendations of the pools from the peak utilization of
+// their instances, by default over the last week.
+func (c *delegateCommand) handleSizing(w http.ResponseWriter, r *http.Request) {
+	type sizingResponse struct {
+		Recommendations []drivers.SizingRecommendation `json:"recommendations"`
+	}
+
+	query := r.URL.Query()
+	since := 7 * 24 * time.Hour //nolint:gomnd
+	if v := query.Get("since"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			httprender.BadRequest(w, "invalid duration in the URL parameter 'since'", nil)
+			return
+		}
+		since = parsed
+	}
+
+	recommendations, err := c.poolManager.Sizing(r.Context(), query.Get("pool"), time.Now().Add(-since))
+	if err != nil {
+		logrus.WithError(err).Error("could not get the sizing recommendations of the pools")
+		writeError(w, err)
+		return
+	}
+	httprender.OK(w, sizingResponse{Recommendations: recommendations})
+}
+
+// handleMetrics writes the statistics of the pools in the Prometheus text format.
+func (c *delegateCommand) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	pools, err := c.poolManager.PoolsStatus(r.Context())
+	if err != nil {
+		logrus.WithError(err).Error("could not get the status of the pools")
+		httprender.InternalError(w, "could not get the status of the pools", err, nil)
+		return
+	}
+
+	var b strings.Builder
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	metric("drone_runner_pool_instances", "gauge", "Number of the instances of the pool by state.")
+	for i := range pools {
+		p := &pools[i]
+		fmt.Fprintf(&b, "drone_runner_pool_instances{pool=%s,state=\"free\"} %d\n", labelValue(p.Name), p.Free)
+		fmt.Fprintf(&b, "drone_runner_pool_instances{pool=%s,state=\"busy\"} %d\n", labelValue(p.Name), p.Busy)
+		fmt.Fprintf(&b, "drone_runner_pool_instances{pool=%s,state=\"hibernating\"} %d\n", labelValue(p.Name), p.Hibernating)
+	}
+	metric("drone_runner_pool_max_size", "gauge", "Maximum number of the instances of the pool.")
+	for i := range pools {
+		fmt.Fprintf(&b, "drone_runner_pool_max_size{pool=%s} %d\n", labelValue(pools[i].Name), pools[i].MaxSize)
+	}
+	metric("drone_runner_pool_overflow_instances", "gauge", "Number of the busy instances of the pool created over its max size.")
+	for i := range pools {
+		fmt.Fprintf(&b, "drone_runner_pool_overflow_instances{pool=%s} %d\n", labelValue(pools[i].Name), pools[i].Overflow)
+	}
+	metric("drone_runner_pool_queue_depth", "gauge", "Number of the stages waiting for an instance of the pool.")
+	for i := range pools {
+		fmt.Fprintf(&b, "drone_runner_pool_queue_depth{pool=%s} %d\n", labelValue(pools[i].Name), pools[i].QueueDepth)
+	}
+	metric("drone_runner_pool_create_failure_ratio", "gauge", "Ratio of the failed instance creations of the pool in the recent window.")
+	for i := range pools {
+		fmt.Fprintf(&b, "drone_runner_pool_create_failure_ratio{pool=%s} %g\n", labelValue(pools[i].Name), pools[i].FailureRate)
+	}
+	metric("drone_runner_pool_disk_warnings_total", "counter", "Number of the disk usage warnings of the stages of the pool.")
+	for i := range pools {
+		fmt.Fprintf(&b, "drone_runner_pool_disk_warnings_total{pool=%s} %d\n", labelValue(pools[i].Name), pools[i].DiskWarnings)
+	}
+	metric("drone_runner_setup_phase_duration_seconds", "histogram", "Duration of the phases of the setup of the stages of the pool.")
+	for i := range pools {
+		for j := range pools[i].Phases {
+			h := &pools[i].Phases[j]
+			labels := fmt.Sprintf("pool=%s,phase=%s", labelValue(pools[i].Name), labelValue(h.Phase))
+			for k, bound := range drivers.PhaseBuckets {
+				fmt.Fprintf(&b, "drone_runner_setup_phase_duration_seconds_bucket{%s,le=\"%g\"} %d\n", labels, bound, h.Buckets[k])
+			}
+			fmt.Fprintf(&b, "drone_runner_setup_phase_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.Count)
+			fmt.Fprintf(&b, "drone_runner_setup_phase_duration_seconds_sum{%s} %g\n", labels, h.SumSeconds)
+			fmt.Fprintf(&b, "drone_runner_setup_phase_duration_seconds_count{%s} %d\n", labels, h.Count)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	io.WriteString(w, b.String()) //nolint: errcheck
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue quotes a label value of the Prometheus text format.
+func labelValue(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+// serve serves the handler on the address until the context is done, over TLS if the certificate
+// is set.
+func serve(ctx context.Context, addr, cert, key string, handler http.Handler) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		TLSConfig:         fips.Apply(nil),
+	}
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		<-gctx.Done()
+		return srv.Shutdown(context.Background())
+	})
+	g.Go(func() error {
+		var err error
+		if cert != "" {
+			err = srv.ListenAndServeTLS(cert, key)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	})
+	return g.Wait()
+}
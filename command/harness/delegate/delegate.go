@@ -4,17 +4,21 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/drone-runners/drone-runner-aws/command/config"
 	"github.com/drone-runners/drone-runner-aws/command/harness"
 	"github.com/drone-runners/drone-runner-aws/engine/resource"
 	"github.com/drone-runners/drone-runner-aws/internal/drivers"
 	"github.com/drone-runners/drone-runner-aws/internal/httprender"
+	"github.com/drone-runners/drone-runner-aws/internal/oidc"
 	errors "github.com/drone-runners/drone-runner-aws/internal/types"
 	"github.com/drone-runners/drone-runner-aws/store"
 	"github.com/drone-runners/drone-runner-aws/store/database"
+	"github.com/drone-runners/drone-runner-aws/types"
 	loghistory "github.com/drone/runner-go/logger/history"
-	"github.com/drone/runner-go/server"
 	"github.com/drone/signal"
 	"github.com/go-chi/chi/v5"
 	"github.com/joho/godotenv"
@@ -37,10 +41,28 @@ func (c *delegateCommand) delegateListener() http.Handler {
 
 	mux.Use(harness.Middleware)
 
+	mux.Get("/pools", c.handlePools)
+	mux.Delete("/pools/{id}", c.handleDeletePool)
+	mux.Get("/capacity", c.handleCapacity)
+	mux.Get("/capabilities", c.handleCapabilities)
 	mux.Post("/pool_owner", c.handlePoolOwner)
 	mux.Post("/setup", c.handleSetup)
+	mux.Post("/cancel_setup", c.handleCancelSetup)
 	mux.Post("/destroy", c.handleDestroy)
+	mux.Get("/destroy_status", c.handleDestroyStatus)
 	mux.Post("/step", c.handleStep)
+	mux.Post("/artifacts", c.handleArtifacts)
+	mux.Get(oidc.DiscoveryPath, c.handleOIDCDiscovery)
+	mux.Get(oidc.JWKSPath, c.handleOIDCKeys)
+	mux.Post("/extend_lease", c.handleExtendLease)
+	mux.Get("/analytics/boot_times", c.handleBootTimes)
+	mux.Get("/analytics/usage", c.handleUsage)
+	mux.Get("/pool_mappings", c.handlePoolMappings)
+	mux.Put("/pool_mappings", c.handlePutPoolMapping)
+	mux.Delete("/pool_mappings", c.handleDeletePoolMapping)
+	if c.env.Admin.Port == "" {
+		c.adminRoutes(mux)
+	}
 
 	return mux
 }
@@ -85,30 +107,44 @@ func (c *delegateCommand) run(*kingpin.ParseContext) error {
 		cancel()
 	})
 
-	instanceStore, stageOwnerStore, err := database.ProvideStore(c.env.Database.Driver, c.env.Database.Datasource)
+	instanceStore, stageOwnerStore, destroyRetryStore, stageRecordStore, poolMappingStore, _, closeStore, err := database.ProvideStore(c.env.Database.Driver, c.env.Database.Datasource, database.EnvOptions(&c.env)...)
 	if err != nil {
 		logrus.WithError(err).Fatalln("Unable to start the database")
 	}
+	defer closeStore()
 
 	c.stageOwnerStore = stageOwnerStore
 	c.poolManager = drivers.New(ctx, instanceStore, &c.env)
+	c.poolManager.SetDestroyRetryStore(destroyRetryStore)
+	c.poolManager.SetStageRecordStore(stageRecordStore)
+	c.poolManager.SetPoolMappingStore(poolMappingStore)
 
 	_, err = harness.SetupPool(ctx, &c.env, c.poolManager, c.poolFile)
 	defer harness.Cleanup(&c.env, c.poolManager) //nolint: errcheck
 	if err != nil {
 		return err
 	}
+	if err = harness.ResumeDestroys(ctx, stageOwnerStore, c.poolManager); err != nil {
+		logrus.WithError(err).Errorln("could not resume the destroy of the terminating instances")
+	}
 
 	hook := loghistory.New()
 	logrus.AddHook(hook)
 
 	var g errgroup.Group
-	runnerServer := server.Server{
-		Addr:    c.env.Server.Port,
-		Handler: c.delegateListener(),
+	apiHandler := c.delegateListener()
+	if c.env.Admin.Port == "" {
+		logrus.Warnln("delegate: no admin listener, the health, readiness and metrics endpoints are served by the delegate API")
+	} else {
+		logrus.WithField("addr", c.env.Admin.Port).
+			Infoln("starting the admin server")
+
+		g.Go(func() error {
+			return serve(ctx, c.env.Admin.Port, c.env.Admin.TLSCert, c.env.Admin.TLSKey, c.adminListener())
+		})
 	}
 
-	logrus.WithField("addr", runnerServer.Addr).
+	logrus.WithField("addr", c.env.Server.Port).
 		WithField("kind", resource.Kind).
 		WithField("type", resource.Type).
 		Infoln("starting the server")
@@ -119,7 +155,7 @@ func (c *delegateCommand) run(*kingpin.ParseContext) error {
 	})
 
 	g.Go(func() error {
-		return runnerServer.ListenAndServe(ctx)
+		return serve(ctx, c.env.Server.Port, c.env.Server.TLSCert, c.env.Server.TLSKey, apiHandler)
 	})
 
 	waitErr := g.Wait()
@@ -165,6 +201,192 @@ func (c *delegateCommand) handlePoolOwner(w http.ResponseWriter, r *http.Request
 	httprender.OK(w, poolOwnerResponse{Owner: true})
 }
 
+func (c *delegateCommand) handlePools(w http.ResponseWriter, r *http.Request) {
+	type poolsResponse struct {
+		Pools []drivers.PoolStatus `json:"pools"`
+	}
+
+	pools, err := c.poolManager.PoolsStatus(r.Context())
+	if err != nil {
+		logrus.WithError(err).Error("could not get the status of the pools")
+		writeError(w, err)
+		return
+	}
+	httprender.OK(w, poolsResponse{Pools: pools})
+}
+
+func (c *delegateCommand) handleDeletePool(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := &harness.DeletePoolRequest{PoolID: chi.URLParam(r, "id")}
+	if v := query.Get("drain"); v != "" {
+		drain, err := strconv.ParseBool(v)
+		if err != nil {
+			httprender.BadRequest(w, "invalid URL parameter 'drain'", nil)
+			return
+		}
+		req.Drain = drain
+	}
+	if v := query.Get("timeout_mins"); v != "" {
+		timeout, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			httprender.BadRequest(w, "invalid URL parameter 'timeout_mins'", nil)
+			return
+		}
+		req.TimeoutMins = timeout
+	}
+
+	resp, err := harness.HandleDeletePool(r.Context(), req, &c.env, c.poolManager)
+	if err != nil {
+		logrus.WithField("pool", req.PoolID).WithError(err).Error("could not delete the pool")
+		writeError(w, err)
+		return
+	}
+	if resp.Status == harness.PoolDraining {
+		httprender.JSON(w, resp, http.StatusAccepted)
+		return
+	}
+	httprender.OK(w, resp)
+}
+
+// handlePoolMappings returns the account pool mappings changed at runtime and the static ones of
+// the environment, the former take precedence.
+func (c *delegateCommand) handlePoolMappings(w http.ResponseWriter, r *http.Request) {
+	type poolMappingsResponse struct {
+		Mappings []*types.PoolMapping `json:"mappings"`
+		Static   []*types.PoolMapping `json:"static"`
+	}
+
+	mappings, err := c.poolManager.PoolMappings(r.Context())
+	if err != nil {
+		logrus.WithError(err).Error("could not list the pool mappings")
+		writeError(w, err)
+		return
+	}
+	httprender.OK(w, poolMappingsResponse{Mappings: mappings, Static: c.poolManager.StaticPoolMappings()})
+}
+
+// handlePutPoolMapping maps the stages of an account for a pool to another pool, the other
+// replicas apply the mapping on their next reload.
+func (c *delegateCommand) handlePutPoolMapping(w http.ResponseWriter, r *http.Request) {
+	req := &types.PoolMapping{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		httprender.BadRequest(w, "failed to decode the request body", nil)
+		return
+	}
+
+	mapping, err := c.poolManager.PutPoolMapping(r.Context(), req.AccountID, req.PoolName, req.TargetPool)
+	if err != nil {
+		logrus.WithField("account_id", req.AccountID).WithField("pool", req.PoolName).WithError(err).Error("could not update the pool mapping")
+		writeError(w, err)
+		return
+	}
+	logrus.WithField("account_id", mapping.AccountID).
+		WithField("pool", mapping.PoolName).
+		WithField("target_pool", mapping.TargetPool).
+		Infoln("updated the pool mapping")
+	httprender.OK(w, mapping)
+}
+
+// handleDeletePoolMapping removes the mapping of a pool of an account changed at runtime.
+func (c *delegateCommand) handleDeletePoolMapping(w http.ResponseWriter, r *http.Request) {
+	accountID, poolName := r.URL.Query().Get("account_id"), r.URL.Query().Get("pool")
+	if accountID == "" || poolName == "" {
+		httprender.BadRequest(w, "mandatory URL parameters 'account_id' and 'pool' are required", nil)
+		return
+	}
+	if err := c.poolManager.DeletePoolMapping(r.Context(), accountID, poolName); err != nil {
+		logrus.WithField("account_id", accountID).WithField("pool", poolName).WithError(err).Error("could not delete the pool mapping")
+		writeError(w, err)
+		return
+	}
+	logrus.WithField("account_id", accountID).
+		WithField("pool", poolName).
+		Infoln("deleted the pool mapping")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *delegateCommand) handleCapacity(w http.ResponseWriter, r *http.Request) {
+	type capacityResponse struct {
+		Pools []drivers.PoolCapacity `json:"pools"`
+	}
+
+	pools, err := c.poolManager.Capacity(r.Context())
+	if err != nil {
+		logrus.WithError(err).Error("could not get the capacity of the pools")
+		writeError(w, err)
+		return
+	}
+	httprender.OK(w, capacityResponse{Pools: pools})
+}
+
+// handleBootTimes returns the boot time percentiles of the pools, by default per hour over the last week.
+func (c *delegateCommand) handleBootTimes(w http.ResponseWriter, r *http.Request) {
+	type bootTimesResponse struct {
+		BootTimes []drivers.BootTimeStats `json:"boot_times"`
+	}
+
+	query := r.URL.Query()
+	since, bucket := 7*24*time.Hour, time.Hour //nolint:gomnd
+	for param, d := range map[string]*time.Duration{"since": &since, "bucket": &bucket} {
+		v := query.Get(param)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			httprender.BadRequest(w, "invalid duration in the URL parameter '"+param+"'", nil)
+			return
+		}
+		*d = parsed
+	}
+
+	stats, err := c.poolManager.BootTimes(r.Context(), query.Get("pool"), time.Now().Add(-since), bucket)
+	if err != nil {
+		logrus.WithError(err).Error("could not get the boot times of the pools")
+		writeError(w, err)
+		return
+	}
+	httprender.OK(w, bootTimesResponse{BootTimes: stats})
+}
+
+// handleUsage returns the utilization of the pools with their recommended sizes, by default over
+// the last hour, day and week. The windows are selected with a comma-separated list of durations.
+func (c *delegateCommand) handleUsage(w http.ResponseWriter, r *http.Request) {
+	type usageResponse struct {
+		Usage []drivers.PoolUsage `json:"usage"`
+	}
+
+	query := r.URL.Query()
+	windows := drivers.DefaultUsageWindows
+	if v := query.Get("windows"); v != "" {
+		windows = nil
+		for _, s := range strings.Split(v, ",") {
+			parsed, err := time.ParseDuration(strings.TrimSpace(s))
+			if err != nil || parsed <= 0 {
+				httprender.BadRequest(w, "invalid duration in the URL parameter 'windows'", nil)
+				return
+			}
+			windows = append(windows, parsed)
+		}
+	}
+
+	usage, err := c.poolManager.Usage(r.Context(), query.Get("pool"), windows)
+	if err != nil {
+		logrus.WithError(err).Error("could not get the usage of the pools")
+		writeError(w, err)
+		return
+	}
+	httprender.OK(w, usageResponse{Usage: usage})
+}
+
+func (c *delegateCommand) handleCapabilities(w http.ResponseWriter, _ *http.Request) {
+	type capabilitiesResponse struct {
+		Pools []drivers.PoolCapabilities `json:"pools"`
+	}
+
+	httprender.OK(w, capabilitiesResponse{Pools: c.poolManager.Capabilities()})
+}
+
 func (c *delegateCommand) handleSetup(w http.ResponseWriter, r *http.Request) {
 	req := &harness.SetupVMRequest{}
 	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
@@ -182,6 +404,22 @@ func (c *delegateCommand) handleSetup(w http.ResponseWriter, r *http.Request) {
 	httprender.OK(w, resp)
 }
 
+func (c *delegateCommand) handleCancelSetup(w http.ResponseWriter, r *http.Request) {
+	req := &harness.CancelSetupRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		logrus.WithError(err).Error("could not decode the cancel setup request body")
+		httprender.BadRequest(w, err.Error(), nil)
+		return
+	}
+	resp, err := harness.HandleCancelSetup(r.Context(), req, c.poolManager)
+	if err != nil {
+		logrus.WithField("stage_runtime_id", req.StageRuntimeID).WithError(err).Error("could not cancel the setup of the stage")
+		writeError(w, err)
+		return
+	}
+	httprender.OK(w, resp)
+}
+
 func (c *delegateCommand) handleStep(w http.ResponseWriter, r *http.Request) {
 	req := &harness.ExecuteVMRequest{}
 	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
@@ -200,6 +438,41 @@ func (c *delegateCommand) handleStep(w http.ResponseWriter, r *http.Request) {
 	httprender.OK(w, resp)
 }
 
+func (c *delegateCommand) handleArtifacts(w http.ResponseWriter, r *http.Request) {
+	req := &harness.ArtifactsRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		httprender.BadRequest(w, err.Error(), nil)
+		return
+	}
+	resp, err := harness.HandleArtifacts(r.Context(), req, c.stageOwnerStore, &c.env)
+	if err != nil {
+		logrus.WithError(err).Warnln("could not sign the uploads of the artifacts")
+		writeError(w, err)
+		return
+	}
+	httprender.OK(w, resp)
+}
+
+// handleOIDCDiscovery serves the provider configuration of the issuer of the identity tokens of the
+// stages, the clouds fetch it with the keys to verify the tokens.
+func (c *delegateCommand) handleOIDCDiscovery(w http.ResponseWriter, _ *http.Request) {
+	iss, err := harness.OIDCIssuer(&c.env)
+	if err != nil || iss == nil {
+		httprender.NotFound(w, "the runner issues no identity tokens", nil)
+		return
+	}
+	httprender.OK(w, iss.Discovery())
+}
+
+func (c *delegateCommand) handleOIDCKeys(w http.ResponseWriter, _ *http.Request) {
+	iss, err := harness.OIDCIssuer(&c.env)
+	if err != nil || iss == nil {
+		httprender.NotFound(w, "the runner issues no identity tokens", nil)
+		return
+	}
+	httprender.OK(w, iss.JWKS())
+}
+
 func (c *delegateCommand) handleDestroy(w http.ResponseWriter, r *http.Request) {
 	// TODO: Change the java object to match VmCleanupRequest
 	rs := &struct {
@@ -207,29 +480,77 @@ func (c *delegateCommand) handleDestroy(w http.ResponseWriter, r *http.Request)
 		InstanceID    string `json:"instance_id"`
 		PoolID        string `json:"pool_id"`
 		CorrelationID string `json:"correlation_id"`
+		Async         bool   `json:"async"`
 	}{}
 	if err := json.NewDecoder(r.Body).Decode(rs); err != nil {
 		logrus.WithError(err).Error("could not decode VM destroy request body")
 		httprender.BadRequest(w, err.Error(), nil)
 		return
 	}
-	req := &harness.VMCleanupRequest{PoolID: rs.PoolID, StageRuntimeID: rs.ID}
+	req := &harness.VMCleanupRequest{PoolID: rs.PoolID, StageRuntimeID: rs.ID, Async: rs.Async}
 	ctx := r.Context()
-	err := harness.HandleDestroy(ctx, req, c.stageOwnerStore, c.poolManager)
+	resp, err := harness.HandleDestroy(ctx, req, c.stageOwnerStore, c.poolManager)
 	if err != nil {
 		logrus.WithField("stage_runtime_id", req.StageRuntimeID).WithError(err).Error("could not destroy VM")
 		writeError(w, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+	httprender.OK(w, resp)
+}
+
+func (c *delegateCommand) handleExtendLease(w http.ResponseWriter, r *http.Request) {
+	req := &harness.ExtendLeaseRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		logrus.WithError(err).Error("could not decode the extend lease request body")
+		httprender.BadRequest(w, err.Error(), nil)
+		return
+	}
+	resp, err := harness.HandleExtendLease(r.Context(), req, c.stageOwnerStore, c.poolManager)
+	if err != nil {
+		logrus.WithField("stage_runtime_id", req.StageRuntimeID).WithError(err).Error("could not extend the lease of the VM")
+		writeError(w, err)
+		return
+	}
+	httprender.OK(w, resp)
+}
+
+func (c *delegateCommand) handleDestroyStatus(w http.ResponseWriter, r *http.Request) {
+	stageRuntimeID := r.URL.Query().Get("stage_runtime_id")
+	if stageRuntimeID == "" {
+		httprender.BadRequest(w, "mandatory URL parameter 'stage_runtime_id' is missing", nil)
+		return
+	}
+
+	status, ok := harness.DestroyStatusOf(stageRuntimeID)
+	if !ok {
+		writeError(w, errors.NewNotFoundError("no destroy found for the stage"))
+		return
+	}
+	httprender.OK(w, status)
 }
 
 func writeError(w http.ResponseWriter, err error) {
-	switch err.(type) {
+	switch e := err.(type) {
 	case *errors.BadRequestError:
 		httphelper.WriteBadRequest(w, err)
 	case *errors.NotFoundError:
 		httphelper.WriteNotFound(w, err)
+	case *errors.UnauthorizedError:
+		httprender.ClientError(w, err.Error(), http.StatusUnauthorized, nil)
+	case *errors.TimeoutError:
+		out := struct {
+			Message string `json:"error_msg"`
+			Output  string `json:"output,omitempty"`
+			Status  int    `json:"code"`
+		}{e.Msg, e.Output, http.StatusGatewayTimeout}
+		httphelper.WriteJSON(w, &out, http.StatusGatewayTimeout)
+	case *errors.RetryableError:
+		out := struct {
+			Message   string `json:"error_msg"`
+			Status    int    `json:"code"`
+			Retryable bool   `json:"retryable"`
+		}{e.Msg, http.StatusServiceUnavailable, true}
+		httphelper.WriteJSON(w, &out, http.StatusServiceUnavailable)
 	default:
 		httphelper.WriteInternalError(w, err)
 	}
@@ -6,8 +6,10 @@ import (
 	"time"
 
 	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/internal/events"
 	ierrors "github.com/drone-runners/drone-runner-aws/internal/types"
 	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/pkg/errors"
@@ -21,38 +23,51 @@ var (
 type VMCleanupRequest struct {
 	PoolID         string `json:"pool_id"`
 	StageRuntimeID string `json:"stage_runtime_id"`
+	Async          bool   `json:"async"`      // return right away and destroy the VM in background
+	AccountID      string `json:"account_id"` // the instances of the other accounts are not found
 }
 
-func HandleDestroy(ctx context.Context, r *VMCleanupRequest, s store.StageOwnerStore, poolManager *drivers.Manager) error {
+type VMCleanupResponse struct {
+	PoolID     string `json:"pool_id"`
+	InstanceID string `json:"instance_id"`
+	ProviderID string `json:"provider_id,omitempty"` // identifies the instance in the cloud console
+	Status     string `json:"status"`
+}
+
+func HandleDestroy(ctx context.Context, r *VMCleanupRequest, s store.StageOwnerStore, poolManager *drivers.Manager) (*VMCleanupResponse, error) {
 	if r.StageRuntimeID == "" {
-		return ierrors.NewBadRequestError("mandatory field 'stage_runtime_id' in the request body is empty")
+		return nil, ierrors.NewBadRequestError("mandatory field 'stage_runtime_id' in the request body is empty")
+	}
+	ctx = drivers.WithAccountID(ctx, r.AccountID)
+	if r.Async {
+		return destroyQueue().Enqueue(ctx, r, s, poolManager), nil
 	}
 	// We do retries on destroy in case a destroy call comes while an initialize call is still happening.
 	cnt := 0
 	b := createBackoff(destroyTimeout)
 	for {
 		duration := b.NextBackOff()
-		err := handleDestroy(ctx, r, s, poolManager, cnt)
+		resp, err := handleDestroy(ctx, r, s, poolManager, cnt)
 		if err != nil {
 			logrus.WithError(err).
 				WithField("retry_count", cnt).
 				WithField("stage_runtime_id", r.StageRuntimeID).
 				Errorln("could not destroy VM")
 			if duration == backoff.Stop {
-				return err
+				return nil, err
 			}
 			time.Sleep(duration)
 			cnt++
 			continue
 		}
-		return nil
+		return resp, nil
 	}
 }
 
-func handleDestroy(ctx context.Context, r *VMCleanupRequest, s store.StageOwnerStore, poolManager *drivers.Manager, retryCount int) error {
+func handleDestroy(ctx context.Context, r *VMCleanupRequest, s store.StageOwnerStore, poolManager *drivers.Manager, retryCount int) (*VMCleanupResponse, error) {
 	entity, err := s.Find(ctx, r.StageRuntimeID)
 	if err != nil || entity == nil {
-		return errors.Wrap(err, fmt.Sprintf("failed to find stage owner entity for stage: %s", r.StageRuntimeID))
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to find stage owner entity for stage: %s", r.StageRuntimeID))
 	}
 	poolID := entity.PoolName
 
@@ -64,30 +79,61 @@ func handleDestroy(ctx context.Context, r *VMCleanupRequest, s store.StageOwnerS
 
 	logr.Traceln("starting the destroy process")
 
-	inst, err := poolManager.GetInstanceByStageID(ctx, poolID, r.StageRuntimeID)
+	inst, err := poolManager.GetInstanceToDestroy(ctx, poolID, r.StageRuntimeID)
 	if err != nil {
-		return fmt.Errorf("cannot get the instance by tag: %w", err)
+		return nil, fmt.Errorf("cannot get the instance by tag: %w", err)
 	}
 	if inst == nil {
-		return fmt.Errorf("instance with stage runtime ID %s not found", r.StageRuntimeID)
+		return nil, fmt.Errorf("instance with stage runtime ID %s not found", r.StageRuntimeID)
 	}
 
 	logr = logr.
 		WithField("instance_id", inst.ID).
-		WithField("instance_name", inst.Name)
-
+		WithField("instance_name", inst.Name).
+		WithField("provider_id", inst.ProviderID)
+
+	usage := diskMonitor().Stop(r.StageRuntimeID)
+	cacheState().Save(ctx, r.StageRuntimeID, inst)
+	poolManager.Finalize(ctx, poolID, inst)
+	if err = serviceState().Stop(ctx, r.StageRuntimeID); err != nil {
+		logr.WithError(err).Warnln("failed to stop the services")
+	}
+	runDestroyHooks(ctx, r.StageRuntimeID, poolID, inst, poolManager)
 	if err = poolManager.Destroy(ctx, poolID, inst.ID); err != nil {
-		return fmt.Errorf("cannot destroy the instance: %w", err)
+		return nil, fmt.Errorf("cannot destroy the instance: %w", err)
 	}
 	logr.Traceln("destroyed instance")
+	poolManager.CompleteStage(ctx, r.StageRuntimeID, usage)
+	e := events.ForInstance(events.StageCompleted, inst)
+	e.StageRuntimeID = r.StageRuntimeID
+	poolManager.Publish(e)
 
 	envState().Delete(r.StageRuntimeID)
+	stageLogs().Delete(r.StageRuntimeID)
+	stepIsolation().Delete(r.StageRuntimeID, poolManager)
 
 	if err = s.Delete(ctx, r.StageRuntimeID); err != nil {
 		logr.WithError(err).Errorln("failed to delete stage owner entity")
 	}
 
-	return nil
+	return &VMCleanupResponse{PoolID: poolID, InstanceID: inst.ID, ProviderID: inst.ProviderID, Status: DestroyCompleted}, nil
+}
+
+// runDestroyHooks runs the destroy hooks of the pool on the instance of the stage, their output and
+// their outcome go to the logs of the stage.
+func runDestroyHooks(ctx context.Context, stageRuntimeID, poolID string, inst *types.Instance, poolManager *drivers.Manager) {
+	log, _ := stageLogs().Get(stageRuntimeID)
+	results := poolManager.RunDestroyHooks(ctx, poolID, stageRuntimeID, log.key, inst)
+	if len(results) == 0 {
+		return
+	}
+	lines := make([]string, len(results))
+	for i := range results {
+		lines[i] = results[i].String()
+	}
+	if err := stageLogs().Write(ctx, stageRuntimeID, "destroy-hooks", lines...); err != nil {
+		logrus.WithError(err).WithField("stage_runtime_id", stageRuntimeID).Warnln("failed to write the outcome of the destroy hooks to the stage logs")
+	}
 }
 
 func createBackoff(maxElapsedTime time.Duration) *backoff.ExponentialBackOff {
@@ -0,0 +1,173 @@
+package harness
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Destroy states.
+const (
+	DestroyPending   = "pending"
+	DestroyRunning   = "running"
+	DestroyCompleted = "completed"
+	DestroyFailed    = "failed"
+)
+
+var (
+	destroys     *DestroyQueue
+	destroysOnce sync.Once
+
+	// destroyWorkers is the number of instances destroyed concurrently.
+	destroyWorkers = 10
+	// destroyStatusTTL is how long the status of a finished destroy is kept for.
+	destroyStatusTTL = time.Hour
+)
+
+// DestroyStatus is the status of an asynchronous destroy.
+type DestroyStatus struct {
+	StageRuntimeID string             `json:"stage_runtime_id"`
+	State          string             `json:"state"`
+	Error          string             `json:"error,omitempty"`
+	Result         *VMCleanupResponse `json:"result,omitempty"`
+	Updated        int64              `json:"updated"`
+}
+
+// DestroyQueue destroys the instances of stages in background, so the slow deprovisioning
+// on some clouds doesn't delay the completion of the pipeline.
+type DestroyQueue struct {
+	mu      sync.Mutex
+	workers chan struct{}
+	status  map[string]*DestroyStatus
+}
+
+// Enqueue marks the instance of the stage for termination and queues its destruction.
+func (q *DestroyQueue) Enqueue(ctx context.Context, r *VMCleanupRequest, s store.StageOwnerStore, poolManager *drivers.Manager) *VMCleanupResponse {
+	logr := logrus.WithField("stage_runtime_id", r.StageRuntimeID)
+
+	resp := &VMCleanupResponse{Status: DestroyPending}
+	// the instance might not be found if the setup of the stage is still in progress, the destroy retries then.
+	if entity, err := s.Find(ctx, r.StageRuntimeID); err == nil && entity != nil {
+		resp.PoolID = entity.PoolName
+		if inst, err := poolManager.GetInstanceToDestroy(ctx, entity.PoolName, r.StageRuntimeID); err == nil {
+			inst.State = types.StateTerminating
+			inst.Updated = time.Now().Unix()
+			if err := poolManager.Update(ctx, inst); err != nil {
+				logr.WithError(err).Warnln("failed to mark the instance for termination")
+			}
+			resp.InstanceID = inst.ID
+			resp.ProviderID = inst.ProviderID
+		}
+	}
+
+	q.mu.Lock()
+	if st, ok := q.status[r.StageRuntimeID]; ok && (st.State == DestroyPending || st.State == DestroyRunning) {
+		q.mu.Unlock()
+		return resp
+	}
+	q.status[r.StageRuntimeID] = &DestroyStatus{
+		StageRuntimeID: r.StageRuntimeID,
+		State:          DestroyPending,
+		Updated:        time.Now().Unix(),
+	}
+	q.expire()
+	q.mu.Unlock()
+
+	req := *r
+	req.Async = false
+	go func() {
+		q.workers <- struct{}{}
+		defer func() { <-q.workers }()
+
+		q.update(req.StageRuntimeID, DestroyRunning, nil, nil)
+		// the request context is gone by now.
+		result, err := HandleDestroy(context.Background(), &req, s, poolManager)
+		if err != nil {
+			logr.WithError(err).Errorln("could not destroy VM in background")
+			q.update(req.StageRuntimeID, DestroyFailed, nil, err)
+			return
+		}
+		q.update(req.StageRuntimeID, DestroyCompleted, result, nil)
+	}()
+
+	logr.Traceln("queued VM destroy")
+	return resp
+}
+
+// ResumeDestroys queues the destruction of the instances marked for termination before the runner
+// restarted, the queue is kept in memory only.
+func ResumeDestroys(ctx context.Context, s store.StageOwnerStore, poolManager *drivers.Manager) error {
+	instances, err := poolManager.TerminatingInstances(ctx)
+	if err != nil {
+		return err
+	}
+	for _, inst := range instances {
+		if inst.Stage == "" {
+			continue
+		}
+		logrus.WithField("stage_runtime_id", inst.Stage).
+			WithField("instance_id", inst.ID).
+			Infoln("resuming the destroy of an instance marked for termination")
+		destroyQueue().Enqueue(ctx, &VMCleanupRequest{PoolID: inst.Pool, StageRuntimeID: inst.Stage, Async: true}, s, poolManager)
+	}
+	return nil
+}
+
+// Status returns the status of the destroy of the stage.
+func (q *DestroyQueue) Status(stageRuntimeID string) (DestroyStatus, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	st, ok := q.status[stageRuntimeID]
+	if !ok {
+		return DestroyStatus{}, false
+	}
+	return *st, true
+}
+
+func (q *DestroyQueue) update(stageRuntimeID, state string, result *VMCleanupResponse, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	st, ok := q.status[stageRuntimeID]
+	if !ok {
+		return
+	}
+	st.State = state
+	st.Result = result
+	st.Updated = time.Now().Unix()
+	if err != nil {
+		st.Error = err.Error()
+	}
+}
+
+// expire removes the status of destroys finished a while ago, the caller must hold the lock.
+func (q *DestroyQueue) expire() {
+	cutoff := time.Now().Add(-destroyStatusTTL).Unix()
+	for id, st := range q.status {
+		if (st.State == DestroyCompleted || st.State == DestroyFailed) && st.Updated < cutoff {
+			delete(q.status, id)
+		}
+	}
+}
+
+func destroyQueue() *DestroyQueue {
+	destroysOnce.Do(func() {
+		destroys = &DestroyQueue{
+			workers: make(chan struct{}, destroyWorkers),
+			status:  make(map[string]*DestroyStatus),
+		}
+	})
+	return destroys
+}
+
+// DestroyStatusOf returns the status of the asynchronous destroy of the stage.
+func DestroyStatusOf(stageRuntimeID string) (DestroyStatus, bool) {
+	return destroyQueue().Status(stageRuntimeID)
+}
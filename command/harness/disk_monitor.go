@@ -0,0 +1,283 @@
+package harness
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/internal/lehelper"
+	"github.com/drone-runners/drone-runner-aws/internal/oshelp"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/harness/lite-engine/api"
+	lehttp "github.com/harness/lite-engine/cli/client"
+
+	"github.com/sirupsen/logrus"
+)
+
+// output variables of the disk probe step, the utilization of the CPUs and of the memory is
+// sampled by the same probe.
+const (
+	diskUsedVar  = "DISK_USED_PCT"
+	diskAvailVar = "DISK_AVAIL_KB"
+	cpusVar      = "CPUS"
+	cpuUsedVar   = "CPU_USED_PCT"
+	memTotalVar  = "MEM_TOTAL_KB"
+	memUsedVar   = "MEM_USED_KB"
+)
+
+// diskProbeTimeout bounds a disk probe, the probe runs next to the steps of the stage.
+const diskProbeTimeout = time.Minute
+
+var (
+	monitors     *DiskMonitorState
+	monitorsOnce sync.Once
+)
+
+// DiskMonitorState keeps track of the disk monitors of the stages set up by the runner, and of
+// the peak utilization of the instances of the stages they sample.
+type DiskMonitorState struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+	usage   map[string]*types.ResourceUsage
+}
+
+// stageLog is the log stream of a stage the disk usage warnings are written to.
+type stageLog struct {
+	config        api.LogConfig
+	key           string
+	correlationID string
+}
+
+func diskMonitor() *DiskMonitorState {
+	monitorsOnce.Do(func() {
+		monitors = &DiskMonitorState{
+			mu:      sync.Mutex{},
+			cancels: make(map[string]context.CancelFunc),
+			usage:   make(map[string]*types.ResourceUsage),
+		}
+	})
+	return monitors
+}
+
+// Start periodically probes the disk usage of the instance of the stage through its lite-engine
+// until the stage is destroyed. When the usage crosses the threshold a warning is written to the
+// log stream of the stage, next to its setup logs, and counted in the statistics of the pool.
+// The peak utilization of the CPUs and of the memory of the instance is kept for Stop.
+func (s *DiskMonitorState) Start(stageRuntimeID string, inst *types.Instance, log stageLog, env *config.EnvConfig, poolManager *drivers.Manager) {
+	interval := time.Minute * time.Duration(env.Settings.DiskCheckMins)
+	if interval <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	if prev, ok := s.cancels[stageRuntimeID]; ok {
+		prev()
+	}
+	s.cancels[stageRuntimeID] = cancel
+	delete(s.usage, stageRuntimeID)
+	s.mu.Unlock()
+
+	go s.run(ctx, stageRuntimeID, inst, log, interval, env, poolManager)
+}
+
+// Stop stops the disk monitor of the stage and returns the peak utilization of its instance, nil
+// if it was not sampled.
+func (s *DiskMonitorState) Stop(stageRuntimeID string) *types.ResourceUsage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cancel, ok := s.cancels[stageRuntimeID]; ok {
+		cancel()
+		delete(s.cancels, stageRuntimeID)
+	}
+	usage := s.usage[stageRuntimeID]
+	delete(s.usage, stageRuntimeID)
+	return usage
+}
+
+// sample keeps the peak utilization of the instance of the stage.
+func (s *DiskMonitorState) sample(stageRuntimeID string, u *types.ResourceUsage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.cancels[stageRuntimeID]; !ok {
+		return // stopped meanwhile
+	}
+	peak, ok := s.usage[stageRuntimeID]
+	if !ok {
+		s.usage[stageRuntimeID] = u
+		return
+	}
+	peak.CPUs, peak.MemTotalMB = u.CPUs, u.MemTotalMB
+	if u.PeakCPUPct > peak.PeakCPUPct {
+		peak.PeakCPUPct = u.PeakCPUPct
+	}
+	if u.PeakMemMB > peak.PeakMemMB {
+		peak.PeakMemMB = u.PeakMemMB
+	}
+}
+
+func (s *DiskMonitorState) run(ctx context.Context, stageRuntimeID string, inst *types.Instance, log stageLog, interval time.Duration,
+	env *config.EnvConfig, poolManager *drivers.Manager) {
+	logr := logrus.
+		WithField("stage_runtime_id", stageRuntimeID).
+		WithField("instance_id", inst.ID).
+		WithField("pool", inst.Pool)
+
+	client, err := lehelper.GetClient(inst, env.Runner.Name, inst.Port, env.LiteEngine.EnableMock, env.LiteEngine.MockStepTimeoutSecs)
+	if err != nil {
+		logr.WithError(err).Warnln("disk monitor: failed to create the lite-engine client")
+		return
+	}
+	_, rootDir := poolManager.Inspect(inst.Pool)
+	threshold := env.Settings.DiskWarnPercent
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	warned := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		outputs, probeErr := probeDisk(ctx, client, inst.Platform.OS, rootDir)
+		if probeErr != nil {
+			logr.WithError(probeErr).Debugln("disk monitor: failed to probe the disk usage")
+			continue
+		}
+		if usage, ok := parseUtilization(outputs); ok {
+			s.sample(stageRuntimeID, usage)
+		}
+		used, availKB, parseErr := parseDiskUsage(outputs)
+		if parseErr != nil {
+			logr.WithError(parseErr).Debugln("disk monitor: failed to probe the disk usage")
+			continue
+		}
+		// warn once per crossing of the threshold
+		if used < threshold {
+			warned = false
+			continue
+		}
+		if warned {
+			continue
+		}
+		warned = true
+		msg := fmt.Sprintf("WARNING: the disk of the build VM is %d%% full, %d MB left. The steps might fail with \"no space left on device\".",
+			used, availKB/1024) //nolint:gomnd
+		logr.WithField("disk_used_pct", used).Warnln("disk monitor: the disk usage crossed the threshold")
+		poolManager.RecordDiskWarning(inst.Pool)
+		if writeErr := writeStageLog(ctx, env, log, "disk-usage", msg); writeErr != nil {
+			logr.WithError(writeErr).Warnln("disk monitor: failed to write the warning to the stage logs")
+		}
+	}
+}
+
+// probeDisk runs a step on the host of the instance reporting the usage of the disk of the
+// directory and the utilization of the CPUs and of the memory, it returns the output variables.
+func probeDisk(ctx context.Context, client lehttp.Client, os, dir string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, diskProbeTimeout)
+	defer cancel()
+
+	req := &api.StartStepRequest{
+		ID:         "disk-usage-" + oshelp.Random(),
+		Name:       "disk-usage",
+		Kind:       api.Run,
+		OutputVars: []string{diskUsedVar, diskAvailVar, cpusVar, cpuUsedVar, memTotalVar, memUsedVar},
+	}
+	req.Run.Entrypoint, req.Run.Command = diskProbeCommand(os, dir)
+	if _, err := client.StartStep(ctx, req); err != nil {
+		return nil, err
+	}
+	resp, err := client.RetryPollStep(ctx, &api.PollStepRequest{ID: req.ID}, diskProbeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("disk probe failed: %s", resp.Error)
+	}
+	return resp.Outputs, nil
+}
+
+// diskProbeCommand returns the script setting the output variables of the disk probe.
+func diskProbeCommand(os, dir string) (entrypoint, command []string) {
+	if os == oshelp.OSWindows {
+		if dir == "" {
+			dir = `C:\`
+		}
+		script := fmt.Sprintf(`$drive = Get-PSDrive (Get-Item '%s').PSDrive.Name
+$Env:%s = [math]::Round(100 * $drive.Used / ($drive.Used + $drive.Free))
+$Env:%s = [math]::Floor($drive.Free / 1024)
+$os = Get-CimInstance Win32_OperatingSystem
+$Env:%s = [Environment]::ProcessorCount
+$Env:%s = [math]::Round((Get-CimInstance Win32_Processor | Measure-Object -Property LoadPercentage -Average).Average)
+$Env:%s = $os.TotalVisibleMemorySize
+$Env:%s = $os.TotalVisibleMemorySize - $os.FreePhysicalMemory`, dir, diskUsedVar, diskAvailVar, cpusVar, cpuUsedVar, memTotalVar, memUsedVar)
+		return []string{"powershell"}, []string{script}
+	}
+	if dir == "" {
+		dir = "/"
+	}
+	// the CPU utilization is measured over a second from /proc/stat, the hosts without /proc,
+	// e.g. macOS, only report the disk usage.
+	script := fmt.Sprintf(`%s=$(df -Pk '%s' | awk 'NR==2 {sub("%%", "", $5); print $5}')
+%s=$(df -Pk '%s' | awk 'NR==2 {print $4}')
+%s=$(nproc 2>/dev/null)
+%s=$({ head -1 /proc/stat; sleep 1; head -1 /proc/stat; } 2>/dev/null | awk '{idle=$5+$6; total=0; for (f=2; f<=NF; f++) total+=$f; if (NR==1) {idle1=idle; total1=total} else if (total>total1) printf "%%d", 100*(1-(idle-idle1)/(total-total1))}')
+%s=$(awk '/^MemTotal:/ {print $2}' /proc/meminfo 2>/dev/null)
+%s=$(awk '/^MemTotal:/ {t=$2} /^MemAvailable:/ {a=$2} END {if (t) print t-a}' /proc/meminfo 2>/dev/null)`,
+		diskUsedVar, dir, diskAvailVar, dir, cpusVar, cpuUsedVar, memTotalVar, memUsedVar)
+	return []string{"sh", "-c"}, []string{script}
+}
+
+func parseDiskUsage(outputs map[string]string) (used int, availKB int64, err error) {
+	if used, err = strconv.Atoi(strings.TrimSpace(outputs[diskUsedVar])); err != nil {
+		return 0, 0, fmt.Errorf("invalid disk usage %q", outputs[diskUsedVar])
+	}
+	if availKB, err = strconv.ParseInt(strings.TrimSpace(outputs[diskAvailVar]), 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid available disk space %q", outputs[diskAvailVar])
+	}
+	return used, availKB, nil
+}
+
+// parseUtilization returns the utilization sampled by the probe, false if the host did not report it.
+func parseUtilization(outputs map[string]string) (*types.ResourceUsage, bool) {
+	values := map[string]int64{}
+	for _, v := range []string{cpusVar, cpuUsedVar, memTotalVar, memUsedVar} {
+		n, err := strconv.ParseInt(strings.TrimSpace(outputs[v]), 10, 64)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		values[v] = n
+	}
+	return &types.ResourceUsage{
+		CPUs:       int(values[cpusVar]),
+		MemTotalMB: values[memTotalVar] / 1024, //nolint:gomnd
+		PeakCPUPct: int(values[cpuUsedVar]),
+		PeakMemMB:  values[memUsedVar] / 1024, //nolint:gomnd
+	}, true
+}
+
+// writeStageLog appends the lines to a stream of the stage next to its setup logs, the stream of
+// the setup is closed once the setup completes.
+func writeStageLog(ctx context.Context, env *config.EnvConfig, log stageLog, stream string, lines ...string) error {
+	wc, err := getStreamLogger(ctx, env, log.config, log.key+"-"+stream, log.correlationID)
+	if err != nil {
+		return err
+	}
+	if wc == nil {
+		return nil // the runner logs the warning
+	}
+	for _, line := range lines {
+		if _, err = fmt.Fprintln(wc, line); err != nil {
+			_ = wc.Close()
+			return err
+		}
+	}
+	return wc.Close()
+}
@@ -17,7 +17,7 @@ type VMCleanupTask struct {
 
 func (t *VMCleanupTask) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background() // TODO: Get this from http Request
-	log := logrus.New()
+	log := logrus.StandardLogger()
 	task := &client.Task{}
 	err := json.NewDecoder(r.Body).Decode(task)
 	if err != nil {
@@ -40,12 +40,15 @@ func (t *VMCleanupTask) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		httphelper.WriteBadRequest(w, err)
 		return
 	}
-	err = harness.HandleDestroy(ctx, req, t.c.stageOwnerStore, t.c.poolManager)
+	destroyResp, err := harness.HandleDestroy(ctx, req, t.c.stageOwnerStore, t.c.poolManager)
 	if err != nil {
 		logr.WithError(err).Error("could not destroy VM")
 		httphelper.WriteJSON(w, failedResponse(err.Error()), httpFailed)
 		return
 	}
+	logr.WithField("instance_id", destroyResp.InstanceID).
+		WithField("provider_id", destroyResp.ProviderID).
+		Traceln("destroyed VM")
 	resp := VMTaskExecutionResponse{
 		CommandExecutionStatus: Success,
 		DelegateMetaInfo: DelegateMetaInfo{
@@ -2,7 +2,6 @@ package dlite
 
 import (
 	"context"
-	"time"
 
 	"github.com/drone-runners/drone-runner-aws/command/config"
 	"github.com/drone-runners/drone-runner-aws/command/harness"
@@ -95,13 +94,17 @@ func (c *dliteCommand) run(*kingpin.ParseContext) error {
 		cancel()
 	})
 
-	instanceStore, stageOwnerStore, err := database.ProvideStore(c.env.Database.Driver, c.env.Database.Datasource)
+	instanceStore, stageOwnerStore, destroyRetryStore, stageRecordStore, poolMappingStore, taskJournalStore, closeStore, err := database.ProvideStore(c.env.Database.Driver, c.env.Database.Datasource, database.EnvOptions(&c.env)...)
 	if err != nil {
 		logrus.WithError(err).Fatalln("Unable to start the database")
 	}
+	defer closeStore()
 
 	c.stageOwnerStore = stageOwnerStore
 	c.poolManager = drivers.New(ctx, instanceStore, &c.env)
+	c.poolManager.SetDestroyRetryStore(destroyRetryStore)
+	c.poolManager.SetStageRecordStore(stageRecordStore)
+	c.poolManager.SetPoolMappingStore(poolMappingStore)
 
 	poolConfig, err := harness.SetupPool(ctx, &c.env, c.poolManager, c.poolFile)
 	defer harness.Cleanup(&c.env, c.poolManager) //nolint: errcheck
@@ -109,6 +112,9 @@ func (c *dliteCommand) run(*kingpin.ParseContext) error {
 		logrus.WithError(err).Error("could not setup pool")
 		return err
 	}
+	if err = harness.ResumeDestroys(ctx, stageOwnerStore, c.poolManager); err != nil {
+		logrus.WithError(err).Errorln("could not resume the destroy of the terminating instances")
+	}
 
 	tags := parseTags(poolConfig)
 
@@ -122,6 +128,9 @@ func (c *dliteCommand) run(*kingpin.ParseContext) error {
 		return err
 	}
 
+	s := newScheduler(p, c.delegateInfo.ID, &c.env, taskJournalStore)
+	c.recoverTasks(ctx, s)
+
 	var g errgroup.Group
 
 	g.Go(func() error {
@@ -131,25 +140,20 @@ func (c *dliteCommand) run(*kingpin.ParseContext) error {
 
 	g.Go(func() error {
 		// Start the HTTP server
-		s := server.Server{
+		srv := server.Server{
 			Addr:    c.env.Server.Port,
-			Handler: Handler(p),
+			Handler: Handler(p, s),
 		}
 
-		logrus.WithField("addr", s.Addr).
+		logrus.WithField("addr", srv.Addr).
 			Infoln("starting the server")
 
-		return s.ListenAndServe(ctx)
+		return srv.ListenAndServe(ctx)
 	})
 
 	g.Go(func() error {
-		// Start the poller
-		pollDuration := time.Duration(c.env.Dlite.PollIntervalMilliSecs) * time.Millisecond
-		err = p.Poll(ctx, c.env.Dlite.ParallelWorkers, c.delegateInfo.ID, pollDuration)
-		if err != nil {
-			return err
-		}
-		return nil
+		// Start polling for the tasks
+		return s.run(ctx)
 	})
 
 	waitErr := g.Wait()
@@ -22,7 +22,7 @@ type VMExecuteTaskRequest struct {
 
 func (t *VMExecuteTask) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background() // TODO: (Vistaar) Set this in dlite
-	log := logrus.New()
+	log := logrus.StandardLogger()
 	task := &client.Task{}
 	err := json.NewDecoder(r.Body).Decode(task)
 	if err != nil {
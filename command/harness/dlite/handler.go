@@ -17,7 +17,7 @@ var (
 	disabledStatus = "DISABLED"
 )
 
-func Handler(p *poller.Poller) http.Handler {
+func Handler(p *poller.Poller, s *scheduler) http.Handler {
 	r := chi.NewRouter()
 	r.Use(harness.Middleware)
 	r.Use(middleware.Recoverer)
@@ -33,6 +33,10 @@ func Handler(p *poller.Poller) http.Handler {
 	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		io.WriteString(w, okStatus) //nolint: errcheck
 	})
+	r.Get("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		io.WriteString(w, s.metrics()) //nolint: errcheck
+	})
 	return r
 }
 
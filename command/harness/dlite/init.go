@@ -29,7 +29,7 @@ func (t *VMInitTask) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), initTimeoutSec*time.Second) // TODO: Get this from the request
 	defer cancel()
 
-	log := logrus.New()
+	log := logrus.StandardLogger()
 	task := &client.Task{}
 	err := json.NewDecoder(r.Body).Decode(task)
 	if err != nil {
@@ -0,0 +1,175 @@
+package dlite
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/harness"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/sirupsen/logrus"
+	"github.com/wings-software/dlite/client"
+)
+
+// The messages of the tasks failed on the startup of the runner.
+const (
+	interruptedSetupMsg = "the runner restarted while setting up the stage, the stage can be retried"
+	interruptedStepMsg  = "the runner restarted while running the step, its outcome is unknown"
+)
+
+// journalAcquired records the acquired task in the journal, it returns nil if the runner keeps no
+// journal or the task could not be recorded.
+func (s *scheduler) journalAcquired(ctx context.Context, task *client.Task) *types.TaskJournalEntry {
+	if s.journal == nil {
+		return nil
+	}
+	now := time.Now().Unix()
+	entry := &types.TaskJournalEntry{
+		TaskID:   task.ID,
+		TaskType: task.Type,
+		Runner:   s.runner,
+		State:    types.TaskAcquired,
+		Created:  now,
+		Updated:  now,
+	}
+	entry.StageID, entry.PoolID, entry.AccountID = taskStage(task)
+	// the destroys are executed again on the startup, destroying an instance twice is harmless
+	if classOf(task.Type) == classDestroy {
+		if b, err := json.Marshal(task); err == nil {
+			entry.Task = string(b)
+		}
+	}
+	if err := s.journal.Create(ctx, entry); err != nil {
+		logrus.WithError(err).WithField("task_id", task.ID).Warnln("dlite: could not record the task in the journal")
+		return nil
+	}
+	return entry
+}
+
+// journalExecuted records the response of the executed task, so it is sent again if the runner
+// restarts before sending it.
+func (s *scheduler) journalExecuted(ctx context.Context, entry *types.TaskJournalEntry, response []byte) {
+	if entry == nil {
+		return
+	}
+	entry.State = types.TaskExecuted
+	entry.Response = string(response)
+	entry.Updated = time.Now().Unix()
+	if err := s.journal.Update(ctx, entry); err != nil {
+		logrus.WithError(err).WithField("task_id", entry.TaskID).Warnln("dlite: could not record the response of the task in the journal")
+	}
+}
+
+// settle drops the task from the journal.
+func (s *scheduler) settle(ctx context.Context, entry *types.TaskJournalEntry) {
+	if entry == nil {
+		return
+	}
+	if err := s.journal.Delete(ctx, entry.TaskID); err != nil {
+		logrus.WithError(err).WithField("task_id", entry.TaskID).Warnln("dlite: could not drop the task from the journal")
+	}
+}
+
+// taskStage returns the stage of the task, its pool and its account.
+func taskStage(task *client.Task) (stageID, poolID, accountID string) {
+	switch task.Type {
+	case initTask:
+		req := &VMInitRequest{}
+		if json.Unmarshal(task.Data, req) == nil {
+			return req.SetupVMRequest.ID, req.SetupVMRequest.PoolID, req.SetupVMRequest.SetupRequest.LogConfig.AccountID
+		}
+	case executeTask:
+		req := &VMExecuteTaskRequest{}
+		if json.Unmarshal(task.Data, req) == nil {
+			return req.ExecuteVMRequest.StageRuntimeID, req.ExecuteVMRequest.PoolID, req.ExecuteVMRequest.AccountID
+		}
+	case cleanupTask:
+		req := &harness.VMCleanupRequest{}
+		if json.Unmarshal(task.Data, req) == nil {
+			return req.StageRuntimeID, req.PoolID, req.AccountID
+		}
+	}
+	return "", "", ""
+}
+
+// recoverTasks settles the tasks of the journal the runner acquired before it restarted, so the
+// manager does not see them running forever:
+//   - the response of an executed task is sent again,
+//   - a destroy is executed again,
+//   - a setup fails and the instance of its stage is destroyed in the background,
+//   - a step fails, its state on the instance is unknown.
+//
+// The tasks are settled in the background, they are dropped from the journal whether or not the
+// manager accepts their status.
+func (c *dliteCommand) recoverTasks(ctx context.Context, s *scheduler) {
+	if s.journal == nil {
+		return
+	}
+	entries, err := s.journal.List(ctx, s.runner)
+	if err != nil {
+		logrus.WithError(err).Errorln("dlite: could not list the tasks of the journal")
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+	logrus.WithField("tasks", len(entries)).Warnln("dlite: settling the tasks interrupted by the restart of the runner")
+	go func() {
+		for _, entry := range entries {
+			c.recoverTask(ctx, s, entry)
+		}
+	}()
+}
+
+func (c *dliteCommand) recoverTask(ctx context.Context, s *scheduler, entry *types.TaskJournalEntry) {
+	logr := logrus.WithField("task_id", entry.TaskID).
+		WithField("task_type", entry.TaskType).
+		WithField("state", entry.State).
+		WithField("stage_runtime_id", entry.StageID)
+	// the entry is dropped whatever the outcome, the task is not settled again on the next restart
+	defer s.settle(ctx, entry)
+
+	response := []byte(entry.Response)
+	if entry.State != types.TaskExecuted {
+		switch classOf(entry.TaskType) {
+		case classDestroy:
+			task := &client.Task{}
+			if err := json.Unmarshal([]byte(entry.Task), task); err != nil {
+				logr.WithError(err).Errorln("dlite: could not decode the interrupted task")
+				return
+			}
+			data, err := s.runTask(ctx, task)
+			if err != nil {
+				logr.WithError(err).Errorln("dlite: could not execute the interrupted task again")
+				return
+			}
+			response = data
+		case classSetup:
+			if entry.StageID != "" {
+				req := &harness.VMCleanupRequest{PoolID: entry.PoolID, StageRuntimeID: entry.StageID, AccountID: entry.AccountID, Async: true}
+				if _, err := harness.HandleDestroy(ctx, req, c.stageOwnerStore, c.poolManager); err != nil {
+					logr.WithError(err).Warnln("dlite: could not destroy the instance of the interrupted setup")
+				}
+			}
+			response = c.failedTaskResponse(interruptedSetupMsg)
+		default:
+			response = c.failedTaskResponse(interruptedStepMsg)
+		}
+	}
+	if err := s.poller.Client.SendStatus(ctx, s.delegateID, entry.TaskID,
+		&client.TaskResponse{ID: entry.TaskID, Data: response, Code: "OK", Type: entry.TaskType}); err != nil {
+		logr.WithError(err).Warnln("dlite: could not send the status of the interrupted task")
+		return
+	}
+	logr.Infoln("dlite: settled the interrupted task")
+}
+
+// failedTaskResponse returns the encoded response of a failed task.
+func (c *dliteCommand) failedTaskResponse(msg string) []byte {
+	resp := failedResponse(msg)
+	if c.delegateInfo != nil {
+		resp.DelegateMetaInfo = DelegateMetaInfo{HostName: c.delegateInfo.Host, ID: c.delegateInfo.ID}
+	}
+	b, _ := json.Marshal(resp)
+	return b
+}
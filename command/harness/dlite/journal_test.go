@@ -0,0 +1,90 @@
+package dlite
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/store/database/memory"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/wings-software/dlite/client"
+	"github.com/wings-software/dlite/poller"
+	"github.com/wings-software/dlite/router"
+	"github.com/wings-software/dlite/task"
+)
+
+func TestJournal(t *testing.T) {
+	db, err := memory.Open("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal := memory.NewTaskJournalStore(db)
+	ctx := context.Background()
+
+	c := &fakeClient{events: []*client.TaskEvent{{TaskID: "destroy-1", TaskType: cleanupTask}}}
+	r := router.NewRouter(map[string]task.Handler{
+		cleanupTask: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "destroyed") //nolint: errcheck
+		}),
+	})
+	env := testEnv(1, 0, 10)
+	env.Runner.Name = "runner-0"
+	s := newScheduler(poller.New("account", "secret", "runner", nil, c, r), "delegate", env, journal)
+
+	// the tasks are recorded until their response is sent
+	data := json.RawMessage(`{"execute_step_request":{"stage_runtime_id":"stage-1","pool_id":"linux","account_id":"account"}}`)
+	entry := s.journalAcquired(ctx, &client.Task{ID: "step-1", Type: executeTask, Data: data})
+	if entry == nil || entry.StageID != "stage-1" || entry.PoolID != "linux" || entry.AccountID != "account" || entry.Task != "" {
+		t.Errorf("expected the step recorded with its stage and without its data, got %+v", entry)
+	}
+	if err = s.acquireAndRun(ctx, "destroy-1"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := journal.List(ctx, "runner-0"); len(entries) != 1 || entries[0].TaskID != "step-1" {
+		t.Errorf("expected the destroy to be dropped from the journal once sent, got %+v", entries)
+	}
+
+	// the tasks interrupted by a restart are settled
+	destroy, _ := json.Marshal(&client.Task{ID: "destroy-2", Type: cleanupTask, Data: json.RawMessage(`{}`)})
+	for _, e := range []*types.TaskJournalEntry{
+		{TaskID: "executed-1", TaskType: executeTask, Runner: "runner-0", State: types.TaskExecuted, Response: `{"ok":true}`},
+		{TaskID: "destroy-2", TaskType: cleanupTask, Runner: "runner-0", State: types.TaskAcquired, Task: string(destroy)},
+		{TaskID: "other-1", TaskType: executeTask, Runner: "runner-1", State: types.TaskAcquired},
+	} {
+		if err = journal.Create(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := &dliteCommand{delegateInfo: &poller.DelegateInfo{ID: "delegate", Host: "host"}}
+	cmd.recoverTasks(ctx, s)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if entries, _ := journal.List(ctx, "runner-0"); len(entries) == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.mu.Lock()
+	responses := c.responses
+	c.mu.Unlock()
+	if got := responses["executed-1"]; got != `{"ok":true}` {
+		t.Errorf("expected the response of the executed task to be sent again, got %q", got)
+	}
+	if got := responses["destroy-2"]; got != "destroyed" {
+		t.Errorf("expected the destroy to be executed again, got %q", got)
+	}
+	if got := responses["step-1"]; !strings.Contains(got, interruptedStepMsg) || !strings.Contains(got, `"FAILURE"`) {
+		t.Errorf("expected the interrupted step to fail, got %q", got)
+	}
+	if _, ok := responses["other-1"]; ok {
+		t.Error("expected the tasks of the other runners to be left alone")
+	}
+	if entries, _ := journal.List(ctx, "runner-0"); len(entries) != 0 {
+		t.Errorf("expected the journal of the runner to be empty, got %+v", entries)
+	}
+}
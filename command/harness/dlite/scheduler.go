@@ -0,0 +1,317 @@
+package dlite
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/sirupsen/logrus"
+	"github.com/wings-software/dlite/client"
+	"github.com/wings-software/dlite/poller"
+)
+
+// Classes of the tasks. Every class has a queue of its own and can have workers of its own, so a
+// flood of step tasks does not hold back the setups and the destroys.
+const (
+	classSetup   = "setup"
+	classStep    = "step"
+	classDestroy = "destroy"
+)
+
+// taskClasses are the classes in the order of the metrics.
+var taskClasses = []string{classSetup, classStep, classDestroy}
+
+func classOf(taskType string) string {
+	switch taskType {
+	case initTask:
+		return classSetup
+	case cleanupTask:
+		return classDestroy
+	default:
+		return classStep
+	}
+}
+
+// queuedTask is a task event waiting for a worker.
+type queuedTask struct {
+	event  *client.TaskEvent
+	queued time.Time
+}
+
+// taskQueue holds the task events of a class until a worker acquires them.
+type taskQueue struct {
+	class   string
+	tasks   chan queuedTask
+	workers int // the workers dedicated to the class
+
+	running  atomic.Int64
+	done     atomic.Int64
+	deferred atomic.Int64 // the events left on the manager because the queue was full
+	waitNs   atomic.Int64 // the total time the done tasks waited in the queue
+}
+
+// scheduler polls the task events of the delegate and executes them, in place of Poller.Poll whose
+// workers take the events in the order they are polled. The events are queued by class and served
+// by the workers of their class and by the shared workers, which serve the classes in turn. An
+// event is not polled again while it is queued or executed; once its queue is full it is left on
+// the manager for the next poll, or for another delegate.
+type scheduler struct {
+	poller     *poller.Poller
+	delegateID string
+	interval   time.Duration
+	pollers    int // the concurrent polls of the task events
+	shared     int // the workers serving every class
+	queues     map[string]*taskQueue
+	turn       atomic.Uint32 // the class the shared workers look at first
+
+	seen sync.Map // the IDs of the tasks queued or executed
+
+	journal store.TaskJournalStore // records the tasks until their response is sent, nil if none
+	runner  string                 // the name of the runner, the runner settles its own tasks of the journal
+}
+
+func newScheduler(p *poller.Poller, delegateID string, env *config.EnvConfig, journal store.TaskJournalStore) *scheduler {
+	s := &scheduler{
+		poller:     p,
+		delegateID: delegateID,
+		journal:    journal,
+		runner:     env.Runner.Name,
+		interval:   time.Duration(env.Dlite.PollIntervalMilliSecs) * time.Millisecond,
+		pollers:    env.Dlite.PollConcurrency,
+		shared:     env.Dlite.ParallelWorkers,
+		queues:     map[string]*taskQueue{},
+	}
+	workers := map[string]int{
+		classSetup:   env.Dlite.SetupWorkers,
+		classStep:    env.Dlite.StepWorkers,
+		classDestroy: env.Dlite.DestroyWorkers,
+	}
+	for _, class := range taskClasses {
+		s.queues[class] = &taskQueue{class: class, tasks: make(chan queuedTask, env.Dlite.QueueSize), workers: workers[class]}
+	}
+	return s
+}
+
+// run polls and executes the tasks until the context is done.
+func (s *scheduler) run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for i := 0; i < s.pollers; i++ {
+		// the polls are spread over the interval
+		delay := s.interval * time.Duration(i) / time.Duration(s.pollers)
+		go s.poll(ctx, delay)
+	}
+	worker := func(name string, take func(context.Context) (*taskQueue, queuedTask, bool)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				q, t, ok := take(ctx)
+				if !ok {
+					return
+				}
+				s.execute(ctx, q, t, name)
+			}
+		}()
+	}
+	for _, class := range taskClasses {
+		q := s.queues[class]
+		for i := 0; i < q.workers; i++ {
+			worker(fmt.Sprintf("%s-%d", class, i), q.take)
+		}
+	}
+	for i := 0; i < s.shared; i++ {
+		worker(fmt.Sprintf("shared-%d", i), s.take)
+	}
+	logrus.WithField("pollers", s.pollers).
+		WithField("shared_workers", s.shared).
+		WithField("setup_workers", s.queues[classSetup].workers).
+		WithField("step_workers", s.queues[classStep].workers).
+		WithField("destroy_workers", s.queues[classDestroy].workers).
+		Infoln("dlite: polling for tasks")
+	wg.Wait()
+	return nil
+}
+
+func (s *scheduler) poll(ctx context.Context, delay time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		resp, err := s.poller.Client.GetTaskEvents(ctx, s.delegateID)
+		if err != nil {
+			logrus.WithError(err).Errorln("dlite: could not query for task events")
+		} else {
+			filter := s.poller.Filter
+			for _, ev := range resp.TaskEvents {
+				if filter == nil || filter(ev) {
+					s.enqueue(ev)
+				}
+			}
+		}
+		timer.Reset(s.interval)
+	}
+}
+
+// enqueue queues the event unless it is already queued or executed, or its queue is full.
+func (s *scheduler) enqueue(ev *client.TaskEvent) {
+	if _, loaded := s.seen.LoadOrStore(ev.TaskID, true); loaded {
+		return
+	}
+	q := s.queues[classOf(ev.TaskType)]
+	select {
+	case q.tasks <- queuedTask{event: ev, queued: time.Now()}:
+	default:
+		s.seen.Delete(ev.TaskID)
+		q.deferred.Add(1)
+		logrus.WithField("task_id", ev.TaskID).
+			WithField("class", q.class).
+			Debugln("dlite: the queue is full, the task is left for the next poll")
+	}
+}
+
+// take returns the next task of the queue.
+func (q *taskQueue) take(ctx context.Context) (*taskQueue, queuedTask, bool) {
+	select {
+	case <-ctx.Done():
+		return nil, queuedTask{}, false
+	case t := <-q.tasks:
+		return q, t, true
+	}
+}
+
+// take returns the next task of any class for a shared worker. The classes are served in turn, a
+// class with many queued tasks does not starve the others.
+func (s *scheduler) take(ctx context.Context) (*taskQueue, queuedTask, bool) {
+	first := int(s.turn.Add(1))
+	for i := range taskClasses {
+		q := s.queues[taskClasses[(first+i)%len(taskClasses)]]
+		select {
+		case t := <-q.tasks:
+			return q, t, true
+		default:
+		}
+	}
+	setup, step, destroy := s.queues[classSetup], s.queues[classStep], s.queues[classDestroy]
+	select {
+	case <-ctx.Done():
+		return nil, queuedTask{}, false
+	case t := <-setup.tasks:
+		return setup, t, true
+	case t := <-step.tasks:
+		return step, t, true
+	case t := <-destroy.tasks:
+		return destroy, t, true
+	}
+}
+
+func (s *scheduler) execute(ctx context.Context, q *taskQueue, t queuedTask, worker string) {
+	defer s.seen.Delete(t.event.TaskID)
+	q.waitNs.Add(int64(time.Since(t.queued)))
+	q.running.Add(1)
+	defer func() {
+		q.running.Add(-1)
+		q.done.Add(1)
+	}()
+
+	logr := logrus.WithField("task_id", t.event.TaskID).WithField("worker", worker)
+	if err := s.acquireAndRun(ctx, t.event.TaskID); err != nil {
+		logr.WithError(err).Errorln("dlite: could not perform the task")
+	}
+}
+
+// acquireAndRun acquires the task, executes its handler and sends its response to the manager. The
+// task is recorded in the journal until its response is sent.
+func (s *scheduler) acquireAndRun(ctx context.Context, taskID string) error {
+	task, err := s.poller.Client.Acquire(ctx, s.delegateID, taskID)
+	if err != nil {
+		return fmt.Errorf("failed to acquire task: %w", err)
+	}
+	entry := s.journalAcquired(ctx, task)
+	data, err := s.runTask(ctx, task)
+	if err != nil {
+		s.settle(ctx, entry)
+		return err
+	}
+	s.journalExecuted(ctx, entry, data)
+	return s.respond(ctx, entry, task.ID, task.Type, data)
+}
+
+// runTask executes the handler of the task and returns its response.
+func (s *scheduler) runTask(ctx context.Context, task *client.Task) ([]byte, error) {
+	handler := s.poller.Router.Route(task.Type)
+	if handler == nil {
+		return nil, fmt.Errorf("task type %s not supported by the delegate", task.Type)
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(task); err != nil {
+		return nil, fmt.Errorf("failed to encode task: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/", &buf)
+	if err != nil {
+		return nil, err
+	}
+	w := &taskResponseWriter{header: http.Header{}}
+	handler.ServeHTTP(w, req)
+	return w.body.Bytes(), nil
+}
+
+// respond sends the response of the task to the manager, the task is then dropped from the journal.
+func (s *scheduler) respond(ctx context.Context, entry *types.TaskJournalEntry, taskID, taskType string, data []byte) error {
+	resp := &client.TaskResponse{ID: taskID, Data: data, Code: "OK", Type: taskType}
+	if err := s.poller.Client.SendStatus(ctx, s.delegateID, taskID, resp); err != nil {
+		return fmt.Errorf("failed to send the task status: %w", err)
+	}
+	s.settle(ctx, entry)
+	return nil
+}
+
+// taskResponseWriter collects the response of a task handler.
+type taskResponseWriter struct {
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (w *taskResponseWriter) Header() http.Header         { return w.header }
+func (w *taskResponseWriter) WriteHeader(int)             {}
+func (w *taskResponseWriter) Write(p []byte) (int, error) { return w.body.Write(p) }
+
+// metrics returns the metrics of the queues in the Prometheus text format.
+func (s *scheduler) metrics() string {
+	var b strings.Builder
+	metric := func(name, kind, help string, value func(q *taskQueue) string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, class := range taskClasses {
+			fmt.Fprintf(&b, "%s{class=%q} %s\n", name, class, value(s.queues[class]))
+		}
+	}
+	metric("drone_runner_dlite_queue_depth", "gauge", "Number of the tasks of the class waiting for a worker.",
+		func(q *taskQueue) string { return fmt.Sprint(len(q.tasks)) })
+	metric("drone_runner_dlite_queue_capacity", "gauge", "Number of the tasks of the class the queue holds.",
+		func(q *taskQueue) string { return fmt.Sprint(cap(q.tasks)) })
+	metric("drone_runner_dlite_running_tasks", "gauge", "Number of the tasks of the class being executed.",
+		func(q *taskQueue) string { return fmt.Sprint(q.running.Load()) })
+	metric("drone_runner_dlite_workers", "gauge", "Number of the workers dedicated to the class.",
+		func(q *taskQueue) string { return fmt.Sprint(q.workers) })
+	metric("drone_runner_dlite_tasks_total", "counter", "Number of the tasks of the class executed.",
+		func(q *taskQueue) string { return fmt.Sprint(q.done.Load()) })
+	metric("drone_runner_dlite_deferred_tasks_total", "counter", "Number of the task events of the class left for the next poll as the queue was full.",
+		func(q *taskQueue) string { return fmt.Sprint(q.deferred.Load()) })
+	metric("drone_runner_dlite_queue_wait_seconds_total", "counter", "Total time the tasks of the class waited for a worker.",
+		func(q *taskQueue) string { return fmt.Sprint(time.Duration(q.waitNs.Load()).Seconds()) })
+	fmt.Fprintf(&b, "# HELP drone_runner_dlite_shared_workers Number of the workers serving every class.\n"+
+		"# TYPE drone_runner_dlite_shared_workers gauge\ndrone_runner_dlite_shared_workers %d\n", s.shared)
+	return b.String()
+}
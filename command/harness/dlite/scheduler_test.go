@@ -0,0 +1,157 @@
+package dlite
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/wings-software/dlite/client"
+	"github.com/wings-software/dlite/poller"
+	"github.com/wings-software/dlite/router"
+	"github.com/wings-software/dlite/task"
+)
+
+// fakeClient hands out its events on every poll, the tasks are removed once their status is sent.
+type fakeClient struct {
+	client.Client
+
+	mu        sync.Mutex
+	events    []*client.TaskEvent
+	sent      []string
+	responses map[string]string
+}
+
+func (c *fakeClient) GetTaskEvents(context.Context, string) (*client.TaskEventsResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &client.TaskEventsResponse{TaskEvents: append([]*client.TaskEvent(nil), c.events...)}, nil
+}
+
+func (c *fakeClient) Acquire(_ context.Context, _, taskID string) (*client.Task, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ev := range c.events {
+		if ev.TaskID == taskID {
+			return &client.Task{ID: taskID, Type: ev.TaskType, Data: []byte("{}")}, nil
+		}
+	}
+	return nil, context.Canceled
+}
+
+func (c *fakeClient) SendStatus(_ context.Context, _, taskID string, resp *client.TaskResponse) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, taskID)
+	if c.responses == nil {
+		c.responses = map[string]string{}
+	}
+	c.responses[taskID] = string(resp.Data)
+	for i, ev := range c.events {
+		if ev.TaskID == taskID {
+			c.events = append(c.events[:i], c.events[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (c *fakeClient) sentTasks() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.sent...)
+}
+
+func testEnv(shared, destroyWorkers, queueSize int) *config.EnvConfig {
+	env := &config.EnvConfig{}
+	env.Dlite.PollIntervalMilliSecs = 10
+	env.Dlite.PollConcurrency = 1
+	env.Dlite.ParallelWorkers = shared
+	env.Dlite.DestroyWorkers = destroyWorkers
+	env.Dlite.QueueSize = queueSize
+	return env
+}
+
+func TestSchedulerEnqueue(t *testing.T) {
+	s := newScheduler(&poller.Poller{}, "delegate", testEnv(1, 0, 1), nil)
+	s.enqueue(&client.TaskEvent{TaskID: "step-1", TaskType: executeTask})
+	s.enqueue(&client.TaskEvent{TaskID: "step-1", TaskType: executeTask})
+	s.enqueue(&client.TaskEvent{TaskID: "step-2", TaskType: executeTask})
+	s.enqueue(&client.TaskEvent{TaskID: "destroy-1", TaskType: cleanupTask})
+
+	if step := s.queues[classStep]; len(step.tasks) != 1 || step.deferred.Load() != 1 {
+		t.Errorf("expected the queued step and the deferred one, got %d queued and %d deferred", len(step.tasks), step.deferred.Load())
+	}
+	if len(s.queues[classDestroy].tasks) != 1 {
+		t.Error("expected the destroy to be queued while the queue of the steps is full")
+	}
+	// the deferred event is queued again by a later poll
+	if _, seen := s.seen.Load("step-2"); seen {
+		t.Error("expected the deferred task to be polled again")
+	}
+
+	// the shared workers serve the classes in turn
+	s = newScheduler(&poller.Poller{}, "delegate", testEnv(1, 0, 10), nil)
+	for _, id := range []string{"step-1", "step-2", "step-3"} {
+		s.enqueue(&client.TaskEvent{TaskID: id, TaskType: executeTask})
+	}
+	s.enqueue(&client.TaskEvent{TaskID: "destroy-1", TaskType: cleanupTask})
+	var order []string
+	for i := 0; i < 4; i++ {
+		_, task, _ := s.take(context.Background())
+		order = append(order, task.event.TaskID)
+	}
+	if got := strings.Join(order, ","); got != "step-1,destroy-1,step-2,step-3" {
+		t.Errorf("expected the destroy to be served in turn, got %s", got)
+	}
+}
+
+func TestSchedulerDestroyNotStarved(t *testing.T) {
+	release := make(chan struct{})
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}
+	c := &fakeClient{events: []*client.TaskEvent{
+		{TaskID: "step-1", TaskType: executeTask},
+		{TaskID: "step-2", TaskType: executeTask},
+		{TaskID: "destroy-1", TaskType: cleanupTask},
+	}}
+	r := router.NewRouter(map[string]task.Handler{
+		executeTask: http.HandlerFunc(handler),
+		cleanupTask: http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+	})
+	// the shared worker is taken by a step, the destroy gets the dedicated worker
+	s := newScheduler(poller.New("account", "secret", "runner", nil, c, r), "delegate", testEnv(1, 1, 10), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = s.run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && len(c.sentTasks()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sent := c.sentTasks(); len(sent) != 1 || sent[0] != "destroy-1" {
+		t.Fatalf("expected the destroy to complete while the steps run, got %q", sent)
+	}
+	if running := s.queues[classStep].running.Load(); running != 1 {
+		t.Errorf("expected a step running on the shared worker, got %d", running)
+	}
+	if !strings.Contains(s.metrics(), `drone_runner_dlite_tasks_total{class="destroy"} 1`) {
+		t.Errorf("expected the destroy in the metrics, got:\n%s", s.metrics())
+	}
+	close(release)
+}
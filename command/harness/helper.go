@@ -1,27 +1,68 @@
 package harness
 
 import (
+	"context"
 	"hash/fnv"
+	"io"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/logsink"
 	leapi "github.com/harness/lite-engine/api"
-	lelivelog "github.com/harness/lite-engine/livelog"
-	lestream "github.com/harness/lite-engine/logstream/remote"
-	"github.com/sirupsen/logrus"
 )
 
-func getStreamLogger(cfg leapi.LogConfig, logKey, correlationID string) *lelivelog.Writer {
-	client := lestream.NewHTTPClient(cfg.URL, cfg.AccountID,
-		cfg.Token, cfg.IndirectUpload, false)
-	wc := lelivelog.New(client, logKey, correlationID, nil)
-	go func() {
-		if err := wc.Open(); err != nil {
-			logrus.WithError(err).Debugln("failed to open log stream")
+var (
+	logSink     logsink.Sink
+	logSinkErr  error
+	logSinkOnce sync.Once
+)
+
+// runnerLogSink returns the sink of the setup logs configured on the runner, it is created once.
+func runnerLogSink(ctx context.Context, env *config.EnvConfig) (logsink.Sink, error) {
+	logSinkOnce.Do(func() {
+		logSink, logSinkErr = logsink.New(ctx, &logsink.Config{
+			Type:   env.LogSink.Type,
+			Path:   env.LogSink.Path,
+			Bucket: env.LogSink.Bucket,
+			Prefix: env.LogSink.Prefix,
+			Region: env.LogSink.Region,
+			URL:    env.LogSink.URL,
+			Labels: env.LogSink.Labels,
+			Batch:  batchOptions(env),
+		})
+	})
+	return logSink, logSinkErr
+}
+
+func batchOptions(env *config.EnvConfig) logsink.BatchOptions {
+	return logsink.BatchOptions{
+		FlushInterval: time.Duration(env.LogSink.FlushIntervalMs) * time.Millisecond,
+		BatchSize:     env.LogSink.BatchSize,
+		MaxInFlight:   env.LogSink.MaxInFlight,
+		MaxBuffered:   env.LogSink.MaxBuffered,
+		Gzip:          env.LogSink.Gzip,
+	}
+}
+
+// getStreamLogger returns the writer of the setup logs, streamed to the Harness log service if
+// the request has a log config and to the sink of the runner otherwise. It returns nil if the logs
+// go to the stdout of the runner.
+func getStreamLogger(ctx context.Context, env *config.EnvConfig, cfg leapi.LogConfig, logKey, correlationID string) (io.WriteCloser, error) {
+	sink := logsink.NewHarness(cfg, batchOptions(env))
+	if cfg.URL == "" {
+		if env.LogSink.Type == "" || env.LogSink.Type == logsink.TypeStdout {
+			return nil, nil
+		}
+		var err error
+		if sink, err = runnerLogSink(ctx, env); err != nil {
+			return nil, err
 		}
-	}()
-	return wc
+	}
+	return sink.Open(ctx, logKey, correlationID)
 }
 
 // generate a id from the filename
@@ -0,0 +1,94 @@
+package harness
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	ierrors "github.com/drone-runners/drone-runner-aws/internal/types"
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+	lehttp "github.com/harness/lite-engine/cli/client"
+
+	"github.com/sirupsen/logrus"
+)
+
+// requester of the lease extensions of the lite-engine heartbeat, see leaseHeartbeat.
+const heartbeatRequester = "lite-engine-heartbeat"
+
+// ExtendLeaseRequest keeps the instance of a stage from being purged past its max age.
+type ExtendLeaseRequest struct {
+	StageRuntimeID string `json:"id"`
+	DurationMins   int64  `json:"duration_mins"`
+	RequestedBy    string `json:"requested_by"` // recorded in the audit log
+	AccountID      string `json:"account_id"`   // the instances of the other accounts are not found
+}
+
+type ExtendLeaseResponse struct {
+	InstanceID   string `json:"instance_id"`
+	LeaseExpires int64  `json:"lease_expires"` // unix time
+}
+
+func HandleExtendLease(ctx context.Context, r *ExtendLeaseRequest, s store.StageOwnerStore, poolManager *drivers.Manager) (*ExtendLeaseResponse, error) {
+	if r.StageRuntimeID == "" {
+		return nil, ierrors.NewBadRequestError("mandatory field 'id' in the request body is empty")
+	}
+	ctx = drivers.WithAccountID(ctx, r.AccountID)
+	entity, err := s.Find(ctx, r.StageRuntimeID)
+	if err != nil || entity == nil {
+		return nil, ierrors.NewNotFoundError(fmt.Sprintf("failed to find the stage owner entity for stage: %s", r.StageRuntimeID))
+	}
+	inst, err := poolManager.GetInstanceByStageID(ctx, entity.PoolName, r.StageRuntimeID)
+	if err != nil {
+		return nil, ierrors.NewNotFoundError(fmt.Sprintf("no running instance found for stage: %s", r.StageRuntimeID))
+	}
+	requester := r.RequestedBy
+	if requester == "" {
+		requester = "api"
+	}
+	expires, err := poolManager.ExtendLease(ctx, entity.PoolName, inst.ID, time.Duration(r.DurationMins)*time.Minute, requester)
+	if err != nil {
+		return nil, err
+	}
+	return &ExtendLeaseResponse{InstanceID: inst.ID, LeaseExpires: expires.Unix()}, nil
+}
+
+// leaseHeartbeat extends the lease of the instance running a step while its lite-engine is healthy,
+// once the instance nears its busy max age. It returns when the context is done.
+func leaseHeartbeat(ctx context.Context, client lehttp.Client, inst *types.Instance, env *config.EnvConfig, poolManager *drivers.Manager) {
+	interval := time.Minute * time.Duration(env.Settings.LeaseHeartbeatMins)
+	if interval <= 0 {
+		return
+	}
+	maxAge := time.Hour * time.Duration(env.Settings.BusyMaxAge)
+	logr := logrus.
+		WithField("instance_id", inst.ID).
+		WithField("stage_runtime_id", inst.Stage).
+		WithField("pool", inst.Pool)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// a missed heartbeat leaves the instance leased until the next one
+		if time.Since(time.Unix(inst.Started, 0)) < maxAge-2*interval {
+			continue
+		}
+		if _, err := client.Health(ctx); err != nil {
+			logr.WithError(err).Warnln("lease heartbeat: the lite-engine is not healthy, the lease is not extended")
+			continue
+		}
+		if _, err := poolManager.ExtendLease(ctx, inst.Pool, inst.ID, 2*interval, heartbeatRequester); err != nil {
+			logr.WithError(err).Warnln("lease heartbeat: failed to extend the lease")
+			if _, ok := err.(*ierrors.BadRequestError); ok {
+				return
+			}
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/logformat"
 	"github.com/drone/runner-go/logger"
 	"github.com/sirupsen/logrus"
 )
@@ -14,7 +15,7 @@ import (
 type OutputSplitter struct{}
 
 func (splitter *OutputSplitter) Write(p []byte) (n int, err error) {
-	if bytes.Contains(p, []byte("level=error")) {
+	if bytes.Contains(p, []byte("level=error")) || bytes.Contains(p, []byte(`"level":"error"`)) {
 		return os.Stderr.Write(p)
 	}
 	return os.Stdout.Write(p)
@@ -24,6 +25,9 @@ func (splitter *OutputSplitter) Write(p []byte) (n int, err error) {
 // the loaded configuration.
 func SetupLogger(c *config.EnvConfig) {
 	logrus.SetOutput(&OutputSplitter{})
+	if c.LogFormat == logformat.FormatJSON {
+		logrus.SetFormatter(logformat.NewJSON())
+	}
 	logger.Default = logger.Logrus(
 		logrus.NewEntry(
 			logrus.StandardLogger(),
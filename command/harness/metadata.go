@@ -0,0 +1,66 @@
+package harness
+
+import (
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// The environment variables of the steps describing the instance they run on, so the builds can
+// adapt to it, e.g. pick the mirrors of the region, and tag their telemetry.
+const (
+	instanceIDVar        = "HARNESS_INSTANCE_ID"
+	instancePoolVar      = "HARNESS_INSTANCE_POOL"
+	instanceProviderVar  = "HARNESS_INSTANCE_PROVIDER"
+	instanceRegionVar    = "HARNESS_INSTANCE_REGION"
+	instanceZoneVar      = "HARNESS_INSTANCE_ZONE"
+	instanceTypeVar      = "HARNESS_INSTANCE_TYPE"
+	instanceLifecycleVar = "HARNESS_INSTANCE_LIFECYCLE"
+)
+
+// Lifecycles of the instances.
+const (
+	lifecycleSpot     = "spot"
+	lifecycleOnDemand = "on-demand"
+)
+
+// setInstanceEnvs passes the metadata of the instance to the step. The variables set by the step
+// itself are kept.
+func setInstanceEnvs(r *ExecuteVMRequest, inst *types.Instance, poolManager *drivers.Manager) {
+	envs := instanceEnvs(inst, poolManager.InstanceCapabilities(inst).Spot)
+	if r.StartStepRequest.Envs == nil {
+		r.StartStepRequest.Envs = make(map[string]string)
+	}
+	for k, v := range envs {
+		if _, ok := r.StartStepRequest.Envs[k]; !ok {
+			r.StartStepRequest.Envs[k] = v
+		}
+	}
+}
+
+// instanceEnvs returns the variables of the metadata of the instance, the metadata the driver of
+// the instance does not set is left out. The ID is the one of the provider, e.g. the EC2 instance id.
+func instanceEnvs(inst *types.Instance, spot bool) map[string]string {
+	id := inst.ProviderID
+	if id == "" {
+		id = inst.ID
+	}
+	lifecycle := lifecycleOnDemand
+	if spot {
+		lifecycle = lifecycleSpot
+	}
+	envs := map[string]string{}
+	for k, v := range map[string]string{
+		instanceIDVar:        id,
+		instancePoolVar:      inst.Pool,
+		instanceProviderVar:  string(inst.Provider),
+		instanceRegionVar:    inst.Region,
+		instanceZoneVar:      inst.Zone,
+		instanceTypeVar:      inst.Size,
+		instanceLifecycleVar: lifecycle,
+	} {
+		if v != "" {
+			envs[k] = v
+		}
+	}
+	return envs
+}
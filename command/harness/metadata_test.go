@@ -0,0 +1,35 @@
+package harness
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestInstanceEnvs(t *testing.T) {
+	inst := &types.Instance{ID: "runner-linux-1", ProviderID: "i-0abc", Pool: "linux", Provider: types.Amazon,
+		Region: "us-east-2", Zone: "us-east-2a", Size: "t3.large"}
+	want := map[string]string{
+		"HARNESS_INSTANCE_ID":        "i-0abc",
+		"HARNESS_INSTANCE_POOL":      "linux",
+		"HARNESS_INSTANCE_PROVIDER":  "amazon",
+		"HARNESS_INSTANCE_REGION":    "us-east-2",
+		"HARNESS_INSTANCE_ZONE":      "us-east-2a",
+		"HARNESS_INSTANCE_TYPE":      "t3.large",
+		"HARNESS_INSTANCE_LIFECYCLE": "spot",
+	}
+	if diff := cmp.Diff(want, instanceEnvs(inst, true)); diff != "" {
+		t.Errorf("unexpected variables (-want +got):\n%s", diff)
+	}
+
+	// the metadata the driver does not set is left out
+	want = map[string]string{
+		"HARNESS_INSTANCE_ID":        "vm-1",
+		"HARNESS_INSTANCE_POOL":      "macos",
+		"HARNESS_INSTANCE_LIFECYCLE": "on-demand",
+	}
+	if diff := cmp.Diff(want, instanceEnvs(&types.Instance{ID: "vm-1", Pool: "macos"}, false)); diff != "" {
+		t.Errorf("unexpected variables (-want +got):\n%s", diff)
+	}
+}
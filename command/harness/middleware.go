@@ -24,8 +24,8 @@ func Middleware(next http.Handler) http.Handler {
 			WithField("status", status).
 			WithField("dur[ms]", dur)
 		logLine := "HTTP: " + r.Method + " " + r.URL.RequestURI()
-		// Avoid logging health checks to avoid spamming the logs
-		if strings.Contains(r.URL.RequestURI(), "healthz") {
+		// Avoid logging health checks and metrics scrapes to avoid spamming the logs
+		if uri := r.URL.RequestURI(); strings.Contains(uri, "healthz") || strings.Contains(uri, "readyz") || strings.Contains(uri, "/metrics") {
 			return
 		}
 		if status >= http.StatusInternalServerError {
@@ -2,18 +2,27 @@ package harness
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/drone-runners/drone-runner-aws/command/config"
 	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/internal/kubernetes"
+	"github.com/drone-runners/drone-runner-aws/internal/leader"
 	"github.com/drone-runners/drone-runner-aws/internal/poolfile"
+	ierrors "github.com/drone-runners/drone-runner-aws/internal/types"
 	"github.com/sirupsen/logrus"
 )
 
 func SetupPool(ctx context.Context, env *config.EnvConfig, poolManager *drivers.Manager, poolFile string) (*config.PoolFile, error) {
-	configPool, confErr := poolfile.ConfigPoolFile(poolFile, env)
-	if confErr != nil {
-		logrus.WithError(confErr).Fatalln("Unable to load pool file, or use an in memory pool")
+	configPool := &config.PoolFile{}
+	// with kubernetes the pools can be defined as resources only.
+	if poolFile != "" || !env.Kubernetes.Pools {
+		var confErr error
+		configPool, confErr = poolfile.ConfigPoolFile(poolFile, env)
+		if confErr != nil {
+			logrus.WithError(confErr).Fatalln("Unable to load pool file, or use an in memory pool")
+		}
 	}
 
 	pools, err := poolfile.ProcessPool(configPool, env.Runner.Name)
@@ -28,6 +37,8 @@ func SetupPool(ctx context.Context, env *config.EnvConfig, poolManager *drivers.
 		return configPool, err
 	}
 
+	poolManager.SetRoutes(poolfile.ProcessRoutes(configPool))
+
 	err = poolManager.PingDriver(ctx)
 	if err != nil {
 		logrus.WithError(err).
@@ -42,6 +53,65 @@ func SetupPool(ctx context.Context, env *config.EnvConfig, poolManager *drivers.
 		return configPool, err
 	}
 
+	err = poolManager.StartTunnel(ctx, env)
+	if err != nil {
+		logrus.WithError(err).
+			Errorln("unable to start the tunnel server")
+		return configPool, err
+	}
+
+	err = poolManager.StartKeyServer(ctx, env)
+	if err != nil {
+		logrus.WithError(err).
+			Errorln("unable to start the key server")
+		return configPool, err
+	}
+
+	err = poolManager.SetupDNS(ctx, env)
+	if err != nil {
+		logrus.WithError(err).
+			Errorln("unable to set up the dns registration")
+		return configPool, err
+	}
+
+	poolManager.SetupAlerts(ctx, env)
+
+	err = poolManager.SetupEvents(ctx, env)
+	if err != nil {
+		logrus.WithError(err).
+			Errorln("unable to set up the events bus")
+		return configPool, err
+	}
+
+	_, err = runnerLogSink(ctx, env)
+	if err != nil {
+		logrus.WithError(err).
+			Errorln("unable to setup the log sink")
+		return configPool, err
+	}
+
+	// with multiple replicas the background jobs run on the leader only.
+	var elector *leader.Elector
+	switch env.HA.Mode {
+	case "":
+	case leader.ModeFollower:
+		poolManager.SetLeader(func() bool { return false })
+		logrus.Infoln("running as a follower, the background jobs are left to the leader")
+	case leader.ModeElection:
+		if env.Database.Driver != "postgres" {
+			return configPool, fmt.Errorf("leader election requires the postgres database, got %s", env.Database.Driver)
+		}
+		elector, err = leader.New(env.Database.Datasource, env.HA.LockID, time.Second*time.Duration(env.HA.ElectionIntervalS))
+		if err != nil {
+			logrus.WithError(err).
+				Errorln("unable to setup leader election")
+			return configPool, err
+		}
+		poolManager.SetLeader(elector.IsLeader)
+	default:
+		return configPool, fmt.Errorf("unknown high availability mode %q", env.HA.Mode)
+	}
+
 	// setup lifetimes of instances
 	busyMaxAge := time.Hour * time.Duration(env.Settings.BusyMaxAge) // includes time required to setup an instance
 	freeMaxAge := time.Hour * time.Duration(env.Settings.FreeMaxAge)
@@ -51,6 +121,47 @@ func SetupPool(ctx context.Context, env *config.EnvConfig, poolManager *drivers.
 			Errorln("failed to start instance purger")
 		return configPool, err
 	}
+	poolManager.StartDestroyRetrier(ctx)
+	poolManager.StartJanitor(ctx, time.Minute*time.Duration(env.Settings.JanitorIntervalMins))
+	poolManager.StartScheduler(ctx)
+	poolManager.StartMaintenanceWatcher(ctx, time.Second*time.Duration(env.Settings.MaintenanceCheckSecs))
+	if !env.LiteEngine.EnableMock {
+		poolManager.StartZombieDetector(ctx, time.Second*time.Duration(env.Settings.ZombieProbeSecs),
+			env.Settings.ZombieProbeFailures, env.Settings.ZombieRecycle)
+	}
+	poolManager.StartImagePrefetcher(ctx, time.Minute*time.Duration(env.Settings.ImagePrefetchIntervalMins))
+	poolManager.StartImageResolver(ctx, time.Minute*time.Duration(env.Settings.ImageResolveIntervalMins))
+	poolManager.StartClaimReleaser(ctx, time.Minute*time.Duration(env.Settings.ClaimTTLMins))
+	poolManager.StartPoolMappingReloader(ctx, time.Second*time.Duration(env.Settings.PoolMappingReloadSecs))
+	if env.HA.Mode != "" {
+		// the instances are shared by the replicas, the startup cleanup is left to the elected leader.
+		// The busy instances might be running the stages of the other replicas, only the free ones are removed.
+		if elector != nil {
+			go elector.Run(ctx, func(ctx context.Context) {
+				if !env.Settings.ReusePool {
+					if cleanErr := poolManager.CleanPools(ctx, false, true); cleanErr != nil {
+						logrus.WithError(cleanErr).
+							Errorln("unable to clean pools")
+					} else {
+						logrus.Infoln("pools cleaned")
+					}
+				}
+				if preflightErr := poolManager.Preflight(ctx); preflightErr != nil {
+					logrus.WithError(preflightErr).
+						Errorln("pool preflight failed")
+					return
+				}
+				if buildPoolErr := poolManager.BuildPools(ctx); buildPoolErr != nil {
+					logrus.WithError(buildPoolErr).
+						Errorln("unable to build pool")
+					return
+				}
+				logrus.Infoln("pool created")
+			})
+		}
+		return configPool, startPoolController(ctx, env, poolManager)
+	}
+
 	// lets remove any old instances.
 	if !env.Settings.ReusePool {
 		cleanErr := poolManager.CleanPools(ctx, true, true)
@@ -59,6 +170,13 @@ func SetupPool(ctx context.Context, env *config.EnvConfig, poolManager *drivers.
 		}
 		logrus.Infoln("pools cleaned")
 	}
+	// check the runner reaches the instances before seeding the pools.
+	preflightErr := poolManager.Preflight(ctx)
+	if preflightErr != nil {
+		logrus.WithError(preflightErr).
+			Errorln("pool preflight failed")
+		return configPool, preflightErr
+	}
 	// seed pools
 	buildPoolErr := poolManager.BuildPools(ctx)
 	if buildPoolErr != nil {
@@ -67,11 +185,27 @@ func SetupPool(ctx context.Context, env *config.EnvConfig, poolManager *drivers.
 		return configPool, buildPoolErr
 	}
 	logrus.Infoln("pool created")
-	return configPool, nil
+	return configPool, startPoolController(ctx, env, poolManager)
+}
+
+// startPoolController starts watching the pools defined as kubernetes resources, if enabled.
+func startPoolController(ctx context.Context, env *config.EnvConfig, poolManager *drivers.Manager) error {
+	if !env.Kubernetes.Pools {
+		return nil
+	}
+	controller, err := kubernetes.New(env.Kubernetes.Namespace, env.Runner.Name, poolManager)
+	if err != nil {
+		logrus.WithError(err).
+			Errorln("unable to start the kubernetes pool controller")
+		return err
+	}
+	go controller.Run(ctx)
+	logrus.Infoln("kubernetes pool controller started")
+	return nil
 }
 
 func Cleanup(env *config.EnvConfig, poolManager *drivers.Manager) error {
-	if env.Settings.ReusePool {
+	if env.Settings.ReusePool || env.HA.Mode != "" {
 		return nil
 	}
 
@@ -84,3 +218,50 @@ func Cleanup(env *config.EnvConfig, poolManager *drivers.Manager) error {
 
 	return cleanErr
 }
+
+// pool deletion statuses
+const (
+	PoolDraining = "draining"
+	PoolDeleted  = "deleted"
+)
+
+// DeletePoolRequest removes a pool from the runner, with drain the busy instances are waited for.
+type DeletePoolRequest struct {
+	PoolID      string
+	Drain       bool
+	TimeoutMins int64 // defaults to the drain timeout of the runner
+}
+
+type DeletePoolResponse struct {
+	PoolID string `json:"pool_id"`
+	Status string `json:"status"`
+}
+
+// HandleDeletePool deletes the pool. A draining pool is deleted in the background once its busy
+// instances complete, its progress is reported by the status of the pools.
+func HandleDeletePool(ctx context.Context, r *DeletePoolRequest, env *config.EnvConfig, poolManager *drivers.Manager) (*DeletePoolResponse, error) {
+	if !poolManager.Exists(r.PoolID) {
+		return nil, ierrors.NewNotFoundError(fmt.Sprintf("pool %q not found", r.PoolID))
+	}
+	if poolManager.Draining(r.PoolID) {
+		return nil, ierrors.NewBadRequestError(fmt.Sprintf("pool %q is already being deleted", r.PoolID))
+	}
+	timeout := time.Minute * time.Duration(r.TimeoutMins)
+	if r.TimeoutMins <= 0 {
+		timeout = time.Minute * time.Duration(env.Settings.DrainTimeoutMins)
+	}
+
+	if !r.Drain {
+		if err := poolManager.DeletePool(ctx, r.PoolID, false, 0); err != nil {
+			return nil, err
+		}
+		return &DeletePoolResponse{PoolID: r.PoolID, Status: PoolDeleted}, nil
+	}
+
+	go func() {
+		if err := poolManager.DeletePool(context.Background(), r.PoolID, true, timeout); err != nil {
+			logrus.WithError(err).WithField("pool", r.PoolID).Errorln("failed to delete the draining pool")
+		}
+	}()
+	return &DeletePoolResponse{PoolID: r.PoolID, Status: PoolDraining}, nil
+}
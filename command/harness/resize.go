@@ -0,0 +1,82 @@
+package harness
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/internal/events"
+	"github.com/drone-runners/drone-runner-aws/internal/oshelp"
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// resizeStage hands the instance of the failed attempt of the stage over to the new attempt, resized
+// in place to the machine type of the resource class of the request. The failed attempt is completed
+// as if it was destroyed. Its instance is destroyed if it can't be resized, the new attempt is set up
+// on a new instance then.
+func resizeStage(ctx context.Context, r *SetupVMRequest, s store.StageOwnerStore, poolManager *drivers.Manager) (*types.Instance, string, error) {
+	entity, err := s.Find(ctx, r.ResizeStageID)
+	if err != nil || entity == nil {
+		return nil, "", fmt.Errorf("failed to find the stage owner entity of the failed attempt %s: %w", r.ResizeStageID, err)
+	}
+	pool := entity.PoolName
+	if !poolManager.Resizable(pool, r.ResourceClass) {
+		destroyFailedAttempt(ctx, r, pool, s, poolManager)
+		return nil, "", fmt.Errorf("the instances of the pool %s can't be resized to the resource class %q: %w", pool, r.ResourceClass, drivers.ErrNotSupported)
+	}
+	// the workspace of the isolated steps is handed off with a shell script
+	if r.IsolateSteps && poolManager.Platform(pool).OS == oshelp.OSWindows {
+		destroyFailedAttempt(ctx, r, pool, s, poolManager)
+		return nil, "", fmt.Errorf("the instances of the windows pool %s can't run isolated steps", pool)
+	}
+	inst, err := poolManager.GetInstanceByStageID(ctx, pool, r.ResizeStageID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to find the instance of the failed attempt %s: %w", r.ResizeStageID, err)
+	}
+	// the failed attempt might have run on a trusted instance, it's never handed over to a fork PR.
+	if r.ForkPR && !inst.Untrusted {
+		destroyFailedAttempt(ctx, r, pool, s, poolManager)
+		return nil, "", fmt.Errorf("the instance of the failed attempt %s is not hardened for the fork PR builds", r.ResizeStageID)
+	}
+
+	// the failed attempt is over, its instance is kept
+	usage := diskMonitor().Stop(r.ResizeStageID)
+	cacheState().Save(ctx, r.ResizeStageID, inst)
+	poolManager.CompleteStage(ctx, r.ResizeStageID, usage)
+	e := events.ForInstance(events.StageCompleted, inst)
+	e.StageRuntimeID = r.ResizeStageID
+	poolManager.Publish(e)
+	envState().Delete(r.ResizeStageID)
+	stageLogs().Delete(r.ResizeStageID)
+	stepIsolation().Delete(r.ResizeStageID, poolManager)
+	if err = s.Delete(ctx, r.ResizeStageID); err != nil {
+		logrus.WithError(err).WithField("stage_runtime_id", r.ResizeStageID).Errorln("resize: failed to delete the stage owner entity of the failed attempt")
+	}
+
+	if err = s.Create(ctx, &types.StageOwner{StageID: r.ID, PoolName: pool}); err != nil {
+		return nil, "", fmt.Errorf("could not create stage owner entity: %w", err)
+	}
+	if inst, err = poolManager.Resize(ctx, pool, inst.ID, r.ResourceClass); err != nil {
+		if derr := s.Delete(context.Background(), r.ID); derr != nil {
+			logrus.WithError(derr).WithField("stage_runtime_id", r.ID).Errorln("resize: could not remove stage ID mapping after the resize failure")
+		}
+		return nil, "", err
+	}
+	return inst, pool, nil
+}
+
+// destroyFailedAttempt destroys the instance of the failed attempt which is not handed over to the new attempt.
+func destroyFailedAttempt(ctx context.Context, r *SetupVMRequest, pool string, s store.StageOwnerStore, poolManager *drivers.Manager) {
+	_, err := HandleDestroy(ctx, &VMCleanupRequest{
+		PoolID:         pool,
+		StageRuntimeID: r.ResizeStageID,
+		Async:          true,
+		AccountID:      r.SetupRequest.LogConfig.AccountID,
+	}, s, poolManager)
+	if err != nil {
+		logrus.WithError(err).WithField("stage_runtime_id", r.ResizeStageID).Errorln("resize: could not destroy the instance of the failed attempt")
+	}
+}
@@ -0,0 +1,57 @@
+package harness
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// resizingDriver resizes the instances in place, it creates none.
+type resizingDriver struct {
+	drivers.Driver
+	resized []string
+}
+
+func (d *resizingDriver) Resize(_ context.Context, inst *types.Instance, _ string) (string, error) {
+	d.resized = append(d.resized, inst.ID)
+	return inst.Address, nil
+}
+
+func TestResizeStage_ForkPRNotHandedTrustedInstance(t *testing.T) {
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	instanceStore := ldb.NewInstanceStore(db)
+	err = instanceStore.Create(ctx, &types.Instance{ID: "trusted", Pool: "linux", State: types.StateInUse, Stage: "failed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver := &resizingDriver{}
+	poolManager := drivers.New(ctx, instanceStore, &config.EnvConfig{})
+	if err = poolManager.Add(drivers.Pool{Name: "linux", Driver: driver, Sizes: map[string]string{"large": "big"}}); err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeStageOwnerStore{owners: map[string]*types.StageOwner{"failed": {StageID: "failed", PoolName: "linux"}}}
+
+	r := &SetupVMRequest{ID: "retry", ResizeStageID: "failed", ResourceClass: "large", ForkPR: true}
+	_, _, err = resizeStage(ctx, r, s, poolManager)
+	if err == nil || !strings.Contains(err.Error(), "not hardened for the fork PR") {
+		t.Fatalf("expected the trusted instance not to be handed over to the fork PR, got %v", err)
+	}
+	if len(driver.resized) != 0 {
+		t.Errorf("expected no instance to be resized, got %v", driver.resized)
+	}
+	if _, ok := s.owners["retry"]; ok {
+		t.Errorf("expected the retry not to own the instance of the failed attempt")
+	}
+}
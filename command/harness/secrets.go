@@ -0,0 +1,68 @@
+package harness
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/secrets"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	secretsResolver     *secrets.Resolver
+	secretsResolverErr  error
+	secretsResolverOnce sync.Once
+)
+
+// stepSecretsResolver returns the resolver of the secrets of the steps, nil if the runner resolves
+// none. It is created once, the secrets are cached across the stages.
+func stepSecretsResolver(env *config.EnvConfig) (*secrets.Resolver, error) {
+	if !env.StepSecrets.Enabled {
+		return nil, nil
+	}
+	secretsResolverOnce.Do(func() {
+		secretsResolver, secretsResolverErr = secrets.NewResolver(env.StepSecrets.Providers,
+			time.Duration(env.StepSecrets.CacheTTLSecs)*time.Second)
+	})
+	return secretsResolver, secretsResolverErr
+}
+
+// resolveStepSecrets resolves the references to the secrets in the environment of the step. The
+// secrets are masked in the logs of the step, and every secret resolved is logged for the audits,
+// without its value.
+func resolveStepSecrets(ctx context.Context, r *ExecuteVMRequest, pool string, env *config.EnvConfig) error {
+	resolver, err := stepSecretsResolver(env)
+	if err != nil || resolver == nil {
+		return err
+	}
+	// the order of the audit logs is stable
+	names := make([]string, 0, len(r.StartStepRequest.Envs))
+	for name := range r.StartStepRequest.Envs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, resolved, resolveErr := resolver.Resolve(ctx, r.StartStepRequest.Envs[name])
+		logr := logrus.WithField("api", "dlite:step").
+			WithField("account_id", r.AccountID).
+			WithField("pool", pool).
+			WithField("stage_runtime_id", r.StageRuntimeID).
+			WithField("step_id", r.StartStepRequest.ID).
+			WithField("env", name)
+		if resolveErr != nil {
+			logr.WithError(resolveErr).Warnln("secrets: failed to resolve a secret of the step")
+			return resolveErr
+		}
+		for _, secret := range resolved {
+			logr.WithField("secret", secret.Ref).Infoln("secrets: resolved a secret of the step")
+			r.StartStepRequest.Secrets = append(r.StartStepRequest.Secrets, secret.Value)
+		}
+		r.StartStepRequest.Envs[name] = value
+	}
+	return nil
+}
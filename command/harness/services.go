@@ -0,0 +1,133 @@
+package harness
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/harness/lite-engine/api"
+	lehttp "github.com/harness/lite-engine/cli/client"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	servicesState *ServiceState
+	servicesOnce  sync.Once
+
+	// serviceStopTimeout bounds the time to stop the services of a stage and to flush their logs.
+	serviceStopTimeout = time.Minute
+)
+
+// ServiceState tracks the service containers started on the instances of the stages, they are
+// stopped before the instances are destroyed.
+type ServiceState struct {
+	mu     sync.Mutex
+	stages map[string]*stageServices
+}
+
+type stageServices struct {
+	client lehttp.Client
+	ids    []string
+}
+
+// Add registers the services started on the instance of the stage through the client.
+func (s *ServiceState) Add(stageRuntimeID string, client lehttp.Client, ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stages[stageRuntimeID] = &stageServices{client: client, ids: ids}
+}
+
+// Stop stops the services of the stage and writes their logs to the runner log. The stage is removed from the state.
+func (s *ServiceState) Stop(ctx context.Context, stageRuntimeID string) error {
+	s.mu.Lock()
+	stage, ok := s.stages[stageRuntimeID]
+	delete(s.stages, stageRuntimeID)
+	s.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return stopServices(ctx, stage.client, stageRuntimeID, stage.ids)
+}
+
+func serviceState() *ServiceState {
+	servicesOnce.Do(func() {
+		servicesState = &ServiceState{
+			mu:     sync.Mutex{},
+			stages: make(map[string]*stageServices),
+		}
+	})
+	return servicesState
+}
+
+// startServices starts the service containers of the pool on the instance as detached steps, on
+// the network of the stage so its steps reach them by name. It returns the step IDs of the started services.
+func startServices(ctx context.Context, client lehttp.Client, services []types.PoolService, setup *api.SetupRequest, instance *types.Instance) ([]string, error) {
+	var ids []string
+	for i := range services {
+		svc := &services[i]
+		if svc.Privileged && instance.Untrusted {
+			logrus.WithField("service", svc.Name).
+				WithField("instance_id", instance.ID).
+				Warnln("skipped the privileged service on the untrusted instance")
+			continue
+		}
+		req := &api.StartStepRequest{
+			ID:           "service-" + svc.Name + "-" + instance.ID,
+			Name:         svc.Name,
+			Kind:         api.Run,
+			Detach:       true,
+			Image:        svc.Image,
+			Envs:         svc.Envs,
+			PortBindings: svc.Ports,
+			Privileged:   svc.Privileged,
+			Network:      setup.Network.ID,
+		}
+		req.Run.Entrypoint, req.Run.Command = svc.Entrypoint, svc.Command
+		if _, err := client.StartStep(ctx, req); err != nil {
+			return ids, fmt.Errorf("failed to start the service %s: %w", svc.Name, err)
+		}
+		ids = append(ids, req.ID)
+	}
+	return ids, nil
+}
+
+// stopServices stops the service containers and flushes their logs. The logs are streamed until the
+// containers exit, the lite-engine stops them with all the containers of the stage.
+func stopServices(ctx context.Context, client lehttp.Client, stageRuntimeID string, ids []string) error {
+	ctx, cancel := context.WithTimeout(ctx, serviceStopTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			logr := logrus.WithField("stage_runtime_id", stageRuntimeID).WithField("service", id)
+			var out bytes.Buffer
+			if err := client.GetStepLogOutput(ctx, &api.StreamOutputRequest{ID: id}, &out); err != nil {
+				logr.WithError(err).Warnln("failed to flush the logs of the service")
+			}
+			for _, line := range strings.Split(strings.TrimRight(out.String(), "\n"), "\n") {
+				if line != "" {
+					logr.Infoln(line)
+				}
+			}
+		}(id)
+	}
+	_, err := client.Destroy(ctx, &api.DestroyRequest{})
+	wg.Wait()
+	if err != nil {
+		return fmt.Errorf("failed to stop the services: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package harness
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/harness/lite-engine/api"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestServices_StoppedOnDestroy(t *testing.T) {
+	var out bytes.Buffer
+	defer logrus.SetOutput(logrus.StandardLogger().Out)
+	logrus.SetOutput(&out)
+
+	client := &fakeClient{
+		response: &api.PollStepResponse{},
+		logs:     map[string]string{"service-redis-instance": "Ready to accept connections\n"},
+	}
+	services := []types.PoolService{
+		{Name: "redis", Image: "redis"},
+		{Name: "docker", Image: "docker:dind", Privileged: true},
+	}
+	instance := &types.Instance{ID: "instance", Untrusted: true}
+
+	ids, err := startServices(context.Background(), client, services, &api.SetupRequest{}, instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != "service-redis-instance" {
+		t.Fatalf("expected the privileged service to be skipped on the untrusted instance, got %v", ids)
+	}
+	serviceState().Add("stage-services", client, ids)
+
+	if err = serviceState().Stop(context.Background(), "stage-services"); err != nil {
+		t.Fatal(err)
+	}
+	if !client.destroyed {
+		t.Errorf("expected the services to be stopped")
+	}
+	if !strings.Contains(out.String(), "Ready to accept connections") {
+		t.Errorf("expected the logs of the service to be flushed, got %q", out.String())
+	}
+
+	// the services are stopped once.
+	client.destroyed = false
+	if err = serviceState().Stop(context.Background(), "stage-services"); err != nil || client.destroyed {
+		t.Errorf("expected the stage to be removed from the state, got error %v destroyed %v", err, client.destroyed)
+	}
+}
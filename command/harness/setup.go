@@ -2,6 +2,7 @@ package harness
 
 import (
 	"context"
+	stderrors "errors"
 	"fmt"
 	"os"
 	"time"
@@ -10,13 +11,17 @@ import (
 
 	"github.com/drone-runners/drone-runner-aws/command/config"
 	"github.com/drone-runners/drone-runner-aws/engine/resource"
+	"github.com/drone-runners/drone-runner-aws/internal/cloudinit"
 	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/internal/events"
+	"github.com/drone-runners/drone-runner-aws/internal/leasetoken"
 	"github.com/drone-runners/drone-runner-aws/internal/lehelper"
 	errors "github.com/drone-runners/drone-runner-aws/internal/types"
 	"github.com/drone-runners/drone-runner-aws/store"
 	"github.com/drone-runners/drone-runner-aws/types"
 	"github.com/drone/runner-go/logger"
 	"github.com/harness/lite-engine/api"
+	lehttp "github.com/harness/lite-engine/cli/client"
 	lespec "github.com/harness/lite-engine/engine/spec"
 
 	"github.com/sirupsen/logrus"
@@ -29,37 +34,105 @@ type SetupVMRequest struct {
 	Tags             map[string]string `json:"tags"`
 	CorrelationID    string            `json:"correlation_id"`
 	LogKey           string            `json:"log_key"`
+	IsolateSteps     bool              `json:"isolate_steps"` // run every step on its own short-lived VM
+	StepIDs          []string          `json:"step_ids"`      // steps for which VMs are provisioned ahead of time
+	ForkPR           bool              `json:"fork_pr"`       // untrusted build, hardened with the untrusted profile of the pool
+	Region           string            `json:"region"`        // region of the caller, preferred by multi-region pools
 	api.SetupRequest `json:"setup_request"`
+
+	// WorkspaceSnapshot is extracted into the workspace before the first step, it overrides the
+	// snapshot of the pool.
+	WorkspaceSnapshot *types.WorkspaceSnapshot `json:"workspace_snapshot,omitempty"`
+	// Cache is restored before the first step and saved at the destroy of the stage.
+	Cache *CacheRequest `json:"cache,omitempty"`
+
+	// Platform and ResourceClass select the pool in the routing table of the pool file if the
+	// pool is not set.
+	Platform      types.Platform `json:"platform"`
+	ResourceClass string         `json:"resource_class"`
+
+	// ResizeStageID is the failed attempt of the stage, e.g. killed for lack of memory, whose instance
+	// is resized in place to the machine type of the resource class in its pool and set up for the
+	// stage. The caller doesn't destroy the failed attempt, the runner destroys its instance if the
+	// instance can't be resized and sets the stage up as usual then.
+	ResizeStageID string `json:"resize_stage_runtime_id,omitempty"`
 }
 
 type SetupVMResponse struct {
-	IPAddress  string `json:"ip_address"`
-	InstanceID string `json:"instance_id"`
+	IPAddress         string `json:"ip_address"`
+	InstanceID        string `json:"instance_id"`
+	ProviderID        string `json:"provider_id,omitempty"` // identifies the instance in the cloud console
+	PoolID            string `json:"pool_id"`               // pool the instance was provisioned from, might be a fallback pool
+	Driver            string `json:"driver"`
+	Region            string `json:"region,omitempty"`
+	Zone              string `json:"zone,omitempty"`
+	MachineType       string `json:"machine_type,omitempty"`
+	Hibernated        bool   `json:"hibernated"`       // the instance was started from hibernation
+	Overflow          bool   `json:"overflow"`         // the instance was created over the max size of the pool
+	Resized           bool   `json:"resized"`          // the instance of the failed attempt was resized for the stage
+	BootDurationMs    int64  `json:"boot_duration_ms"` // time until the lite-engine on the instance was healthy
+	LiteEngineVersion string `json:"lite_engine_version,omitempty"`
+	CacheHit          string `json:"cache_hit,omitempty"` // the key of the cache entry restored
+	// Timings break the setup time down into its phases.
+	Timings *types.ProvisionTimings `json:"timings,omitempty"`
 }
 
 var (
 	setupTimeout = 10 * time.Minute
+	// consoleTimeout bounds the fetch of the console output of an instance which failed to boot.
+	consoleTimeout = 30 * time.Second
 )
 
 func HandleSetup(ctx context.Context, r *SetupVMRequest, s store.StageOwnerStore, env *config.EnvConfig, poolManager *drivers.Manager) (*SetupVMResponse, error) {
+	startTime := time.Now()
 	stageRuntimeID := r.ID
 	if stageRuntimeID == "" {
 		return nil, errors.NewBadRequestError("mandatory field 'id' in the request body is empty")
 	}
 
 	if r.PoolID == "" {
-		return nil, errors.NewBadRequestError("mandatory field 'pool_id' in the request body is empty")
+		pool, fallbacks, ok := poolManager.RoutePool(r.Platform.OS, r.Platform.Arch, r.ResourceClass)
+		if !ok {
+			return nil, errors.NewBadRequestError(fmt.Sprintf("field 'pool_id' in the request body is empty and no route matches the platform %s/%s and the resource class %q",
+				r.Platform.OS, r.Platform.Arch, r.ResourceClass))
+		}
+		logrus.WithField("stage_runtime_id", stageRuntimeID).
+			WithField("platform", r.Platform.OS+"/"+r.Platform.Arch).
+			WithField("resource_class", r.ResourceClass).
+			WithField("pool", pool).
+			Debugln("setup: routed the stage to the pool")
+		r.PoolID = pool
+		if len(r.FallbackPoolIDs) == 0 {
+			r.FallbackPoolIDs = fallbacks
+		}
+	}
+
+	if r.Cache != nil {
+		if err := validateCacheRequest(r.Cache); err != nil {
+			return nil, errors.NewBadRequestError(err.Error())
+		}
 	}
 
+	// the setup is aborted if the stage is cancelled meanwhile, see HandleCancelSetup
+	ctx, setupDone := poolManager.TrackSetup(ctx, stageRuntimeID)
+	defer setupDone()
+
 	// Sets up logger to stream the logs in case log config is set
 	log := logrus.New()
 	var logr *logrus.Entry
-	if r.SetupRequest.LogConfig.URL == "" {
+	wc, sinkErr := getStreamLogger(ctx, env, r.SetupRequest.LogConfig, r.LogKey, r.CorrelationID)
+	if sinkErr != nil {
+		logrus.WithError(sinkErr).Warnln("failed to open the log sink, the setup logs go to stdout")
+	}
+	if wc == nil {
+		// the setup logs are runner logs then, in the format and at the level of the runner
 		log.Out = os.Stdout
+		log.SetFormatter(logrus.StandardLogger().Formatter)
+		log.SetLevel(logrus.GetLevel())
 		logr = log.WithField("api", "dlite:setup").
-			WithField("correlationID", r.CorrelationID)
+			WithField("correlationID", r.CorrelationID).
+			WithField("stage_runtime_id", stageRuntimeID)
 	} else {
-		wc := getStreamLogger(r.SetupRequest.LogConfig, r.LogKey, r.CorrelationID)
 		defer func() {
 			if err := wc.Close(); err != nil {
 				logrus.WithError(err).Debugln("failed to close log stream")
@@ -69,7 +142,11 @@ func HandleSetup(ctx context.Context, r *SetupVMRequest, s store.StageOwnerStore
 		log.Out = wc
 		log.SetLevel(logrus.TraceLevel)
 		logr = log.WithField("stage_runtime_id", stageRuntimeID)
-
+	}
+	if accountID := r.SetupRequest.LogConfig.AccountID; accountID != "" {
+		logr = logr.WithField("account_id", accountID)
+	}
+	if wc != nil {
 		ctx = logger.WithContext(ctx, logger.Logrus(logr))
 	}
 
@@ -91,6 +168,12 @@ func HandleSetup(ctx context.Context, r *SetupVMRequest, s store.StageOwnerStore
 		r.Volumes = append(r.Volumes, &vol)
 	}
 
+	if r.Region != "" {
+		ctx = drivers.WithRegionHint(ctx, r.Region)
+	}
+	ctx = drivers.WithStageID(ctx, stageRuntimeID)
+	ctx = drivers.WithAccountID(ctx, r.SetupRequest.LogConfig.AccountID)
+
 	pools := []string{}
 	pools = append(pools, r.PoolID)
 	pools = append(pools, r.FallbackPoolIDs...)
@@ -101,14 +184,47 @@ func HandleSetup(ctx context.Context, r *SetupVMRequest, s store.StageOwnerStore
 	var instance *types.Instance
 	foundPool := false
 
+	resized := false
+	if r.ResizeStageID != "" {
+		if instance, selectedPool, err = resizeStage(ctx, r, s, poolManager); err != nil {
+			logr.WithError(err).WithField("resize_stage_runtime_id", r.ResizeStageID).
+				Warnln("could not resize the instance of the failed attempt, provisioning an instance")
+			if setupCancelled(ctx) {
+				return nil, fmt.Errorf("could not resize the instance of the failed attempt: %w", drivers.ErrSetupCancelled)
+			}
+		} else {
+			foundPool, resized = true, true
+		}
+	}
+
 	for _, p := range pools {
-		pool := fetchPool(r.SetupRequest.LogConfig.AccountID, p, env.Dlite.PoolMapByAccount)
+		if foundPool {
+			break
+		}
+		pool := poolManager.MapPool(r.SetupRequest.LogConfig.AccountID, p)
 		logr.WithField("pool_id", pool).Traceln("starting the setup process")
 
 		if !poolManager.Exists(pool) {
+			poolErr = fmt.Errorf("pool %q does not exist", pool)
 			logr.WithField("pool_id", pool).Errorln("pool does not exist")
 			continue
 		}
+		// a share of the stages of a pool is set up on the new image of the pool during its rollout
+		pool = poolManager.RolloutTarget(pool)
+
+		// fork PRs must never run on a regular VM, pools without an untrusted profile are skipped.
+		if r.ForkPR && !poolManager.HasUntrustedProfile(pool) {
+			poolErr = fmt.Errorf("pool %q has no untrusted profile for the fork PR builds", pool)
+			logr.WithField("pool_id", pool).Errorln("pool has no untrusted profile, it can't run the fork PR")
+			continue
+		}
+
+		// the workspace of the isolated steps is handed off with a shell script
+		if r.IsolateSteps && poolManager.Platform(pool).OS == oshelp.OSWindows {
+			poolErr = errors.NewBadRequestError(fmt.Sprintf("pool %q is a windows pool, step isolation is not supported on windows", pool))
+			logr.WithField("pool_id", pool).Errorln("step isolation is not supported on windows pools")
+			continue
+		}
 
 		_, findErr := s.Find(ctx, stageRuntimeID)
 		if findErr != nil {
@@ -119,13 +235,20 @@ func HandleSetup(ctx context.Context, r *SetupVMRequest, s store.StageOwnerStore
 			}
 		}
 
-		instance, err = poolManager.Provision(ctx, pool, env.Runner.Name, env)
+		if r.ForkPR {
+			instance, err = poolManager.ProvisionUntrusted(ctx, pool, env.Runner.Name, env)
+		} else {
+			instance, err = poolManager.Provision(ctx, pool, env.Runner.Name, env)
+		}
 		if err != nil {
 			logr.WithError(err).WithField("pool_id", p).Errorln("failed to provision instance")
 			poolErr = err
-			if derr := s.Delete(ctx, stageRuntimeID); derr != nil {
+			if derr := s.Delete(context.Background(), stageRuntimeID); derr != nil {
 				logr.WithField("pool_id", pool).WithError(derr).Errorln("could not remove stage ID mapping after provision failure")
 			}
+			if setupCancelled(ctx) {
+				return nil, fmt.Errorf("could not provision a VM from the pool: %w", drivers.ErrSetupCancelled)
+			}
 			continue
 		}
 		// Successfully provisioned an instance out of the listed pools
@@ -135,6 +258,18 @@ func HandleSetup(ctx context.Context, r *SetupVMRequest, s store.StageOwnerStore
 	}
 
 	if !foundPool {
+		poolManager.Publish(&events.Event{
+			Type:           events.StageSetupFailed,
+			Pool:           r.PoolID,
+			StageRuntimeID: stageRuntimeID,
+			AccountID:      r.SetupRequest.LogConfig.AccountID,
+			Error:          poolErr.Error(),
+		})
+		// the request can't be served by any of the pools, e.g. step isolation on windows pools
+		var badRequest *errors.BadRequestError
+		if stderrors.As(poolErr, &badRequest) {
+			return nil, badRequest
+		}
 		return nil, fmt.Errorf("could not provision a VM from the pool: %w", poolErr)
 	}
 
@@ -143,15 +278,48 @@ func HandleSetup(ctx context.Context, r *SetupVMRequest, s store.StageOwnerStore
 	logr = logr.
 		WithField("ip", instance.Address).
 		WithField("id", instance.ID).
-		WithField("instance_name", instance.Name)
+		WithField("instance_name", instance.Name).
+		WithField("provider_id", instance.ProviderID)
 
 	logr.WithField("selected_pool", selectedPool).WithField("tried_pools", pools).Traceln("successfully provisioned VM in pool")
 
+	// the record of the stage is stored once the setup completes or fails
+	record := &types.StageRecord{
+		StageID:    stageRuntimeID,
+		PoolName:   selectedPool,
+		Driver:     string(instance.Provider),
+		InstanceID: instance.ID,
+		Region:     instance.Region,
+		Size:       instance.Size,
+		Hibernated: instance.IsHibernated,
+		Result:     types.StageSetupFailed,
+		Started:    startTime.Unix(),
+	}
+	defer func() {
+		poolManager.RecordStage(context.Background(), record)
+		e := events.ForInstance(events.StageSetupFailed, instance)
+		if record.Result == types.StageRunning {
+			e.Type = events.StageSetup
+		}
+		e.StageRuntimeID = stageRuntimeID
+		e.AccountID = r.SetupRequest.LogConfig.AccountID
+		poolManager.Publish(e)
+	}()
+
 	// cleanUpFn is a function to terminate the instance if an error occurs later in the handleSetup function
 	cleanUpFn := func(consoleLogs bool) {
-		if consoleLogs {
+		cancelled := setupCancelled(ctx)
+		if cancelled {
+			logr.Infoln("the setup was cancelled, destroying the instance")
+			if derr := s.Delete(context.Background(), stageRuntimeID); derr != nil {
+				logr.WithError(derr).Errorln("could not remove stage ID mapping after the setup was cancelled")
+			}
+		}
+		if consoleLogs && !cancelled {
 			out, logErr := poolManager.InstanceLogs(context.Background(), selectedPool, instance.ID)
-			if logErr != nil {
+			if stderrors.Is(logErr, drivers.ErrNotSupported) {
+				logr.WithError(logErr).Debugln("skipped the console output logs")
+			} else if logErr != nil {
 				logr.WithError(logErr).Errorln("failed to fetch console output logs")
 			} else {
 				logrus.WithField("id", instance.ID).
@@ -164,12 +332,19 @@ func HandleSetup(ctx context.Context, r *SetupVMRequest, s store.StageOwnerStore
 		}
 	}
 
+	timings := instance.Timings
+	if timings == nil {
+		timings = &types.ProvisionTimings{}
+	}
+	hibernated := instance.IsHibernated
 	if instance.IsHibernated {
+		startStart := time.Now()
 		instance, err = poolManager.StartInstance(ctx, selectedPool, instance.ID)
 		if err != nil {
 			go cleanUpFn(false)
 			return nil, fmt.Errorf("failed to start the instance up")
 		}
+		timings.StartMs = time.Since(startStart).Milliseconds()
 	}
 
 	instance.Stage = stageRuntimeID
@@ -186,6 +361,10 @@ func HandleSetup(ctx context.Context, r *SetupVMRequest, s store.StageOwnerStore
 		return nil, fmt.Errorf("failed to add tags to the instance: %w", err)
 	}
 
+	if dnsErr := poolManager.RegisterDNS(ctx, instance); dnsErr != nil {
+		logr.WithError(dnsErr).Warnln("failed to register the instance in dns")
+	}
+
 	client, err := lehelper.GetClient(instance, env.Runner.Name, instance.Port, env.LiteEngine.EnableMock, env.LiteEngine.MockStepTimeoutSecs)
 	if err != nil {
 		go cleanUpFn(false)
@@ -194,10 +373,19 @@ func HandleSetup(ctx context.Context, r *SetupVMRequest, s store.StageOwnerStore
 
 	// try the healthcheck api on the lite-engine until it responds ok
 	logr.Traceln("running healthcheck and waiting for an ok response")
-	if _, err = client.RetryHealth(ctx, setupTimeout); err != nil {
-		go cleanUpFn(true)
+	healthStart := time.Now()
+	client, healthResponse, err := retryHealth(ctx, logr, client, instance, hibernated, bootTimeout(poolManager.Timeouts(selectedPool)),
+		poolManager, leClient(env))
+	if err != nil {
+		if !setupCancelled(ctx) {
+			err = bootFailure(logr, poolManager, selectedPool, instance, err)
+		}
+		go cleanUpFn(false)
 		return nil, fmt.Errorf("failed to call lite-engine retry health: %w", err)
 	}
+	bootDuration := time.Since(startTime)
+	record.HealthCheckMs = time.Since(healthStart).Milliseconds()
+	timings.HealthCheckMs = record.HealthCheckMs
 
 	logr.Traceln("retry health check complete")
 
@@ -207,13 +395,181 @@ func HandleSetup(ctx context.Context, r *SetupVMRequest, s store.StageOwnerStore
 		r.SetupRequest.MountDockerSocket = &b
 	}
 
-	setupResponse, err := client.Setup(ctx, &r.SetupRequest)
+	// Untrusted builds must not get access to the docker daemon of the VM.
+	if instance.Untrusted {
+		b := false
+		r.SetupRequest.MountDockerSocket = &b
+	}
+
+	setupStart := time.Now()
+	setupRequest, leaseKey, err := rotateLeaseKey(&r.SetupRequest, instance)
+	if err != nil {
+		go cleanUpFn(false)
+		return nil, fmt.Errorf("failed to rotate the lease key: %w", err)
+	}
+	setupResponse, err := client.Setup(ctx, setupRequest)
 	if err != nil {
 		go cleanUpFn(true)
 		return nil, fmt.Errorf("failed to call setup lite-engine: %w", err)
 	}
+	if leaseKey != "" {
+		instance.LeaseKey = leaseKey
+		if err = poolManager.Update(ctx, instance); err != nil {
+			go cleanUpFn(true)
+			return nil, fmt.Errorf("failed to store the rotated lease key: %w", err)
+		}
+		if client, err = lehelper.GetClient(instance, env.Runner.Name, instance.Port, env.LiteEngine.EnableMock, env.LiteEngine.MockStepTimeoutSecs); err != nil {
+			go cleanUpFn(true)
+			return nil, fmt.Errorf("failed to create LE client: %w", err)
+		}
+	}
+	serviceIDs, err := startServices(ctx, client, poolManager.Services(selectedPool), &r.SetupRequest, instance)
+	if err != nil {
+		go cleanUpFn(true)
+		return nil, err
+	}
+	serviceState().Add(stageRuntimeID, client, serviceIDs)
+	timings.SetupMs = time.Since(setupStart).Milliseconds()
+
+	// the steps start from an empty workspace if the snapshot can't be restored, they clone then
+	if snapshot := workspaceSnapshot(poolManager.WorkspaceSnapshot(selectedPool), r.WorkspaceSnapshot); snapshot.IsSet() {
+		workspaceStart := time.Now()
+		if wsErr := restoreWorkspace(ctx, client, snapshot, instance); wsErr != nil {
+			logr.WithError(wsErr).WithField("snapshot", snapshot.URL).Warnln("failed to restore the workspace snapshot, the steps start from an empty workspace")
+		} else {
+			timings.WorkspaceMs = time.Since(workspaceStart).Milliseconds()
+			logr.WithField("snapshot", snapshot.URL).Traceln("restored the workspace snapshot")
+		}
+	}
+	cacheHit := setupCache(ctx, logr, client, r, instance, env)
+
+	// the instance runs the stage, it is no longer released once its claim expires
+	if err = poolManager.CompleteClaim(ctx, instance); err != nil {
+		go cleanUpFn(false)
+		return nil, err
+	}
+	poolManager.RecordPhases(selectedPool, timings)
+
+	logr.WithField("response", fmt.Sprintf("%+v", setupResponse)).
+		WithField("timings", fmt.Sprintf("%+v", *timings)).
+		Traceln("VM setup is complete")
+
+	stageLogStream := stageLog{config: r.SetupRequest.LogConfig, key: r.LogKey, correlationID: r.CorrelationID}
+	stageLogs().Add(stageRuntimeID, stageLogStream, env)
+	diskMonitor().Start(stageRuntimeID, instance, stageLogStream, env, poolManager)
+
+	record.BootMs = bootDuration.Milliseconds()
+	record.Result = types.StageRunning
+
+	if r.IsolateSteps {
+		logr.WithField("step_ids", r.StepIDs).Traceln("step isolation enabled, provisioning step VMs")
+		stepIsolation().Add(stageRuntimeID, selectedPool, instance.Untrusted, &r.SetupRequest)
+		for _, stepID := range r.StepIDs {
+			stepIsolation().Prepare(stageRuntimeID, stepID, env, poolManager)
+		}
+	}
 
-	logr.WithField("response", fmt.Sprintf("%+v", setupResponse)).Traceln("VM setup is complete")
+	return &SetupVMResponse{
+		InstanceID:        instance.ID,
+		IPAddress:         instance.Address,
+		ProviderID:        instance.ProviderID,
+		PoolID:            selectedPool,
+		Driver:            string(instance.Provider),
+		Region:            instance.Region,
+		Zone:              instance.Zone,
+		MachineType:       instance.Size,
+		Hibernated:        hibernated,
+		Overflow:          instance.Overflow,
+		Resized:           resized,
+		BootDurationMs:    bootDuration.Milliseconds(),
+		LiteEngineVersion: healthResponse.Version,
+		CacheHit:          cacheHit,
+		Timings:           timings,
+	}, nil
+}
+
+// setupCache restores the cache of the stage on the instance and registers it to save at the
+// destroy unless the entry of its key was restored. It returns the key of the entry restored. The
+// steps start without the cache if it can't be restored.
+func setupCache(ctx context.Context, logr *logrus.Entry, client lehttp.Client, r *SetupVMRequest, instance *types.Instance, env *config.EnvConfig) string {
+	if r.Cache == nil {
+		return ""
+	}
+	logr = logr.WithField("cache_key", r.Cache.Key)
+	c, err := runnerCache(ctx, env)
+	if c == nil {
+		logr.WithError(err).Warnln("cache: no cache is configured on the runner, the cache is skipped")
+		return ""
+	}
+	if !cacheSupported(instance) {
+		logr.WithField("os", instance.Platform.OS).Warnln("cache: the cache is not supported on the instance, the cache is skipped")
+		return ""
+	}
+	hit, err := restoreCache(ctx, client, c, r.Cache, instance, env)
+	if err != nil {
+		logr.WithError(err).Warnln("cache: failed to restore the cache")
+	} else if hit != "" {
+		logr.WithField("cache_hit", hit).Traceln("cache: restored the cache")
+	}
+	if hit != r.Cache.Key {
+		cacheState().Add(r.ID, r.Cache, c, env)
+	}
+	return hit
+}
+
+// setupCancelled returns true if the setup was cancelled, by the stage or by the caller.
+func setupCancelled(ctx context.Context) bool {
+	return stderrors.Is(ctx.Err(), context.Canceled)
+}
 
-	return &SetupVMResponse{InstanceID: instance.ID, IPAddress: instance.Address}, nil
+// rotateLeaseKey returns the setup request writing a new lease key to the key file of the instance, the
+// lite-engine verifies the calls following the setup with it, so the tokens signed for the previous
+// stages are no longer accepted. The request is returned as is if the instance has no lease key.
+func rotateLeaseKey(in *api.SetupRequest, instance *types.Instance) (*api.SetupRequest, string, error) {
+	if instance.LeaseKey == "" {
+		return in, "", nil
+	}
+	key, err := leasetoken.NewKey()
+	if err != nil {
+		return nil, "", err
+	}
+	// the request of the stage is kept as is, the step VMs of the stage are set up with it.
+	out := *in
+	out.Files = append(append([]*lespec.File{}, in.Files...), &lespec.File{
+		Path: leasetoken.KeyFile(instance.Platform.OS),
+		Mode: 0600, //nolint:gomnd
+		Data: key,
+	})
+	return &out, key, nil
+}
+
+// bootTimeout returns how long the lite-engine of an instance of the pool has to become healthy.
+func bootTimeout(t types.Timeouts) time.Duration {
+	if t.BootSecs > 0 {
+		return time.Duration(t.BootSecs) * time.Second
+	}
+	return setupTimeout
+}
+
+// bootFailure returns the health check error of the instance with the reason the startup script
+// or the lite-engine failed, found from the phase markers in the console output of the instance.
+// The console output is logged, the error is returned as is if the boot can't be classified.
+func bootFailure(logr *logrus.Entry, poolManager *drivers.Manager, pool string, instance *types.Instance, err error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), consoleTimeout)
+	defer cancel()
+	out, logErr := poolManager.InstanceLogs(ctx, pool, instance.ID)
+	if stderrors.Is(logErr, drivers.ErrNotSupported) {
+		logr.WithError(logErr).Debugln("skipped the console output logs")
+		return err
+	} else if logErr != nil {
+		logr.WithError(logErr).Errorln("failed to fetch console output logs")
+		return err
+	}
+	logrus.WithField("id", instance.ID).
+		WithField("instance_name", instance.Name).Infof("serial console output: %s", out)
+	if failure := cloudinit.ClassifyBoot(out); failure != nil {
+		logr.WithField("phase", failure.Phase).Warnln(failure.Message)
+		return fmt.Errorf("%w: %s", failure, err)
+	}
+	return err
 }
@@ -0,0 +1,170 @@
+package harness
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/cloudinit"
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	ierrors "github.com/drone-runners/drone-runner-aws/internal/types"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/harness/lite-engine/api"
+	lespec "github.com/harness/lite-engine/engine/spec"
+	"github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// fakeStageOwnerStore is an in-memory stage owner store.
+type fakeStageOwnerStore struct {
+	owners map[string]*types.StageOwner
+}
+
+func (s *fakeStageOwnerStore) Find(_ context.Context, id string) (*types.StageOwner, error) {
+	owner, ok := s.owners[id]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return owner, nil
+}
+
+func (s *fakeStageOwnerStore) Create(_ context.Context, owner *types.StageOwner) error {
+	s.owners[owner.StageID] = owner
+	return nil
+}
+
+func (s *fakeStageOwnerStore) Delete(_ context.Context, id string) error {
+	delete(s.owners, id)
+	return nil
+}
+
+func TestHandleSetup_ForkPRWithoutUntrustedProfile(t *testing.T) {
+	poolManager := &drivers.Manager{}
+	if err := poolManager.Add(drivers.Pool{Name: "trusted"}); err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeStageOwnerStore{owners: map[string]*types.StageOwner{}}
+
+	r := &SetupVMRequest{ID: "stage", PoolID: "trusted", ForkPR: true}
+	_, err := HandleSetup(context.Background(), r, s, &config.EnvConfig{}, poolManager)
+	if err == nil || !strings.Contains(err.Error(), `pool "trusted" has no untrusted profile`) {
+		t.Fatalf("expected the setup of the fork PR to fail, got %v", err)
+	}
+	if len(s.owners) != 0 {
+		t.Errorf("expected no VM to be provisioned for the fork PR, got stage owners %v", s.owners)
+	}
+}
+
+func TestHandleSetup_UnknownPools(t *testing.T) {
+	poolManager := &drivers.Manager{}
+	if err := poolManager.Add(drivers.Pool{Name: "linux"}); err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeStageOwnerStore{owners: map[string]*types.StageOwner{}}
+
+	r := &SetupVMRequest{ID: "stage", PoolID: "unknown", FallbackPoolIDs: []string{"missing"}}
+	_, err := HandleSetup(context.Background(), r, s, &config.EnvConfig{}, poolManager)
+	if err == nil || !strings.Contains(err.Error(), `pool "missing" does not exist`) {
+		t.Fatalf("expected the setup to fail with the missing pool, got %v", err)
+	}
+	if len(s.owners) != 0 {
+		t.Errorf("expected no stage owner, got %v", s.owners)
+	}
+}
+
+func TestHandleSetup_IsolateStepsOnWindows(t *testing.T) {
+	poolManager := &drivers.Manager{}
+	if err := poolManager.Add(drivers.Pool{Name: "windows", Platform: types.Platform{OS: "windows", Arch: "amd64"}}); err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeStageOwnerStore{owners: map[string]*types.StageOwner{}}
+
+	r := &SetupVMRequest{ID: "stage", PoolID: "windows", IsolateSteps: true}
+	_, err := HandleSetup(context.Background(), r, s, &config.EnvConfig{}, poolManager)
+	var badRequest *ierrors.BadRequestError
+	if !errors.As(err, &badRequest) || !strings.Contains(err.Error(), "step isolation is not supported on windows") {
+		t.Fatalf("expected the setup of the isolated stage to be rejected, got %v", err)
+	}
+	if len(s.owners) != 0 {
+		t.Errorf("expected no VM to be provisioned for the isolated stage, got stage owners %v", s.owners)
+	}
+}
+
+func TestRotateLeaseKey(t *testing.T) {
+	in := &api.SetupRequest{Files: []*lespec.File{{Path: "/etc/stage.conf"}}}
+
+	out, key, err := rotateLeaseKey(in, &types.Instance{Platform: types.Platform{OS: "linux"}})
+	if err != nil || out != in || key != "" {
+		t.Fatalf("expected the request of an instance without a lease key to be kept, got %v", err)
+	}
+
+	inst := &types.Instance{LeaseKey: "previous", Platform: types.Platform{OS: "linux"}}
+	if out, key, err = rotateLeaseKey(in, inst); err != nil {
+		t.Fatal(err)
+	}
+	if key == "" || key == inst.LeaseKey {
+		t.Errorf("expected a new lease key, got %q", key)
+	}
+	if len(out.Files) != 2 || out.Files[1].Path != "/etc/lite-engine/lease.key" || out.Files[1].Data != key {
+		t.Errorf("expected the setup to write the new key to the key file, got %+v", out.Files)
+	}
+	if len(in.Files) != 1 {
+		t.Errorf("expected the request of the stage not to be modified, got %+v", in.Files)
+	}
+}
+
+// consoleDriver returns the console output of its instances.
+type consoleDriver struct {
+	drivers.Driver
+	console string
+}
+
+func (d *consoleDriver) Capabilities() drivers.Capabilities {
+	return drivers.Capabilities{ConsoleLogs: true}
+}
+func (d *consoleDriver) CanHibernate() bool { return false }
+func (d *consoleDriver) DriverName() string { return "console" }
+
+func (d *consoleDriver) Logs(context.Context, string) (string, error) {
+	return d.console, nil
+}
+
+func TestBootFailure(t *testing.T) {
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	driver := &consoleDriver{}
+	poolManager := drivers.New(context.Background(), ldb.NewInstanceStore(db), &config.EnvConfig{})
+	if err = poolManager.Add(drivers.Pool{Name: "linux", Driver: driver, Timeouts: types.Timeouts{BootSecs: 1200}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := bootTimeout(poolManager.Timeouts("linux")); got != 20*time.Minute {
+		t.Errorf("expected the boot timeout of the pool, got %s", got)
+	}
+	if got := bootTimeout(poolManager.Timeouts("unknown")); got != setupTimeout {
+		t.Errorf("expected the default boot timeout, got %s", got)
+	}
+
+	healthErr := errors.New("health check timed out")
+	logr := logrus.NewEntry(logrus.StandardLogger())
+	instance := &types.Instance{ID: "instance"}
+
+	driver.console = "login:"
+	if err = bootFailure(logr, poolManager, "linux", instance, healthErr); err != healthErr {
+		t.Errorf("expected the health check error without the phase markers, got %v", err)
+	}
+
+	driver.console = "drone-runner-phase: boot ok\ndrone-runner-phase: packages failed\n"
+	err = bootFailure(logr, poolManager, "linux", instance, healthErr)
+	var failure *cloudinit.BootFailure
+	if !errors.As(err, &failure) || failure.Phase != cloudinit.PhasePackages || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected the packages to fail, got %v", err)
+	}
+}
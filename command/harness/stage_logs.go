@@ -0,0 +1,69 @@
+package harness
+
+import (
+	"context"
+	"sync"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+)
+
+var (
+	logsState *StageLogState
+	logsOnce  sync.Once
+)
+
+// StageLogState keeps the log streams of the stages set up by the runner, so the runner reports
+// to the logs of a stage once its steps are over, e.g. the outcome of the destroy hooks.
+type StageLogState struct {
+	mu   sync.Mutex
+	logs map[string]stageLog
+	env  *config.EnvConfig
+}
+
+func stageLogs() *StageLogState {
+	logsOnce.Do(func() {
+		logsState = &StageLogState{
+			mu:   sync.Mutex{},
+			logs: make(map[string]stageLog),
+		}
+	})
+	return logsState
+}
+
+func (s *StageLogState) Add(stageRuntimeID string, log stageLog, env *config.EnvConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.logs[stageRuntimeID] = log
+	s.env = env
+}
+
+// Get returns the log stream of the stage, false if the stage was not set up by the runner since it started.
+func (s *StageLogState) Get(stageRuntimeID string) (stageLog, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log, ok := s.logs[stageRuntimeID]
+	return log, ok
+}
+
+// Write writes the lines to the stream of the logs of the stage, the lines are dropped if the
+// stage is not known.
+func (s *StageLogState) Write(ctx context.Context, stageRuntimeID, stream string, lines ...string) error {
+	s.mu.Lock()
+	log, ok := s.logs[stageRuntimeID]
+	env := s.env
+	s.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return writeStageLog(ctx, env, log, stream, lines...)
+}
+
+func (s *StageLogState) Delete(stageRuntimeID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.logs, stageRuntimeID)
+}
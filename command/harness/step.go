@@ -16,6 +16,7 @@ import (
 	"github.com/drone-runners/drone-runner-aws/store"
 	"github.com/drone-runners/drone-runner-aws/types"
 	"github.com/harness/lite-engine/api"
+	lehttp "github.com/harness/lite-engine/cli/client"
 	lespec "github.com/harness/lite-engine/engine/spec"
 
 	"github.com/pkg/errors"
@@ -28,7 +29,10 @@ type ExecuteVMRequest struct {
 	IPAddress            string `json:"ip_address"`
 	PoolID               string `json:"pool_id"`
 	CorrelationID        string `json:"correlation_id"`
+	AccountID            string `json:"account_id"` // the instances of the other accounts are not found
 	api.StartStepRequest `json:"start_step_request"`
+	// Resources limits the container of the step, the default limits of the runner apply if not set.
+	Resources StepResources `json:"resources,omitempty"`
 }
 
 var (
@@ -39,6 +43,10 @@ func HandleStep(ctx context.Context, r *ExecuteVMRequest, s store.StageOwnerStor
 	if r.ID == "" && r.IPAddress == "" {
 		return nil, ierrors.NewBadRequestError("either parameter 'id' or 'ip_address' must be provided")
 	}
+	if r.InstanceID != "" && !r.Detach && stepIsolation().Enabled(r.StageRuntimeID) {
+		return nil, ierrors.NewBadRequestError("parameter 'instance_id' is not supported, the stage runs its steps in isolation")
+	}
+	ctx = drivers.WithAccountID(ctx, r.AccountID)
 
 	entity, err := s.Find(ctx, r.StageRuntimeID)
 	if err != nil || entity == nil {
@@ -53,7 +61,16 @@ func HandleStep(ctx context.Context, r *ExecuteVMRequest, s store.StageOwnerStor
 		WithField("pool", poolID).
 		WithField("correlation_id", r.CorrelationID)
 
+	// the exports of the previous steps are not resolved, a step can't fetch the secrets by exporting
+	// references to them
+	if err = resolveStepSecrets(ctx, r, poolID, env); err != nil {
+		return nil, fmt.Errorf("could not resolve the secrets of the step: %w", err)
+	}
 	setPrevStepExportEnvs(r)
+	setArtifactsEnvs(r, env)
+	if err = setCredentialsEnvs(ctx, r, poolID, env, poolManager); err != nil {
+		return nil, fmt.Errorf("could not mint the cloud credentials of the step: %w", err)
+	}
 	// add global volumes as mounts only if image is specified
 	if r.Image != "" {
 		for _, pair := range env.Runner.Volumes {
@@ -69,12 +86,33 @@ func HandleStep(ctx context.Context, r *ExecuteVMRequest, s store.StageOwnerStor
 			r.Volumes = append(r.Volumes, mount)
 		}
 	}
-	inst, err := getInstance(ctx, poolID, r.StageRuntimeID, r.InstanceID, poolManager)
-	if err != nil {
-		return nil, err
+	var inst, stageInst *types.Instance
+	// in step isolation mode every step except the detached ones (services) gets a VM of its own
+	isolated := !r.Detach && stepIsolation().Enabled(r.StageRuntimeID)
+	if isolated {
+		stageInst, err = getInstance(ctx, poolID, r.StageRuntimeID, "", poolManager)
+		if err != nil {
+			return nil, err
+		}
+		var stepPool string
+		stepPool, inst, err = stepIsolation().Acquire(ctx, r.StageRuntimeID, r.StartStepRequest.ID, env, poolManager)
+		if err != nil {
+			return nil, err
+		}
+		// the step VM is short-lived, it's destroyed in background once the step completes
+		defer func(inst *types.Instance) {
+			go destroyStepVM(stepPool, inst, poolManager)
+		}(inst)
+		logr = logr.WithField("step_instance_id", inst.ID).WithField("step_provider_id", inst.ProviderID)
+	} else {
+		inst, err = getInstance(ctx, poolID, r.StageRuntimeID, r.InstanceID, poolManager)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	logr = logr.WithField("ip", inst.Address)
+	logr = logr.WithField("ip", inst.Address).WithField("provider_id", inst.ProviderID)
+	setInstanceEnvs(r, inst, poolManager)
 
 	client, err := lehelper.GetClient(inst, env.Runner.Name, inst.Port, env.LiteEngine.EnableMock, env.LiteEngine.MockStepTimeoutSecs)
 	if err != nil {
@@ -110,19 +148,70 @@ func HandleStep(ctx context.Context, r *ExecuteVMRequest, s store.StageOwnerStor
 			}
 		}
 	}
-	startStepResponse, err := client.StartStep(ctx, &r.StartStepRequest)
+	resources := r.Resources
+	if resources.CPU == "" {
+		resources.CPU = env.Settings.StepCPU
+	}
+	if resources.Memory == "" {
+		resources.Memory = env.Settings.StepMemory
+	}
+	if err = applyStepResources(&r.StartStepRequest, resources); err != nil {
+		return nil, ierrors.NewBadRequestError(err.Error())
+	}
+	// Untrusted builds must not get access to the docker daemon of the VM.
+	if inst.Untrusted {
+		b := false
+		r.StartStepRequest.MountDockerSocket = &b
+		r.StartStepRequest.Privileged = false
+	}
+
+	// the workspace is handed off through the stage VM, it holds the workspace in between the steps
+	var stageClient lehttp.Client
+	stepID := r.StartStepRequest.ID
+	if isolated && !env.LiteEngine.EnableMock {
+		if r.StartStepRequest.WorkingDir == "" {
+			return nil, ierrors.NewBadRequestError("parameter 'working_dir' must be provided, the stage runs its steps in isolation")
+		}
+		stageClient, err = lehelper.GetClient(stageInst, env.Runner.Name, stageInst.Port, false, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create client: %w", err)
+		}
+		if err = handOffWorkspace(ctx, stageClient, client, r.StartStepRequest.WorkingDir, stepID); err != nil {
+			return nil, err
+		}
+	}
+
+	pollTimeout := applyStepTimeout(&r.StartStepRequest, inst.Pool, poolManager)
+	// the step is interrupted if its instance is recycled as unreachable
+	stepCtx, stepDone := poolManager.TrackStep(ctx, inst.ID)
+	defer stepDone()
+	startStepResponse, err := client.StartStep(stepCtx, &r.StartStepRequest)
 	if err != nil {
-		return nil, fmt.Errorf("failed to call LE.StartStep: %w", err)
+		return nil, interruptedStepError(ctx, stepCtx, inst, poolManager, fmt.Errorf("failed to call LE.StartStep: %w", err))
 	}
 
 	logr.WithField("startStepResponse", startStepResponse).Traceln("LE.StartStep complete")
 
-	pollResponse, err := client.RetryPollStep(ctx, &api.PollStepRequest{ID: r.StartStepRequest.ID}, stepTimeout)
+	heartbeatCtx, stopHeartbeat := context.WithCancel(stepCtx)
+	go leaseHeartbeat(heartbeatCtx, client, inst, env, poolManager)
+	pollResponse, err := pollStep(ctx, stepCtx, client, r.StartStepRequest.ID, pollTimeout)
+	stopHeartbeat()
+	var timeoutErr *ierrors.TimeoutError
+	if errors.As(err, &timeoutErr) {
+		logr.WithField("timeout", pollTimeout).Warnln("step timed out")
+		return nil, err
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to call LE.RetryPollStep: %w", err)
+		return nil, interruptedStepError(ctx, stepCtx, inst, poolManager, fmt.Errorf("failed to call LE.RetryPollStep: %w", err))
 	}
 
 	logr.WithField("pollResponse", pollResponse).Traceln("completed LE.RetryPollStep")
+	// the workspace is handed back whatever the exit code of the step is, the following steps might rely on it
+	if stageClient != nil {
+		if err = handOffWorkspace(ctx, client, stageClient, r.StartStepRequest.WorkingDir, stepID); err != nil {
+			return nil, err
+		}
+	}
 	if len(pollResponse.Envs) > 0 {
 		envState().Add(r.StageRuntimeID, pollResponse.Envs)
 	}
@@ -130,6 +219,24 @@ func HandleStep(ctx context.Context, r *ExecuteVMRequest, s store.StageOwnerStor
 	return pollResponse, nil
 }
 
+// interruptedStepError returns a retryable error if the instance of the failed step has a maintenance
+// or an interruption announced by its provider, or was recycled as unreachable, so the stage is
+// retried rather than failing with the lost connection to the lite-engine. Otherwise the error is
+// returned as is.
+func interruptedStepError(ctx, stepCtx context.Context, inst *types.Instance, poolManager *drivers.Manager, err error) error {
+	if reason := drivers.StepInterruption(stepCtx); reason != "" {
+		return ierrors.NewRetryableError(fmt.Sprintf("instance %s was recycled, %s, the stage can be retried: %s",
+			inst.ID, reason, err))
+	}
+	// the maintenance is found by the watcher while the step runs, the instance is read again.
+	latest, findErr := poolManager.Find(ctx, inst.ID)
+	if findErr != nil || latest.Maintenance == "" {
+		return err
+	}
+	return ierrors.NewRetryableError(fmt.Sprintf("instance %s is affected by %s, the stage can be retried: %s",
+		inst.ID, latest.Maintenance, err))
+}
+
 func getInstance(ctx context.Context, poolID, stageRuntimeID,
 	instanceID string, poolManager *drivers.Manager) (
 	*types.Instance, error) {
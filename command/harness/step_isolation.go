@@ -0,0 +1,385 @@
+package harness
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/internal/lehelper"
+	ierrors "github.com/drone-runners/drone-runner-aws/internal/types"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/dchest/uniuri"
+	"github.com/harness/lite-engine/api"
+	lehttp "github.com/harness/lite-engine/cli/client"
+	lespec "github.com/harness/lite-engine/engine/spec"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	isolation     *StepIsolationState
+	isolationOnce sync.Once
+)
+
+// StepIsolationState tracks the dedicated VMs of stages running in step isolation mode,
+// where every (non-detached) step is executed on its own short-lived VM.
+type StepIsolationState struct {
+	mu     sync.Mutex
+	stages map[string]*isolatedStage
+}
+
+type isolatedStage struct {
+	pool      string
+	untrusted bool
+	setup     api.SetupRequest
+	steps     map[string]*stepVM
+}
+
+type stepVM struct {
+	ready    chan struct{}
+	instance *types.Instance
+	err      error
+	consumed bool // the VM was acquired by its step, guarded by the state mutex
+
+	destroyOnce sync.Once
+}
+
+// destroy destroys the VM once, both the stage removal and the provisioning might find it unused.
+func (vm *stepVM) destroy(pool string, poolManager *drivers.Manager) {
+	vm.destroyOnce.Do(func() {
+		destroyStepVM(pool, vm.instance, poolManager)
+	})
+}
+
+// Add registers a stage for step isolation. Setup request is replayed on every step VM.
+func (s *StepIsolationState) Add(stageRuntimeID, pool string, untrusted bool, setup *api.SetupRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stages[stageRuntimeID] = &isolatedStage{
+		pool:      pool,
+		untrusted: untrusted,
+		setup:     *setup,
+		steps:     make(map[string]*stepVM),
+	}
+}
+
+// Enabled returns true if the stage runs in step isolation mode.
+func (s *StepIsolationState) Enabled(stageRuntimeID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.stages[stageRuntimeID]
+	return ok
+}
+
+// Prepare starts provisioning of a VM for the step in the background. The result is
+// picked up by Acquire once the step is executed.
+func (s *StepIsolationState) Prepare(stageRuntimeID, stepID string, env *config.EnvConfig, poolManager *drivers.Manager) {
+	s.mu.Lock()
+	stage, ok := s.stages[stageRuntimeID]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	if _, exists := stage.steps[stepID]; exists {
+		s.mu.Unlock()
+		return
+	}
+	vm := &stepVM{ready: make(chan struct{})}
+	stage.steps[stepID] = vm
+	pool := stage.pool
+	untrusted := stage.untrusted
+	setup := stage.setup
+	s.mu.Unlock()
+
+	go func() {
+		// the global context is not available here, the setup request context can't be
+		// used either as provisioning continues after the setup call has returned.
+		ctx, cancel := context.WithTimeout(context.Background(), setupTimeout)
+		defer cancel()
+		ctx = drivers.WithAccountID(ctx, setup.LogConfig.AccountID)
+
+		vm.instance, vm.err = provisionStepVM(ctx, pool, untrusted, stageRuntimeID, stepID, &setup, env, poolManager)
+		close(vm.ready)
+
+		// the stage might have been destroyed while the VM was being provisioned.
+		s.mu.Lock()
+		_, enabled := s.stages[stageRuntimeID]
+		consumed := vm.consumed
+		s.mu.Unlock()
+		if vm.err == nil && !enabled && !consumed {
+			vm.destroy(pool, poolManager)
+		}
+	}()
+}
+
+// Acquire returns the dedicated VM for the step, provisioning it if it was not prepared ahead of time.
+// The VM is removed from the state and the caller is responsible for releasing it.
+func (s *StepIsolationState) Acquire(ctx context.Context, stageRuntimeID, stepID string, env *config.EnvConfig,
+	poolManager *drivers.Manager) (pool string, inst *types.Instance, err error) {
+	s.Prepare(stageRuntimeID, stepID, env, poolManager)
+
+	s.mu.Lock()
+	stage, ok := s.stages[stageRuntimeID]
+	if !ok {
+		s.mu.Unlock()
+		return "", nil, fmt.Errorf("stage %s is not running in step isolation mode", stageRuntimeID)
+	}
+	vm := stage.steps[stepID]
+	pool = stage.pool
+	s.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return pool, nil, ctx.Err()
+	case <-vm.ready:
+	}
+	if vm.err == nil && !drivers.Owns(ctx, vm.instance) {
+		return pool, nil, ierrors.NewNotFoundError(fmt.Sprintf("no VM found for step %s", stepID))
+	}
+
+	s.mu.Lock()
+	vm.consumed = true
+	delete(stage.steps, stepID)
+	s.mu.Unlock()
+
+	if vm.err != nil {
+		return pool, nil, fmt.Errorf("failed to provision VM for step %s: %w", stepID, vm.err)
+	}
+	return pool, vm.instance, nil
+}
+
+// Delete removes the stage from the state and destroys all step VMs which were not consumed.
+func (s *StepIsolationState) Delete(stageRuntimeID string, poolManager *drivers.Manager) {
+	s.mu.Lock()
+	stage, ok := s.stages[stageRuntimeID]
+	delete(s.stages, stageRuntimeID)
+	s.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	for _, vm := range stage.steps {
+		select {
+		case <-vm.ready:
+			if vm.err == nil {
+				vm.destroy(stage.pool, poolManager)
+			}
+		default:
+			// still provisioning, it gets destroyed once ready.
+		}
+	}
+}
+
+func stepIsolation() *StepIsolationState {
+	isolationOnce.Do(func() {
+		isolation = &StepIsolationState{
+			mu:     sync.Mutex{},
+			stages: make(map[string]*isolatedStage),
+		}
+	})
+	return isolation
+}
+
+// provisionStepVM provisions an instance from the pool and runs the lite-engine setup on it.
+func provisionStepVM(ctx context.Context, pool string, untrusted bool, stageRuntimeID, stepID string, setup *api.SetupRequest,
+	env *config.EnvConfig, poolManager *drivers.Manager) (*types.Instance, error) {
+	logr := logrus.
+		WithField("stage_runtime_id", stageRuntimeID).
+		WithField("step_id", stepID).
+		WithField("pool", pool)
+
+	ctx = drivers.WithStageID(ctx, stageRuntimeID)
+	var inst *types.Instance
+	var err error
+	if untrusted {
+		inst, err = poolManager.ProvisionUntrusted(ctx, pool, env.Runner.Name, env)
+	} else {
+		inst, err = poolManager.Provision(ctx, pool, env.Runner.Name, env)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logr = logr.WithField("instance_id", inst.ID)
+
+	woken := inst.IsHibernated
+	if inst.IsHibernated {
+		provisioned := inst
+		inst, err = poolManager.StartInstance(ctx, pool, provisioned.ID)
+		if err != nil {
+			destroyStepVM(pool, provisioned, poolManager)
+			return nil, err
+		}
+	}
+
+	inst.Stage = stepStageID(stageRuntimeID, stepID)
+	inst.Updated = time.Now().Unix()
+	if err = poolManager.Update(ctx, inst); err != nil {
+		destroyStepVM(pool, inst, poolManager)
+		return nil, err
+	}
+	if dnsErr := poolManager.RegisterDNS(ctx, inst); dnsErr != nil {
+		logr.WithError(dnsErr).Warnln("failed to register the step instance in dns")
+	}
+
+	client, err := lehelper.GetClient(inst, env.Runner.Name, inst.Port, env.LiteEngine.EnableMock, env.LiteEngine.MockStepTimeoutSecs)
+	if err != nil {
+		destroyStepVM(pool, inst, poolManager)
+		return nil, err
+	}
+
+	if client, _, err = retryHealth(ctx, logr, client, inst, woken, bootTimeout(poolManager.Timeouts(pool)), poolManager, leClient(env)); err != nil {
+		if ctx.Err() == nil {
+			err = bootFailure(logr, poolManager, pool, inst, err)
+		}
+		destroyStepVM(pool, inst, poolManager)
+		return nil, fmt.Errorf("failed to call lite-engine retry health: %w", err)
+	}
+
+	if _, err = client.Setup(ctx, setup); err != nil {
+		destroyStepVM(pool, inst, poolManager)
+		return nil, fmt.Errorf("failed to call setup lite-engine: %w", err)
+	}
+	if _, err = startServices(ctx, client, poolManager.Services(pool), setup, inst); err != nil {
+		destroyStepVM(pool, inst, poolManager)
+		return nil, err
+	}
+	if err = poolManager.CompleteClaim(ctx, inst); err != nil {
+		destroyStepVM(pool, inst, poolManager)
+		return nil, err
+	}
+
+	logr.Traceln("step VM is ready")
+	return inst, nil
+}
+
+func destroyStepVM(pool string, inst *types.Instance, poolManager *drivers.Manager) {
+	if inst == nil {
+		return
+	}
+	if err := poolManager.Destroy(context.Background(), pool, inst.ID); err != nil {
+		logrus.WithError(err).
+			WithField("pool", pool).
+			WithField("instance_id", inst.ID).
+			Errorln("failed to destroy step VM")
+	}
+}
+
+// step VMs are tagged with a stage ID of their own so they are never
+// picked up as the primary VM of the stage.
+func stepStageID(stageRuntimeID, stepID string) string {
+	return fmt.Sprintf("%s/%s", stageRuntimeID, stepID)
+}
+
+var (
+	// maxStepWorkspaceSize bounds the compressed workspace handed off between the VMs of an isolated stage.
+	// The archive is relayed by the runner in memory as a base64 step output, twice per step, so only
+	// small workspaces can be handed off; larger ones must be cached outside of the workspace.
+	maxStepWorkspaceSize = 16 << 20 //nolint:gomnd
+	handOffTimeout       = 10 * time.Minute
+)
+
+const (
+	// workspaceOutput is the output variable of the export step carrying the base64 encoded workspace archive.
+	workspaceOutput = "DRONE_ISOLATED_WORKSPACE"
+	// workspaceTooLarge is the exit code of the export step if the archive exceeds maxStepWorkspaceSize.
+	workspaceTooLarge = 3
+)
+
+// handOffWorkspace copies the workspace of an isolated stage from a VM to another. The steps of the stage
+// run on VMs of their own, the workspace is copied from the stage VM to the step VM before the step runs,
+// and back once it completes, so every step sees the files of the previous steps.
+func handOffWorkspace(ctx context.Context, from, to lehttp.Client, workDir, stepID string) error {
+	archive, err := exportWorkspace(ctx, from, workDir, stepID)
+	if err != nil {
+		return err
+	}
+	return importWorkspace(ctx, to, workDir, stepID, archive)
+}
+
+// exportWorkspace returns the workspace of the VM as a base64 encoded gzipped tar archive.
+func exportWorkspace(ctx context.Context, client lehttp.Client, workDir, stepID string) (string, error) {
+	script := fmt.Sprintf(`set -e
+archive=$(mktemp)
+trap 'rm -f "$archive"' EXIT
+mkdir -p %[1]q
+tar -czf "$archive" -C %[1]q .
+size=$(wc -c < "$archive")
+if [ "$size" -gt %[2]d ]; then
+  echo "the workspace archive of $size bytes exceeds the limit of %[2]d bytes" >&2
+  exit %[3]d
+fi
+printf '%[4]s=' > "$DRONE_OUTPUT"
+base64 < "$archive" | tr -d '\n' >> "$DRONE_OUTPUT"
+echo >> "$DRONE_OUTPUT"`, workDir, maxStepWorkspaceSize, workspaceTooLarge, workspaceOutput)
+
+	res, err := runWorkspaceStep(ctx, client, workspaceStepID(stepID, "export"), script, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to export the workspace: %w", err)
+	}
+	if res.ExitCode == workspaceTooLarge {
+		return "", fmt.Errorf("failed to export the workspace: it exceeds the limit of %d MB of the isolated stages", maxStepWorkspaceSize>>20) //nolint:gomnd
+	}
+	if res.ExitCode != 0 {
+		return "", fmt.Errorf("failed to export the workspace: exit code %d %s", res.ExitCode, res.Error)
+	}
+	archive, ok := res.Outputs[workspaceOutput]
+	if !ok {
+		return "", errors.New("failed to export the workspace: the archive is missing from the outputs")
+	}
+	return archive, nil
+}
+
+// importWorkspace replaces the workspace of the VM with the archive.
+func importWorkspace(ctx context.Context, client lehttp.Client, workDir, stepID, archive string) error {
+	id := workspaceStepID(stepID, "import")
+	path := fmt.Sprintf("/tmp/%s.tgz.b64", id)
+	script := fmt.Sprintf(`set -e
+trap 'rm -f %[1]q' EXIT
+mkdir -p %[2]q
+find %[2]q -mindepth 1 -delete
+base64 -d < %[1]q | tar -xzf - -C %[2]q`, path, workDir)
+
+	files := []*lespec.File{{Path: path, Mode: 0600, Data: archive}} //nolint:gomnd
+	res, err := runWorkspaceStep(ctx, client, id, script, files)
+	if err != nil {
+		return fmt.Errorf("failed to import the workspace: %w", err)
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("failed to import the workspace: exit code %d %s", res.ExitCode, res.Error)
+	}
+	return nil
+}
+
+// runWorkspaceStep runs the shell script on the VM, outside of any container.
+func runWorkspaceStep(ctx context.Context, client lehttp.Client, id, script string, files []*lespec.File) (*api.PollStepResponse, error) {
+	req := &api.StartStepRequest{
+		ID:      id,
+		Name:    id,
+		Kind:    api.Run,
+		Timeout: int(handOffTimeout.Seconds()),
+		Run: api.RunConfig{
+			Entrypoint: []string{"sh", "-c"},
+			Command:    []string{script},
+		},
+		Files: files,
+	}
+	if _, err := client.StartStep(ctx, req); err != nil {
+		return nil, err
+	}
+	return client.RetryPollStep(ctx, &api.PollStepRequest{ID: id}, handOffTimeout)
+}
+
+// workspaceStepID returns a unique ID of a step handing off the workspace of the step, the stage VM
+// runs the hand-off steps of all the steps.
+func workspaceStepID(stepID, op string) string {
+	return fmt.Sprintf("%s-workspace-%s-%s", stepID, op, uniuri.NewLen(8)) //nolint:gomnd
+}
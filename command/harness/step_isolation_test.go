@@ -0,0 +1,151 @@
+package harness
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	ierrors "github.com/drone-runners/drone-runner-aws/internal/types"
+	"github.com/harness/lite-engine/api"
+)
+
+// fakeClient is a lite-engine client recording the started steps, every poll returns the response.
+type fakeClient struct {
+	response  *api.PollStepResponse
+	steps     []*api.StartStepRequest
+	logs      map[string]string // the output of the steps by ID
+	destroyed bool
+}
+
+func (c *fakeClient) Setup(context.Context, *api.SetupRequest) (*api.SetupResponse, error) {
+	return &api.SetupResponse{}, nil
+}
+
+func (c *fakeClient) Destroy(context.Context, *api.DestroyRequest) (*api.DestroyResponse, error) {
+	c.destroyed = true
+	return &api.DestroyResponse{}, nil
+}
+
+func (c *fakeClient) StartStep(_ context.Context, in *api.StartStepRequest) (*api.StartStepResponse, error) {
+	c.steps = append(c.steps, in)
+	return &api.StartStepResponse{}, nil
+}
+
+func (c *fakeClient) PollStep(context.Context, *api.PollStepRequest) (*api.PollStepResponse, error) {
+	return c.response, nil
+}
+
+func (c *fakeClient) RetryPollStep(_ context.Context, in *api.PollStepRequest, _ time.Duration) (*api.PollStepResponse, error) {
+	if n := len(c.steps); n == 0 || c.steps[n-1].ID != in.ID {
+		return nil, errors.New("step not started")
+	}
+	return c.response, nil
+}
+
+func (c *fakeClient) GetStepLogOutput(_ context.Context, in *api.StreamOutputRequest, w io.Writer) error {
+	_, err := io.WriteString(w, c.logs[in.ID])
+	return err
+}
+
+func (c *fakeClient) Health(context.Context) (*api.HealthResponse, error) {
+	return &api.HealthResponse{OK: true}, nil
+}
+
+func (c *fakeClient) RetryHealth(context.Context, time.Duration) (*api.HealthResponse, error) {
+	return &api.HealthResponse{OK: true}, nil
+}
+
+func TestHandOffWorkspace(t *testing.T) {
+	from := &fakeClient{response: &api.PollStepResponse{Outputs: map[string]string{workspaceOutput: "YXJjaGl2ZQ=="}}}
+	to := &fakeClient{response: &api.PollStepResponse{}}
+
+	if err := handOffWorkspace(context.Background(), from, to, "/drone/src", "step1"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(from.steps) != 1 || len(to.steps) != 1 {
+		t.Fatalf("expected one step on each VM, got %d and %d", len(from.steps), len(to.steps))
+	}
+	export := from.steps[0]
+	if !strings.HasPrefix(export.ID, "step1-workspace-export-") {
+		t.Errorf("unexpected export step ID %q", export.ID)
+	}
+	if export.Image != "" || !strings.Contains(export.Run.Command[0], `-C "/drone/src" .`) {
+		t.Errorf("expected the export step to archive the workspace on the host, got %+v", export)
+	}
+	imp := to.steps[0]
+	if !strings.HasPrefix(imp.ID, "step1-workspace-import-") {
+		t.Errorf("unexpected import step ID %q", imp.ID)
+	}
+	if len(imp.Files) != 1 || imp.Files[0].Data != "YXJjaGl2ZQ==" {
+		t.Fatalf("expected the archive to be uploaded to the step VM, got %+v", imp.Files)
+	}
+	if !strings.Contains(imp.Run.Command[0], imp.Files[0].Path) || !strings.Contains(imp.Run.Command[0], `-C "/drone/src"`) {
+		t.Errorf("expected the import step to extract the archive into the workspace, got %q", imp.Run.Command[0])
+	}
+}
+
+func TestHandOffWorkspace_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		export  *api.PollStepResponse
+		imp     *api.PollStepResponse
+		message string
+	}{
+		{
+			name:    "too large",
+			export:  &api.PollStepResponse{Exited: true, ExitCode: workspaceTooLarge},
+			message: "exceeds the limit of 16 MB",
+		},
+		{
+			name:    "export failure",
+			export:  &api.PollStepResponse{Exited: true, ExitCode: 2, Error: "tar: error"},
+			message: "failed to export the workspace: exit code 2",
+		},
+		{
+			name:    "missing archive",
+			export:  &api.PollStepResponse{Exited: true},
+			message: "the archive is missing",
+		},
+		{
+			name:    "import failure",
+			export:  &api.PollStepResponse{Exited: true, Outputs: map[string]string{workspaceOutput: ""}},
+			imp:     &api.PollStepResponse{Exited: true, ExitCode: 1},
+			message: "failed to import the workspace: exit code 1",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			from := &fakeClient{response: test.export}
+			to := &fakeClient{response: test.imp}
+
+			err := handOffWorkspace(context.Background(), from, to, "/drone/src", "step1")
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Fatalf("expected error containing %q, got %v", test.message, err)
+			}
+			if test.imp == nil && len(to.steps) != 0 {
+				t.Errorf("expected no import once the export failed")
+			}
+		})
+	}
+}
+
+func TestHandleStep_IsolatedStageRejectsInstanceID(t *testing.T) {
+	stepIsolation().Add("stage-instance-id", "pool", false, &api.SetupRequest{})
+	defer stepIsolation().Delete("stage-instance-id", nil)
+
+	r := &ExecuteVMRequest{
+		StageRuntimeID:   "stage-instance-id",
+		InstanceID:       "instance",
+		StartStepRequest: api.StartStepRequest{ID: "step1"},
+	}
+	_, err := HandleStep(context.Background(), r, nil, nil, nil)
+
+	var badRequest *ierrors.BadRequestError
+	if !errors.As(err, &badRequest) {
+		t.Fatalf("expected a bad request error, got %v", err)
+	}
+}
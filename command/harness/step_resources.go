@@ -0,0 +1,65 @@
+package harness
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/harness/lite-engine/api"
+)
+
+// cpuPeriod is the cfs period the cpu limits of the steps are expressed in, the docker default.
+const cpuPeriod = 100000
+
+// StepResources limits the resources of the container of a step, so a runaway step is killed
+// instead of exhausting the VM and the services of the stage running next to it.
+type StepResources struct {
+	CPU    string `json:"cpu,omitempty"`    // cores, e.g. 1.5, or millicores, e.g. 500m
+	Memory string `json:"memory,omitempty"` // e.g. 512m, 2g or 2Gi
+}
+
+// applyStepResources sets the limits on the step, the limits already set on the step are kept.
+// Steps without an image run on the VM directly and can't be limited.
+func applyStepResources(step *api.StartStepRequest, resources StepResources) error {
+	if step.Image == "" || (resources.CPU == "" && resources.Memory == "") {
+		return nil
+	}
+	if resources.CPU != "" && step.CPUQuota == 0 {
+		quota, err := parseCPUQuota(resources.CPU)
+		if err != nil {
+			return err
+		}
+		step.CPUPeriod = cpuPeriod
+		step.CPUQuota = quota
+	}
+	if resources.Memory != "" && step.MemLimit == 0 {
+		// the kubernetes style suffixes are binary units already.
+		limit, err := units.RAMInBytes(strings.TrimRight(resources.Memory, "iI"))
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("invalid memory limit %q", resources.Memory)
+		}
+		step.MemLimit = limit
+		// without swap the step is oom killed at the limit instead of swapping the VM to a halt.
+		if step.MemSwapLimit == 0 {
+			step.MemSwapLimit = limit
+		}
+	}
+	return nil
+}
+
+// parseCPUQuota returns the cfs quota of the cpu limit.
+func parseCPUQuota(cpu string) (int64, error) {
+	var cores float64
+	var err error
+	if millis := strings.TrimSuffix(cpu, "m"); millis != cpu {
+		cores, err = strconv.ParseFloat(millis, 64)
+		cores /= 1000
+	} else {
+		cores, err = strconv.ParseFloat(cpu, 64)
+	}
+	if err != nil || cores <= 0 {
+		return 0, fmt.Errorf("invalid cpu limit %q", cpu)
+	}
+	return int64(cores * cpuPeriod), nil
+}
@@ -0,0 +1,70 @@
+package harness
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	ierrors "github.com/drone-runners/drone-runner-aws/internal/types"
+	"github.com/harness/lite-engine/api"
+	lehttp "github.com/harness/lite-engine/cli/client"
+)
+
+const (
+	// stepTimeoutGrace is the time the lite-engine gets past the timeout of a step to kill it and
+	// report its exit before the runner gives up on it.
+	stepTimeoutGrace = time.Minute
+	// outputTimeout bounds the collection of the output of a step that timed out.
+	outputTimeout = 10 * time.Second
+	// maxTimeoutOutput is the size of the end of the output returned with a step timeout.
+	maxTimeoutOutput = 64 << 10
+)
+
+// applyStepTimeout sets the timeout of the pool on a step without a timeout of its own, so the
+// lite-engine kills the step once it expires, and returns how long the runner polls the step.
+func applyStepTimeout(r *api.StartStepRequest, pool string, poolManager *drivers.Manager) time.Duration {
+	if r.Timeout <= 0 {
+		r.Timeout = int(poolManager.StepTimeout(pool).Seconds())
+	}
+	if r.Timeout <= 0 {
+		return stepTimeout
+	}
+	return time.Duration(r.Timeout)*time.Second + stepTimeoutGrace
+}
+
+// pollStep polls the step until it completes. A step which doesn't complete within the timeout
+// fails with the timeout error of stepTimeoutError, unless the step context is done first.
+func pollStep(ctx, stepCtx context.Context, client lehttp.Client, stepID string, timeout time.Duration) (*api.PollStepResponse, error) {
+	res, err := client.RetryPollStep(stepCtx, &api.PollStepRequest{ID: stepID}, timeout)
+	if err != nil && stepCtx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+		return nil, stepTimeoutError(ctx, client, stepID, timeout)
+	}
+	return res, err
+}
+
+// stepTimeoutError returns the timeout error of a step with the end of the output the lite-engine
+// collected before the step timed out.
+func stepTimeoutError(ctx context.Context, client lehttp.Client, stepID string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, outputTimeout)
+	defer cancel()
+	out := &tailBuffer{max: maxTimeoutOutput}
+	// the output is best effort, the step is reported as timed out with what was collected
+	_ = client.GetStepLogOutput(ctx, &api.StreamOutputRequest{ID: stepID}, out)
+	return ierrors.NewTimeoutError(fmt.Sprintf("step %s did not complete within %s", stepID, timeout), string(out.buf))
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.max; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,85 @@
+package harness
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	ierrors "github.com/drone-runners/drone-runner-aws/internal/types"
+	"github.com/harness/lite-engine/api"
+)
+
+// hangingClient is a lite-engine client whose steps never complete, the polls last until their timeout.
+type hangingClient struct {
+	fakeClient
+}
+
+func (c *hangingClient) RetryPollStep(ctx context.Context, _ *api.PollStepRequest, timeout time.Duration) (*api.PollStepResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestApplyStepTimeout(t *testing.T) {
+	poolManager := &drivers.Manager{}
+	if err := poolManager.Add(drivers.Pool{Name: "linux", StepTimeout: 30 * time.Minute}, drivers.Pool{Name: "mac"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		pool    string
+		timeout int
+		want    int
+		poll    time.Duration
+	}{
+		{name: "pool timeout", pool: "linux", want: 1800, poll: 30*time.Minute + stepTimeoutGrace},
+		{name: "step timeout", pool: "linux", timeout: 60, want: 60, poll: time.Minute + stepTimeoutGrace},
+		{name: "no timeout", pool: "mac", poll: stepTimeout},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &api.StartStepRequest{Timeout: test.timeout}
+			poll := applyStepTimeout(r, test.pool, poolManager)
+			if r.Timeout != test.want || poll != test.poll {
+				t.Errorf("got the timeout %d and the poll %s, want %d and %s", r.Timeout, poll, test.want, test.poll)
+			}
+		})
+	}
+}
+
+func TestPollStep_Timeout(t *testing.T) {
+	// the output of the step is longer than the output returned with the timeout, only its end is kept
+	output := strings.Repeat("a", maxTimeoutOutput) + "the end"
+	client := &hangingClient{fakeClient{logs: map[string]string{"step": output}}}
+
+	ctx := context.Background()
+	_, err := pollStep(ctx, ctx, client, "step", 50*time.Millisecond)
+	var timeoutErr *ierrors.TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+	if !strings.Contains(timeoutErr.Msg, "step step did not complete within 50ms") {
+		t.Errorf("unexpected message %q", timeoutErr.Msg)
+	}
+	if len(timeoutErr.Output) != maxTimeoutOutput || !strings.HasSuffix(timeoutErr.Output, "the end") {
+		t.Errorf("expected the last %d bytes of the output, got %d bytes", maxTimeoutOutput, len(timeoutErr.Output))
+	}
+}
+
+func TestPollStep_Interrupted(t *testing.T) {
+	client := &hangingClient{fakeClient{logs: map[string]string{"step": "output"}}}
+
+	// the step is interrupted before its timeout, it is not reported as timed out
+	ctx := context.Background()
+	stepCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err := pollStep(ctx, stepCtx, client, "step", time.Minute)
+	var timeoutErr *ierrors.TimeoutError
+	if err == nil || errors.As(err, &timeoutErr) {
+		t.Errorf("expected the interruption error, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package harness
+
+import (
+	"context"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/internal/lehelper"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/harness/lite-engine/api"
+	lehttp "github.com/harness/lite-engine/cli/client"
+	"github.com/sirupsen/logrus"
+)
+
+// wakeAttempts is the number of the health checks of an instance started from hibernation, the
+// boot timeout is split between them.
+const wakeAttempts = 3
+
+// leClientFunc creates the lite-engine client of an instance.
+type leClientFunc func(inst *types.Instance) (lehttp.Client, error)
+
+// leClient returns the function creating the lite-engine clients of the instances.
+func leClient(env *config.EnvConfig) leClientFunc {
+	return func(inst *types.Instance) (lehttp.Client, error) {
+		return lehelper.GetClient(inst, env.Runner.Name, inst.Port, env.LiteEngine.EnableMock, env.LiteEngine.MockStepTimeoutSecs)
+	}
+}
+
+// retryHealth waits for the lite-engine of the instance to respond ok and returns the client it
+// responded to. The address of an instance started from hibernation might change on the start, or
+// be assigned after it: after a failed health check of a woken instance its address is looked up
+// again, and the client is created again for the new address.
+func retryHealth(ctx context.Context, logr *logrus.Entry, client lehttp.Client, inst *types.Instance, woken bool,
+	timeout time.Duration, poolManager *drivers.Manager, newClient leClientFunc) (lehttp.Client, *api.HealthResponse, error) {
+	if !woken {
+		resp, err := client.RetryHealth(ctx, timeout)
+		return client, resp, err
+	}
+	deadline := time.Now().Add(timeout)
+	for attempt := 1; ; attempt++ {
+		attemptTimeout := timeout / wakeAttempts
+		if attempt == wakeAttempts {
+			attemptTimeout = time.Until(deadline)
+		}
+		resp, err := client.RetryHealth(ctx, attemptTimeout)
+		if err == nil || attempt == wakeAttempts || ctx.Err() != nil {
+			return client, resp, err
+		}
+		changed, refreshErr := poolManager.RefreshAddress(ctx, inst)
+		if refreshErr != nil {
+			logr.WithError(refreshErr).Warnln("could not look up the address of the woken instance again")
+			continue
+		}
+		if !changed {
+			continue
+		}
+		logr.WithField("ip", inst.Address).Infoln("the address of the woken instance changed, checking its health on the new address")
+		if client, err = newClient(inst); err != nil {
+			return nil, nil, err
+		}
+	}
+}
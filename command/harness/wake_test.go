@@ -0,0 +1,83 @@
+package harness
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/harness/lite-engine/api"
+	lehttp "github.com/harness/lite-engine/cli/client"
+	"github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// addressClient is the lite-engine client of an address, only the lite-engine of the instance at
+// the live address responds.
+type addressClient struct {
+	lehttp.Client
+	address string
+	live    string
+}
+
+func (c *addressClient) RetryHealth(context.Context, time.Duration) (*api.HealthResponse, error) {
+	if c.address != c.live {
+		return nil, errors.New("connection timed out")
+	}
+	return &api.HealthResponse{OK: true}, nil
+}
+
+// movingDriver resolves the instances to the live address.
+type movingDriver struct {
+	drivers.Driver
+	live string
+}
+
+func (d *movingDriver) ResolveAddress(context.Context, *types.Instance) (address, privateAddress string, err error) {
+	return d.live, "", nil
+}
+
+func TestRetryHealth_WokenInstanceAddressChange(t *testing.T) {
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	instanceStore := ldb.NewInstanceStore(db)
+	// the instance was started with the address it had before the hibernation
+	inst := &types.Instance{ID: "vm-1", Pool: "linux", State: types.StateInUse, Address: "1.1.1.1"}
+	if err = instanceStore.Create(ctx, inst); err != nil {
+		t.Fatal(err)
+	}
+	poolManager := drivers.New(ctx, instanceStore, &config.EnvConfig{})
+	if err = poolManager.Add(drivers.Pool{Name: "linux", Driver: &movingDriver{live: "2.2.2.2"}}); err != nil {
+		t.Fatal(err)
+	}
+	newClient := func(inst *types.Instance) (lehttp.Client, error) {
+		return &addressClient{address: inst.Address, live: "2.2.2.2"}, nil
+	}
+	logr := logrus.NewEntry(logrus.StandardLogger())
+
+	// a fresh instance is not looked up again
+	client, _ := newClient(inst)
+	if _, _, err = retryHealth(ctx, logr, client, inst, false, time.Second, poolManager, newClient); err == nil {
+		t.Fatal("expected the health check of the stale address to fail")
+	}
+
+	client, resp, err := retryHealth(ctx, logr, client, inst, true, time.Second, poolManager, newClient)
+	if err != nil || !resp.OK {
+		t.Fatalf("expected the woken instance to respond on its new address, got %v", err)
+	}
+	if c := client.(*addressClient); c.address != "2.2.2.2" {
+		t.Errorf("expected the client of the new address, got %q", c.address)
+	}
+	if stored, _ := instanceStore.Find(ctx, "vm-1"); stored.Address != "2.2.2.2" {
+		t.Errorf("expected the new address to be stored, got %q", stored.Address)
+	}
+}
@@ -0,0 +1,102 @@
+package harness
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/objectstore"
+	"github.com/drone-runners/drone-runner-aws/internal/oshelp"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/harness/lite-engine/api"
+	lehttp "github.com/harness/lite-engine/cli/client"
+)
+
+// workspaceTimeout bounds the download and the extraction of a workspace snapshot.
+var workspaceTimeout = 15 * time.Minute
+
+// The snapshot is downloaded to a temporary file, tar detects its compression then. The gs://
+// snapshots are copied with gsutil and the service account of the instance.
+const (
+	restoreWorkspaceScript = `set -e
+archive=$(mktemp)
+trap 'rm -f "$archive"' EXIT
+case "$SNAPSHOT_URL" in
+gs://*) gsutil -q cp "$SNAPSHOT_URL" "$archive" ;;
+*) curl -fsSL --retry 3 -o "$archive" "$SNAPSHOT_URL" ;;
+esac
+mkdir -p "$WORKSPACE_PATH"
+tar -xf "$archive" -C "$WORKSPACE_PATH"`
+
+	restoreWorkspaceScriptWindows = `$ErrorActionPreference = 'Stop'
+$archive = New-TemporaryFile
+try {
+  if ($env:SNAPSHOT_URL.StartsWith('gs://')) {
+    gsutil -q cp $env:SNAPSHOT_URL $archive.FullName
+    if ($LASTEXITCODE -ne 0) { exit $LASTEXITCODE }
+  } else {
+    Invoke-WebRequest -UseBasicParsing -Uri $env:SNAPSHOT_URL -OutFile $archive.FullName
+  }
+  New-Item -ItemType Directory -Force -Path $env:WORKSPACE_PATH | Out-Null
+  tar -xf $archive.FullName -C $env:WORKSPACE_PATH
+  if ($LASTEXITCODE -ne 0) { exit $LASTEXITCODE }
+} finally {
+  Remove-Item -Force $archive.FullName
+}`
+)
+
+// workspaceSnapshot returns the workspace snapshot of the stage, the fields set by the setup
+// request override the ones of the pool.
+func workspaceSnapshot(pool types.WorkspaceSnapshot, req *types.WorkspaceSnapshot) types.WorkspaceSnapshot {
+	if req == nil {
+		return pool
+	}
+	if req.URL != "" {
+		pool.URL = req.URL
+	}
+	if req.Path != "" {
+		pool.Path = req.Path
+	}
+	return pool
+}
+
+// restoreWorkspace extracts the workspace snapshot into the workspace of the instance, before the
+// first step of the stage runs. The s3:// snapshots are presigned by the runner, see
+// objectstore.DownloadURL.
+func restoreWorkspace(ctx context.Context, client lehttp.Client, snapshot types.WorkspaceSnapshot, instance *types.Instance) error {
+	if snapshot.Path == "" {
+		return fmt.Errorf("the workspace snapshot %s has no path", snapshot.URL)
+	}
+	ctx, cancel := context.WithTimeout(ctx, workspaceTimeout)
+	defer cancel()
+
+	url, err := objectstore.DownloadURL(ctx, snapshot.URL, workspaceTimeout)
+	if err != nil {
+		return err
+	}
+	req := &api.StartStepRequest{
+		ID:   "workspace-" + instance.ID,
+		Name: "workspace",
+		Kind: api.Run,
+		Envs: map[string]string{
+			"SNAPSHOT_URL":   url,
+			"WORKSPACE_PATH": snapshot.Path,
+		},
+	}
+	if instance.Platform.OS == oshelp.OSWindows {
+		req.Run.Entrypoint, req.Run.Command = []string{"powershell"}, []string{restoreWorkspaceScriptWindows}
+	} else {
+		req.Run.Entrypoint, req.Run.Command = []string{"sh", "-c"}, []string{restoreWorkspaceScript}
+	}
+	if _, err = client.StartStep(ctx, req); err != nil {
+		return fmt.Errorf("could not start the restore of the workspace snapshot: %w", err)
+	}
+	resp, err := client.RetryPollStep(ctx, &api.PollStepRequest{ID: req.ID}, workspaceTimeout)
+	if err != nil {
+		return fmt.Errorf("could not restore the workspace snapshot: %w", err)
+	}
+	if resp.Error != "" || resp.ExitCode != 0 {
+		return fmt.Errorf("the restore of the workspace snapshot failed with exit code %d: %s", resp.ExitCode, resp.Error)
+	}
+	return nil
+}
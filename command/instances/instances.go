@@ -0,0 +1,564 @@
+package instances
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/internal/lehelper"
+	"github.com/drone-runners/drone-runner-aws/internal/poolfile"
+	"github.com/drone-runners/drone-runner-aws/store/database"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/drone/signal"
+
+	"github.com/joho/godotenv"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const commandTimeout = 10 * time.Minute
+
+// instancesCommand loads the pools and the instances of a runner, from its pool file and its
+// database. The instances are managed as the runner manages them, through its drivers.
+type instancesCommand struct {
+	envFile  string
+	poolFile string
+}
+
+type listCommand struct {
+	*instancesCommand
+	pool   string
+	state  string
+	minAge time.Duration
+	json   bool
+}
+
+type showCommand struct {
+	*instancesCommand
+	id   string
+	json bool
+}
+
+// actionCommand destroys, hibernates or starts an instance.
+type actionCommand struct {
+	*instancesCommand
+	id  string
+	yes bool
+}
+
+// importCommand registers the instances of the cloud account in the database.
+type importCommand struct {
+	*instancesCommand
+	pool   string
+	free   bool
+	dryRun bool
+	yes    bool
+	json   bool
+}
+
+type sshCommand struct {
+	*instancesCommand
+	id       string
+	user     string
+	identity string
+	args     []string
+}
+
+// Register registers the commands managing the instances of the pools.
+func Register(app *kingpin.Application) {
+	c := new(instancesCommand)
+	cmd := app.Command("instances", "manages the instances of the pools of a runner, through its database and its drivers")
+	cmd.Flag("envfile", "load the environment variable file").
+		StringVar(&c.envFile)
+	cmd.Flag("pool", "the pool file").
+		StringVar(&c.poolFile)
+
+	list := &listCommand{instancesCommand: c}
+	listCmd := cmd.Command("list", "lists the instances").
+		Action(list.run)
+	listCmd.Flag("pool-name", "only the instances of the pool").
+		StringVar(&list.pool)
+	listCmd.Flag("state", "only the instances in the state: created, inuse, hibernating or terminating").
+		EnumVar(&list.state, string(types.StateCreated), string(types.StateInUse), string(types.StateHibernating), string(types.StateTerminating))
+	listCmd.Flag("min-age", "only the instances started at least this long ago, e.g. 2h").
+		DurationVar(&list.minAge)
+	listCmd.Flag("json", "print the instances as json").
+		BoolVar(&list.json)
+
+	show := &showCommand{instancesCommand: c}
+	showCmd := cmd.Command("show", "shows an instance").
+		Action(show.run)
+	showCmd.Arg("id", "the id of the instance").
+		Required().
+		StringVar(&show.id)
+	showCmd.Flag("json", "print the instance as json").
+		BoolVar(&show.json)
+
+	destroy := &actionCommand{instancesCommand: c}
+	destroyCmd := cmd.Command("destroy", "destroys an instance").
+		Action(destroy.destroy)
+	destroyCmd.Arg("id", "the id of the instance").
+		Required().
+		StringVar(&destroy.id)
+	destroyCmd.Flag("yes", "do not ask for a confirmation").
+		Short('y').
+		BoolVar(&destroy.yes)
+
+	hibernate := &actionCommand{instancesCommand: c}
+	hibernateCmd := cmd.Command("hibernate", "hibernates a free instance").
+		Action(hibernate.hibernate)
+	hibernateCmd.Arg("id", "the id of the instance").
+		Required().
+		StringVar(&hibernate.id)
+	hibernateCmd.Flag("yes", "do not ask for a confirmation").
+		Short('y').
+		BoolVar(&hibernate.yes)
+
+	start := &actionCommand{instancesCommand: c}
+	startCmd := cmd.Command("start", "starts a hibernated instance").
+		Action(start.start)
+	startCmd.Arg("id", "the id of the instance").
+		Required().
+		StringVar(&start.id)
+
+	imp := &importCommand{instancesCommand: c}
+	importCmd := cmd.Command("import", "registers the instances the runner created in the cloud account which are not in the database, e.g. after its loss").
+		Action(imp.run)
+	importCmd.Flag("pool-name", "only the instances of the pool").
+		StringVar(&imp.pool)
+	importCmd.Flag("free", "import the running instances as free, the runner was stopped and they run no stage").
+		BoolVar(&imp.free)
+	importCmd.Flag("dry-run", "only print the instances which would be imported").
+		BoolVar(&imp.dryRun)
+	importCmd.Flag("yes", "do not ask for a confirmation").
+		Short('y').
+		BoolVar(&imp.yes)
+	importCmd.Flag("json", "print the instances as json").
+		BoolVar(&imp.json)
+
+	shell := &sshCommand{instancesCommand: c}
+	sshCmd := cmd.Command("ssh", "opens an ssh session to an instance, the arguments after the id are passed to ssh").
+		Action(shell.run)
+	sshCmd.Arg("id", "the id of the instance").
+		Required().
+		StringVar(&shell.id)
+	sshCmd.Arg("args", "the arguments of ssh, e.g. a command").
+		StringsVar(&shell.args)
+	sshCmd.Flag("user", "the user of the instance").
+		Default("root").
+		StringVar(&shell.user)
+	sshCmd.Flag("identity", "the private key of the user").
+		Short('i').
+		StringVar(&shell.identity)
+}
+
+// manager returns the manager of the pools of the pool file, with the instances of the database of
+// the runner, and the cleanup closing the database.
+func (c *instancesCommand) manager(ctx context.Context) (*drivers.Manager, func(), error) {
+	if err := godotenv.Load(c.envFile); err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	env, err := config.FromEnviron()
+	if err != nil {
+		return nil, nil, err
+	}
+	instanceStore, _, _, _, _, _, closeStore, err := database.ProvideStore(env.Database.Driver, env.Database.Datasource, database.EnvOptions(&env)...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("instances: unable to open the database: %w", err)
+	}
+	poolManager := drivers.New(ctx, instanceStore, &env)
+
+	configPool, err := poolfile.ConfigPoolFile(c.poolFile, &env)
+	if err != nil {
+		closeStore()
+		return nil, nil, fmt.Errorf("instances: unable to load the pool file: %w", err)
+	}
+	pools, err := poolfile.ProcessPool(configPool, env.Runner.Name)
+	if err != nil {
+		closeStore()
+		return nil, nil, fmt.Errorf("instances: unable to process the pool file: %w", err)
+	}
+	if err = poolManager.Add(pools...); err != nil {
+		closeStore()
+		return nil, nil, fmt.Errorf("instances: unable to add the pools: %w", err)
+	}
+	return poolManager, closeStore, nil
+}
+
+// find returns the instance, it must belong to a pool of the pool file, and the cleanup closing the database.
+func (c *instancesCommand) find(ctx context.Context, id string) (*drivers.Manager, *types.Instance, func(), error) {
+	poolManager, closeStore, err := c.manager(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	inst, err := poolManager.Find(ctx, id)
+	if err != nil {
+		closeStore()
+		return nil, nil, nil, fmt.Errorf("instances: unable to find the instance %s: %w", id, err)
+	}
+	if !poolManager.Exists(inst.Pool) {
+		closeStore()
+		return nil, nil, nil, fmt.Errorf("instances: the pool %s of the instance %s is not in the pool file", inst.Pool, id)
+	}
+	return poolManager, inst, closeStore, nil
+}
+
+func (c *listCommand) run(*kingpin.ParseContext) error {
+	ctx, cancel := context.WithTimeout(signal.WithContext(context.Background()), commandTimeout)
+	defer cancel()
+	poolManager, closeStore, err := c.manager(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	all, err := poolManager.Instances(ctx, c.pool)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	instances := make([]*types.Instance, 0, len(all))
+	for _, inst := range all {
+		if c.state != "" && string(inst.State) != c.state {
+			continue
+		}
+		if c.minAge > 0 && now.Sub(time.Unix(inst.Started, 0)) < c.minAge {
+			continue
+		}
+		instances = append(instances, redacted(inst))
+	}
+
+	if c.json {
+		return printJSON(instances)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint:gomnd
+	fmt.Fprintln(w, "ID\tPOOL\tSTATE\tHIBERNATED\tADDRESS\tSTAGE\tACCOUNT\tAGE")
+	for _, inst := range instances {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\t%s\t%s\n",
+			inst.ID, inst.Pool, inst.State, inst.IsHibernated, inst.Address, inst.Stage, inst.AccountID, age(now, inst.Started))
+	}
+	return w.Flush()
+}
+
+func (c *showCommand) run(*kingpin.ParseContext) error {
+	ctx, cancel := context.WithTimeout(signal.WithContext(context.Background()), commandTimeout)
+	defer cancel()
+	_, inst, closeStore, err := c.find(ctx, c.id)
+	if err != nil {
+		return err
+	}
+	closeStore()
+	inst = redacted(inst)
+	if c.json {
+		return printJSON(inst)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint:gomnd
+	for _, field := range [][2]string{
+		{"ID", inst.ID},
+		{"Name", inst.Name},
+		{"Pool", inst.Pool},
+		{"Driver", string(inst.Provider)},
+		{"Provider ID", inst.ProviderID},
+		{"State", string(inst.State)},
+		{"Hibernated", fmt.Sprint(inst.IsHibernated)},
+		{"Address", inst.Address},
+		{"Private address", inst.PrivateAddress},
+		{"Route", string(inst.Route)},
+		{"Port", fmt.Sprint(inst.Port)},
+		{"Platform", inst.Platform.OS + "/" + inst.Platform.Arch},
+		{"Image", inst.Image},
+		{"Size", inst.Size},
+		{"Region", inst.Region},
+		{"Zone", inst.Zone},
+		{"Stage", inst.Stage},
+		{"Account", inst.AccountID},
+		{"Lite-engine env", inst.LiteEngineEnv},
+		{"Lite-engine features", inst.LiteEngineFeatures},
+		{"Maintenance", inst.Maintenance},
+		{"Host key", hostKeyFingerprints(inst.HostKey)},
+		{"Started", time.Unix(inst.Started, 0).Format(time.RFC3339)},
+		{"Age", age(time.Now(), inst.Started)},
+	} {
+		fmt.Fprintf(w, "%s:\t%s\n", field[0], field[1])
+	}
+	return w.Flush()
+}
+
+func (c *actionCommand) destroy(*kingpin.ParseContext) error {
+	ctx, cancel := context.WithTimeout(signal.WithContext(context.Background()), commandTimeout)
+	defer cancel()
+	poolManager, inst, closeStore, err := c.find(ctx, c.id)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	prompt := fmt.Sprintf("destroy the instance %s of the pool %s?", inst.ID, inst.Pool)
+	if inst.State == types.StateInUse {
+		prompt = fmt.Sprintf("the instance %s of the pool %s runs the stage %s, destroy it?", inst.ID, inst.Pool, inst.Stage)
+	}
+	if ok, confirmErr := confirm(ctx, os.Stdin, os.Stdout, prompt, c.yes); confirmErr != nil || !ok {
+		return confirmErr
+	}
+	if err = poolManager.Destroy(ctx, inst.Pool, inst.ID); err != nil {
+		return err
+	}
+	fmt.Printf("instance %s: destroyed\n", inst.ID)
+	return nil
+}
+
+func (c *actionCommand) hibernate(*kingpin.ParseContext) error {
+	ctx, cancel := context.WithTimeout(signal.WithContext(context.Background()), commandTimeout)
+	defer cancel()
+	poolManager, inst, closeStore, err := c.find(ctx, c.id)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	switch {
+	case inst.State == types.StateInUse || inst.State == types.StateTerminating:
+		return fmt.Errorf("instances: the instance %s is %s, only the free instances are hibernated", inst.ID, inst.State)
+	case inst.IsHibernated:
+		fmt.Printf("instance %s: already hibernated\n", inst.ID)
+		return nil
+	}
+	prompt := fmt.Sprintf("hibernate the instance %s of the pool %s?", inst.ID, inst.Pool)
+	if ok, confirmErr := confirm(ctx, os.Stdin, os.Stdout, prompt, c.yes); confirmErr != nil || !ok {
+		return confirmErr
+	}
+	if err = poolManager.Hibernate(ctx, inst.Pool, inst.ID); err != nil {
+		return err
+	}
+	fmt.Printf("instance %s: hibernated\n", inst.ID)
+	return nil
+}
+
+func (c *actionCommand) start(*kingpin.ParseContext) error {
+	ctx, cancel := context.WithTimeout(signal.WithContext(context.Background()), commandTimeout)
+	defer cancel()
+	poolManager, inst, closeStore, err := c.find(ctx, c.id)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	if !inst.IsHibernated {
+		fmt.Printf("instance %s: already running\n", inst.ID)
+		return nil
+	}
+	if inst, err = poolManager.StartInstance(ctx, inst.Pool, inst.ID); err != nil {
+		return err
+	}
+	fmt.Printf("instance %s: started at %s\n", inst.ID, inst.Address)
+	return nil
+}
+
+func (c *importCommand) run(*kingpin.ParseContext) error {
+	ctx, cancel := context.WithTimeout(signal.WithContext(context.Background()), commandTimeout)
+	defer cancel()
+	poolManager, closeStore, err := c.manager(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	found, err := poolManager.Import(ctx, c.pool, c.free, true)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		fmt.Println("no instance to import")
+		return nil
+	}
+	if err = c.print(found); err != nil || c.dryRun {
+		return err
+	}
+	prompt := fmt.Sprintf("import the %d instances?", len(found))
+	if ok, confirmErr := confirm(ctx, os.Stdin, os.Stdout, prompt, c.yes); confirmErr != nil || !ok {
+		return confirmErr
+	}
+	imported, err := poolManager.Import(ctx, c.pool, c.free, false)
+	fmt.Printf("%d instances imported\n", len(imported))
+	return err
+}
+
+func (c *importCommand) print(instances []*drivers.ImportedInstance) error {
+	if c.json {
+		out := make([]*drivers.ImportedInstance, 0, len(instances))
+		for _, inst := range instances {
+			out = append(out, &drivers.ImportedInstance{Instance: redacted(inst.Instance), Reusable: inst.Reusable})
+		}
+		return printJSON(out)
+	}
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint:gomnd
+	fmt.Fprintln(w, "ID\tPOOL\tSTATE\tHIBERNATED\tREUSABLE\tADDRESS\tAGE")
+	for _, inst := range instances {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%t\t%s\t%s\n",
+			inst.ID, inst.Pool, inst.State, inst.IsHibernated, inst.Reusable, inst.Address, age(now, inst.Started))
+	}
+	return w.Flush()
+}
+
+func (c *sshCommand) run(*kingpin.ParseContext) error {
+	ctx, cancel := context.WithTimeout(signal.WithContext(context.Background()), commandTimeout)
+	poolManager, inst, closeStore, err := c.find(ctx, c.id)
+	if err != nil {
+		cancel()
+		return err
+	}
+	switch {
+	case inst.IsHibernated:
+		err = fmt.Errorf("instances: the instance %s is hibernated, start it first", inst.ID)
+	case inst.Address == "":
+		err = fmt.Errorf("instances: the instance %s has no address", inst.ID)
+	}
+	var keys []ssh.PublicKey
+	if err == nil {
+		keys, err = hostKeys(ctx, poolManager, inst)
+	}
+	closeStore()
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	args := []string{}
+	if len(keys) > 0 {
+		knownHosts, removeKnownHosts, knownHostsErr := writeKnownHosts(inst.ID, keys)
+		if knownHostsErr != nil {
+			return knownHostsErr
+		}
+		defer removeKnownHosts()
+		// the keys are known by the id of the instance, they stay valid when its address changes.
+		args = append(args, "-o", "HostKeyAlias="+inst.ID, "-o", "UserKnownHostsFile="+knownHosts, "-o", "StrictHostKeyChecking=yes")
+	} else {
+		fmt.Fprintf(os.Stderr, "instances: the host key of the instance %s is not recorded, ssh verifies it against your known hosts\n", inst.ID)
+	}
+	if c.identity != "" {
+		args = append(args, "-i", c.identity)
+	}
+	args = append(args, c.user+"@"+inst.Address)
+	args = append(args, c.args...)
+	cmd := exec.Command("ssh", args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// hostKeys returns the host keys recorded for the instance. If none are recorded, the host keys the
+// instance printed to its console are recorded, for the drivers reading the console output.
+func hostKeys(ctx context.Context, poolManager *drivers.Manager, inst *types.Instance) ([]ssh.PublicKey, error) {
+	if inst.HostKey != "" {
+		keys, err := lehelper.ParseHostKeys(inst.HostKey)
+		if err != nil {
+			return nil, fmt.Errorf("instances: the host key of the instance %s: %w", inst.ID, err)
+		}
+		return keys, nil
+	}
+	console, err := poolManager.InstanceLogs(ctx, inst.Pool, inst.ID)
+	if err != nil {
+		if !errors.Is(err, drivers.ErrNotSupported) {
+			fmt.Fprintf(os.Stderr, "instances: unable to read the console output of the instance %s: %s\n", inst.ID, err)
+		}
+		return nil, nil
+	}
+	keys := lehelper.ParseConsoleHostKeys(console)
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	if err = poolManager.RecordHostKey(ctx, inst.ID, lehelper.MarshalHostKeys(keys...)); err != nil {
+		fmt.Fprintf(os.Stderr, "instances: unable to record the host keys of the instance %s: %s\n", inst.ID, err)
+	}
+	fmt.Fprintf(os.Stderr, "instances: the host keys of the instance %s were read from its console: %s\n",
+		inst.ID, lehelper.HostKeyFingerprints(keys))
+	return keys, nil
+}
+
+// hostKeyFingerprints returns the fingerprints of the recorded host keys, or the recorded host keys
+// if they can't be parsed.
+func hostKeyFingerprints(hostKey string) string {
+	keys, err := lehelper.ParseHostKeys(hostKey)
+	if err != nil {
+		return hostKey
+	}
+	return lehelper.HostKeyFingerprints(keys)
+}
+
+// writeKnownHosts writes the host keys of the instance to a temporary known_hosts file, under the
+// id of the instance, and returns the file and the cleanup removing it.
+func writeKnownHosts(id string, keys []ssh.PublicKey) (string, func(), error) {
+	f, err := os.CreateTemp("", "known_hosts")
+	if err != nil {
+		return "", nil, fmt.Errorf("instances: unable to write the known hosts: %w", err)
+	}
+	for _, key := range keys {
+		fmt.Fprintln(f, knownhosts.Line([]string{id}, key))
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", nil, fmt.Errorf("instances: unable to write the known hosts: %w", err)
+	}
+	return f.Name(), func() { os.Remove(f.Name()) }, nil
+}
+
+// confirm asks the question and reports whether it was answered yes, or returns true if the
+// confirmation is skipped.
+func confirm(ctx context.Context, in io.Reader, out io.Writer, question string, skip bool) (bool, error) {
+	if skip {
+		return true, nil
+	}
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	// the answer is read from the background, the prompt is left on an interrupt.
+	answers := make(chan string, 1)
+	errs := make(chan error, 1)
+	go func() {
+		answer, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil && err != io.EOF {
+			errs <- err
+			return
+		}
+		answers <- answer
+	}()
+	var answer string
+	select {
+	case <-ctx.Done():
+		fmt.Fprintln(out)
+		return false, ctx.Err()
+	case err := <-errs:
+		return false, err
+	case answer = <-answers:
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	fmt.Fprintln(out, "aborted")
+	return false, nil
+}
+
+// redacted returns the instance without its keys, the keys of the lite-engine are not printed.
+func redacted(inst *types.Instance) *types.Instance {
+	out := *inst
+	out.CAKey, out.TLSKey, out.LeaseKey, out.KeyRef = nil, nil, "", ""
+	return &out
+}
+
+func age(now time.Time, started int64) string {
+	if started == 0 {
+		return ""
+	}
+	return now.Sub(time.Unix(started, 0)).Round(time.Second).String()
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,87 @@
+package pool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const requestTimeout = 10 * time.Minute
+
+type deleteCommand struct {
+	endpoint    string
+	name        string
+	drain       bool
+	timeoutMins int64
+}
+
+// Register registers the commands managing the pools of a running runner.
+func Register(app *kingpin.Application) {
+	c := new(deleteCommand)
+
+	pool := app.Command("pool", "manages the pools of a running runner")
+	cmd := pool.Command("delete", "deletes a pool, with drain the busy instances of the pool complete first").
+		Action(c.run)
+	cmd.Arg("name", "the name of the pool").
+		Required().
+		StringVar(&c.name)
+	cmd.Flag("endpoint", "the address of the runner").
+		Default("http://127.0.0.1:3000").
+		StringVar(&c.endpoint)
+	cmd.Flag("drain", "stop assigning stages to the pool and wait for its busy instances").
+		BoolVar(&c.drain)
+	cmd.Flag("timeout-mins", "how long to wait for the busy instances, defaults to the drain timeout of the runner").
+		Int64Var(&c.timeoutMins)
+}
+
+func (c *deleteCommand) run(*kingpin.ParseContext) error {
+	query := url.Values{}
+	query.Set("drain", strconv.FormatBool(c.drain))
+	if c.timeoutMins > 0 {
+		query.Set("timeout_mins", strconv.FormatInt(c.timeoutMins, 10))
+	}
+	path := strings.TrimSuffix(c.endpoint, "/") + "/pools/" + url.PathEscape(c.name) + "?" + query.Encode()
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, path, http.NoBody)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("pool: failed to reach the runner: %w", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode > 299 { //nolint:gomnd
+		out := new(struct {
+			Message string `json:"error_msg"`
+		})
+		if jsonErr := json.Unmarshal(body, out); jsonErr == nil && out.Message != "" {
+			return fmt.Errorf("pool: failed to delete the pool: %s", out.Message)
+		}
+		return fmt.Errorf("pool: failed to delete the pool: %s", http.StatusText(res.StatusCode))
+	}
+
+	out := new(struct {
+		Status string `json:"status"`
+	})
+	if err = json.Unmarshal(body, out); err != nil {
+		return err
+	}
+	fmt.Printf("pool %s: %s\n", c.name, out.Status)
+	return nil
+}
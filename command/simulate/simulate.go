@@ -0,0 +1,297 @@
+package simulate
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/command/harness"
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/internal/drivers/noop"
+	"github.com/drone-runners/drone-runner-aws/internal/poolfile"
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/store/database"
+	"github.com/drone/signal"
+	"github.com/google/uuid"
+	"github.com/harness/lite-engine/api"
+
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const runnerName = "simulate"
+
+// phases of a simulated stage.
+const (
+	phaseSetup   = "setup"
+	phaseStep    = "step"
+	phaseDestroy = "destroy"
+)
+
+// maxCauseLen truncates the failure causes, the errors of the same cause differ by their ids.
+const maxCauseLen = 120
+
+type simulateCommand struct {
+	envFile     string
+	poolFile    string
+	pools       []string
+	concurrency int
+	stages      int
+	noop        bool
+	stepSecs    int
+}
+
+// result is the outcome of a phase of a simulated stage.
+type result struct {
+	phase    string
+	pool     string
+	duration time.Duration
+	err      error
+}
+
+// Register registers the command simulating the stages of the pipelines against the pools.
+func Register(app *kingpin.Application) {
+	c := new(simulateCommand)
+
+	cmd := app.Command("simulate", "simulates concurrent stages against the pools and reports the latencies and the failures").
+		Action(c.run)
+	cmd.Flag("envfile", "load the environment variable file").
+		StringVar(&c.envFile)
+	cmd.Flag("pool", "the pool file").
+		StringVar(&c.poolFile)
+	cmd.Flag("target", "the pools the stages are set up on, all the pools by default").
+		StringsVar(&c.pools)
+	cmd.Flag("concurrency", "number of stages running at the same time").
+		Default("10").
+		IntVar(&c.concurrency)
+	cmd.Flag("stages", "number of stages run by every concurrent worker").
+		Default("1").
+		IntVar(&c.stages)
+	cmd.Flag("noop", "replace the drivers of the pools by the noop driver and mock the lite-engine").
+		BoolVar(&c.noop)
+	cmd.Flag("step-secs", "duration of the step of a stage with the mocked lite-engine").
+		Default("5").
+		IntVar(&c.stepSecs)
+}
+
+func (c *simulateCommand) run(*kingpin.ParseContext) error {
+	// load environment variables from file.
+	if err := godotenv.Load(c.envFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	env, err := config.FromEnviron()
+	if err != nil {
+		return err
+	}
+	if c.noop {
+		env.LiteEngine.EnableMock = true
+		env.LiteEngine.MockStepTimeoutSecs = c.stepSecs
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// an interrupted simulation still cleans the pools and closes the database.
+	ctx = signal.WithContextFunc(ctx, func() {
+		println("simulate: received signal, terminating process")
+		cancel()
+	})
+	// the stages are stored in the database of the runner, so its throughput is part of the simulation.
+	instanceStore, stageOwnerStore, destroyRetryStore, stageRecordStore, _, _, closeStore, err := database.ProvideStore(env.Database.Driver, env.Database.Datasource, database.EnvOptions(&env)...)
+	if err != nil {
+		return fmt.Errorf("simulate: unable to open the database: %w", err)
+	}
+	defer closeStore()
+	poolManager := drivers.New(ctx, instanceStore, &env)
+	poolManager.SetDestroyRetryStore(destroyRetryStore)
+	poolManager.SetStageRecordStore(stageRecordStore)
+
+	configPool, err := poolfile.ConfigPoolFile(c.poolFile, &env)
+	if err != nil {
+		return fmt.Errorf("simulate: unable to load the pool file: %w", err)
+	}
+	pools, err := poolfile.ProcessPool(configPool, runnerName)
+	if err != nil {
+		return fmt.Errorf("simulate: unable to process the pool file: %w", err)
+	}
+	if c.noop {
+		for i := range pools {
+			if pools[i].Driver, err = noop.New(noop.WithRootDirectory()); err != nil {
+				return err
+			}
+			pools[i].Regions = nil
+			pools[i].Bootstrapper = nil
+			pools[i].Tunnel = false
+			pools[i].Preflight = false
+		}
+	}
+	if err = poolManager.Add(pools...); err != nil {
+		return fmt.Errorf("simulate: unable to add the pools: %w", err)
+	}
+	targets := c.pools
+	if len(targets) == 0 {
+		for i := range pools {
+			targets = append(targets, pools[i].Name)
+		}
+	}
+	for _, target := range targets {
+		if !poolManager.Exists(target) {
+			return fmt.Errorf("simulate: pool %q not found", target)
+		}
+	}
+
+	defer func() {
+		if cleanErr := poolManager.CleanPools(context.Background(), true, true); cleanErr != nil {
+			logrus.WithError(cleanErr).Errorln("simulate: unable to clean the pools")
+		}
+	}()
+	logrus.Infoln("simulate: building the pools")
+	if err = poolManager.BuildPools(ctx); err != nil {
+		return fmt.Errorf("simulate: unable to build the pools: %w", err)
+	}
+
+	logrus.WithField("concurrency", c.concurrency).
+		WithField("stages", c.concurrency*c.stages).
+		Infoln("simulate: running the stages")
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for w := 0; w < c.concurrency; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < c.stages; i++ {
+				c.runStage(ctx, targets[(w+i)%len(targets)], &env, stageOwnerStore, poolManager, results)
+			}
+		}(w)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	started := time.Now()
+	var all []result
+	for r := range results {
+		all = append(all, r)
+	}
+	return printReport(all, time.Since(started))
+}
+
+// runStage sets up a stage, runs a step and destroys the stage like the control plane does.
+func (c *simulateCommand) runStage(ctx context.Context, pool string, env *config.EnvConfig, s store.StageOwnerStore,
+	poolManager *drivers.Manager, results chan<- result) {
+	id := uuid.NewString()
+	mount := false
+
+	start := time.Now()
+	setup, err := harness.HandleSetup(ctx, &harness.SetupVMRequest{
+		ID:           id,
+		PoolID:       pool,
+		SetupRequest: api.SetupRequest{MountDockerSocket: &mount},
+	}, s, env, poolManager)
+	results <- result{phase: phaseSetup, pool: pool, duration: time.Since(start), err: err}
+	if err != nil {
+		return
+	}
+	// the stage might be set up on a fallback or a shadow pool.
+	pool = setup.PoolID
+
+	start = time.Now()
+	resp, err := harness.HandleStep(ctx, &harness.ExecuteVMRequest{
+		StageRuntimeID: id,
+		CorrelationID:  id,
+		PoolID:         pool,
+		StartStepRequest: api.StartStepRequest{
+			ID: uuid.NewString(),
+			Run: api.RunConfig{
+				Command:    []string{fmt.Sprintf("sleep %d", c.stepSecs)},
+				Entrypoint: []string{"sh", "-c"},
+			},
+		},
+	}, s, env, poolManager)
+	if err == nil && resp.Error != "" {
+		err = fmt.Errorf("step failed: %s", resp.Error)
+	}
+	results <- result{phase: phaseStep, pool: pool, duration: time.Since(start), err: err}
+
+	start = time.Now()
+	_, err = harness.HandleDestroy(ctx, &harness.VMCleanupRequest{PoolID: pool, StageRuntimeID: id}, s, poolManager)
+	results <- result{phase: phaseDestroy, pool: pool, duration: time.Since(start), err: err}
+}
+
+// printReport prints the latency distribution and the failures of every phase, and the causes of the failures.
+func printReport(results []result, elapsed time.Duration) error {
+	durations := map[string][]time.Duration{}
+	failures := map[string]int{}
+	causes := map[string]int{}
+	for _, r := range results {
+		if r.err != nil {
+			failures[r.phase]++
+			causes[r.phase+": "+failureCause(r.err)]++
+			continue
+		}
+		durations[r.phase] = append(durations[r.phase], r.duration)
+	}
+
+	fmt.Printf("simulated %d stages in %s\n\n", len(durations[phaseSetup])+failures[phaseSetup], elapsed.Round(time.Second))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint:gomnd
+	fmt.Fprintln(w, "PHASE\tOK\tFAILED\tP50\tP95\tP99\tMAX")
+	for _, phase := range []string{phaseSetup, phaseStep, phaseDestroy} {
+		d := durations[phase]
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\n", phase, len(d), failures[phase],
+			percentile(d, 0.5), percentile(d, 0.95), percentile(d, 0.99), percentile(d, 1)) //nolint:gomnd
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(causes) == 0 {
+		return nil
+	}
+
+	type cause struct {
+		text  string
+		count int
+	}
+	sorted := make([]cause, 0, len(causes))
+	for text, count := range causes {
+		sorted = append(sorted, cause{text: text, count: count})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].count > sorted[j].count })
+	fmt.Println("\nfailure causes:")
+	for _, c := range sorted {
+		fmt.Printf("%6d  %s\n", c.count, c.text)
+	}
+	return nil
+}
+
+// failureCause returns the first line of the error, truncated.
+func failureCause(err error) string {
+	cause := strings.SplitN(err.Error(), "\n", 2)[0] //nolint:gomnd
+	if len(cause) > maxCauseLen {
+		cause = cause[:maxCauseLen] + "..."
+	}
+	return cause
+}
+
+// percentile returns the nearest-rank percentile of the sorted durations, 0 if there are none.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1].Round(time.Millisecond)
+}
@@ -0,0 +1,222 @@
+// Package alert notifies the operators of the errors of the runner which need them, e.g. a pool
+// failing to create instances. The identical alerts raised within a window are sent once, with
+// their count, so a failing pool doesn't page once per stage.
+package alert
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Severities of the alerts.
+const (
+	SeverityCritical = "critical"
+	SeverityError    = "error"
+	SeverityWarning  = "warning"
+)
+
+const (
+	defaultWindow       = 5 * time.Minute
+	defaultMaxPerWindow = 20
+	notifyTimeout       = 30 * time.Second
+)
+
+// Alert is an error raised by the runner. The alerts with the same key are identical.
+type Alert struct {
+	Key      string
+	Severity string
+	Summary  string
+	Pool     string
+	Error    string
+}
+
+// Notification is the alerts of a key raised within a window, the summary and the error are the
+// ones of the last alert.
+type Notification struct {
+	Key       string    `json:"key"`
+	Severity  string    `json:"severity"`
+	Summary   string    `json:"summary"`
+	Pool      string    `json:"pool,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Runner    string    `json:"runner"`
+}
+
+// Notifier sends the notifications, e.g. to a webhook or to PagerDuty.
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// Config configures the notifiers of the alerts.
+type Config struct {
+	Runner              string        // the name of the runner the alerts are raised by
+	Window              time.Duration // the identical alerts are aggregated within, 5 minutes if zero
+	MaxPerWindow        int           // the notifications sent per window, the others are summed up in one
+	WebhookURL          string        // the JSON notifications are posted to the URL
+	PagerDutyRoutingKey string        // the integration key of a PagerDuty service
+}
+
+// Alerter aggregates the alerts raised within a window and sends a notification per key when
+// the window ends.
+type Alerter struct {
+	runner       string
+	window       time.Duration
+	maxPerWindow int
+	notifiers    []Notifier
+
+	mu      sync.Mutex
+	pending map[string]*Notification
+}
+
+// New returns the alerter of the config, nil if no notifier is set.
+func New(cfg *Config) *Alerter {
+	var notifiers []Notifier
+	if cfg.WebhookURL != "" {
+		notifiers = append(notifiers, NewWebhook(cfg.WebhookURL))
+	}
+	if cfg.PagerDutyRoutingKey != "" {
+		notifiers = append(notifiers, NewPagerDuty(cfg.PagerDutyRoutingKey))
+	}
+	if len(notifiers) == 0 {
+		return nil
+	}
+	return newAlerter(cfg, notifiers...)
+}
+
+func newAlerter(cfg *Config, notifiers ...Notifier) *Alerter {
+	a := &Alerter{
+		runner:       cfg.Runner,
+		window:       cfg.Window,
+		maxPerWindow: cfg.MaxPerWindow,
+		notifiers:    notifiers,
+		pending:      map[string]*Notification{},
+	}
+	if a.window <= 0 {
+		a.window = defaultWindow
+	}
+	if a.maxPerWindow <= 0 {
+		a.maxPerWindow = defaultMaxPerWindow
+	}
+	return a
+}
+
+// Raise adds the alert to the notification of its key. It's a no-op on a nil alerter.
+func (a *Alerter) Raise(al Alert) {
+	if a == nil {
+		return
+	}
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n, ok := a.pending[al.Key]
+	if !ok {
+		n = &Notification{Key: al.Key, FirstSeen: now, Runner: a.runner}
+		a.pending[al.Key] = n
+	}
+	n.Severity = al.Severity
+	n.Summary = al.Summary
+	n.Pool = al.Pool
+	n.Error = al.Error
+	n.Count++
+	n.LastSeen = now
+}
+
+// Start sends the notifications at the end of every window until the context is done, the
+// pending ones are sent then.
+func (a *Alerter) Start(ctx context.Context) {
+	if a == nil {
+		return
+	}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logrus.Errorf("alert: recovered from panic: %v", r)
+			}
+		}()
+		ticker := time.NewTicker(a.window)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				a.Flush(context.Background())
+				return
+			case <-ticker.C:
+				a.Flush(ctx)
+			}
+		}
+	}()
+}
+
+// Flush sends the pending notifications, the most frequent first. Beyond the max per window the
+// notifications are summed up in one.
+func (a *Alerter) Flush(ctx context.Context) {
+	a.mu.Lock()
+	pending := make([]*Notification, 0, len(a.pending))
+	for _, n := range a.pending {
+		pending = append(pending, n)
+	}
+	a.pending = map[string]*Notification{}
+	a.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].Count != pending[j].Count {
+			return pending[i].Count > pending[j].Count
+		}
+		return pending[i].Key < pending[j].Key
+	})
+	if len(pending) > a.maxPerWindow {
+		dropped := pending[a.maxPerWindow-1:]
+		summary := &Notification{
+			Key:       "alert:suppressed",
+			Severity:  SeverityWarning,
+			Summary:   fmt.Sprintf("%d more kinds of alerts were raised, see the logs of the runner", len(dropped)),
+			FirstSeen: dropped[0].FirstSeen,
+			LastSeen:  dropped[0].LastSeen,
+			Runner:    a.runner,
+		}
+		for _, n := range dropped {
+			summary.Count += n.Count
+			if n.FirstSeen.Before(summary.FirstSeen) {
+				summary.FirstSeen = n.FirstSeen
+			}
+			if n.LastSeen.After(summary.LastSeen) {
+				summary.LastSeen = n.LastSeen
+			}
+		}
+		pending = append(pending[:a.maxPerWindow-1], summary)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+	for _, n := range pending {
+		for _, notifier := range a.notifiers {
+			if err := notifier.Notify(ctx, n); err != nil {
+				logrus.WithError(err).
+					WithField("key", n.Key).
+					WithField("count", n.Count).
+					Errorln("alert: could not send the notification")
+			}
+		}
+	}
+}
+
+// variable matches the parts of the error messages changing from an occurrence to the next, the
+// identifiers, the addresses and the numbers.
+var variable = regexp.MustCompile(`[0-9a-fA-F]{8}(-?[0-9a-fA-F]{4}){3}-?[0-9a-fA-F]{12}|[0-9a-fA-F]*[0-9][0-9a-fA-F]*`)
+
+// Fingerprint returns the message without its identifiers and numbers, the errors of the same
+// cause have the same fingerprint.
+func Fingerprint(msg string) string {
+	return variable.ReplaceAllString(msg, "#")
+}
@@ -0,0 +1,84 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type recorder struct {
+	mu    sync.Mutex
+	notes []*Notification
+}
+
+func (r *recorder) Notify(_ context.Context, n *Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notes = append(r.notes, n)
+	return nil
+}
+
+func TestAlerterAggregates(t *testing.T) {
+	r := &recorder{}
+	a := newAlerter(&Config{Runner: "runner-1", MaxPerWindow: 2}, r)
+	for i := 0; i < 3; i++ {
+		a.Raise(Alert{Key: "provision:linux", Severity: SeverityError, Summary: "pool linux fails to create instances", Pool: "linux"})
+	}
+	a.Raise(Alert{Key: "provision:windows", Severity: SeverityError, Pool: "windows"})
+	a.Raise(Alert{Key: "destroy:linux", Severity: SeverityError, Pool: "linux"})
+	a.Flush(context.Background())
+
+	if len(r.notes) != 2 {
+		t.Fatalf("got %d notifications, want 2", len(r.notes))
+	}
+	if n := r.notes[0]; n.Key != "provision:linux" || n.Count != 3 || n.Runner != "runner-1" {
+		t.Errorf("unexpected notification %+v", n)
+	}
+	if n := r.notes[1]; n.Key != "alert:suppressed" || n.Count != 2 {
+		t.Errorf("the alerts beyond the max are not summed up: %+v", n)
+	}
+
+	a.Flush(context.Background())
+	if len(r.notes) != 2 {
+		t.Error("the notifications are sent again")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a := Fingerprint("failed to create instance i-0a1b2c3d4e: request 9f86d081-884c-7d65-9a2f-eaa0c55ad015 timed out after 30s")
+	b := Fingerprint("failed to create instance i-07f8e9d0c1: request 2c26b46b-68ff-c68f-f99b-453c1d304134 timed out after 30s")
+	if a != b {
+		t.Errorf("the fingerprints differ:\n%s\n%s", a, b)
+	}
+	if Fingerprint("quota exceeded") == Fingerprint("image not found") {
+		t.Error("the fingerprints of different errors are equal")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var got Notification
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	if err := NewWebhook(srv.URL).Notify(context.Background(), &Notification{Key: "provision:linux", Count: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if got.Key != "provision:linux" || got.Count != 4 {
+		t.Errorf("unexpected notification %+v", got)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if New(&Config{}) != nil {
+		t.Error("alerter without a notifier")
+	}
+	var a *Alerter
+	a.Raise(Alert{Key: "noop"}) // no-op on a nil alerter
+}
@@ -0,0 +1,91 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// pagerDutyURL is the endpoint of the events API v2 of PagerDuty.
+const pagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// webhook posts the notifications as JSON to a URL.
+type webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook returns the notifier posting the notifications to the URL.
+func NewWebhook(url string) Notifier {
+	return &webhook{url: url, client: &http.Client{Timeout: notifyTimeout}}
+}
+
+func (w *webhook) Notify(ctx context.Context, n *Notification) error {
+	return post(ctx, w.client, w.url, n)
+}
+
+// pagerDuty triggers the incidents of a PagerDuty service, deduplicated by the key of the alerts.
+type pagerDuty struct {
+	url        string
+	routingKey string
+	client     *http.Client
+}
+
+// NewPagerDuty returns the notifier triggering the incidents of the service of the integration key.
+func NewPagerDuty(routingKey string) Notifier {
+	return &pagerDuty{url: pagerDutyURL, routingKey: routingKey, client: &http.Client{Timeout: notifyTimeout}}
+}
+
+func (p *pagerDuty) Notify(ctx context.Context, n *Notification) error {
+	details := map[string]string{
+		"count":      strconv.Itoa(n.Count),
+		"first_seen": n.FirstSeen.UTC().Format(time.RFC3339),
+		"last_seen":  n.LastSeen.UTC().Format(time.RFC3339),
+	}
+	if n.Pool != "" {
+		details["pool"] = n.Pool
+	}
+	if n.Error != "" {
+		details["error"] = n.Error
+	}
+	event := map[string]interface{}{
+		"routing_key":  p.routingKey,
+		"event_action": "trigger",
+		"dedup_key":    n.Runner + "/" + n.Key,
+		"payload": map[string]interface{}{
+			"summary":        fmt.Sprintf("%s (%d times)", n.Summary, n.Count),
+			"source":         n.Runner,
+			"severity":       n.Severity,
+			"component":      n.Pool,
+			"custom_details": details,
+		},
+	}
+	return post(ctx, p.client, p.url, event)
+}
+
+func post(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 { //nolint:gomnd
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024)) //nolint:gomnd
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
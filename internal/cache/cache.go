@@ -0,0 +1,194 @@
+// Package cache stores the caches of the stages in a bucket, e.g. the dependencies downloaded by
+// their steps. A cache is restored on the instance of a stage at its setup and saved from it at its
+// destroy, the instances upload and download the entries through the short-lived URLs signed by
+// the runner and need no access to the bucket.
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/objectstore"
+)
+
+var (
+	// ErrNotFound is returned if no entry matches the keys of a restore.
+	ErrNotFound = errors.New("cache: no entry matches the keys")
+	// ErrExists is returned if the entry of a save exists, the entries are never overwritten.
+	ErrExists = errors.New("cache: the entry exists")
+	// ErrLocked is returned if the entry of a save is being saved by another stage.
+	ErrLocked = errors.New("cache: the entry is being saved")
+)
+
+// Config configures the cache of the runner.
+type Config struct {
+	Store   objectstore.Config
+	Prefix  string // the prefix of the entries
+	MaxSize int64  // the total size of the entries in bytes, no limit if zero
+}
+
+// Cache resolves the keys of the stages to the entries of a backend.
+type Cache struct {
+	backend objectstore.Backend
+	prefix  string
+	maxSize int64
+
+	mu     sync.Mutex
+	saving map[string]bool
+}
+
+// New returns the cache of the config.
+func New(ctx context.Context, cfg *Config) (*Cache, error) {
+	backend, err := objectstore.NewBackend(ctx, &cfg.Store)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithBackend(backend, cfg.Prefix, cfg.MaxSize), nil
+}
+
+// NewWithBackend returns a cache storing its entries under the prefix of the backend.
+func NewWithBackend(backend objectstore.Backend, prefix string, maxSize int64) *Cache {
+	return &Cache{
+		backend: backend,
+		prefix:  strings.Trim(prefix, "/"),
+		maxSize: maxSize,
+		saving:  map[string]bool{},
+	}
+}
+
+// Restore returns the request downloading the entry of the first key matching one, and the key of
+// the entry. A key matches the entry of the key, or else the newest entry whose key starts with it.
+func (c *Cache) Restore(ctx context.Context, keys []string, ttl time.Duration) (req *objectstore.Request, key string, err error) {
+	objects, err := c.backend.List(ctx, c.entryPrefix())
+	if err != nil {
+		return nil, "", fmt.Errorf("cache: could not list the entries: %w", err)
+	}
+	// the newest entries first
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Modified.After(objects[j].Modified)
+	})
+	for _, k := range keys {
+		if k == "" {
+			continue
+		}
+		name := c.objectName(k)
+		match := ""
+		for i := range objects {
+			if objects[i].Name == name {
+				match = name
+				break
+			}
+			if match == "" && strings.HasPrefix(objects[i].Name, strings.TrimSuffix(name, entrySuffix)) {
+				match = objects[i].Name
+			}
+		}
+		if match == "" {
+			continue
+		}
+		if req, err = c.backend.SignGet(ctx, match, ttl); err != nil {
+			return nil, "", fmt.Errorf("cache: could not sign the download of %s: %w", k, err)
+		}
+		return req, c.entryKey(match), nil
+	}
+	return nil, "", ErrNotFound
+}
+
+// Save returns the request uploading the entry of the key. The key is locked until unlock is
+// called, once the upload completed or failed. It returns ErrExists if the entry exists and
+// ErrLocked if another stage is saving it.
+func (c *Cache) Save(ctx context.Context, key string, ttl time.Duration) (req *objectstore.Request, unlock func(), err error) {
+	name := c.objectName(key)
+	c.mu.Lock()
+	if c.saving[name] {
+		c.mu.Unlock()
+		return nil, nil, ErrLocked
+	}
+	c.saving[name] = true
+	c.mu.Unlock()
+	unlock = func() {
+		c.mu.Lock()
+		delete(c.saving, name)
+		c.mu.Unlock()
+	}
+
+	objects, err := c.backend.List(ctx, name)
+	if err != nil {
+		unlock()
+		return nil, nil, fmt.Errorf("cache: could not list the entries: %w", err)
+	}
+	for i := range objects {
+		if objects[i].Name == name {
+			unlock()
+			return nil, nil, ErrExists
+		}
+	}
+	if req, err = c.backend.SignPut(ctx, name, ttl); err != nil {
+		unlock()
+		return nil, nil, fmt.Errorf("cache: could not sign the upload of %s: %w", key, err)
+	}
+	return req, unlock, nil
+}
+
+// Evict deletes the oldest entries until the entries fit the max size of the cache, it returns
+// the number of entries deleted.
+func (c *Cache) Evict(ctx context.Context) (int, error) {
+	if c.maxSize <= 0 {
+		return 0, nil
+	}
+	objects, err := c.backend.List(ctx, c.entryPrefix())
+	if err != nil {
+		return 0, fmt.Errorf("cache: could not list the entries: %w", err)
+	}
+	var total int64
+	for i := range objects {
+		total += objects[i].Size
+	}
+	// the oldest entries first
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Modified.Before(objects[j].Modified)
+	})
+	deleted := 0
+	for i := 0; i < len(objects) && total > c.maxSize; i++ {
+		if err = c.backend.Delete(ctx, objects[i].Name); err != nil {
+			return deleted, fmt.Errorf("cache: could not delete %s: %w", objects[i].Name, err)
+		}
+		total -= objects[i].Size
+		deleted++
+	}
+	return deleted, nil
+}
+
+// entrySuffix is the extension of the entries, gzipped tarballs.
+const entrySuffix = ".tar.gz"
+
+func (c *Cache) entryPrefix() string {
+	if c.prefix == "" {
+		return ""
+	}
+	return c.prefix + "/"
+}
+
+// objectName returns the name of the entry of the key. The characters of the key unsafe in an
+// object name are replaced, the names of the keys sharing a prefix share it.
+func (c *Cache) objectName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	return path.Join(c.entryPrefix(), name) + entrySuffix
+}
+
+// entryKey returns the key of the entry, as the unsafe characters were replaced.
+func (c *Cache) entryKey(name string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(name, c.entryPrefix()), entrySuffix)
+}
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/objectstore"
+)
+
+// memBackend keeps the entries in memory, the urls are the names of the entries.
+type memBackend struct {
+	mu      sync.Mutex
+	objects map[string]objectstore.Object
+}
+
+func (b *memBackend) SignGet(_ context.Context, name string, _ time.Duration) (*objectstore.Request, error) {
+	return &objectstore.Request{URL: "get:" + name}, nil
+}
+
+func (b *memBackend) SignPut(_ context.Context, name string, _ time.Duration) (*objectstore.Request, error) {
+	return &objectstore.Request{URL: "put:" + name}, nil
+}
+
+func (b *memBackend) List(_ context.Context, prefix string) ([]objectstore.Object, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var objects []objectstore.Object
+	for name, obj := range b.objects {
+		if strings.HasPrefix(name, prefix) {
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
+
+func (b *memBackend) Delete(_ context.Context, name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.objects, name)
+	return nil
+}
+
+func (b *memBackend) put(name string, size int64, modified time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[name] = objectstore.Object{Name: name, Size: size, Modified: modified}
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	backend := &memBackend{objects: map[string]objectstore.Object{}}
+	backend.put("ci/npm-linux-abc.tar.gz", 10, now.Add(-2*time.Hour))
+	backend.put("ci/npm-linux-def.tar.gz", 10, now.Add(-time.Hour))
+	backend.put("ci/npm-linux.tar.gz", 10, now.Add(-3*time.Hour))
+	c := NewWithBackend(backend, "/ci/", 0)
+
+	tests := []struct {
+		keys []string
+		want string
+	}{
+		{keys: []string{"npm-linux-abc", "npm-linux"}, want: "npm-linux-abc"},
+		// the newest entry of the prefix
+		{keys: []string{"npm-linux-xyz", "npm-linux-"}, want: "npm-linux-def"},
+		// the entry of the key before the newer entries of the prefix
+		{keys: []string{"npm-linux"}, want: "npm-linux"},
+		{keys: []string{"", "npm/linux-abc"}, want: ""},
+	}
+	for _, test := range tests {
+		req, key, err := c.Restore(ctx, test.keys, time.Minute)
+		if test.want == "" {
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("%v: expected no entry, got %v", test.keys, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %s", test.keys, err)
+			continue
+		}
+		if key != test.want || req.URL != "get:ci/"+test.want+".tar.gz" {
+			t.Errorf("%v: expected the entry %s, got %s at %s", test.keys, test.want, key, req.URL)
+		}
+	}
+}
+
+func TestSave(t *testing.T) {
+	ctx := context.Background()
+	backend := &memBackend{objects: map[string]objectstore.Object{}}
+	backend.put("npm-abc.tar.gz", 10, time.Now())
+	c := NewWithBackend(backend, "", 0)
+
+	if _, _, err := c.Save(ctx, "npm-abc", time.Minute); !errors.Is(err, ErrExists) {
+		t.Errorf("expected the existing entry not to be saved, got %v", err)
+	}
+	req, unlock, err := c.Save(ctx, "npm-def", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL != "put:npm-def.tar.gz" {
+		t.Errorf("unexpected upload url %s", req.URL)
+	}
+	if _, _, err = c.Save(ctx, "npm-def", time.Minute); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected the entry being saved to be locked, got %v", err)
+	}
+	unlock()
+	if _, unlock, err = c.Save(ctx, "npm-def", time.Minute); err != nil {
+		t.Errorf("expected the entry to be unlocked, got %v", err)
+	} else {
+		unlock()
+	}
+}
+
+func TestEvict(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	backend := &memBackend{objects: map[string]objectstore.Object{}}
+	backend.put("ci/old.tar.gz", 40, now.Add(-3*time.Hour))
+	backend.put("ci/mid.tar.gz", 40, now.Add(-2*time.Hour))
+	backend.put("ci/new.tar.gz", 40, now.Add(-time.Hour))
+	backend.put("other/big.tar.gz", 1000, now.Add(-4*time.Hour))
+
+	if n, err := NewWithBackend(backend, "ci", 0).Evict(ctx); err != nil || n != 0 {
+		t.Errorf("expected no eviction without a max size, got %d, %v", n, err)
+	}
+	n, err := NewWithBackend(backend, "ci", 100).Evict(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected one entry to be evicted, got %d", n)
+	}
+	var names []string
+	for name := range backend.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "ci/mid.tar.gz,ci/new.tar.gz,other/big.tar.gz" {
+		t.Errorf("expected the oldest entry of the cache to be evicted, got %v", names)
+	}
+}
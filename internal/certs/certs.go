@@ -8,20 +8,36 @@ import (
 	"github.com/harness/lite-engine/cli/certs"
 )
 
-func Generate(runnerName string) (*types.InstanceCreateOpts, error) {
-	ca, err := certs.GenerateCA()
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate ca certificate: %w", err)
+// CA is a PEM encoded certificate authority signing the certificates of the lite-engines.
+type CA struct {
+	Cert []byte
+	Key  []byte
+}
+
+// Generate returns the certificates of a new instance signed by the CA, a new CA is generated if nil.
+func Generate(runnerName string, ca *CA) (*types.InstanceCreateOpts, error) {
+	var caCert *certs.Certificate
+	if ca != nil {
+		caCert = &certs.Certificate{Cert: ca.Cert, Key: ca.Key}
+	} else {
+		var err error
+		if caCert, err = certs.GenerateCA(); err != nil {
+			return nil, fmt.Errorf("failed to generate ca certificate: %w", err)
+		}
 	}
-	tlsCert, err := certs.GenerateCert(runnerName, ca)
+	tlsCert, err := certs.GenerateCert(runnerName, caCert)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate tls certificate: %w", err)
 	}
-	return &types.InstanceCreateOpts{
-		CACert:     ca.Cert,
-		CAKey:      ca.Key,
+	opts := &types.InstanceCreateOpts{
+		CACert:     caCert.Cert,
 		TLSCert:    tlsCert.Cert,
 		TLSKey:     tlsCert.Key,
 		RunnerName: runnerName,
-	}, nil
+	}
+	// the key of a CA shared by the instances is not stored with every instance.
+	if ca == nil {
+		opts.CAKey = caCert.Key
+	}
+	return opts, nil
 }
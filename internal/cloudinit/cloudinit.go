@@ -10,10 +10,13 @@ package cloudinit
 import (
 	"encoding/base64"
 	"fmt"
+	"net"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
 
+	"github.com/drone-runners/drone-runner-aws/internal/leasetoken"
 	"github.com/drone-runners/drone-runner-aws/internal/oshelp"
 	"github.com/drone-runners/drone-runner-aws/types"
 )
@@ -28,6 +31,172 @@ type Params struct {
 	HarnessTestBinaryURI string
 	PluginBinaryURI      string
 	Tmate                types.Tmate
+	// Persistent starts the lite-engine on every boot, required by the instances which are stopped
+	// and started again, the processes started by the startup script don't survive a reboot.
+	Persistent bool
+	// LiteEnginePort is the port the lite-engine listens on, DefaultLiteEnginePort if zero.
+	LiteEnginePort int
+	// Tunnel is the server the instance forwards the lite-engine port to, nil if the lite-engine
+	// is reached directly.
+	Tunnel *types.Tunnel
+	// Slim only installs the lite-engine and its certificates, the image provides docker, git and
+	// the plugin binary.
+	Slim bool
+	// Tuning holds the kernel settings of the linux instances.
+	Tuning types.Tuning
+	// Checksums are the SHA256 digests the downloaded binaries are verified against.
+	Checksums types.Checksums
+	// LiteEngineEnv and LiteEngineFeatures are added to the environment file of the lite-engine.
+	LiteEngineEnv      map[string]string
+	LiteEngineFeatures []string
+	// LeaseKey is the key of the lease tokens of the lite-engine, added to its environment file with the
+	// path of the file of the rotated key. Empty if the pool doesn't use the lease tokens.
+	LeaseKey string
+	// KeyFetch is the shell command printing the TLS key of the lite-engine, run by the startup script
+	// instead of writing the key from the user data. TLSKey is empty if set.
+	KeyFetch string
+	// WindowsContainers sets up the windows instances for the Windows containers.
+	WindowsContainers types.WindowsContainers
+}
+
+// DefaultLiteEnginePort is the port the lite-engine listens on by default.
+const DefaultLiteEnginePort = 9079
+
+// Port returns the port the lite-engine listens on.
+func (p Params) Port() int {
+	if p.LiteEnginePort == 0 {
+		return DefaultLiteEnginePort
+	}
+	return p.LiteEnginePort
+}
+
+// tunnelDir holds the key and the known_hosts file of the tunnel.
+const tunnelDir = "/etc/lite-engine/tunnel"
+
+// TunnelArgs returns the arguments of the ssh command which forwards the lite-engine port to the
+// tunnel server. The instance authenticates with the key of its lite-engine certificate.
+func (p Params) TunnelArgs() string {
+	if p.Tunnel == nil {
+		return ""
+	}
+	host, port, err := net.SplitHostPort(p.Tunnel.Address)
+	if err != nil {
+		host, port = p.Tunnel.Address, "22"
+	}
+	return fmt.Sprintf("-N -T -i %[1]s/key -p %[2]s -o UserKnownHostsFile=%[1]s/known_hosts -o StrictHostKeyChecking=yes"+
+		" -o ServerAliveInterval=15 -o ServerAliveCountMax=3 -o ExitOnForwardFailure=yes -R 127.0.0.1:%[3]d:127.0.0.1:%[3]d tunnel@%[4]s",
+		tunnelDir, port, p.Port(), host)
+}
+
+// the files keeping the kernel settings across the reboots of the instances.
+const (
+	tuningSysctlFile = "/etc/sysctl.d/99-lite-engine.conf"
+	tuningLimitsFile = "/etc/security/limits.d/99-lite-engine.conf"
+	tuningSwapFile   = "/swapfile"
+)
+
+// TuningScript returns the shell commands applying the kernel settings, the file descriptor limit
+// of the lite-engine itself is set by the command starting it.
+func (p Params) TuningScript() string {
+	t := p.Tuning
+	var lines []string
+	if t.SwapSizeMB > 0 {
+		lines = append(lines,
+			fmt.Sprintf("fallocate -l %[1]dM %[2]s || dd if=/dev/zero of=%[2]s bs=1M count=%[1]d", t.SwapSizeMB, tuningSwapFile),
+			fmt.Sprintf("chmod 0600 %[1]s && mkswap %[1]s && swapon %[1]s", tuningSwapFile),
+			fmt.Sprintf("echo '%s none swap sw 0 0' >> /etc/fstab", tuningSwapFile))
+	}
+	if t.MaxMapCount > 0 {
+		lines = append(lines,
+			fmt.Sprintf("echo 'vm.max_map_count=%d' >> %s", t.MaxMapCount, tuningSysctlFile),
+			fmt.Sprintf("sysctl -w vm.max_map_count=%d", t.MaxMapCount))
+	}
+	if t.NoFile > 0 {
+		lines = append(lines,
+			fmt.Sprintf(`printf '* soft nofile %[1]d\n* hard nofile %[1]d\nroot soft nofile %[1]d\nroot hard nofile %[1]d\n' > %[2]s`, t.NoFile, tuningLimitsFile))
+	}
+	if t.TransparentHugepages != "" {
+		lines = append(lines,
+			fmt.Sprintf("echo %s > /sys/kernel/mm/transparent_hugepage/enabled", t.TransparentHugepages))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Verify returns the shell command checking the SHA256 digest of the file, empty if the digest is
+// not set. On a mismatch the file is removed and the script exits, so the lite-engine never starts
+// and the instance fails its health check instead of running an unverified binary.
+func (p Params) Verify(sum, file string) string {
+	if sum == "" {
+		return ""
+	}
+	tool := "sha256sum"
+	if p.Platform.OS == oshelp.OSMac {
+		tool = "shasum -a 256"
+	}
+	return fmt.Sprintf(`echo "%[1]s  %[2]s" | %[3]s -c - || { echo "checksum mismatch of %[2]s, expected sha256 %[1]s" >&2; rm -f %[2]s; exit 1; }`,
+		strings.ToLower(sum), file, tool)
+}
+
+// FeatureFlagsEnv is the variable of the environment of the lite-engine listing its feature flags.
+const FeatureFlagsEnv = "FEATURE_FLAGS"
+
+// EnvNames returns the names of the variables of the environment, sorted.
+func EnvNames(env map[string]string) []string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LiteEngineEnvFile returns the lines the startup script adds to the environment file of the
+// lite-engine, empty if the pool sets neither variables, feature flags nor lease tokens. The scripts
+// decode the lines from base64, so the values are never interpreted by the shells.
+func (p Params) LiteEngineEnvFile() string {
+	var sb strings.Builder
+	for _, name := range EnvNames(p.LiteEngineEnv) {
+		sb.WriteString(name + "=" + p.LiteEngineEnv[name] + "\n")
+	}
+	if len(p.LiteEngineFeatures) > 0 {
+		sb.WriteString(FeatureFlagsEnv + "=" + strings.Join(p.LiteEngineFeatures, ",") + "\n")
+	}
+	if p.LeaseKey != "" {
+		sb.WriteString(leasetoken.KeyEnv + "=" + p.LeaseKey + "\n")
+		sb.WriteString(leasetoken.KeyFileEnv + "=" + leasetoken.KeyFile(p.Platform.OS) + "\n")
+	}
+	return sb.String()
+}
+
+// Defaults of the Windows containers.
+const (
+	DefaultDockerVersion      = "27.3.1"
+	DefaultContainerIsolation = "process"
+)
+
+// DockerVersion returns the version of docker installed for the Windows containers.
+func (p Params) DockerVersion() string {
+	if p.WindowsContainers.DockerVersion == "" {
+		return DefaultDockerVersion
+	}
+	return p.WindowsContainers.DockerVersion
+}
+
+// ContainerIsolation returns the default isolation of the Windows containers.
+func (p Params) ContainerIsolation() string {
+	if p.WindowsContainers.Isolation == "" {
+		return DefaultContainerIsolation
+	}
+	return p.WindowsContainers.Isolation
+}
+
+// BaseImages returns the base images of the Windows containers as the items of a powershell array.
+func (p Params) BaseImages() string {
+	quoted := make([]string, 0, len(p.WindowsContainers.BaseImages))
+	for _, image := range p.WindowsContainers.BaseImages {
+		quoted = append(quoted, "'"+strings.ReplaceAll(image, "'", "''")+"'")
+	}
+	return strings.Join(quoted, ", ")
 }
 
 var funcs = map[string]interface{}{
@@ -74,8 +243,57 @@ func Custom(templateText string, params *Params) (payload string, err error) {
 	return payload, nil
 }
 
-const linuxScript = `
-#!/usr/bin/bash
+// linuxScript detects the distro of the image, so the packages, the docker engine and the
+// cgroups are set up on the Debian, the RHEL, the Amazon Linux and the SUSE families alike.
+const linuxScript = `#!/usr/bin/bash
+. /etc/os-release
+DISTRO=" $ID $ID_LIKE "
+{{ .Marker "boot" "ok" }}
+
+install_packages() {
+	if command -v apt-get > /dev/null; then
+		DEBIAN_FRONTEND=noninteractive apt-get update -qq && DEBIAN_FRONTEND=noninteractive apt-get install -y -qq "$@"
+	elif command -v dnf > /dev/null; then
+		dnf install -y --allowerasing "$@"
+	elif command -v yum > /dev/null; then
+		yum install -y "$@"
+	elif command -v zypper > /dev/null; then
+		zypper --non-interactive install "$@"
+	fi
+}
+
+{{ if not .Slim }}
+install_docker() {
+	case "$DISTRO" in
+	*" amzn "*)
+		install_packages docker ;;
+	*" sles "*|*" suse "*)
+		install_packages docker ;;
+	*" debian "*|*" ubuntu "*)
+		install_packages docker.io ;;
+	*" rhel "*|*" centos "*)
+		if command -v dnf > /dev/null; then
+			install_packages dnf-plugins-core
+			dnf config-manager --add-repo https://download.docker.com/linux/centos/docker-ce.repo
+		else
+			install_packages yum-utils
+			yum-config-manager --add-repo https://download.docker.com/linux/centos/docker-ce.repo
+		fi
+		install_packages docker-ce docker-ce-cli containerd.io ;;
+	*" fedora "*)
+		install_packages dnf-plugins-core
+		dnf config-manager --add-repo https://download.docker.com/linux/fedora/docker-ce.repo
+		install_packages docker-ce docker-ce-cli containerd.io ;;
+	esac
+}
+{{ end }}
+
+command -v wget > /dev/null || install_packages wget
+{{- if not .Slim }}
+command -v git > /dev/null || install_packages git
+command -v docker > /dev/null || install_docker
+{{ .Check "packages" "command -v docker > /dev/null" }}
+{{ end }}
 mkdir {{ .CertDir }}
 
 echo {{ .CACert | base64 }} | base64 -d >> {{ .CaCertPath }}
@@ -84,24 +302,73 @@ chmod 0600 {{ .CaCertPath }}
 echo {{ .TLSCert | base64 }} | base64 -d  >> {{ .CertPath }}
 chmod 0600 {{ .CertPath }}
 
+{{ if .KeyFetch -}}
+(umask 077 && {{ .KeyFetch }} > {{ .KeyPath }})
+{{- else -}}
 echo {{ .TLSKey | base64 }} | base64 -d >> {{ .KeyPath }}
 chmod 0600 {{ .KeyPath }}
+{{- end }}
+{{ .Check "certs" (printf "[ -s %s ] && [ -s %s ]" .CertPath .KeyPath) }}
 
 /usr/bin/wget "{{ .LiteEnginePath }}/lite-engine-{{ .Platform.OS }}-{{ .Platform.Arch }}" -O /usr/bin/lite-engine
+{{- with .Verify .Checksums.LiteEngine "/usr/bin/lite-engine" }}
+{{ . }}
+{{- end }}
 chmod 777 /usr/bin/lite-engine
+{{ .Check "download" "[ -s /usr/bin/lite-engine ]" }}
 touch $HOME/.env
 echo "SKIP_PREPARE_SERVER=true" >> $HOME/.env;
+echo "HTTPS_BIND=:{{ .Port }}" >> $HOME/.env;
+{{- with .LiteEngineEnvFile }}
+echo {{ . | base64 }} | base64 -d >> $HOME/.env
+{{- end }}
 
-{{ if .PluginBinaryURI }}
+{{ if and .PluginBinaryURI (not .Slim) }}
 wget {{ .PluginBinaryURI }}/plugin-{{ .Platform.OS }}-{{ .Platform.Arch }}  -O /usr/bin/plugin
+{{- with .Verify .Checksums.Plugin "/usr/bin/plugin" }}
+{{ . }}
+{{- end }}
 chmod 777 /usr/bin/plugin
 {{ end }}
 
-systemctl disable docker.service
-update-alternatives --set iptables /usr/sbin/iptables-legacy
-service docker start
+# the binaries downloaded on an SELinux enforcing image get the label of the downloads
+command -v restorecon > /dev/null && restorecon /usr/bin/lite-engine /usr/bin/plugin 2> /dev/null
+
+if command -v firewall-cmd > /dev/null && firewall-cmd --state > /dev/null 2>&1; then
+	firewall-cmd --add-port={{ .Port }}/tcp
+elif command -v ufw > /dev/null; then
+	ufw allow {{ .Port }}
+fi
+
+{{ if not .Slim }}
+# docker drives the cgroups with systemd on the cgroup v2 hosts, e.g. RHEL 9
+if [ -f /sys/fs/cgroup/cgroup.controllers ] && [ ! -f /etc/docker/daemon.json ]; then
+	mkdir -p /etc/docker
+	echo '{"exec-opts": ["native.cgroupdriver=systemd"]}' > /etc/docker/daemon.json
+fi
 
+systemctl disable docker.service
+case "$DISTRO" in
+*" debian "*|*" ubuntu "*)
+	update-alternatives --set iptables /usr/sbin/iptables-legacy ;;
+esac
+systemctl start docker.service || service docker start
+{{ end }}{{ with .TuningScript }}
+{{ . }}
+{{ end }}
+{{- if .Tuning.NoFile }}
+ulimit -n {{ .Tuning.NoFile }}
+{{ end }}
 /usr/bin/lite-engine server --env-file $HOME/.env > $HOME/lite-engine.log 2>&1 &
+{{ .ExitWatch }}
+{{ .Marker "start" "ok" }}
+{{ if .Tunnel }}
+mkdir -p ` + tunnelDir + `
+echo {{ .Tunnel.KnownHosts | base64 }} | base64 -d > ` + tunnelDir + `/known_hosts
+echo {{ .TLSKey | base64 }} | base64 -d > ` + tunnelDir + `/key
+chmod 0600 ` + tunnelDir + `/key
+nohup sh -c 'while true; do /usr/bin/ssh {{ .TunnelArgs }}; sleep 5; done' > /var/log/lite-engine-tunnel.log 2>&1 &
+{{ end }}
 `
 
 const macScript = `
@@ -118,12 +385,22 @@ echo {{ .TLSKey | base64 }} | base64 -d >> {{ .KeyPath }}
 chmod 0600 {{ .KeyPath }}
 
 /usr/local/bin/wget "{{ .LiteEnginePath }}/lite-engine-{{ .Platform.OS }}-{{ .Platform.Arch }}" -O /usr/local/bin/lite-engine
+{{- with .Verify .Checksums.LiteEngine "/usr/local/bin/lite-engine" }}
+{{ . }}
+{{- end }}
 chmod 777 /usr/local/bin/lite-engine
 touch $HOME/.env
 echo "SKIP_PREPARE_SERVER=true" >> .env;
+echo "HTTPS_BIND=:{{ .Port }}" >> $HOME/.env;
+{{- with .LiteEngineEnvFile }}
+echo {{ . | base64 }} | base64 -d >> $HOME/.env
+{{- end }}
 
-{{ if .PluginBinaryURI }}
+{{ if and .PluginBinaryURI (not .Slim) }}
 wget {{ .PluginBinaryURI }}/plugin-{{ .Platform.OS }}-{{ .Platform.Arch }}  -O /usr/bin/plugin
+{{- with .Verify .Checksums.Plugin "/usr/bin/plugin" }}
+{{ . }}
+{{- end }}
 chmod 777 /usr/bin/plugin
 {{ end }}
 
@@ -144,12 +421,22 @@ echo {{ .TLSKey | base64 }} | base64 -d >> {{ .KeyPath }}
 chmod 0600 {{ .KeyPath }}
 
 wget "{{ .LiteEnginePath }}/lite-engine-{{ .Platform.OS }}-{{ .Platform.Arch }}" -O /opt/homebrew/bin/lite-engine
+{{- with .Verify .Checksums.LiteEngine "/opt/homebrew/bin/lite-engine" }}
+{{ . }}
+{{- end }}
 chmod 777 /opt/homebrew/bin/lite-engine
 touch $HOME/.env
 echo "SKIP_PREPARE_SERVER=true" >> .env;
+echo "HTTPS_BIND=:{{ .Port }}" >> $HOME/.env;
+{{- with .LiteEngineEnvFile }}
+echo {{ . | base64 }} | base64 -d >> $HOME/.env
+{{- end }}
 
-{{ if .PluginBinaryURI }}
+{{ if and .PluginBinaryURI (not .Slim) }}
 wget {{ .PluginBinaryURI }}/plugin-{{ .Platform.OS }}-{{ .Platform.Arch }}  -O /usr/local/bin/plugin
+{{- with .Verify .Checksums.Plugin "/usr/local/bin/plugin" }}
+{{ . }}
+{{- end }}
 chmod 777 /usr/local/bin/plugin
 {{ end }}
 
@@ -225,8 +512,103 @@ func LinuxBash(params *Params) (payload string) {
 	return sb.String()
 }
 
+// persistentConfigDir keeps the certificates of a persistent lite-engine, /tmp doesn't survive a reboot.
+const persistentConfigDir = "/etc/lite-engine"
+
+// tuningScriptFile applies the kernel settings of the pool on the instances bootstrapped by cloud-init.
+const tuningScriptFile = persistentConfigDir + "/tuning.sh"
+
+// liteEngineUnitFile and liteEngineStartCmd run the lite-engine as a systemd service when the
+// instance is persistent, and as a background process of the cloud-init run otherwise. The tunnel
+// to the runner always runs as a service so it is re-established when the connection drops.
+const liteEngineUnitFile = `
+{{ with .TuningScript }}
+- path: ` + tuningScriptFile + `
+  permissions: '0700'
+  encoding: b64
+  content: {{ . | base64 }}
+{{ end }}
+{{ if .Persistent }}
+- path: /etc/systemd/system/lite-engine.service
+  permissions: '0644'
+  content: |
+    [Unit]
+    Description=Harness lite-engine
+    Wants=network-online.target docker.service
+    After=network-online.target docker.service
+
+    [Service]
+    ExecStart=/usr/bin/lite-engine server --env-file /root/.env
+{{- if .Tuning.NoFile }}
+    LimitNOFILE={{ .Tuning.NoFile }}
+{{- end }}
+    ExecStartPost={{ .StartMarker }}
+    ExecStopPost={{ .ExitMarker }}
+    Restart=always
+    RestartSec=5
+    StandardOutput=append:/var/log/lite-engine.log
+    StandardError=append:/var/log/lite-engine.log
+
+    [Install]
+    WantedBy=multi-user.target
+{{ end }}
+{{ if .Tunnel }}
+- path: ` + tunnelDir + `/known_hosts
+  permissions: '0644'
+  encoding: b64
+  content: {{ .Tunnel.KnownHosts | base64 }}
+- path: ` + tunnelDir + `/key
+  permissions: '0600'
+  encoding: b64
+  content: {{ .TLSKey | base64 }}
+- path: /etc/systemd/system/lite-engine-tunnel.service
+  permissions: '0644'
+  content: |
+    [Unit]
+    Description=Harness lite-engine tunnel
+    Wants=network-online.target
+    After=network-online.target
+
+    [Service]
+    ExecStart=/usr/bin/ssh {{ .TunnelArgs }}
+    Restart=always
+    RestartSec=5
+
+    [Install]
+    WantedBy=multi-user.target
+{{ end }}`
+
+const liteEngineStartCmd = `
+- 'echo "HTTPS_BIND=:{{ .Port }}" >> /root/.env'
+{{- with .LiteEngineEnvFile }}
+- 'echo {{ . | base64 }} | base64 -d >> /root/.env'
+{{- end }}
+{{ if .TuningScript }}
+- 'sh ` + tuningScriptFile + `'
+{{ end }}
+{{ if .Persistent }}
+- 'mkdir -p ` + persistentConfigDir + `/certs && cp {{ .CaCertPath }} {{ .CertPath }} {{ .KeyPath }} ` + persistentConfigDir + `/certs/ && chmod 0600 ` + persistentConfigDir + `/certs/*'
+- 'printf "SERVER_CERT_FILE=` + persistentConfigDir + `/certs/server-cert.pem\nSERVER_KEY_FILE=` + persistentConfigDir + `/certs/server-key.pem\nCLIENT_CERT_FILE=` + persistentConfigDir + `/certs/ca-cert.pem\n" >> /root/.env'
+{{ if not .Slim }}
+- 'systemctl enable docker.service'
+{{ end }}- 'systemctl daemon-reload'
+- 'systemctl enable --now lite-engine.service'
+{{ else }}
+{{ if .Tuning.NoFile }}
+- 'ulimit -n {{ .Tuning.NoFile }}'
+{{ end }}
+- '/usr/bin/lite-engine server --env-file /root/.env > /var/log/lite-engine.log 2>&1 &'
+- '{{ .ExitWatch }}'
+{{ end }}
+- '{{ .Marker "start" "ok" }}'
+{{ if .Tunnel }}
+- 'systemctl daemon-reload'
+- 'systemctl enable --now lite-engine-tunnel.service'
+{{ end }}`
+
 const ubuntuScript = `
 #cloud-config
+{{ if not .Slim }}
 apt:
   sources:
     docker.list:
@@ -235,6 +617,9 @@ apt:
 packages:
 - wget
 - docker-ce
+{{ end }}
+bootcmd:
+- '{{ .Marker "boot" "ok" }}'
 write_files:
 - path: {{ .CaCertPath }}
   permissions: '0600'
@@ -244,27 +629,45 @@ write_files:
   permissions: '0600'
   encoding: b64
   content: {{ .TLSCert | base64 }}
+{{- if not .KeyFetch }}
 - path: {{ .KeyPath }}
   permissions: '0600'
   encoding: b64
   content: {{ .TLSKey | base64 }}
+{{- end }}` + liteEngineUnitFile + `
 runcmd:
 - 'set -x'
-- 'ufw allow 9079'
+{{- if not .Slim }}
+- '{{ .Check "packages" "command -v docker > /dev/null" }}'
+{{- end }}
+{{- if .KeyFetch }}
+- '(umask 077 && {{ .KeyFetch }} > {{ .KeyPath }})'
+{{- end }}
+- '{{ .Check "certs" (printf "[ -s %s ] && [ -s %s ]" .CertPath .KeyPath) }}'
+- 'ufw allow {{ .Port }}'
 - 'wget --debug "{{ .LiteEnginePath }}/lite-engine-{{ .Platform.OS }}-{{ .Platform.Arch }}" -O /usr/bin/lite-engine'
+{{- with .Verify .Checksums.LiteEngine "/usr/bin/lite-engine" }}
+- '{{ . }}'
+{{- end }}
 - 'chmod 777 /usr/bin/lite-engine'
-{{ if .HarnessTestBinaryURI }}
+- '{{ .Check "download" "[ -s /usr/bin/lite-engine ]" }}'
+{{ if and .HarnessTestBinaryURI (not .Slim) }}
 - 'wget "{{ .HarnessTestBinaryURI }}/{{ .Platform.Arch }}/{{ .Platform.OS }}/bin/split_tests-{{ .Platform.OS }}_{{ .Platform.Arch }}" -O /usr/bin/split_tests'
+{{- with .Verify .Checksums.SplitTests "/usr/bin/split_tests" }}
+- '{{ . }}'
+{{- end }}
 - 'chmod 777 /usr/bin/split_tests'
 {{ end }}
-{{ if .PluginBinaryURI }}
+{{ if and .PluginBinaryURI (not .Slim) }}
 - 'wget {{ .PluginBinaryURI }}/plugin-{{ .Platform.OS }}-{{ .Platform.Arch }}  -O /usr/bin/plugin'
+{{- with .Verify .Checksums.Plugin "/usr/bin/plugin" }}
+- '{{ . }}'
+{{- end }}
 - 'chmod 777 /usr/bin/plugin'
 {{ end }}
 - 'touch /root/.env'
-- '[ -f "/etc/environment" ] && cp "/etc/environment" /root/.env'
-- '/usr/bin/lite-engine server --env-file /root/.env > /var/log/lite-engine.log 2>&1 &'
-{{ if .Tmate.Enabled }}
+- '[ -f "/etc/environment" ] && cp "/etc/environment" /root/.env'` + liteEngineStartCmd + `
+{{ if and .Tmate.Enabled (not .Slim) }}
 - 'mkdir /addon'
 {{ if eq .Platform.Arch "amd64" }}
 - 'wget https://github.com/tmate-io/tmate/releases/download/2.4.0/tmate-2.4.0-static-linux-amd64.tar.xz -O /addon/tmate.xz' 
@@ -286,10 +689,14 @@ var ubuntuTemplate = template.Must(template.New(oshelp.OSLinux).Funcs(funcs).Par
 
 const amazonLinuxScript = `
 #cloud-config
+{{ if not .Slim }}
 packages:
 - wget
 - docker
 - git
+{{ end }}
+bootcmd:
+- '{{ .Marker "boot" "ok" }}'
 write_files:
 - path: {{ .CaCertPath }}
   permissions: '0600'
@@ -299,22 +706,36 @@ write_files:
   permissions: '0600'
   encoding: b64
   content: {{ .TLSCert | base64 }}
+{{- if not .KeyFetch }}
 - path: {{ .KeyPath }}
   permissions: '0600'
   encoding: b64
   content: {{ .TLSKey | base64 }}
+{{- end }}` + liteEngineUnitFile + `
 runcmd:
+{{ if not .Slim }}
+- '{{ .Check "packages" "command -v docker > /dev/null" }}'
 - 'sudo service docker start'
 - 'sudo usermod -a -G docker ec2-user'
+{{ end }}{{- if .KeyFetch }}
+- '(umask 077 && {{ .KeyFetch }} > {{ .KeyPath }})'
+{{- end }}
+- '{{ .Check "certs" (printf "[ -s %s ] && [ -s %s ]" .CertPath .KeyPath) }}'
 - 'wget "{{ .LiteEnginePath }}/lite-engine-{{ .Platform.OS }}-{{ .Platform.Arch }}" -O /usr/bin/lite-engine'
+{{- with .Verify .Checksums.LiteEngine "/usr/bin/lite-engine" }}
+- '{{ . }}'
+{{- end }}
 - 'chmod 777 /usr/bin/lite-engine'
-{{ if .PluginBinaryURI }}
+- '{{ .Check "download" "[ -s /usr/bin/lite-engine ]" }}'
+{{ if and .PluginBinaryURI (not .Slim) }}
 - 'wget {{ .PluginBinaryURI }}/plugin-{{ .Platform.OS }}-{{ .Platform.Arch }}  -O /usr/bin/plugin'
+{{- with .Verify .Checksums.Plugin "/usr/bin/plugin" }}
+- '{{ . }}'
+{{- end }}
 - 'chmod 777 /usr/bin/plugin'
 {{ end }}
-- 'touch /root/.env'
-- '/usr/bin/lite-engine server --env-file /root/.env > /var/log/lite-engine.log 2>&1 &'
-{{ if .Tmate.Enabled }}
+- 'touch /root/.env'` + liteEngineStartCmd + `
+{{ if and .Tmate.Enabled (not .Slim) }}
 - 'mkdir /addon'
 {{ if eq .Platform.Arch "amd64" }}
 - 'wget https://github.com/tmate-io/tmate/releases/download/2.4.0/tmate-2.4.0-static-linux-amd64.tar.xz -O /addon/tmate.xz' 
@@ -342,6 +763,9 @@ func Linux(params *Params) (payload string) {
 	certPath := filepath.Join(certsDir, "server-cert.pem")
 	keyPath := filepath.Join(certsDir, "server-key.pem")
 	switch params.Platform.OSName {
+	case oshelp.RHEL:
+		// cloud-init runs the user data starting with #! as a script, which detects the distro
+		return LinuxBash(params)
 	case oshelp.AmazonLinux:
 		err := amazonLinuxTemplate.Execute(sb, struct {
 			Params
@@ -378,24 +802,86 @@ func Linux(params *Params) (payload string) {
 	return sb.String()
 }
 
+// windowsScript installs and starts the lite-engine. The steps are skipped for the images with git,
+// the lite-engine and the plugin pre-installed, and the independent downloads run in parallel.
+// The lite-engine of an image is used unless it was downloaded from another path, it is restarted
+// if the image installed it as the lite-engine service. The ready file is written once the
+// lite-engine is started. The slim script doesn't install git and the plugin. With the Windows
+// containers the instance is restarted if the Containers feature needs it, the lite-engine then
+// starts on boot.
 const windowsScript = `
 <powershell>
 
+# the progress bar slows the downloads down by minutes
+$ProgressPreference = 'SilentlyContinue'
+[Net.ServicePointManager]::SecurityProtocol = [Net.SecurityProtocolType]::Tls12 -bor [Net.SecurityProtocolType]::Tls11 -bor [Net.SecurityProtocolType]::Tls
+
 echo "[DRONE] Initialization Starting"
 
-echo "[DRONE] Installing Scoop Package Manager"
-iex "& {$(irm get.scoop.sh)} -RunAsAdmin"
+$dir = "C:\Program Files\lite-engine"
+New-Item -ItemType Directory -Force -Path $dir | Out-Null
+New-Item -ItemType Directory -Force -Path "{{ .CertDir }}" | Out-Null
 
-echo "[DRONE] Installing Git"
-scoop install git --global
+# the port is opened first so the probes of the runner are refused, not dropped, until the lite-engine listens
+if (-not (Get-NetFirewallRule -DisplayName "ALLOW TCP PORT {{ .Port }}" -ErrorAction SilentlyContinue)) {
+	New-NetFirewallRule -DisplayName "ALLOW TCP PORT {{ .Port }}" -Direction inbound -Profile Any -Action Allow -LocalPort {{ .Port }} -Protocol TCP | Out-Null
+}
 
-echo "[DRONE] Updating PATH so we have access to git commands (otherwise Scoop.sh shim files cannot be found)"
-$env:Path = [System.Environment]::GetEnvironmentVariable("Path","Machine") + ";" + [System.Environment]::GetEnvironmentVariable("Path","User")
+$download = {
+	param($uri, $file)
+	$ProgressPreference = 'SilentlyContinue'
+	[Net.ServicePointManager]::SecurityProtocol = [Net.SecurityProtocolType]::Tls12 -bor [Net.SecurityProtocolType]::Tls11 -bor [Net.SecurityProtocolType]::Tls
+	Invoke-WebRequest -Uri $uri -OutFile $file
+	Set-Content -Path "$file.source" -Value $uri
+}
 
-echo "[DRONE] Setup LiteEngine Certificates"
+# installed returns whether the file is pre-installed by the image or was downloaded from the uri
+function installed($uri, $file) {
+	if (-not (Test-Path $file)) {
+		return $false
+	}
+	if (-not (Test-Path "$file.source")) {
+		return $true
+	}
+	return (Get-Content "$file.source") -eq $uri
+}
 
-mkdir "C:\Program Files\lite-engine"
-mkdir "{{ .CertDir }}"
+$jobs = @()
+$checksums = @{}
+{{ if not .Slim }}
+if (Get-Command git -ErrorAction SilentlyContinue) {
+	echo "[DRONE] Git is pre-installed"
+} else {
+	echo "[DRONE] Installing Git"
+	$jobs += Start-Job -ScriptBlock {
+		iex "& {$(irm get.scoop.sh)} -RunAsAdmin"
+		scoop install git --global
+	}
+}
+
+$plugin = "{{ .PluginBinaryURI }}/plugin-{{ .Platform.OS }}-{{ .Platform.Arch }}.exe"
+if (installed $plugin "$dir\plugin.exe") {
+	echo "[DRONE] Plugin is pre-installed"
+} else {
+	echo "[DRONE] Downloading Plugin"
+	$jobs += Start-Job -ScriptBlock $download -ArgumentList $plugin, "$dir\plugin.exe"
+{{- with .Checksums.Plugin }}
+	$checksums["$dir\plugin.exe"] = "{{ . }}"
+{{- end }}
+}
+{{ end }}
+$liteEngine = "{{ .LiteEnginePath }}/lite-engine-{{ .Platform.OS }}-{{ .Platform.Arch }}.exe"
+if (installed $liteEngine "$dir\lite-engine.exe") {
+	echo "[DRONE] LiteEngine is pre-installed"
+} else {
+	echo "[DRONE] Downloading LiteEngine"
+	$jobs += Start-Job -ScriptBlock $download -ArgumentList $liteEngine, "$dir\lite-engine.exe"
+{{- with .Checksums.LiteEngine }}
+	$checksums["$dir\lite-engine.exe"] = "{{ . }}"
+{{- end }}
+}
+
+echo "[DRONE] Setup LiteEngine Certificates"
 
 $object0 = "{{ .CACert | base64 }}"
 $Object = [System.Convert]::FromBase64String($object0)
@@ -416,20 +902,121 @@ if (test-path($profile) -eq "false")
 	new-item -path $env:windir\System32\WindowsPowerShell\v1.0\profile.ps1 -itemtype file -force
 }
 
-[Net.ServicePointManager]::SecurityProtocol = [Net.SecurityProtocolType]::Tls12 -bor [Net.SecurityProtocolType]::Tls11 -bor [Net.SecurityProtocolType]::Tls
+Set-Content -Path "$dir\.env" -Value "HTTPS_BIND=:{{ .Port }}"
+{{- with .LiteEngineEnvFile }}
+Add-Content -Path "$dir\.env" -NoNewline -Value ([System.Text.Encoding]::UTF8.GetString([System.Convert]::FromBase64String("{{ . | base64 }}")))
+{{- end }}
 
-Invoke-WebRequest -Uri "{{ .PluginBinaryURI }}/plugin-{{ .Platform.OS }}-{{ .Platform.Arch }}.exe" -OutFile "C:\Program Files\lite-engine\plugin.exe"
-$env:Path = 'C:\Program Files\lite-engine;' + $env:Path
+if ($jobs) {
+	echo "[DRONE] Waiting for the installation"
+	$jobs | Wait-Job | Receive-Job
+}
 
-# Refresh the PSEnviroment
-refreshenv
+# the downloads are verified against their checksums, the initialization fails on a mismatch
+foreach ($file in $checksums.Keys) {
+	$hash = (Get-FileHash -Algorithm SHA256 -Path $file -ErrorAction SilentlyContinue).Hash
+	if ($hash -ne $checksums[$file]) {
+		echo "[DRONE] Checksum mismatch of $file, expected sha256 $($checksums[$file]), got $hash"
+		Remove-Item -Force -Path $file, "$file.source" -ErrorAction SilentlyContinue
+		exit 1
+	}
+}
 
-fsutil file createnew "C:\Program Files\lite-engine\.env" 0
-Invoke-WebRequest -Uri "{{ .LiteEnginePath }}/lite-engine-{{ .Platform.OS }}-{{ .Platform.Arch }}.exe" -OutFile "C:\Program Files\lite-engine\lite-engine.exe"
-New-NetFirewallRule -DisplayName "ALLOW TCP PORT 9079" -Direction inbound -Profile Any -Action Allow -LocalPort 9079 -Protocol TCP
-Start-Process -FilePath "C:\Program Files\lite-engine\lite-engine.exe" -ArgumentList "server --env-file=` + "`" + `"C:\Program Files\lite-engine\.env` + "`" + `"" -RedirectStandardOutput "C:\Program Files\lite-engine\log.out" -RedirectStandardError "C:\Program Files\lite-engine\log.err"
+{{- if .WindowsContainers.Enabled }}
+
+echo "[DRONE] Setting up the Windows containers"
+$reboot = $false
+if ((Get-WindowsFeature -Name Containers).InstallState -ne "Installed") {
+	echo "[DRONE] Installing the Containers feature"
+	$reboot = (Install-WindowsFeature -Name Containers).RestartNeeded -eq "Yes"
+}
+if (-not (Get-Service -Name docker -ErrorAction SilentlyContinue)) {
+	echo "[DRONE] Installing Docker {{ .DockerVersion }}"
+	Invoke-WebRequest -Uri "https://download.docker.com/win/static/stable/x86_64/docker-{{ .DockerVersion }}.zip" -OutFile "$env:TEMP\docker.zip"
+	Expand-Archive -Path "$env:TEMP\docker.zip" -DestinationPath $env:ProgramFiles -Force
+	Remove-Item -Force -Path "$env:TEMP\docker.zip"
+	[Environment]::SetEnvironmentVariable("Path", [Environment]::GetEnvironmentVariable("Path", "Machine") + ";$env:ProgramFiles\docker", "Machine")
+	& "$env:ProgramFiles\docker\dockerd.exe" --register-service
+}
 
+# with the process isolation the containers share the kernel of the host, their base images must be built for its version
+$version = Get-ItemProperty -Path "HKLM:\SOFTWARE\Microsoft\Windows NT\CurrentVersion"
+$tag = @{ "17763" = "ltsc2019"; "20348" = "ltsc2022"; "26100" = "ltsc2025" }[$version.CurrentBuild]
+if (-not $tag) {
+	$tag = "10.0.$($version.CurrentBuild).$($version.UBR)"
+}
+echo "[DRONE] The base images of the host are tagged $tag"
+[Environment]::SetEnvironmentVariable("DRONE_WINDOWS_IMAGE_TAG", $tag, "Machine")
+
+# the settings of docker set by the image are kept
+$daemon = "$env:ProgramData\docker\config\daemon.json"
+New-Item -ItemType Directory -Force -Path (Split-Path $daemon) | Out-Null
+$config = if (Test-Path $daemon) { Get-Content -Raw -Path $daemon | ConvertFrom-Json } else { [pscustomobject]@{} }
+$config | Add-Member -Force -NotePropertyName "exec-opts" -NotePropertyValue @("isolation={{ .ContainerIsolation }}")
+$config | ConvertTo-Json -Depth 10 | Set-Content -Path $daemon
+if (-not $reboot) {
+	Restart-Service -Name docker
+}
+{{- with .BaseImages }}
+
+# the base images are pulled from the background once docker runs, on every boot so they follow the updates of the host
+Set-Content -Path "$dir\pull-base-images.ps1" -Value @'
+$tag = [Environment]::GetEnvironmentVariable("DRONE_WINDOWS_IMAGE_TAG", "Machine")
+$docker = (Get-Command docker -ErrorAction SilentlyContinue).Source
+if (-not $docker) {
+	$docker = "$env:ProgramFiles\docker\docker.exe"
+}
+(Get-Service -Name docker).WaitForStatus("Running", "00:10:00")
+foreach ($image in @({{ . }})) {
+	if ($image -notmatch ':[^/]+$') {
+		$image = "${image}:$tag"
+	}
+	& $docker pull $image
+}
+'@
+$action = New-ScheduledTaskAction -Execute "powershell.exe" -Argument ('-NoProfile -ExecutionPolicy Bypass -File "' + $dir + '\pull-base-images.ps1"')
+Register-ScheduledTask -TaskName "pull-base-images" -Action $action -Trigger (New-ScheduledTaskTrigger -AtStartup) -User "SYSTEM" -RunLevel Highest -Force | Out-Null
+if (-not $reboot) {
+	Start-ScheduledTask -TaskName "pull-base-images"
+}
+{{- end }}
+{{- end }}
+
+echo "[DRONE] Updating PATH so we have access to git commands (otherwise Scoop.sh shim files cannot be found)"
+$env:Path = [System.Environment]::GetEnvironmentVariable("Path","Machine") + ";" + [System.Environment]::GetEnvironmentVariable("Path","User")
+$env:Path = "$dir;" + $env:Path
+
+if (Get-Service -Name "lite-engine" -ErrorAction SilentlyContinue) {
+	echo "[DRONE] Restarting the LiteEngine service"
+	Restart-Service -Name "lite-engine"
+} else {
+{{- if or .Persistent .WindowsContainers.Enabled }}
+	$action = New-ScheduledTaskAction -Execute "$dir\lite-engine.exe" -Argument "server --env-file=` + "`" + `"$dir\.env` + "`" + `"" -WorkingDirectory $dir
+	$trigger = New-ScheduledTaskTrigger -AtStartup
+	$settings = New-ScheduledTaskSettingsSet -ExecutionTimeLimit 0 -RestartCount 3 -RestartInterval (New-TimeSpan -Minutes 1)
+	Register-ScheduledTask -TaskName "lite-engine" -Action $action -Trigger $trigger -Settings $settings -User "SYSTEM" -RunLevel Highest -Force
+{{- if .WindowsContainers.Enabled }}
+	# the lite-engine starts once the instance is restarted for the Containers feature
+	if (-not $reboot) {
+		Start-ScheduledTask -TaskName "lite-engine"
+	}
+{{- else }}
+	Start-ScheduledTask -TaskName "lite-engine"
+{{- end }}
+{{- else }}
+	Start-Process -FilePath "$dir\lite-engine.exe" -ArgumentList "server --env-file=` + "`" + `"$dir\.env` + "`" + `"" -RedirectStandardOutput "$dir\log.out" -RedirectStandardError "$dir\log.err"
+{{- end }}
+}
+
+Set-Content -Path "$dir\ready" -Value (Get-Date -Format o)
 echo "[DRONE] Initialization Complete"
+{{- if .WindowsContainers.Enabled }}
+
+if ($reboot) {
+	echo "[DRONE] Restarting to enable the Containers feature"
+	Restart-Computer -Force
+}
+{{- end }}
 
 </powershell>`
 
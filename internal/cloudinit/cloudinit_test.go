@@ -1,12 +1,17 @@
 package cloudinit_test
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"regexp"
 	"strings"
 	"testing"
 
 	"github.com/drone-runners/drone-runner-aws/internal/cloudinit"
+	"github.com/drone-runners/drone-runner-aws/internal/oshelp"
 	"github.com/drone-runners/drone-runner-aws/types"
+	"gopkg.in/yaml.v2"
 )
 
 const (
@@ -39,6 +44,158 @@ func TestLinux(t *testing.T) {
 	}
 }
 
+// TestLinuxBashDistros verifies that the bash script branches on the distro and that the RHEL
+// images run it instead of the Ubuntu cloud-config.
+func TestLinuxBashDistros(t *testing.T) {
+	params := &cloudinit.Params{
+		LiteEnginePath: liteEnginePath,
+		Platform:       types.Platform{OS: oshelp.OSLinux, OSName: oshelp.RHEL, Arch: oshelp.ArchAMD64},
+	}
+	s := cloudinit.Linux(params)
+	if !strings.HasPrefix(s, "#!/usr/bin/bash\n") {
+		t.Fatalf("rhel init script is not a shell script: %q", s[:20])
+	}
+	for _, want := range []string{"apt-get install", "dnf install", "yum install", "zypper --non-interactive install",
+		"linux/centos/docker-ce.repo", "native.cgroupdriver=systemd", "firewall-cmd --add-port=9079/tcp"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("bash init script does not contain %q", want)
+		}
+	}
+}
+
+// TestSlim verifies that the slim scripts only install the lite-engine and its certificates.
+func TestSlim(t *testing.T) {
+	params := &cloudinit.Params{
+		LiteEnginePath:  liteEnginePath,
+		PluginBinaryURI: "https://github.com/drone/plugin/releases/download/v0.1.0",
+		Platform:        types.Platform{OS: oshelp.OSLinux, Arch: oshelp.ArchAMD64},
+		Tmate:           types.Tmate{Enabled: true},
+		Slim:            true,
+	}
+	for name, s := range map[string]string{
+		"ubuntu":  cloudinit.Linux(params),
+		"bash":    cloudinit.LinuxBash(params),
+		"amazon":  cloudinit.Linux(&cloudinit.Params{LiteEnginePath: liteEnginePath, Platform: types.Platform{OSName: oshelp.AmazonLinux}, Slim: true}),
+		"windows": cloudinit.Windows(params),
+	} {
+		if !strings.Contains(s, liteEnginePath) {
+			t.Errorf("slim %s script does not install the lite-engine", name)
+		}
+		for _, unwanted := range []string{"docker-ce", "service docker start", "plugin-linux", "tmate", "install git", "scoop"} {
+			if strings.Contains(s, unwanted) {
+				t.Errorf("slim %s script contains %q", name, unwanted)
+			}
+		}
+	}
+
+	params.Slim = false
+	if s := cloudinit.Linux(params); !strings.Contains(s, "docker-ce") || !strings.Contains(s, "plugin-linux") {
+		t.Error("ubuntu script does not install docker and the plugin")
+	}
+}
+
+// TestTuning verifies that the linux scripts apply the kernel settings before the lite-engine starts.
+func TestTuning(t *testing.T) {
+	params := &cloudinit.Params{
+		LiteEnginePath: liteEnginePath,
+		Platform:       types.Platform{OS: oshelp.OSLinux, Arch: oshelp.ArchAMD64},
+		Tuning:         types.Tuning{SwapSizeMB: 4096, MaxMapCount: 262144, NoFile: 65536, TransparentHugepages: "never"},
+	}
+	script := params.TuningScript()
+	for _, want := range []string{"fallocate -l 4096M /swapfile", "sysctl -w vm.max_map_count=262144", "* soft nofile 65536",
+		"echo never > /sys/kernel/mm/transparent_hugepage/enabled"} {
+		if !strings.Contains(script, want) {
+			t.Errorf("tuning script does not contain %q", want)
+		}
+	}
+
+	s := cloudinit.LinuxBash(params)
+	if i := strings.Index(s, "ulimit -n 65536"); i < 0 || i > strings.Index(s, "/usr/bin/lite-engine server") || !strings.Contains(s, script) {
+		t.Error("bash script does not apply the kernel settings before starting the lite-engine")
+	}
+
+	var doc struct {
+		WriteFiles []struct {
+			Path    string `yaml:"path"`
+			Content string `yaml:"content"`
+		} `yaml:"write_files"`
+		RunCmd []string `yaml:"runcmd"`
+	}
+	if err := yaml.Unmarshal([]byte(cloudinit.Linux(params)), &doc); err != nil {
+		t.Fatalf("init script is not valid cloud-config: %s", err)
+	}
+	var found bool
+	for _, f := range doc.WriteFiles {
+		if f.Path == "/etc/lite-engine/tuning.sh" {
+			b, _ := base64.StdEncoding.DecodeString(f.Content)
+			found = string(b) == script
+		}
+	}
+	if !found || !strings.Contains(strings.Join(doc.RunCmd, "\n"), "sh /etc/lite-engine/tuning.sh\nulimit -n 65536\n") {
+		t.Errorf("cloud-config does not apply the kernel settings: %v", doc.RunCmd)
+	}
+
+	params.Persistent = true
+	if s := cloudinit.Linux(params); !strings.Contains(s, "LimitNOFILE=65536") {
+		t.Error("lite-engine unit does not set the file descriptor limit")
+	}
+
+	params.Tuning = types.Tuning{}
+	if s := cloudinit.Linux(params); strings.Contains(s, "tuning.sh") {
+		t.Error("init script applies the kernel settings although none is set")
+	}
+}
+
+// TestChecksums verifies that the scripts check the downloaded binaries against their digests.
+func TestChecksums(t *testing.T) {
+	leSum, pluginSum := strings.Repeat("ab", 32), strings.Repeat("cd", 32)
+	params := &cloudinit.Params{
+		LiteEnginePath:  liteEnginePath,
+		PluginBinaryURI: "https://github.com/drone/plugin/releases/download/v0.1.0",
+		Platform:        types.Platform{OS: oshelp.OSLinux, Arch: oshelp.ArchAMD64},
+		Checksums:       types.Checksums{LiteEngine: leSum, Plugin: pluginSum},
+	}
+
+	var doc struct {
+		RunCmd []string `yaml:"runcmd"`
+	}
+	if err := yaml.Unmarshal([]byte(cloudinit.Linux(params)), &doc); err != nil {
+		t.Fatalf("init script is not valid cloud-config: %s", err)
+	}
+	for file, sum := range map[string]string{"/usr/bin/lite-engine": leSum, "/usr/bin/plugin": pluginSum} {
+		var verified bool
+		for i, cmd := range doc.RunCmd {
+			if strings.HasSuffix(cmd, "-O "+file) {
+				verified = i+1 < len(doc.RunCmd) && doc.RunCmd[i+1] == params.Verify(sum, file)
+			}
+		}
+		if !verified {
+			t.Errorf("cloud-config does not verify %s after downloading it: %v", file, doc.RunCmd)
+		}
+	}
+
+	s := cloudinit.LinuxBash(params)
+	if i := strings.Index(s, `echo "`+leSum+`  /usr/bin/lite-engine" | sha256sum -c -`); i < 0 || i > strings.Index(s, "/usr/bin/lite-engine server") {
+		t.Error("bash script does not verify the lite-engine before starting it")
+	}
+
+	mac := *params
+	mac.Platform = types.Platform{OS: oshelp.OSMac, Arch: oshelp.ArchARM64}
+	if s := cloudinit.Mac(&mac); !strings.Contains(s, `/opt/homebrew/bin/lite-engine" | shasum -a 256 -c -`) {
+		t.Error("mac script does not verify the lite-engine")
+	}
+
+	if s := cloudinit.Windows(params); !strings.Contains(s, `$checksums["$dir\lite-engine.exe"] = "`+leSum+`"`) ||
+		!strings.Contains(s, `$checksums["$dir\plugin.exe"] = "`+pluginSum+`"`) {
+		t.Error("windows script does not verify the downloads")
+	}
+
+	params.Checksums = types.Checksums{}
+	if s := cloudinit.LinuxBash(params); strings.Contains(s, "sha256sum") {
+		t.Error("bash script verifies the downloads although no checksum is set")
+	}
+}
+
 func TestWindows(t *testing.T) {
 	params := &cloudinit.Params{
 		LiteEnginePath: liteEnginePath,
@@ -53,3 +210,356 @@ func TestWindows(t *testing.T) {
 		t.Error("windows init script does not contain LE path")
 	}
 }
+
+// TestWindowsPreinstalled verifies that the windows init script skips the installation of the
+// software pre-installed by the image and signals the readiness of the instance.
+func TestWindowsPreinstalled(t *testing.T) {
+	params := &cloudinit.Params{
+		LiteEnginePath:  liteEnginePath,
+		PluginBinaryURI: "https://github.com/drone/plugin/releases/download/v0.1.0",
+		Platform:        types.Platform{OS: oshelp.OSWindows, Arch: oshelp.ArchAMD64},
+	}
+	s := cloudinit.Windows(params)
+	for _, want := range []string{
+		"if (Get-Command git -ErrorAction SilentlyContinue)",
+		`if (installed $liteEngine "$dir\lite-engine.exe")`,
+		`if (installed $plugin "$dir\plugin.exe")`,
+		`Restart-Service -Name "lite-engine"`,
+		"Start-Job -ScriptBlock $download",
+		`Set-Content -Path "$dir\ready"`,
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("windows init script does not contain %q", want)
+		}
+	}
+	if strings.Index(s, "New-NetFirewallRule") > strings.Index(s, "Start-Job") {
+		t.Error("windows init script opens the lite-engine port after the downloads")
+	}
+}
+
+// TestWindowsContainers verifies that the windows init script sets up docker for the process
+// isolation and starts the lite-engine on boot, so it comes back once the instance restarts.
+func TestWindowsContainers(t *testing.T) {
+	params := &cloudinit.Params{
+		LiteEnginePath: liteEnginePath,
+		Platform:       types.Platform{OS: oshelp.OSWindows, Arch: oshelp.ArchAMD64},
+	}
+	if s := cloudinit.Windows(params); strings.Contains(s, "Containers") || strings.Contains(s, "$reboot") {
+		t.Error("windows init script sets up the Windows containers although they are not enabled")
+	}
+
+	params.WindowsContainers = types.WindowsContainers{
+		Enabled:    true,
+		BaseImages: []string{"mcr.microsoft.com/windows/servercore", "mcr.microsoft.com/windows/nanoserver:ltsc2022"},
+	}
+	s := cloudinit.Windows(params)
+	for _, want := range []string{
+		"Install-WindowsFeature -Name Containers",
+		"docker-" + cloudinit.DefaultDockerVersion + ".zip",
+		`-NotePropertyValue @("isolation=process")`,
+		`foreach ($image in @('mcr.microsoft.com/windows/servercore', 'mcr.microsoft.com/windows/nanoserver:ltsc2022'))`,
+		"New-ScheduledTaskTrigger -AtStartup",
+		"Restart-Computer -Force",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("windows init script does not contain %q", want)
+		}
+	}
+	if strings.Contains(s, "Start-Process -FilePath") {
+		t.Error("windows init script does not start the lite-engine on boot")
+	}
+	if strings.Index(s, "Restart-Computer") < strings.Index(s, `Set-Content -Path "$dir\ready"`) {
+		t.Error("windows init script restarts the instance before it is initialized")
+	}
+}
+
+// TestPersistent verifies that the lite-engine of the instances resumed from standby is started on boot.
+func TestPersistent(t *testing.T) {
+	for _, osName := range []string{"ubuntu", oshelp.AmazonLinux} {
+		params := &cloudinit.Params{
+			LiteEnginePath: liteEnginePath,
+			CACert:         caCertFile + "\n",
+			TLSCert:        certFile + "\n",
+			TLSKey:         keyFile + "\n",
+			Platform:       types.Platform{OS: oshelp.OSLinux, OSName: osName, Arch: oshelp.ArchAMD64},
+		}
+
+		s := cloudinit.Linux(params)
+		if strings.Contains(s, "lite-engine.service") || !strings.Contains(s, "lite-engine.log 2>&1 &") {
+			t.Errorf("%s: init script of a non persistent instance must start the lite-engine in the background", osName)
+		}
+
+		params.Persistent = true
+		s = cloudinit.Linux(params)
+		if !strings.Contains(s, "systemctl enable --now lite-engine.service") {
+			t.Errorf("%s: persistent init script does not enable the lite-engine service", osName)
+		}
+		if strings.Contains(s, "lite-engine.log 2>&1 &") {
+			t.Errorf("%s: persistent init script starts the lite-engine in the background", osName)
+		}
+		if !strings.Contains(s, "SERVER_CERT_FILE=/etc/lite-engine/certs/server-cert.pem") {
+			t.Errorf("%s: persistent init script does not keep the certificates out of /tmp", osName)
+		}
+
+		var doc struct {
+			WriteFiles []struct {
+				Path    string `yaml:"path"`
+				Content string `yaml:"content"`
+			} `yaml:"write_files"`
+			RunCmd []string `yaml:"runcmd"`
+		}
+		if err := yaml.Unmarshal([]byte(s), &doc); err != nil {
+			t.Fatalf("%s: persistent init script is not valid cloud-config: %s", osName, err)
+		}
+		var unit string
+		for _, f := range doc.WriteFiles {
+			if f.Path == "/etc/systemd/system/lite-engine.service" {
+				unit = f.Content
+			}
+		}
+		if !strings.Contains(unit, "ExecStart=/usr/bin/lite-engine server --env-file /root/.env\n") ||
+			!strings.Contains(unit, "WantedBy=multi-user.target") {
+			t.Errorf("%s: unexpected lite-engine unit file %q", osName, unit)
+		}
+	}
+
+	params := &cloudinit.Params{
+		LiteEnginePath: liteEnginePath,
+		Platform:       types.Platform{OS: oshelp.OSWindows, Arch: oshelp.ArchAMD64},
+		Persistent:     true,
+	}
+	s := cloudinit.Windows(params)
+	if !strings.Contains(s, "New-ScheduledTaskTrigger -AtStartup") || strings.Contains(s, "Start-Process -FilePath") {
+		t.Error("persistent windows init script does not start the lite-engine on boot")
+	}
+}
+
+func TestIgnition(t *testing.T) {
+	params := &cloudinit.Params{
+		LiteEnginePath: liteEnginePath,
+		CACert:         caCertFile + "\n",
+		TLSCert:        certFile + "\n",
+		TLSKey:         keyFile + "\n",
+		Platform:       types.Platform{OS: "linux", Arch: "amd64"},
+	}
+
+	provider, err := cloudinit.NewProvider(cloudinit.ProviderIgnition, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := provider.Generate(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg map[string]interface{}
+	if err = json.Unmarshal([]byte(s), &cfg); err != nil {
+		t.Errorf("ignition config is not valid json: %s", err)
+	}
+	lePath := fmt.Sprintf(`%s/lite-engine-%s-%s`, params.LiteEnginePath, params.Platform.OS, params.Platform.Arch)
+	if !strings.Contains(s, lePath) {
+		t.Error("ignition config does not contain LE path")
+	}
+}
+
+func TestLiteEnginePort(t *testing.T) {
+	params := &cloudinit.Params{
+		LiteEnginePath: liteEnginePath,
+		Platform:       types.Platform{OS: "linux", Arch: "amd64"},
+		LiteEnginePort: 19079,
+	}
+
+	for name, s := range map[string]string{
+		"linux":   cloudinit.Linux(params),
+		"bash":    cloudinit.LinuxBash(params),
+		"mac":     cloudinit.Mac(params),
+		"windows": cloudinit.Windows(params),
+	} {
+		if !strings.Contains(s, "HTTPS_BIND=:19079") {
+			t.Errorf("%s script does not bind the lite-engine to the port of the pool", name)
+		}
+		if regexp.MustCompile(`\b9079\b`).MatchString(s) {
+			t.Errorf("%s script uses the default lite-engine port", name)
+		}
+	}
+	if s := cloudinit.Linux(params); !strings.Contains(s, "ufw allow 19079") {
+		t.Error("linux script does not open the port of the pool")
+	}
+
+	params.LiteEnginePort = 0
+	if s := cloudinit.Linux(params); !strings.Contains(s, fmt.Sprintf("HTTPS_BIND=:%d", cloudinit.DefaultLiteEnginePort)) {
+		t.Error("linux script does not bind the lite-engine to the default port")
+	}
+}
+
+func TestLiteEngineEnv(t *testing.T) {
+	params := &cloudinit.Params{
+		LiteEnginePath:     liteEnginePath,
+		Platform:           types.Platform{OS: "linux", Arch: "amd64"},
+		LiteEngineEnv:      map[string]string{"DNS_SERVERS": "10.0.0.2", "CONTAINERD_ADDRESS": "/run/containerd.sock"},
+		LiteEngineFeatures: []string{"containerd", "pull_cache"},
+	}
+	want := "CONTAINERD_ADDRESS=/run/containerd.sock\nDNS_SERVERS=10.0.0.2\nFEATURE_FLAGS=containerd,pull_cache\n"
+	if got := params.LiteEngineEnvFile(); got != want {
+		t.Fatalf("want the environment %q, got %q", want, got)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(want))
+	for name, s := range map[string]string{
+		"linux":   cloudinit.Linux(params),
+		"bash":    cloudinit.LinuxBash(params),
+		"mac":     cloudinit.Mac(params),
+		"windows": cloudinit.Windows(params),
+	} {
+		if !strings.Contains(s, encoded) {
+			t.Errorf("%s script does not add the environment of the pool to the lite-engine", name)
+		}
+	}
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal([]byte(cloudinit.Linux(params)), &doc); err != nil {
+		t.Fatalf("init script is not valid cloud-config: %s", err)
+	}
+	s, err := (&cloudinit.IgnitionProvider{}).Generate(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg struct {
+		Storage struct {
+			Files []struct {
+				Path     string `json:"path"`
+				Contents struct {
+					Source string `json:"source"`
+				} `json:"contents"`
+			} `json:"files"`
+		} `json:"storage"`
+	}
+	if err = json.Unmarshal([]byte(s), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range cfg.Storage.Files {
+		if f.Path != "/etc/lite-engine/.env" {
+			continue
+		}
+		b, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(f.Contents.Source, "data:;base64,"))
+		if !strings.HasSuffix(string(b), want) {
+			t.Errorf("ignition environment does not end with the environment of the pool: %q", b)
+		}
+	}
+
+	params.LiteEngineEnv, params.LiteEngineFeatures = nil, nil
+	if s := cloudinit.Linux(params); strings.Contains(s, "base64 -d >> /root/.env") {
+		t.Error("linux script adds an environment although the pool sets none")
+	}
+}
+
+func TestLiteEngineEnv_LeaseKey(t *testing.T) {
+	for _, test := range []struct {
+		os   string
+		file string
+	}{
+		{os: oshelp.OSLinux, file: "/etc/lite-engine/lease.key"},
+		{os: oshelp.OSMac, file: "/tmp/lite-engine/lease.key"},
+		{os: oshelp.OSWindows, file: `C:\Program Files\lite-engine\lease.key`},
+	} {
+		params := &cloudinit.Params{
+			Platform:           types.Platform{OS: test.os, Arch: "amd64"},
+			LiteEngineFeatures: []string{"containerd"},
+			LeaseKey:           "key",
+		}
+		want := "FEATURE_FLAGS=containerd\nLEASE_KEY=key\nLEASE_KEY_FILE=" + test.file + "\n"
+		if got := params.LiteEngineEnvFile(); got != want {
+			t.Errorf("want the %s environment %q, got %q", test.os, want, got)
+		}
+	}
+}
+
+func TestTunnel(t *testing.T) {
+	params := &cloudinit.Params{
+		LiteEnginePath: liteEnginePath,
+		Platform:       types.Platform{OS: "linux", Arch: "amd64"},
+		LiteEnginePort: 19079,
+		Tunnel:         &types.Tunnel{Address: "runner.example.com:2222", KnownHosts: "runner.example.com ssh-ed25519 AAAA"},
+	}
+	args := params.TunnelArgs()
+	for _, want := range []string{"-p 2222", "-R 127.0.0.1:19079:127.0.0.1:19079", "tunnel@runner.example.com", "StrictHostKeyChecking=yes"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("tunnel args %q do not contain %q", args, want)
+		}
+	}
+	if s := cloudinit.Linux(params); !strings.Contains(s, "lite-engine-tunnel.service") {
+		t.Error("linux script does not start the tunnel")
+	}
+	if s := cloudinit.LinuxBash(params); !strings.Contains(s, args) {
+		t.Error("bash script does not start the tunnel")
+	}
+
+	params.Tunnel = nil
+	if s := cloudinit.Linux(params); strings.Contains(s, "lite-engine-tunnel") {
+		t.Error("linux script starts the tunnel although it is not enabled")
+	}
+}
+
+func TestKeyFetch(t *testing.T) {
+	const fetch = "aws ssm get-parameter --region us-east-2 --name /drone-runner/keys/ref --with-decryption " +
+		"--query Parameter.Value --output text | base64 -d"
+	write := "(umask 077 && " + fetch + " > /tmp/certs/server-key.pem)"
+	for _, osName := range []string{"ubuntu", oshelp.AmazonLinux} {
+		params := &cloudinit.Params{
+			LiteEnginePath: liteEnginePath,
+			CACert:         caCertFile + "\n",
+			TLSCert:        certFile + "\n",
+			KeyFetch:       fetch,
+			Platform:       types.Platform{OS: oshelp.OSLinux, OSName: osName, Arch: oshelp.ArchAMD64},
+		}
+		var doc struct {
+			WriteFiles []struct {
+				Path string `yaml:"path"`
+			} `yaml:"write_files"`
+			RunCmd []string `yaml:"runcmd"`
+		}
+		if err := yaml.Unmarshal([]byte(cloudinit.Linux(params)), &doc); err != nil {
+			t.Fatalf("%s: init script is not valid cloud-config: %s", osName, err)
+		}
+		for _, f := range doc.WriteFiles {
+			if f.Path == "/tmp/certs/server-key.pem" {
+				t.Errorf("%s: init script writes the key although it is fetched", osName)
+			}
+		}
+		fetched, checked := -1, -1
+		for i, cmd := range doc.RunCmd {
+			if cmd == write {
+				fetched = i
+			}
+			if strings.Contains(cmd, "drone-runner-phase: certs") && checked < 0 {
+				checked = i
+			}
+		}
+		if fetched < 0 || fetched > checked {
+			t.Errorf("%s: init script does not fetch the key before the certificates are checked, got %q", osName, doc.RunCmd)
+		}
+	}
+
+	params := &cloudinit.Params{
+		LiteEnginePath: liteEnginePath,
+		KeyFetch:       fetch,
+		Platform:       types.Platform{OS: oshelp.OSLinux, OSName: oshelp.RHEL, Arch: oshelp.ArchAMD64},
+	}
+	s := cloudinit.LinuxBash(params)
+	if !strings.Contains(s, write) || strings.Contains(s, "base64 -d >> /tmp/certs/server-key.pem") {
+		t.Error("bash script does not fetch the key")
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	if _, err := cloudinit.NewProvider("talos", ""); err == nil {
+		t.Error("expected an error for an unknown provider")
+	}
+	provider, err := cloudinit.NewProvider("talos", "custom {{ .LiteEnginePath }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := provider.Generate(&cloudinit.Params{LiteEnginePath: liteEnginePath})
+	if s != "custom "+liteEnginePath {
+		t.Errorf("Want custom template, got %s", s)
+	}
+}
@@ -0,0 +1,144 @@
+package cloudinit
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PhaseMarker prefixes the markers the linux startup scripts write to the console of the instance
+// as they go through their phases. The runner reads them from the console output when the
+// lite-engine of a new instance doesn't become healthy, to tell a failed startup script from an
+// unhealthy lite-engine, see ClassifyBoot.
+const PhaseMarker = "drone-runner-phase:"
+
+// Phases of the startup scripts, in order.
+const (
+	PhaseBoot       = "boot"        // the startup script runs
+	PhasePackages   = "packages"    // docker and the other packages are installed
+	PhaseCerts      = "certs"       // the certificates of the lite-engine are written
+	PhaseDownload   = "download"    // the lite-engine is downloaded and verified
+	PhaseStart      = "start"       // the lite-engine is started
+	PhaseLiteEngine = "lite-engine" // the lite-engine exited
+)
+
+const (
+	phaseOK     = "ok"
+	phaseFailed = "failed"
+	phaseExited = "exited"
+)
+
+var phaseOrder = []string{PhaseBoot, PhasePackages, PhaseCerts, PhaseDownload, PhaseStart}
+
+// what the startup script does in the phases, for the messages.
+var phaseActions = map[string]string{
+	PhasePackages: "installing the packages, e.g. docker",
+	PhaseCerts:    "writing the certificates of the lite-engine",
+	PhaseDownload: "downloading the lite-engine",
+	PhaseStart:    "starting the lite-engine",
+}
+
+// Marker returns the shell command writing the marker of the phase to the console, it never fails.
+func (p Params) Marker(phase, status string) string {
+	return fmt.Sprintf(`echo "%s %s %s" > /dev/console 2> /dev/null || true`, PhaseMarker, phase, status)
+}
+
+// Check returns the shell command marking the phase ok if the test passes, failed otherwise.
+func (p Params) Check(phase, test string) string {
+	return fmt.Sprintf("if %s; then %s; else %s; fi", test, p.Marker(phase, phaseOK), p.Marker(phase, phaseFailed))
+}
+
+// ExitWatch returns the shell command marking the exit of the lite-engine started in the background
+// by the previous command.
+func (p Params) ExitWatch() string {
+	return fmt.Sprintf("le=$!; (while kill -0 $le 2> /dev/null; do sleep 5; done; %s) &", p.Marker(PhaseLiteEngine, phaseExited))
+}
+
+// StartMarker and ExitMarker return the commands of the lite-engine service marking its starts, the
+// runcmd of cloud-init only runs on the first boot, and its exits with their status.
+func (p Params) StartMarker() string {
+	return fmt.Sprintf(`/bin/sh -c 'echo "%s %s %s" > /dev/console'`, PhaseMarker, PhaseStart, phaseOK)
+}
+
+func (p Params) ExitMarker() string {
+	return fmt.Sprintf(`/bin/sh -c 'echo "%s %s %s $EXIT_STATUS" > /dev/console'`, PhaseMarker, PhaseLiteEngine, phaseExited)
+}
+
+// BootFailure is the reason the lite-engine of an instance didn't become healthy, found from the
+// phase markers in the console output of the instance.
+type BootFailure struct {
+	Phase   string
+	Message string
+}
+
+func (f *BootFailure) Error() string {
+	return f.Message
+}
+
+// ClassifyBoot returns the failure of the last boot of the instance from its console output, nil if
+// the output has no phase markers, e.g. the instances of a custom user data template, the windows
+// instances, or a driver without the console output.
+func ClassifyBoot(console string) *BootFailure {
+	var (
+		found  bool
+		done   = map[string]bool{}
+		failed string
+		exits  []string
+	)
+	for _, line := range strings.Split(console, "\n") {
+		// the commands writing the markers are traced by the scripts with set -x, only their output counts.
+		i := strings.Index(line, PhaseMarker)
+		if i < 0 || strings.Contains(line[:i], "echo") {
+			continue
+		}
+		fields := strings.Fields(line[i+len(PhaseMarker):])
+		if len(fields) < 2 {
+			continue
+		}
+		phase, status := fields[0], fields[1]
+		if phase == PhaseBoot {
+			// the markers of the previous boots of a restarted instance don't count.
+			done, failed, exits = map[string]bool{}, "", nil
+		}
+		found = true
+		switch {
+		case status == phaseOK:
+			done[phase] = true
+		case status == phaseFailed && failed == "":
+			failed = phase
+		case phase == PhaseLiteEngine && status == phaseExited:
+			exits = append(exits, strings.Join(fields[2:], " "))
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	switch {
+	case failed != "":
+		return &BootFailure{Phase: failed, Message: fmt.Sprintf("the startup script failed while %s", phaseActions[failed])}
+	case len(exits) > 1:
+		return &BootFailure{Phase: PhaseLiteEngine, Message: fmt.Sprintf("the lite-engine is crash looping, it exited %d times%s",
+			len(exits), exitStatus(", last", exits[len(exits)-1]))}
+	case len(exits) == 1:
+		return &BootFailure{Phase: PhaseLiteEngine, Message: "the lite-engine exited" + exitStatus("", exits[0])}
+	case done[PhaseStart]:
+		return &BootFailure{Phase: PhaseStart, Message: "the lite-engine is running but it didn't answer the health check, " +
+			"check the runner can reach the instance"}
+	}
+	// the script stopped in the phase after the last completed one, the slim scripts skip the packages.
+	last := 0
+	for i, phase := range phaseOrder {
+		if done[phase] {
+			last = i
+		}
+	}
+	next := phaseOrder[last+1]
+	return &BootFailure{Phase: next, Message: fmt.Sprintf("the startup script stopped while %s", phaseActions[next])}
+}
+
+func exitStatus(prefix, status string) string {
+	if status == "" {
+		return ""
+	}
+	return prefix + " with status " + status
+}
@@ -0,0 +1,113 @@
+package cloudinit_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/internal/cloudinit"
+	"github.com/drone-runners/drone-runner-aws/internal/oshelp"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"gopkg.in/yaml.v2"
+)
+
+func TestClassifyBoot(t *testing.T) {
+	const (
+		boot     = "[    5.1] cloud-init[712]: drone-runner-phase: boot ok\n"
+		packages = "drone-runner-phase: packages ok\n"
+		certs    = "drone-runner-phase: certs ok\n"
+		download = "drone-runner-phase: download ok\n"
+		start    = "drone-runner-phase: start ok\n"
+	)
+	tests := []struct {
+		name    string
+		console string
+		phase   string
+		message string
+	}{
+		{
+			name:    "no markers",
+			console: "Ubuntu 22.04 LTS\nlogin:",
+		},
+		{
+			name:    "packages failed",
+			console: boot + "drone-runner-phase: packages failed\n" + certs + download + start,
+			phase:   cloudinit.PhasePackages,
+			message: "failed while installing the packages",
+		},
+		{
+			name:    "checksum mismatch",
+			console: boot + packages + certs + "checksum mismatch of /usr/bin/lite-engine\n",
+			phase:   cloudinit.PhaseDownload,
+			message: "stopped while downloading the lite-engine",
+		},
+		{
+			name:    "slim",
+			console: boot + "drone-runner-phase: certs failed\n",
+			phase:   cloudinit.PhaseCerts,
+			message: "failed while writing the certificates",
+		},
+		{
+			name:    "crash loop",
+			console: boot + packages + certs + download + start + "drone-runner-phase: lite-engine exited 1\ndrone-runner-phase: lite-engine exited 2\n",
+			phase:   cloudinit.PhaseLiteEngine,
+			message: "crash looping, it exited 2 times, last with status 2",
+		},
+		{
+			name:    "exited",
+			console: boot + packages + certs + download + start + "+ echo drone-runner-phase: lite-engine exited\ndrone-runner-phase: lite-engine exited\n",
+			phase:   cloudinit.PhaseLiteEngine,
+			message: "the lite-engine exited",
+		},
+		{
+			name:    "unreachable",
+			console: "drone-runner-phase: lite-engine exited 0\n" + boot + packages + certs + download + start,
+			phase:   cloudinit.PhaseStart,
+			message: "didn't answer the health check",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			failure := cloudinit.ClassifyBoot(test.console)
+			if test.phase == "" {
+				if failure != nil {
+					t.Fatalf("expected no failure, got %v", failure)
+				}
+				return
+			}
+			if failure == nil || failure.Phase != test.phase || !strings.Contains(failure.Message, test.message) {
+				t.Fatalf("expected a failure of phase %s containing %q, got %+v", test.phase, test.message, failure)
+			}
+		})
+	}
+}
+
+// TestPhaseMarkers verifies the linux scripts mark their phases in order and stay valid cloud-config.
+func TestPhaseMarkers(t *testing.T) {
+	for _, osName := range []string{"ubuntu", oshelp.AmazonLinux, oshelp.RHEL} {
+		for _, persistent := range []bool{false, true} {
+			params := &cloudinit.Params{
+				LiteEnginePath: liteEnginePath,
+				Platform:       types.Platform{OS: oshelp.OSLinux, OSName: osName, Arch: oshelp.ArchAMD64},
+				Persistent:     persistent && osName != oshelp.RHEL,
+			}
+			s := cloudinit.Linux(params)
+			last := -1
+			for _, phase := range []string{"boot ok", "packages ok", "certs ok", "download ok", "start ok"} {
+				i := strings.LastIndex(s, "drone-runner-phase: "+phase)
+				if i < 0 || i < last {
+					t.Errorf("%s: the marker %q is missing or out of order", osName, phase)
+				}
+				last = i
+			}
+			if !strings.Contains(s, "drone-runner-phase: lite-engine exited") {
+				t.Errorf("%s: the exits of the lite-engine are not marked", osName)
+			}
+			if osName != oshelp.RHEL {
+				var doc map[string]interface{}
+				if err := yaml.Unmarshal([]byte(s), &doc); err != nil {
+					t.Fatalf("%s: the init script is not valid cloud-config: %s", osName, err)
+				}
+			}
+		}
+	}
+}
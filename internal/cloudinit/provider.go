@@ -0,0 +1,201 @@
+package cloudinit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-aws/internal/oshelp"
+)
+
+// Startup script providers.
+const (
+	ProviderCloudInit = "cloud-init"
+	ProviderShell     = "shell"
+	ProviderIgnition  = "ignition"
+)
+
+// Provider generates the startup script an instance runs on the first boot to install and start the lite-engine.
+type Provider interface {
+	Generate(params *Params) (string, error)
+}
+
+// NewProvider returns the startup script provider of the given kind. The cloud-init provider is used by default.
+// A custom template always takes precedence over the kind.
+func NewProvider(kind, template string) (Provider, error) {
+	if template != "" {
+		return &CustomProvider{Template: template}, nil
+	}
+
+	switch kind {
+	case "", ProviderCloudInit:
+		return &CloudInitProvider{}, nil
+	case ProviderShell:
+		return &ShellProvider{}, nil
+	case ProviderIgnition:
+		return &IgnitionProvider{}, nil
+	default:
+		return nil, fmt.Errorf("unknown startup script provider %q", kind)
+	}
+}
+
+// CloudInitProvider generates cloud-init user data, a powershell script on windows.
+type CloudInitProvider struct{}
+
+func (*CloudInitProvider) Generate(params *Params) (string, error) {
+	switch params.Platform.OS {
+	case oshelp.OSWindows:
+		return Windows(params), nil
+	case oshelp.OSMac:
+		return Mac(params), nil
+	default:
+		return Linux(params), nil
+	}
+}
+
+// ShellProvider generates a raw shell script, for images which execute the user data directly.
+type ShellProvider struct{}
+
+func (*ShellProvider) Generate(params *Params) (string, error) {
+	switch params.Platform.OS {
+	case oshelp.OSWindows:
+		return Windows(params), nil
+	case oshelp.OSMac:
+		return Mac(params), nil
+	default:
+		return LinuxBash(params), nil
+	}
+}
+
+// CustomProvider generates the startup script from a user defined template.
+type CustomProvider struct {
+	Template string
+}
+
+func (p *CustomProvider) Generate(params *Params) (string, error) {
+	return Custom(p.Template, params)
+}
+
+// IgnitionProvider generates an Ignition config, for image families which don't run cloud-init, like Flatcar.
+// The lite-engine runs as a systemd service. Certificates and the binary are kept out of
+// the read-only /usr and the /tmp directory, which is not available while Ignition runs.
+type IgnitionProvider struct{}
+
+const (
+	ignitionVersion   = "3.3.0"
+	ignitionConfigDir = "/etc/lite-engine"
+	ignitionBinary    = "/opt/bin/lite-engine"
+)
+
+type (
+	ignitionConfig struct {
+		Ignition ignitionMeta    `json:"ignition"`
+		Storage  ignitionStorage `json:"storage"`
+		Systemd  ignitionSystemd `json:"systemd"`
+	}
+
+	ignitionMeta struct {
+		Version string `json:"version"`
+	}
+
+	ignitionStorage struct {
+		Files []ignitionFile `json:"files"`
+	}
+
+	ignitionFile struct {
+		Path      string           `json:"path"`
+		Mode      int              `json:"mode"`
+		Overwrite bool             `json:"overwrite"`
+		Contents  ignitionContents `json:"contents"`
+	}
+
+	ignitionContents struct {
+		Source string `json:"source"`
+	}
+
+	ignitionSystemd struct {
+		Units []ignitionUnit `json:"units"`
+	}
+
+	ignitionUnit struct {
+		Name     string `json:"name"`
+		Enabled  bool   `json:"enabled"`
+		Contents string `json:"contents"`
+	}
+)
+
+func (*IgnitionProvider) Generate(params *Params) (string, error) {
+	if params.Platform.OS != oshelp.OSLinux {
+		return "", fmt.Errorf("ignition startup script is not supported on %s", params.Platform.OS)
+	}
+
+	caCertPath := ignitionConfigDir + "/certs/ca-cert.pem"
+	certPath := ignitionConfigDir + "/certs/server-cert.pem"
+	keyPath := ignitionConfigDir + "/certs/server-key.pem"
+	envPath := ignitionConfigDir + "/.env"
+
+	env := strings.Join([]string{
+		"SKIP_PREPARE_SERVER=true",
+		"SERVER_CERT_FILE=" + certPath,
+		"SERVER_KEY_FILE=" + keyPath,
+		"CLIENT_CERT_FILE=" + caCertPath,
+		fmt.Sprintf("HTTPS_BIND=:%d", params.Port()),
+	}, "\n") + "\n" + params.LiteEngineEnvFile()
+
+	// the unit fails before the lite-engine starts if the download doesn't match its checksum
+	var verify string
+	if sum := params.Checksums.LiteEngine; sum != "" {
+		verify = fmt.Sprintf("ExecStartPre=/bin/sh -c 'echo \"%s  %s\" | sha256sum -c -'\n", strings.ToLower(sum), ignitionBinary)
+	}
+
+	unit := fmt.Sprintf(`[Unit]
+Description=Harness lite-engine
+Wants=network-online.target docker.service
+After=network-online.target docker.service
+
+[Service]
+ExecStartPre=/usr/bin/mkdir -p /opt/bin
+ExecStartPre=/usr/bin/curl -fsSL --retry 5 -o %[1]s "%[2]s/lite-engine-%[3]s-%[4]s"
+%[6]sExecStartPre=/usr/bin/chmod 0755 %[1]s
+ExecStart=%[1]s server --env-file %[5]s
+Restart=on-failure
+
+[Install]
+WantedBy=multi-user.target
+`, ignitionBinary, params.LiteEnginePath, params.Platform.OS, params.Platform.Arch, envPath, verify)
+
+	cfg := ignitionConfig{
+		Ignition: ignitionMeta{Version: ignitionVersion},
+		Storage: ignitionStorage{
+			Files: []ignitionFile{
+				ignitionDataFile(caCertPath, 0600, params.CACert), //nolint:gomnd
+				ignitionDataFile(certPath, 0600, params.TLSCert),  //nolint:gomnd
+				ignitionDataFile(keyPath, 0600, params.TLSKey),    //nolint:gomnd
+				ignitionDataFile(envPath, 0600, env),              //nolint:gomnd
+			},
+		},
+		Systemd: ignitionSystemd{
+			Units: []ignitionUnit{
+				{Name: "lite-engine.service", Enabled: true, Contents: unit},
+			},
+		},
+	}
+
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal ignition config: %w", err)
+	}
+	return string(b), nil
+}
+
+func ignitionDataFile(path string, mode int, data string) ignitionFile {
+	return ignitionFile{
+		Path:      path,
+		Mode:      mode,
+		Overwrite: true,
+		Contents: ignitionContents{
+			Source: "data:;base64," + base64.StdEncoding.EncodeToString([]byte(data)),
+		},
+	}
+}
@@ -0,0 +1,65 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+
+	clouddns "google.golang.org/api/dns/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// cloudDNSRegistrar registers the hosts in a google cloud dns managed zone.
+type cloudDNSRegistrar struct {
+	service     *clouddns.Service
+	project     string
+	managedZone string
+	zone        string
+	ttl         int64
+}
+
+func newCloudDNS(ctx context.Context, project, managedZone, jsonPath, zone string, ttl int) (*cloudDNSRegistrar, error) {
+	var opts []option.ClientOption
+	if jsonPath != "" {
+		opts = append(opts, option.WithCredentialsFile(jsonPath))
+	}
+	service, err := clouddns.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("dns: failed to create the cloud dns client: %w", err)
+	}
+	return &cloudDNSRegistrar{service: service, project: project, managedZone: managedZone, zone: zone, ttl: int64(ttl)}, nil
+}
+
+func (r *cloudDNSRegistrar) Register(ctx context.Context, host string, addr netip.Addr) error {
+	rrset := &clouddns.ResourceRecordSet{
+		Name:    fqdn(host, r.zone),
+		Type:    recordType(addr),
+		Ttl:     r.ttl,
+		Rrdatas: []string{addr.String()},
+	}
+	_, err := r.service.ResourceRecordSets.Create(r.project, r.managedZone, rrset).Context(ctx).Do()
+	if isStatus(err, http.StatusConflict) {
+		_, err = r.service.ResourceRecordSets.Patch(r.project, r.managedZone, rrset.Name, rrset.Type, rrset).Context(ctx).Do()
+	}
+	if err != nil {
+		return fmt.Errorf("dns: failed to register %s in cloud dns: %w", rrset.Name, err)
+	}
+	return nil
+}
+
+func (r *cloudDNSRegistrar) Deregister(ctx context.Context, host string, addr netip.Addr) error {
+	name := fqdn(host, r.zone)
+	_, err := r.service.ResourceRecordSets.Delete(r.project, r.managedZone, name, recordType(addr)).Context(ctx).Do()
+	if err != nil && !isStatus(err, http.StatusNotFound) {
+		return fmt.Errorf("dns: failed to deregister %s in cloud dns: %w", name, err)
+	}
+	return nil
+}
+
+func isStatus(err error, code int) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == code
+}
@@ -0,0 +1,93 @@
+// Package dns registers the instances in a DNS zone, so debugging sessions and webhooks can reach
+// them by a stable name instead of their address.
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Providers of the zone.
+const (
+	ProviderRoute53  = "route53"
+	ProviderCloudDNS = "clouddns"
+	ProviderRFC2136  = "rfc2136"
+)
+
+// defaultTTL is the TTL of the records, in seconds, if not configured.
+const defaultTTL = 60
+
+// Registrar manages the address records of the hosts of a zone.
+type Registrar interface {
+	// Register creates the address record of the host, the record replaces the previous one.
+	Register(ctx context.Context, host string, addr netip.Addr) error
+	// Deregister deletes the address record of the host, it's a no-op if the record does not exist.
+	Deregister(ctx context.Context, host string, addr netip.Addr) error
+}
+
+// Config configures the zone the instances are registered in.
+type Config struct {
+	Provider string
+	Zone     string // the domain of the hosts, e.g. ci.example.com
+	TTL      int
+
+	Route53ZoneID string // the hosted zone of the route53 provider
+
+	GoogleProject     string // the project of the clouddns managed zone
+	GoogleManagedZone string // the managed zone of the clouddns provider
+	GoogleJSONPath    string // the credentials of the clouddns provider, the default credentials if empty
+
+	Server        string // host:port of the rfc2136 server
+	TSIGKey       string // the name of the TSIG key signing the updates, unsigned if empty
+	TSIGSecret    string // the base64 encoded secret of the TSIG key
+	TSIGAlgorithm string // hmac-sha256 if empty
+}
+
+// New returns the registrar of the config, nil if no provider is set.
+func New(ctx context.Context, cfg *Config) (Registrar, error) {
+	if cfg.Provider == "" {
+		return nil, nil
+	}
+	zone := strings.Trim(cfg.Zone, ".")
+	if zone == "" {
+		return nil, fmt.Errorf("dns: the zone of the %s provider is not set", cfg.Provider)
+	}
+	ttl := cfg.TTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	switch cfg.Provider {
+	case ProviderRoute53:
+		if cfg.Route53ZoneID == "" {
+			return nil, fmt.Errorf("dns: the hosted zone of the %s provider is not set", cfg.Provider)
+		}
+		return newRoute53(cfg.Route53ZoneID, zone, ttl)
+	case ProviderCloudDNS:
+		if cfg.GoogleProject == "" || cfg.GoogleManagedZone == "" {
+			return nil, fmt.Errorf("dns: the project and the managed zone of the %s provider are not set", cfg.Provider)
+		}
+		return newCloudDNS(ctx, cfg.GoogleProject, cfg.GoogleManagedZone, cfg.GoogleJSONPath, zone, ttl)
+	case ProviderRFC2136:
+		if cfg.Server == "" {
+			return nil, fmt.Errorf("dns: the server of the %s provider is not set", cfg.Provider)
+		}
+		return newRFC2136(cfg.Server, zone, ttl, cfg.TSIGKey, cfg.TSIGSecret, cfg.TSIGAlgorithm)
+	default:
+		return nil, fmt.Errorf("dns: unknown provider %q", cfg.Provider)
+	}
+}
+
+// fqdn returns the fully qualified name of the host in the zone.
+func fqdn(host, zone string) string {
+	return strings.Trim(host, ".") + "." + zone + "."
+}
+
+// recordType returns the type of the address record of the address.
+func recordType(addr netip.Addr) string {
+	if addr.Is4() {
+		return "A"
+	}
+	return "AAAA"
+}
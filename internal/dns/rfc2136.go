@@ -0,0 +1,272 @@
+package dns
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// wire format constants of the messages, see RFC 1035, RFC 2136 and RFC 8945.
+const (
+	typeA    = 1
+	typeSOA  = 6
+	typeAAAA = 28
+	typeTSIG = 250
+
+	classIN   = 1
+	classNone = 254
+	classAny  = 255
+
+	opcodeUpdate = 5
+	headerLen    = 12
+	maxLabelLen  = 63
+	maxNameLen   = 255
+
+	tsigFudge = 300 // seconds of clock skew the server accepts
+)
+
+// rfc2136Timeout bounds an update when the context has no deadline.
+const rfc2136Timeout = 10 * time.Second
+
+var tsigAlgorithms = map[string]func() hash.Hash{
+	"hmac-sha256": sha256.New,
+	"hmac-sha384": sha512.New384,
+	"hmac-sha512": sha512.New,
+}
+
+var rcodes = []string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED", "YXDOMAIN", "YXRRSET", "NXRRSET", "NOTAUTH", "NOTZONE"}
+
+// rfc2136Registrar sends dynamic updates to the primary server of the zone over TCP, signed with
+// the TSIG key when one is configured. The server authenticates the updates, the signatures of its
+// responses are not verified.
+type rfc2136Registrar struct {
+	server    string
+	zone      string
+	ttl       uint32
+	key       string // name of the TSIG key, unsigned updates if empty
+	secret    []byte
+	algorithm string
+	hash      func() hash.Hash
+}
+
+// record is a resource record of the update section.
+type record struct {
+	name  string
+	typ   uint16
+	class uint16
+	ttl   uint32
+	rdata []byte
+}
+
+func newRFC2136(server, zone string, ttl int, key, secret, algorithm string) (*rfc2136Registrar, error) {
+	r := &rfc2136Registrar{server: server, zone: zone, ttl: uint32(ttl)}
+	if key == "" {
+		return r, nil
+	}
+	if algorithm == "" {
+		algorithm = "hmac-sha256"
+	}
+	algorithm = strings.ToLower(strings.TrimSuffix(algorithm, "."))
+	h, ok := tsigAlgorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("dns: unsupported TSIG algorithm %q", algorithm)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil || len(decoded) == 0 {
+		return nil, fmt.Errorf("dns: the secret of the TSIG key %s is not valid base64", key)
+	}
+	r.key = strings.ToLower(strings.TrimSuffix(key, "."))
+	r.secret = decoded
+	r.algorithm = algorithm
+	r.hash = h
+	return r, nil
+}
+
+func (r *rfc2136Registrar) Register(ctx context.Context, host string, addr netip.Addr) error {
+	name := fqdn(host, r.zone)
+	typ := addressType(addr)
+	// the address replaces the addresses the name had
+	updates := []record{
+		{name: name, typ: typ, class: classAny},
+		{name: name, typ: typ, class: classIN, ttl: r.ttl, rdata: addr.AsSlice()},
+	}
+	if err := r.update(ctx, updates); err != nil {
+		return fmt.Errorf("dns: failed to register %s: %w", name, err)
+	}
+	return nil
+}
+
+func (r *rfc2136Registrar) Deregister(ctx context.Context, host string, addr netip.Addr) error {
+	name := fqdn(host, r.zone)
+	// only the address of the instance is deleted, the name might have been registered again since.
+	updates := []record{{name: name, typ: addressType(addr), class: classNone, rdata: addr.AsSlice()}}
+	if err := r.update(ctx, updates); err != nil {
+		return fmt.Errorf("dns: failed to deregister %s: %w", name, err)
+	}
+	return nil
+}
+
+func (r *rfc2136Registrar) update(ctx context.Context, updates []record) error {
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+	msg, err := r.message(binary.BigEndian.Uint16(id[:]), time.Now(), updates)
+	if err != nil {
+		return err
+	}
+	return r.exchange(ctx, msg)
+}
+
+// message returns the update message of the zone, signed if the registrar has a key.
+func (r *rfc2136Registrar) message(id uint16, now time.Time, updates []record) ([]byte, error) {
+	msg := make([]byte, headerLen)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], opcodeUpdate<<11) //nolint:gomnd
+	binary.BigEndian.PutUint16(msg[4:], 1)                // the zone section
+	binary.BigEndian.PutUint16(msg[8:], uint16(len(updates)))
+
+	msg, err := appendName(msg, r.zone)
+	if err != nil {
+		return nil, err
+	}
+	msg = binary.BigEndian.AppendUint16(msg, typeSOA)
+	msg = binary.BigEndian.AppendUint16(msg, classIN)
+	for i := range updates {
+		if msg, err = appendRecord(msg, &updates[i]); err != nil {
+			return nil, err
+		}
+	}
+	if r.key == "" {
+		return msg, nil
+	}
+	return r.sign(msg, id, now)
+}
+
+// sign appends the TSIG record of the message, see RFC 8945 section 4.3.
+func (r *rfc2136Registrar) sign(msg []byte, id uint16, now time.Time) ([]byte, error) {
+	key, err := appendName(nil, r.key)
+	if err != nil {
+		return nil, err
+	}
+	algorithm, err := appendName(nil, r.algorithm)
+	if err != nil {
+		return nil, err
+	}
+	timers := make([]byte, 8) //nolint:gomnd
+	signed := uint64(now.Unix())
+	binary.BigEndian.PutUint16(timers[0:], uint16(signed>>32)) //nolint:gomnd
+	binary.BigEndian.PutUint32(timers[2:], uint32(signed))
+	binary.BigEndian.PutUint16(timers[6:], tsigFudge)
+
+	mac := hmac.New(r.hash, r.secret)
+	mac.Write(msg)
+	mac.Write(key)
+	mac.Write([]byte{0, classAny, 0, 0, 0, 0}) // class and ttl
+	mac.Write(algorithm)
+	mac.Write(timers)
+	mac.Write([]byte{0, 0, 0, 0}) // error and other len
+	sum := mac.Sum(nil)
+
+	rdata := make([]byte, 0, len(algorithm)+len(timers)+len(sum)+8) //nolint:gomnd
+	rdata = append(rdata, algorithm...)
+	rdata = append(rdata, timers...)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(sum)))
+	rdata = append(rdata, sum...)
+	rdata = binary.BigEndian.AppendUint16(rdata, id)
+	rdata = binary.BigEndian.AppendUint16(rdata, 0) // error
+	rdata = binary.BigEndian.AppendUint16(rdata, 0) // other len
+
+	if msg, err = appendRecord(msg, &record{name: r.key, typ: typeTSIG, class: classAny, rdata: rdata}); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint16(msg[10:], 1) // the additional section
+	return msg, nil
+}
+
+// exchange sends the message and checks the response code.
+func (r *rfc2136Registrar) exchange(ctx context.Context, msg []byte) error {
+	dialer := net.Dialer{Timeout: rfc2136Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", r.server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(rfc2136Timeout)
+	}
+	if err = conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	frame := binary.BigEndian.AppendUint16(make([]byte, 0, len(msg)+2), uint16(len(msg))) //nolint:gomnd
+	if _, err = conn.Write(append(frame, msg...)); err != nil {
+		return err
+	}
+	var size [2]byte
+	if _, err = io.ReadFull(conn, size[:]); err != nil {
+		return err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err = io.ReadFull(conn, resp); err != nil {
+		return err
+	}
+	if len(resp) < headerLen || binary.BigEndian.Uint16(resp) != binary.BigEndian.Uint16(msg) {
+		return errors.New("invalid response from the server")
+	}
+	if rcode := int(resp[3] & 0x0f); rcode != 0 { //nolint:gomnd
+		if rcode < len(rcodes) {
+			return fmt.Errorf("the server answered %s", rcodes[rcode])
+		}
+		return fmt.Errorf("the server answered rcode %d", rcode)
+	}
+	return nil
+}
+
+func addressType(addr netip.Addr) uint16 {
+	if addr.Is4() {
+		return typeA
+	}
+	return typeAAAA
+}
+
+// appendName appends the uncompressed wire format of the domain name.
+func appendName(b []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if len(name)+2 > maxNameLen {
+		return nil, fmt.Errorf("domain name %q is too long", name)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > maxLabelLen {
+			return nil, fmt.Errorf("invalid label %q in domain name %q", label, name)
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), nil
+}
+
+func appendRecord(b []byte, rec *record) ([]byte, error) {
+	b, err := appendName(b, rec.name)
+	if err != nil {
+		return nil, err
+	}
+	b = binary.BigEndian.AppendUint16(b, rec.typ)
+	b = binary.BigEndian.AppendUint16(b, rec.class)
+	b = binary.BigEndian.AppendUint32(b, rec.ttl)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(rec.rdata)))
+	return append(b, rec.rdata...), nil
+}
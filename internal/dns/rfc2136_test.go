@@ -0,0 +1,120 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRFC2136Message(t *testing.T) {
+	unsigned, err := newRFC2136("127.0.0.1:53", "ci.example.com", 60, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := newRFC2136("127.0.0.1:53", "ci.example.com", 60, "runner.", "c2VjcmV0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates := []record{{name: fqdn("stage.pool", "ci.example.com"), typ: typeA, class: classIN, ttl: 60, rdata: []byte{10, 0, 0, 1}}}
+	now := time.Unix(1700000000, 0)
+
+	plain, err := unsigned.message(0x1234, now, updates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x12, 0x34, 0x28, 0, 0, 1, 0, 0, 0, 1, 0, 0, // header, opcode update
+		2, 'c', 'i', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0, typeSOA, 0, classIN,
+		5, 's', 't', 'a', 'g', 'e', 4, 'p', 'o', 'o', 'l', 2, 'c', 'i', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+		0, typeA, 0, classIN, 0, 0, 0, 60, 0, 4, 10, 0, 0, 1,
+	}
+	if !bytes.Equal(plain, want) {
+		t.Fatalf("unexpected message\ngot  %v\nwant %v", plain, want)
+	}
+
+	msg, err := signed.message(0x1234, now, updates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binary.BigEndian.Uint16(msg[10:]) != 1 || !bytes.Equal(msg[headerLen:len(plain)], plain[headerLen:]) {
+		t.Fatalf("expected the message followed by the TSIG record, got %v", msg)
+	}
+
+	key := []byte{6, 'r', 'u', 'n', 'n', 'e', 'r', 0}
+	algorithm := []byte{11, 'h', 'm', 'a', 'c', '-', 's', 'h', 'a', '2', '5', '6', 0}
+	timers := []byte{0, 0, 0x65, 0x53, 0xf1, 0x00, 0x01, 0x2c}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(plain)
+	mac.Write(key)
+	mac.Write([]byte{0, classAny, 0, 0, 0, 0})
+	mac.Write(algorithm)
+	mac.Write(timers)
+	mac.Write([]byte{0, 0, 0, 0})
+	sum := mac.Sum(nil)
+
+	var tsig []byte
+	tsig = append(tsig, key...)
+	tsig = append(tsig, 0, typeTSIG, 0, classAny, 0, 0, 0, 0)
+	tsig = binary.BigEndian.AppendUint16(tsig, uint16(len(algorithm)+len(timers)+2+len(sum)+6))
+	tsig = append(tsig, algorithm...)
+	tsig = append(tsig, timers...)
+	tsig = append(tsig, 0, byte(len(sum)))
+	tsig = append(tsig, sum...)
+	tsig = append(tsig, 0x12, 0x34, 0, 0, 0, 0)
+	if !bytes.Equal(msg[len(plain):], tsig) {
+		t.Errorf("unexpected TSIG record\ngot  %v\nwant %v", msg[len(plain):], tsig)
+	}
+}
+
+func TestRFC2136Exchange(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// the server refuses the deletions and accepts the other updates
+	go func() {
+		for {
+			conn, acceptErr := ln.Accept()
+			if acceptErr != nil {
+				return
+			}
+			var size [2]byte
+			if _, readErr := io.ReadFull(conn, size[:]); readErr != nil {
+				conn.Close()
+				continue
+			}
+			msg := make([]byte, binary.BigEndian.Uint16(size[:]))
+			_, _ = io.ReadFull(conn, msg)
+			resp := append([]byte{0, headerLen}, msg[:headerLen]...)
+			resp[5] |= 0x80 // response
+			if bytes.Contains(msg, []byte{0, typeA, 0, classNone}) {
+				resp[5] |= 5 // refused
+			}
+			_, _ = conn.Write(resp)
+			conn.Close()
+		}
+	}()
+
+	r, err := newRFC2136(ln.Addr().String(), "ci.example.com", 60, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	addr := netip.MustParseAddr("10.0.0.1")
+	if err = r.Register(ctx, "stage.pool", addr); err != nil {
+		t.Errorf("unexpected error registering: %s", err)
+	}
+	if err = r.Deregister(ctx, "stage.pool", addr); err == nil || !strings.Contains(err.Error(), "REFUSED") {
+		t.Errorf("expected the deregistration to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// route53Registrar registers the hosts in a route53 hosted zone, with the default AWS credentials.
+type route53Registrar struct {
+	client *route53.Route53
+	zoneID string
+	zone   string
+	ttl    int64
+}
+
+func newRoute53(zoneID, zone string, ttl int) (*route53Registrar, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("dns: failed to create the aws session: %w", err)
+	}
+	return &route53Registrar{client: route53.New(sess), zoneID: zoneID, zone: zone, ttl: int64(ttl)}, nil
+}
+
+func (r *route53Registrar) Register(ctx context.Context, host string, addr netip.Addr) error {
+	return r.change(ctx, route53.ChangeActionUpsert, host, addr)
+}
+
+func (r *route53Registrar) Deregister(ctx context.Context, host string, addr netip.Addr) error {
+	err := r.change(ctx, route53.ChangeActionDelete, host, addr)
+	// route53 rejects the deletion of a record which does not exist, or no longer has the address.
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == route53.ErrCodeInvalidChangeBatch && strings.Contains(aerr.Message(), "not found") {
+		return nil
+	}
+	return err
+}
+
+func (r *route53Registrar) change(ctx context.Context, action, host string, addr netip.Addr) error {
+	name := fqdn(host, r.zone)
+	_, err := r.client.ChangeResourceRecordSetsWithContext(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(r.zoneID),
+		ChangeBatch: &route53.ChangeBatch{
+			Changes: []*route53.Change{{
+				Action: aws.String(action),
+				ResourceRecordSet: &route53.ResourceRecordSet{
+					Name:            aws.String(name),
+					Type:            aws.String(recordType(addr)),
+					TTL:             aws.Int64(r.ttl),
+					ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(addr.String())}},
+				},
+			}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("dns: failed to %s the record of %s in route53: %w", strings.ToLower(action), name, err)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package drivers
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+type accountIDKey struct{}
+
+// WithAccountID returns a context carrying the account of the stage. The instances provisioned
+// with the context are stamped with the account, and the instances of the other accounts are
+// neither handed out nor found with it.
+func WithAccountID(ctx context.Context, accountID string) context.Context {
+	return context.WithValue(ctx, accountIDKey{}, accountID)
+}
+
+func accountID(ctx context.Context) string {
+	id, _ := ctx.Value(accountIDKey{}).(string)
+	return id
+}
+
+// Owns reports whether the account of the context can use the instance.
+func Owns(ctx context.Context, inst *types.Instance) bool {
+	return ownedBy(inst, accountID(ctx))
+}
+
+// ownedBy reports whether the instance can be used by the stages of the account. The instances
+// of no account, the free ones and the ones claimed without an account, can be used by any.
+func ownedBy(inst *types.Instance, account string) bool {
+	return account == "" || inst.AccountID == "" || inst.AccountID == account
+}
@@ -0,0 +1,83 @@
+package drivers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestAccountSegregation(t *testing.T) {
+	const pool = "linux"
+
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	instanceStore := ldb.NewInstanceStore(db)
+	m := New(ctx, instanceStore, &config.EnvConfig{})
+	if err = m.Add(Pool{Name: pool, MaxSize: 4, Driver: failingDriver{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Unix()
+	instances := []*types.Instance{
+		// a free instance left stamped with another account is never handed out
+		{ID: "stale", Pool: pool, State: types.StateCreated, Started: now - 10, AccountID: "b"},
+		{ID: "free", Pool: pool, State: types.StateCreated, Started: now},
+		{ID: "busy-b", Pool: pool, State: types.StateInUse, Stage: "stage-b", Started: now, AccountID: "b"},
+	}
+	for _, inst := range instances {
+		if err = instanceStore.Create(ctx, inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctxA := WithAccountID(ctx, "a")
+	inst, err := m.Provision(ctxA, pool, "runner", &config.EnvConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inst.ID != "free" || inst.AccountID != "a" {
+		t.Fatalf("expected the free instance stamped with the account, got %s of account %q", inst.ID, inst.AccountID)
+	}
+	stored, err := instanceStore.Find(ctx, inst.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.AccountID != "a" {
+		t.Errorf("expected the account to be stored, got %q", stored.AccountID)
+	}
+
+	if _, err = m.GetInstanceByStageID(ctxA, pool, "stage-b"); err == nil {
+		t.Error("expected the instance of another account not to be found by stage")
+	}
+	if _, err = m.Find(ctxA, "busy-b"); err == nil {
+		t.Error("expected the instance of another account not to be found by id")
+	}
+	ctxB := WithAccountID(ctx, "b")
+	if found, findErr := m.GetInstanceByStageID(ctxB, pool, "stage-b"); findErr != nil || found.ID != "busy-b" {
+		t.Errorf("expected the instance of the account to be found, got %v", findErr)
+	}
+	// the requests without an account are not restricted
+	if _, err = m.Find(ctx, "busy-b"); err != nil {
+		t.Errorf("expected the instance to be found without an account, got %v", err)
+	}
+
+	list, err := instanceStore.List(ctx, pool, &types.QueryParams{AccountID: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, listed := range list {
+		if listed.AccountID == "b" {
+			t.Errorf("expected the instances of the other accounts to be filtered out, got %s", listed.ID)
+		}
+	}
+}
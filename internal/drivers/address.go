@@ -0,0 +1,95 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/drone/runner-go/logger"
+)
+
+// SelectAddress returns the address the lite-engine of an instance is reached on, given the IPv4
+// and the IPv6 address the cloud assigned to it. Dual-stack instances fall back to IPv4 in the
+// zones without IPv6, IPv6-only instances have no address until the IPv6 address is assigned.
+func SelectAddress(family types.IPFamily, ipv4, ipv6 string) string {
+	switch family {
+	case types.IPv6:
+		return normalizeAddress(ipv6)
+	case types.DualStack:
+		if ipv6 != "" {
+			return normalizeAddress(ipv6)
+		}
+		return normalizeAddress(ipv4)
+	default:
+		return normalizeAddress(ipv4)
+	}
+}
+
+// normalizeAddress returns the canonical form of the address, so the same IPv6 address is always
+// stored the same way.
+func normalizeAddress(address string) string {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return address
+	}
+	return addr.Unmap().String()
+}
+
+// RefreshAddress looks the addresses of the instance up again with the driver of its pool and
+// stores them if they changed, it returns true if they did. The instances of the drivers which
+// can't look their addresses up are left as is.
+func (m *Manager) RefreshAddress(ctx context.Context, inst *types.Instance) (bool, error) {
+	pool := m.getPool(inst.Pool)
+	if pool == nil {
+		return false, fmt.Errorf("refresh_address: pool name %q not found", inst.Pool)
+	}
+	driver, err := driverFor(pool, inst)
+	if err != nil {
+		return false, fmt.Errorf("refresh_address: %w", err)
+	}
+	changed, err := resolveAddress(ctx, driver, inst)
+	if err != nil || !changed {
+		return false, err
+	}
+	if err = m.instanceStore.Update(ctx, inst); err != nil {
+		return false, fmt.Errorf("refresh_address: failed to update instance store %s of %q pool: %w", inst.ID, inst.Pool, err)
+	}
+	// the record of the stage points at the old address otherwise
+	if inst.Stage != "" {
+		if err = m.RegisterDNS(ctx, inst); err != nil {
+			logger.FromContext(ctx).WithError(err).
+				WithField("instance_id", inst.ID).
+				Warnln("manager: failed to register the new address of the instance in dns")
+		}
+	}
+	return true, nil
+}
+
+// resolveAddress sets the current addresses of the instance, it returns true if they changed. An
+// address the driver can't tell yet is kept.
+func resolveAddress(ctx context.Context, driver Driver, inst *types.Instance) (bool, error) {
+	resolver, ok := driver.(AddressResolver)
+	if !ok {
+		return false, nil
+	}
+	address, privateAddress, err := resolver.ResolveAddress(ctx, inst)
+	if err != nil {
+		return false, fmt.Errorf("could not resolve the address of the instance %s: %w", inst.ID, err)
+	}
+	changed := false
+	if address != "" && address != inst.Address {
+		logger.FromContext(ctx).
+			WithField("instance_id", inst.ID).
+			WithField("old_ip", inst.Address).
+			WithField("ip", address).
+			Infoln("manager: the address of the instance changed")
+		inst.Address = address
+		changed = true
+	}
+	if privateAddress != "" && privateAddress != inst.PrivateAddress {
+		inst.PrivateAddress = privateAddress
+		changed = true
+	}
+	return changed, nil
+}
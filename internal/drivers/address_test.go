@@ -0,0 +1,90 @@
+package drivers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestSelectAddress(t *testing.T) {
+	tests := []struct {
+		family     types.IPFamily
+		ipv4, ipv6 string
+		want       string
+	}{
+		{family: "", ipv4: "10.0.0.1", ipv6: "2600:1f18::1", want: "10.0.0.1"},
+		{family: types.IPv4, ipv4: "10.0.0.1", want: "10.0.0.1"},
+		{family: types.DualStack, ipv4: "10.0.0.1", ipv6: "2600:1f18:0:0::1", want: "2600:1f18::1"},
+		{family: types.DualStack, ipv4: "10.0.0.1", want: "10.0.0.1"}, // the zone has no IPv6 subnet
+		{family: types.IPv6, ipv4: "10.0.0.1", want: ""},
+		{family: types.IPv6, ipv6: "2600:1F18::0001", want: "2600:1f18::1"},
+		{family: types.IPv4, ipv4: "::ffff:10.0.0.1", want: "10.0.0.1"},
+	}
+	for _, test := range tests {
+		if got := SelectAddress(test.family, test.ipv4, test.ipv6); got != test.want {
+			t.Errorf("%q %q %q: want %q, got %q", test.family, test.ipv4, test.ipv6, test.want, got)
+		}
+	}
+}
+
+// wakingDriver starts the instances with the address of start, the addresses resolved afterwards
+// are the ones of resolved.
+type wakingDriver struct {
+	failingDriver
+	start    string
+	resolved [2]string
+}
+
+func (d *wakingDriver) CanHibernate() bool { return true }
+
+func (d *wakingDriver) Start(context.Context, string, string) (string, error) { return d.start, nil }
+
+func (d *wakingDriver) ResolveAddress(context.Context, *types.Instance) (address, privateAddress string, err error) {
+	return d.resolved[0], d.resolved[1], nil
+}
+
+func TestStartInstance_AddressChange(t *testing.T) {
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	instanceStore := ldb.NewInstanceStore(db)
+	err = instanceStore.Create(ctx, &types.Instance{ID: "vm-1", Pool: "linux", State: types.StateInUse,
+		Address: "1.1.1.1", PrivateAddress: "10.0.0.1", IsHibernated: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the public address is not assigned yet when the instance is started
+	driver := &wakingDriver{resolved: [2]string{"", "10.0.0.2"}}
+	m := New(ctx, instanceStore, &config.EnvConfig{})
+	if err = m.Add(Pool{Name: "linux", Driver: driver}); err != nil {
+		t.Fatal(err)
+	}
+
+	inst, err := m.StartInstance(ctx, "linux", "vm-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inst.IsHibernated || inst.Address != "1.1.1.1" || inst.PrivateAddress != "10.0.0.2" {
+		t.Errorf("expected the address to be kept until it is assigned and the private address to be updated, got %+v", inst)
+	}
+
+	// the new public address is assigned
+	driver.resolved = [2]string{"2.2.2.2", "10.0.0.2"}
+	if changed, err := m.RefreshAddress(ctx, inst); err != nil || !changed {
+		t.Fatalf("expected the address to change, got %t, %v", changed, err)
+	}
+	if stored, _ := instanceStore.Find(ctx, "vm-1"); stored.Address != "2.2.2.2" {
+		t.Errorf("expected the new address to be stored, got %q", stored.Address)
+	}
+	if changed, err := m.RefreshAddress(ctx, inst); err != nil || changed {
+		t.Errorf("expected the address not to change again, got %t, %v", changed, err)
+	}
+}
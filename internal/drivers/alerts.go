@@ -0,0 +1,22 @@
+package drivers
+
+import (
+	"context"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/alert"
+)
+
+// SetupAlerts starts the alerter notifying the operators of the provisioning, destroy and rollout
+// failures of the pools, if a notifier is set. The alerts are logged in any case.
+func (m *Manager) SetupAlerts(ctx context.Context, env *config.EnvConfig) {
+	m.alerter = alert.New(&alert.Config{
+		Runner:              m.runnerName,
+		Window:              time.Second * time.Duration(env.Alerts.WindowSecs),
+		MaxPerWindow:        env.Alerts.MaxPerWindow,
+		WebhookURL:          env.Alerts.WebhookURL,
+		PagerDutyRoutingKey: env.Alerts.PagerDutyRoutingKey,
+	})
+	m.alerter.Start(ctx)
+}
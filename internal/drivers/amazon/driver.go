@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/drone-runners/drone-runner-aws/internal/drivers"
@@ -19,10 +20,23 @@ import (
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 	"github.com/cenkalti/backoff/v4"
 	"github.com/dchest/uniuri"
 )
 
+// tags identifying the runner and the pool of an instance.
+const (
+	tagRunner = "drone-runner"
+	tagPool   = "drone-runner-pool"
+)
+
+// nameConstraints are the rules of the Name tag of the instances.
+var nameConstraints = drivers.NameConstraints{MaxLen: 255, Extra: "._"} //nolint:gomnd
+
 // config is a struct that implements drivers.Pool interface
 type config struct {
 	spotInstance     bool
@@ -37,7 +51,11 @@ type config struct {
 
 	rootDir string
 
+	imageMu       sync.RWMutex
 	image         string
+	amiFilter     string   // name pattern of the AMIs, the latest one is the image
+	amiOwners     []string // owners of the AMIs matching the filter
+	imageResolved bool     // the AMI matching the filter was looked up
 	size          string
 	sizeAlt       string
 	user          string
@@ -46,16 +64,21 @@ type config struct {
 	vpc           string
 	groups        []string
 	allocPublicIP bool
+	ipFamily      types.IPFamily
 	volumeType    string
 	volumeSize    int64
 	volumeIops    int64
 	kmsKeyID      string
 	deviceName    string
 	iamProfileArn string
+	iamProfile    string            // the name of the instance profile, if set by name rather than by arn
 	tags          map[string]string // user defined tags
 	hibernate     bool
+	standby       bool
 
 	service *ec2.EC2
+	ssm     ssmiface.SSMAPI // the parameters of the TLS keys of the pools with the ssm key delivery
+	iam     iamiface.IAMAPI // the instance profile is checked with it
 }
 
 func New(opts ...Option) (drivers.Driver, error) {
@@ -78,6 +101,8 @@ func New(opts ...Option) (drivers.Driver, error) {
 		}
 		mySession := session.Must(session.NewSession())
 		p.service = ec2.New(mySession, config)
+		p.ssm = ssm.New(mySession, config)
+		p.iam = iam.New(mySession, config)
 	}
 	return p, nil
 }
@@ -87,15 +112,28 @@ func (p *config) DriverName() string {
 }
 
 func (p *config) InstanceType() string {
-	return p.image
+	return p.currentImage()
 }
 
 func (p *config) RootDir() string {
 	return p.rootDir
 }
 
+func (p *config) CanRunUntrusted() bool {
+	return true
+}
+
 func (p *config) CanHibernate() bool {
-	return p.hibernate
+	return p.hibernate || p.standby
+}
+
+func (p *config) Capabilities() drivers.Capabilities {
+	return drivers.Capabilities{
+		ConsoleLogs: true,
+		Tags:        true,
+		Spot:        p.spotInstance,
+		Zones:       p.availabilityZone != "",
+	}
 }
 
 const (
@@ -115,7 +153,7 @@ func (p *config) Ping(ctx context.Context) error {
 	return err
 }
 
-func lookupCreateSecurityGroupID(ctx context.Context, client *ec2.EC2, vpc string) (string, error) {
+func lookupCreateSecurityGroupID(ctx context.Context, client *ec2.EC2, vpc string, port int64, ipv6 bool) (string, error) {
 	input := &ec2.DescribeSecurityGroupsInput{
 		GroupNames: []*string{aws.String(defaultSecurityGroupName)},
 	}
@@ -139,8 +177,8 @@ func lookupCreateSecurityGroupID(ctx context.Context, client *ec2.EC2, vpc strin
 			IpPermissions: []*ec2.IpPermission{
 				{
 					IpProtocol: aws.String("tcp"),
-					FromPort:   aws.Int64(lehelper.LiteEnginePort),
-					ToPort:     aws.Int64(lehelper.LiteEnginePort),
+					FromPort:   aws.Int64(port),
+					ToPort:     aws.Int64(port),
 					IpRanges: []*ec2.IpRange{
 						{
 							CidrIp: aws.String("0.0.0.0/0"),
@@ -149,6 +187,9 @@ func lookupCreateSecurityGroupID(ctx context.Context, client *ec2.EC2, vpc strin
 				},
 			},
 		}
+		if ipv6 {
+			ingress.IpPermissions[0].Ipv6Ranges = []*ec2.Ipv6Range{{CidrIpv6: aws.String("::/0")}}
+		}
 		_, ingressErr := client.AuthorizeSecurityGroupIngressWithContext(ctx, ingress)
 		if ingressErr != nil {
 			return "", fmt.Errorf("failed to create ingress rules for security group: %s. %s", defaultSecurityGroupName, ingressErr)
@@ -159,7 +200,7 @@ func lookupCreateSecurityGroupID(ctx context.Context, client *ec2.EC2, vpc strin
 }
 
 // lookup Security Group ID and check it has the correct ingress rules
-func checkIngressRules(ctx context.Context, client *ec2.EC2, groupID string) error {
+func checkIngressRules(ctx context.Context, client *ec2.EC2, groupID string, port int64) error {
 	input := &ec2.DescribeSecurityGroupsInput{
 		GroupIds: []*string{aws.String(groupID)},
 	}
@@ -170,13 +211,13 @@ func checkIngressRules(ctx context.Context, client *ec2.EC2, groupID string) err
 	securityGroup := securityGroupResponse.SecurityGroups[0]
 	found := false
 	for _, permission := range securityGroup.IpPermissions {
-		if *permission.IpProtocol == "tcp" && *permission.FromPort == lehelper.LiteEnginePort && *permission.ToPort == lehelper.LiteEnginePort {
+		if *permission.IpProtocol == "tcp" && *permission.FromPort == port && *permission.ToPort == port {
 			found = true
 			break
 		}
 	}
 	if !found {
-		return fmt.Errorf("security group %s does not have the correct ingress rules. There is no rule for port %d", *securityGroup.GroupName, lehelper.LiteEnginePort)
+		return fmt.Errorf("security group %s does not have the correct ingress rules. There is no rule for port %d", *securityGroup.GroupName, port)
 	}
 	return nil
 }
@@ -185,15 +226,23 @@ func checkIngressRules(ctx context.Context, client *ec2.EC2, groupID string) err
 func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (instance *types.Instance, err error) {
 	client := p.service
 	startTime := time.Now()
+	// the instance records the image it was created from, the image of the pool might be resolved again meanwhile
+	image, err := p.imageToCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
 	logr := logger.FromContext(ctx).
 		WithField("driver", types.Amazon).
-		WithField("ami", p.InstanceType()).
+		WithField("ami", image).
 		WithField("pool", opts.PoolName).
 		WithField("region", p.region).
-		WithField("image", p.image).
+		WithField("image", image).
 		WithField("size", p.size).
 		WithField("hibernate", p.CanHibernate())
 	var name = fmt.Sprintf("%s-%s-%s", opts.RunnerName, opts.PoolName, uniuri.NewLen(8)) //nolint:gomnd
+	if opts.Name != "" {
+		name = nameConstraints.Sanitize(opts.Name)
+	}
 	var tags = map[string]string{
 		"Name": name,
 	}
@@ -201,6 +250,9 @@ func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 	for k, v := range p.tags {
 		tags[k] = v
 	}
+	// the janitor finds the leaked instances of the pool by these tags
+	tags[tagRunner] = opts.RunnerName
+	tags[tagPool] = opts.PoolName
 	if p.vpc == "" {
 		logr.Traceln("amazon: using default vpc, checking security groups")
 	} else {
@@ -210,14 +262,19 @@ func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 	if p.groups == nil || len(p.groups) == 0 {
 		logr.Warnf("aws: no security group specified assuming '%s'", defaultSecurityGroupName)
 		// lookup/create group
-		returnedGroupID, lookupErr := lookupCreateSecurityGroupID(ctx, client, p.vpc)
+		returnedGroupID, lookupErr := lookupCreateSecurityGroupID(ctx, client, p.vpc, int64(opts.LiteEnginePort), p.ipv6())
 		if lookupErr != nil {
 			return nil, lookupErr
 		}
 		p.groups = append(p.groups, returnedGroupID)
 	}
+	groups := p.groups
+	// untrusted instances are placed in the egress restricted security groups of the profile
+	if opts.Untrusted != nil && len(opts.Untrusted.SecurityGroups) > 0 {
+		groups = opts.Untrusted.SecurityGroups
+	}
 	// check the security group ingress rules
-	rulesErr := checkIngressRules(ctx, client, p.groups[0])
+	rulesErr := checkIngressRules(ctx, client, groups[0], int64(opts.LiteEnginePort))
 	if rulesErr != nil {
 		return nil, rulesErr
 	}
@@ -225,14 +282,26 @@ func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 	logr.Traceln("amazon: provisioning VM")
 
 	var iamProfile *ec2.IamInstanceProfileSpecification
-	if p.iamProfileArn != "" {
+	if p.iamProfileArn != "" && opts.Untrusted == nil {
 		iamProfile = &ec2.IamInstanceProfileSpecification{
 			Arn: aws.String(p.iamProfileArn),
 		}
+	} else if p.iamProfile != "" && opts.Untrusted == nil {
+		iamProfile = &ec2.IamInstanceProfileSpecification{
+			Name: aws.String(p.iamProfile),
+		}
+	}
+
+	// instances in standby are stopped and started again, the lite-engine must come back on every boot.
+	userdataOpts := *opts
+	userdataOpts.Persistent = opts.Persistent || p.standby
+	userdata, err := lehelper.GenerateUserdata(p.userData, &userdataOpts)
+	if err != nil {
+		return nil, err
 	}
 
 	in := &ec2.RunInstancesInput{
-		ImageId:            aws.String(p.image),
+		ImageId:            aws.String(image),
 		InstanceType:       aws.String(p.size),
 		Placement:          &ec2.Placement{AvailabilityZone: aws.String(p.availabilityZone)},
 		MinCount:           aws.Int64(1),
@@ -240,15 +309,15 @@ func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 		IamInstanceProfile: iamProfile,
 		UserData: aws.String(
 			base64.StdEncoding.EncodeToString(
-				[]byte(lehelper.GenerateUserdata(p.userData, opts)),
+				[]byte(userdata),
 			),
 		),
 		NetworkInterfaces: []*ec2.InstanceNetworkInterfaceSpecification{
 			{
-				AssociatePublicIpAddress: aws.Bool(p.allocPublicIP),
+				AssociatePublicIpAddress: aws.Bool(p.publicIPv4()),
 				DeviceIndex:              aws.Int64(0),
 				SubnetId:                 aws.String(p.subnet),
-				Groups:                   aws.StringSlice(p.groups),
+				Groups:                   aws.StringSlice(groups),
 			},
 		},
 		TagSpecifications: []*ec2.TagSpecification{
@@ -271,6 +340,19 @@ func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 	if p.keyPairName != "" {
 		in.KeyName = aws.String(p.keyPairName)
 	}
+	if p.ipv6() {
+		in.NetworkInterfaces[0].Ipv6AddressCount = aws.Int64(1)
+	}
+
+	// cloud-init reads the user data from the instance metadata service, untrusted instances keep it
+	// with session tokens required and a hop limit of 1, so it's not reachable from the build containers.
+	if opts.Untrusted != nil {
+		in.MetadataOptions = &ec2.InstanceMetadataOptionsRequest{
+			HttpEndpoint:            aws.String(ec2.InstanceMetadataEndpointStateEnabled),
+			HttpTokens:              aws.String(ec2.HttpTokensStateRequired),
+			HttpPutResponseHopLimit: aws.Int64(1),
+		}
+	}
 
 	if p.volumeType == "io1" {
 		for _, blockDeviceMapping := range in.BlockDeviceMappings {
@@ -282,7 +364,7 @@ func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 		}
 	}
 
-	if p.CanHibernate() {
+	if p.hibernate {
 		for _, blockDeviceMapping := range in.BlockDeviceMappings {
 			blockDeviceMapping.Ebs.Encrypted = aws.Bool(true)
 			if p.kmsKeyID != "" {
@@ -326,25 +408,27 @@ func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 	launchTime := p.getLaunchTime(amazonInstance)
 
 	instance = &types.Instance{
-		ID:           instanceID,
-		Name:         instanceID,
-		Provider:     types.Amazon, // this is driver, though its the old legacy name of provider
-		State:        types.StateCreated,
-		Pool:         opts.PoolName,
-		Image:        p.image,
-		Zone:         p.availabilityZone,
-		Region:       p.region,
-		Size:         p.size,
-		Platform:     opts.Platform,
-		Address:      instanceIP,
-		CACert:       opts.CACert,
-		CAKey:        opts.CAKey,
-		TLSCert:      opts.TLSCert,
-		TLSKey:       opts.TLSKey,
-		Started:      launchTime.Unix(),
-		Updated:      time.Now().Unix(),
-		IsHibernated: false,
-		Port:         lehelper.LiteEnginePort,
+		ID:             instanceID,
+		Name:           instanceID,
+		ProviderID:     instanceID,
+		Provider:       types.Amazon, // this is driver, though its the old legacy name of provider
+		State:          types.StateCreated,
+		Pool:           opts.PoolName,
+		Image:          image,
+		Zone:           p.availabilityZone,
+		Region:         p.region,
+		Size:           p.size,
+		Platform:       opts.Platform,
+		Address:        instanceIP,
+		CACert:         opts.CACert,
+		CAKey:          opts.CAKey,
+		TLSCert:        opts.TLSCert,
+		TLSKey:         opts.TLSKey,
+		Started:        launchTime.Unix(),
+		Updated:        time.Now().Unix(),
+		IsHibernated:   false,
+		Port:           int64(opts.LiteEnginePort),
+		PrivateAddress: aws.StringValue(amazonInstance.PrivateIpAddress),
 	}
 	logr.
 		WithField("ip", instanceIP).
@@ -386,6 +470,102 @@ func (p *config) Destroy(ctx context.Context, instances []*types.Instance) (err
 	return nil
 }
 
+// CleanupHosts terminates the instances tagged with the runner and the pool which are not live instances.
+func (p *config) CleanupHosts(ctx context.Context, runnerName, poolName string, live []*types.Instance) error {
+	liveSet := drivers.LiveSet(live)
+	in := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("tag:" + tagRunner), Values: aws.StringSlice([]string{runnerName})},
+			{Name: aws.String("tag:" + tagPool), Values: aws.StringSlice([]string{poolName})},
+			{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{
+				ec2.InstanceStateNamePending, ec2.InstanceStateNameRunning, ec2.InstanceStateNameStopping, ec2.InstanceStateNameStopped,
+			})},
+		},
+	}
+
+	var leaked []*string
+	err := p.service.DescribeInstancesPagesWithContext(ctx, in, func(out *ec2.DescribeInstancesOutput, _ bool) bool {
+		for _, reservation := range out.Reservations {
+			for _, inst := range reservation.Instances {
+				if drivers.IsLeaked(aws.StringValue(inst.InstanceId), p.getLaunchTime(inst), liveSet) {
+					leaked = append(leaked, inst.InstanceId)
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list the instances of the pool: %w", err)
+	}
+	if len(leaked) == 0 {
+		return nil
+	}
+
+	logr := logger.FromContext(ctx).
+		WithField("driver", types.Amazon).
+		WithField("pool", poolName).
+		WithField("id", aws.StringValueSlice(leaked))
+	if _, err = p.service.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{InstanceIds: leaked}); err != nil {
+		return fmt.Errorf("failed to terminate the leaked instances: %w", err)
+	}
+	logr.Infoln("amazon: terminated leaked instances")
+	return nil
+}
+
+// Inventory returns the instances tagged with the runner and the pool, see drivers.Inventory.
+func (p *config) Inventory(ctx context.Context, opts *types.InstanceCreateOpts) ([]*types.Instance, error) {
+	in := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("tag:" + tagRunner), Values: aws.StringSlice([]string{opts.RunnerName})},
+			{Name: aws.String("tag:" + tagPool), Values: aws.StringSlice([]string{opts.PoolName})},
+			{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{
+				ec2.InstanceStateNamePending, ec2.InstanceStateNameRunning, ec2.InstanceStateNameStopping, ec2.InstanceStateNameStopped,
+			})},
+		},
+	}
+
+	var instances []*types.Instance
+	err := p.service.DescribeInstancesPagesWithContext(ctx, in, func(out *ec2.DescribeInstancesOutput, _ bool) bool {
+		for _, reservation := range out.Reservations {
+			for _, inst := range reservation.Instances {
+				instanceID := aws.StringValue(inst.InstanceId)
+				state := ""
+				if inst.State != nil {
+					state = aws.StringValue(inst.State.Name)
+				}
+				zone := p.availabilityZone
+				if inst.Placement != nil {
+					zone = aws.StringValue(inst.Placement.AvailabilityZone)
+				}
+				instances = append(instances, &types.Instance{
+					ID:             instanceID,
+					Name:           instanceID,
+					ProviderID:     instanceID,
+					Provider:       types.Amazon,
+					State:          types.StateCreated,
+					Pool:           opts.PoolName,
+					Image:          aws.StringValue(inst.ImageId),
+					Zone:           zone,
+					Region:         p.region,
+					Size:           aws.StringValue(inst.InstanceType),
+					Platform:       opts.Platform,
+					Address:        p.getIP(inst),
+					Started:        p.getLaunchTime(inst).Unix(),
+					Updated:        time.Now().Unix(),
+					IsHibernated:   state == ec2.InstanceStateNameStopping || state == ec2.InstanceStateNameStopped,
+					Port:           int64(opts.LiteEnginePort),
+					PrivateAddress: aws.StringValue(inst.PrivateIpAddress),
+				})
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the instances of the pool: %w", err)
+	}
+	return instances, nil
+}
+
 func (p *config) Logs(ctx context.Context, instanceID string) (string, error) {
 	client := p.service
 
@@ -424,9 +604,10 @@ func (p *config) Hibernate(ctx context.Context, instanceID, poolName string) err
 		WithField("instanceID", instanceID)
 
 	client := p.service
+	// standby instances are stopped, they boot from the disk on start.
 	_, err := client.StopInstancesWithContext(ctx, &ec2.StopInstancesInput{
 		InstanceIds: []*string{aws.String(instanceID)},
-		Hibernate:   aws.Bool(true),
+		Hibernate:   aws.Bool(p.hibernate),
 	})
 	if err != nil {
 		logr.WithError(err).
@@ -499,18 +680,82 @@ func (p *config) Start(ctx context.Context, instanceID, poolName string) (string
 	return p.getIP(awsInstance), nil
 }
 
+// ResolveAddress returns the current addresses of the instance, the public address of an instance
+// changes when it is started again.
+func (p *config) ResolveAddress(ctx context.Context, instance *types.Instance) (address, privateAddress string, err error) {
+	amazonInstance, err := p.getInstance(ctx, instance.ID)
+	if err != nil {
+		return "", "", err
+	}
+	return p.getIP(amazonInstance), aws.StringValue(amazonInstance.PrivateIpAddress), nil
+}
+
+// Resize stops the instance, changes its instance type and starts it again. The instance is stopped,
+// not hibernated: the type of a hibernated instance can't be changed.
+func (p *config) Resize(ctx context.Context, instance *types.Instance, size string) (string, error) {
+	client := p.service
+
+	logr := logger.FromContext(ctx).
+		WithField("driver", types.Amazon).
+		WithField("pool", instance.Pool).
+		WithField("instanceID", instance.ID).
+		WithField("size", size)
+
+	ids := []*string{aws.String(instance.ID)}
+	if _, err := client.StopInstancesWithContext(ctx, &ec2.StopInstancesInput{InstanceIds: ids}); err != nil {
+		logr.WithError(err).Errorln("aws: failed to stop the VM")
+		return "", err
+	}
+	if err := client.WaitUntilInstanceStoppedWithContext(ctx, &ec2.DescribeInstancesInput{InstanceIds: ids}); err != nil {
+		logr.WithError(err).Errorln("aws: the VM failed to stop")
+		return "", err
+	}
+	_, err := client.ModifyInstanceAttributeWithContext(ctx, &ec2.ModifyInstanceAttributeInput{
+		InstanceId:   aws.String(instance.ID),
+		InstanceType: &ec2.AttributeValue{Value: aws.String(size)},
+	})
+	if err != nil {
+		logr.WithError(err).Errorln("aws: failed to change the instance type of the VM")
+		return "", err
+	}
+	logr.Traceln("amazon: VM resized")
+	return p.Start(ctx, instance.ID, instance.Pool)
+}
+
 func (p *config) getIP(amazonInstance *ec2.Instance) string {
-	if p.allocPublicIP {
-		if amazonInstance.PublicIpAddress == nil {
-			return ""
-		}
-		return *amazonInstance.PublicIpAddress
+	ipv4 := aws.StringValue(amazonInstance.PrivateIpAddress)
+	if p.publicIPv4() {
+		ipv4 = aws.StringValue(amazonInstance.PublicIpAddress)
 	}
+	return drivers.SelectAddress(p.ipFamily, ipv4, ipv6Address(amazonInstance))
+}
 
-	if amazonInstance.PrivateIpAddress == nil {
-		return ""
+// ipv6Address returns the IPv6 address of the primary network interface of the instance.
+func ipv6Address(amazonInstance *ec2.Instance) string {
+	if amazonInstance.Ipv6Address != nil {
+		return *amazonInstance.Ipv6Address
+	}
+	for _, iface := range amazonInstance.NetworkInterfaces {
+		if iface.Attachment == nil || aws.Int64Value(iface.Attachment.DeviceIndex) != 0 {
+			continue
+		}
+		for _, addr := range iface.Ipv6Addresses {
+			if addr.Ipv6Address != nil {
+				return *addr.Ipv6Address
+			}
+		}
 	}
-	return *amazonInstance.PrivateIpAddress
+	return ""
+}
+
+// ipv6 reports whether the instances get an IPv6 address.
+func (p *config) ipv6() bool {
+	return p.ipFamily == types.DualStack || p.ipFamily == types.IPv6
+}
+
+// publicIPv4 reports whether the instances get a public IPv4 address, IPv6 instances never do.
+func (p *config) publicIPv4() bool {
+	return p.allocPublicIP && p.ipFamily != types.IPv6
 }
 
 func (p *config) getState(amazonInstance *ec2.Instance) string {
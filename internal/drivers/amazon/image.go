@@ -0,0 +1,77 @@
+package amazon
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// currentImage returns the AMI the new instances are created from.
+func (p *config) currentImage() string {
+	p.imageMu.RLock()
+	defer p.imageMu.RUnlock()
+	return p.image
+}
+
+// ResolveImage looks up the latest available AMI matching the name pattern of the pool, the new
+// instances are created from it. The fixed AMI of a pool without a filter is returned as is.
+func (p *config) ResolveImage(ctx context.Context) (string, error) {
+	if p.amiFilter == "" {
+		return p.currentImage(), nil
+	}
+	in := &ec2.DescribeImagesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("name"), Values: aws.StringSlice([]string{p.amiFilter})},
+			{Name: aws.String("state"), Values: aws.StringSlice([]string{ec2.ImageStateAvailable})},
+		},
+	}
+	if len(p.amiOwners) > 0 {
+		in.Owners = aws.StringSlice(p.amiOwners)
+	}
+	out, err := p.service.DescribeImagesWithContext(ctx, in)
+	if err != nil {
+		return "", fmt.Errorf("amazon: failed to look up the AMIs matching %q: %w", p.amiFilter, err)
+	}
+	image := latestImage(out.Images)
+	if image == "" {
+		return "", fmt.Errorf("amazon: no available AMI matches %q", p.amiFilter)
+	}
+
+	p.imageMu.Lock()
+	defer p.imageMu.Unlock()
+	p.image = image
+	p.imageResolved = true
+	return image, nil
+}
+
+// imageToCreate returns the image of a new instance, the image is looked up first if it was
+// never resolved, e.g. the lookup failed when the pool was built.
+func (p *config) imageToCreate(ctx context.Context) (string, error) {
+	p.imageMu.RLock()
+	image, resolved := p.image, p.imageResolved
+	p.imageMu.RUnlock()
+	if p.amiFilter == "" || resolved {
+		return image, nil
+	}
+	return p.ResolveImage(ctx)
+}
+
+// latestImage returns the id of the most recently created image, empty if there is none.
+func latestImage(images []*ec2.Image) string {
+	var latest *ec2.Image
+	for _, image := range images {
+		if image.ImageId == nil || image.CreationDate == nil {
+			continue
+		}
+		// the creation dates are ISO 8601 timestamps in UTC, they sort lexically
+		if latest == nil || *image.CreationDate > *latest.CreationDate {
+			latest = image
+		}
+	}
+	if latest == nil {
+		return ""
+	}
+	return *latest.ImageId
+}
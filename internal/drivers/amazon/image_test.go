@@ -0,0 +1,23 @@
+package amazon
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestLatestImage(t *testing.T) {
+	images := []*ec2.Image{
+		{ImageId: aws.String("ami-old"), CreationDate: aws.String("2023-01-10T08:00:00.000Z")},
+		{ImageId: aws.String("ami-new"), CreationDate: aws.String("2023-06-02T08:00:00.000Z")},
+		{ImageId: aws.String("ami-undated")},
+		{ImageId: aws.String("ami-mid"), CreationDate: aws.String("2023-03-15T08:00:00.000Z")},
+	}
+	if got := latestImage(images); got != "ami-new" {
+		t.Errorf("expected the latest image ami-new, got %s", got)
+	}
+	if got := latestImage(nil); got != "" {
+		t.Errorf("expected no image, got %s", got)
+	}
+}
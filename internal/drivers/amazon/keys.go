@@ -0,0 +1,43 @@
+package amazon
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+
+	"github.com/drone-runners/drone-runner-aws/internal/keydelivery"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// PublishKey stores the TLS key of a new instance in a SecureString parameter encrypted with the
+// default key of SSM, the instance reads it with its instance profile.
+func (p *config) PublishKey(ctx context.Context, ref string, key []byte) (string, error) {
+	if p.ssm == nil {
+		return "", errors.New("amazon: the ssm client is not set up")
+	}
+	_, err := p.ssm.PutParameterWithContext(ctx, &ssm.PutParameterInput{
+		Name:  aws.String(keydelivery.SSMParameter(ref)),
+		Type:  aws.String(ssm.ParameterTypeSecureString),
+		Value: aws.String(base64.StdEncoding.EncodeToString(key)),
+	})
+	if err != nil {
+		return "", err
+	}
+	return keydelivery.SSMFetch(p.region, ref), nil
+}
+
+// RevokeKey deletes the parameter of the key, a parameter already deleted is not an error.
+func (p *config) RevokeKey(ctx context.Context, ref string) error {
+	if p.ssm == nil {
+		return errors.New("amazon: the ssm client is not set up")
+	}
+	_, err := p.ssm.DeleteParameterWithContext(ctx, &ssm.DeleteParameterInput{Name: aws.String(keydelivery.SSMParameter(ref))})
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == ssm.ErrCodeParameterNotFound {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package amazon
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// maxStatusIDs is the number of instances described at once.
+	maxStatusIDs = 100
+	// spotInterruptionNotice is the time between the interruption notice of a spot instance and its interruption.
+	spotInterruptionNotice = 2 * time.Minute
+)
+
+// eventKinds maps the codes of the scheduled events of EC2 to the kinds of the events.
+var eventKinds = map[string]string{
+	ec2.EventCodeInstanceReboot:     drivers.EventReboot,
+	ec2.EventCodeSystemReboot:       drivers.EventReboot,
+	ec2.EventCodeSystemMaintenance:  drivers.EventMaintenance,
+	ec2.EventCodeInstanceRetirement: drivers.EventStop,
+	ec2.EventCodeInstanceStop:       drivers.EventStop,
+}
+
+// ScheduledEvents returns the scheduled events of the instances, e.g. the maintenance or the
+// retirement of their hosts, and the interruption notices of the spot instances.
+func (p *config) ScheduledEvents(ctx context.Context, instances []*types.Instance) ([]drivers.ScheduledEvent, error) {
+	var events []drivers.ScheduledEvent
+	for start := 0; start < len(instances); start += maxStatusIDs {
+		end := start + maxStatusIDs
+		if end > len(instances) {
+			end = len(instances)
+		}
+		ids := make([]*string, 0, end-start)
+		for _, inst := range instances[start:end] {
+			ids = append(ids, aws.String(inst.ID))
+		}
+
+		in := &ec2.DescribeInstanceStatusInput{InstanceIds: ids, IncludeAllInstances: aws.Bool(true)}
+		err := p.service.DescribeInstanceStatusPagesWithContext(ctx, in, func(out *ec2.DescribeInstanceStatusOutput, _ bool) bool {
+			for _, status := range out.InstanceStatuses {
+				for _, e := range status.Events {
+					if event, ok := scheduledEvent(aws.StringValue(status.InstanceId), e); ok {
+						events = append(events, event)
+					}
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe the status of the instances: %w", err)
+		}
+
+		if !p.spotInstance {
+			continue
+		}
+		spot := &ec2.DescribeSpotInstanceRequestsInput{
+			Filters: []*ec2.Filter{{Name: aws.String("instance-id"), Values: ids}},
+		}
+		err = p.service.DescribeSpotInstanceRequestsPagesWithContext(ctx, spot, func(out *ec2.DescribeSpotInstanceRequestsOutput, _ bool) bool {
+			for _, req := range out.SpotInstanceRequests {
+				if event, ok := spotInterruption(req); ok {
+					events = append(events, event)
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe the spot requests of the instances: %w", err)
+		}
+	}
+	return events, nil
+}
+
+// scheduledEvent returns the event of the instance, false if the event is over or not known.
+func scheduledEvent(instanceID string, e *ec2.InstanceStatusEvent) (drivers.ScheduledEvent, bool) {
+	kind, ok := eventKinds[aws.StringValue(e.Code)]
+	description := aws.StringValue(e.Description)
+	// the past events are kept with their description prefixed
+	if !ok || strings.HasPrefix(description, "[Completed]") || strings.HasPrefix(description, "[Canceled]") {
+		return drivers.ScheduledEvent{}, false
+	}
+	return drivers.ScheduledEvent{
+		InstanceID:  instanceID,
+		Kind:        kind,
+		Description: fmt.Sprintf("%s, %s", aws.StringValue(e.Code), description),
+		NotBefore:   aws.TimeValue(e.NotBefore),
+	}, true
+}
+
+// spotInterruption returns the interruption of the spot instance of the request, false if the
+// instance is not marked for an interruption.
+func spotInterruption(req *ec2.SpotInstanceRequest) (drivers.ScheduledEvent, bool) {
+	if req.Status == nil {
+		return drivers.ScheduledEvent{}, false
+	}
+	switch code := aws.StringValue(req.Status.Code); code {
+	case "marked-for-termination", "marked-for-stop", "marked-for-hibernation":
+		e := drivers.ScheduledEvent{
+			InstanceID:  aws.StringValue(req.InstanceId),
+			Kind:        drivers.EventInterruption,
+			Description: fmt.Sprintf("spot instance %s", code),
+		}
+		if req.Status.UpdateTime != nil {
+			e.NotBefore = req.Status.UpdateTime.Add(spotInterruptionNotice)
+		}
+		return e, true
+	default:
+		return drivers.ScheduledEvent{}, false
+	}
+}
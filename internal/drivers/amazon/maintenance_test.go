@@ -0,0 +1,57 @@
+package amazon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestScheduledEvent(t *testing.T) {
+	notBefore := time.Date(2026, 10, 20, 3, 0, 0, 0, time.UTC)
+	tests := []struct {
+		code, description string
+		kind              string
+		ok                bool
+	}{
+		{code: ec2.EventCodeSystemMaintenance, description: "scheduled maintenance", kind: drivers.EventMaintenance, ok: true},
+		{code: ec2.EventCodeInstanceRetirement, description: "retiring", kind: drivers.EventStop, ok: true},
+		{code: ec2.EventCodeSystemReboot, description: "[Completed] scheduled reboot", ok: false},
+		{code: ec2.EventCodeInstanceStop, description: "[Canceled] scheduled stop", ok: false},
+		{code: "unknown", description: "", ok: false},
+	}
+	for _, test := range tests {
+		e, ok := scheduledEvent("i-1", &ec2.InstanceStatusEvent{
+			Code:        aws.String(test.code),
+			Description: aws.String(test.description),
+			NotBefore:   aws.Time(notBefore),
+		})
+		if ok != test.ok || e.Kind != test.kind {
+			t.Errorf("want the event %q of %q to be %t with kind %q, got %t with %+v", test.code, test.description, test.ok, test.kind, ok, e)
+		}
+		if ok && (e.InstanceID != "i-1" || !e.NotBefore.Equal(notBefore)) {
+			t.Errorf("unexpected event %+v", e)
+		}
+	}
+}
+
+func TestSpotInterruption(t *testing.T) {
+	updated := time.Date(2026, 10, 20, 3, 0, 0, 0, time.UTC)
+	e, ok := spotInterruption(&ec2.SpotInstanceRequest{
+		InstanceId: aws.String("i-1"),
+		Status:     &ec2.SpotInstanceStatus{Code: aws.String("marked-for-termination"), UpdateTime: aws.Time(updated)},
+	})
+	if !ok || e.Kind != drivers.EventInterruption || !e.NotBefore.Equal(updated.Add(2*time.Minute)) {
+		t.Errorf("expected an interruption two minutes after the notice, got %t with %+v", ok, e)
+	}
+
+	if _, ok = spotInterruption(&ec2.SpotInstanceRequest{
+		InstanceId: aws.String("i-2"),
+		Status:     &ec2.SpotInstanceStatus{Code: aws.String("fulfilled")},
+	}); ok {
+		t.Error("expected no interruption of the fulfilled request")
+	}
+}
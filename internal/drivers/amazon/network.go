@@ -0,0 +1,205 @@
+package amazon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// ephemeralPort stands for the ports the runner receives the responses of the lite-engine on,
+// the network ACLs are stateless and must allow them on the way out.
+const ephemeralPort = 49152
+
+// DiagnoseNetwork checks the security groups, the route table and the network ACL of the subnet of
+// the instance let the runner reach the lite-engine port.
+func (p *config) DiagnoseNetwork(ctx context.Context, instance *types.Instance, source net.IP) ([]string, error) {
+	client := p.service
+	awsInstance, err := p.getInstance(ctx, instance.ID)
+	if err != nil {
+		return nil, err
+	}
+	port := instance.Port
+	subnetID := aws.StringValue(awsInstance.SubnetId)
+	vpcID := aws.StringValue(awsInstance.VpcId)
+	var findings []string
+
+	var groupIDs []*string
+	for _, group := range awsInstance.SecurityGroups {
+		groupIDs = append(groupIDs, group.GroupId)
+	}
+	groups, err := client.DescribeSecurityGroupsWithContext(ctx, &ec2.DescribeSecurityGroupsInput{GroupIds: groupIDs})
+	if err != nil {
+		return nil, fmt.Errorf("could not describe the security groups: %w", err)
+	}
+	var permissions []*ec2.IpPermission
+	for _, group := range groups.SecurityGroups {
+		permissions = append(permissions, group.IpPermissions...)
+	}
+	if !securityGroupsAllow(permissions, port, source) {
+		findings = append(findings, fmt.Sprintf("security group: none of the security groups %v has an inbound rule allowing tcp/%d from %s",
+			aws.StringValueSlice(groupIDs), port, describeSource(source)))
+	}
+
+	tables, err := client.DescribeRouteTablesWithContext(ctx, &ec2.DescribeRouteTablesInput{
+		Filters: []*ec2.Filter{{Name: aws.String("association.subnet-id"), Values: []*string{aws.String(subnetID)}}},
+	})
+	if err == nil && len(tables.RouteTables) == 0 {
+		// the subnets without an explicit association use the main route table of the vpc.
+		tables, err = client.DescribeRouteTablesWithContext(ctx, &ec2.DescribeRouteTablesInput{
+			Filters: []*ec2.Filter{
+				{Name: aws.String("vpc-id"), Values: []*string{aws.String(vpcID)}},
+				{Name: aws.String("association.main"), Values: []*string{aws.String("true")}},
+			},
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not describe the route tables: %w", err)
+	}
+	if source != nil && len(tables.RouteTables) > 0 {
+		table := tables.RouteTables[0]
+		switch route := routeFor(table.Routes, source); {
+		case route == nil:
+			findings = append(findings, fmt.Sprintf("route table: %s of subnet %s has no route back to %s, a vpc peering or transit gateway route is missing",
+				aws.StringValue(table.RouteTableId), subnetID, source))
+		case aws.StringValue(route.State) == ec2.RouteStateBlackhole:
+			findings = append(findings, fmt.Sprintf("route table: the route to %s%s in %s is a blackhole, its target was deleted",
+				aws.StringValue(route.DestinationCidrBlock), aws.StringValue(route.DestinationIpv6CidrBlock), aws.StringValue(table.RouteTableId)))
+		}
+	}
+
+	acls, err := client.DescribeNetworkAclsWithContext(ctx, &ec2.DescribeNetworkAclsInput{
+		Filters: []*ec2.Filter{{Name: aws.String("association.subnet-id"), Values: []*string{aws.String(subnetID)}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not describe the network acls: %w", err)
+	}
+	if len(acls.NetworkAcls) > 0 {
+		acl := acls.NetworkAcls[0]
+		if !networkACLAllows(acl.Entries, false, port, source) {
+			findings = append(findings, fmt.Sprintf("network acl: %s denies the inbound tcp/%d from %s",
+				aws.StringValue(acl.NetworkAclId), port, describeSource(source)))
+		}
+		if !networkACLAllows(acl.Entries, true, ephemeralPort, source) {
+			findings = append(findings, fmt.Sprintf("network acl: %s denies the outbound responses to the ephemeral ports of %s",
+				aws.StringValue(acl.NetworkAclId), describeSource(source)))
+		}
+	}
+	return findings, nil
+}
+
+func describeSource(source net.IP) string {
+	if source == nil {
+		return "anywhere"
+	}
+	return source.String()
+}
+
+// securityGroupsAllow reports whether a rule allows tcp on the port from the source, from any
+// address if the source is not known. Rules referencing other security groups or prefix lists
+// can't be checked against an address and are assumed to allow it.
+func securityGroupsAllow(permissions []*ec2.IpPermission, port int64, source net.IP) bool {
+	for _, permission := range permissions {
+		if !protocolAllows(aws.StringValue(permission.IpProtocol), permission.FromPort, permission.ToPort, port) {
+			continue
+		}
+		if len(permission.UserIdGroupPairs) > 0 || len(permission.PrefixListIds) > 0 {
+			return true
+		}
+		for _, r := range permission.IpRanges {
+			if cidrContains(aws.StringValue(r.CidrIp), source) {
+				return true
+			}
+		}
+		for _, r := range permission.Ipv6Ranges {
+			if cidrContains(aws.StringValue(r.CidrIpv6), source) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// routeFor returns the most specific route to the source.
+func routeFor(routes []*ec2.Route, source net.IP) *ec2.Route {
+	var best *ec2.Route
+	bestBits := -1
+	for _, route := range routes {
+		cidr := aws.StringValue(route.DestinationCidrBlock)
+		if cidr == "" {
+			cidr = aws.StringValue(route.DestinationIpv6CidrBlock)
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil || !network.Contains(source) {
+			continue
+		}
+		if bits, _ := network.Mask.Size(); bits > bestBits {
+			best, bestBits = route, bits
+		}
+	}
+	return best
+}
+
+// networkACLAllows evaluates the rules of the direction in order, the first rule matching the traffic decides.
+func networkACLAllows(entries []*ec2.NetworkAclEntry, egress bool, port int64, source net.IP) bool {
+	var rules []*ec2.NetworkAclEntry
+	for _, entry := range entries {
+		if aws.BoolValue(entry.Egress) == egress {
+			rules = append(rules, entry)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return aws.Int64Value(rules[i].RuleNumber) < aws.Int64Value(rules[j].RuleNumber)
+	})
+	for _, rule := range rules {
+		cidr := aws.StringValue(rule.CidrBlock)
+		if cidr == "" {
+			cidr = aws.StringValue(rule.Ipv6CidrBlock)
+		}
+		if !cidrContains(cidr, source) {
+			continue
+		}
+		var from, to *int64
+		if rule.PortRange != nil {
+			from, to = rule.PortRange.From, rule.PortRange.To
+		}
+		if !protocolAllows(aws.StringValue(rule.Protocol), from, to, port) {
+			continue
+		}
+		return aws.StringValue(rule.RuleAction) == ec2.RuleActionAllow
+	}
+	return false
+}
+
+// protocolAllows reports whether the protocol and the port range of a rule cover tcp on the port.
+func protocolAllows(protocol string, from, to *int64, port int64) bool {
+	switch protocol {
+	case "-1", "all":
+		return true
+	case "tcp", "6":
+	default:
+		return false
+	}
+	if from == nil || to == nil || aws.Int64Value(from) == -1 {
+		return true
+	}
+	return aws.Int64Value(from) <= port && port <= aws.Int64Value(to)
+}
+
+// cidrContains reports whether the block contains the source, or is open to any address if the source is not known.
+func cidrContains(cidr string, source net.IP) bool {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	if source == nil {
+		ones, _ := network.Mask.Size()
+		return ones == 0
+	}
+	return network.Contains(source)
+}
@@ -0,0 +1,67 @@
+package amazon
+
+import (
+	"net"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestSecurityGroupsAllow(t *testing.T) {
+	source := net.ParseIP("10.1.0.5")
+	permissions := []*ec2.IpPermission{
+		{IpProtocol: aws.String("tcp"), FromPort: aws.Int64(22), ToPort: aws.Int64(22), IpRanges: []*ec2.IpRange{{CidrIp: aws.String("0.0.0.0/0")}}},
+		{IpProtocol: aws.String("tcp"), FromPort: aws.Int64(9079), ToPort: aws.Int64(9079), IpRanges: []*ec2.IpRange{{CidrIp: aws.String("10.0.0.0/16")}}},
+	}
+	if securityGroupsAllow(permissions, 9079, source) {
+		t.Error("expected the peered vpc not to be allowed")
+	}
+	if securityGroupsAllow(permissions, 9079, nil) {
+		t.Error("expected the port not to be open to anywhere")
+	}
+	permissions[1].IpRanges = append(permissions[1].IpRanges, &ec2.IpRange{CidrIp: aws.String("10.1.0.0/16")})
+	if !securityGroupsAllow(permissions, 9079, source) {
+		t.Error("expected the peered vpc to be allowed")
+	}
+	all := []*ec2.IpPermission{{IpProtocol: aws.String("-1"), IpRanges: []*ec2.IpRange{{CidrIp: aws.String("0.0.0.0/0")}}}}
+	if !securityGroupsAllow(all, 9079, nil) {
+		t.Error("expected all the traffic to be allowed")
+	}
+}
+
+func TestRouteFor(t *testing.T) {
+	routes := []*ec2.Route{
+		{DestinationCidrBlock: aws.String("10.0.0.0/16"), GatewayId: aws.String("local")},
+		{DestinationCidrBlock: aws.String("0.0.0.0/0"), NatGatewayId: aws.String("nat-1")},
+		{DestinationCidrBlock: aws.String("10.1.0.0/16"), VpcPeeringConnectionId: aws.String("pcx-1")},
+	}
+	if route := routeFor(routes, net.ParseIP("10.1.0.5")); route == nil || aws.StringValue(route.VpcPeeringConnectionId) != "pcx-1" {
+		t.Errorf("expected the peering route, got %v", route)
+	}
+	if route := routeFor(routes[:1], net.ParseIP("10.1.0.5")); route != nil {
+		t.Errorf("expected no route, got %v", route)
+	}
+}
+
+func TestNetworkACLAllows(t *testing.T) {
+	source := net.ParseIP("10.1.0.5")
+	entries := []*ec2.NetworkAclEntry{
+		{RuleNumber: aws.Int64(100), Egress: aws.Bool(false), Protocol: aws.String("6"), CidrBlock: aws.String("10.1.0.0/16"),
+			PortRange: &ec2.PortRange{From: aws.Int64(9079), To: aws.Int64(9079)}, RuleAction: aws.String(ec2.RuleActionDeny)},
+		{RuleNumber: aws.Int64(200), Egress: aws.Bool(false), Protocol: aws.String("-1"), CidrBlock: aws.String("0.0.0.0/0"), RuleAction: aws.String(ec2.RuleActionAllow)},
+		{RuleNumber: aws.Int64(100), Egress: aws.Bool(true), Protocol: aws.String("-1"), CidrBlock: aws.String("0.0.0.0/0"), RuleAction: aws.String(ec2.RuleActionAllow)},
+	}
+	if networkACLAllows(entries, false, 9079, source) {
+		t.Error("expected the first matching rule to deny the inbound traffic")
+	}
+	if !networkACLAllows(entries, false, 22, source) {
+		t.Error("expected the inbound traffic to another port to be allowed")
+	}
+	if !networkACLAllows(entries, true, ephemeralPort, source) {
+		t.Error("expected the outbound traffic to be allowed")
+	}
+	if networkACLAllows(nil, false, 9079, source) {
+		t.Error("expected the implicit rule to deny the traffic")
+	}
+}
@@ -3,6 +3,7 @@ package amazon
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/drone-runners/drone-runner-aws/internal/oshelp"
 	"github.com/drone-runners/drone-runner-aws/types"
@@ -31,8 +32,8 @@ func SetPlatformDefaults(platform *types.Platform) (*types.Platform, error) {
 		if platform.OSName == "" {
 			platform.OSName = oshelp.Ubuntu
 		}
-		if platform.OSName != oshelp.Ubuntu && platform.OSName != oshelp.AmazonLinux {
-			return platform, fmt.Errorf("aws - invalid OS Name %s, has to be one of the following '%s/%s'", platform.OSName, oshelp.Ubuntu, oshelp.AmazonLinux)
+		if platform.OSName != oshelp.Ubuntu && platform.OSName != oshelp.AmazonLinux && platform.OSName != oshelp.RHEL {
+			return platform, fmt.Errorf("aws - invalid OS Name %s, has to be one of the following '%s/%s/%s'", platform.OSName, oshelp.Ubuntu, oshelp.AmazonLinux, oshelp.RHEL)
 		}
 	}
 	return platform, nil
@@ -87,6 +88,15 @@ func WithAMI(ami string) Option {
 	}
 }
 
+// WithAMIFilter returns an option to create the instances from the latest AMI matching the name
+// pattern, e.g. ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-amd64-server-*, owned by one of the owners.
+func WithAMIFilter(name string, owners []string) Option {
+	return func(p *config) {
+		p.amiFilter = name
+		p.amiOwners = owners
+	}
+}
+
 // WithPrivateIP returns an option to set the private IP address.
 func WithPrivateIP(private bool) Option {
 	return func(p *config) {
@@ -94,6 +104,13 @@ func WithPrivateIP(private bool) Option {
 	}
 }
 
+// WithIPFamily returns an option to set the IP stack of the instances.
+func WithIPFamily(family types.IPFamily) Option {
+	return func(p *config) {
+		p.ipFamily = family
+	}
+}
+
 // WithRetries returns an option to set the retry count.
 func WithRetries(retries int) Option {
 	return func(p *config) {
@@ -222,6 +239,21 @@ func WithIamProfileArn(t string) Option {
 	}
 }
 
+// WithInstanceProfile returns an option to set the instance profile by name or by arn, it
+// replaces the iam profile arn if set.
+func WithInstanceProfile(profile string) Option {
+	return func(p *config) {
+		if profile == "" {
+			return
+		}
+		if strings.HasPrefix(profile, "arn:") {
+			p.iamProfileArn, p.iamProfile = profile, ""
+		} else {
+			p.iamProfileArn, p.iamProfile = "", profile
+		}
+	}
+}
+
 // WithVpc returns an option to set the vpc.
 func WithVpc(t string) Option {
 	return func(p *config) {
@@ -256,6 +288,14 @@ func WithHibernate(hibernate bool) Option {
 	}
 }
 
+// WithStandby returns an option to keep free instances stopped instead of running. Unlike hibernation
+// it works for every image, it is meant for pools with slow booting images such as windows.
+func WithStandby(standby bool) Option {
+	return func(p *config) {
+		p.standby = standby
+	}
+}
+
 // WithTags returns a list of tags to apply to the instance.
 func WithTags(t map[string]string) Option {
 	return func(p *config) {
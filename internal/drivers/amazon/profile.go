@@ -0,0 +1,42 @@
+package amazon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// CheckProfile checks the instance profile attached to the instances exists and has a role, the
+// instances would get no credentials otherwise.
+func (p *config) CheckProfile(ctx context.Context) error {
+	name := profileName(p.iamProfileArn, p.iamProfile)
+	if name == "" || p.iam == nil {
+		return nil
+	}
+	out, err := p.iam.GetInstanceProfileWithContext(ctx, &iam.GetInstanceProfileInput{InstanceProfileName: aws.String(name)})
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == iam.ErrCodeNoSuchEntityException {
+		return fmt.Errorf("amazon: the instance profile %q does not exist", name)
+	}
+	if err != nil {
+		return fmt.Errorf("amazon: could not get the instance profile %q: %w", name, err)
+	}
+	if out.InstanceProfile == nil || len(out.InstanceProfile.Roles) == 0 {
+		return fmt.Errorf("amazon: the instance profile %q has no role", name)
+	}
+	return nil
+}
+
+// profileName returns the name of the instance profile, the last part of the path of its arn, e.g.
+// ci of arn:aws:iam::123456789012:instance-profile/runners/ci.
+func profileName(arn, name string) string {
+	if arn == "" {
+		return name
+	}
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
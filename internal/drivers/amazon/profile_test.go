@@ -0,0 +1,53 @@
+package amazon
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+)
+
+// fakeIAM knows the instance profiles and their roles.
+type fakeIAM struct {
+	iamiface.IAMAPI
+	profiles map[string][]string
+}
+
+func (f *fakeIAM) GetInstanceProfileWithContext(_ aws.Context, in *iam.GetInstanceProfileInput, _ ...request.Option) (*iam.GetInstanceProfileOutput, error) {
+	roles, ok := f.profiles[aws.StringValue(in.InstanceProfileName)]
+	if !ok {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "not found", nil)
+	}
+	profile := &iam.InstanceProfile{InstanceProfileName: in.InstanceProfileName}
+	for _, role := range roles {
+		profile.Roles = append(profile.Roles, &iam.Role{RoleName: aws.String(role)})
+	}
+	return &iam.GetInstanceProfileOutput{InstanceProfile: profile}, nil
+}
+
+func TestCheckProfile(t *testing.T) {
+	fake := &fakeIAM{profiles: map[string][]string{"ci": {"ci-role"}, "empty": nil}}
+	tests := []struct {
+		profile string
+		err     string
+	}{
+		{profile: ""},
+		{profile: "ci"},
+		{profile: "arn:aws:iam::123456789012:instance-profile/runners/ci"},
+		{profile: "missing", err: `the instance profile "missing" does not exist`},
+		{profile: "empty", err: `the instance profile "empty" has no role`},
+	}
+	for _, test := range tests {
+		p := &config{iam: fake}
+		WithInstanceProfile(test.profile)(p)
+		err := p.CheckProfile(context.Background())
+		if (err == nil) != (test.err == "") || (err != nil && !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("profile %q: want the error %q, got %v", test.profile, test.err, err)
+		}
+	}
+}
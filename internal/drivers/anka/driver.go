@@ -3,7 +3,6 @@ package anka
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -67,7 +66,10 @@ func (p *config) CanHibernate() bool {
 
 func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (instance *types.Instance, err error) {
 	startTime := time.Now()
-	uData := lehelper.GenerateUserdata(p.userData, opts)
+	uData, err := lehelper.GenerateUserdata(p.userData, opts)
+	if err != nil {
+		return nil, err
+	}
 	machineName := fmt.Sprintf("%s-%s-%s", opts.RunnerName, opts.PoolName, uniuri.NewLen(8)) //nolint:gomnd
 
 	logr := logger.FromContext(ctx).
@@ -151,20 +153,21 @@ func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 	}
 
 	instance = &types.Instance{
-		ID:       createdVM.Body.UUID,
-		Name:     machineName,
-		Provider: types.Anka, // this is driver, though its the old legacy name of provider
-		State:    types.StateCreated,
-		Pool:     opts.PoolName,
-		Platform: opts.Platform,
-		Address:  ip,
-		CACert:   opts.CACert,
-		CAKey:    opts.CAKey,
-		TLSCert:  opts.TLSCert,
-		TLSKey:   opts.TLSKey,
-		Started:  startTime.Unix(),
-		Updated:  time.Now().Unix(),
-		Port:     lehelper.LiteEnginePort,
+		ID:         createdVM.Body.UUID,
+		Name:       machineName,
+		ProviderID: createdVM.Body.UUID,
+		Provider:   types.Anka, // this is driver, though its the old legacy name of provider
+		State:      types.StateCreated,
+		Pool:       opts.PoolName,
+		Platform:   opts.Platform,
+		Address:    ip,
+		CACert:     opts.CACert,
+		CAKey:      opts.CAKey,
+		TLSCert:    opts.TLSCert,
+		TLSKey:     opts.TLSKey,
+		Started:    startTime.Unix(),
+		Updated:    time.Now().Unix(),
+		Port:       int64(opts.LiteEnginePort),
 	}
 	logr.
 		WithField("ip", ip).
@@ -199,20 +202,20 @@ func (p *config) Destroy(ctx context.Context, instances []*types.Instance) (err
 }
 
 func (p *config) Hibernate(_ context.Context, _, _ string) error {
-	return errors.New("unimplemented")
+	return drivers.ErrNotSupported
 }
 
 func (p *config) Start(_ context.Context, _, _ string) (string, error) {
-	return "", errors.New("unimplemented")
+	return "", drivers.ErrNotSupported
 }
 
 func (p *config) Logs(ctx context.Context, instance string) (string, error) {
-	return "", nil
+	return "", drivers.ErrNotSupported
 }
 
 func (p *config) SetTags(ctx context.Context, instance *types.Instance,
 	tags map[string]string) error {
-	return nil
+	return drivers.ErrNotSupported
 }
 
 func commandCloneVM(ctx context.Context, vmID, newVMName string) *exec.Cmd {
@@ -49,7 +49,11 @@ func New(opts ...Option) (drivers.Driver, error) {
 
 func (c *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (instance *types.Instance, err error) {
 	startTime := time.Now()
-	uData := base64.StdEncoding.EncodeToString([]byte(lehelper.GenerateUserdata(c.userData, opts)))
+	userdata, err := lehelper.GenerateUserdata(c.userData, opts)
+	if err != nil {
+		return nil, err
+	}
+	uData := base64.StdEncoding.EncodeToString([]byte(userdata))
 	machineName := fmt.Sprintf("%s-%s-%s", opts.RunnerName, opts.PoolName, uniuri.NewLen(8)) //nolint:gomnd
 	logr := logger.FromContext(ctx).
 		WithField("cloud", types.AnkaBuild).
@@ -87,20 +91,21 @@ func (c *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 	}
 	port := inst.Vminfo.PortForwarding[0].HostPort
 	instance = &types.Instance{
-		ID:       inst.InstanceID,
-		Name:     machineName,
-		Provider: types.AnkaBuild,
-		State:    types.StateCreated,
-		Pool:     opts.PoolName,
-		Platform: opts.Platform,
-		Address:  inst.Vminfo.HostIP,
-		CACert:   opts.CACert,
-		CAKey:    opts.CAKey,
-		TLSCert:  opts.TLSCert,
-		TLSKey:   opts.TLSKey,
-		Started:  inst.TS.Unix(),
-		Updated:  time.Now().Unix(),
-		Port:     int64(port),
+		ID:         inst.InstanceID,
+		Name:       machineName,
+		ProviderID: inst.InstanceID,
+		Provider:   types.AnkaBuild,
+		State:      types.StateCreated,
+		Pool:       opts.PoolName,
+		Platform:   opts.Platform,
+		Address:    inst.Vminfo.HostIP,
+		CACert:     opts.CACert,
+		CAKey:      opts.CAKey,
+		TLSCert:    opts.TLSCert,
+		TLSKey:     opts.TLSKey,
+		Started:    inst.TS.Unix(),
+		Updated:    time.Now().Unix(),
+		Port:       int64(port),
 	}
 	logr.
 		WithField("ip", inst.Vminfo.HostIP).
@@ -201,11 +206,11 @@ func (c *config) Destroy(ctx context.Context, instances []*types.Instance) (err
 }
 
 func (c *config) Hibernate(_ context.Context, _, _ string) error {
-	return errors.New("unimplemented")
+	return drivers.ErrNotSupported
 }
 
 func (c *config) Start(_ context.Context, _, _ string) (ipAddress string, err error) {
-	return "", errors.New("unimplemented")
+	return "", drivers.ErrNotSupported
 }
 
 func (c *config) Ping(ctx context.Context) error {
@@ -226,12 +231,12 @@ func (c *config) Ping(ctx context.Context) error {
 }
 
 func (c *config) Logs(_ context.Context, _ string) (string, error) {
-	return "", nil
+	return "", drivers.ErrNotSupported
 }
 
 func (c *config) SetTags(ctx context.Context, instance *types.Instance,
 	tags map[string]string) error {
-	return nil
+	return drivers.ErrNotSupported
 }
 
 func (c *config) RootDir() string {
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/drone/runner-go/logger"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
@@ -51,6 +53,8 @@ type config struct {
 	username string
 	password string
 
+	identity string // the resource ID of the user-assigned managed identity of the instances
+
 	service *armcompute.VirtualMachinesClient
 	cred    azcore.TokenCredential
 }
@@ -65,13 +69,13 @@ func New(opts ...Option) (drivers.Driver, error) {
 		return nil, errors.New("missing required azure account credentials (tenant_id, client_id, client_secret, subscription_id)")
 	}
 	if p.service == nil {
-		cred, err := azidentity.NewClientSecretCredential(p.tenantID, p.clientID, p.clientSecret, nil)
+		cred, err := azidentity.NewClientSecretCredential(p.tenantID, p.clientID, p.clientSecret, credentialOptions())
 		p.cred = cred
 		if err != nil {
 			return nil, err
 		}
 
-		p.service, err = armcompute.NewVirtualMachinesClient(p.subscriptionID, cred, nil)
+		p.service, err = armcompute.NewVirtualMachinesClient(p.subscriptionID, cred, clientOptions())
 		if err != nil {
 			return nil, err
 		}
@@ -79,6 +83,16 @@ func New(opts ...Option) (drivers.Driver, error) {
 	return p, nil
 }
 
+// clientOptions and credentialOptions send the requests of the SDK through the default transport,
+// which applies the TLS policy of the runner, the SDK has its own transport otherwise.
+func clientOptions() *arm.ClientOptions {
+	return &arm.ClientOptions{ClientOptions: azcore.ClientOptions{Transport: &http.Client{Transport: http.DefaultTransport}}}
+}
+
+func credentialOptions() *azidentity.ClientSecretCredentialOptions {
+	return &azidentity.ClientSecretCredentialOptions{ClientOptions: azcore.ClientOptions{Transport: &http.Client{Transport: http.DefaultTransport}}}
+}
+
 func (c *config) RootDir() string {
 	return c.rootDir
 }
@@ -95,6 +109,10 @@ func (c *config) CanHibernate() bool {
 	return false
 }
 
+func (c *config) Capabilities() drivers.Capabilities {
+	return drivers.Capabilities{Zones: len(c.zones) > 0}
+}
+
 func (c *config) Zones() string {
 	var z string
 	if len(z) == 1 {
@@ -107,10 +125,17 @@ func (c *config) Zones() string {
 	return z
 }
 
+// nameConstraints are the rules of the VM names, the names of the network interfaces derived
+// from them are limited to 80 characters.
+var nameConstraints = drivers.NameConstraints{MaxLen: 60} //nolint:gomnd
+
 func (c *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (instance *types.Instance, err error) {
 	sanitizedRunnerName := strings.ReplaceAll(opts.RunnerName, " ", "-")
 	sanitizedPoolName := strings.ReplaceAll(opts.PoolName, " ", "-")
 	var name = fmt.Sprintf("%s-%s-%s", sanitizedRunnerName, sanitizedPoolName, uniuri.NewLen(8)) //nolint:gomnd
+	if opts.Name != "" {
+		name = nameConstraints.Sanitize(opts.Name)
+	}
 	vnetName := fmt.Sprintf("%s-vnet", name)
 	subnetName := fmt.Sprintf("%s-subnet", name)
 	publicIPName := fmt.Sprintf("%s-publicip", name)
@@ -166,7 +191,11 @@ func (c *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 	// create the instance
 	startTime := time.Now()
 
-	uData := base64.StdEncoding.EncodeToString([]byte(lehelper.GenerateUserdata(c.userData, opts)))
+	userdata, err := lehelper.GenerateUserdata(c.userData, opts)
+	if err != nil {
+		return nil, err
+	}
+	uData := base64.StdEncoding.EncodeToString([]byte(userdata))
 
 	logr.Traceln("azure: creating VM")
 
@@ -211,6 +240,13 @@ func (c *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 		},
 	}
 
+	if c.identity != "" && opts.Untrusted == nil {
+		in.Identity = &armcompute.VirtualMachineIdentity{
+			Type:                   to.Ptr(armcompute.ResourceIdentityTypeUserAssigned),
+			UserAssignedIdentities: map[string]*armcompute.UserAssignedIdentitiesValue{c.identity: {}},
+		}
+	}
+
 	poller, err := c.service.BeginCreateOrUpdate(ctx, c.resourceGroupName, name, in, nil)
 	if err != nil {
 		return nil, err
@@ -306,15 +342,60 @@ func (c *config) Destroy(ctx context.Context, instances []*types.Instance) (err
 }
 
 func (c *config) Hibernate(_ context.Context, _, _ string) error {
-	return errors.New("unimplemented")
+	return drivers.ErrNotSupported
 }
 
 func (c *config) Start(_ context.Context, _, _ string) (ipAddress string, err error) {
-	return "", errors.New("unimplemented")
+	return "", drivers.ErrNotSupported
+}
+
+// Resize deallocates the VM, changes its size and starts it again. The public IP of the VM is
+// static, its address doesn't change.
+func (c *config) Resize(ctx context.Context, instance *types.Instance, size string) (string, error) {
+	logr := logger.FromContext(ctx).
+		WithField("cloud", types.Azure).
+		WithField("instance_id", instance.ID).
+		WithField("size", size)
+
+	if c.resourceGroupName == "" {
+		c.resourceGroupName = defaultResourceGroup
+	}
+	deallocate, err := c.service.BeginDeallocate(ctx, c.resourceGroupName, instance.ID, nil)
+	if err == nil {
+		_, err = deallocate.PollUntilDone(ctx, nil)
+	}
+	if err != nil {
+		logr.WithError(err).Errorln("azure: failed to deallocate the VM")
+		return "", err
+	}
+	update, err := c.service.BeginUpdate(ctx, c.resourceGroupName, instance.ID, armcompute.VirtualMachineUpdate{
+		Properties: &armcompute.VirtualMachineProperties{
+			HardwareProfile: &armcompute.HardwareProfile{
+				VMSize: to.Ptr(armcompute.VirtualMachineSizeTypes(size)),
+			},
+		},
+	}, nil)
+	if err == nil {
+		_, err = update.PollUntilDone(ctx, nil)
+	}
+	if err != nil {
+		logr.WithError(err).Errorln("azure: failed to change the size of the VM")
+		return "", err
+	}
+	start, err := c.service.BeginStart(ctx, c.resourceGroupName, instance.ID, nil)
+	if err == nil {
+		_, err = start.PollUntilDone(ctx, nil)
+	}
+	if err != nil {
+		logr.WithError(err).Errorln("azure: failed to start the VM")
+		return "", err
+	}
+	logr.Traceln("azure: VM resized")
+	return instance.Address, nil
 }
 
 func (c *config) Ping(ctx context.Context) error {
-	_, err := azidentity.NewClientSecretCredential(c.tenantID, c.clientID, c.clientSecret, nil)
+	_, err := azidentity.NewClientSecretCredential(c.tenantID, c.clientID, c.clientSecret, credentialOptions())
 	if err != nil {
 		return err
 	}
@@ -322,18 +403,19 @@ func (c *config) Ping(ctx context.Context) error {
 }
 
 func (c *config) Logs(ctx context.Context, instanceID string) (string, error) {
-	return "", nil
+	return "", drivers.ErrNotSupported
 }
 
 func (c *config) SetTags(ctx context.Context, instance *types.Instance,
 	tags map[string]string) error {
-	return nil
+	return drivers.ErrNotSupported
 }
 
 func (c *config) mapToInstance(vm *armcompute.VirtualMachinesClientCreateOrUpdateResponse, opts *types.InstanceCreateOpts) types.Instance {
 	return types.Instance{
 		ID:           *vm.Name,
 		Name:         *vm.Name,
+		ProviderID:   *vm.ID,
 		Provider:     types.Azure,
 		State:        types.StateCreated,
 		Pool:         opts.PoolName,
@@ -349,6 +431,6 @@ func (c *config) mapToInstance(vm *armcompute.VirtualMachinesClientCreateOrUpdat
 		Started:      vm.Properties.TimeCreated.Unix(),
 		Updated:      time.Now().Unix(),
 		IsHibernated: false,
-		Port:         lehelper.LiteEnginePort,
+		Port:         int64(opts.LiteEnginePort),
 	}
 }
@@ -167,3 +167,10 @@ func WithSecurityGroupName(securityGroupName string) Option {
 		p.securityGroupName = securityGroupName
 	}
 }
+
+// WithManagedIdentity returns an option to attach a user-assigned managed identity to the instances.
+func WithManagedIdentity(id string) Option {
+	return func(p *config) {
+		p.identity = id
+	}
+}
@@ -0,0 +1,34 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+// identityAPIVersion is the version of the API of the user-assigned managed identities.
+const identityAPIVersion = "2023-01-31"
+
+// CheckProfile checks the managed identity attached to the instances exists.
+func (c *config) CheckProfile(ctx context.Context) error {
+	if c.identity == "" || c.cred == nil {
+		return nil
+	}
+	client, err := armresources.NewClient(c.subscriptionID, c.cred, clientOptions())
+	if err != nil {
+		return err
+	}
+	_, err = client.GetByID(ctx, c.identity, identityAPIVersion, nil)
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("azure: the managed identity %q does not exist", c.identity)
+	}
+	if err != nil {
+		return fmt.Errorf("azure: could not get the managed identity %q: %w", c.identity, err)
+	}
+	return nil
+}
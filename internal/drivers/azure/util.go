@@ -23,7 +23,7 @@ func (c *config) createResourceGroup(ctx context.Context) (*armresources.Resourc
 		logr.Debugln("using default resource group name")
 		c.resourceGroupName = defaultResourceGroup
 	}
-	resourceGroupClient, err := armresources.NewResourceGroupsClient(c.subscriptionID, c.cred, nil)
+	resourceGroupClient, err := armresources.NewResourceGroupsClient(c.subscriptionID, c.cred, clientOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -46,7 +46,7 @@ func (c *config) createResourceGroup(ctx context.Context) (*armresources.Resourc
 
 func (c *config) createVirtualNetwork(ctx context.Context, vnetName string) (*armnetwork.VirtualNetwork, error) {
 	logr := logger.FromContext(ctx)
-	vnetClient, err := armnetwork.NewVirtualNetworksClient(c.subscriptionID, c.cred, nil)
+	vnetClient, err := armnetwork.NewVirtualNetworksClient(c.subscriptionID, c.cred, clientOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -75,7 +75,7 @@ func (c *config) createVirtualNetwork(ctx context.Context, vnetName string) (*ar
 }
 
 func (c *config) deleteVirtualNetWork(ctx context.Context, vnetName string) error {
-	vnetClient, err := armnetwork.NewVirtualNetworksClient(c.subscriptionID, c.cred, nil)
+	vnetClient, err := armnetwork.NewVirtualNetworksClient(c.subscriptionID, c.cred, clientOptions())
 	if err != nil {
 		return err
 	}
@@ -95,7 +95,7 @@ func (c *config) deleteVirtualNetWork(ctx context.Context, vnetName string) erro
 
 func (c *config) createSubnets(ctx context.Context, subnetName, vnetName string) (*armnetwork.Subnet, error) {
 	logr := logger.FromContext(ctx)
-	subnetClient, err := armnetwork.NewSubnetsClient(c.subscriptionID, c.cred, nil)
+	subnetClient, err := armnetwork.NewSubnetsClient(c.subscriptionID, c.cred, clientOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -106,7 +106,7 @@ func (c *config) createSubnets(ctx context.Context, subnetName, vnetName string)
 	}
 	// map security group to subnet if exists
 	if c.securityGroupName != "" {
-		securityGroupClient, _ := armnetwork.NewSecurityGroupsClient(c.subscriptionID, c.cred, nil)
+		securityGroupClient, _ := armnetwork.NewSecurityGroupsClient(c.subscriptionID, c.cred, clientOptions())
 		sG, sgErr := securityGroupClient.Get(ctx, c.resourceGroupName, c.securityGroupName, nil)
 		if sgErr != nil {
 			logr.Infof("failed to get security group %s: %s", c.securityGroupName, sgErr)
@@ -130,7 +130,7 @@ func (c *config) createSubnets(ctx context.Context, subnetName, vnetName string)
 
 func (c *config) createPublicIP(ctx context.Context, publicIPName string) (*armnetwork.PublicIPAddress, error) {
 	logr := logger.FromContext(ctx)
-	publicIPAddressClient, err := armnetwork.NewPublicIPAddressesClient(c.subscriptionID, c.cred, nil)
+	publicIPAddressClient, err := armnetwork.NewPublicIPAddressesClient(c.subscriptionID, c.cred, clientOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -157,7 +157,7 @@ func (c *config) createPublicIP(ctx context.Context, publicIPName string) (*armn
 }
 
 func (c *config) deletePublicIP(ctx context.Context, publicIPName string) error {
-	publicIPAddressClient, err := armnetwork.NewPublicIPAddressesClient(c.subscriptionID, c.cred, nil)
+	publicIPAddressClient, err := armnetwork.NewPublicIPAddressesClient(c.subscriptionID, c.cred, clientOptions())
 	if err != nil {
 		return err
 	}
@@ -176,7 +176,7 @@ func (c *config) deletePublicIP(ctx context.Context, publicIPName string) error
 
 func (c *config) createNetworkInterface(ctx context.Context, networkInterfaceName, subnetID, publicIPID string) (*armnetwork.Interface, error) {
 	logr := logger.FromContext(ctx)
-	nicClient, err := armnetwork.NewInterfacesClient(c.subscriptionID, c.cred, nil)
+	nicClient, err := armnetwork.NewInterfacesClient(c.subscriptionID, c.cred, clientOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -230,7 +230,7 @@ func (c *config) addExtension(ctx context.Context, vmName string) (ext armcomput
 			}),
 		},
 	}
-	client, clientErr := armcompute.NewVirtualMachineExtensionsClient(c.subscriptionID, c.cred, nil)
+	client, clientErr := armcompute.NewVirtualMachineExtensionsClient(c.subscriptionID, c.cred, clientOptions())
 	if clientErr != nil {
 		return ext, clientErr
 	}
@@ -247,7 +247,7 @@ func (c *config) addExtension(ctx context.Context, vmName string) (ext armcomput
 }
 
 func (c *config) deleteNetworkInterface(ctx context.Context, networkInterfaceName string) error {
-	nicClient, err := armnetwork.NewInterfacesClient(c.subscriptionID, c.cred, nil)
+	nicClient, err := armnetwork.NewInterfacesClient(c.subscriptionID, c.cred, clientOptions())
 	if err != nil {
 		return err
 	}
@@ -266,7 +266,7 @@ func (c *config) deleteNetworkInterface(ctx context.Context, networkInterfaceNam
 }
 
 func (c *config) deleteDisk(ctx context.Context, diskName string) error {
-	diskClient, err := armcompute.NewDisksClient(c.subscriptionID, c.cred, nil)
+	diskClient, err := armcompute.NewDisksClient(c.subscriptionID, c.cred, clientOptions())
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,135 @@
+package drivers
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// blacklistThreshold is the number of consecutive capacity failures after which a zone is blacklisted.
+	blacklistThreshold = 2
+	// blacklistCooldown is the initial time a zone is blacklisted for, it doubles with every failed probe.
+	blacklistCooldown = 5 * time.Minute
+	// blacklistMaxCooldown caps the time a zone is blacklisted for.
+	blacklistMaxCooldown = time.Hour
+	// probeTimeout is the time after which a probe that never reported back is considered lost.
+	probeTimeout = 5 * time.Minute
+
+	// capacityErrors are the error codes the cloud providers return when a region or zone is out of capacity.
+	capacityErrors = []string{
+		"InsufficientInstanceCapacity",
+		"InsufficientHostCapacity",
+		"InsufficientCapacity",
+		"ZONE_RESOURCE_POOL_EXHAUSTED",
+		"does not have enough resources available",
+		"stockout",
+	}
+)
+
+// Blacklist tracks provisioning capacity failures per zone (or region) and temporarily stops placing
+// instances in a zone after repeated failures. Once the cooldown expires a single probe is let through:
+// if it succeeds the zone is healthy again, otherwise the zone is blacklisted for twice as long.
+// The zero value is ready to use.
+type Blacklist struct {
+	mu    sync.Mutex
+	zones map[string]*zoneFailures
+}
+
+type zoneFailures struct {
+	failures int           // consecutive capacity failures
+	cooldown time.Duration // current blacklisting period, zero if the zone is not blacklisted
+	until    time.Time     // the zone is blacklisted until this time
+	probe    time.Time     // start time of the probe in flight, zero if there is none
+}
+
+// Allowed returns true if an instance can be placed in the zone. If the zone is blacklisted and
+// its cooldown has expired, the call is allowed as the recovery probe.
+func (b *Blacklist) Allowed(zone string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	z := b.zones[zone]
+	if z == nil || z.cooldown == 0 {
+		return true
+	}
+
+	now := time.Now()
+	if now.Before(z.until) {
+		return false
+	}
+	if !z.probe.IsZero() && now.Sub(z.probe) < probeTimeout {
+		return false
+	}
+	z.probe = now
+	return true
+}
+
+// Blacklisted returns true if the zone is blacklisted. Unlike Allowed it never starts a probe.
+func (b *Blacklist) Blacklisted(zone string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	z := b.zones[zone]
+	return z != nil && z.cooldown > 0 && time.Now().Before(z.until)
+}
+
+// Succeeded marks the zone as healthy.
+func (b *Blacklist) Succeeded(zone string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.zones, zone)
+}
+
+// Failed records a failed instance placement in the zone. Only capacity errors count toward blacklisting.
+func (b *Blacklist) Failed(zone string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	z := b.zones[zone]
+	if !isCapacityError(err) {
+		// the probe failed for a reason unrelated to capacity, let another one through.
+		if z != nil {
+			z.probe = time.Time{}
+		}
+		return
+	}
+
+	if b.zones == nil {
+		b.zones = make(map[string]*zoneFailures)
+	}
+	if z == nil {
+		z = &zoneFailures{}
+		b.zones[zone] = z
+	}
+
+	z.failures++
+	z.probe = time.Time{}
+	switch {
+	case z.cooldown > 0:
+		// the recovery probe failed.
+		z.cooldown *= 2
+		if z.cooldown > blacklistMaxCooldown {
+			z.cooldown = blacklistMaxCooldown
+		}
+	case z.failures >= blacklistThreshold:
+		z.cooldown = blacklistCooldown
+	default:
+		return
+	}
+	z.until = time.Now().Add(z.cooldown)
+}
+
+func isCapacityError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, code := range capacityErrors {
+		if strings.Contains(msg, strings.ToLower(code)) {
+			return true
+		}
+	}
+	return false
+}
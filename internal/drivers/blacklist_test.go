@@ -0,0 +1,54 @@
+package drivers
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBlacklist(t *testing.T) {
+	capacityErr := errors.New("InsufficientInstanceCapacity: we currently do not have sufficient capacity")
+	otherErr := errors.New("connection reset by peer")
+
+	b := &Blacklist{}
+	const zone = "us-east-2a"
+
+	b.Failed(zone, otherErr)
+	b.Failed(zone, otherErr)
+	if !b.Allowed(zone) {
+		t.Errorf("zone must not be blacklisted after non capacity errors")
+	}
+
+	b.Failed(zone, capacityErr)
+	if !b.Allowed(zone) {
+		t.Errorf("zone must not be blacklisted after a single capacity error")
+	}
+
+	b.Failed(zone, capacityErr)
+	if b.Allowed(zone) || !b.Blacklisted(zone) {
+		t.Errorf("zone must be blacklisted after repeated capacity errors")
+	}
+
+	// expire the cooldown, a single probe is let through.
+	b.zones[zone].until = time.Now().Add(-time.Second)
+	if !b.Allowed(zone) {
+		t.Errorf("a probe must be allowed once the cooldown expired")
+	}
+	if b.Allowed(zone) {
+		t.Errorf("only a single probe must be allowed")
+	}
+
+	b.Failed(zone, capacityErr)
+	if got, want := b.zones[zone].cooldown, 2*blacklistCooldown; got != want {
+		t.Errorf("Want cooldown %s after a failed probe, got %s", want, got)
+	}
+
+	b.zones[zone].until = time.Now().Add(-time.Second)
+	if !b.Allowed(zone) {
+		t.Errorf("a probe must be allowed once the cooldown expired")
+	}
+	b.Succeeded(zone)
+	if !b.Allowed(zone) || b.Blacklisted(zone) {
+		t.Errorf("zone must be healthy after a successful probe")
+	}
+}
@@ -0,0 +1,102 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/lehelper"
+	"github.com/drone-runners/drone-runner-aws/internal/oshelp"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/drone/runner-go/logger"
+	"github.com/harness/lite-engine/api"
+)
+
+// canaryTimeout bounds the validation of a canary instance once its lite-engine is healthy.
+const canaryTimeout = 5 * time.Minute
+
+// default validation scripts of the canary instances: docker and git work and the names resolve.
+const (
+	defaultCanaryScript = `set -e
+docker info > /dev/null
+git --version
+getent hosts github.com || nslookup github.com`
+	defaultCanaryScriptWindows = `$ErrorActionPreference = 'Stop'
+docker info | Out-Null
+git --version
+Resolve-DnsName github.com | Out-Null`
+)
+
+// RolloutObserver is implemented by the pool observers notified of the rollouts blocked by a
+// canary instance failing the validation of the new image.
+type RolloutObserver interface {
+	RolloutBlocked(pool string, err error)
+}
+
+// runCanary creates a canary instance in the shadow pool of the rollout and runs the validation
+// script of the pool on it through its lite-engine. The canary instance is destroyed afterwards.
+func (m *Manager) runCanary(ctx context.Context, shadow *poolEntry, script string) error {
+	logr := logger.FromContext(ctx).WithField("pool", shadow.Name)
+	logr.Infoln("rollout: creating a canary instance")
+
+	inst, err := m.setupInstance(ctx, shadow, setupOpts{inuse: true})
+	if err != nil {
+		return fmt.Errorf("could not create the canary instance: %w", err)
+	}
+	defer func() {
+		if destroyErr := m.destroyOrRetry(context.Background(), shadow, []*types.Instance{inst}, true); destroyErr != nil {
+			logr.WithError(destroyErr).
+				WithField("instance", inst.ID).
+				Errorln("rollout: failed to destroy the canary instance")
+		}
+	}()
+
+	client, err := lehelper.GetClient(inst, m.runnerName, int64(liteEnginePort(&shadow.Pool)), false, 0)
+	if err != nil {
+		return err
+	}
+	healthCtx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+	if _, err = client.RetryHealth(healthCtx, preflightTimeout); err != nil {
+		return fmt.Errorf("the lite-engine of the canary instance %s is not healthy: %w", inst.ID, err)
+	}
+
+	ctx, cancel = context.WithTimeout(ctx, canaryTimeout)
+	defer cancel()
+	if _, err = client.Setup(ctx, &api.SetupRequest{}); err != nil {
+		return fmt.Errorf("could not set up the lite-engine of the canary instance %s: %w", inst.ID, err)
+	}
+	req := &api.StartStepRequest{
+		ID:   "canary-" + oshelp.Random(),
+		Name: "canary",
+		Kind: api.Run,
+	}
+	req.Run.Entrypoint, req.Run.Command = canaryCommand(inst.Platform.OS, script)
+	if _, err = client.StartStep(ctx, req); err != nil {
+		return fmt.Errorf("could not start the validation script on the canary instance %s: %w", inst.ID, err)
+	}
+	resp, err := client.RetryPollStep(ctx, &api.PollStepRequest{ID: req.ID}, canaryTimeout)
+	if err != nil {
+		return fmt.Errorf("the validation script did not complete on the canary instance %s: %w", inst.ID, err)
+	}
+	if resp.Error != "" || resp.ExitCode != 0 {
+		return fmt.Errorf("the validation script failed on the canary instance %s with exit code %d: %s", inst.ID, resp.ExitCode, resp.Error)
+	}
+
+	logr.WithField("instance", inst.ID).Infoln("rollout: the canary instance passed the validation")
+	return nil
+}
+
+// canaryCommand returns the command running the validation script, the default script of the OS if empty.
+func canaryCommand(os, script string) (entrypoint, command []string) {
+	if os == oshelp.OSWindows {
+		if script == "" {
+			script = defaultCanaryScriptWindows
+		}
+		return []string{"powershell"}, []string{script}
+	}
+	if script == "" {
+		script = defaultCanaryScript
+	}
+	return []string{"sh", "-c"}, []string{script}
+}
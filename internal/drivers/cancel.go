@@ -0,0 +1,59 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrSetupCancelled is returned when the setup of the stage an instance is provisioned for is
+// cancelled, see CancelSetup.
+var ErrSetupCancelled = errors.New("the setup of the stage was cancelled")
+
+// setups holds the cancellations of the setups in progress on the runner, by stage.
+type setups struct {
+	mu      sync.Mutex
+	cancels map[string]*context.CancelFunc
+}
+
+// TrackSetup returns a context for the setup of the stage which CancelSetup cancels. done must
+// be called once the setup completes.
+func (m *Manager) TrackSetup(ctx context.Context, stageID string) (setupCtx context.Context, done func()) {
+	setupCtx, cancel := context.WithCancel(ctx)
+
+	m.setups.mu.Lock()
+	defer m.setups.mu.Unlock()
+	if m.setups.cancels == nil {
+		m.setups.cancels = map[string]*context.CancelFunc{}
+	}
+	entry := &cancel
+	m.setups.cancels[stageID] = entry
+	return setupCtx, func() {
+		m.setups.mu.Lock()
+		defer m.setups.mu.Unlock()
+		// a retried setup of the stage might have replaced the cancellation
+		if m.setups.cancels[stageID] == entry {
+			delete(m.setups.cancels, stageID)
+		}
+		cancel()
+	}
+}
+
+// CancelSetup aborts the setup of the stage in progress on the runner: the creation of its
+// instance is abandoned and the instance is destroyed. It returns false if the stage is not
+// being set up.
+func (m *Manager) CancelSetup(stageID string) bool {
+	m.setups.mu.Lock()
+	cancel, ok := m.setups.cancels[stageID]
+	delete(m.setups.cancels, stageID)
+	m.setups.mu.Unlock()
+	if ok {
+		(*cancel)()
+	}
+	return ok
+}
+
+// setupCancelled returns true if the setup the context belongs to was cancelled.
+func setupCancelled(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.Canceled)
+}
@@ -0,0 +1,76 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// slowDriver creates the instances once released, whether the context is done or not.
+type slowDriver struct {
+	failingDriver
+	started   chan struct{}
+	release   chan struct{}
+	destroyed atomic.Int32
+}
+
+func (d *slowDriver) Create(context.Context, *types.InstanceCreateOpts) (*types.Instance, error) {
+	close(d.started)
+	<-d.release
+	return &types.Instance{ID: "slow"}, nil
+}
+
+func (d *slowDriver) Destroy(_ context.Context, instances []*types.Instance) error {
+	d.destroyed.Add(int32(len(instances)))
+	return nil
+}
+
+func TestCancelSetup(t *testing.T) {
+	const pool, stage = "linux", "stage"
+
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	env := &config.EnvConfig{}
+	m := New(context.Background(), ldb.NewInstanceStore(db), env)
+	driver := &slowDriver{started: make(chan struct{}), release: make(chan struct{})}
+	if err = m.Add(Pool{Name: pool, MaxSize: 1, Driver: driver}); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.CancelSetup(stage) {
+		t.Error("expected no setup of the stage to cancel")
+	}
+	ctx, done := m.TrackSetup(context.Background(), stage)
+	defer done()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, provisionErr := m.Provision(ctx, pool, "runner", env)
+		errs <- provisionErr
+	}()
+	<-driver.started
+	if !m.CancelSetup(stage) {
+		t.Error("expected the setup of the stage to be cancelled")
+	}
+	close(driver.release)
+
+	if err = <-errs; !errors.Is(err, ErrSetupCancelled) {
+		t.Errorf("expected the provision to be cancelled, got %v", err)
+	}
+	if got := driver.destroyed.Load(); got != 1 {
+		t.Errorf("expected the instance created for the cancelled setup to be destroyed, destroyed %d", got)
+	}
+	if _, attempts := m.getPool(pool).stats.failureRate(); attempts != 0 {
+		t.Errorf("expected the cancelled setup not to count as a creation attempt, got %d attempts", attempts)
+	}
+}
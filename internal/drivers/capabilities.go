@@ -0,0 +1,76 @@
+package drivers
+
+import (
+	"sort"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// Capabilities are the features a driver supports with the settings of its pool.
+type Capabilities struct {
+	Hibernate   bool `json:"hibernate"`    // the free instances are stopped and started on demand
+	ConsoleLogs bool `json:"console_logs"` // the console output of the instances is available
+	Tags        bool `json:"tags"`         // the tags of the stages are set on the instances
+	Spot        bool `json:"spot"`         // the instances are spot or preemptible instances
+	Resize      bool `json:"resize"`       // the instances can be created with another size if the size is unavailable
+	Zones       bool `json:"zones"`        // the instances are placed in availability zones
+	Untrusted   bool `json:"untrusted"`    // the instances can be hardened for untrusted builds
+	Capacity    bool `json:"capacity"`     // the capacity of the nodes running the instances is reported
+}
+
+// CapabilityReporter is implemented by the drivers reporting the features they support. For the
+// other drivers only the features the manager can tell from the driver are reported.
+type CapabilityReporter interface {
+	Capabilities() Capabilities
+}
+
+// PoolCapabilities are the capabilities of the driver of a pool.
+type PoolCapabilities struct {
+	Name         string       `json:"name"`
+	Driver       string       `json:"driver"`
+	Capabilities Capabilities `json:"capabilities"`
+}
+
+// capabilitiesOf returns the capabilities of the driver.
+func capabilitiesOf(driver Driver) Capabilities {
+	var c Capabilities
+	if reporter, ok := driver.(CapabilityReporter); ok {
+		c = reporter.Capabilities()
+	}
+	c.Hibernate = driver.CanHibernate()
+	if capable, ok := driver.(UntrustedCapable); ok {
+		c.Untrusted = capable.CanRunUntrusted()
+	}
+	_, c.Capacity = driver.(CapacityReporter)
+	return c
+}
+
+// Capabilities returns the capabilities of the drivers of the pools ordered by name.
+func (m *Manager) Capabilities() []PoolCapabilities {
+	pools := m.pools()
+	capabilities := make([]PoolCapabilities, 0, len(pools))
+	for _, pool := range pools {
+		capabilities = append(capabilities, PoolCapabilities{
+			Name:         pool.Name,
+			Driver:       pool.Driver.DriverName(),
+			Capabilities: capabilitiesOf(pool.Driver),
+		})
+	}
+	sort.Slice(capabilities, func(i, j int) bool { return capabilities[i].Name < capabilities[j].Name })
+	return capabilities
+}
+
+// InstanceCapabilities returns the capabilities of the driver managing the instance, the region
+// driver of the instances of a multi-region pool. It returns none if the pool of the instance is
+// gone.
+func (m *Manager) InstanceCapabilities(inst *types.Instance) Capabilities {
+	pool := m.getPool(inst.Pool)
+	if pool == nil {
+		return Capabilities{}
+	}
+	driver, err := driverFor(pool, inst)
+	if err != nil {
+		return Capabilities{}
+	}
+	return capabilitiesOf(driver)
+}
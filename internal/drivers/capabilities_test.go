@@ -0,0 +1,65 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// reportingDriver reports its capabilities, it can't hibernate whatever it reports.
+type reportingDriver struct {
+	failingDriver
+}
+
+func (reportingDriver) Capabilities() Capabilities {
+	return Capabilities{Hibernate: true, ConsoleLogs: true, Zones: true}
+}
+
+func TestCapabilitiesOf(t *testing.T) {
+	if got := capabilitiesOf(failingDriver{}); got != (Capabilities{}) {
+		t.Errorf("expected no capabilities, got %+v", got)
+	}
+	want := Capabilities{ConsoleLogs: true, Zones: true}
+	if got := capabilitiesOf(reportingDriver{}); got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+// unsupportedDriver supports none of the optional operations.
+type unsupportedDriver struct {
+	failingDriver
+}
+
+func (unsupportedDriver) SetTags(context.Context, *types.Instance, map[string]string) error {
+	return ErrNotSupported
+}
+
+func (unsupportedDriver) Logs(context.Context, string) (string, error) { return "", ErrNotSupported }
+
+func TestUnsupportedOperations(t *testing.T) {
+	const pool = "linux"
+
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	m := New(ctx, ldb.NewInstanceStore(db), &config.EnvConfig{})
+	if err = m.Add(Pool{Name: pool, MaxSize: 1, Driver: unsupportedDriver{}}); err != nil {
+		t.Fatal(err)
+	}
+	inst := &types.Instance{ID: "instance", Pool: pool}
+	if err = m.SetInstanceTags(ctx, pool, inst, map[string]string{"stage": "1"}); err != nil {
+		t.Errorf("expected the tags to be skipped, got %v", err)
+	}
+	if _, err = m.InstanceLogs(ctx, pool, inst.ID); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("expected the console logs not to be supported, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// NodeCapacity describes the resources of a node running the instances of a pool. The reserved
+// resources are the resources of all the allocations on the node, the runner resources are the
+// part of them reserved by the jobs of the runner.
+type NodeCapacity struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Datacenter string `json:"datacenter"`
+	Status     string `json:"status"`
+	Eligible   bool   `json:"eligible"` // the node accepts new allocations
+
+	CPUTotalMhz    int64 `json:"cpu_total_mhz"`
+	CPUReservedMhz int64 `json:"cpu_reserved_mhz"`
+	CPURunnerMhz   int64 `json:"cpu_runner_mhz"`
+	CPUFreeMhz     int64 `json:"cpu_free_mhz"`
+
+	MemoryTotalMB    int64 `json:"memory_total_mb"`
+	MemoryReservedMB int64 `json:"memory_reserved_mb"`
+	MemoryRunnerMB   int64 `json:"memory_runner_mb"`
+	MemoryFreeMB     int64 `json:"memory_free_mb"`
+}
+
+// PoolCapacity is the capacity of the nodes of a pool.
+type PoolCapacity struct {
+	Name   string         `json:"name"`
+	Driver string         `json:"driver"`
+	Nodes  []NodeCapacity `json:"nodes"`
+}
+
+// Capacity returns the capacity of the nodes of the pools whose drivers report it.
+func (m *Manager) Capacity(ctx context.Context) ([]PoolCapacity, error) {
+	pools := m.pools()
+	capacities := make([]PoolCapacity, 0, len(pools))
+	for _, pool := range pools {
+		reporter, ok := pool.Driver.(CapacityReporter)
+		if !ok {
+			continue
+		}
+		nodes, err := reporter.Capacity(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("capacity: failed to get the capacity of %q pool: %w", pool.Name, err)
+		}
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+		capacities = append(capacities, PoolCapacity{
+			Name:   pool.Name,
+			Driver: pool.Driver.DriverName(),
+			Nodes:  nodes,
+		})
+	}
+	sort.Slice(capacities, func(i, j int) bool { return capacities[i].Name < capacities[j].Name })
+	return capacities, nil
+}
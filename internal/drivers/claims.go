@@ -0,0 +1,137 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// claimHealthTimeout bounds the health check of the lite-engine of an instance whose claim expired.
+const claimHealthTimeout = 30 * time.Second
+
+// CompleteClaim records that the setup claiming the instance completed, the stage runs on it and
+// its claim no longer expires.
+func (m *Manager) CompleteClaim(ctx context.Context, instance *types.Instance) error {
+	if instance.Claimed == 0 {
+		return nil
+	}
+	claimed := instance.Claimed
+	instance.Claimed = 0
+	if err := m.instanceStore.Update(ctx, instance); err != nil {
+		instance.Claimed = claimed
+		return fmt.Errorf("claim: failed to update instance %s: %w", instance.ID, err)
+	}
+	return nil
+}
+
+// StartClaimReleaser periodically releases the instances claimed by setups which did not complete
+// within the ttl, e.g. because the runner crashed before the stage started, so they are not kept
+// busy until the purger destroys them. An instance the setup did not use yet is returned to the
+// pool if its lite-engine is healthy, the others are destroyed.
+func (m *Manager) StartClaimReleaser(ctx context.Context, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	interval := ttl / 2 //nolint:gomnd
+
+	logrus.Infof("Claim releaser started. It will run every %.2f minutes", interval.Minutes())
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !m.IsLeader() {
+					continue
+				}
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							logrus.Errorf("PANIC %v\n%s", r, debug.Stack())
+						}
+					}()
+					m.releaseExpiredClaims(ctx, ttl)
+				}()
+			}
+		}
+	}()
+}
+
+func (m *Manager) releaseExpiredClaims(ctx context.Context, ttl time.Duration) {
+	for _, pool := range m.pools() {
+		pool.Lock()
+		busy, _, _, err := m.List(ctx, pool)
+		pool.Unlock()
+		if err != nil {
+			logrus.WithError(err).WithField("pool", pool.Name).
+				Errorln("claim: failed to list instances, skipping the pool")
+			continue
+		}
+		for _, inst := range busy {
+			if inst.State != types.StateInUse || inst.Claimed == 0 || time.Since(time.Unix(inst.Claimed, 0)) < ttl {
+				continue
+			}
+			m.releaseClaim(ctx, pool, inst)
+		}
+	}
+}
+
+// releaseClaim returns the instance of the expired claim to the pool, or destroys it.
+func (m *Manager) releaseClaim(ctx context.Context, pool *poolEntry, inst *types.Instance) {
+	logr := logrus.WithField("pool", pool.Name).
+		WithField("instance_id", inst.ID).
+		WithField("stage_runtime_id", inst.Stage).
+		WithField("claimed", time.Unix(inst.Claimed, 0).UTC().Format(time.RFC3339))
+
+	// the setup tags the instance with its stage before it sets up the lite-engine, the instance
+	// is left as it was created until then. The untrusted and the overflow instances are never
+	// kept free.
+	reusable := inst.Stage == "" && !inst.Untrusted && !inst.Overflow && !pool.draining.Load()
+	if reusable && !inst.IsHibernated {
+		hctx, cancel := context.WithTimeout(ctx, claimHealthTimeout)
+		err := m.checkInstanceConnectivity(hctx, inst.ID)
+		cancel()
+		if err != nil {
+			logr.WithError(err).Warnln("claim: the instance of the expired claim is not healthy")
+			reusable = false
+		}
+	}
+
+	pool.Lock()
+	defer pool.Unlock()
+	// the setup might have completed meanwhile
+	current, err := m.Find(ctx, inst.ID)
+	if err != nil || current.State != types.StateInUse || current.Claimed != inst.Claimed || current.Stage != inst.Stage {
+		return
+	}
+
+	if reusable {
+		current.State = types.StateCreated
+		current.Claimed = 0
+		// the instance ran nothing of the account, it is free for any account again
+		current.AccountID = ""
+		released, updateErr := m.instanceStore.CompareAndUpdate(ctx, current, types.StateInUse)
+		if updateErr != nil {
+			logr.WithError(updateErr).Errorln("claim: failed to return the instance to the pool")
+			return
+		}
+		if released {
+			logr.Warnln("claim: the setup claiming the instance did not complete, returned the instance to the pool")
+		}
+		return
+	}
+
+	if err = m.destroyOrRetry(ctx, pool, []*types.Instance{current}, true); err != nil {
+		logr.WithError(err).Errorln("claim: failed to destroy the instance of the expired claim")
+		return
+	}
+	logr.Warnln("claim: the setup claiming the instance did not complete, destroyed the instance")
+}
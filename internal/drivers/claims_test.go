@@ -0,0 +1,88 @@
+package drivers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestClaims(t *testing.T) {
+	const pool = "linux"
+
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	instanceStore := ldb.NewInstanceStore(db)
+	m := New(ctx, instanceStore, &config.EnvConfig{})
+	if err = m.Add(Pool{Name: pool, MaxSize: 10, Driver: failingDriver{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the setup claims the free instance and completes
+	if err = instanceStore.Create(ctx, &types.Instance{ID: "free", Pool: pool, State: types.StateCreated}); err != nil {
+		t.Fatal(err)
+	}
+	inst, err := m.Provision(ctx, pool, "runner", &config.EnvConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inst.Claimed == 0 {
+		t.Error("expected the provisioned instance to be claimed")
+	}
+	if err = m.CompleteClaim(ctx, inst); err != nil {
+		t.Fatal(err)
+	}
+
+	expired := time.Now().Add(-time.Hour).Unix()
+	instances := []*types.Instance{
+		// the setup crashed before starting the hibernated instance
+		{ID: "hibernated", Pool: pool, State: types.StateInUse, Claimed: expired, IsHibernated: true},
+		// the setup crashed while setting the lite-engine up for the stage
+		{ID: "tagged", Pool: pool, State: types.StateInUse, Claimed: expired, Stage: "stage", IsHibernated: true},
+		// the lite-engine of the instance can't be reached
+		{ID: "unhealthy", Pool: pool, State: types.StateInUse, Claimed: expired},
+		// the setup is in progress
+		{ID: "recent", Pool: pool, State: types.StateInUse, Claimed: time.Now().Unix()},
+	}
+	for _, i := range instances {
+		if err = instanceStore.Create(ctx, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m.releaseExpiredClaims(ctx, 30*time.Minute)
+
+	want := map[string]types.InstanceState{
+		"free":       types.StateInUse,
+		"hibernated": types.StateCreated,
+		"recent":     types.StateInUse,
+	}
+	list, err := instanceStore.List(ctx, pool, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]types.InstanceState{}
+	for _, i := range list {
+		got[i.ID] = i.State
+		if i.ID == "hibernated" && i.Claimed != 0 {
+			t.Error("expected the claim of the released instance to be cleared")
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got instances %v, want %v", got, want)
+	}
+	for id, state := range want {
+		if got[id] != state {
+			t.Errorf("got instance %s %q, want %q", id, got[id], state)
+		}
+	}
+}
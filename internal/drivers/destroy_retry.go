@@ -0,0 +1,237 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/alert"
+	"github.com/drone-runners/drone-runner-aws/internal/events"
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/drone/runner-go/logger"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// destroyRetryInterval is how often the queue of failed destroys is checked.
+	destroyRetryInterval = 30 * time.Second
+	destroyRetryMinDelay = 30 * time.Second
+	destroyRetryMaxDelay = time.Hour
+)
+
+// SetDestroyRetryStore sets the store of the failed destroys. Without the store the failed
+// destroys are not retried.
+func (m *Manager) SetDestroyRetryStore(s store.DestroyRetryStore) {
+	m.destroyRetries = s
+}
+
+// StartDestroyRetrier periodically retries the failed destroys, the instances are destroyed
+// with an exponential backoff until the cloud provider accepts the destroy.
+func (m *Manager) StartDestroyRetrier(ctx context.Context) {
+	if m.destroyRetries == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(destroyRetryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !m.IsLeader() {
+					continue
+				}
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							logrus.Errorf("PANIC %v\n%s", r, debug.Stack())
+						}
+					}()
+					m.retryDestroys(ctx)
+				}()
+			}
+		}
+	}()
+}
+
+// destroyOrRetry destroys the instances and deletes them from the store. When the driver fails
+// the instances are destroyed one by one, the ones that still fail are kept in the store as
+// terminating and queued for retry. The instances not stored yet, of a failed setup, are stored
+// first so the retrier can find them.
+func (m *Manager) destroyOrRetry(ctx context.Context, pool *poolEntry, instances []*types.Instance, stored bool) error {
+	failed := map[*types.Instance]error{}
+	if err := m.destroyInstances(ctx, pool, instances); err != nil {
+		if len(instances) == 1 {
+			failed[instances[0]] = err
+		} else {
+			for _, inst := range instances {
+				if ierr := m.destroyInstances(ctx, pool, []*types.Instance{inst}); ierr != nil {
+					failed[inst] = ierr
+				}
+			}
+		}
+	}
+
+	var firstErr error
+	for _, inst := range instances {
+		logr := logger.FromContext(ctx).
+			WithField("pool", pool.Name).
+			WithField("instance_id", inst.ID).
+			WithField("provider_id", inst.ProviderID)
+
+		destroyErr, ok := failed[inst]
+		if !ok {
+			m.deregisterDNS(ctx, inst)
+			m.events.Publish(events.ForInstance(events.InstanceDestroyed, inst))
+			if !stored {
+				continue
+			}
+			if err := m.Delete(ctx, inst.ID); err != nil {
+				logr.WithError(err).Warnln("manager: failed to delete the destroyed instance from the store")
+			}
+			continue
+		}
+
+		if firstErr == nil {
+			firstErr = destroyErr
+		}
+		if m.destroyRetries == nil {
+			continue
+		}
+		inst.State = types.StateTerminating
+		var err error
+		if stored {
+			err = m.instanceStore.Update(ctx, inst)
+		} else {
+			err = m.instanceStore.Create(ctx, inst)
+		}
+		if err != nil {
+			logr.WithError(err).Errorln("manager: failed to store the instance to retry its destroy")
+			continue
+		}
+		m.queueDestroyRetry(ctx, pool, inst, destroyErr)
+	}
+	return firstErr
+}
+
+// queueDestroyRetry records the failed destroy of the instance, the instance is kept in the
+// store until it's destroyed.
+func (m *Manager) queueDestroyRetry(ctx context.Context, pool *poolEntry, instance *types.Instance, destroyErr error) {
+	if m.destroyRetries == nil {
+		return
+	}
+	// the instance is already queued, the retrier keeps its own schedule.
+	if _, err := m.destroyRetries.Find(ctx, instance.ID); err == nil {
+		return
+	}
+
+	now := time.Now()
+	retry := &types.DestroyRetry{
+		InstanceID:  instance.ID,
+		PoolName:    pool.Name,
+		Attempts:    1,
+		LastError:   destroyErr.Error(),
+		NextAttempt: now.Add(destroyRetryDelay(1)).Unix(),
+		Created:     now.Unix(),
+	}
+	logr := logger.FromContext(ctx).
+		WithField("pool", pool.Name).
+		WithField("instance_id", instance.ID).
+		WithField("provider_id", instance.ProviderID)
+	if err := m.destroyRetries.Create(ctx, retry); err != nil {
+		logr.WithError(err).Errorln("manager: failed to queue the destroy for retry")
+		return
+	}
+	logr.Warnln("manager: destroy failed, queued for retry")
+}
+
+func (m *Manager) retryDestroys(ctx context.Context) {
+	retries, err := m.destroyRetries.List(ctx)
+	if err != nil {
+		logrus.WithError(err).Errorln("manager: failed to list the destroy retries")
+		return
+	}
+
+	now := time.Now()
+	for _, retry := range retries {
+		if retry.NextAttempt > now.Unix() {
+			break
+		}
+		m.retryDestroy(ctx, retry)
+	}
+}
+
+func (m *Manager) retryDestroy(ctx context.Context, retry *types.DestroyRetry) {
+	logr := logrus.
+		WithField("pool", retry.PoolName).
+		WithField("instance_id", retry.InstanceID).
+		WithField("attempts", retry.Attempts)
+
+	instance, err := m.Find(ctx, retry.InstanceID)
+	if err != nil {
+		// the instance was removed from the store by a later destroy or by a clean up.
+		logr.WithError(err).Infoln("manager: instance of the destroy retry not found, dropping the retry")
+		if err = m.destroyRetries.Delete(ctx, retry.InstanceID); err != nil {
+			logr.WithError(err).Errorln("manager: failed to delete the destroy retry")
+		}
+		return
+	}
+	logr = logr.WithField("provider_id", instance.ProviderID)
+
+	pool := m.getPool(retry.PoolName)
+	if pool == nil {
+		err = fmt.Errorf("pool name %q not found", retry.PoolName)
+	} else {
+		err = m.destroyInstances(ctx, pool, []*types.Instance{instance})
+	}
+	if err == nil {
+		m.events.Publish(events.ForInstance(events.InstanceDestroyed, instance))
+		if derr := m.Delete(ctx, instance.ID); derr != nil {
+			logr.WithError(derr).Warnln("manager: failed to delete the destroyed instance from the store")
+		}
+		if derr := m.destroyRetries.Delete(ctx, retry.InstanceID); derr != nil {
+			logr.WithError(derr).Errorln("manager: failed to delete the destroy retry")
+		}
+		logr.Infoln("manager: destroyed instance on retry")
+		return
+	}
+
+	retry.Attempts++
+	retry.LastError = err.Error()
+	retry.NextAttempt = time.Now().Add(destroyRetryDelay(retry.Attempts)).Unix()
+	if uerr := m.destroyRetries.Update(ctx, retry); uerr != nil {
+		logr.WithError(uerr).Errorln("manager: failed to update the destroy retry")
+	}
+
+	logr = logr.WithError(err).WithField("attempts", retry.Attempts)
+	if m.destroyAlertAfter > 0 && retry.Attempts >= m.destroyAlertAfter {
+		logr.WithField("alert", true).
+			Errorln("manager: destroy keeps failing, the instance might be leaked")
+		m.alerter.Raise(alert.Alert{
+			Key:      "destroy:" + retry.PoolName,
+			Severity: alert.SeverityCritical,
+			Summary:  fmt.Sprintf("the destroy of instances of pool %s keeps failing, they might be leaked", retry.PoolName),
+			Pool:     retry.PoolName,
+			Error:    err.Error(),
+		})
+		return
+	}
+	logr.Warnln("manager: destroy retry failed")
+}
+
+// destroyRetryDelay returns the delay before the next destroy after the given number of failed attempts.
+func destroyRetryDelay(attempts int) time.Duration {
+	delay := destroyRetryMinDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= destroyRetryMaxDelay {
+			return destroyRetryMaxDelay
+		}
+	}
+	return delay
+}
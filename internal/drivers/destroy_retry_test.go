@@ -0,0 +1,25 @@
+package drivers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDestroyRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: 30 * time.Second},
+		{attempts: 2, want: time.Minute},
+		{attempts: 4, want: 4 * time.Minute},
+		{attempts: 7, want: 32 * time.Minute},
+		{attempts: 8, want: time.Hour},
+		{attempts: 100, want: time.Hour},
+	}
+	for _, test := range tests {
+		if got := destroyRetryDelay(test.attempts); got != test.want {
+			t.Errorf("Want delay %s after %d attempts, got %s", test.want, test.attempts, got)
+		}
+	}
+}
@@ -62,6 +62,9 @@ func (p *config) Ping(ctx context.Context) error {
 	return err
 }
 
+// nameConstraints are the rules of the droplet names, they are the hostnames of the droplets.
+var nameConstraints = drivers.NameConstraints{MaxLen: 63, Extra: "."} //nolint:gomnd
+
 // Create an AWS instance for the pool, it will not perform build specific setup.
 func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (instance *types.Instance, err error) {
 	startTime := time.Now()
@@ -71,8 +74,16 @@ func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 		WithField("image", p.image).
 		WithField("hibernate", p.CanHibernate())
 	var name = fmt.Sprintf("%s-%s-%s", opts.RunnerName, opts.PoolName, uniuri.NewLen(8)) //nolint:gomnd
+	if opts.Name != "" {
+		name = nameConstraints.Sanitize(opts.Name)
+	}
 	logr.Infof("digitalocean: creating instance %s", name)
 
+	userdata, err := lehelper.GenerateUserdata(p.userData, opts)
+	if err != nil {
+		return nil, err
+	}
+
 	// create a new digitalocean request
 	req := &godo.DropletCreateRequest{
 		Name:     name,
@@ -80,7 +91,7 @@ func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 		Size:     p.size,
 		Tags:     p.tags,
 		IPv6:     false,
-		UserData: lehelper.GenerateUserdata(p.userData, opts),
+		UserData: userdata,
 
 		Image: godo.DropletCreateImage{
 			Slug: p.image,
@@ -134,7 +145,7 @@ func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 		Started:      startTime.Unix(),
 		Updated:      startTime.Unix(),
 		IsHibernated: false,
-		Port:         lehelper.LiteEnginePort,
+		Port:         int64(opts.LiteEnginePort),
 	}
 	// poll the digitalocean endpoint for server updates and exit when a network address is allocated.
 	interval := time.Duration(0)
@@ -159,6 +170,7 @@ poller:
 				return instance, err
 			}
 			instance.ID = fmt.Sprint(droplet.ID)
+			instance.ProviderID = instance.ID
 			for _, network := range droplet.Networks.V4 {
 				if network.Type == "public" {
 					instance.Address = network.IPAddress
@@ -220,20 +232,20 @@ func (p *config) Destroy(ctx context.Context, instances []*types.Instance) (err
 }
 
 func (p *config) Logs(ctx context.Context, instanceID string) (string, error) {
-	return "no logs here", nil
+	return "", drivers.ErrNotSupported
 }
 
 func (p *config) Hibernate(ctx context.Context, instanceID, poolName string) error {
-	return nil
+	return drivers.ErrNotSupported
 }
 
 func (p *config) Start(ctx context.Context, instanceID, poolName string) (string, error) {
-	return "", nil
+	return "", drivers.ErrNotSupported
 }
 
 func (p *config) SetTags(ctx context.Context, instance *types.Instance,
 	tags map[string]string) error {
-	return nil
+	return drivers.ErrNotSupported
 }
 
 // helper function returns a new digitalocean client.
@@ -0,0 +1,79 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/dns"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/drone/runner-go/logger"
+)
+
+// dnsLabel are the rules of the labels of the DNS names of the instances.
+var dnsLabel = NameConstraints{MaxLen: 63, Lower: true} //nolint:gomnd
+
+// SetupDNS creates the registrar of the zone the instances are registered in, if enabled.
+func (m *Manager) SetupDNS(ctx context.Context, env *config.EnvConfig) error {
+	registrar, err := dns.New(ctx, &dns.Config{
+		Provider:          env.DNS.Provider,
+		Zone:              env.DNS.Zone,
+		TTL:               env.DNS.TTL,
+		Route53ZoneID:     env.DNS.Route53ZoneID,
+		GoogleProject:     env.DNS.GoogleProject,
+		GoogleManagedZone: env.DNS.GoogleManagedZone,
+		GoogleJSONPath:    env.DNS.GoogleJSONPath,
+		Server:            env.DNS.Server,
+		TSIGKey:           env.DNS.TSIGKey,
+		TSIGSecret:        env.DNS.TSIGSecret,
+		TSIGAlgorithm:     env.DNS.TSIGAlgorithm,
+	})
+	if err != nil {
+		return err
+	}
+	m.registrar = registrar
+	return nil
+}
+
+// RegisterDNS registers the instance assigned to a stage as <stage>.<pool> in the zone of the runner,
+// the record is deleted when the instance is destroyed.
+func (m *Manager) RegisterDNS(ctx context.Context, instance *types.Instance) error {
+	if m.registrar == nil {
+		return nil
+	}
+	host, addr, err := dnsRecord(instance)
+	if err != nil {
+		return err
+	}
+	return m.registrar.Register(ctx, host, addr)
+}
+
+// deregisterDNS deletes the record of a destroyed instance, the failures are only logged since the
+// records of the stages are replaced when the stage runs again.
+func (m *Manager) deregisterDNS(ctx context.Context, instance *types.Instance) {
+	if m.registrar == nil || instance.Stage == "" {
+		return
+	}
+	host, addr, err := dnsRecord(instance)
+	if err == nil {
+		err = m.registrar.Deregister(ctx, host, addr)
+	}
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).
+			WithField("instance_id", instance.ID).
+			WithField("stage_runtime_id", instance.Stage).
+			Warnln("manager: failed to deregister the instance from dns")
+	}
+}
+
+// dnsRecord returns the host and the address of the record of the instance.
+func dnsRecord(instance *types.Instance) (host string, addr netip.Addr, err error) {
+	if instance.Stage == "" {
+		return "", addr, fmt.Errorf("instance %s is not assigned to a stage", instance.ID)
+	}
+	if addr, err = netip.ParseAddr(instance.Address); err != nil {
+		return "", addr, fmt.Errorf("address of instance %s is not an ip address: %w", instance.ID, err)
+	}
+	return dnsLabel.Sanitize(instance.Stage) + "." + dnsLabel.Sanitize(instance.Pool), addr.Unmap(), nil
+}
@@ -0,0 +1,93 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	itypes "github.com/drone-runners/drone-runner-aws/internal/types"
+	"github.com/drone/runner-go/logger"
+)
+
+// drainPollInterval is how often a draining pool is checked for busy instances.
+var drainPollInterval = 10 * time.Second
+
+// Draining returns true if the pool no longer gets new stages.
+func (m *Manager) Draining(name string) bool {
+	pool := m.getPool(name)
+	return pool != nil && pool.draining.Load()
+}
+
+// DeletePool removes the pool from the manager and destroys its instances. With drain the pool
+// stops getting new stages and the busy instances are waited for, up to the timeout, before the
+// remaining instances are destroyed; without drain the busy instances are destroyed right away.
+func (m *Manager) DeletePool(ctx context.Context, name string, drain bool, timeout time.Duration) error {
+	pool := m.getPool(name)
+	if pool == nil {
+		return itypes.NewNotFoundError(fmt.Sprintf("pool %q not found", name))
+	}
+	if !pool.draining.CompareAndSwap(false, true) {
+		return itypes.NewBadRequestError(fmt.Sprintf("pool %q is already being deleted", name))
+	}
+
+	logr := logger.FromContext(ctx).
+		WithField("pool", name).
+		WithField("drain", drain)
+	logr.Infoln("manager: deleting the pool")
+
+	if drain {
+		if err := m.waitForBusyInstances(ctx, pool, timeout); err != nil {
+			logr.WithError(err).Warnln("manager: the busy instances did not complete, destroying them")
+		}
+	}
+
+	pool.Lock()
+	err := m.cleanPool(ctx, pool, true, true)
+	pool.Unlock()
+	if err != nil {
+		pool.draining.Store(false)
+		return fmt.Errorf("delete pool: failed to destroy the instances of %q pool: %w", name, err)
+	}
+
+	m.poolMu.Lock()
+	if m.poolMap[name] == pool {
+		delete(m.poolMap, name)
+	}
+	m.poolMu.Unlock()
+
+	logr.Infoln("manager: pool deleted")
+	return nil
+}
+
+// waitForBusyInstances returns once the pool has no busy instances, the timeout expires or the
+// context is done.
+func (m *Manager) waitForBusyInstances(ctx context.Context, pool *poolEntry, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		busy, _, _, err := m.List(ctx, pool)
+		if err != nil {
+			return err
+		}
+		// the stages being provisioned get a busy instance too.
+		if len(busy) == 0 && pool.stats.pending.Load() == 0 {
+			return nil
+		}
+		logger.FromContext(ctx).
+			WithField("pool", pool.Name).
+			WithField("busy", len(busy)).
+			Debugln("manager: waiting for the busy instances of the draining pool")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestDeletePoolDrain(t *testing.T) {
+	const pool = "linux"
+
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	drainPollInterval = 10 * time.Millisecond
+	ctx := context.Background()
+	instanceStore := ldb.NewInstanceStore(db)
+	m := New(ctx, instanceStore, &config.EnvConfig{})
+	if err = m.Add(Pool{Name: pool, MaxSize: 2, Driver: failingDriver{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	busy := &types.Instance{ID: "busy", Pool: pool, State: types.StateInUse, Started: time.Now().Unix()}
+	free := &types.Instance{ID: "free", Pool: pool, State: types.StateCreated, Started: time.Now().Unix()}
+	for _, inst := range []*types.Instance{busy, free} {
+		if err = instanceStore.Create(ctx, inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan error)
+	go func() { done <- m.DeletePool(ctx, pool, true, time.Minute) }()
+
+	// the draining pool hands out no instance, even the free one.
+	deadline := time.Now().Add(time.Second)
+	for !m.Draining(pool) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err = m.Provision(ctx, pool, "runner", &config.EnvConfig{}); !errors.Is(err, ErrPoolDraining) {
+		t.Errorf("expected the draining pool to refuse the stage, got %v", err)
+	}
+
+	select {
+	case err = <-done:
+		t.Fatalf("expected the pool to wait for the busy instance, returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the stage completes
+	if err = instanceStore.Delete(ctx, busy.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if m.Exists(pool) {
+		t.Error("expected the pool to be removed")
+	}
+	if _, err = instanceStore.Find(ctx, free.ID); err == nil {
+		t.Error("expected the free instance to be destroyed")
+	}
+}
@@ -0,0 +1,33 @@
+package drivers
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/events"
+)
+
+// SetupEvents starts the bus publishing the lifecycle events of the instances of the pools, and
+// the events of the stages published with Publish, if a bus is set.
+func (m *Manager) SetupEvents(ctx context.Context, env *config.EnvConfig) error {
+	bus, err := events.New(ctx, &events.Config{
+		Runner:   m.runnerName,
+		Type:     env.Events.Type,
+		Endpoint: env.Events.Endpoint,
+		Topic:    env.Events.Topic,
+		Format:   env.Events.Format,
+		Region:   env.Events.Region,
+		Buffer:   env.Events.Buffer,
+	})
+	if err != nil {
+		return err
+	}
+	m.events = bus
+	m.events.Start(ctx)
+	return nil
+}
+
+// Publish publishes the event to the bus of the runner, it's a no-op without a bus.
+func (m *Manager) Publish(e *events.Event) {
+	m.events.Publish(e)
+}
@@ -0,0 +1,94 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/lehelper"
+	"github.com/drone-runners/drone-runner-aws/internal/oshelp"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/drone/runner-go/logger"
+	"github.com/harness/lite-engine/api"
+)
+
+// Finalize waits for the work left on the instance of a completed stage, e.g. the uploads of the
+// artifacts and of the caches, before the instance is destroyed. The finalize script of the pool
+// runs on the instance and Finalize returns once it exits, at most after the destroy grace period
+// of the pool. Without a script Finalize waits for the whole grace period.
+func (m *Manager) Finalize(ctx context.Context, poolName string, inst *types.Instance) {
+	pool := m.getPool(poolName)
+	if pool == nil || pool.DestroyGracePeriod <= 0 {
+		return
+	}
+	logr := logger.FromContext(ctx).
+		WithField("pool", poolName).
+		WithField("instance_id", inst.ID).
+		WithField("grace_period", pool.DestroyGracePeriod)
+
+	ctx, cancel := context.WithTimeout(ctx, pool.DestroyGracePeriod)
+	defer cancel()
+
+	start := time.Now()
+	if pool.FinalizeScript == "" {
+		logr.Infoln("finalize: waiting for the grace period before the destroy")
+		<-ctx.Done()
+		return
+	}
+	err := m.runFinalizeScript(ctx, &pool.Pool, inst)
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		logr.Warnln("finalize: the finalize script did not complete within the grace period, destroying the instance")
+	case err != nil:
+		logr.WithError(err).Warnln("finalize: the finalize script failed, destroying the instance")
+	default:
+		logr.WithField("duration", time.Since(start)).Infoln("finalize: the finalize script completed")
+	}
+}
+
+// runFinalizeScript runs the finalize script of the pool on the instance through its lite-engine.
+func (m *Manager) runFinalizeScript(ctx context.Context, pool *Pool, inst *types.Instance) error {
+	_, err := m.runScript(ctx, pool, inst, "finalize", pool.FinalizeScript, "", pool.DestroyGracePeriod)
+	return err
+}
+
+// runScript runs a script on the instance through its lite-engine and waits until it exits, at
+// most the timeout. Its output goes to the log key, if set. It returns the exit code of the script.
+func (m *Manager) runScript(ctx context.Context, pool *Pool, inst *types.Instance, name, script, logKey string, timeout time.Duration) (int, error) {
+	port := inst.Port
+	if port == 0 {
+		port = int64(liteEnginePort(pool))
+	}
+	client, err := lehelper.GetClient(inst, m.runnerName, port, false, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	req := &api.StartStepRequest{
+		ID:     name + "-" + oshelp.Random(),
+		Name:   name,
+		Kind:   api.Run,
+		LogKey: logKey,
+	}
+	req.Run.Entrypoint, req.Run.Command = scriptCommand(inst.Platform.OS, script)
+	if _, err = client.StartStep(ctx, req); err != nil {
+		return 0, fmt.Errorf("could not start the %s script: %w", name, err)
+	}
+	resp, err := client.RetryPollStep(ctx, &api.PollStepRequest{ID: req.ID}, timeout)
+	if err != nil {
+		return 0, err
+	}
+	if resp.Error != "" || resp.ExitCode != 0 {
+		return resp.ExitCode, fmt.Errorf("the %s script failed with exit code %d: %s", name, resp.ExitCode, resp.Error)
+	}
+	return 0, nil
+}
+
+// scriptCommand returns the command running a script of the pool, e.g. the finalize script.
+func scriptCommand(os, script string) (entrypoint, command []string) {
+	if os == oshelp.OSWindows {
+		return []string{"powershell"}, []string{script}
+	}
+	return []string{"sh", "-c"}, []string{script}
+}
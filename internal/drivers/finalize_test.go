@@ -0,0 +1,55 @@
+package drivers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestFinalize(t *testing.T) {
+	const grace = 50 * time.Millisecond
+
+	ctx := context.Background()
+	m := New(ctx, nil, &config.EnvConfig{})
+	pools := []Pool{
+		{Name: "none", Driver: failingDriver{}},
+		{Name: "grace", DestroyGracePeriod: grace, Driver: failingDriver{}},
+		// the lite-engine of the instance is not reachable, the script fails right away
+		{Name: "script", DestroyGracePeriod: time.Minute, FinalizeScript: "true", Driver: failingDriver{}},
+	}
+	for i := range pools {
+		if err := m.Add(pools[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inst := &types.Instance{ID: "instance", Address: "127.0.0.1", Port: 1}
+
+	start := time.Now()
+	m.Finalize(ctx, "none", inst)
+	if d := time.Since(start); d >= grace {
+		t.Errorf("expected no wait without a grace period, waited %s", d)
+	}
+
+	start = time.Now()
+	m.Finalize(ctx, "grace", inst)
+	if d := time.Since(start); d < grace {
+		t.Errorf("expected to wait for the grace period without a script, waited %s", d)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	start = time.Now()
+	m.Finalize(cancelled, "grace", inst)
+	if d := time.Since(start); d >= grace {
+		t.Errorf("expected no wait once the context is done, waited %s", d)
+	}
+
+	start = time.Now()
+	m.Finalize(ctx, "script", inst)
+	if d := time.Since(start); d >= 10*time.Second {
+		t.Errorf("expected no wait once the finalize script failed, waited %s", d)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net/http"
+	"os"
 	"reflect"
 	"strconv"
 	"strings"
@@ -19,13 +20,18 @@ import (
 
 	"github.com/dchest/uniuri"
 	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iam/v1"
 	"google.golang.org/api/option"
+	"google.golang.org/api/secretmanager/v1"
 )
 
 const (
 	maxInstanceNameLen = 63
+	maxLabelValueLen   = 63
 	randStrLen         = 5
 	tagRetries         = 3
 	getRetries         = 3
@@ -35,6 +41,12 @@ const (
 	tagRetrySleepMs    = 50
 )
 
+// labels identifying the runner and the pool of an instance.
+const (
+	labelRunner = "drone-runner"
+	labelPool   = "drone-runner-pool"
+)
+
 var (
 	defaultTags = []string{
 		"allow-docker",
@@ -61,11 +73,16 @@ type config struct {
 	diskSize            int64
 	diskType            string
 	hibernate           bool
+	standby             bool
+	imageMu             sync.RWMutex
 	image               string
+	imageFamily         string // project/family of the images, the latest one is the image
+	imageResolved       bool   // the latest image of the family was looked up
 	network             string
 	noServiceAccount    bool
 	subnetwork          string
 	privateIP           bool
+	ipFamily            types.IPFamily
 	scopes              []string
 	serviceAccountEmail string
 	size                string
@@ -74,6 +91,12 @@ type config struct {
 	userData            string
 	userDataKey         string
 	service             *compute.Service
+	tokens              oauth2.TokenSource     // the credentials of the service, nil if the service is set by an option
+	secrets             *secretmanager.Service // the secrets of the TLS keys of the pools with the secret-manager key delivery
+	iam                 *iam.Service           // the service account is checked with it
+
+	// zones blacklisted after repeated capacity failures
+	blacklist drivers.Blacklist
 }
 
 func New(opts ...Option) (drivers.Driver, error) {
@@ -85,15 +108,31 @@ func New(opts ...Option) (drivers.Driver, error) {
 	ctx := context.Background()
 	var err error
 	if p.service == nil {
+		var creds *google.Credentials
 		if p.JSONPath != "" {
-			p.service, err = compute.NewService(ctx, option.WithCredentialsFile(p.JSONPath))
+			var b []byte
+			if b, err = os.ReadFile(p.JSONPath); err != nil {
+				return nil, err
+			}
+			creds, err = google.CredentialsFromJSON(ctx, b, compute.CloudPlatformScope)
 		} else {
-			p.service, err = compute.NewService(ctx)
+			creds, err = google.FindDefaultCredentials(ctx, compute.CloudPlatformScope)
 		}
-
 		if err != nil {
 			return nil, err
 		}
+		// the token source is shared with the health check, a token refreshed by the check is
+		// used by the service.
+		p.tokens = creds.TokenSource
+		if p.service, err = compute.NewService(ctx, option.WithCredentials(creds)); err != nil {
+			return nil, err
+		}
+		if p.secrets, err = secretmanager.NewService(ctx, option.WithCredentials(creds)); err != nil {
+			return nil, err
+		}
+		if p.iam, err = iam.NewService(ctx, option.WithCredentials(creds)); err != nil {
+			return nil, err
+		}
 	}
 	return p, nil
 }
@@ -102,7 +141,14 @@ func (p *config) RootDir() string {
 	return p.rootDir
 }
 
+// RandomZone returns a random zone, skipping the zones blacklisted after repeated capacity failures.
+// If all the zones are blacklisted any of them is returned.
 func (p *config) RandomZone() string {
+	for _, i := range rand.Perm(len(p.zones)) { //nolint: gosec
+		if p.blacklist.Allowed(p.zones[i]) {
+			return p.zones[i]
+		}
+	}
 	return p.zones[rand.Intn(len(p.zones))] //nolint: gosec
 }
 
@@ -116,11 +162,23 @@ func (p *config) DriverName() string {
 }
 
 func (p *config) InstanceType() string {
-	return p.image
+	return p.currentImage()
+}
+
+func (p *config) CanRunUntrusted() bool {
+	return true
 }
 
 func (p *config) CanHibernate() bool {
-	return p.hibernate
+	return p.hibernate || p.standby
+}
+
+func (p *config) Capabilities() drivers.Capabilities {
+	return drivers.Capabilities{
+		ConsoleLogs: true,
+		Tags:        true,
+		Zones:       true,
+	}
 }
 
 func (p *config) Logs(ctx context.Context, instance string) (string, error) {
@@ -153,12 +211,26 @@ func (p *config) Ping(ctx context.Context) error {
 	return errors.New("unable to ping google")
 }
 
+// CheckHealth gets a token of the credentials, refreshed if it expired, and lists the regions
+// of the project with it.
+func (p *config) CheckHealth(ctx context.Context) error {
+	if p.tokens != nil {
+		if _, err := p.tokens.Token(); err != nil {
+			return fmt.Errorf("could not refresh the token of the google credentials: %w", err)
+		}
+	}
+	return p.Ping(ctx)
+}
+
 func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (instance *types.Instance, err error) {
 	p.init.Do(func() {
-		_ = p.setup(ctx)
+		_ = p.setup(ctx, opts.LiteEnginePort)
 	})
 
 	var name = getInstanceName(opts.RunnerName, opts.PoolName)
+	if opts.Name != "" {
+		name = nameConstraints.Sanitize(opts.Name)
+	}
 	inst, err := p.create(ctx, opts, name)
 	if err != nil {
 		defer p.Destroy(context.Background(), []*types.Instance{{ID: name}}) //nolint:errcheck
@@ -169,14 +241,18 @@ func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 
 func (p *config) create(ctx context.Context, opts *types.InstanceCreateOpts, name string) (instance *types.Instance, err error) {
 	zone := p.RandomZone()
+	// the instance records the image it was created from, the image of the pool might be resolved again meanwhile
+	image, err := p.imageToCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	logr := logger.FromContext(ctx).
 		WithField("cloud", types.Google).
 		WithField("name", name).
-		WithField("image", p.InstanceType()).
 		WithField("pool", opts.PoolName).
 		WithField("zone", zone).
-		WithField("image", p.image).
+		WithField("image", image).
 		WithField("size", p.size)
 
 	// create the instance
@@ -186,7 +262,7 @@ func (p *config) create(ctx context.Context, opts *types.InstanceCreateOpts, nam
 
 	networkConfig := []*compute.AccessConfig{}
 
-	if !p.privateIP {
+	if !p.privateIP && p.ipFamily != types.IPv6 {
 		networkConfig = []*compute.AccessConfig{
 			{
 				Name: "External NAT",
@@ -215,6 +291,14 @@ func (p *config) create(ctx context.Context, opts *types.InstanceCreateOpts, nam
 		}
 	}
 
+	// instances in standby are stopped and started again, the lite-engine must come back on every boot.
+	userdataOpts := *opts
+	userdataOpts.Persistent = opts.Persistent || p.standby
+	userdata, err := lehelper.GenerateUserdata(p.userData, &userdataOpts)
+	if err != nil {
+		return nil, err
+	}
+
 	in := &compute.Instance{
 		Name:           name,
 		Zone:           fmt.Sprintf("projects/%s/zones/%s", p.projectID, zone),
@@ -224,7 +308,7 @@ func (p *config) create(ctx context.Context, opts *types.InstanceCreateOpts, nam
 			Items: []*compute.MetadataItems{
 				{
 					Key:   p.userDataKey,
-					Value: googleapi.String(lehelper.GenerateUserdata(p.userData, opts)),
+					Value: googleapi.String(userdata),
 				},
 			},
 		},
@@ -236,7 +320,7 @@ func (p *config) create(ctx context.Context, opts *types.InstanceCreateOpts, nam
 				AutoDelete: true,
 				DeviceName: opts.PoolName,
 				InitializeParams: &compute.AttachedDiskInitializeParams{
-					SourceImage: fmt.Sprintf("https://www.googleapis.com/compute/v1/projects/%s", p.image),
+					SourceImage: fmt.Sprintf("https://www.googleapis.com/compute/v1/projects/%s", image),
 					DiskType:    fmt.Sprintf("projects/%s/zones/%s/diskTypes/%s", p.projectID, zone, p.diskType),
 					DiskSizeGb:  p.diskSize,
 				},
@@ -259,8 +343,30 @@ func (p *config) create(ctx context.Context, opts *types.InstanceCreateOpts, nam
 		Tags: &compute.Tags{
 			Items: p.tags,
 		},
+		// the janitor finds the leaked instances of the pool by these labels
+		Labels: map[string]string{
+			labelRunner: labelValue(opts.RunnerName),
+			labelPool:   labelValue(opts.PoolName),
+		},
 	}
-	if !p.noServiceAccount {
+	// IPv6 instances keep the internal IPv4 address, only the external IPv4 address is charged.
+	if p.ipv6() {
+		in.NetworkInterfaces[0].StackType = "IPV4_IPV6"
+		if !p.privateIP {
+			in.NetworkInterfaces[0].Ipv6AccessConfigs = []*compute.AccessConfig{
+				{
+					Name:        "External IPv6",
+					Type:        "DIRECT_IPV6",
+					NetworkTier: "PREMIUM",
+				},
+			}
+		}
+	}
+	// untrusted instances run without a service account, the network tags of the profile select the egress restricted firewall rules.
+	if opts.Untrusted != nil && len(opts.Untrusted.SecurityGroups) > 0 {
+		in.Tags.Items = append(append([]string{}, p.tags...), opts.Untrusted.SecurityGroups...)
+	}
+	if !p.noServiceAccount && opts.Untrusted == nil {
 		in.ServiceAccounts = []*compute.ServiceAccount{
 			{
 				Scopes: p.scopes,
@@ -272,15 +378,18 @@ func (p *config) create(ctx context.Context, opts *types.InstanceCreateOpts, nam
 	requestID := uuid.New().String()
 	op, err := p.insertInstance(ctx, p.projectID, zone, requestID, in)
 	if err != nil {
+		p.blacklist.Failed(zone, err)
 		logr.WithError(err).Errorln("google: failed to provision VM")
 		return nil, err
 	}
 
 	err = p.waitZoneOperation(ctx, op.Name, zone)
 	if err != nil {
+		p.blacklist.Failed(zone, err)
 		logr.WithError(err).Errorln("instance insert operation failed")
 		return nil, err
 	}
+	p.blacklist.Succeeded(zone)
 
 	logr.Debugln("instance insert operation completed")
 
@@ -296,6 +405,7 @@ func (p *config) create(ctx context.Context, opts *types.InstanceCreateOpts, nam
 	}
 
 	instanceMap := p.mapToInstance(vm, zone, opts)
+	instanceMap.Image = image
 	logr.
 		WithField("ip", instanceMap.Address).
 		WithField("time", fmt.Sprintf("%.2fs", time.Since(startTime).Seconds())).
@@ -347,34 +457,35 @@ func (p *config) setTags(ctx context.Context, instance *types.Instance,
 }
 
 func (p *config) Destroy(ctx context.Context, instances []*types.Instance) (err error) {
-	var instanceIDs []string
-	for _, instance := range instances {
-		instanceIDs = append(instanceIDs, instance.ID)
-	}
-	if len(instanceIDs) == 0 {
+	if len(instances) == 0 {
 		return errors.New("no instance IDs provided")
 	}
 
-	for _, instanceID := range instanceIDs {
+	for _, instance := range instances {
+		instanceID := instance.ID
 		logr := logger.FromContext(ctx).
 			WithField("id", instanceID).
 			WithField("cloud", types.Google)
-		zone, err := p.findInstanceZone(ctx, instanceID)
-		if err != nil {
-			logr.WithError(err).Errorln("google: failed to find instance")
-			continue
+		zone := instance.Zone
+		if zone == "" {
+			var findErr error
+			if zone, findErr = p.findInstanceZone(ctx, instanceID); findErr != nil {
+				logr.WithError(findErr).Errorln("google: failed to find instance")
+				err = fmt.Errorf("failed to find the zone of instance %s: %w", instanceID, findErr)
+				continue
+			}
 		}
 
 		requestID := uuid.New().String()
 
-		_, err = p.deleteInstance(ctx, p.projectID, zone, instanceID, requestID)
-		if err != nil {
+		_, delErr := p.deleteInstance(ctx, p.projectID, zone, instanceID, requestID)
+		if delErr != nil {
 			// https://github.com/googleapis/google-api-go-client/blob/master/googleapi/googleapi.go#L135
-			if gerr, ok := err.(*googleapi.Error); ok &&
+			if gerr, ok := delErr.(*googleapi.Error); ok &&
 				gerr.Code == http.StatusNotFound {
-				logr.WithError(err).Errorln("google: VM not found")
+				logr.WithError(delErr).Errorln("google: VM not found")
 			} else {
-				logr.WithError(err).Errorln("google: failed to delete the VM")
+				logr.WithError(delErr).Errorln("google: failed to delete the VM")
 			}
 		}
 		logr.Info("google: sent delete instance request")
@@ -382,6 +493,71 @@ func (p *config) Destroy(ctx context.Context, instances []*types.Instance) (err
 	return
 }
 
+// CleanupHosts deletes the instances labeled with the runner and the pool which are not live instances.
+func (p *config) CleanupHosts(ctx context.Context, runnerName, poolName string, live []*types.Instance) error {
+	liveSet := drivers.LiveSet(live)
+	filter := fmt.Sprintf("labels.%s = %q AND labels.%s = %q", labelRunner, labelValue(runnerName), labelPool, labelValue(poolName))
+
+	for _, zone := range p.zones {
+		var leaked []string
+		err := p.service.Instances.List(p.projectID, zone).Filter(filter).Pages(ctx, func(list *compute.InstanceList) error {
+			for _, vm := range list.Items {
+				created, _ := time.Parse(time.RFC3339, vm.CreationTimestamp)
+				if drivers.IsLeaked(strconv.FormatUint(vm.Id, 10), created, liveSet) {
+					leaked = append(leaked, vm.Name)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list the instances of the pool in zone %s: %w", zone, err)
+		}
+
+		for _, name := range leaked {
+			logr := logger.FromContext(ctx).
+				WithField("cloud", types.Google).
+				WithField("pool", poolName).
+				WithField("zone", zone).
+				WithField("name", name)
+			if _, err := p.deleteInstance(ctx, p.projectID, zone, name, uuid.New().String()); err != nil {
+				logr.WithError(err).Errorln("google: failed to delete leaked VM")
+				continue
+			}
+			logr.Infoln("google: deleted leaked VM")
+		}
+	}
+	return nil
+}
+
+// Inventory returns the instances labelled with the runner and the pool in the zones of the pool, see
+// drivers.Inventory.
+func (p *config) Inventory(ctx context.Context, opts *types.InstanceCreateOpts) ([]*types.Instance, error) {
+	filter := fmt.Sprintf("labels.%s = %q AND labels.%s = %q", labelRunner, labelValue(opts.RunnerName), labelPool, labelValue(opts.PoolName))
+
+	var instances []*types.Instance
+	for _, zone := range p.zones {
+		err := p.service.Instances.List(p.projectID, zone).Filter(filter).Pages(ctx, func(list *compute.InstanceList) error {
+			for _, vm := range list.Items {
+				if len(vm.NetworkInterfaces) == 0 {
+					continue
+				}
+				inst := p.mapToInstance(vm, zone, opts)
+				// the standby instances are stopped, they are terminated until started again
+				switch vm.Status {
+				case "STOPPING", "TERMINATED", "SUSPENDING", "SUSPENDED":
+					inst.IsHibernated = true
+				}
+				instances = append(instances, &inst)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the instances of the pool in zone %s: %w", zone, err)
+		}
+	}
+	return instances, nil
+}
+
 func (p *config) Hibernate(ctx context.Context, instanceID, _ string) error {
 	logr := logger.FromContext(ctx).
 		WithField("id", instanceID).
@@ -392,7 +568,13 @@ func (p *config) Hibernate(ctx context.Context, instanceID, _ string) error {
 		return err
 	}
 
-	op, err := p.suspendInstance(ctx, p.projectID, zone, instanceID)
+	var op *compute.Operation
+	if p.hibernate {
+		op, err = p.suspendInstance(ctx, p.projectID, zone, instanceID)
+	} else {
+		// standby instances are stopped, they boot from the disk on start.
+		op, err = p.stopInstance(ctx, p.projectID, zone, instanceID)
+	}
 	if err != nil {
 		logr.WithError(err).Errorln("google: failed to suspend VM")
 		return err
@@ -420,11 +602,15 @@ func (p *config) Start(ctx context.Context, instanceID, _ string) (string, error
 	if err != nil {
 		return "", err
 	}
-	if vm.Status != "SUSPENDED" {
+	var op *compute.Operation
+	switch vm.Status {
+	case "SUSPENDED":
+		op, err = p.resumeInstance(ctx, p.projectID, zone, instanceID)
+	case "TERMINATED":
+		op, err = p.startInstance(ctx, p.projectID, zone, instanceID)
+	default:
 		return p.getInstanceIP(vm), nil
 	}
-
-	op, err := p.resumeInstance(ctx, p.projectID, zone, instanceID)
 	if err != nil {
 		logr.WithError(err).Errorln("google: failed to suspend VM")
 		return "", err
@@ -444,6 +630,57 @@ func (p *config) Start(ctx context.Context, instanceID, _ string) (string, error
 	return p.getInstanceIP(vm), nil
 }
 
+// ResolveAddress returns the current addresses of the instance, the ephemeral external address of
+// an instance changes when it is resumed or started again.
+func (p *config) ResolveAddress(ctx context.Context, instance *types.Instance) (address, privateAddress string, err error) {
+	zone := instance.Zone
+	if zone == "" {
+		if zone, err = p.findInstanceZone(ctx, instance.ID); err != nil {
+			return "", "", err
+		}
+	}
+	vm, err := p.getInstance(ctx, p.projectID, zone, instance.ID)
+	if err != nil {
+		return "", "", err
+	}
+	if len(vm.NetworkInterfaces) == 0 {
+		return "", "", nil
+	}
+	return p.getInstanceIP(vm), vm.NetworkInterfaces[0].NetworkIP, nil
+}
+
+// Resize stops the instance, changes its machine type and starts it again. The instance is stopped,
+// not suspended: the machine type of a suspended instance can't be changed.
+func (p *config) Resize(ctx context.Context, instance *types.Instance, size string) (string, error) {
+	logr := logger.FromContext(ctx).
+		WithField("id", instance.ID).
+		WithField("cloud", types.Google).
+		WithField("size", size)
+
+	zone, err := p.findInstanceZone(ctx, instance.ID)
+	if err != nil {
+		return "", err
+	}
+	op, err := p.stopInstance(ctx, p.projectID, zone, instance.ID)
+	if err == nil {
+		err = p.waitZoneOperation(ctx, op.Name, zone)
+	}
+	if err != nil {
+		logr.WithError(err).Errorln("google: failed to stop VM")
+		return "", err
+	}
+	op, err = p.setMachineType(ctx, p.projectID, zone, instance.ID, size)
+	if err == nil {
+		err = p.waitZoneOperation(ctx, op.Name, zone)
+	}
+	if err != nil {
+		logr.WithError(err).Errorln("google: failed to change the machine type of VM")
+		return "", err
+	}
+	logr.Traceln("google: VM resized")
+	return p.Start(ctx, instance.ID, instance.Pool)
+}
+
 func (p *config) getInstance(ctx context.Context, projectID, zone, name string) (*compute.Instance, error) {
 	return retry(ctx, getRetries, secSleep, func() (*compute.Instance, error) {
 		return p.service.Instances.Get(projectID, zone, name).Context(ctx).Do()
@@ -451,14 +688,23 @@ func (p *config) getInstance(ctx context.Context, projectID, zone, name string)
 }
 
 func (p *config) getInstanceIP(i *compute.Instance) string {
-	instanceIP := ""
 	network := i.NetworkInterfaces[0]
-	if p.privateIP {
-		instanceIP = network.NetworkIP
-	} else if len(network.AccessConfigs) > 0 {
-		instanceIP = network.AccessConfigs[0].NatIP
+	ipv4, ipv6 := network.NetworkIP, network.Ipv6Address
+	if !p.privateIP {
+		ipv4 = ""
+		if len(network.AccessConfigs) > 0 {
+			ipv4 = network.AccessConfigs[0].NatIP
+		}
+		if len(network.Ipv6AccessConfigs) > 0 {
+			ipv6 = network.Ipv6AccessConfigs[0].ExternalIpv6
+		}
 	}
-	return instanceIP
+	return drivers.SelectAddress(p.ipFamily, ipv4, ipv6)
+}
+
+// ipv6 reports whether the instances get an IPv6 address.
+func (p *config) ipv6() bool {
+	return p.ipFamily == types.DualStack || p.ipFamily == types.IPv6
 }
 
 func (p *config) suspendInstance(ctx context.Context, projectID, zone, name string) (*compute.Operation, error) {
@@ -473,6 +719,27 @@ func (p *config) resumeInstance(ctx context.Context, projectID, zone, name strin
 	})
 }
 
+func (p *config) stopInstance(ctx context.Context, projectID, zone, name string) (*compute.Operation, error) {
+	return retry(ctx, getRetries, secSleep, func() (*compute.Operation, error) {
+		return p.service.Instances.Stop(projectID, zone, name).Context(ctx).Do()
+	})
+}
+
+func (p *config) startInstance(ctx context.Context, projectID, zone, name string) (*compute.Operation, error) {
+	return retry(ctx, getRetries, secSleep, func() (*compute.Operation, error) {
+		return p.service.Instances.Start(projectID, zone, name).Context(ctx).Do()
+	})
+}
+
+func (p *config) setMachineType(ctx context.Context, projectID, zone, name, size string) (*compute.Operation, error) {
+	in := &compute.InstancesSetMachineTypeRequest{
+		MachineType: fmt.Sprintf("projects/%s/zones/%s/machineTypes/%s", projectID, zone, size),
+	}
+	return retry(ctx, getRetries, secSleep, func() (*compute.Operation, error) {
+		return p.service.Instances.SetMachineType(projectID, zone, name, in).Context(ctx).Do()
+	})
+}
+
 func (p *config) insertInstance(ctx context.Context, projectID, zone, requestID string, in *compute.Instance) (*compute.Operation, error) {
 	return retry(ctx, insertRetries, secSleep, func() (*compute.Operation, error) {
 		return p.service.Instances.Insert(projectID, zone, in).RequestId(requestID).Context(ctx).Do()
@@ -486,34 +753,30 @@ func (p *config) deleteInstance(ctx context.Context, projectID, zone, instanceID
 }
 
 func (p *config) mapToInstance(vm *compute.Instance, zone string, opts *types.InstanceCreateOpts) types.Instance {
-	network := vm.NetworkInterfaces[0]
-	instanceIP := ""
-	if p.privateIP {
-		instanceIP = network.NetworkIP
-	} else {
-		instanceIP = network.AccessConfigs[0].NatIP
-	}
+	instanceIP := p.getInstanceIP(vm)
 
 	started, _ := time.Parse(time.RFC3339, vm.CreationTimestamp)
 	return types.Instance{
-		ID:           strconv.FormatUint(vm.Id, 10),
-		Name:         vm.Name,
-		Provider:     types.Google, // this is driver, though its the old legacy name of provider
-		State:        types.StateCreated,
-		Pool:         opts.PoolName,
-		Image:        p.image,
-		Zone:         zone,
-		Size:         p.size,
-		Platform:     opts.Platform,
-		Address:      instanceIP,
-		CACert:       opts.CACert,
-		CAKey:        opts.CAKey,
-		TLSCert:      opts.TLSCert,
-		TLSKey:       opts.TLSKey,
-		Started:      started.Unix(),
-		Updated:      time.Now().Unix(),
-		IsHibernated: false,
-		Port:         lehelper.LiteEnginePort,
+		ID:             strconv.FormatUint(vm.Id, 10),
+		Name:           vm.Name,
+		ProviderID:     vm.SelfLink,
+		Provider:       types.Google, // this is driver, though its the old legacy name of provider
+		State:          types.StateCreated,
+		Pool:           opts.PoolName,
+		Image:          p.currentImage(),
+		Zone:           zone,
+		Size:           p.size,
+		Platform:       opts.Platform,
+		Address:        instanceIP,
+		CACert:         opts.CACert,
+		CAKey:          opts.CAKey,
+		TLSCert:        opts.TLSCert,
+		TLSKey:         opts.TLSKey,
+		Started:        started.Unix(),
+		Updated:        time.Now().Unix(),
+		IsHibernated:   false,
+		Port:           int64(opts.LiteEnginePort),
+		PrivateAddress: vm.NetworkInterfaces[0].NetworkIP,
 	}
 }
 
@@ -566,19 +829,36 @@ func (p *config) waitZoneOperation(ctx context.Context, name, zone string) error
 	}
 }
 
-func (p *config) setup(ctx context.Context) error {
+func (p *config) setup(ctx context.Context, liteEnginePort int) error {
 	if reflect.DeepEqual(p.tags, defaultTags) {
-		return p.setupFirewall(ctx)
+		return p.setupFirewall(ctx, liteEnginePort)
 	}
 	return nil
 }
 
-func (p *config) setupFirewall(ctx context.Context) error {
-	logr := logger.FromContext(ctx)
+// setupFirewall creates the firewall rule allowing the traffic to the lite-engine on the port of the pool.
+func (p *config) setupFirewall(ctx context.Context, liteEnginePort int) error {
+	// the default rule is kept for the pools using the default port, a rule is created for any other port.
+	name := "default-allow-docker"
+	if liteEnginePort != lehelper.LiteEnginePort {
+		name = fmt.Sprintf("default-allow-lite-engine-%d", liteEnginePort)
+	}
+	if err := p.setupFirewallRule(ctx, name, liteEnginePort, "0.0.0.0/0"); err != nil {
+		return err
+	}
+	// a rule can't mix IPv4 and IPv6 source ranges.
+	if p.ipv6() {
+		return p.setupFirewallRule(ctx, name+"-ipv6", liteEnginePort, "::/0")
+	}
+	return nil
+}
+
+func (p *config) setupFirewallRule(ctx context.Context, name string, liteEnginePort int, sourceRange string) error {
+	logr := logger.FromContext(ctx).WithField("rule", name)
 
 	logr.Debugln("finding default firewall rules")
 
-	_, err := p.service.Firewalls.Get(p.projectID, "default-allow-docker").Context(ctx).Do()
+	_, err := p.service.Firewalls.Get(p.projectID, name).Context(ctx).Do()
 	if err == nil {
 		logr.Debugln("found default firewall rule")
 		return nil
@@ -588,14 +868,14 @@ func (p *config) setupFirewall(ctx context.Context) error {
 		Allowed: []*compute.FirewallAllowed{
 			{
 				IPProtocol: "tcp",
-				Ports:      []string{"2376", fmt.Sprint(lehelper.LiteEnginePort)},
+				Ports:      []string{"2376", fmt.Sprint(liteEnginePort)},
 			},
 		},
 		Direction:    "INGRESS",
-		Name:         "default-allow-docker",
+		Name:         name,
 		Network:      p.network,
 		Priority:     1000,
-		SourceRanges: []string{"0.0.0.0/0"},
+		SourceRanges: []string{sourceRange},
 		TargetTags:   []string{"allow-docker"},
 	}
 
@@ -635,6 +915,9 @@ func (p *config) waitGlobalOperation(ctx context.Context, name string) error {
 	}
 }
 
+// nameConstraints are the rules of the instance names, see getInstanceName.
+var nameConstraints = drivers.NameConstraints{MaxLen: maxInstanceNameLen, Lower: true, LetterFirst: true}
+
 // instance name must be 1-63 characters long and match the regular expression
 // [a-z]([-a-z0-9]*[a-z0-9])?
 func getInstanceName(runner, pool string) string {
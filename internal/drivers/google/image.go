@@ -0,0 +1,49 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// currentImage returns the image the new instances are created from.
+func (p *config) currentImage() string {
+	p.imageMu.RLock()
+	defer p.imageMu.RUnlock()
+	return p.image
+}
+
+// ResolveImage looks up the latest image of the image family of the pool, the new instances are
+// created from it. The fixed image of a pool without a family is returned as is.
+func (p *config) ResolveImage(ctx context.Context) (string, error) {
+	if p.imageFamily == "" {
+		return p.currentImage(), nil
+	}
+	project, family, ok := strings.Cut(p.imageFamily, "/")
+	if !ok {
+		project, family = p.projectID, p.imageFamily
+	}
+	img, err := p.service.Images.GetFromFamily(project, family).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("google: failed to look up the image family %s: %w", p.imageFamily, err)
+	}
+	image := fmt.Sprintf("%s/global/images/%s", project, img.Name)
+
+	p.imageMu.Lock()
+	defer p.imageMu.Unlock()
+	p.image = image
+	p.imageResolved = true
+	return image, nil
+}
+
+// imageToCreate returns the image of a new instance, the image is looked up first if it was
+// never resolved, e.g. the lookup failed when the pool was built.
+func (p *config) imageToCreate(ctx context.Context) (string, error) {
+	p.imageMu.RLock()
+	image, resolved := p.image, p.imageResolved
+	p.imageMu.RUnlock()
+	if p.imageFamily == "" || resolved {
+		return image, nil
+	}
+	return p.ResolveImage(ctx)
+}
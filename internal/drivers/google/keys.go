@@ -0,0 +1,57 @@
+package google
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/drone-runners/drone-runner-aws/internal/keydelivery"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/secretmanager/v1"
+)
+
+// PublishKey stores the TLS key of a new instance in a secret of the project, the instance reads it
+// with its service account. The secret expires with the key server TTL if it is not revoked.
+func (p *config) PublishKey(ctx context.Context, ref string, key []byte) (string, error) {
+	if p.secrets == nil {
+		return "", errors.New("google: the secret manager client is not set up")
+	}
+	id := keydelivery.SecretID(ref)
+	secret := &secretmanager.Secret{
+		Replication: &secretmanager.Replication{Automatic: &secretmanager.Automatic{}},
+		Ttl:         fmt.Sprintf("%ds", int64(keydelivery.TTL.Seconds())),
+	}
+	if _, err := p.secrets.Projects.Secrets.Create("projects/"+p.projectID, secret).SecretId(id).Context(ctx).Do(); err != nil {
+		return "", err
+	}
+	// the payload of the secret is the encoded key, the fetch command decodes it.
+	payload := base64.StdEncoding.EncodeToString([]byte(base64.StdEncoding.EncodeToString(key)))
+	_, err := p.secrets.Projects.Secrets.AddVersion(p.secretName(ref), &secretmanager.AddSecretVersionRequest{
+		Payload: &secretmanager.SecretPayload{Data: payload},
+	}).Context(ctx).Do()
+	if err != nil {
+		_ = p.RevokeKey(context.Background(), ref)
+		return "", err
+	}
+	return keydelivery.SecretManagerFetch(p.projectID, ref), nil
+}
+
+// RevokeKey deletes the secret of the key, a secret already deleted or expired is not an error.
+func (p *config) RevokeKey(ctx context.Context, ref string) error {
+	if p.secrets == nil {
+		return errors.New("google: the secret manager client is not set up")
+	}
+	_, err := p.secrets.Projects.Secrets.Delete(p.secretName(ref)).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+func (p *config) secretName(ref string) string {
+	return fmt.Sprintf("projects/%s/secrets/%s", p.projectID, keydelivery.SecretID(ref))
+}
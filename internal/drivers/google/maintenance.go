@@ -0,0 +1,96 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// maintenanceOperation is a system operation of GCP on an instance, see ScheduledEvents.
+type maintenanceOperation struct {
+	kind        string
+	description string
+}
+
+// maintenanceOperations are the system operations of GCP announcing the maintenance or the
+// interruption of an instance, by operation type.
+var maintenanceOperations = map[string]maintenanceOperation{
+	"compute.instances.migrateOnHostMaintenance":   {kind: drivers.EventMaintenance, description: "live migration for a host maintenance"},
+	"compute.instances.terminateOnHostMaintenance": {kind: drivers.EventStop, description: "termination for a host maintenance"},
+	"compute.instances.hostError":                  {kind: drivers.EventReboot, description: "restart after a host error"},
+	"compute.instances.preempted":                  {kind: drivers.EventInterruption, description: "preemption"},
+}
+
+// ScheduledEvents returns the live migrations, the host errors and the preemptions of the
+// instances, from the system operations of their zones started since the instances were created.
+func (p *config) ScheduledEvents(ctx context.Context, instances []*types.Instance) ([]drivers.ScheduledEvent, error) {
+	byZone := map[string]map[uint64]*types.Instance{}
+	for _, inst := range instances {
+		id, err := strconv.ParseUint(inst.ID, 10, 64)
+		if err != nil || inst.Zone == "" {
+			continue
+		}
+		if byZone[inst.Zone] == nil {
+			byZone[inst.Zone] = map[uint64]*types.Instance{}
+		}
+		byZone[inst.Zone][id] = inst
+	}
+
+	filter := maintenanceFilter()
+	var events []drivers.ScheduledEvent
+	for zone, zoneInstances := range byZone {
+		err := p.service.ZoneOperations.List(p.projectID, zone).Filter(filter).Pages(ctx, func(list *compute.OperationList) error {
+			for _, op := range list.Items {
+				if e, ok := operationEvent(op, zoneInstances[op.TargetId]); ok {
+					events = append(events, e)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the operations of zone %s: %w", zone, err)
+		}
+	}
+	return events, nil
+}
+
+// maintenanceFilter returns the filter of the operations listing the maintenance operations.
+func maintenanceFilter() string {
+	clauses := make([]string, 0, len(maintenanceOperations))
+	for opType := range maintenanceOperations {
+		clauses = append(clauses, fmt.Sprintf("(operationType = %q)", opType))
+	}
+	sort.Strings(clauses)
+	return strings.Join(clauses, " OR ")
+}
+
+// operationEvent returns the event of the instance of the operation, false if the operation is not
+// on the instance or started before the instance was created.
+func operationEvent(op *compute.Operation, inst *types.Instance) (drivers.ScheduledEvent, bool) {
+	known, ok := maintenanceOperations[op.OperationType]
+	if !ok || inst == nil {
+		return drivers.ScheduledEvent{}, false
+	}
+	inserted, err := time.Parse(time.RFC3339, op.InsertTime)
+	if err != nil || inserted.Unix() < inst.Started {
+		return drivers.ScheduledEvent{}, false
+	}
+	description := known.description
+	if op.StatusMessage != "" {
+		description += ", " + op.StatusMessage
+	}
+	return drivers.ScheduledEvent{
+		InstanceID:  inst.ID,
+		Kind:        known.kind,
+		Description: description,
+		NotBefore:   inserted,
+	}, true
+}
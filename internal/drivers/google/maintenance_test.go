@@ -0,0 +1,39 @@
+package google
+
+import (
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"google.golang.org/api/compute/v1"
+)
+
+func TestOperationEvent(t *testing.T) {
+	started := time.Date(2026, 10, 20, 3, 0, 0, 0, time.UTC)
+	inst := &types.Instance{ID: "42", Started: started.Unix()}
+
+	e, ok := operationEvent(&compute.Operation{
+		OperationType: "compute.instances.preempted",
+		InsertTime:    started.Add(time.Hour).Format(time.RFC3339),
+		StatusMessage: "Instance was preempted.",
+	}, inst)
+	if !ok || e.InstanceID != "42" || e.Kind != drivers.EventInterruption || e.Description != "preemption, Instance was preempted." {
+		t.Errorf("expected the preemption of the instance, got %t with %+v", ok, e)
+	}
+
+	tests := []*compute.Operation{
+		// the operation of a previous instance of the same id
+		{OperationType: "compute.instances.hostError", InsertTime: started.Add(-time.Hour).Format(time.RFC3339)},
+		{OperationType: "compute.instances.insert", InsertTime: started.Add(time.Hour).Format(time.RFC3339)},
+	}
+	for _, op := range tests {
+		if e, ok = operationEvent(op, inst); ok {
+			t.Errorf("expected no event of the operation %+v, got %+v", op, e)
+		}
+	}
+	if _, ok = operationEvent(&compute.Operation{OperationType: "compute.instances.preempted"}, nil); ok {
+		t.Error("expected no event of the operation of another instance")
+	}
+}
@@ -76,6 +76,15 @@ func WithMachineImage(image string) Option {
 	}
 }
 
+// WithImageFamily returns an option to create the instances from the latest image of the family,
+// e.g. ubuntu-os-cloud/ubuntu-2204-lts. The family is looked up in the project of the pool if it
+// has no project.
+func WithImageFamily(family string) Option {
+	return func(p *config) {
+		p.imageFamily = family
+	}
+}
+
 // WithSize returns an option to set the instance type.
 func WithSize(size string) Option {
 	return func(p *config) {
@@ -112,6 +121,13 @@ func WithPrivateIP(private bool) Option {
 	}
 }
 
+// WithIPFamily returns an option to set the IP stack of the instances.
+func WithIPFamily(family types.IPFamily) Option {
+	return func(p *config) {
+		p.ipFamily = family
+	}
+}
+
 // WithProject returns an option to set the project.
 func WithProject(project string) Option {
 	return func(p *config) {
@@ -216,3 +232,11 @@ func WithHibernate(hibernate bool) Option {
 		p.hibernate = hibernate
 	}
 }
+
+// WithStandby returns an option to keep free instances stopped instead of running. Unlike hibernation
+// it works for every image, it is meant for pools with slow booting images such as windows.
+func WithStandby(standby bool) Option {
+	return func(p *config) {
+		p.standby = standby
+	}
+}
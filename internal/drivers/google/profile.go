@@ -0,0 +1,27 @@
+package google
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+)
+
+// CheckProfile checks the service account attached to the instances exists, the default service
+// account of the compute engine is not checked.
+func (p *config) CheckProfile(ctx context.Context) error {
+	if p.noServiceAccount || p.serviceAccountEmail == "" || p.serviceAccountEmail == "default" || p.iam == nil {
+		return nil
+	}
+	_, err := p.iam.Projects.ServiceAccounts.Get("projects/-/serviceAccounts/" + p.serviceAccountEmail).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return fmt.Errorf("google: the service account %q does not exist", p.serviceAccountEmail)
+	}
+	if err != nil {
+		return fmt.Errorf("google: could not get the service account %q: %w", p.serviceAccountEmail, err)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package google
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/iam/v1"
+	"google.golang.org/api/option"
+)
+
+func TestCheckProfile(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/serviceAccounts/ci@project.iam.gserviceaccount.com") {
+			_, _ = w.Write([]byte(`{"email": "ci@project.iam.gserviceaccount.com"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error": {"code": 404, "message": "not found"}}`))
+	}))
+	defer srv.Close()
+	service, err := iam.NewService(context.Background(), option.WithEndpoint(srv.URL), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		email string
+		none  bool
+		err   string
+	}{
+		{email: ""},
+		{email: "ci@project.iam.gserviceaccount.com"},
+		{email: "gone@project.iam.gserviceaccount.com", err: `the service account "gone@project.iam.gserviceaccount.com" does not exist`},
+		{email: "gone@project.iam.gserviceaccount.com", none: true},
+	}
+	for _, test := range tests {
+		p := &config{iam: service}
+		WithServiceAccountEmail(test.email)(p)
+		WithNoServiceAccount(test.none)(p)
+		err := p.CheckProfile(context.Background())
+		if (err == nil) != (test.err == "") || (err != nil && !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("service account %q: want the error %q, got %v", test.email, test.err, err)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package google
 import (
 	"crypto/rand"
 	"math/big"
+	"strings"
 )
 
 const letters = "0123456789abcdefghijklmnopqrstuvwxyz"
@@ -29,3 +30,22 @@ func substrSuffix(s string, maxLen int) string {
 
 	return s[len(s)-maxLen:]
 }
+
+// labelValue converts the string to a valid label value: at most 63 lowercase letters,
+// digits, underscores and dashes.
+func labelValue(s string) string {
+	value := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, s)
+	if len(value) > maxLabelValueLen {
+		value = value[:maxLabelValueLen]
+	}
+	return value
+}
@@ -1,6 +1,7 @@
 package google
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -21,3 +22,20 @@ func Test_substrSuffix(t *testing.T) {
 		}
 	}
 }
+
+func Test_labelValue(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected string
+	}{
+		{s: "linux-amd64", expected: "linux-amd64"},
+		{s: "My Runner.1", expected: "my-runner-1"},
+		{s: strings.Repeat("a", 70), expected: strings.Repeat("a", 63)},
+	}
+
+	for _, test := range tests {
+		if got, want := labelValue(test.s), test.expected; got != want {
+			t.Errorf("Want label value %s, got %s", want, got)
+		}
+	}
+}
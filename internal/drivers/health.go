@@ -0,0 +1,145 @@
+package drivers
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// poolHealthTTL is how long the result of the check of a driver is reused.
+	poolHealthTTL = 30 * time.Second
+	// poolHealthTimeout bounds the check of a driver.
+	poolHealthTimeout = 10 * time.Second
+)
+
+// Statuses of the health of the pools.
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+)
+
+// PoolHealth is the result of the check of the connectivity of the driver of a pool and of the
+// drivers of its regions. A pool is degraded if any of them failed, the runner still serves the
+// other pools. The warm-up of the pool is reported along, it is unset if the instances of the pool
+// could not be listed.
+type PoolHealth struct {
+	Name      string         `json:"name"`
+	Driver    string         `json:"driver"`
+	Status    string         `json:"status"`
+	Error     string         `json:"error,omitempty"`
+	LatencyMs int64          `json:"latency_ms"`
+	CheckedAt time.Time      `json:"checked_at"`
+	Regions   []RegionHealth `json:"regions,omitempty"`
+	WarmUp    *PoolWarmUp    `json:"warm_up,omitempty"`
+}
+
+// RegionHealth is the result of the check of the driver of a region of a pool.
+type RegionHealth struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMs int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// healthCheck is the cached result of the check of a driver.
+type healthCheck struct {
+	checked time.Time
+	latency time.Duration
+	err     error
+}
+
+func (c *healthCheck) status() (status, msg string) {
+	if c.err != nil {
+		return HealthDegraded, c.err.Error()
+	}
+	return HealthOK, ""
+}
+
+// poolsHealth caches the checks of the drivers by pool and region.
+type poolsHealth struct {
+	mu     sync.Mutex
+	checks map[string]*healthCheck
+}
+
+// PoolsHealth checks the connectivity of the drivers of the pools, with HealthChecker if the
+// driver implements it or with Ping otherwise. The drivers are checked concurrently and the result
+// of a check is reused for a while, so the probes of the load balancers do not hit the APIs of the
+// cloud providers every time.
+func (m *Manager) PoolsHealth(ctx context.Context) []PoolHealth {
+	m.health.mu.Lock()
+	defer m.health.mu.Unlock()
+	if m.health.checks == nil {
+		m.health.checks = map[string]*healthCheck{}
+	}
+
+	pools := m.pools()
+	checks := make(map[string]*healthCheck)
+	var wg sync.WaitGroup
+	check := func(key string, driver Driver) {
+		if c, ok := m.health.checks[key]; ok && time.Since(c.checked) < poolHealthTTL {
+			checks[key] = c
+			return
+		}
+		c := &healthCheck{}
+		checks[key] = c
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.latency, c.err = checkDriver(ctx, driver)
+			c.checked = time.Now()
+		}()
+	}
+	for _, pool := range pools {
+		check(pool.Name, pool.Driver)
+		for i := range pool.Regions {
+			check(pool.Name+"/"+pool.Regions[i].Name, pool.Regions[i].Driver)
+		}
+	}
+	wg.Wait()
+	// the checks of the removed pools are dropped
+	m.health.checks = checks
+
+	out := make([]PoolHealth, 0, len(pools))
+	for _, pool := range pools {
+		c := checks[pool.Name]
+		h := PoolHealth{
+			Name:      pool.Name,
+			Driver:    pool.Driver.DriverName(),
+			LatencyMs: c.latency.Milliseconds(),
+			CheckedAt: c.checked,
+		}
+		h.Status, h.Error = c.status()
+		for i := range pool.Regions {
+			rc := checks[pool.Name+"/"+pool.Regions[i].Name]
+			r := RegionHealth{Name: pool.Regions[i].Name, LatencyMs: rc.latency.Milliseconds(), CheckedAt: rc.checked}
+			r.Status, r.Error = rc.status()
+			if rc.err != nil {
+				h.Status = HealthDegraded
+			}
+			h.Regions = append(h.Regions, r)
+		}
+		if w, err := m.warmUp(ctx, pool); err == nil {
+			h.WarmUp = &w
+		}
+		out = append(out, h)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func checkDriver(ctx context.Context, driver Driver) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, poolHealthTimeout)
+	defer cancel()
+
+	start := time.Now()
+	var err error
+	if checker, ok := driver.(HealthChecker); ok {
+		err = checker.CheckHealth(ctx)
+	} else {
+		err = driver.Ping(ctx)
+	}
+	return time.Since(start), err
+}
@@ -0,0 +1,59 @@
+package drivers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+)
+
+// pingCountingDriver counts its pings.
+type pingCountingDriver struct {
+	failingDriver
+	pings *int
+}
+
+func (d pingCountingDriver) Ping(context.Context) error {
+	*d.pings++
+	return nil
+}
+
+func TestPoolsHealth(t *testing.T) {
+	ctx := context.Background()
+	m := New(ctx, nil, &config.EnvConfig{})
+	pings := 0
+	if err := m.Add(
+		Pool{Name: "linux", MaxSize: 1, Driver: pingCountingDriver{pings: &pings}},
+		Pool{Name: "windows", MaxSize: 1, Driver: failingDriver{},
+			Regions: []Region{{Name: "eu", Driver: unreachableDriver{}}, {Name: "us", Driver: failingDriver{}}}},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	pools := m.PoolsHealth(ctx)
+	if len(pools) != 2 {
+		t.Fatalf("got %d pools, want 2", len(pools))
+	}
+	if linux := pools[0]; linux.Name != "linux" || linux.Status != HealthOK || linux.Error != "" {
+		t.Errorf("got %+v, want the linux pool healthy", linux)
+	}
+	windows := pools[1]
+	if windows.Status != HealthDegraded {
+		t.Errorf("got status %q, want the windows pool degraded by its region", windows.Status)
+	}
+	if len(windows.Regions) != 2 || windows.Regions[0].Status != HealthDegraded || windows.Regions[1].Status != HealthOK {
+		t.Errorf("got regions %+v, want the eu region degraded only", windows.Regions)
+	}
+
+	// the results are reused until they expire
+	m.PoolsHealth(ctx)
+	if pings != 1 {
+		t.Errorf("got %d pings, want the cached result", pings)
+	}
+	m.health.checks["linux"].checked = time.Time{}
+	m.PoolsHealth(ctx)
+	if pings != 2 {
+		t.Errorf("got %d pings, want the expired result checked again", pings)
+	}
+}
@@ -0,0 +1,143 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/drone/runner-go/logger"
+)
+
+// defaultHookTimeout bounds the destroy hooks without a timeout of their own.
+const defaultHookTimeout = 5 * time.Minute
+
+// DestroyHook is a destroy hook of a pool with its parsed script, see types.DestroyHook.
+type DestroyHook struct {
+	Name              string
+	Timeout           time.Duration
+	ContinueOnFailure bool
+	tmpl              *template.Template
+}
+
+// HookData are the fields of the scripts of the destroy hooks, e.g. "docker system df > /tmp/{{.Stage}}.df".
+type HookData struct {
+	Runner    string // name of the runner
+	Pool      string // name of the pool
+	Stage     string // runtime ID of the completed stage
+	AccountID string // account of the stage, empty if unknown
+	Instance  string // ID of the instance
+	Name      string // name of the instance
+	OS        string
+	Arch      string
+}
+
+// HookResult is the outcome of a destroy hook.
+type HookResult struct {
+	Name     string
+	Skipped  bool // an earlier hook failed
+	ExitCode int
+	Duration time.Duration
+	Err      error
+}
+
+func (r *HookResult) String() string {
+	switch {
+	case r.Skipped:
+		return fmt.Sprintf("destroy hook %s: skipped after a failed hook", r.Name)
+	case r.Err != nil:
+		return fmt.Sprintf("destroy hook %s: failed after %s: %s", r.Name, r.Duration.Round(time.Millisecond), r.Err)
+	default:
+		return fmt.Sprintf("destroy hook %s: completed in %s", r.Name, r.Duration.Round(time.Millisecond))
+	}
+}
+
+// ParseDestroyHooks parses the scripts of the destroy hooks of a pool.
+func ParseDestroyHooks(hooks []types.DestroyHook) ([]DestroyHook, error) {
+	parsed := make([]DestroyHook, 0, len(hooks))
+	for i := range hooks {
+		h := &hooks[i]
+		tmpl, err := template.New(h.Name).Option("missingkey=error").Parse(h.Script)
+		if err != nil {
+			return nil, fmt.Errorf("invalid script of the destroy hook %s: %w", h.Name, err)
+		}
+		if err = tmpl.Execute(&strings.Builder{}, HookData{}); err != nil {
+			return nil, fmt.Errorf("invalid script of the destroy hook %s: %w", h.Name, err)
+		}
+		timeout := time.Duration(h.TimeoutSecs) * time.Second
+		if timeout <= 0 {
+			timeout = defaultHookTimeout
+		}
+		parsed = append(parsed, DestroyHook{Name: h.Name, Timeout: timeout, ContinueOnFailure: h.ContinueOnFailure, tmpl: tmpl})
+	}
+	return parsed, nil
+}
+
+// RunDestroyHooks runs the destroy hooks of the pool on the instance of the completed stage, in
+// order. A failed hook skips the next ones unless it continues on failure. The output of each hook
+// is streamed to the log key of the stage suffixed with the name of the hook, if the log key is set.
+// The failures are only reported, the instance is destroyed in any case.
+func (m *Manager) RunDestroyHooks(ctx context.Context, poolName, stageID, logKey string, inst *types.Instance) []HookResult {
+	pool := m.getPool(poolName)
+	if pool == nil || len(pool.DestroyHooks) == 0 {
+		return nil
+	}
+	data := HookData{
+		Runner:    m.runnerName,
+		Pool:      poolName,
+		Stage:     stageID,
+		AccountID: inst.AccountID,
+		Instance:  inst.ID,
+		Name:      inst.Name,
+		OS:        inst.Platform.OS,
+		Arch:      inst.Platform.Arch,
+	}
+
+	results := make([]HookResult, 0, len(pool.DestroyHooks))
+	failed := false
+	for i := range pool.DestroyHooks {
+		h := &pool.DestroyHooks[i]
+		if failed {
+			results = append(results, HookResult{Name: h.Name, Skipped: true})
+			continue
+		}
+		result := m.runDestroyHook(ctx, &pool.Pool, inst, h, &data, logKey)
+		logr := logger.FromContext(ctx).
+			WithField("pool", poolName).
+			WithField("instance_id", inst.ID).
+			WithField("stage_runtime_id", stageID).
+			WithField("hook", h.Name).
+			WithField("duration", result.Duration)
+		if result.Err != nil {
+			logr.WithError(result.Err).WithField("exit_code", result.ExitCode).Warnln("destroy hooks: the hook failed")
+			failed = !h.ContinueOnFailure
+		} else {
+			logr.Infoln("destroy hooks: the hook completed")
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (m *Manager) runDestroyHook(ctx context.Context, pool *Pool, inst *types.Instance, h *DestroyHook, data *HookData, logKey string) HookResult {
+	result := HookResult{Name: h.Name}
+	var script strings.Builder
+	if result.Err = h.tmpl.Execute(&script, data); result.Err != nil {
+		return result
+	}
+	if logKey != "" {
+		logKey += "-destroy-hook-" + h.Name
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.Timeout)
+	defer cancel()
+	start := time.Now()
+	result.ExitCode, result.Err = m.runScript(ctx, pool, inst, "destroy-hook", script.String(), logKey, h.Timeout)
+	result.Duration = time.Since(start)
+	if ctx.Err() != nil {
+		result.Err = fmt.Errorf("the hook did not complete within %s: %w", h.Timeout, ctx.Err())
+	}
+	return result
+}
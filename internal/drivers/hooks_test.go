@@ -0,0 +1,69 @@
+package drivers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestParseDestroyHooks(t *testing.T) {
+	hooks, err := ParseDestroyHooks([]types.DestroyHook{
+		{Name: "prune", Script: "docker system df > /tmp/{{.Stage}}.df"},
+		{Name: "license", Script: "deactivate {{.Instance}}", TimeoutSecs: 30, ContinueOnFailure: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hooks[0].Timeout != defaultHookTimeout || hooks[1].Timeout != 30*time.Second || !hooks[1].ContinueOnFailure {
+		t.Errorf("unexpected hooks %+v", hooks)
+	}
+
+	for _, script := range []string{"echo {{.Stage", "echo {{.Unknown}}"} {
+		if _, err = ParseDestroyHooks([]types.DestroyHook{{Name: "bad", Script: script}}); err == nil {
+			t.Errorf("expected the script %q to be rejected", script)
+		}
+	}
+}
+
+func TestRunDestroyHooks(t *testing.T) {
+	ctx := context.Background()
+	m := New(ctx, nil, &config.EnvConfig{})
+	hooks, err := ParseDestroyHooks([]types.DestroyHook{
+		{Name: "first", Script: "true"},
+		{Name: "second", Script: "true"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tolerant := append([]DestroyHook(nil), hooks...)
+	tolerant[0].ContinueOnFailure = true
+	pools := []Pool{
+		{Name: "none", Driver: failingDriver{}},
+		{Name: "strict", Driver: failingDriver{}, DestroyHooks: hooks},
+		{Name: "tolerant", Driver: failingDriver{}, DestroyHooks: tolerant},
+	}
+	for i := range pools {
+		if err = m.Add(pools[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the lite-engine of the instance is not reachable, the hooks fail right away
+	inst := &types.Instance{ID: "instance", Address: "127.0.0.1", Port: 1}
+
+	if results := m.RunDestroyHooks(ctx, "none", "stage", "", inst); len(results) != 0 {
+		t.Errorf("expected no hooks, got %v", results)
+	}
+
+	results := m.RunDestroyHooks(ctx, "strict", "stage", "", inst)
+	if len(results) != 2 || results[0].Err == nil || !results[1].Skipped {
+		t.Errorf("expected the failed hook to skip the next one, got %+v", results)
+	}
+
+	results = m.RunDestroyHooks(ctx, "tolerant", "stage", "", inst)
+	if len(results) != 2 || results[0].Err == nil || results[1].Skipped || results[1].Err == nil {
+		t.Errorf("expected the hook continuing on failure to run the next one, got %+v", results)
+	}
+}
@@ -0,0 +1,71 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ResolveImages looks up the images of the pools whose drivers select the image by a filter, so
+// the new instances track the latest image. It returns the first failed lookup.
+func (m *Manager) ResolveImages(ctx context.Context) error {
+	var firstErr error
+	for _, pool := range m.pools() {
+		candidates := []Driver{pool.Driver}
+		for i := range pool.Regions {
+			candidates = append(candidates, pool.Regions[i].Driver)
+		}
+		for _, driver := range candidates {
+			resolver, ok := driver.(ImageResolver)
+			if !ok {
+				continue
+			}
+			logr := logrus.WithField("pool", pool.Name).WithField("driver", driver.DriverName())
+			previous := imageOf(driver)
+			image, err := resolver.ResolveImage(ctx)
+			if err != nil {
+				logr.WithError(err).Errorln("images: failed to resolve the image of the pool")
+				if firstErr == nil {
+					firstErr = fmt.Errorf("pool %q: %w", pool.Name, err)
+				}
+				continue
+			}
+			if image != previous {
+				logr.WithField("previous", previous).WithField("image", image).
+					Infoln("images: the pool creates the new instances from a new image")
+			}
+		}
+	}
+	return firstErr
+}
+
+// StartImageResolver looks up the images of the pools periodically, see ResolveImages.
+func (m *Manager) StartImageResolver(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							logrus.Errorf("PANIC %v\n%s", r, debug.Stack())
+						}
+					}()
+					// the failures are logged, the pools keep the images resolved before
+					_ = m.ResolveImages(ctx)
+				}()
+			}
+		}
+	}()
+}
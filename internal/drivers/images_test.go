@@ -0,0 +1,51 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+)
+
+// resolvingDriver resolves its image to the next of the images.
+type resolvingDriver struct {
+	failingDriver
+	image  string
+	images []string
+}
+
+func (d *resolvingDriver) InstanceType() string { return d.image }
+
+func (d *resolvingDriver) ResolveImage(context.Context) (string, error) {
+	if len(d.images) == 0 {
+		return "", errors.New("no image")
+	}
+	d.image, d.images = d.images[0], d.images[1:]
+	return d.image, nil
+}
+
+func TestResolveImages(t *testing.T) {
+	ctx := context.Background()
+	m := New(ctx, nil, &config.EnvConfig{})
+	driver := &resolvingDriver{images: []string{"image-1", "image-2"}}
+	region := &resolvingDriver{images: []string{"region-image-1"}}
+	if err := m.Add(Pool{Name: "linux", Driver: driver, Regions: []Region{{Name: "eu", Driver: region}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.ResolveImages(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if driver.image != "image-1" || region.image != "region-image-1" {
+		t.Errorf("expected the images of the pool and of its region to be resolved, got %s and %s", driver.image, region.image)
+	}
+
+	// the region has no newer image, its lookup fails and it keeps its image
+	if err := m.ResolveImages(ctx); err == nil {
+		t.Error("expected the failed lookup to be reported")
+	}
+	if driver.image != "image-2" || region.image != "region-image-1" {
+		t.Errorf("expected the pool to track the new image and the region to keep its image, got %s and %s", driver.image, region.image)
+	}
+}
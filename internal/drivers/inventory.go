@@ -0,0 +1,131 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/drone-runners/drone-runner-aws/internal/certs"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ImportedInstance is an instance of the cloud account registered in the store by Import.
+type ImportedInstance struct {
+	*types.Instance
+	// Reusable is set if the runner can reach the lite-engine of the instance, the instance is
+	// handed to the stages once it is free.
+	Reusable bool `json:"reusable"`
+}
+
+// Import registers in the store the instances of the pool the drivers find in the cloud account,
+// e.g. after the loss of the store, so the janitor does not terminate them and the pool manages
+// them again. The instances of all the pools are imported if poolName is empty, the instances
+// already in the store are skipped. With dryRun the instances are only returned.
+//
+// The store kept what the cloud account doesn't, the state of an instance is inferred:
+//   - a hibernated instance is free,
+//   - a running instance is busy, its stage is unknown: it might still run a stage, it is destroyed
+//     once it reaches the max age of the busy instances. With free the running instances are free,
+//     e.g. for a runner which was stopped before the loss.
+//
+// The certificates of the lite-engines are lost with the store: the instances of the pools with a
+// CA get new certificates signed by the CA. The instances of the other pools, and of the pools whose
+// instances are reached through a lease key, a route or the tunnel, can't be reached: they are
+// imported busy and destroyed at their max age.
+func (m *Manager) Import(ctx context.Context, poolName string, free, dryRun bool) ([]*ImportedInstance, error) {
+	var pools []*poolEntry
+	if poolName != "" {
+		pool := m.getPool(poolName)
+		if pool == nil {
+			return nil, fmt.Errorf("import: pool name %q not found", poolName)
+		}
+		pools = append(pools, pool)
+	} else {
+		pools = m.pools()
+	}
+
+	var imported []*ImportedInstance
+	for _, pool := range pools {
+		instances, err := m.inventory(ctx, pool, poolName != "")
+		if err != nil {
+			return imported, err
+		}
+		for _, inst := range instances {
+			if _, findErr := m.instanceStore.Find(ctx, inst.ID); findErr == nil {
+				continue
+			}
+			reusable := pool.CA != nil && !pool.LeaseTokens && !pool.Tunnel && len(pool.Routes) == 0
+			if reusable {
+				opts, certErr := certs.Generate(m.runnerName, pool.CA)
+				if certErr != nil {
+					return imported, fmt.Errorf("import: failed to generate the certificates of instance %s: %w", inst.ID, certErr)
+				}
+				inst.CACert, inst.TLSCert, inst.TLSKey = opts.CACert, opts.TLSCert, opts.TLSKey
+			}
+			if !reusable || (!inst.IsHibernated && !free) {
+				inst.State = types.StateInUse
+			} else {
+				inst.State = types.StateCreated
+			}
+			inst.Pool = pool.Name
+			inst.Tunnel = pool.Tunnel
+			imported = append(imported, &ImportedInstance{Instance: inst, Reusable: reusable})
+			if dryRun {
+				continue
+			}
+			if err = m.instanceStore.Create(ctx, inst); err != nil {
+				return imported, fmt.Errorf("import: failed to store instance %s: %w", inst.ID, err)
+			}
+			logrus.WithField("pool", pool.Name).
+				WithField("instance_id", inst.ID).
+				WithField("state", inst.State).
+				WithField("hibernated", inst.IsHibernated).
+				WithField("reusable", reusable).
+				Infoln("import: registered the instance of the cloud account")
+		}
+	}
+	return imported, nil
+}
+
+// inventory returns the instances of the pool in the cloud account, from the drivers of its regions.
+// The pools whose drivers can't list their instances are skipped, unless required.
+func (m *Manager) inventory(ctx context.Context, pool *poolEntry, required bool) ([]*types.Instance, error) {
+	all := []Driver{pool.Driver}
+	for i := range pool.Regions {
+		all = append(all, pool.Regions[i].Driver)
+	}
+	opts := &types.InstanceCreateOpts{
+		RunnerName:     m.runnerName,
+		PoolName:       pool.Name,
+		Platform:       pool.Platform,
+		LiteEnginePort: liteEnginePort(&pool.Pool),
+	}
+
+	seen := map[string]bool{}
+	var instances []*types.Instance
+	listed := false
+	for _, driver := range all {
+		inventory, ok := driver.(Inventory)
+		if !ok {
+			continue
+		}
+		listed = true
+		list, err := inventory.Inventory(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("import: failed to list the instances of %q pool: %w", pool.Name, err)
+		}
+		for _, inst := range list {
+			if !seen[inst.ID] {
+				seen[inst.ID] = true
+				instances = append(instances, inst)
+			}
+		}
+	}
+	if !listed && required {
+		return nil, fmt.Errorf("import: listing the instances of the %s driver: %w", pool.Driver.DriverName(), ErrNotSupported)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Started < instances[j].Started })
+	return instances, nil
+}
@@ -0,0 +1,105 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/certs"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/types"
+	lecerts "github.com/harness/lite-engine/cli/certs"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// inventoryDriver lists the instances of its cloud account.
+type inventoryDriver struct {
+	failingDriver
+	instances []types.Instance
+}
+
+func (d *inventoryDriver) Inventory(_ context.Context, opts *types.InstanceCreateOpts) ([]*types.Instance, error) {
+	list := make([]*types.Instance, 0, len(d.instances))
+	for i := range d.instances {
+		inst := d.instances[i]
+		inst.Pool, inst.Port = opts.PoolName, int64(opts.LiteEnginePort)
+		list = append(list, &inst)
+	}
+	return list, nil
+}
+
+func TestImport(t *testing.T) {
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	instanceStore := ldb.NewInstanceStore(db)
+	if err = instanceStore.Create(ctx, &types.Instance{ID: "stored", Pool: "linux", State: types.StateCreated}); err != nil {
+		t.Fatal(err)
+	}
+	ca, err := lecerts.GenerateCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cloud := []types.Instance{{ID: "stored"}, {ID: "running", Started: 2}, {ID: "stopped", Started: 1, IsHibernated: true}}
+	m := New(ctx, instanceStore, &config.EnvConfig{})
+	err = m.Add(
+		Pool{Name: "linux", Driver: &inventoryDriver{instances: cloud}, CA: &certs.CA{Cert: ca.Cert, Key: ca.Key}},
+		Pool{Name: "windows", Driver: &inventoryDriver{instances: []types.Instance{{ID: "no-ca", IsHibernated: true}}}},
+		Pool{Name: "static", Driver: failingDriver{}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := m.Import(ctx, "linux", false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 || found[0].ID != "stopped" || found[1].ID != "running" {
+		t.Fatalf("expected the instances missing from the store, got %+v", found)
+	}
+	if _, err = instanceStore.Find(ctx, "running"); err == nil {
+		t.Error("expected the dry run not to store the instances")
+	}
+	// the running instances are free once the runner was stopped
+	if found, err = m.Import(ctx, "linux", true, true); err != nil || found[1].State != types.StateCreated {
+		t.Errorf("expected the running instance to be free, got %+v: %v", found, err)
+	}
+
+	imported, err := m.Import(ctx, "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported) != 3 {
+		t.Fatalf("expected the instances of the pools with an inventory, got %d", len(imported))
+	}
+	for _, test := range []struct {
+		id       string
+		state    types.InstanceState
+		reusable bool
+	}{
+		{id: "stopped", state: types.StateCreated, reusable: true},
+		{id: "running", state: types.StateInUse, reusable: true}, // it might run a stage
+		{id: "no-ca", state: types.StateInUse},                   // its lite-engine can't be reached
+	} {
+		inst, findErr := instanceStore.Find(ctx, test.id)
+		if findErr != nil {
+			t.Fatalf("%s: %s", test.id, findErr)
+		}
+		if inst.State != test.state || (len(inst.TLSCert) > 0) != test.reusable {
+			t.Errorf("%s: expected the state %s and the certificates %v, got %s and %d bytes", test.id, test.state, test.reusable, inst.State, len(inst.TLSCert))
+		}
+	}
+
+	if imported, err = m.Import(ctx, "linux", false, true); err != nil || len(imported) != 0 {
+		t.Errorf("expected the imported instances to be skipped, got %d: %v", len(imported), err)
+	}
+	if _, err = m.Import(ctx, "static", false, true); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("expected the driver without an inventory to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package drivers
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// StartJanitor periodically asks the drivers implementing Janitor to clean up the leftovers of
+// the destroyed instances on their hosts.
+func (m *Manager) StartJanitor(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	logrus.Infof("Janitor started. It will run every %.2f minutes", interval.Minutes())
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !m.IsLeader() {
+					continue
+				}
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							logrus.Errorf("PANIC %v\n%s", r, debug.Stack())
+						}
+					}()
+					m.cleanupHosts(ctx)
+				}()
+			}
+		}
+	}()
+}
+
+func (m *Manager) cleanupHosts(ctx context.Context) {
+	pools := m.pools()
+
+	// pools of the runner might share the hosts, the live instances of all the pools are kept.
+	var live []*types.Instance
+	for _, pool := range pools {
+		instances, err := m.instanceStore.List(ctx, pool.Name, nil)
+		if err != nil {
+			logrus.WithError(err).WithField("pool", pool.Name).
+				Errorln("janitor: failed to list instances, skipping the clean up")
+			return
+		}
+		live = append(live, instances...)
+	}
+
+	for _, pool := range pools {
+		janitor, ok := pool.Driver.(Janitor)
+		if !ok {
+			continue
+		}
+		logr := logrus.WithField("pool", pool.Name).WithField("driver", pool.Driver.DriverName())
+		if err := janitor.CleanupHosts(ctx, m.runnerName, pool.Name, live); err != nil {
+			logr.WithError(err).Errorln("janitor: failed to clean up the hosts")
+			continue
+		}
+		logr.Traceln("janitor: cleaned up the hosts")
+	}
+}
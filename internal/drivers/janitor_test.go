@@ -0,0 +1,28 @@
+package drivers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestIsLeaked(t *testing.T) {
+	live := LiveSet([]*types.Instance{{ID: "live"}})
+	old := time.Now().Add(-2 * JanitorGracePeriod)
+
+	tests := []struct {
+		id      string
+		created time.Time
+		want    bool
+	}{
+		{id: "live", created: old, want: false},
+		{id: "leaked", created: old, want: true},
+		{id: "creating", created: time.Now(), want: false},
+	}
+	for _, test := range tests {
+		if got := IsLeaked(test.id, test.created, live); got != test.want {
+			t.Errorf("Want leaked %t for host %s, got %t", test.want, test.id, got)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/keydelivery"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/drone/runner-go/logger"
+)
+
+// StartKeyServer starts the server the instances of the pools with the url key delivery fetch their
+// TLS key from, if enabled.
+func (m *Manager) StartKeyServer(ctx context.Context, env *config.EnvConfig) error {
+	if env.KeyServer.Bind == "" {
+		return nil
+	}
+	return keydelivery.Start(ctx, env.KeyServer.Bind, env.KeyServer.URL, env.KeyServer.CertFile, env.KeyServer.KeyFile)
+}
+
+// keyPublisher returns where the TLS keys of the instances of the pool are published, nil if they
+// are in the user data.
+func keyPublisher(pool *poolEntry) (keydelivery.Publisher, error) {
+	switch pool.KeyDelivery {
+	case keydelivery.ModeUserData:
+		return nil, nil
+	case keydelivery.ModeURL:
+		if srv := keydelivery.Default(); srv != nil {
+			return srv, nil
+		}
+		return nil, fmt.Errorf("manager: pool %q uses the url key delivery but the key server is not enabled", pool.Name)
+	default:
+		if publisher, ok := pool.Driver.(keydelivery.Publisher); ok {
+			return publisher, nil
+		}
+		return nil, fmt.Errorf("manager: the %s key delivery is not supported by the driver of pool %q", pool.KeyDelivery, pool.Name)
+	}
+}
+
+// publishKey publishes the TLS key of a new instance of the pool and sets the command fetching it,
+// the key is left out of the user data. It returns the reference of the key, empty if the key is
+// in the user data.
+func (m *Manager) publishKey(ctx context.Context, pool *poolEntry, opts *types.InstanceCreateOpts) (string, error) {
+	publisher, err := keyPublisher(pool)
+	if err != nil || publisher == nil {
+		return "", err
+	}
+	ref, err := keydelivery.NewRef()
+	if err != nil {
+		return "", fmt.Errorf("manager: failed to generate the reference of the TLS key: %w", err)
+	}
+	if opts.KeyFetch, err = publisher.PublishKey(ctx, ref, opts.TLSKey); err != nil {
+		return "", fmt.Errorf("manager: failed to publish the TLS key to the %s key delivery: %w", pool.KeyDelivery, err)
+	}
+	return ref, nil
+}
+
+// revokeKey revokes the published TLS key of an instance of the pool, once the instance is destroyed
+// or failed to be created. A key which can't be revoked is only logged, the instance is gone.
+func (m *Manager) revokeKey(ctx context.Context, pool *poolEntry, ref string) {
+	if ref == "" {
+		return
+	}
+	publisher, err := keyPublisher(pool)
+	if err == nil && publisher != nil {
+		err = publisher.RevokeKey(ctx, ref)
+	}
+	if err != nil {
+		logger.FromContext(ctx).
+			WithError(err).
+			WithField("pool", pool.Name).
+			Warnln("manager: failed to revoke the TLS key of the instance")
+	}
+}
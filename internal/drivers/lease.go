@@ -0,0 +1,66 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	itypes "github.com/drone-runners/drone-runner-aws/internal/types"
+	"github.com/drone/runner-go/logger"
+)
+
+// ExtendLease keeps the busy instance from being purged for the duration past its max age, the
+// lease is bounded by the hard max age of the instances. The extensions are audit logged with the
+// requester, the lite-engine heartbeat of the runner or the caller of the API.
+func (m *Manager) ExtendLease(ctx context.Context, poolName, instanceID string, d time.Duration, requester string) (time.Time, error) {
+	if d <= 0 {
+		return time.Time{}, itypes.NewBadRequestError(fmt.Sprintf("invalid lease duration %s", d))
+	}
+	pool := m.getPool(poolName)
+	if pool == nil {
+		return time.Time{}, fmt.Errorf("lease: pool name %q not found", poolName)
+	}
+
+	// the purger lists and destroys the stale instances of the pool under the lock.
+	pool.Lock()
+	defer pool.Unlock()
+
+	inst, err := m.Find(ctx, instanceID)
+	if err != nil {
+		return time.Time{}, itypes.NewNotFoundError(fmt.Sprintf("instance %s not found", instanceID))
+	}
+	if inst.Untrusted {
+		return time.Time{}, itypes.NewBadRequestError("the lease of an untrusted instance can't be extended")
+	}
+
+	logr := logger.FromContext(ctx).
+		WithField("audit", "lease").
+		WithField("pool", poolName).
+		WithField("instance_id", inst.ID).
+		WithField("stage_runtime_id", inst.Stage).
+		WithField("requested_by", requester)
+
+	now := time.Now()
+	expires := now.Add(d)
+	if m.leaseMaxAge > 0 {
+		if hardMax := time.Unix(inst.Started, 0).Add(m.leaseMaxAge); expires.After(hardMax) {
+			expires = hardMax
+		}
+	}
+	if !expires.After(now) {
+		logr.Warnln("lease: the instance reached its hard max age, the lease is not extended")
+		return time.Time{}, itypes.NewBadRequestError(fmt.Sprintf("instance %s reached its hard max age of %s", inst.ID, m.leaseMaxAge))
+	}
+	current := time.Unix(inst.LeaseExpires, 0)
+	if !expires.After(current) {
+		return current, nil
+	}
+
+	inst.LeaseExpires = expires.Unix()
+	if err = m.instanceStore.Update(ctx, inst); err != nil {
+		return time.Time{}, fmt.Errorf("lease: failed to update instance %s: %w", inst.ID, err)
+	}
+	logr.WithField("lease_expires", expires.UTC().Format(time.RFC3339)).
+		Infoln("lease: extended the lease of the instance")
+	return expires, nil
+}
@@ -0,0 +1,67 @@
+package drivers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestExtendLease(t *testing.T) {
+	const pool = "linux"
+
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	instanceStore := ldb.NewInstanceStore(db)
+	env := &config.EnvConfig{}
+	env.Settings.LeaseMaxAge = 2
+	m := New(ctx, instanceStore, env)
+	if err = m.Add(Pool{Name: pool, MaxSize: 1, Driver: failingDriver{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Now().Add(-time.Hour)
+	busy := &types.Instance{ID: "busy", Pool: pool, State: types.StateInUse, Started: started.Unix()}
+	untrusted := &types.Instance{ID: "untrusted", Pool: pool, State: types.StateInUse, Started: started.Unix(), Untrusted: true}
+	for _, inst := range []*types.Instance{busy, untrusted} {
+		if err = instanceStore.Create(ctx, inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expires, err := m.ExtendLease(ctx, pool, busy.ID, 10*time.Minute, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(expires); d < 9*time.Minute || d > 10*time.Minute {
+		t.Errorf("expected the lease to expire in 10 minutes, expires in %s", d)
+	}
+
+	// the lease is bounded by the hard max age
+	if expires, err = m.ExtendLease(ctx, pool, busy.ID, 5*time.Hour, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if hardMax := started.Add(2 * time.Hour).Unix(); expires.Unix() != hardMax {
+		t.Errorf("expected the lease to expire at the hard max age %d, got %d", hardMax, expires.Unix())
+	}
+	inst, err := instanceStore.Find(ctx, busy.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inst.LeaseExpires != expires.Unix() {
+		t.Errorf("expected the lease to be stored, got %d", inst.LeaseExpires)
+	}
+
+	if _, err = m.ExtendLease(ctx, pool, untrusted.ID, 10*time.Minute, "test"); err == nil {
+		t.Error("expected the lease of the untrusted instance not to be extended")
+	}
+}
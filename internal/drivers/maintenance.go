@@ -0,0 +1,173 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/events"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Kinds of the scheduled events of the instances.
+const (
+	EventReboot       = "reboot"       // the instance or its host is rebooted
+	EventStop         = "stop"         // the instance is stopped or retired
+	EventMaintenance  = "maintenance"  // the host of the instance is under maintenance, e.g. a live migration
+	EventInterruption = "interruption" // the spot or preemptible instance is reclaimed by the provider
+)
+
+// ScheduledEvent is a maintenance or an interruption of an instance announced by its provider.
+type ScheduledEvent struct {
+	InstanceID  string
+	Kind        string
+	Description string
+	// NotBefore is the earliest time of the event, zero if the provider does not tell.
+	NotBefore time.Time
+}
+
+// String returns the notice of the event stored with the instance.
+func (e *ScheduledEvent) String() string {
+	s := e.Kind
+	if e.Description != "" {
+		s += ": " + e.Description
+	}
+	if !e.NotBefore.IsZero() {
+		s += fmt.Sprintf(" (not before %s)", e.NotBefore.UTC().Format(time.RFC3339))
+	}
+	return s
+}
+
+// StartMaintenanceWatcher periodically asks the drivers implementing MaintenanceWatcher for the
+// scheduled events of the instances of their pools. The free instances with an event are replaced
+// right away, the busy ones keep running their stage with the event stored as their maintenance,
+// so the failing steps are reported as retryable, and an instance.maintenance event is published.
+func (m *Manager) StartMaintenanceWatcher(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	logrus.Infof("Maintenance watcher started. It will run every %.2f minutes", interval.Minutes())
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !m.IsLeader() {
+					continue
+				}
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							logrus.Errorf("PANIC %v\n%s", r, debug.Stack())
+						}
+					}()
+					m.checkMaintenance(ctx)
+				}()
+			}
+		}
+	}()
+}
+
+func (m *Manager) checkMaintenance(ctx context.Context) {
+	for _, pool := range m.pools() {
+		if err := m.checkPoolMaintenance(ctx, pool); err != nil {
+			logrus.WithError(err).WithField("pool", pool.Name).
+				Errorln("maintenance: failed to check the scheduled events of the instances")
+		}
+	}
+}
+
+// checkPoolMaintenance handles the scheduled events of the instances of the pool, see StartMaintenanceWatcher.
+func (m *Manager) checkPoolMaintenance(ctx context.Context, pool *poolEntry) error {
+	pool.Lock()
+	defer pool.Unlock()
+
+	busy, free, hibernating, err := m.List(ctx, pool)
+	if err != nil {
+		return fmt.Errorf("failed to list the instances: %w", err)
+	}
+	byDriver := map[Driver][]*types.Instance{}
+	byID := map[string]*types.Instance{}
+	for _, inst := range append(append(busy, free...), hibernating...) {
+		if inst.State == types.StateTerminating {
+			continue
+		}
+		driver, driverErr := driverFor(pool, inst)
+		if driverErr != nil {
+			return driverErr
+		}
+		byDriver[driver] = append(byDriver[driver], inst)
+		byID[inst.ID] = inst
+	}
+
+	var scheduled []ScheduledEvent
+	for driver, instances := range byDriver {
+		watcher, ok := driver.(MaintenanceWatcher)
+		if !ok {
+			continue
+		}
+		driverEvents, eventsErr := watcher.ScheduledEvents(ctx, instances)
+		if eventsErr != nil {
+			return fmt.Errorf("failed to get the scheduled events: %w", eventsErr)
+		}
+		scheduled = append(scheduled, driverEvents...)
+	}
+
+	// the events of an instance are stored as a single notice
+	var affected []string
+	notices := map[string]string{}
+	for i := range scheduled {
+		id := scheduled[i].InstanceID
+		if byID[id] == nil {
+			continue
+		}
+		if _, ok := notices[id]; ok {
+			notices[id] += "; " + scheduled[i].String()
+			continue
+		}
+		affected = append(affected, id)
+		notices[id] = scheduled[i].String()
+	}
+
+	var replace []*types.Instance
+	for _, id := range affected {
+		inst, notice := byID[id], notices[id]
+		if inst.Maintenance == notice {
+			continue
+		}
+		inst.Maintenance = notice
+		logr := logrus.WithField("pool", pool.Name).
+			WithField("instance_id", inst.ID).
+			WithField("provider_id", inst.ProviderID).
+			WithField("maintenance", notice)
+		e := events.ForInstance(events.InstanceMaintenance, inst)
+		e.Error = notice
+		m.events.Publish(e)
+
+		if inst.State != types.StateInUse {
+			logr.Warnln("maintenance: replacing the free instance")
+			replace = append(replace, inst)
+			continue
+		}
+		logr.WithField("stage", inst.Stage).Warnln("maintenance: the busy instance is affected, its stage can be retried if it fails")
+		if err = m.instanceStore.Update(ctx, inst); err != nil {
+			return fmt.Errorf("failed to update instance %s: %w", inst.ID, err)
+		}
+	}
+	if len(replace) == 0 {
+		return nil
+	}
+
+	if err = m.destroyOrRetry(ctx, pool, replace, true); err != nil {
+		return fmt.Errorf("failed to destroy the affected instances: %w", err)
+	}
+	return m.buildPool(ctx, pool)
+}
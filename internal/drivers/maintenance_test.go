@@ -0,0 +1,76 @@
+package drivers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// maintainedDriver reports the scheduled events of the instances.
+type maintainedDriver struct {
+	failingDriver
+	events []ScheduledEvent
+}
+
+func (d *maintainedDriver) ScheduledEvents(context.Context, []*types.Instance) ([]ScheduledEvent, error) {
+	return d.events, nil
+}
+
+func TestCheckPoolMaintenance(t *testing.T) {
+	const pool = "linux"
+
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	instanceStore := ldb.NewInstanceStore(db)
+	m := New(ctx, instanceStore, &config.EnvConfig{})
+	notBefore := time.Date(2026, 10, 20, 3, 0, 0, 0, time.UTC)
+	driver := &maintainedDriver{events: []ScheduledEvent{
+		{InstanceID: "free", Kind: EventReboot, Description: "system-reboot"},
+		{InstanceID: "busy", Kind: EventInterruption, Description: "spot instance marked-for-termination", NotBefore: notBefore},
+		{InstanceID: "busy", Kind: EventMaintenance},
+		{InstanceID: "gone", Kind: EventStop},
+	}}
+	if err = m.Add(Pool{Name: pool, MaxSize: 4, Driver: driver}); err != nil {
+		t.Fatal(err)
+	}
+	for _, inst := range []*types.Instance{
+		{ID: "free", Pool: pool, State: types.StateCreated},
+		{ID: "busy", Pool: pool, State: types.StateInUse, Stage: "stage"},
+		{ID: "healthy", Pool: pool, State: types.StateCreated},
+	} {
+		if err = instanceStore.Create(ctx, inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = m.checkPoolMaintenance(ctx, m.getPool(pool)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = instanceStore.Find(ctx, "free"); err == nil {
+		t.Error("expected the free instance with a scheduled event to be replaced")
+	}
+	healthy, err := instanceStore.Find(ctx, "healthy")
+	if err != nil || healthy.Maintenance != "" {
+		t.Errorf("expected the healthy instance to be kept as is, got %+v, %v", healthy, err)
+	}
+	busy, err := instanceStore.Find(ctx, "busy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "interruption: spot instance marked-for-termination (not before 2026-10-20T03:00:00Z); maintenance"
+	if busy.Maintenance != want || !strings.HasPrefix(busy.Maintenance, EventInterruption) {
+		t.Errorf("got the maintenance %q of the busy instance, want %q", busy.Maintenance, want)
+	}
+}
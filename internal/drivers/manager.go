@@ -5,17 +5,26 @@ import (
 	"fmt"
 	"runtime/debug"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/alert"
 	"github.com/drone-runners/drone-runner-aws/internal/certs"
+	"github.com/drone-runners/drone-runner-aws/internal/cloudinit"
+	"github.com/drone-runners/drone-runner-aws/internal/dns"
+	"github.com/drone-runners/drone-runner-aws/internal/events"
+	"github.com/drone-runners/drone-runner-aws/internal/leasetoken"
+	"github.com/drone-runners/drone-runner-aws/internal/lehelper"
+	"github.com/drone-runners/drone-runner-aws/internal/route"
+	"github.com/drone-runners/drone-runner-aws/internal/tunnel"
 	itypes "github.com/drone-runners/drone-runner-aws/internal/types"
 	"github.com/drone-runners/drone-runner-aws/store"
 	"github.com/drone-runners/drone-runner-aws/types"
 	"github.com/drone/runner-go/logger"
-	lehttp "github.com/harness/lite-engine/cli/client"
 	"github.com/pkg/errors"
 
 	"github.com/sirupsen/logrus"
@@ -24,6 +33,7 @@ import (
 type (
 	Manager struct {
 		globalCtx            context.Context
+		poolMu               sync.RWMutex
 		poolMap              map[string]*poolEntry
 		strategy             Strategy
 		cleanupTimer         *time.Ticker
@@ -33,11 +43,44 @@ type (
 		harnessTestBinaryURI string
 		pluginBinaryURI      string
 		tmate                types.Tmate
+		isLeader             func() bool
+		observer             PoolObserver
+		destroyRetries       store.DestroyRetryStore
+		destroyAlertAfter    int
+		registrar            dns.Registrar
+		alerter              *alert.Alerter
+		events               *events.Bus
+		leaseMaxAge          time.Duration
+		stageRecords         store.StageRecordStore
+		readiness            readiness
+		health               poolsHealth
+		routes               routes
+		mappings             poolMappings
+		setups               setups
+		steps                steps
+		zombies              zombies
+		warmUpGate           bool
+	}
+
+	// PoolObserver is notified of the outcome of the instance provisioning in the pools.
+	PoolObserver interface {
+		InstanceCreated(pool string)
+		InstanceCreateFailed(pool string, err error)
 	}
 
 	poolEntry struct {
 		sync.Mutex
 		Pool
+		regions  regionSelector
+		stats    poolStats
+		draining atomic.Bool // the pool is being deleted and gets no new stages
+		// overflowPending is the number of overflow instances being created
+		overflowPending atomic.Int32
+		// sleeping is set once the scheduler puts the pool to sleep, see StartScheduler
+		sleeping atomic.Bool
+		rollout  atomic.Pointer[rollout]
+		// warm is set once the pool reached its min size, see PoolWarmUp
+		warm atomic.Bool
 	}
 )
 
@@ -53,12 +96,16 @@ func New(
 		liteEnginePath:       env.LiteEngine.Path,
 		harnessTestBinaryURI: env.Settings.HarnessTestBinaryURI,
 		pluginBinaryURI:      env.Settings.PluginBinaryURI,
+		destroyAlertAfter:    env.Settings.DestroyRetryAlertThreshold,
+		leaseMaxAge:          time.Hour * time.Duration(env.Settings.LeaseMaxAge),
+		mappings:             poolMappings{static: staticPoolMappings(env.Dlite.PoolMapByAccount)},
+		warmUpGate:           env.Settings.ReadinessWarmPools,
 	}
 }
 
 // Inspect returns OS and root directory for a pool.
 func (m *Manager) Inspect(name string) (platform types.Platform, rootDir string) {
-	entry := m.poolMap[name]
+	entry := m.getPool(name)
 	if entry == nil {
 		return
 	}
@@ -69,17 +116,59 @@ func (m *Manager) Inspect(name string) (platform types.Platform, rootDir string)
 	return
 }
 
+// StepTimeout returns the timeout of the steps of the pool without a timeout of their own, zero if none.
+func (m *Manager) StepTimeout(name string) time.Duration {
+	if entry := m.getPool(name); entry != nil {
+		return entry.StepTimeout
+	}
+	return 0
+}
+
+// Services returns the service containers started on the instances of the pool for every stage.
+func (m *Manager) Services(name string) []types.PoolService {
+	if entry := m.getPool(name); entry != nil {
+		return entry.Services
+	}
+	return nil
+}
+
+// WorkspaceSnapshot returns the workspace snapshot extracted on the instances of the pool for every stage.
+func (m *Manager) WorkspaceSnapshot(name string) types.WorkspaceSnapshot {
+	if entry := m.getPool(name); entry != nil {
+		return entry.WorkspaceSnapshot
+	}
+	return types.WorkspaceSnapshot{}
+}
+
+// Credentials returns the cloud credentials passed to the steps of the stages of the pool.
+func (m *Manager) Credentials(name string) types.PoolCredentials {
+	if entry := m.getPool(name); entry != nil {
+		return entry.Credentials
+	}
+	return types.PoolCredentials{}
+}
+
+// Platform returns the platform of the instances of the pool.
+func (m *Manager) Platform(name string) types.Platform {
+	if entry := m.getPool(name); entry != nil {
+		return entry.Platform
+	}
+	return types.Platform{}
+}
+
 // Exists returns true if a pool with given name exists.
 func (m *Manager) Exists(name string) bool {
-	return m.poolMap[name] != nil
+	return m.getPool(name) != nil
 }
 
 func (m *Manager) Count() int {
+	m.poolMu.RLock()
+	defer m.poolMu.RUnlock()
 	return len(m.poolMap)
 }
 
 func (m *Manager) MatchPoolNameFromPlatform(requested *types.Platform) string {
-	for _, pool := range m.poolMap {
+	for _, pool := range m.pools() {
 		if pool.Platform.OS == requested.OS && pool.Platform.Arch == requested.Arch {
 			return pool.Name
 		}
@@ -87,25 +176,44 @@ func (m *Manager) MatchPoolNameFromPlatform(requested *types.Platform) string {
 	return ""
 }
 
+// Find returns the instance, an instance of another account than the one of the context is not found.
 func (m *Manager) Find(ctx context.Context, instanceID string) (*types.Instance, error) {
-	return m.instanceStore.Find(ctx, instanceID)
+	inst, err := m.instanceStore.Find(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if !ownedBy(inst, accountID(ctx)) {
+		return nil, itypes.NewNotFoundError(fmt.Sprintf("instance %s not found", instanceID))
+	}
+	return inst, nil
 }
 
+// GetInstanceByStageID returns the instance in use by the stage. The instance of a stage queued
+// for destruction is not returned.
 func (m *Manager) GetInstanceByStageID(ctx context.Context, poolName, stage string) (*types.Instance, error) {
+	return m.getInstanceByStageID(ctx, poolName, stage, types.StateInUse)
+}
+
+// GetInstanceToDestroy returns the instance of the stage, in use or queued for destruction.
+func (m *Manager) GetInstanceToDestroy(ctx context.Context, poolName, stage string) (*types.Instance, error) {
+	return m.getInstanceByStageID(ctx, poolName, stage, types.StateInUse, types.StateTerminating)
+}
+
+func (m *Manager) getInstanceByStageID(ctx context.Context, poolName, stage string, states ...types.InstanceState) (*types.Instance, error) {
 	if stage == "" {
 		logger.FromContext(ctx).
 			Errorln("manager: GetInstanceByStageID stage runtime ID is not set")
 		return nil, fmt.Errorf("stage runtime ID is not set")
 	}
 
-	pool := m.poolMap[poolName]
+	pool := m.getPool(poolName)
 	if pool == nil {
 		err := fmt.Errorf("GetInstanceByStageID: pool name %s not found", poolName)
 		logger.FromContext(ctx).WithError(err).WithField("stage_runtime_id", stage).
 			Errorln("manager: GetInstanceByStageID failed find pool")
 		return nil, err
 	}
-	query := types.QueryParams{Status: types.StateInUse, Stage: stage}
+	query := types.QueryParams{Stage: stage, AccountID: accountID(ctx)}
 	list, err := m.instanceStore.List(ctx, pool.Name, &query)
 	if err != nil {
 		logger.FromContext(ctx).WithError(err).WithField("stage_runtime_id", stage).
@@ -113,10 +221,35 @@ func (m *Manager) GetInstanceByStageID(ctx context.Context, poolName, stage stri
 		return nil, err
 	}
 
-	if len(list) == 0 {
-		return nil, fmt.Errorf("manager: instance for stage runtime ID %s not found", stage)
+	for _, inst := range list {
+		for _, state := range states {
+			if inst.State == state {
+				return inst, nil
+			}
+		}
 	}
-	return list[0], nil
+	return nil, fmt.Errorf("manager: instance for stage runtime ID %s not found", stage)
+}
+
+// TerminatingInstances returns the instances of the pools queued for destruction, except the
+// instances whose destruction failed and is retried by the destroy retrier.
+func (m *Manager) TerminatingInstances(ctx context.Context) ([]*types.Instance, error) {
+	var instances []*types.Instance
+	for _, pool := range m.pools() {
+		list, err := m.instanceStore.List(ctx, pool.Name, &types.QueryParams{Status: types.StateTerminating})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the terminating instances of %q pool: %w", pool.Name, err)
+		}
+		for _, inst := range list {
+			if m.destroyRetries != nil {
+				if _, findErr := m.destroyRetries.Find(ctx, inst.ID); findErr == nil {
+					continue
+				}
+			}
+			instances = append(instances, inst)
+		}
+	}
+	return instances, nil
 }
 
 func (m *Manager) List(ctx context.Context, pool *poolEntry) (busy, free, hibernating []*types.Instance, err error) {
@@ -130,7 +263,7 @@ func (m *Manager) List(ctx context.Context, pool *poolEntry) (busy, free, hibern
 	for _, instance := range list {
 		// required to append instance not pointer
 		loopInstance := instance
-		if instance.State == types.StateInUse {
+		if instance.State == types.StateInUse || instance.State == types.StateTerminating {
 			busy = append(busy, loopInstance)
 		} else if instance.State == types.StateHibernating {
 			hibernating = append(hibernating, loopInstance)
@@ -142,6 +275,30 @@ func (m *Manager) List(ctx context.Context, pool *poolEntry) (busy, free, hibern
 	return busy, free, hibernating, nil
 }
 
+// Instances returns the instances of the pool, or of all the pools if the name is empty, ordered
+// by pool and by start time.
+func (m *Manager) Instances(ctx context.Context, poolName string) ([]*types.Instance, error) {
+	pools := m.pools()
+	if poolName != "" {
+		pool := m.getPool(poolName)
+		if pool == nil {
+			return nil, fmt.Errorf("instances: pool name %q not found", poolName)
+		}
+		pools = []*poolEntry{pool}
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].Name < pools[j].Name })
+	var instances []*types.Instance
+	for _, pool := range pools {
+		list, err := m.instanceStore.List(ctx, pool.Name, &types.QueryParams{AccountID: accountID(ctx)})
+		if err != nil {
+			return nil, fmt.Errorf("instances: failed to list the instances of %q pool: %w", pool.Name, err)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Started < list[j].Started })
+		instances = append(instances, list...)
+	}
+	return instances, nil
+}
+
 func (m *Manager) Delete(ctx context.Context, instanceID string) error {
 	return m.instanceStore.Delete(ctx, instanceID)
 }
@@ -150,16 +307,50 @@ func (m *Manager) Update(ctx context.Context, instance *types.Instance) error {
 	return m.instanceStore.Update(ctx, instance)
 }
 
+// RecordHostKey records the ssh host keys of the instance, the recorded host keys are kept. The
+// instance is only updated if its state did not change in the meantime.
+func (m *Manager) RecordHostKey(ctx context.Context, instanceID, hostKey string) error {
+	inst, err := m.Find(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+	if inst.HostKey != "" {
+		return nil
+	}
+	inst.HostKey = hostKey
+	_, err = m.instanceStore.CompareAndUpdate(ctx, inst, inst.State)
+	return err
+}
+
 func (m *Manager) AddTmate(env *config.EnvConfig) error {
 	m.tmate = types.Tmate(env.Tmate)
 	return nil
 }
 
+// SetLeader sets the check whether the replica is the leader. Only the leader runs the
+// background jobs. Without the check the replica is always the leader.
+func (m *Manager) SetLeader(isLeader func() bool) {
+	m.isLeader = isLeader
+}
+
+// SetObserver sets the observer notified of the instance provisioning outcomes.
+func (m *Manager) SetObserver(observer PoolObserver) {
+	m.observer = observer
+}
+
+// IsLeader returns true if the replica runs the background jobs.
+func (m *Manager) IsLeader() bool {
+	return m.isLeader == nil || m.isLeader()
+}
+
 func (m *Manager) Add(pools ...Pool) error {
 	if len(pools) == 0 {
 		return nil
 	}
 
+	m.poolMu.Lock()
+	defer m.poolMu.Unlock()
+
 	if m.poolMap == nil {
 		m.poolMap = map[string]*poolEntry{}
 	}
@@ -183,6 +374,69 @@ func (m *Manager) Add(pools ...Pool) error {
 	return nil
 }
 
+// Upsert adds the pool or updates the settings of the pool of the same name. The settings are
+// updated in place under the lock of the pool, so the provisioning in progress, the statistics and
+// the health of the regions of the pool are kept. Instances of the pool are managed by the new driver.
+// A new image of a pool with a rollout policy is rolled out gradually instead, see rollout.
+func (m *Manager) Upsert(pool *Pool) error {
+	if pool.Name == "" {
+		return errors.New("pool must have a name")
+	}
+
+	m.poolMu.Lock()
+	if m.poolMap == nil {
+		m.poolMap = map[string]*poolEntry{}
+	}
+	entry, exists := m.poolMap[pool.Name]
+	if !exists {
+		m.poolMap[pool.Name] = &poolEntry{
+			Mutex: sync.Mutex{},
+			Pool:  *pool,
+		}
+	}
+	m.poolMu.Unlock()
+
+	// the pool might be locked for a while, e.g. while it is being built, the other pools must not wait.
+	if exists {
+		rolledOut, err := m.startRollout(entry, pool)
+		if err != nil || rolledOut {
+			return err
+		}
+		entry.Lock()
+		entry.Pool = *pool
+		entry.Unlock()
+	}
+	return nil
+}
+
+// Remove removes the pool. Instances of the pool are not destroyed, callers
+// should clean the pool first.
+func (m *Manager) Remove(name string) {
+	m.poolMu.Lock()
+	defer m.poolMu.Unlock()
+
+	delete(m.poolMap, name)
+}
+
+func (m *Manager) getPool(name string) *poolEntry {
+	m.poolMu.RLock()
+	defer m.poolMu.RUnlock()
+
+	return m.poolMap[name]
+}
+
+// pools returns a snapshot of the pools, the pools can change while the caller iterates.
+func (m *Manager) pools() []*poolEntry {
+	m.poolMu.RLock()
+	defer m.poolMu.RUnlock()
+
+	pools := make([]*poolEntry, 0, len(m.poolMap))
+	for _, pool := range m.poolMap {
+		pools = append(pools, pool)
+	}
+	return pools
+}
+
 func (m *Manager) StartInstancePurger(ctx context.Context, maxAgeBusy, maxAgeFree time.Duration) error {
 	const minMaxAge = 5 * time.Minute
 	if maxAgeBusy < minMaxAge || maxAgeFree < minMaxAge {
@@ -198,7 +452,15 @@ func (m *Manager) StartInstancePurger(ctx context.Context, maxAgeBusy, maxAgeFre
 		panic("purger already started")
 	}
 
-	d := time.Duration(maxAgeBusy.Minutes() * 0.9 * float64(time.Minute))
+	// untrusted instances might have a shorter lifetime, the purger needs to run often enough to catch them.
+	minAgeBusy := maxAgeBusy
+	for _, pool := range m.pools() {
+		if maxAge := untrustedMaxAge(pool, maxAgeBusy); maxAge < minAgeBusy {
+			minAgeBusy = maxAge
+		}
+	}
+
+	d := time.Duration(minAgeBusy.Minutes() * 0.9 * float64(time.Minute))
 	m.cleanupTimer = time.NewTicker(d)
 
 	logrus.Infof("Instance purger started. It will run every %.2f minutes", d.Minutes())
@@ -216,6 +478,10 @@ func (m *Manager) StartInstancePurger(ctx context.Context, maxAgeBusy, maxAgeFre
 				case <-ctx.Done():
 					return
 				case <-m.cleanupTimer.C:
+					if !m.IsLeader() {
+						logrus.Traceln("Skipping instance purger, the replica is not the leader")
+						return
+					}
 					logrus.Traceln("Launching instance purger")
 
 					err := m.forEach(ctx, func(ctx context.Context, pool *poolEntry) error {
@@ -234,8 +500,13 @@ func (m *Manager) StartInstancePurger(ctx context.Context, maxAgeBusy, maxAgeFre
 
 						var instances []*types.Instance
 						for _, inst := range busy {
+							maxAge := maxAgeBusy
+							if inst.Untrusted {
+								maxAge = untrustedMaxAge(pool, maxAgeBusy)
+							}
 							startedAt := time.Unix(inst.Started, 0)
-							if time.Since(startedAt) > maxAgeBusy {
+							// the stages running past the max age keep their instance while it's leased, see ExtendLease.
+							if time.Since(startedAt) > maxAge && time.Now().Unix() >= inst.LeaseExpires {
 								instances = append(instances, inst)
 							}
 						}
@@ -252,16 +523,10 @@ func (m *Manager) StartInstancePurger(ctx context.Context, maxAgeBusy, maxAgeFre
 
 						logr.Infof("purger: Terminating %d stale instances\n", len(instances))
 
-						err = pool.Driver.Destroy(ctx, instances)
+						err = m.destroyOrRetry(ctx, pool, instances, true)
 						if err != nil {
 							return fmt.Errorf("failed to delete instances of pool=%q error: %w", pool.Name, err)
 						}
-						for _, instance := range instances {
-							derr := m.Delete(ctx, instance.ID)
-							if derr != nil {
-								return fmt.Errorf("failed to delete %s from instance store with err: %s", instance.ID, derr)
-							}
-						}
 
 						err = m.buildPool(ctx, pool)
 						if err != nil {
@@ -289,16 +554,23 @@ func (m *Manager) Provision(ctx context.Context, poolName, serverName string, en
 	m.liteEnginePath = env.LiteEngine.Path
 	m.tmate = types.Tmate(env.Tmate)
 
-	pool := m.poolMap[poolName]
+	pool := m.getPool(poolName)
 	if pool == nil {
 		return nil, fmt.Errorf("provision: pool name %q not found", poolName)
 	}
+	if pool.draining.Load() {
+		return nil, ErrPoolDraining
+	}
+
+	pool.stats.pending.Add(1)
+	defer pool.stats.pending.Add(-1)
 
 	strategy := m.strategy
 	if strategy == nil {
 		strategy = Greedy{}
 	}
 
+	start := time.Now()
 	pool.Lock()
 
 	busy, free, _, err := m.List(ctx, pool)
@@ -307,46 +579,137 @@ func (m *Manager) Provision(ctx context.Context, poolName, serverName string, en
 		return nil, fmt.Errorf("provision: failed to list instances of %q pool: %w", poolName, err)
 	}
 
-	if len(free) == 0 {
-		pool.Unlock()
-		if canCreate := strategy.CanCreate(pool.MinSize, pool.MaxSize, len(busy), len(free)); !canCreate {
-			return nil, ErrorNoInstanceAvailable
-		}
-		var inst *types.Instance
-		inst, err = m.setupInstance(ctx, pool, true)
-		if err != nil {
-			return nil, fmt.Errorf("provision: failed to create instance: %w", err)
-		}
-		return inst, nil
-	}
-
 	sort.Slice(free, func(i, j int) bool {
 		iTime := time.Unix(free[i].Started, 0)
 		jTime := time.Unix(free[j].Started, 0)
 		return iTime.Before(jTime)
 	})
 
-	inst := free[0]
-	inst.State = types.StateInUse
-	err = m.instanceStore.Update(ctx, inst)
-	if err != nil {
+	// the runners sharing the store might claim the same free instance, it is claimed only if
+	// it is still free in the store.
+	account := accountID(ctx)
+	var inst *types.Instance
+	for _, candidate := range free {
+		if !ownedBy(candidate, account) {
+			continue
+		}
+		state := candidate.State
+		candidate.State = types.StateInUse
+		candidate.Claimed = time.Now().Unix()
+		candidate.AccountID = account
+		claimed, claimErr := m.instanceStore.CompareAndUpdate(ctx, candidate, state)
+		if claimErr != nil {
+			pool.Unlock()
+			return nil, fmt.Errorf("provision: failed to tag an instance in %q pool: %w", poolName, claimErr)
+		}
+		if claimed {
+			inst = candidate
+			break
+		}
+		busy = append(busy, candidate)
+	}
+
+	if inst == nil {
+		overflow, release, reserveErr := reserve(pool, strategy, busy, nil)
 		pool.Unlock()
-		return nil, fmt.Errorf("provision: failed to tag an instance in %q pool: %w", poolName, err)
+		if reserveErr != nil {
+			return nil, reserveErr
+		}
+		defer release()
+		createStart := time.Now()
+		inst, err = m.setupInstance(ctx, pool, setupOpts{inuse: true, overflow: overflow})
+		if err != nil {
+			return nil, fmt.Errorf("provision: failed to create instance: %w", err)
+		}
+		inst.Timings = &types.ProvisionTimings{
+			PoolWaitMs: createStart.Sub(start).Milliseconds(),
+			CreateMs:   time.Since(createStart).Milliseconds(),
+		}
+		return inst, nil
 	}
 	pool.Unlock()
+	inst.Timings = &types.ProvisionTimings{PoolWaitMs: time.Since(start).Milliseconds()}
 
+	// the pool is refilled once it wakes up
+	if pool.asleep() {
+		return inst, nil
+	}
 	// the go routine here uses the global context because this function is called
 	// from setup API call (and we can't use HTTP request context for async tasks)
 	go func(ctx context.Context) {
-		_, _ = m.setupInstance(ctx, pool, false)
+		_, _ = m.setupInstance(ctx, pool, setupOpts{})
 	}(m.globalCtx)
 
 	return inst, nil
 }
 
+// HasUntrustedProfile returns true if the pool defines a hardening profile for untrusted builds.
+func (m *Manager) HasUntrustedProfile(poolName string) bool {
+	pool := m.getPool(poolName)
+	return pool != nil && pool.Untrusted.Enabled
+}
+
+// ProvisionUntrusted creates a dedicated instance, hardened with the untrusted profile of the pool,
+// and tags it as in use. Untrusted instances are never taken from or returned to the free instances of the pool.
+func (m *Manager) ProvisionUntrusted(ctx context.Context, poolName, serverName string, env *config.EnvConfig) (*types.Instance, error) {
+	m.runnerName = serverName
+	m.liteEnginePath = env.LiteEngine.Path
+	m.tmate = types.Tmate(env.Tmate)
+
+	pool := m.getPool(poolName)
+	if pool == nil {
+		return nil, fmt.Errorf("provision: pool name %q not found", poolName)
+	}
+	if !pool.Untrusted.Enabled {
+		return nil, fmt.Errorf("provision: pool %q has no untrusted profile", poolName)
+	}
+	if pool.draining.Load() {
+		return nil, ErrPoolDraining
+	}
+	if capable, ok := pool.Driver.(UntrustedCapable); !ok || !capable.CanRunUntrusted() {
+		return nil, fmt.Errorf("provision: driver %s of pool %q can't harden instances for untrusted builds", pool.Driver.DriverName(), poolName)
+	}
+
+	pool.stats.pending.Add(1)
+	defer pool.stats.pending.Add(-1)
+
+	strategy := m.strategy
+	if strategy == nil {
+		strategy = Greedy{}
+	}
+
+	start := time.Now()
+	pool.Lock()
+
+	busy, free, _, err := m.List(ctx, pool)
+	if err != nil {
+		pool.Unlock()
+		return nil, fmt.Errorf("provision: failed to list instances of %q pool: %w", poolName, err)
+	}
+
+	// free instances are not hardened and can't be used, but they still count toward the pool size.
+	overflow, release, err := reserve(pool, strategy, busy, free)
+	pool.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	createStart := time.Now()
+	inst, err := m.setupInstance(ctx, pool, setupOpts{inuse: true, untrusted: true, overflow: overflow})
+	if err != nil {
+		return nil, fmt.Errorf("provision: failed to create untrusted instance: %w", err)
+	}
+	inst.Timings = &types.ProvisionTimings{
+		PoolWaitMs: createStart.Sub(start).Milliseconds(),
+		CreateMs:   time.Since(createStart).Milliseconds(),
+	}
+	return inst, nil
+}
+
 // Destroy destroys an instance in a pool.
 func (m *Manager) Destroy(ctx context.Context, poolName, instanceID string) error {
-	pool := m.poolMap[poolName]
+	pool := m.getPool(poolName)
 	if pool == nil {
 		return fmt.Errorf("provision: pool name %q not found", poolName)
 	}
@@ -356,62 +719,89 @@ func (m *Manager) Destroy(ctx context.Context, poolName, instanceID string) erro
 		return err
 	}
 
-	err = pool.Driver.Destroy(ctx, []*types.Instance{instance})
+	err = m.destroyOrRetry(ctx, pool, []*types.Instance{instance}, true)
 	if err != nil {
 		return fmt.Errorf("provision: failed to destroy an instance of %q pool: %w", poolName, err)
 	}
-
-	if derr := m.Delete(ctx, instanceID); derr != nil {
-		logrus.Warnf("failed to delete instance %s from store with err: %s", instanceID, derr)
-	}
 	return nil
 }
 
 func (m *Manager) BuildPools(ctx context.Context) error {
+	if err := m.ResolveImages(ctx); err != nil {
+		return err
+	}
 	return m.forEach(ctx, m.buildPoolWithMutex)
 }
 
+// BuildPool builds the pool of the given name.
+func (m *Manager) BuildPool(ctx context.Context, poolName string) error {
+	pool := m.getPool(poolName)
+	if pool == nil {
+		return fmt.Errorf("build_pool: pool name %q not found", poolName)
+	}
+	return m.buildPoolWithMutex(ctx, pool)
+}
+
 func (m *Manager) CleanPools(ctx context.Context, destroyBusy, destroyFree bool) error {
-	for _, pool := range m.poolMap {
-		busy, free, hibernating, err := m.List(ctx, pool)
-		if err != nil {
+	for _, pool := range m.pools() {
+		if err := m.cleanPool(ctx, pool, destroyBusy, destroyFree); err != nil {
 			return err
 		}
-		free = append(free, hibernating...)
-		var instances []*types.Instance
+	}
 
-		if destroyBusy {
-			instances = append(instances, busy...)
-		}
+	return nil
+}
 
-		if destroyFree {
-			instances = append(instances, free...)
-		}
+// CleanPool destroys the instances of the pool of the given name.
+func (m *Manager) CleanPool(ctx context.Context, poolName string, destroyBusy, destroyFree bool) error {
+	pool := m.getPool(poolName)
+	if pool == nil {
+		return fmt.Errorf("clean_pool: pool name %q not found", poolName)
+	}
+	return m.cleanPool(ctx, pool, destroyBusy, destroyFree)
+}
 
-		if len(instances) == 0 {
-			continue
-		}
-		err = pool.Driver.Destroy(ctx, instances)
-		if err != nil {
-			return err
-		}
-		for _, inst := range instances {
-			err = m.Delete(ctx, inst.ID)
-			if err != nil {
-				return err
-			}
-		}
+func (m *Manager) cleanPool(ctx context.Context, pool *poolEntry, destroyBusy, destroyFree bool) error {
+	busy, free, hibernating, err := m.List(ctx, pool)
+	if err != nil {
+		return err
 	}
+	free = append(free, hibernating...)
+	var instances []*types.Instance
 
-	return nil
+	if destroyBusy {
+		instances = append(instances, busy...)
+	}
+
+	if destroyFree {
+		instances = append(instances, free...)
+	}
+
+	if len(instances) == 0 {
+		return nil
+	}
+	return m.destroyOrRetry(ctx, pool, instances, true)
 }
 
+// PingDriver checks the drivers of the pools reach their providers, and that the cloud identities
+// attached to the instances exist.
 func (m *Manager) PingDriver(ctx context.Context) error {
-	for _, pool := range m.poolMap {
+	for _, pool := range m.pools() {
 		err := pool.Driver.Ping(ctx)
 		if err != nil {
 			return err
 		}
+		if err = checkProfile(ctx, pool.Driver); err != nil {
+			return fmt.Errorf("pool %q: %w", pool.Name, err)
+		}
+		for _, r := range pool.Regions {
+			if err = r.Driver.Ping(ctx); err != nil {
+				return fmt.Errorf("region %q: %w", r.Name, err)
+			}
+			if err = checkProfile(ctx, r.Driver); err != nil {
+				return fmt.Errorf("pool %q, region %q: %w", pool.Name, r.Name, err)
+			}
+		}
 
 		const pauseBetweenChecks = 500 * time.Millisecond
 		time.Sleep(pauseBetweenChecks)
@@ -420,10 +810,18 @@ func (m *Manager) PingDriver(ctx context.Context) error {
 	return nil
 }
 
+// checkProfile checks the cloud identity of the driver, if it attaches one to the instances.
+func checkProfile(ctx context.Context, driver Driver) error {
+	if checker, ok := driver.(ProfileChecker); ok {
+		return checker.CheckProfile(ctx)
+	}
+	return nil
+}
+
 // SetInstanceTags sets tags on an instance in a pool.
 func (m *Manager) SetInstanceTags(ctx context.Context, poolName string, instance *types.Instance,
 	tags map[string]string) error {
-	pool := m.poolMap[poolName]
+	pool := m.getPool(poolName)
 	if pool == nil {
 		return fmt.Errorf("provision: pool name %q not found", poolName)
 	}
@@ -432,7 +830,18 @@ func (m *Manager) SetInstanceTags(ctx context.Context, poolName string, instance
 		return nil
 	}
 
-	if err := pool.Driver.SetTags(ctx, instance, tags); err != nil {
+	driver, err := driverFor(pool, instance)
+	if err != nil {
+		return fmt.Errorf("provision: failed to label an instance of %q pool: %w", poolName, err)
+	}
+	if !capabilitiesOf(driver).Tags {
+		logger.FromContext(ctx).
+			WithField("pool", poolName).
+			WithField("driver", driver.DriverName()).
+			Debugln("provision: the driver does not support tags, the tags of the stage are skipped")
+		return nil
+	}
+	if err := driver.SetTags(ctx, instance, tags); err != nil {
 		return fmt.Errorf("provision: failed to label an instance of %q pool: %w", poolName, err)
 	}
 	return nil
@@ -440,12 +849,22 @@ func (m *Manager) SetInstanceTags(ctx context.Context, poolName string, instance
 
 // BuildPool populates a pool with as many instances as it's needed for the pool.
 func (m *Manager) buildPool(ctx context.Context, pool *poolEntry) error {
+	// a draining pool is not refilled, nor a pool in its off-hours.
+	if pool.draining.Load() || pool.asleep() {
+		return nil
+	}
 	instBusy, instFree, instHibernating, err := m.List(ctx, pool)
 	if err != nil {
 		return err
 	}
 	instFree = append(instFree, instHibernating...)
 
+	if claimer, ok := pool.Driver.(Claimer); ok {
+		claimer.RestoreClaims(append(append([]*types.Instance{}, instBusy...), instFree...))
+	}
+	// the overflow instances are destroyed after use, they don't take the room of the pool
+	instBusy, _ = splitOverflow(instBusy)
+
 	strategy := m.strategy
 	if strategy == nil {
 		strategy = Greedy{}
@@ -465,7 +884,7 @@ func (m *Manager) buildPool(ctx context.Context, pool *poolEntry) error {
 			instances[i] = instFree[i]
 		}
 
-		err := pool.Driver.Destroy(ctx, instances)
+		err := m.destroyOrRetry(ctx, pool, instances, true)
 		if err != nil {
 			logr.WithError(err).Errorln("build pool: failed to destroy excess instances")
 		}
@@ -483,7 +902,7 @@ func (m *Manager) buildPool(ctx context.Context, pool *poolEntry) error {
 			defer wg.Done()
 
 			// generate certs cert
-			inst, err := m.setupInstance(ctx, pool, false)
+			inst, err := m.setupInstance(ctx, pool, setupOpts{})
 			if err != nil {
 				logr.WithError(err).Errorln("build pool: failed to create instance")
 				return
@@ -509,12 +928,25 @@ func (m *Manager) buildPoolWithMutex(ctx context.Context, pool *poolEntry) error
 	return m.buildPool(ctx, pool)
 }
 
-func (m *Manager) setupInstance(ctx context.Context, pool *poolEntry, inuse bool) (*types.Instance, error) {
+// setupOpts describes the instance created by setupInstance.
+type setupOpts struct {
+	inuse     bool // the instance is taken by a stage right away
+	untrusted bool // the instance is hardened with the untrusted profile of the pool
+	overflow  bool // the instance exceeds the limit of the pool, it's destroyed after use
+}
+
+func (m *Manager) setupInstance(ctx context.Context, pool *poolEntry, opts setupOpts) (*types.Instance, error) {
 	var inst *types.Instance
 
 	// generate certs
-	createOptions, err := certs.Generate(m.runnerName)
+	createOptions, err := certs.Generate(m.runnerName, pool.CA)
+	if err != nil {
+		logrus.WithError(err).
+			Errorln("manager: failed to generate certificates")
+		return nil, err
+	}
 	createOptions.LiteEnginePath = m.liteEnginePath
+	createOptions.LiteEnginePort = liteEnginePort(&pool.Pool)
 	createOptions.Platform = pool.Platform
 	createOptions.PoolName = pool.Name
 	createOptions.Limit = pool.MaxSize
@@ -522,32 +954,112 @@ func (m *Manager) setupInstance(ctx context.Context, pool *poolEntry, inuse bool
 	createOptions.HarnessTestBinaryURI = m.harnessTestBinaryURI
 	createOptions.PluginBinaryURI = m.pluginBinaryURI
 	createOptions.Tmate = m.tmate
+	createOptions.StartupScript = pool.StartupScript
+	createOptions.Slim = pool.Slim
+	createOptions.Tuning = pool.Tuning
+	createOptions.Checksums = pool.Checksums
+	createOptions.WindowsContainers = pool.WindowsContainers
+	createOptions.LiteEngineEnv = pool.LiteEngineEnv
+	createOptions.LiteEngineFeatures = pool.LiteEngineFeatures
+	if pool.LeaseTokens {
+		if createOptions.LeaseKey, err = leasetoken.NewKey(); err != nil {
+			return nil, fmt.Errorf("manager: failed to generate the lease key: %w", err)
+		}
+	}
+	keyRef, err := m.publishKey(ctx, pool, createOptions)
 	if err != nil {
-		logrus.WithError(err).
-			Errorln("manager: failed to generate certificates")
 		return nil, err
 	}
+	// the resized instances are restarted
+	createOptions.Persistent = len(pool.Sizes) > 0
+	if opts.untrusted {
+		createOptions.Untrusted = &pool.Untrusted
+	}
+	if pool.Tunnel {
+		srv := tunnel.Default()
+		if srv == nil {
+			return nil, fmt.Errorf("manager: pool %q uses the tunnel but the tunnel server is not enabled", pool.Name)
+		}
+		createOptions.Tunnel = &types.Tunnel{Address: srv.Address(), KnownHosts: srv.KnownHosts()}
+	}
+	if pool.NameTemplate != nil {
+		if createOptions.Name, err = pool.NameTemplate.Render(m.runnerName, pool.Name, stageID(ctx), time.Now()); err != nil {
+			return nil, err
+		}
+	}
 	// create instance
-	inst, err = pool.Driver.Create(ctx, createOptions)
+	inst, err = m.createInstance(ctx, pool, createOptions)
+	if err != nil {
+		m.revokeKey(context.Background(), pool, keyRef)
+	}
+	if err != nil && setupCancelled(ctx) {
+		return nil, fmt.Errorf("%w: %s", ErrSetupCancelled, err)
+	}
 	if err != nil {
 		logrus.WithError(err).
 			Errorln("manager: failed to create instance")
-		return nil, err
+		return nil, m.instanceCreateFailed(pool, err)
+	}
+	inst.KeyRef = keyRef
+	// the drivers not watching the context complete the creation of the instance of a cancelled setup
+	if opts.inuse && setupCancelled(ctx) {
+		_ = m.destroyOrRetry(context.Background(), pool, []*types.Instance{inst}, false)
+		return nil, ErrSetupCancelled
+	}
+
+	if pool.Bootstrapper != nil {
+		if err = m.bootstrapInstance(ctx, pool, inst, createOptions); err != nil {
+			logrus.WithError(err).
+				WithField("instance", inst.ID).
+				Errorln("manager: failed to bootstrap instance")
+			_ = m.destroyOrRetry(context.Background(), pool, []*types.Instance{inst}, false)
+			if setupCancelled(ctx) {
+				return nil, fmt.Errorf("%w: %s", ErrSetupCancelled, err)
+			}
+			return nil, m.instanceCreateFailed(pool, err)
+		}
+	}
+
+	if len(pool.Routes) > 0 {
+		if inst.Route, err = route.Select(ctx, inst, pool.Routes, liteEnginePort(&pool.Pool)); err != nil {
+			logrus.WithError(err).
+				WithField("instance", inst.ID).
+				Errorln("manager: failed to find a route to the instance")
+			_ = m.destroyOrRetry(context.Background(), pool, []*types.Instance{inst}, false)
+			if setupCancelled(ctx) {
+				return nil, fmt.Errorf("%w: %s", ErrSetupCancelled, err)
+			}
+			return nil, m.instanceCreateFailed(pool, err)
+		}
 	}
 
-	if inuse {
+	if opts.inuse {
 		inst.State = types.StateInUse
+		inst.Claimed = time.Now().Unix()
+		inst.AccountID = accountID(ctx)
 	}
+	inst.Untrusted = opts.untrusted
+	inst.Overflow = opts.overflow
+	inst.Tunnel = pool.Tunnel
+	inst.LiteEngineEnv = strings.Join(cloudinit.EnvNames(pool.LiteEngineEnv), ",")
+	inst.LiteEngineFeatures = strings.Join(pool.LiteEngineFeatures, ",")
+	inst.LeaseKey = createOptions.LeaseKey
 
 	err = m.instanceStore.Create(ctx, inst)
 	if err != nil {
 		logrus.WithError(err).
 			Errorln("manager: failed to store instance")
-		_ = pool.Driver.Destroy(ctx, []*types.Instance{inst})
+		_ = m.destroyOrRetry(ctx, pool, []*types.Instance{inst}, false)
 		return nil, err
 	}
 
-	if !inuse {
+	pool.stats.record(false)
+	if m.observer != nil {
+		m.observer.InstanceCreated(pool.Name)
+	}
+	m.events.Publish(events.ForInstance(events.InstanceCreated, inst))
+
+	if !opts.inuse {
 		go func() {
 			herr := m.hibernateWithRetries(context.Background(), pool.Name, inst.ID)
 			if herr != nil {
@@ -558,8 +1070,47 @@ func (m *Manager) setupInstance(ctx context.Context, pool *poolEntry, inuse bool
 	return inst, nil
 }
 
+// instanceCreateFailed records a failed creation of an instance of the pool and returns the error.
+func (m *Manager) instanceCreateFailed(pool *poolEntry, err error) error {
+	pool.stats.record(true)
+	m.recordRolloutOutcome(pool.Name, true)
+	if m.observer != nil {
+		m.observer.InstanceCreateFailed(pool.Name, err)
+	}
+	m.events.Publish(&events.Event{
+		Type:   events.InstanceCreateFailed,
+		Pool:   pool.Name,
+		Driver: pool.Driver.DriverName(),
+		Error:  err.Error(),
+	})
+	m.alerter.Raise(alert.Alert{
+		Key:      "provision:" + pool.Name + ":" + alert.Fingerprint(err.Error()),
+		Severity: alert.SeverityError,
+		Summary:  fmt.Sprintf("pool %s fails to create instances", pool.Name),
+		Pool:     pool.Name,
+		Error:    err.Error(),
+	})
+	return err
+}
+
+// bootstrapInstance installs the lite-engine on an instance of a pool which can't run user data.
+func (m *Manager) bootstrapInstance(ctx context.Context, pool *poolEntry, inst *types.Instance, opts *types.InstanceCreateOpts) error {
+	script, err := lehelper.GenerateStartupScript(opts)
+	if err != nil {
+		return err
+	}
+	// the console output lets the bootstrapper verify the host key of the instance
+	var console lehelper.ConsoleFunc
+	if driver, driverErr := driverFor(pool, inst); driverErr == nil && capabilitiesOf(driver).ConsoleLogs {
+		console = func(ctx context.Context) (string, error) {
+			return driver.Logs(ctx, inst.ID)
+		}
+	}
+	return pool.Bootstrapper.Bootstrap(ctx, inst, script, console)
+}
+
 func (m *Manager) StartInstance(ctx context.Context, poolName, instanceID string) (*types.Instance, error) {
-	pool := m.poolMap[poolName]
+	pool := m.getPool(poolName)
 	if pool == nil {
 		return nil, fmt.Errorf("start_instance: pool name %q not found", poolName)
 	}
@@ -574,30 +1125,66 @@ func (m *Manager) StartInstance(ctx context.Context, poolName, instanceID string
 	}
 
 	logrus.WithField("instanceID", instanceID).Infoln("Starting vm from hibernate state")
-	ipAddress, err := pool.Driver.Start(ctx, instanceID, poolName)
+	driver, err := driverFor(pool, inst)
+	if err != nil {
+		return nil, fmt.Errorf("start_instance: %w", err)
+	}
+	startCtx, cancel := withTimeout(ctx, pool.Timeouts.StartSecs)
+	ipAddress, err := driver.Start(startCtx, instanceID, poolName)
+	cancel()
 	if err != nil {
 		return nil, fmt.Errorf("start_instance: failed to start the instance %s of %q pool: %w", instanceID, poolName, err)
 	}
 
 	inst.IsHibernated = false
-	inst.Address = ipAddress
+	if ipAddress != "" {
+		inst.Address = ipAddress
+	}
+	// the address of the started instance might be assigned after the start, and its private
+	// address might change too.
+	if _, resolveErr := resolveAddress(ctx, driver, inst); resolveErr != nil {
+		logrus.WithError(resolveErr).WithField("instanceID", instanceID).Warnln("start_instance: the address of the started instance might be stale")
+	}
 	if err := m.instanceStore.Update(ctx, inst); err != nil {
 		return nil, fmt.Errorf("start_instance: failed to update instance store %s of %q pool: %w", instanceID, poolName, err)
 	}
+	m.events.Publish(events.ForInstance(events.InstanceStarted, inst))
 	return inst, nil
 }
 
 func (m *Manager) InstanceLogs(ctx context.Context, poolName, instanceID string) (string, error) {
-	pool := m.poolMap[poolName]
+	pool := m.getPool(poolName)
 	if pool == nil {
 		return "", fmt.Errorf("instance_logs: pool name %q not found", poolName)
 	}
 
-	return pool.Driver.Logs(ctx, instanceID)
+	driver := pool.Driver
+	if inst, findErr := m.Find(ctx, instanceID); findErr == nil {
+		var err error
+		if driver, err = driverFor(pool, inst); err != nil {
+			return "", fmt.Errorf("instance_logs: %w", err)
+		}
+	}
+	if !capabilitiesOf(driver).ConsoleLogs {
+		return "", fmt.Errorf("instance_logs: console logs of the %s driver: %w", driver.DriverName(), ErrNotSupported)
+	}
+	return driver.Logs(ctx, instanceID)
+}
+
+// Hibernate hibernates the free instance of the pool, an instance in use is left running.
+func (m *Manager) Hibernate(ctx context.Context, poolName, instanceID string) error {
+	pool := m.getPool(poolName)
+	if pool == nil {
+		return fmt.Errorf("hibernate: pool name %q not found", poolName)
+	}
+	if !pool.Driver.CanHibernate() {
+		return fmt.Errorf("hibernate: the %s driver: %w", pool.Driver.DriverName(), ErrNotSupported)
+	}
+	return m.hibernate(ctx, instanceID, poolName, pool)
 }
 
 func (m *Manager) hibernateWithRetries(ctx context.Context, poolName, instanceID string) error {
-	pool := m.poolMap[poolName]
+	pool := m.getPool(poolName)
 	if pool == nil {
 		return fmt.Errorf("hibernate: pool name %q not found", poolName)
 	}
@@ -652,7 +1239,13 @@ func (m *Manager) hibernate(ctx context.Context, instanceID, poolName string, po
 	pool.Unlock()
 
 	logrus.WithField("instanceID", instanceID).Infoln("Hibernating vm")
-	if err = pool.Driver.Hibernate(ctx, instanceID, poolName); err != nil {
+	driver, err := driverFor(pool, inst)
+	if err == nil {
+		hibernateCtx, cancel := withTimeout(ctx, pool.Timeouts.HibernateSecs)
+		err = driver.Hibernate(hibernateCtx, instanceID, poolName)
+		cancel()
+	}
+	if err != nil {
 		if uerr := m.updateInstState(ctx, pool, instanceID, types.StateCreated); uerr != nil {
 			logrus.WithError(err).WithField("instanceID", instanceID).Errorln("failed to update state for failed hibernation")
 		}
@@ -672,6 +1265,7 @@ func (m *Manager) hibernate(ctx context.Context, instanceID, poolName string, po
 		return fmt.Errorf("hibernate: failed to update instance in db %s of %q pool: %w", instanceID, poolName, err)
 	}
 	pool.Unlock()
+	m.events.Publish(events.ForInstance(events.InstanceHibernated, inst))
 	return nil
 }
 
@@ -691,8 +1285,111 @@ func (m *Manager) updateInstState(ctx context.Context, pool *poolEntry, instance
 	return nil
 }
 
+// createInstance creates an instance with the driver of the pool. Instances of a multi-region
+// pool are placed in the most suitable region, falling back to the other regions on failure.
+func (m *Manager) createInstance(ctx context.Context, pool *poolEntry, opts *types.InstanceCreateOpts) (*types.Instance, error) {
+	if err := m.restoreClaims(ctx, pool); err != nil {
+		return nil, err
+	}
+	if len(pool.Regions) == 0 {
+		createCtx, cancel := withTimeout(ctx, pool.Timeouts.CreateSecs)
+		defer cancel()
+		return pool.Driver.Create(createCtx, opts)
+	}
+
+	var err error
+	candidates := pool.regions.candidates(pool.Regions, regionHint(ctx))
+	for i := range candidates {
+		r := &candidates[i]
+		startTime := time.Now()
+		var inst *types.Instance
+		createCtx, cancel := withTimeout(ctx, pool.Timeouts.CreateSecs)
+		inst, err = r.Driver.Create(createCtx, opts)
+		cancel()
+		if err == nil {
+			pool.regions.succeeded(r, time.Since(startTime))
+			inst.Region = r.Name
+			return inst, nil
+		}
+		pool.regions.failed(r, err)
+		logrus.WithError(err).
+			WithField("pool", pool.Name).
+			WithField("region", r.placement()).
+			Warnln("manager: failed to create instance in region, trying the next one")
+	}
+	return nil, err
+}
+
+// restoreClaims refreshes the claims of a pool handing out pre-existing machines from the store, the
+// machines might have been claimed or released by another runner since.
+func (m *Manager) restoreClaims(ctx context.Context, pool *poolEntry) error {
+	claimer, ok := pool.Driver.(Claimer)
+	if !ok {
+		return nil
+	}
+	instances, err := m.instanceStore.List(ctx, pool.Name, nil)
+	if err != nil {
+		return fmt.Errorf("failed to list the claimed machines of %q pool: %w", pool.Name, err)
+	}
+	claimer.RestoreClaims(instances)
+	return nil
+}
+
+// destroyInstances destroys the instances with the drivers managing them.
+func (m *Manager) destroyInstances(ctx context.Context, pool *poolEntry, instances []*types.Instance) error {
+	for _, inst := range instances {
+		logger.FromContext(ctx).
+			WithField("pool", pool.Name).
+			WithField("instance_id", inst.ID).
+			WithField("provider_id", inst.ProviderID).
+			Traceln("manager: destroying instance")
+		route.Close(inst.ID)
+		m.revokeKey(ctx, pool, inst.KeyRef)
+	}
+
+	ctx, cancel := withTimeout(ctx, pool.Timeouts.DestroySecs)
+	defer cancel()
+	if len(pool.Regions) == 0 {
+		return pool.Driver.Destroy(ctx, instances)
+	}
+
+	byDriver := make(map[Driver][]*types.Instance)
+	for _, inst := range instances {
+		driver, err := driverFor(pool, inst)
+		if err != nil {
+			return err
+		}
+		byDriver[driver] = append(byDriver[driver], inst)
+	}
+	for driver, driverInstances := range byDriver {
+		if err := driver.Destroy(ctx, driverInstances); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// liteEnginePort returns the port the lite-engine of the instances of the pool listens on.
+func liteEnginePort(pool *Pool) int {
+	if pool.LiteEnginePort == 0 {
+		return lehelper.LiteEnginePort
+	}
+	return pool.LiteEnginePort
+}
+
+// untrustedMaxAge returns the lifetime of an untrusted instance of the pool.
+func untrustedMaxAge(pool *poolEntry, maxAgeBusy time.Duration) time.Duration {
+	if !pool.Untrusted.Enabled || pool.Untrusted.MaxAgeMins <= 0 {
+		return maxAgeBusy
+	}
+	if maxAge := time.Duration(pool.Untrusted.MaxAgeMins) * time.Minute; maxAge < maxAgeBusy {
+		return maxAge
+	}
+	return maxAgeBusy
+}
+
 func (m *Manager) forEach(ctx context.Context, f func(ctx context.Context, pool *poolEntry) error) error {
-	for _, pool := range m.poolMap {
+	for _, pool := range m.pools() {
 		err := f(ctx, pool)
 		if err != nil {
 			return err
@@ -734,8 +1431,11 @@ func (m *Manager) checkInstanceConnectivity(ctx context.Context, instanceID stri
 		return errors.New("instance has not received IP address")
 	}
 
-	endpoint := fmt.Sprintf("https://%s:9079/", instance.Address)
-	client, err := lehttp.NewHTTPClient(endpoint, m.runnerName, string(instance.CACert), string(instance.TLSCert), string(instance.TLSKey))
+	port := instance.Port
+	if port == 0 {
+		port = lehelper.LiteEnginePort
+	}
+	client, err := lehelper.GetClient(instance, m.runnerName, port, false, 0)
 	if err != nil {
 		return errors.Wrap(err, "failed to create client")
 	}
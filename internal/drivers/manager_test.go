@@ -0,0 +1,287 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// failingDriver fails to create instances, so the pools only hand out the stored instances.
+type failingDriver struct{}
+
+func (failingDriver) Create(context.Context, *types.InstanceCreateOpts) (*types.Instance, error) {
+	return nil, errors.New("create is not supported")
+}
+func (failingDriver) Destroy(context.Context, []*types.Instance) error                  { return nil }
+func (failingDriver) Hibernate(context.Context, string, string) error                   { return nil }
+func (failingDriver) Start(context.Context, string, string) (string, error)             { return "", nil }
+func (failingDriver) SetTags(context.Context, *types.Instance, map[string]string) error { return nil }
+func (failingDriver) Ping(context.Context) error                                        { return nil }
+func (failingDriver) Logs(context.Context, string) (string, error)                      { return "", nil }
+func (failingDriver) RootDir() string                                                   { return "" }
+func (failingDriver) DriverName() string                                                { return "failing" }
+func (failingDriver) CanHibernate() bool                                                { return false }
+
+// listBarrier holds the listing runners until all of them have listed the instances, so they all
+// see the same free instances.
+type listBarrier struct {
+	store.InstanceStore
+	wg sync.WaitGroup
+}
+
+func (b *listBarrier) List(ctx context.Context, pool string, params *types.QueryParams) ([]*types.Instance, error) {
+	list, err := b.InstanceStore.List(ctx, pool, params)
+	b.wg.Done()
+	b.wg.Wait()
+	return list, err
+}
+
+// TestProvisionSharedStore verifies that the runners sharing a store never hand out the same instance.
+func TestProvisionSharedStore(t *testing.T) {
+	const pool = "linux"
+
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	instanceStore := &listBarrier{InstanceStore: ldb.NewInstanceStore(db)}
+	free := &types.Instance{ID: "instance", Pool: pool, State: types.StateCreated, Started: time.Now().Unix()}
+	if err = instanceStore.Create(ctx, free); err != nil {
+		t.Fatal(err)
+	}
+
+	env := &config.EnvConfig{}
+	managers := []*Manager{New(ctx, instanceStore, env), New(ctx, instanceStore, env)}
+	instanceStore.wg.Add(len(managers))
+
+	var wg sync.WaitGroup
+	results := make([]error, len(managers))
+	for i, m := range managers {
+		if err = m.Add(Pool{Name: pool, MaxSize: 1, Driver: failingDriver{}}); err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(i int, m *Manager) {
+			defer wg.Done()
+			_, results[i] = m.Provision(ctx, pool, "runner", env)
+		}(i, m)
+	}
+	wg.Wait()
+
+	// the runner losing the instance falls back to creating one, which the driver fails.
+	claimed := 0
+	for _, err := range results {
+		if err == nil {
+			claimed++
+		}
+	}
+	if claimed != 1 {
+		t.Errorf("expected the instance to be claimed by one runner, claimed %d times", claimed)
+	}
+
+	inst, err := instanceStore.Find(ctx, free.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inst.State != types.StateInUse {
+		t.Errorf("expected the instance to be in use, got %s", inst.State)
+	}
+}
+
+func TestUpsertKeepsPoolState(t *testing.T) {
+	m := New(context.Background(), nil, &config.EnvConfig{})
+	if err := m.Upsert(&Pool{Name: "linux", MaxSize: 1, Driver: failingDriver{}}); err != nil {
+		t.Fatal(err)
+	}
+	entry := m.getPool("linux")
+	entry.stats.record(true)
+
+	if err := m.Upsert(&Pool{Name: "linux", MaxSize: 5, Driver: failingDriver{}}); err != nil {
+		t.Fatal(err)
+	}
+	if m.getPool("linux") != entry {
+		t.Fatal("the pool must be updated in place")
+	}
+	if entry.MaxSize != 5 {
+		t.Errorf("expected the settings to be updated, got max size %d", entry.MaxSize)
+	}
+	if _, attempts := entry.stats.failureRate(); attempts != 1 {
+		t.Errorf("expected the statistics to be kept, got %d create attempts", attempts)
+	}
+}
+
+func TestTerminatingInstances(t *testing.T) {
+	const pool = "linux"
+
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	instanceStore := ldb.NewInstanceStore(db)
+	for _, inst := range []*types.Instance{
+		{ID: "inuse", Pool: pool, Stage: "stage-1", State: types.StateInUse},
+		{ID: "terminating", Pool: pool, Stage: "stage-2", State: types.StateTerminating},
+		{ID: "retried", Pool: pool, Stage: "stage-3", State: types.StateTerminating},
+	} {
+		if err = instanceStore.Create(ctx, inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	retryStore := ldb.NewDestroyRetryStore(db)
+	if err = retryStore.Create(ctx, &types.DestroyRetry{InstanceID: "retried", PoolName: pool}); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(ctx, instanceStore, &config.EnvConfig{})
+	m.SetDestroyRetryStore(retryStore)
+	if err = m.Add(Pool{Name: pool, Driver: failingDriver{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	instances, err := m.TerminatingInstances(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1 || instances[0].ID != "terminating" {
+		t.Errorf("expected the terminating instance not retried, got %v", instances)
+	}
+
+	// the instance of a stage queued for destruction is only returned to destroy it.
+	if _, err = m.GetInstanceByStageID(ctx, pool, "stage-2"); err == nil {
+		t.Error("expected the terminating instance not to be returned")
+	}
+	if inst, getErr := m.GetInstanceToDestroy(ctx, pool, "stage-2"); getErr != nil || inst.ID != "terminating" {
+		t.Errorf("expected the terminating instance to be returned to destroy it, got %v, %v", inst, getErr)
+	}
+}
+
+// stuckDriver fails to destroy the instances in the stuck set.
+type stuckDriver struct {
+	failingDriver
+	stuck map[string]bool
+}
+
+func (d stuckDriver) Destroy(_ context.Context, instances []*types.Instance) error {
+	for _, inst := range instances {
+		if d.stuck[inst.ID] {
+			return errors.New("destroy failed")
+		}
+	}
+	return nil
+}
+
+// TestCleanPoolQueuesFailedDestroys verifies that the instances the driver fails to destroy are
+// kept in the store and queued for retry, while the others are deleted.
+func TestCleanPoolQueuesFailedDestroys(t *testing.T) {
+	const pool = "linux"
+
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	instanceStore := ldb.NewInstanceStore(db)
+	for _, inst := range []*types.Instance{
+		{ID: "destroyed", Pool: pool, State: types.StateCreated},
+		{ID: "stuck", Pool: pool, State: types.StateCreated},
+	} {
+		if err = instanceStore.Create(ctx, inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	retryStore := ldb.NewDestroyRetryStore(db)
+
+	m := New(ctx, instanceStore, &config.EnvConfig{})
+	m.SetDestroyRetryStore(retryStore)
+	if err = m.Add(Pool{Name: pool, Driver: stuckDriver{stuck: map[string]bool{"stuck": true}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = m.cleanPool(ctx, m.getPool(pool), true, true); err == nil {
+		t.Error("expected the failed destroy to be reported")
+	}
+
+	if _, err = instanceStore.Find(ctx, "destroyed"); err == nil {
+		t.Error("expected the destroyed instance to be deleted")
+	}
+	inst, err := instanceStore.Find(ctx, "stuck")
+	if err != nil {
+		t.Fatalf("expected the instance failed to destroy to be kept, got %s", err)
+	}
+	if inst.State != types.StateTerminating {
+		t.Errorf("expected the instance failed to destroy to be terminating, got %s", inst.State)
+	}
+	if _, err = retryStore.Find(ctx, "stuck"); err != nil {
+		t.Errorf("expected the failed destroy to be queued for retry, got %s", err)
+	}
+}
+
+func TestInstances(t *testing.T) {
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	instanceStore := ldb.NewInstanceStore(db)
+	for _, inst := range []*types.Instance{
+		{ID: "linux-2", Pool: "linux", State: types.StateInUse, Started: 2, AccountID: "a"},
+		{ID: "linux-1", Pool: "linux", State: types.StateCreated, Started: 1},
+		{ID: "windows-1", Pool: "windows", State: types.StateInUse, Started: 3, AccountID: "b"},
+	} {
+		if err = instanceStore.Create(ctx, inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := New(ctx, instanceStore, &config.EnvConfig{})
+	if err = m.Add(Pool{Name: "linux", Driver: failingDriver{}}, Pool{Name: "windows", Driver: failingDriver{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := func(instances []*types.Instance) []string {
+		var out []string
+		for _, inst := range instances {
+			out = append(out, inst.ID)
+		}
+		return out
+	}
+	tests := []struct {
+		ctx  context.Context
+		pool string
+		want []string
+	}{
+		{ctx: ctx, want: []string{"linux-1", "linux-2", "windows-1"}},
+		{ctx: ctx, pool: "linux", want: []string{"linux-1", "linux-2"}},
+		{ctx: WithAccountID(ctx, "b"), want: []string{"linux-1", "windows-1"}},
+	}
+	for _, test := range tests {
+		instances, listErr := m.Instances(test.ctx, test.pool)
+		if listErr != nil {
+			t.Fatal(listErr)
+		}
+		if got := ids(instances); strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("expected the instances %v of the pool %q, got %v", test.want, test.pool, got)
+		}
+	}
+	if _, err = m.Instances(ctx, "macos"); err == nil {
+		t.Error("expected an error listing the instances of an unknown pool")
+	}
+}
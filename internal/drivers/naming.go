@@ -0,0 +1,132 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/dchest/uniuri"
+)
+
+// nameRandomLen is the length of the random part of the instance names.
+const nameRandomLen = 8
+
+// nameTimestampLayout is the layout of the creation time in the instance names, it sorts lexically.
+const nameTimestampLayout = "20060102150405"
+
+// NameTemplate renders the names of the instances of a pool from a text/template, for example
+// "ci-{{.Pool}}-{{.Stage}}-{{.Random}}". The text outside the actions is the prefix of the names.
+type NameTemplate struct {
+	text string
+	tmpl *template.Template
+}
+
+// NameData are the fields of a name template.
+type NameData struct {
+	Runner    string // name of the runner
+	Pool      string // name of the pool
+	Stage     string // runtime ID of the stage, empty for the instances created for the free pool
+	Random    string // random lowercase alphanumeric characters, unique per instance
+	Timestamp string // creation time in UTC, formatted as 20060102150405
+}
+
+// ParseNameTemplate parses the name template of a pool. The template must use the random part,
+// the names of the instances created at the same time would clash otherwise.
+func ParseNameTemplate(text string) (*NameTemplate, error) {
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name template: %w", err)
+	}
+	if !strings.Contains(text, ".Random") {
+		return nil, errors.New("invalid name template: the template must contain {{.Random}}")
+	}
+	t := &NameTemplate{text: text, tmpl: tmpl}
+	if _, err = t.Render("runner", "pool", "stage", time.Now()); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Render returns the name of a new instance, the drivers make it fit the naming rules of
+// their provider, see NameConstraints.
+func (t *NameTemplate) Render(runner, pool, stage string, now time.Time) (string, error) {
+	data := NameData{
+		Runner:    runner,
+		Pool:      pool,
+		Stage:     stage,
+		Random:    strings.ToLower(uniuri.NewLen(nameRandomLen)),
+		Timestamp: now.UTC().Format(nameTimestampLayout),
+	}
+	var sb strings.Builder
+	if err := t.tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("failed to render name template %q: %w", t.text, err)
+	}
+	return sb.String(), nil
+}
+
+func (t *NameTemplate) String() string {
+	return t.text
+}
+
+// NameConstraints are the naming rules of the instances of a provider. The names only keep
+// letters, digits, dashes and the extra characters, the other characters are replaced by dashes.
+type NameConstraints struct {
+	MaxLen      int    // maximum length of the names
+	Lower       bool   // the names are lowercase
+	LetterFirst bool   // the names start with a letter
+	Extra       string // characters allowed besides letters, digits and dashes
+}
+
+// Sanitize makes a rendered name fit the constraints. The names too long lose their middle, the
+// prefix and the suffix, usually the random part, are kept.
+func (c NameConstraints) Sanitize(name string) string {
+	if c.Lower {
+		name = strings.ToLower(name)
+	}
+	var sb strings.Builder
+	dash := false
+	for _, r := range name {
+		if !c.allowed(r) {
+			r = '-'
+		}
+		// collapse the dashes left by the empty fields and the replaced characters
+		if r == '-' && dash {
+			continue
+		}
+		dash = r == '-'
+		sb.WriteRune(r)
+	}
+	name = strings.Trim(sb.String(), "-")
+	if c.LetterFirst && (name == "" || !isLetter(rune(name[0]))) {
+		name = "i-" + name
+	}
+	if c.MaxLen > 0 && len(name) > c.MaxLen {
+		keep := c.MaxLen - nameRandomLen - 1
+		name = strings.TrimRight(name[:keep], "-") + "-" + strings.TrimLeft(name[len(name)-nameRandomLen:], "-")
+	}
+	return strings.Trim(name, "-")
+}
+
+func (c NameConstraints) allowed(r rune) bool {
+	return isLetter(r) || (r >= '0' && r <= '9') || r == '-' || strings.ContainsRune(c.Extra, r)
+}
+
+func isLetter(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+type stageIDKey struct{}
+
+// WithStageID returns a context carrying the runtime ID of the stage an instance is provisioned
+// for, the name templates of the pools use it.
+func WithStageID(ctx context.Context, stageID string) context.Context {
+	return context.WithValue(ctx, stageIDKey{}, stageID)
+}
+
+func stageID(ctx context.Context) string {
+	id, _ := ctx.Value(stageIDKey{}).(string)
+	return id
+}
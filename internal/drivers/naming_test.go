@@ -0,0 +1,52 @@
+package drivers
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseNameTemplate(t *testing.T) {
+	for _, text := range []string{"ci-{{.Pool}}", "ci-{{.Random", "{{.Random}}-{{.Unknown}}"} {
+		if _, err := ParseNameTemplate(text); err == nil {
+			t.Errorf("expected an error parsing %q", text)
+		}
+	}
+
+	tmpl, err := ParseNameTemplate("ci-{{.Pool}}-{{.Stage}}-{{.Timestamp}}-{{.Random}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	name, err := tmpl.Render("runner", "linux", "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^ci-linux--20230405060708-[a-z0-9]{8}$`).MatchString(name) {
+		t.Errorf("unexpected name %q", name)
+	}
+	other, _ := tmpl.Render("runner", "linux", "", now)
+	if other == name {
+		t.Errorf("expected the random parts of the names to differ, got %q twice", name)
+	}
+}
+
+func TestNameConstraintsSanitize(t *testing.T) {
+	tests := []struct {
+		name        string
+		constraints NameConstraints
+		want        string
+	}{
+		{"ci-linux--abcd1234", NameConstraints{}, "ci-linux-abcd1234"},
+		{"CI_Linux Pool.abcd1234", NameConstraints{Lower: true}, "ci-linux-pool-abcd1234"},
+		{"CI_Linux Pool.abcd1234", NameConstraints{Extra: "._"}, "CI_Linux-Pool.abcd1234"},
+		{"-1234-abcd1234-", NameConstraints{LetterFirst: true}, "i-1234-abcd1234"},
+		{"ci-" + strings.Repeat("x", 80) + "-abcd1234", NameConstraints{MaxLen: 20}, "ci-xxxxxxxx-abcd1234"},
+	}
+	for _, test := range tests {
+		if got := test.constraints.Sanitize(test.name); got != test.want {
+			t.Errorf("Sanitize(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package nomad
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone/runner-go/logger"
+	"github.com/hashicorp/nomad/api"
+)
+
+// runnerJobPrefixes are the prefixes of the IDs of the jobs registered by the runner.
+var runnerJobPrefixes = []string{initJobID(""), destroyJobID(""), "janitor_job_", "prefetch_job_"}
+
+// Capacity returns the resources of the nodes of the cluster, the resources reserved by the
+// allocations on the nodes and the part of them reserved by the jobs of the runner.
+func (p *config) Capacity(ctx context.Context) ([]drivers.NodeCapacity, error) {
+	q := (&api.QueryOptions{}).WithContext(ctx)
+	stubs, _, err := p.client.Nodes().List(q)
+	if err != nil {
+		return nil, fmt.Errorf("scheduler: could not list the nodes: %w", err)
+	}
+
+	capacities := make([]drivers.NodeCapacity, 0, len(stubs))
+	for _, stub := range stubs {
+		node, _, nodeErr := p.client.Nodes().Info(stub.ID, q)
+		if nodeErr != nil {
+			return nil, fmt.Errorf("scheduler: could not get the node %s: %w", stub.ID, nodeErr)
+		}
+		allocs, _, allocErr := p.client.Nodes().Allocations(stub.ID, q)
+		if allocErr != nil {
+			return nil, fmt.Errorf("scheduler: could not list the allocations of the node %s: %w", stub.ID, allocErr)
+		}
+		capacities = append(capacities, nodeCapacity(node, allocs))
+	}
+	return capacities, nil
+}
+
+// nodeCapacity sums up the resources of the allocations on the node which are not terminal.
+func nodeCapacity(node *api.Node, allocs []*api.Allocation) drivers.NodeCapacity {
+	c := drivers.NodeCapacity{
+		ID:         node.ID,
+		Name:       node.Name,
+		Datacenter: node.Datacenter,
+		Status:     node.Status,
+		Eligible:   isEligible(node.Status, node.SchedulingEligibility, node.Drain),
+	}
+	c.CPUTotalMhz, c.MemoryTotalMB = allocatable(node.NodeResources, node.ReservedResources)
+
+	for _, alloc := range allocs {
+		if alloc.ClientTerminalStatus() || alloc.ServerTerminalStatus() || alloc.AllocatedResources == nil {
+			continue
+		}
+		var cpu, mem int64
+		for _, task := range alloc.AllocatedResources.Tasks {
+			cpu += task.Cpu.CpuShares
+			mem += task.Memory.MemoryMB
+		}
+		c.CPUReservedMhz += cpu
+		c.MemoryReservedMB += mem
+		if isRunnerJob(alloc.JobID) {
+			c.CPURunnerMhz += cpu
+			c.MemoryRunnerMB += mem
+		}
+	}
+
+	c.CPUFreeMhz = max64(c.CPUTotalMhz-c.CPUReservedMhz, 0)
+	c.MemoryFreeMB = max64(c.MemoryTotalMB-c.MemoryReservedMB, 0)
+	return c
+}
+
+// checkCapacity returns an error if no eligible node of the datacenter has the CPU in MHz and the
+// memory in MB, even with no other allocation on it. The resources in use are not taken into
+// account, the resource job waits for them to be released.
+func (p *config) checkCapacity(ctx context.Context, cpu, mem int) error {
+	q := (&api.QueryOptions{Params: map[string]string{"resources": "true"}}).WithContext(ctx)
+	stubs, _, err := p.client.Nodes().List(q)
+	if err != nil {
+		// the nodes might not be readable with the token of the runner, the scheduler decides then.
+		logger.FromContext(ctx).WithError(err).Warnln("scheduler: could not list the nodes to check the capacity")
+		return nil
+	}
+	return fitsNode(stubs, cpu, mem)
+}
+
+// fitsNode returns an error if none of the eligible nodes of the datacenter has the CPU and the memory.
+func fitsNode(stubs []*api.NodeListStub, cpu, mem int) error {
+	eligible := 0
+	var maxCPU, maxMem int64
+	for _, stub := range stubs {
+		if stub.Datacenter != datacenter || !isEligible(stub.Status, stub.SchedulingEligibility, stub.Drain) {
+			continue
+		}
+		eligible++
+		nodeCPU, nodeMem := allocatable(stub.NodeResources, stub.ReservedResources)
+		if nodeCPU >= int64(cpu) && nodeMem >= int64(mem) {
+			return nil
+		}
+		maxCPU, maxMem = max64(maxCPU, nodeCPU), max64(maxMem, nodeMem)
+	}
+	if eligible == 0 {
+		return fmt.Errorf("scheduler: no eligible node in the %s datacenter", datacenter)
+	}
+	return fmt.Errorf("scheduler: none of the %d eligible nodes can fit a VM with %d MHz of CPU and %d MB of memory, "+
+		"the largest node has %d MHz of CPU and %d MB of memory", eligible, cpu, mem, maxCPU, maxMem)
+}
+
+// isEligible returns true if the node accepts new allocations.
+func isEligible(status, eligibility string, drain bool) bool {
+	return status == api.NodeStatusReady && eligibility == api.NodeSchedulingEligible && !drain
+}
+
+// allocatable returns the CPU and the memory of the node available to the allocations, the resources
+// reserved for the node itself are never available to them.
+func allocatable(resources *api.NodeResources, reserved *api.NodeReservedResources) (cpu, mem int64) {
+	if resources != nil {
+		cpu, mem = resources.Cpu.CpuShares, resources.Memory.MemoryMB
+	}
+	if reserved != nil {
+		cpu -= int64(reserved.Cpu.CpuShares)
+		mem -= int64(reserved.Memory.MemoryMB)
+	}
+	return cpu, mem
+}
+
+func isRunnerJob(id string) bool {
+	for _, prefix := range runnerJobPrefixes {
+		if strings.HasPrefix(id, prefix) {
+			return true
+		}
+	}
+	return false
+}
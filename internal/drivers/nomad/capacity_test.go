@@ -0,0 +1,97 @@
+package nomad
+
+import (
+	"testing"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+func TestNodeCapacity(t *testing.T) {
+	node := &api.Node{
+		ID:                    "node-1",
+		Name:                  "metal-1",
+		Status:                api.NodeStatusReady,
+		SchedulingEligibility: api.NodeSchedulingEligible,
+		NodeResources: &api.NodeResources{
+			Cpu:    api.NodeCpuResources{CpuShares: 10000},
+			Memory: api.NodeMemoryResources{MemoryMB: 8192},
+		},
+		ReservedResources: &api.NodeReservedResources{
+			Cpu:    api.NodeReservedCpuResources{CpuShares: 1000},
+			Memory: api.NodeReservedMemoryResources{MemoryMB: 1024},
+		},
+	}
+	alloc := func(job, status string, cpu, mem int64) *api.Allocation {
+		return &api.Allocation{
+			JobID:         job,
+			ClientStatus:  status,
+			DesiredStatus: api.AllocDesiredStatusRun,
+			AllocatedResources: &api.AllocatedResources{
+				Tasks: map[string]*api.AllocatedTaskResources{
+					"task": {
+						Cpu:    api.AllocatedCpuResources{CpuShares: cpu},
+						Memory: api.AllocatedMemoryResources{MemoryMB: mem},
+					},
+				},
+			},
+		}
+	}
+	allocs := []*api.Allocation{
+		alloc(resourceJobID("vm1"), api.AllocClientStatusRunning, 4000, 2048),
+		alloc(destroyJobID("vm2"), api.AllocClientStatusPending, 40, 20),
+		alloc("other", api.AllocClientStatusRunning, 1000, 1024),
+		alloc(resourceJobID("vm3"), api.AllocClientStatusComplete, 4000, 2048),
+	}
+
+	got := nodeCapacity(node, allocs)
+	if !got.Eligible {
+		t.Error("expected the ready node to be eligible")
+	}
+	if got.CPUTotalMhz != 9000 || got.CPUReservedMhz != 5040 || got.CPURunnerMhz != 4040 || got.CPUFreeMhz != 3960 {
+		t.Errorf("unexpected cpu capacity %+v", got)
+	}
+	if got.MemoryTotalMB != 7168 || got.MemoryReservedMB != 3092 || got.MemoryRunnerMB != 2068 || got.MemoryFreeMB != 4076 {
+		t.Errorf("unexpected memory capacity %+v", got)
+	}
+
+	node.Drain = true
+	if nodeCapacity(node, nil).Eligible {
+		t.Error("expected the draining node not to be eligible")
+	}
+}
+
+func TestFitsNode(t *testing.T) {
+	node := func(dc, eligibility string, cpu, mem int64) *api.NodeListStub {
+		return &api.NodeListStub{
+			Datacenter:            dc,
+			Status:                api.NodeStatusReady,
+			SchedulingEligibility: eligibility,
+			NodeResources: &api.NodeResources{
+				Cpu:    api.NodeCpuResources{CpuShares: cpu},
+				Memory: api.NodeMemoryResources{MemoryMB: mem},
+			},
+		}
+	}
+	small := node(datacenter, api.NodeSchedulingEligible, 20000, 32768)
+	large := node(datacenter, api.NodeSchedulingEligible, 40000, 131072)
+	ineligible := node(datacenter, api.NodeSchedulingIneligible, 40000, 131072)
+	otherDC := node("dc2", api.NodeSchedulingEligible, 40000, 131072)
+
+	tests := []struct {
+		name    string
+		stubs   []*api.NodeListStub
+		wantErr bool
+	}{
+		{name: "fits the large node", stubs: []*api.NodeListStub{small, large}},
+		{name: "too large for all the nodes", stubs: []*api.NodeListStub{small}, wantErr: true},
+		{name: "fits an ineligible node only", stubs: []*api.NodeListStub{small, ineligible}, wantErr: true},
+		{name: "fits a node of another datacenter only", stubs: []*api.NodeListStub{small, otherDC}, wantErr: true},
+		{name: "no nodes", wantErr: true},
+	}
+	for _, test := range tests {
+		// a VM with 64GB of memory
+		if err := fitsNode(test.stubs, 10000, 65536); (err != nil) != test.wantErr {
+			t.Errorf("%s: want error %t, got %v", test.name, test.wantErr, err)
+		}
+	}
+}
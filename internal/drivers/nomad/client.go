@@ -1,17 +1,176 @@
 package nomad
 
-import "github.com/hashicorp/nomad/api"
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
-func NewClient(address string, insecure bool, caCertPath, clientCertPath, clientKeyPath string) (*api.Client, error) {
+	"github.com/drone-runners/drone-runner-aws/internal/fips"
+	"github.com/hashicorp/nomad/api"
+	"github.com/sirupsen/logrus"
+)
+
+// ClientConfig configures the client of the Nomad servers.
+type ClientConfig struct {
+	// Addresses are the servers, the next one is used when a server is unreachable.
+	Addresses []string
+	Insecure  bool
+	// CACertPath is the CA the certificates of the servers are verified against.
+	CACertPath string
+	// ClientCertPath and ClientKeyPath are the certificate of the runner, they are read again when
+	// the files change so a renewed certificate is used without a restart.
+	ClientCertPath string
+	ClientKeyPath  string
+	// TLSServerName is the name the certificates of the servers are verified against and sent as
+	// SNI, e.g. server.global.nomad, the host of the address if empty.
+	TLSServerName string
+}
+
+func NewClient(cfg *ClientConfig) (*api.Client, error) {
+	servers := make([]*url.URL, 0, len(cfg.Addresses))
+	for _, address := range cfg.Addresses {
+		u, err := url.Parse(address)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid nomad address %q", address)
+		}
+		servers = append(servers, u)
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("the address of the nomad server is not set")
+	}
+
+	// the client of the api package is replaced to apply the TLS policy of the runner, like the
+	// api package it sticks to HTTP/1 for the websockets of the allocations.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = fips.Apply(transport.TLSClientConfig)
+	transport.ForceAttemptHTTP2 = false
+	httpClient := &http.Client{Transport: transport}
 	tlsConfig := &api.TLSConfig{
-		CACert:     caCertPath,
-		ClientKey:  clientKeyPath,
-		Insecure:   insecure,
-		ClientCert: clientCertPath,
+		CACert:        cfg.CACertPath,
+		Insecure:      cfg.Insecure,
+		TLSServerName: cfg.TLSServerName,
+	}
+	if err := api.ConfigureTLS(httpClient, tlsConfig); err != nil {
+		return nil, err
+	}
+	if cfg.ClientCertPath != "" || cfg.ClientKeyPath != "" {
+		reloader := &keyPairReloader{certPath: cfg.ClientCertPath, keyPath: cfg.ClientKeyPath}
+		if _, err := reloader.GetClientCertificate(nil); err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig.GetClientCertificate = reloader.GetClientCertificate
 	}
-	config := &api.Config{
-		Address:   address,
-		TLSConfig: tlsConfig,
+	if len(servers) > 1 {
+		httpClient.Transport = &failoverTransport{base: transport, servers: servers}
+	}
+
+	return api.NewClient(&api.Config{
+		Address:    servers[0].String(),
+		TLSConfig:  tlsConfig,
+		HttpClient: httpClient,
+	})
+}
+
+// failoverTransport sends the requests to the current server, and to the next ones if it is
+// unreachable. The first server reached becomes the current one.
+type failoverTransport struct {
+	base    http.RoundTripper
+	servers []*url.URL
+	current atomic.Int32
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := int(t.current.Load())
+	var err error
+	for i := range t.servers {
+		idx := (start + i) % len(t.servers)
+		r := req.Clone(req.Context())
+		r.URL.Scheme, r.URL.Host, r.Host = t.servers[idx].Scheme, t.servers[idx].Host, ""
+		if i > 0 && req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return nil, err // the body was consumed by the previous attempt
+			}
+			if r.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		var resp *http.Response
+		resp, err = t.base.RoundTrip(r)
+		if err != nil && unreachable(err) {
+			continue
+		}
+		if idx != start && t.current.CompareAndSwap(int32(start), int32(idx)) {
+			logrus.WithField("server", t.servers[idx].Host).
+				WithField("previous", t.servers[start].Host).
+				Warnln("nomad: the server is unreachable, failed over to the next one")
+		}
+		return resp, err
+	}
+	return nil, err
+}
+
+// unreachable returns whether the request failed because no connection to the server could be
+// made, so it was not processed and can be sent to another server.
+func unreachable(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// keyPairReloader returns the client certificate of the TLS handshakes, read again from its
+// files when they change.
+type keyPairReloader struct {
+	certPath string
+	keyPath  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // the latest modification time of the files
+}
+
+func (r *keyPairReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime, err := latestModTime(r.certPath, r.keyPath)
+	if err == nil && r.cert != nil && !modTime.After(r.modTime) {
+		return r.cert, nil
+	}
+	if err == nil {
+		var cert tls.Certificate
+		if cert, err = tls.LoadX509KeyPair(r.certPath, r.keyPath); err == nil {
+			if r.cert != nil {
+				logrus.WithField("path", r.certPath).Infoln("nomad: reloaded the client certificate")
+			}
+			r.cert, r.modTime = &cert, modTime
+			return r.cert, nil
+		}
+	}
+	if r.cert == nil {
+		return nil, fmt.Errorf("nomad: could not load the client certificate: %w", err)
+	}
+	// e.g. the certificate is renewed and the key is not written yet, the previous one is used.
+	logrus.WithError(err).Warnln("nomad: could not reload the client certificate, using the previous one")
+	return r.cert, nil
+}
+
+func latestModTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
 	}
-	return api.NewClient(config)
+	return latest, nil
 }
@@ -0,0 +1,154 @@
+package nomad
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFailover(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`"127.0.0.1:4647"`))
+	}))
+	defer up.Close()
+
+	client, err := NewClient(&ClientConfig{Addresses: []string{down.URL, up.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		leader, err := client.Status().Leader()
+		if err != nil {
+			t.Fatalf("request %d: %s", i, err)
+		}
+		if leader != "127.0.0.1:4647" {
+			t.Errorf("request %d: got leader %q", i, leader)
+		}
+	}
+}
+
+func TestFailoverSticky(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+
+	transport := &failoverTransport{base: http.DefaultTransport, servers: []*url.URL{mustParse(t, down.URL), mustParse(t, up.URL)}}
+	req, err := http.NewRequest(http.MethodPost, down.URL+"/v1/jobs", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := transport.current.Load(); got != 1 {
+		t.Errorf("got current server %d, want the one reachable", got)
+	}
+}
+
+func mustParse(t *testing.T, s string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestKeyPairReloader(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeKeyPair(t, certPath, keyPath, "first")
+
+	r := &keyPairReloader{certPath: certPath, keyPath: keyPath}
+	cert, err := r.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := commonName(t, cert.Certificate[0]); got != "first" {
+		t.Errorf("got %q, want the first certificate", got)
+	}
+
+	writeKeyPair(t, certPath, keyPath, "second")
+	later := time.Now().Add(time.Minute)
+	for _, path := range []string{certPath, keyPath} {
+		if err = os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cert, err = r.GetClientCertificate(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := commonName(t, cert.Certificate[0]); got != "second" {
+		t.Errorf("got %q, want the renewed certificate", got)
+	}
+
+	// a key being written keeps the previous certificate
+	if err = os.WriteFile(keyPath, []byte("partial"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	if err = os.Chtimes(keyPath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = r.GetClientCertificate(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := commonName(t, cert.Certificate[0]); got != "second" {
+		t.Errorf("got %q, want the previous certificate", got)
+	}
+}
+
+func writeKeyPair(t *testing.T, certPath, keyPath, name string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func commonName(t *testing.T, der []byte) string {
+	t.Helper()
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Subject.CommonName
+}
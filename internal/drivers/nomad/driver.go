@@ -2,17 +2,18 @@ package nomad
 
 import (
 	"context"
+	_ "embed"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"net"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/drone-runners/drone-runner-aws/internal/cloudinit"
 	"github.com/drone-runners/drone-runner-aws/internal/drivers"
-	"github.com/drone-runners/drone-runner-aws/internal/lehelper"
 	"github.com/drone-runners/drone-runner-aws/internal/oshelp"
 	"github.com/drone-runners/drone-runner-aws/types"
 	"github.com/drone/runner-go/logger"
@@ -20,30 +21,66 @@ import (
 	"golang.org/x/exp/slices"
 )
 
+// vmScript manages the VMs on the nodes, see drone-nomad-vm.sh.
+//
+//go:embed drone-nomad-vm.sh
+var vmScript string
+
+// datacenter is the nomad datacenter the jobs are scheduled in.
+const datacenter = "dc1"
+
+// vmScriptPath is where drone-nomad-vm.sh is installed on the nodes running the jobs as a non-root user.
+const vmScriptPath = "/usr/local/sbin/drone-nomad-vm"
+
+// vmNamePrefix starts the names of the VMs, drone-nomad-vm.sh only manages the VMs named so.
+const vmNamePrefix = "drone-"
+
+// runnerTagLen is the length of the tag of the runner in the names of the VMs, see vmPrefix.
+const runnerTagLen = 8
+
 var (
-	ignitePath              = "/usr/local/bin/ignite"
+	vmNameRegexp = regexp.MustCompile(`^drone-[a-z0-9]([a-z0-9-]{0,55}[a-z0-9])?$`)
+	// nameConstraints are the rules of the VM names without the prefix, they are part of the job IDs,
+	// see vmNameRegexp and vmPrefix.
+	nameConstraints         = drivers.NameConstraints{MaxLen: 63 - len(vmPrefix("")), Lower: true}
 	clientDisconnectTimeout = 4 * time.Minute
 	destroyRetryAttempts    = 3
-	resourceJobTimeout      = 3 * time.Minute
-	initTimeout             = 5 * time.Minute
-	destroyTimeout          = 10 * time.Minute
 	minNomadCPUMhz          = 40
 	minNomadMemoryMb        = 20
 	machineFrequencyMhz     = 5100 // TODO: Find a way to extract this from the node directly
+	vmMemoryOverheadMb      = 256  // memory used by the VM process on top of the VM memory
+)
+
+// The default timeouts of the jobs, see WithTimeouts.
+const (
+	defaultResourceJobTimeout = 3 * time.Minute
+	defaultInitTimeout        = 5 * time.Minute
+	defaultDestroyTimeout     = 10 * time.Minute
 )
 
 type config struct {
 	address        string
+	addresses      []string // the servers failed over to, the address is the first one if set
+	tlsServerName  string
 	vmImage        string
 	vmMemoryGB     string
 	vmCpus         string
+	memoryGB       int // vmMemoryGB parsed when the driver is created
+	cpus           int // vmCpus parsed when the driver is created
 	vmDiskSize     string
 	caCertPath     string
 	clientCertPath string
 	clientKeyPath  string
 	insecure       bool
 	noop           bool
+	enforceLimits  bool
+	user           string
 	client         *api.Client
+	// resourceJobTimeout is the time to find a node with the resources of the VM, initTimeout the time
+	// to start the VM and destroyTimeout the time to remove it.
+	resourceJobTimeout time.Duration
+	initTimeout        time.Duration
+	destroyTimeout     time.Duration
 }
 
 // SetPlatformDefaults comes up with default values of the platform
@@ -67,12 +104,34 @@ func SetPlatformDefaults(platform *types.Platform) (*types.Platform, error) {
 }
 
 func New(opts ...Option) (drivers.Driver, error) {
-	p := new(config)
+	p := &config{
+		resourceJobTimeout: defaultResourceJobTimeout,
+		initTimeout:        defaultInitTimeout,
+		destroyTimeout:     defaultDestroyTimeout,
+	}
 	for _, opt := range opts {
 		opt(p)
 	}
+	var err error
+	if p.cpus, err = strconv.Atoi(p.vmCpus); err != nil || p.cpus <= 0 {
+		return nil, fmt.Errorf("invalid VM cpus %q, has to be a positive integer", p.vmCpus)
+	}
+	if p.memoryGB, err = strconv.Atoi(p.vmMemoryGB); err != nil || p.memoryGB <= 0 {
+		return nil, fmt.Errorf("invalid VM memory %q, has to be a positive integer of GBs", p.vmMemoryGB)
+	}
 	if p.client == nil {
-		client, err := NewClient(p.address, p.insecure, p.caCertPath, p.clientCertPath, p.clientKeyPath)
+		addresses := p.addresses
+		if p.address != "" {
+			addresses = append([]string{p.address}, addresses...)
+		}
+		client, err := NewClient(&ClientConfig{
+			Addresses:      addresses,
+			Insecure:       p.insecure,
+			CACertPath:     p.caCertPath,
+			ClientCertPath: p.clientCertPath,
+			ClientKeyPath:  p.clientKeyPath,
+			TLSServerName:  p.tlsServerName,
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -85,6 +144,10 @@ func (p *config) DriverName() string {
 	return "nomad"
 }
 
+func (p *config) InstanceType() string {
+	return p.vmImage
+}
+
 func (p *config) RootDir() string {
 	return ""
 }
@@ -101,22 +164,34 @@ func (p *config) Ping(ctx context.Context) error {
 	return errors.New("could not create a client to the nomad server")
 }
 
+// CheckHealth queries the leader of the servers, which fails if the servers are unreachable or
+// have lost their quorum.
+func (p *config) CheckHealth(ctx context.Context) error {
+	if err := p.Ping(ctx); err != nil {
+		return err
+	}
+	var leader string
+	if _, err := p.client.Raw().Query("/v1/status/leader", &leader, (&api.QueryOptions{}).WithContext(ctx)); err != nil {
+		return fmt.Errorf("could not query the leader of the nomad servers: %w", err)
+	}
+	if leader == "" {
+		return errors.New("the nomad servers have no leader")
+	}
+	return nil
+}
+
 // Create creates a VM using port forwarding inside a bare metal machine assigned by nomad.
 // This function is idempotent - any errors in between will cleanup the created VMs.
 func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (*types.Instance, error) {
 	startupScript := generateStartupScript(opts)
 
-	vm := strings.ToLower(random(20)) //nolint:gomnd
-
-	cpus, err := strconv.Atoi(p.vmCpus)
-	if err != nil {
-		return nil, errors.New("could not convert VM cpus to integer")
-	}
-	memGB, err := strconv.Atoi(p.vmMemoryGB)
-	if err != nil {
-		return nil, errors.New("could  not convert VM memory to integer")
+	vm := vmPrefix(opts.RunnerName) + strings.ToLower(random(20)) //nolint:gomnd
+	if opts.Name != "" {
+		vm = vmPrefix(opts.RunnerName) + nameConstraints.Sanitize(opts.Name)
 	}
 
+	cpus, memGB := p.cpus, p.memoryGB
+
 	// Create a resource job which occupies resources until the VM is alive to avoid
 	// oversubscribing the node
 	var resourceJob *api.Job
@@ -129,14 +204,27 @@ func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (*t
 
 	logr := logger.FromContext(ctx).WithField("vm", vm).WithField("resource_job_id", resourceJobID)
 
+	if !p.noop {
+		// fail right away if the VM can never be scheduled instead of waiting for the resource job to time out.
+		cpu, mem := resourceJobResources(cpus, memGB)
+		if err := p.checkCapacity(ctx, cpu, mem); err != nil {
+			return nil, err
+		}
+	}
+
 	logr.Infoln("scheduler: finding a node which has available resources ... ")
 
-	_, _, err = p.client.Jobs().Register(resourceJob, nil)
+	_, _, err := p.client.Jobs().Register(resourceJob, nil)
 	if err != nil {
 		return nil, fmt.Errorf("scheduler: could not register job, err: %w", err)
 	}
-	// If resources don't become available in `resourceJobTimeout`, we fail the step
-	_, err = p.pollForJob(ctx, resourceJobID, logr, resourceJobTimeout, true, []JobStatus{Running, Dead})
+	// If resources don't become available in the resource job timeout, we fail the step
+	_, err = p.pollForJob(ctx, resourceJobID, logr, p.resourceJobTimeout, true, []JobStatus{Running, Dead})
+	var placementErr *PlacementError
+	if errors.As(err, &placementErr) {
+		cpu, mem := resourceJobResources(cpus, memGB)
+		return nil, fmt.Errorf("scheduler: no node can place the VM (%d MHz of cpu, %d MB of memory): %w", cpu, mem, placementErr)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("scheduler: could not find a node with available resources, err: %w", err)
 	}
@@ -155,27 +243,28 @@ func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (*t
 	if p.noop {
 		initJob, initJobID, initTaskGroup = p.initJobNoop(vm, startupScript, hostPort, id)
 	} else {
-		initJob, initJobID, initTaskGroup = p.initJob(vm, startupScript, hostPort, id)
+		initJob, initJobID, initTaskGroup = p.initJob(vm, startupScript, hostPort, opts.LiteEnginePort, id)
 	}
 
 	logr = logr.WithField("init_job_id", initJobID).WithField("node_ip", ip).WithField("node_port", hostPort)
 
 	instance := &types.Instance{
-		ID:       vm,
-		NodeID:   id,
-		Name:     vm,
-		Platform: opts.Platform,
-		State:    types.StateCreated,
-		CACert:   opts.CACert,
-		CAKey:    opts.CAKey,
-		TLSCert:  opts.TLSCert,
-		TLSKey:   opts.TLSKey,
-		Provider: types.Nomad,
-		Pool:     opts.PoolName,
-		Started:  time.Now().Unix(),
-		Updated:  time.Now().Unix(),
-		Port:     int64(hostPort),
-		Address:  ip,
+		ID:         vm,
+		NodeID:     id,
+		ProviderID: resourceJobID, // the job holding the resources of the VM as long as the VM is alive
+		Name:       vm,
+		Platform:   opts.Platform,
+		State:      types.StateCreated,
+		CACert:     opts.CACert,
+		CAKey:      opts.CAKey,
+		TLSCert:    opts.TLSCert,
+		TLSKey:     opts.TLSKey,
+		Provider:   types.Nomad,
+		Pool:       opts.PoolName,
+		Started:    time.Now().Unix(),
+		Updated:    time.Now().Unix(),
+		Port:       int64(hostPort),
+		Address:    ip,
 	}
 
 	logr.Debugln("scheduler: submitting VM creation job to nomad")
@@ -185,7 +274,10 @@ func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (*t
 		return nil, fmt.Errorf("scheduler: could not register job, err: %w", err)
 	}
 	logr.Debugln("scheduler: successfully submitted job to nomad, started polling for job status")
-	_, err = p.pollForJob(ctx, initJobID, logr, initTimeout, true, []JobStatus{Dead})
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	go p.watchInitJob(watchCtx, logr, initJobID)
+	_, err = p.pollForJob(ctx, initJobID, logr, p.initTimeout, true, []JobStatus{Dead})
+	stopWatch()
 	if err != nil {
 		// Destroy the VM if it's in a partially created state
 		defer p.Destroy(context.Background(), []*types.Instance{instance}) //nolint:errcheck
@@ -228,12 +320,9 @@ func (p *config) resourceJob(cpus, memGB int, vm string) (job *api.Job, id strin
 	id = resourceJobID(vm)
 	portLabel := vm
 
-	sleepTime := resourceJobTimeout + initTimeout + 2*time.Minute // add 2 minutes for a buffer
+	sleepTime := p.resourceJobTimeout + p.initTimeout + 2*time.Minute // add 2 minutes for a buffer
 
-	// TODO: Check if this logic can be made better, although we are bounded by some limitations of Nomad scheduling
-	// We want to keep some buffer for other tasks to come in (which require minimum cpu and memory)
-	cpu := machineFrequencyMhz*cpus - 109
-	mem := convertGigsToMegs(memGB) - 53
+	cpu, mem := resourceJobResources(cpus, memGB)
 
 	// This job stays alive to keep resources on nomad busy until the VM is destroyed
 	// It sleeps until the max VM creation timeout, after which it periodically checks whether the VM is alive or not
@@ -241,7 +330,7 @@ func (p *config) resourceJob(cpus, memGB int, vm string) (job *api.Job, id strin
 		ID:          &id,
 		Name:        stringToPtr(id),
 		Type:        stringToPtr("batch"),
-		Datacenters: []string{"dc1"},
+		Datacenters: []string{datacenter},
 		// TODO (Vistaar): This can be updated once we have more data points
 		Reschedule: &api.ReschedulePolicy{
 			Attempts:  intToPtr(0),
@@ -257,19 +346,13 @@ func (p *config) resourceJob(cpus, memGB int, vm string) (job *api.Job, id strin
 				Name:  stringToPtr(fmt.Sprintf("init_task_group_resource_%s", vm)),
 				Count: intToPtr(1),
 				Tasks: []*api.Task{
-					{
-
+					p.shellTask(&api.Task{
 						Name: "sleep_and_ping",
 						Resources: &api.Resources{
 							MemoryMB: intToPtr(mem), // to keep resources available for the destroy jobs
 							CPU:      intToPtr(cpu), // keep some buffer for destroy and init tasks
 						},
-						Driver: "raw_exec",
-						Config: map[string]interface{}{
-							"command": "/usr/bin/su",
-							"args":    []string{"-c", generateHealthCheckScript(sleepTime, fmt.Sprintf("$NOMAD_PORT_%s", portLabel))},
-						},
-					},
+					}, generateHealthCheckScript(sleepTime, fmt.Sprintf("$NOMAD_PORT_%s", portLabel))),
 				},
 			},
 		},
@@ -277,6 +360,13 @@ func (p *config) resourceJob(cpus, memGB int, vm string) (job *api.Job, id strin
 	return job, id
 }
 
+// resourceJobResources returns the CPU in MHz and the memory in MB reserved by the resource job of a VM.
+func resourceJobResources(cpus, memGB int) (cpu, mem int) {
+	// TODO: Check if this logic can be made better, although we are bounded by some limitations of Nomad scheduling
+	// We want to keep some buffer for other tasks to come in (which require minimum cpu and memory)
+	return machineFrequencyMhz*cpus - 109, convertGigsToMegs(memGB) - 53
+}
+
 // fetchMachine returns details of the machine where the job has been allocated
 func (p *config) fetchMachine(logr logger.Logger, id string) (ip, nodeID string, port int, err error) {
 	// Get the allocation corresponding to this job submission. If this call fails, there is not much we can do in terms
@@ -335,30 +425,22 @@ func (p *config) fetchMachine(logr logger.Logger, id string) (ip, nodeID string,
 // initJob creates a job which is targeted to a specific node. The job does the following:
 //  1. Starts a VM with the provided config
 //  2. Runs a startup script inside the VM
-func (p *config) initJob(vm, startupScript string, hostPort int, nodeID string) (job *api.Job, id, group string) {
+func (p *config) initJob(vm, startupScript string, hostPort, vmPort int, nodeID string) (job *api.Job, id, group string) {
 	id = initJobID(vm)
 	group = fmt.Sprintf("init_task_group_%s", vm)
 	encodedStartupScript := base64.StdEncoding.EncodeToString([]byte(startupScript))
 
-	hostPath := fmt.Sprintf("/usr/local/bin/%s.sh", vm)
-	vmPath := fmt.Sprintf("/usr/bin/%s.sh", vm)
-
-	runCmd := fmt.Sprintf("%s run %s --name %s --cpus %s --memory %sGB --size %s --ssh --runtime=docker --ports %d:%s --copy-files %s:%s",
-		ignitePath,
-		p.vmImage,
-		vm,
-		p.vmCpus,
-		p.vmMemoryGB,
-		p.vmDiskSize,
-		hostPort,
-		strconv.Itoa(lehelper.LiteEnginePort),
-		hostPath,
-		vmPath)
+	args := []string{vm, p.vmImage, p.vmCpus, p.vmMemoryGB, p.vmDiskSize, strconv.Itoa(hostPort), strconv.Itoa(vmPort)}
+	if p.enforceLimits {
+		// hard cgroup CPU and memory limits on the container running the VM process
+		args = append(args, strconv.Itoa(convertGigsToMegs(p.memoryGB)+vmMemoryOverheadMb))
+	}
+	createCmd := fmt.Sprintf("echo %s | %s", encodedStartupScript, p.vmCmd("create", args...))
 	job = &api.Job{
 		ID:          &id,
 		Name:        stringToPtr(vm),
 		Type:        stringToPtr("batch"),
-		Datacenters: []string{"dc1"},
+		Datacenters: []string{datacenter},
 		Constraints: []*api.Constraint{
 			{
 				LTarget: "${node.unique.id}",
@@ -379,56 +461,10 @@ func (p *config) initJob(vm, startupScript string, hostPort int, nodeID string)
 				Name:  stringToPtr(group),
 				Count: intToPtr(1),
 				Tasks: []*api.Task{
-					{
-						Name:      "create_startup_script_on_host",
-						Driver:    "raw_exec",
-						Resources: minNomadResources(),
-						Config: map[string]interface{}{
-							"command": "/usr/bin/su",
-							"args":    []string{"-c", fmt.Sprintf("echo %s >> %s", encodedStartupScript, hostPath)},
-						},
-						Lifecycle: &api.TaskLifecycle{
-							Sidecar: false,
-							Hook:    "prestart",
-						},
-					},
-
-					{
-						Name:      "ignite_run",
-						Driver:    "raw_exec",
+					p.vmTask(&api.Task{
+						Name:      initTask,
 						Resources: minNomadResources(),
-						Config: map[string]interface{}{
-							"command": "/usr/bin/su",
-							"args":    []string{"-c", runCmd},
-						},
-					},
-
-					{
-						Name:      "ignite_exec",
-						Driver:    "raw_exec",
-						Resources: minNomadResources(),
-						Config: map[string]interface{}{
-							"command": "/usr/bin/su",
-							"args":    []string{"-c", fmt.Sprintf("%s exec %s 'cat %s | base64 --decode | bash'", ignitePath, vm, vmPath)},
-						},
-						Lifecycle: &api.TaskLifecycle{
-							Sidecar: false,
-							Hook:    "poststop",
-						},
-					},
-					{
-						Name:      "cleanup_startup_script_from_host",
-						Driver:    "raw_exec",
-						Resources: minNomadResources(),
-						Config: map[string]interface{}{
-							"command": "/usr/bin/su",
-							"args":    []string{"-c", fmt.Sprintf("rm %s", hostPath)},
-						},
-						Lifecycle: &api.TaskLifecycle{
-							Sidecar: false,
-							Hook:    "poststop",
-						},
-					},
+					}, createCmd),
 				},
 			},
 		},
@@ -436,6 +472,52 @@ func (p *config) initJob(vm, startupScript string, hostPort int, nodeID string)
 	return job, id, group
 }
 
+// shellTask sets up the task to run the shell command. By default the command is run as root with the raw_exec
+// driver. If a user is configured the isolated exec driver is used instead, running the command as the user.
+func (p *config) shellTask(task *api.Task, cmd string) *api.Task {
+	if p.user == "" {
+		task.Driver = "raw_exec"
+		task.Config = map[string]interface{}{
+			"command": "/usr/bin/su",
+			"args":    []string{"-c", cmd},
+		}
+		return task
+	}
+	task.Driver = "exec"
+	task.User = p.user
+	task.Config = map[string]interface{}{
+		"command": "/bin/bash",
+		"args":    []string{"-c", cmd},
+	}
+	return task
+}
+
+// vmTask sets up the task to run a drone-nomad-vm command. When the tasks are run as root the script
+// is rendered into the task directory, otherwise it is run with sudo from the node.
+func (p *config) vmTask(task *api.Task, cmd string) *api.Task {
+	task = p.shellTask(task, cmd)
+	if p.user == "" {
+		task.Templates = append(task.Templates, &api.Template{
+			EmbeddedTmpl: stringToPtr(vmScript),
+			DestPath:     stringToPtr("local/drone-nomad-vm"),
+			Perms:        stringToPtr("0700"),
+			// the script is not a template, it must be rendered as is
+			LeftDelim:  stringToPtr("[%"),
+			RightDelim: stringToPtr("%]"),
+		})
+	}
+	return task
+}
+
+// vmCmd returns the drone-nomad-vm command with the given arguments. The arguments are validated by
+// the script, a non-root user only needs a sudo rule for the script installed on the node.
+func (p *config) vmCmd(cmd string, args ...string) string {
+	if p.user == "" {
+		return fmt.Sprintf("/bin/bash ${NOMAD_TASK_DIR}/drone-nomad-vm %s %s", cmd, strings.Join(args, " "))
+	}
+	return fmt.Sprintf("sudo -n %s %s %s", vmScriptPath, cmd, strings.Join(args, " "))
+}
+
 // destroyJob returns a job targeted to the given node which stops and removes the VM
 func (p *config) destroyJob(vm, nodeID string) (job *api.Job, id string) {
 	id = destroyJobID(vm)
@@ -449,7 +531,7 @@ func (p *config) destroyJob(vm, nodeID string) (job *api.Job, id string) {
 		Name: stringToPtr(random(20)), //nolint:gomnd
 
 		Type:        stringToPtr("batch"),
-		Datacenters: []string{"dc1"},
+		Datacenters: []string{datacenter},
 		Constraints: []*api.Constraint{
 			constraint,
 		},
@@ -462,15 +544,10 @@ func (p *config) destroyJob(vm, nodeID string) (job *api.Job, id string) {
 				Name:  stringToPtr(fmt.Sprintf("delete_task_group_%s", vm)),
 				Count: intToPtr(1),
 				Tasks: []*api.Task{
-					{
+					p.vmTask(&api.Task{
 						Name:      "ignite_stop_and_rm",
 						Resources: minNomadResources(),
-						Driver:    "raw_exec",
-						Config: map[string]interface{}{
-							"command": "/usr/bin/su",
-							"args":    []string{"-c", fmt.Sprintf("%s stop %s && %s rm %s", ignitePath, vm, ignitePath, vm)},
-						},
-					},
+					}, p.vmCmd("destroy", vm)),
 				},
 			},
 		}}
@@ -508,7 +585,7 @@ func (p *config) Destroy(ctx context.Context, instances []*types.Instance) (err
 			return err
 		}
 		logr.Debugln("scheduler: started polling for destroy job")
-		_, err = p.pollForJob(ctx, jobID, logr, destroyTimeout, false, []JobStatus{Dead})
+		_, err = p.pollForJob(ctx, jobID, logr, p.destroyTimeout, false, []JobStatus{Dead})
 		if err != nil {
 			logr.WithError(err).Errorln("scheduler: could not complete destroy job")
 			return err
@@ -518,20 +595,20 @@ func (p *config) Destroy(ctx context.Context, instances []*types.Instance) (err
 }
 
 func (p *config) Logs(ctx context.Context, instanceID string) (string, error) {
-	return "", nil
+	return "", drivers.ErrNotSupported
 }
 
 func (p *config) SetTags(ctx context.Context, instance *types.Instance,
 	tags map[string]string) error {
-	return nil
+	return drivers.ErrNotSupported
 }
 
 func (p *config) Hibernate(ctx context.Context, instanceID, poolName string) error {
-	return nil
+	return drivers.ErrNotSupported
 }
 
 func (p *config) Start(ctx context.Context, instanceID, poolName string) (string, error) {
-	return "", nil
+	return "", drivers.ErrNotSupported
 }
 
 // pollForJob polls on the status of the job and returns back once it is in a terminal state.
@@ -542,9 +619,9 @@ func (p *config) Start(ctx context.Context, instanceID, poolName string) (string
 func (p *config) pollForJob(ctx context.Context, id string, logr logger.Logger, timeout time.Duration, remove bool, terminalStates []JobStatus) (*api.Job, error) { //nolint:unparam
 	terminalStates = append(terminalStates, Dead) // we always return from poll if the job is dead
 	maxPollTime := time.After(timeout)
-	terminal := false
+	b := pollBackoff()
+	var delay time.Duration
 	var job *api.Job
-	var err error
 	var waitIndex uint64
 L:
 	for {
@@ -553,44 +630,52 @@ L:
 			break L
 		case <-maxPollTime:
 			break L
-		default:
-			q := &api.QueryOptions{WaitTime: 15 * time.Second, WaitIndex: waitIndex}
-			var qm *api.QueryMeta
+		case <-time.After(delay):
+			// the query is bound to the context so a cancelled setup does not wait for the blocking query
+			q := (&api.QueryOptions{WaitTime: 15 * time.Second, WaitIndex: waitIndex}).WithContext(ctx)
 			// Get the job status
-			job, qm, err = p.client.Jobs().Info(id, q)
-			if err != nil {
-				logr.WithError(err).WithField("job_id", id).Error("could not retrieve job information")
-				continue
+			info, qm, err := p.client.Jobs().Info(id, q)
+			if isNotFound(err) {
+				// the job was deregistered, e.g. by the janitor or by an operator
+				logr.WithField("job_id", id).Errorln("scheduler: job not found")
+				return nil, fmt.Errorf("scheduler: job %s: %w", id, errJobNotFound)
 			}
-			if job == nil {
+			if err != nil || info == nil {
+				delay = b.NextBackOff()
+				logr.WithError(err).WithField("job_id", id).WithField("retry_in", delay).Warnln("scheduler: could not retrieve job information")
 				continue
 			}
+			b.Reset()
+			delay = 0
+			job = info
 			waitIndex = qm.LastIndex
 			status := Status(*job.Status)
 
 			if slices.Contains(terminalStates, status) {
 				logr.WithField("job_id", id).WithField("status", status).Traceln("scheduler: job reached a terminal state")
-				terminal = true
-				break L
+				return job, nil
 			}
 		}
 	}
-	if job == nil {
-		logr.WithField("job_id", id).Errorln("could not poll for job")
-		return job, errors.New("could not poll for job")
-	}
-	// If a terminal state was reached, we return back
-	if terminal {
-		return job, nil
-	}
 
+	// the last evaluation tells why the job is stuck, e.g. no node has the resources of the VM. It is
+	// read before the job and its evaluations are purged.
+	placementErr := p.evalFailure(id)
 	// Deregister the job if remove is set as true
 	if remove {
-		go func() {
-			p.deregisterJob(logr, id, true) //nolint:errcheck
-		}()
+		go p.deregisterJob(logr, id, true) //nolint:errcheck
+	}
+	if job == nil {
+		logr.WithField("job_id", id).Errorln("could not poll for job")
+		err := errors.New("could not poll for job")
+		if placementErr != nil {
+			err = fmt.Errorf("%s: %w", err, placementErr)
+		}
+		return job, err
+	}
+	if placementErr != nil {
+		return job, fmt.Errorf("scheduler: job never reached terminal state: %w", placementErr)
 	}
-
 	return job, errors.New("scheduler: job never reached terminal state")
 }
 
@@ -616,6 +701,15 @@ func generateStartupScript(opts *types.InstanceCreateOpts) string {
 		LiteEnginePath:       opts.LiteEnginePath,
 		HarnessTestBinaryURI: opts.HarnessTestBinaryURI,
 		PluginBinaryURI:      opts.PluginBinaryURI,
+		LiteEnginePort:       opts.LiteEnginePort,
+		Tunnel:               opts.Tunnel,
+		Slim:                 opts.Slim,
+		Tuning:               opts.Tuning,
+		Checksums:            opts.Checksums,
+		WindowsContainers:    opts.WindowsContainers,
+		LiteEngineEnv:        opts.LiteEngineEnv,
+		LiteEngineFeatures:   opts.LiteEngineFeatures,
+		LeaseKey:             opts.LeaseKey,
 	}
 	return cloudinit.LinuxBash(params)
 }
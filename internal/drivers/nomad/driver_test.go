@@ -0,0 +1,170 @@
+package nomad
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/hashicorp/nomad/api"
+	"golang.org/x/exp/slices"
+)
+
+// testVM is the name of the VM created with createOpts.
+var testVM = vmPrefix("runner") + "vm-1"
+
+func createOpts() *types.InstanceCreateOpts {
+	return &types.InstanceCreateOpts{
+		Name:       "vm-1",
+		PoolName:   "pool",
+		RunnerName: "runner",
+		Platform:   types.Platform{OS: "linux", Arch: "amd64"},
+	}
+}
+
+// eventually waits for the condition, the jobs of the timed out polls are deregistered in the background.
+func eventually(t *testing.T, cond func() bool) bool {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCreateDestroy(t *testing.T) {
+	for _, noop := range []bool{false, true} {
+		f := newFakeNomad(t, func(job *api.Job) fakeOutcome {
+			if *job.ID == resourceJobID(testVM) {
+				return fakeOutcome{status: runningStr}
+			}
+			return fakeOutcome{}
+		})
+		p := newFakeDriver(t, f, noop)
+
+		instance, err := p.Create(context.Background(), createOpts())
+		if err != nil {
+			t.Fatalf("noop %v: %s", noop, err)
+		}
+		if instance.ID != testVM || instance.NodeID != fakeNodeID || instance.Address != fakeNodeIP ||
+			instance.Port != fakeHostPort || instance.ProviderID != resourceJobID(testVM) {
+			t.Errorf("noop %v: unexpected instance %+v", noop, instance)
+		}
+		init := f.registeredJob(initJobID(testVM))
+		if init == nil || len(init.Constraints) != 1 || init.Constraints[0].RTarget != fakeNodeID {
+			t.Fatalf("noop %v: expected the init job to be placed on the node of the resource job, got %+v", noop, init)
+		}
+		if task := init.TaskGroups[0].Tasks[0]; (task.Name == initTask) == noop {
+			t.Errorf("noop %v: unexpected init task %q", noop, task.Name)
+		}
+
+		if err = p.Destroy(context.Background(), []*types.Instance{instance}); err != nil {
+			t.Fatalf("noop %v: %s", noop, err)
+		}
+		registered, deregistered := f.jobIDs()
+		want := []string{resourceJobID(testVM), initJobID(testVM), destroyJobID(testVM)}
+		if !slices.Equal(registered, want) {
+			t.Errorf("noop %v: expected the jobs %q, got %q", noop, want, registered)
+		}
+		if !slices.Equal(deregistered, []string{resourceJobID(testVM)}) {
+			t.Errorf("noop %v: expected the resource job to be deregistered, got %q", noop, deregistered)
+		}
+	}
+}
+
+func TestCreate_ResourceTimeout(t *testing.T) {
+	f := newFakeNomad(t, func(job *api.Job) fakeOutcome {
+		return fakeOutcome{status: pendingStr, evals: []*api.Evaluation{
+			{ID: "eval-1", Status: evalStatusBlocked, CreateIndex: 2},
+			{ID: "eval-0", Status: "complete", CreateIndex: 1, FailedTGAllocs: map[string]*api.AllocationMetric{
+				"resources": {NodesEvaluated: 1, NodesAvailable: map[string]int{datacenter: 1}, DimensionExhausted: map[string]int{"memory": 1}},
+			}},
+		}}
+	})
+	p := newFakeDriver(t, f, true)
+
+	_, err := p.Create(context.Background(), createOpts())
+	if err == nil || !strings.Contains(err.Error(), "no node can place the VM") || !strings.Contains(err.Error(), "memory exhausted on 1 nodes") {
+		t.Fatalf("expected the placement failure, got %v", err)
+	}
+	if !eventually(t, func() bool {
+		_, deregistered := f.jobIDs()
+		return slices.Contains(deregistered, resourceJobID(testVM))
+	}) {
+		t.Error("expected the stuck resource job to be deregistered")
+	}
+	if registered, _ := f.jobIDs(); slices.Contains(registered, initJobID(testVM)) {
+		t.Error("expected no init job once the resource job timed out")
+	}
+}
+
+func TestCreate_NoAllocation(t *testing.T) {
+	f := newFakeNomad(t, func(job *api.Job) fakeOutcome {
+		return fakeOutcome{status: runningStr, unplaced: true}
+	})
+	p := newFakeDriver(t, f, true)
+
+	_, err := p.Create(context.Background(), createOpts())
+	if err == nil || !strings.Contains(err.Error(), "no allocation found") {
+		t.Fatalf("expected the missing allocation, got %v", err)
+	}
+	if _, deregistered := f.jobIDs(); !slices.Equal(deregistered, []string{resourceJobID(testVM)}) {
+		t.Errorf("expected the resource job to be deregistered, got %q", deregistered)
+	}
+}
+
+func TestCreate_InitFailure(t *testing.T) {
+	f := newFakeNomad(t, func(job *api.Job) fakeOutcome {
+		switch *job.ID {
+		case resourceJobID(testVM):
+			return fakeOutcome{status: runningStr}
+		case initJobID(testVM):
+			return fakeOutcome{failed: true}
+		}
+		return fakeOutcome{}
+	})
+	p := newFakeDriver(t, f, true)
+
+	_, err := p.Create(context.Background(), createOpts())
+	if err == nil || !strings.Contains(err.Error(), "init job failed") {
+		t.Fatalf("expected the failure of the init job, got %v", err)
+	}
+	registered, deregistered := f.jobIDs()
+	if !slices.Contains(registered, destroyJobID(testVM)) || !slices.Contains(deregistered, resourceJobID(testVM)) {
+		t.Errorf("expected the partially created VM to be destroyed, got the jobs %q and the deregistered jobs %q", registered, deregistered)
+	}
+}
+
+func TestCreate_InitTimeout(t *testing.T) {
+	// the init job keeps running, e.g. the VM never boots
+	f := newFakeNomad(t, func(job *api.Job) fakeOutcome {
+		return fakeOutcome{status: runningStr}
+	})
+	p := newFakeDriver(t, f, true)
+
+	_, err := p.Create(context.Background(), createOpts())
+	if err == nil || !strings.Contains(err.Error(), "never reached terminal state") {
+		t.Fatalf("expected the init job to time out, got %v", err)
+	}
+	if !eventually(t, func() bool { _, deregistered := f.jobIDs(); return slices.Contains(deregistered, initJobID(testVM)) }) {
+		t.Error("expected the stuck init job to be deregistered")
+	}
+}
+
+func TestDestroy_Timeout(t *testing.T) {
+	f := newFakeNomad(t, func(job *api.Job) fakeOutcome {
+		return fakeOutcome{status: runningStr}
+	})
+	p := newFakeDriver(t, f, true)
+
+	err := p.Destroy(context.Background(), []*types.Instance{{ID: testVM, NodeID: fakeNodeID}})
+	if err == nil || !strings.Contains(err.Error(), "never reached terminal state") {
+		t.Fatalf("expected the destroy job to time out, got %v", err)
+	}
+	// the destroy job is kept, the node retries it
+	if f.registeredJob(destroyJobID(testVM)) == nil {
+		t.Error("expected the destroy job not to be deregistered")
+	}
+}
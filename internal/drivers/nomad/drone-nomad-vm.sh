@@ -0,0 +1,186 @@
+#!/bin/bash
+# drone-nomad-vm manages the ignite VMs of the nomad driver on a node. The jobs run it from the task
+# directory as root, or installed in /usr/local/sbin with sudo when the jobs run as a non-root user.
+# The arguments are validated, so the user can be allowed to run it as root and nothing else:
+#
+#   harness ALL=(root) NOPASSWD: /usr/local/sbin/drone-nomad-vm
+#
+# The script only manages the VMs named by the runner, drone-NAME, and never removes VMs younger than
+# MIN_GRACE seconds. The images of the VMs are limited to the prefixes listed in IMAGES, one per line,
+# e.g. registry.example.com/vms/; the list is required when the script is run with sudo.
+#
+# Usage:
+#   drone-nomad-vm create VM IMAGE CPUS MEMORY_GB DISK_SIZE HOST_PORT VM_PORT [MEMORY_LIMIT_MB] < startup-script
+#   drone-nomad-vm destroy VM
+#   drone-nomad-vm janitor GRACE_PERIOD_SECS PREFIX [VM...]
+#   drone-nomad-vm prefetch IMAGE
+set -uo pipefail
+
+IGNITE=/usr/local/bin/ignite
+# SCRIPT_DIR only holds the startup scripts of the VMs, the janitor removes the stale files in it.
+SCRIPT_DIR=/var/lib/drone-nomad-vm
+IMAGES=/etc/drone-nomad-vm/images
+MIN_GRACE=600
+
+die() {
+  echo "drone-nomad-vm: $*" >&2
+  exit 2
+}
+
+# progress reports a phase of the VM creation, the runner streams these lines into the stage logs.
+progress() {
+  echo "drone-nomad-vm: progress: $*"
+}
+
+# is_vm reports whether the VM is named by the runner, the other VMs of the node are left alone.
+is_vm() {
+  [[ "$1" =~ ^drone-[a-z0-9]([a-z0-9-]{0,55}[a-z0-9])?$ ]]
+}
+
+check_vm() {
+  is_vm "$1" || die "invalid VM name: $1"
+}
+
+check_image() {
+  [[ "$1" =~ ^[A-Za-z0-9][A-Za-z0-9._/:@-]*$ ]] || die "invalid image: $1"
+  if [ ! -f "$IMAGES" ]; then
+    [ -z "${SUDO_USER:-}" ] || die "no images are allowed, list them in $IMAGES"
+    return 0
+  fi
+  local prefix
+  while read -r prefix; do
+    case "$prefix" in '' | '#'*) continue ;; esac
+    [[ "$1" == "$prefix"* ]] && return 0
+  done < "$IMAGES"
+  die "image not allowed by $IMAGES: $1"
+}
+
+check_number() {
+  [[ "$2" =~ ^[0-9]+$ ]] || die "invalid $1: $2"
+}
+
+vm_uid() {
+  "$IGNITE" inspect vm "$1" -t '{{.ObjectMeta.UID}}'
+}
+
+# create starts the VM and runs the base64 encoded startup script read from stdin in it. The script
+# is copied into the VM from the node, where it is only readable by root and removed on exit.
+create() {
+  [ $# -ge 7 ] && [ $# -le 8 ] || die "usage: create VM IMAGE CPUS MEMORY_GB DISK_SIZE HOST_PORT VM_PORT [MEMORY_LIMIT_MB]"
+  local vm=$1 image=$2 cpus=$3 memory=$4 disk=$5 host_port=$6 vm_port=$7 limit=${8:-}
+  check_vm "$vm"
+  check_image "$image"
+  check_number cpus "$cpus"
+  check_number memory "$memory"
+  [[ "$disk" =~ ^[0-9]+[KMGT]?B?$ ]] || die "invalid disk size: $disk"
+  check_number "host port" "$host_port"
+  check_number "VM port" "$vm_port"
+  [ -z "$limit" ] || check_number "memory limit" "$limit"
+
+  local script="$SCRIPT_DIR/$vm.sh"
+  install -d -m 0700 "$SCRIPT_DIR" || die "could not create $SCRIPT_DIR"
+  # the path is validated, it is expanded now as the local is out of scope on exit
+  trap "rm -f $script" EXIT
+  (umask 077 && cat > "$script") || die "could not write the startup script"
+
+  progress "starting the VM $vm"
+  "$IGNITE" run "$image" --name "$vm" --cpus "$cpus" --memory "${memory}GB" --size "$disk" --ssh --runtime=docker \
+    --ports "$host_port:$vm_port" --copy-files "$script:/usr/bin/$vm.sh" || exit
+  if [ -n "$limit" ]; then
+    # hard cgroup limits on the container running the VM process, so a runaway VM can't starve the node.
+    docker update --cpus "$cpus" --memory "${limit}m" --memory-swap "${limit}m" "ignite-$(vm_uid "$vm")" || exit
+  fi
+  progress "VM started, running the startup script"
+  "$IGNITE" exec "$vm" "cat /usr/bin/$vm.sh | base64 --decode | bash" || exit
+  progress "startup script done, waiting for the lite-engine on port $host_port"
+  local i
+  for i in $(seq 60); do
+    if nc -z localhost "$host_port" 2> /dev/null; then
+      progress "lite-engine reachable on port $host_port"
+      return 0
+    fi
+    sleep 1
+  done
+  progress "lite-engine not reachable on port $host_port yet"
+}
+
+# destroy stops and removes the VM, its startup script and the docker container running it, so the
+# port forwarded to the VM is released even if ignite fails to remove the container.
+destroy() {
+  [ $# -eq 1 ] || die "usage: destroy VM"
+  local vm=$1 uid status
+  check_vm "$vm"
+
+  uid=$(vm_uid "$vm")
+  "$IGNITE" stop "$vm" && "$IGNITE" rm "$vm"
+  status=$?
+  if [ -n "$uid" ]; then
+    docker rm -f "ignite-$uid" > /dev/null 2>&1
+  fi
+  rm -f "$SCRIPT_DIR/$vm.sh"
+  return $status
+}
+
+# janitor removes the VMs with the prefix of the runner not in the list which are older than the grace
+# period, their stale startup scripts and the ignite containers of removed VMs. The VMs of the other
+# runners sharing the node have other prefixes.
+janitor() {
+  [ $# -ge 2 ] || die "usage: janitor GRACE_PERIOD_SECS PREFIX [VM...]"
+  local grace=$1 prefix=$2 vm f c created uids keep now
+  shift 2
+  check_number "grace period" "$grace"
+  [ "$grace" -ge "$MIN_GRACE" ] || die "the grace period must be at least ${MIN_GRACE}s: $grace"
+  [[ "$prefix" =~ ^drone-[a-z0-9]+-$ ]] || die "invalid prefix: $prefix"
+  for vm in "$@"; do
+    check_vm "$vm"
+  done
+  keep=" $* "
+  now=$(date +%s)
+
+  for vm in $("$IGNITE" ps -a -t '{{.ObjectMeta.Name}}'); do
+    is_vm "$vm" && [[ "$vm" == "$prefix"* ]] || continue
+    case "$keep" in *" $vm "*) continue ;; esac
+    created=$(date -d "$("$IGNITE" inspect vm "$vm" -t '{{.ObjectMeta.Created}}')" +%s) || continue
+    [ $((now - created)) -gt "$grace" ] || continue
+    echo "removing dangling VM $vm"
+    "$IGNITE" rm -f "$vm"
+  done
+  for f in $(find "$SCRIPT_DIR" -maxdepth 1 -type f -name "$prefix*.sh" -mmin +$((grace / 60)) 2> /dev/null); do
+    vm=$(basename "$f" .sh)
+    case "$keep" in *" $vm "*) continue ;; esac
+    echo "removing startup script $f"
+    rm -f "$f"
+  done
+  uids=" $("$IGNITE" ps -a -t '{{.ObjectMeta.UID}}' | tr '\n' ' ') "
+  for c in $(docker ps -a --filter name=ignite- --format '{{.Names}}'); do
+    case "$uids" in *" ${c#ignite-} "*) continue ;; esac
+    echo "removing orphaned container $c"
+    docker rm -f "$c"
+  done
+}
+
+# prefetch imports the VM image into ignite unless it is imported already, so the first VM of the
+# image on the node does not wait for the import.
+prefetch() {
+  [ $# -eq 1 ] || die "usage: prefetch IMAGE"
+  local image=$1
+  check_image "$image"
+
+  if "$IGNITE" inspect image "$image" > /dev/null 2>&1; then
+    echo "image $image already imported"
+    return 0
+  fi
+  echo "importing image $image"
+  "$IGNITE" image import "$image" --runtime=docker
+}
+
+[ $# -ge 1 ] || die "usage: drone-nomad-vm create|destroy|janitor|prefetch ARGS..."
+cmd=$1
+shift
+case "$cmd" in
+  create) create "$@" ;;
+  destroy) destroy "$@" ;;
+  janitor) janitor "$@" ;;
+  prefetch) prefetch "$@" ;;
+  *) die "unknown command: $cmd" ;;
+esac
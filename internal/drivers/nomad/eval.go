@@ -0,0 +1,142 @@
+package nomad
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/hashicorp/nomad/api"
+)
+
+// errJobNotFound is returned when the job polled was deregistered, it never reaches a terminal state.
+var errJobNotFound = errors.New("job not found")
+
+const (
+	evalStatusBlocked = "blocked"
+	evalStatusFailed  = "failed"
+)
+
+// pollBackoff spaces the retries of the failed queries of the jobs, with jitter so the setups
+// polling at the same time don't hit the servers together.
+func pollBackoff() *backoff.ExponentialBackOff {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = 500 * time.Millisecond //nolint:gomnd
+	b.MaxInterval = 15 * time.Second           //nolint:gomnd
+	b.RandomizationFactor = 0.5                //nolint:gomnd
+	b.MaxElapsedTime = 0                       // the poll is bounded by its timeout
+	return b
+}
+
+// isNotFound reports whether the query failed because the object doesn't exist.
+func isNotFound(err error) bool {
+	var resp api.UnexpectedResponseError
+	return errors.As(err, &resp) && resp.StatusCode() == http.StatusNotFound
+}
+
+// PlacementError is the failure of the last evaluation of a job which could not place its
+// allocations, e.g. the nodes ran out of memory or none has the driver of the tasks.
+type PlacementError struct {
+	EvalID string
+	Status string // blocked if the evaluation waits for the resources
+	// Reasons describe the failure of the evaluation and of each of its task groups.
+	Reasons []string
+}
+
+func (e *PlacementError) Error() string {
+	return fmt.Sprintf("evaluation %s is %s: %s", e.EvalID, e.Status, strings.Join(e.Reasons, "; "))
+}
+
+// evalFailure returns the placement failure of the last evaluation of the job, nil if it placed
+// the allocations or if the evaluations can't be read.
+func (p *config) evalFailure(id string) *PlacementError {
+	evals, _, err := p.client.Jobs().Evaluations(id, nil)
+	if err != nil || len(evals) == 0 {
+		return nil
+	}
+	sort.Slice(evals, func(i, j int) bool { return evals[i].CreateIndex > evals[j].CreateIndex })
+
+	blocked := false
+	for _, eval := range evals {
+		// the blocked evaluations wait for the resources, the evaluation before them failed to place the allocations
+		if eval.Status == evalStatusBlocked && len(eval.FailedTGAllocs) == 0 {
+			blocked = true
+			continue
+		}
+		if eval.Status != evalStatusFailed && len(eval.FailedTGAllocs) == 0 {
+			return nil
+		}
+		e := &PlacementError{EvalID: eval.ID, Status: eval.Status}
+		if blocked || eval.BlockedEval != "" {
+			e.Status = evalStatusBlocked
+		}
+		if eval.StatusDescription != "" {
+			e.Reasons = append(e.Reasons, eval.StatusDescription)
+		}
+		groups := make([]string, 0, len(eval.FailedTGAllocs))
+		for group := range eval.FailedTGAllocs {
+			groups = append(groups, group)
+		}
+		sort.Strings(groups)
+		for _, group := range groups {
+			e.Reasons = append(e.Reasons, fmt.Sprintf("%s: %s", group, describeMetric(eval.FailedTGAllocs[group])))
+		}
+		return e
+	}
+	return nil
+}
+
+// describeMetric explains the placement failure of a task group, e.g. "3 nodes evaluated,
+// constraint missing drivers filtered 1 nodes, memory exhausted on 2 nodes (class large: 2)".
+func describeMetric(m *api.AllocationMetric) string {
+	if m == nil {
+		return "no placement"
+	}
+	available := 0
+	for _, n := range m.NodesAvailable {
+		available += n
+	}
+	parts := []string{fmt.Sprintf("%d nodes evaluated", m.NodesEvaluated)}
+	if available == 0 {
+		parts[0] = "no nodes available in the datacenter"
+	}
+	for _, c := range sortedCounts(m.ClassFiltered) {
+		parts = append(parts, fmt.Sprintf("class %s filtered %d nodes", c.key, c.n))
+	}
+	for _, c := range sortedCounts(m.ConstraintFiltered) {
+		parts = append(parts, fmt.Sprintf("constraint %s filtered %d nodes", c.key, c.n))
+	}
+	// the dimensions are cpu, memory, disk or the ports, the classes are the ones of the exhausted nodes
+	var classes []string
+	for _, c := range sortedCounts(m.ClassExhausted) {
+		classes = append(classes, fmt.Sprintf("class %s: %d", c.key, c.n))
+	}
+	for _, c := range sortedCounts(m.DimensionExhausted) {
+		dimension := fmt.Sprintf("%s exhausted on %d nodes", c.key, c.n)
+		if len(classes) > 0 {
+			dimension += " (" + strings.Join(classes, ", ") + ")"
+		}
+		parts = append(parts, dimension)
+	}
+	for _, q := range m.QuotaExhausted {
+		parts = append(parts, fmt.Sprintf("quota %s exhausted", q))
+	}
+	return strings.Join(parts, ", ")
+}
+
+type count struct {
+	key string
+	n   int
+}
+
+func sortedCounts(m map[string]int) []count {
+	counts := make([]count, 0, len(m))
+	for k, n := range m {
+		counts = append(counts, count{key: k, n: n})
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].key < counts[j].key })
+	return counts
+}
@@ -0,0 +1,217 @@
+package nomad
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+	"github.com/hashicorp/nomad/api"
+	"github.com/sirupsen/logrus"
+)
+
+// TestPollForJob verifies that the deregistered jobs end the poll, that the failed queries are retried
+// and that the reason of the last failed evaluation is returned when the job is stuck.
+func TestPollForJob(t *testing.T) {
+	var infoCalls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Nomad-Index", "1")
+		switch r.URL.Path {
+		case "/v1/job/gone":
+			http.Error(w, "job not found", http.StatusNotFound)
+		case "/v1/job/flaky":
+			if atomic.AddInt32(&infoCalls, 1) < 3 {
+				http.Error(w, "no leader", http.StatusInternalServerError)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(&api.Job{ID: stringToPtr("flaky"), Status: stringToPtr(deadStr)})
+		case "/v1/job/stuck":
+			_ = json.NewEncoder(w).Encode(&api.Job{ID: stringToPtr("stuck"), Status: stringToPtr(pendingStr)})
+		case "/v1/job/stuck/evaluations":
+			_ = json.NewEncoder(w).Encode([]*api.Evaluation{
+				{ID: "eval-1", Status: "complete", CreateIndex: 1, BlockedEval: "eval-2", FailedTGAllocs: map[string]*api.AllocationMetric{
+					"resource": {
+						NodesEvaluated:     3,
+						NodesAvailable:     map[string]int{"dc1": 3},
+						ConstraintFiltered: map[string]int{"missing drivers": 1},
+						DimensionExhausted: map[string]int{"memory": 2},
+						ClassExhausted:     map[string]int{"large": 2},
+					},
+				}},
+				{ID: "eval-2", Status: evalStatusBlocked, CreateIndex: 2},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := api.NewClient(&api.Config{Address: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &config{client: client}
+	logr := logger.Logrus(logrus.NewEntry(logrus.New()))
+	ctx := context.Background()
+
+	start := time.Now()
+	if _, err = p.pollForJob(ctx, "gone", logr, time.Minute, false, nil); !errors.Is(err, errJobNotFound) {
+		t.Errorf("expected the deregistered job to end the poll, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("expected the deregistered job not to be polled until the timeout")
+	}
+
+	job, err := p.pollForJob(ctx, "flaky", logr, time.Minute, false, nil)
+	if err != nil || *job.Status != deadStr {
+		t.Errorf("expected the failed queries to be retried, got %v", err)
+	}
+
+	_, err = p.pollForJob(ctx, "stuck", logr, 500*time.Millisecond, false, []JobStatus{Running})
+	if err == nil {
+		t.Fatal("expected the stuck job to time out")
+	}
+	var placementErr *PlacementError
+	if !errors.As(err, &placementErr) || placementErr.EvalID != "eval-1" || placementErr.Status != evalStatusBlocked {
+		t.Errorf("expected the failure of the blocked evaluation, got %v", err)
+	}
+	for _, want := range []string{"constraint missing drivers filtered 1 nodes", "memory exhausted on 2 nodes (class large: 2)"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected the error to contain %q, got %q", want, err)
+		}
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/job/broken" {
+			http.Error(w, "no leader", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "job not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	client, err := api.NewClient(&api.Config{Address: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = client.Jobs().Info("gone", nil); !isNotFound(err) {
+		t.Errorf("expected the 404 to be reported as not found, got %v", err)
+	}
+	if _, _, err = client.Jobs().Info("broken", nil); err == nil || isNotFound(err) {
+		t.Errorf("expected the 500 not to be reported as not found, got %v", err)
+	}
+	if isNotFound(errors.New("Unexpected response code: 404")) {
+		t.Error("expected an untyped error not to be reported as not found")
+	}
+}
+
+func TestEvalFailure(t *testing.T) {
+	exhausted := &api.AllocationMetric{
+		NodesEvaluated:     2,
+		NodesAvailable:     map[string]int{"dc1": 2},
+		DimensionExhausted: map[string]int{"memory": 2},
+	}
+	tests := []struct {
+		name  string
+		evals []*api.Evaluation
+		want  string
+	}{
+		{
+			name: "blocked",
+			evals: []*api.Evaluation{
+				{ID: "eval-1", Status: "complete", CreateIndex: 1, FailedTGAllocs: map[string]*api.AllocationMetric{"resource": exhausted}},
+				{ID: "eval-2", Status: evalStatusBlocked, CreateIndex: 2},
+			},
+			want: "evaluation eval-1 is blocked: resource: 2 nodes evaluated, memory exhausted on 2 nodes",
+		},
+		{
+			name: "failed",
+			evals: []*api.Evaluation{
+				{ID: "eval-1", Status: evalStatusFailed, StatusDescription: "maximum attempts reached", CreateIndex: 1, FailedTGAllocs: map[string]*api.AllocationMetric{
+					"resource": exhausted,
+					"init":     nil,
+				}},
+			},
+			want: "evaluation eval-1 is failed: maximum attempts reached; init: no placement; resource: 2 nodes evaluated, memory exhausted on 2 nodes",
+		},
+		{
+			name: "placed",
+			evals: []*api.Evaluation{
+				{ID: "eval-1", Status: evalStatusFailed, CreateIndex: 1},
+				{ID: "eval-2", Status: "complete", CreateIndex: 2},
+			},
+		},
+		{
+			name: "no evaluation",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Nomad-Index", "1")
+				_ = json.NewEncoder(w).Encode(test.evals)
+			}))
+			defer srv.Close()
+
+			client, err := api.NewClient(&api.Config{Address: srv.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := &config{client: client}
+			got := ""
+			if e := p.evalFailure("job"); e != nil {
+				got = e.Error()
+			}
+			if got != test.want {
+				t.Errorf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestDescribeMetric(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric *api.AllocationMetric
+		want   string
+	}{
+		{
+			name: "no metric",
+			want: "no placement",
+		},
+		{
+			name:   "no nodes",
+			metric: &api.AllocationMetric{NodesAvailable: map[string]int{"dc1": 0}},
+			want:   "no nodes available in the datacenter",
+		},
+		{
+			name: "filtered and exhausted",
+			metric: &api.AllocationMetric{
+				NodesEvaluated:     3,
+				NodesAvailable:     map[string]int{"dc1": 2, "dc2": 1},
+				ClassFiltered:      map[string]int{"gpu": 1},
+				ConstraintFiltered: map[string]int{"missing drivers": 1, "${attr.kernel.name} = linux": 2},
+				DimensionExhausted: map[string]int{"memory": 1, "cpu": 2},
+				QuotaExhausted:     []string{"memory exhausted (2048 > 1024)"},
+			},
+			want: "3 nodes evaluated, class gpu filtered 1 nodes, constraint ${attr.kernel.name} = linux filtered 2 nodes, " +
+				"constraint missing drivers filtered 1 nodes, cpu exhausted on 2 nodes, memory exhausted on 1 nodes, " +
+				"quota memory exhausted (2048 > 1024) exhausted",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := describeMetric(test.metric); got != test.want {
+				t.Errorf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package nomad
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+const (
+	fakeNodeID   = "node-1"
+	fakeNodeIP   = "10.0.0.5"
+	fakeHostPort = 20000
+)
+
+// fakeOutcome is how a job registered with the fake server behaves.
+type fakeOutcome struct {
+	status   string            // the status the job reaches right away, dead if empty
+	failed   bool              // the tasks of the job fail
+	unplaced bool              // the job gets no allocation
+	evals    []*api.Evaluation // the evaluations of the job, e.g. the placement failures
+}
+
+// fakeJob is a job registered with the fake server.
+type fakeJob struct {
+	job     *api.Job
+	outcome fakeOutcome
+	index   uint64
+}
+
+// fakeNomad is an in-memory Nomad server running the driver against a single node, the jobs
+// registered reach the status of their outcome right away. The blocking queries return after a
+// short wait when nothing changed, like the queries of a real server waiting for their index.
+type fakeNomad struct {
+	*httptest.Server
+
+	// outcome returns the behaviour of a job on its registration, the jobs complete if nil.
+	outcome func(job *api.Job) fakeOutcome
+
+	mu           sync.Mutex
+	index        uint64
+	jobs         map[string]*fakeJob
+	registered   []string // the IDs of the jobs, in the order of their registration
+	deregistered []string
+}
+
+func newFakeNomad(t *testing.T, outcome func(job *api.Job) fakeOutcome) *fakeNomad {
+	t.Helper()
+	f := &fakeNomad{outcome: outcome, index: 1, jobs: make(map[string]*fakeJob)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// newFakeDriver returns a driver of the fake server with short timeouts.
+func newFakeDriver(t *testing.T, f *fakeNomad, noop bool) *config {
+	t.Helper()
+	d, err := New(WithAddress(f.URL), WithNoop(noop), WithCpus("2"), WithMemory("4"),
+		WithImage(""), WithDiskSize(""), WithTimeouts(500*time.Millisecond, 500*time.Millisecond, 500*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.(*config)
+}
+
+func (f *fakeNomad) serve(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	switch {
+	case path == "jobs" && (r.Method == http.MethodPut || r.Method == http.MethodPost):
+		f.register(w, r)
+	case path == "jobs":
+		f.list(w, r)
+	case path == "nodes":
+		f.write(w, []*api.NodeListStub{{
+			ID: fakeNodeID, Datacenter: datacenter, Status: "ready", SchedulingEligibility: "eligible",
+			NodeResources: &api.NodeResources{Cpu: api.NodeCpuResources{CpuShares: 32000}, Memory: api.NodeMemoryResources{MemoryMB: 65536}},
+		}})
+	case path == "node/"+fakeNodeID:
+		f.write(w, &api.Node{ID: fakeNodeID, HTTPAddr: fakeNodeIP + ":4646"})
+	case strings.HasPrefix(path, "allocation/"):
+		f.write(w, &api.Allocation{
+			ID: strings.TrimPrefix(path, "allocation/"), NodeID: fakeNodeID,
+			Resources: &api.Resources{Networks: []*api.NetworkResource{{DynamicPorts: []api.Port{{Label: "vm", Value: fakeHostPort}}}}},
+		})
+	case strings.HasPrefix(path, "job/"):
+		id, sub, _ := strings.Cut(strings.TrimPrefix(path, "job/"), "/")
+		f.job(w, r, id, sub)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeNomad) register(w http.ResponseWriter, r *http.Request) {
+	var req api.JobRegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Job == nil || req.Job.ID == nil {
+		http.Error(w, "invalid job", http.StatusBadRequest)
+		return
+	}
+	outcome := fakeOutcome{}
+	if f.outcome != nil {
+		outcome = f.outcome(req.Job)
+	}
+	if outcome.status == "" {
+		outcome.status = deadStr
+	}
+	req.Job.Status = stringToPtr(outcome.status)
+
+	f.mu.Lock()
+	f.index++
+	f.jobs[*req.Job.ID] = &fakeJob{job: req.Job, outcome: outcome, index: f.index}
+	f.registered = append(f.registered, *req.Job.ID)
+	f.mu.Unlock()
+	f.write(w, &api.JobRegisterResponse{EvalID: "eval-" + *req.Job.ID})
+}
+
+// list writes the jobs with the prefix of the query.
+func (f *fakeNomad) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	f.mu.Lock()
+	var stubs []*api.JobListStub
+	for id, j := range f.jobs {
+		if strings.HasPrefix(id, prefix) {
+			stubs = append(stubs, &api.JobListStub{ID: id, Status: *j.job.Status})
+		}
+	}
+	f.mu.Unlock()
+	f.write(w, stubs)
+}
+
+func (f *fakeNomad) job(w http.ResponseWriter, r *http.Request, id, sub string) {
+	if r.Method == http.MethodDelete {
+		f.mu.Lock()
+		delete(f.jobs, id)
+		f.index++
+		f.deregistered = append(f.deregistered, id)
+		f.mu.Unlock()
+		f.write(w, &api.JobDeregisterResponse{EvalID: "eval-deregister"})
+		return
+	}
+
+	f.wait(r)
+	f.mu.Lock()
+	j := f.jobs[id]
+	f.mu.Unlock()
+	if j == nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	group := ""
+	if len(j.job.TaskGroups) > 0 && j.job.TaskGroups[0].Name != nil {
+		group = *j.job.TaskGroups[0].Name
+	}
+	switch sub {
+	case "":
+		f.write(w, j.job)
+	case "allocations":
+		var allocs []*api.AllocationListStub
+		if !j.outcome.unplaced {
+			allocs = append(allocs, &api.AllocationListStub{ID: "alloc-" + id, NodeID: fakeNodeID, ClientStatus: api.AllocClientStatusComplete})
+		}
+		f.write(w, allocs)
+	case "summary":
+		summary := api.TaskGroupSummary{Complete: 1}
+		if j.outcome.failed {
+			summary = api.TaskGroupSummary{Failed: 1}
+		}
+		f.write(w, &api.JobSummary{JobID: id, Summary: map[string]api.TaskGroupSummary{group: summary}})
+	case "evaluations":
+		f.write(w, j.outcome.evals)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// wait holds a blocking query a little when its index is current, the queries would spin otherwise.
+func (f *fakeNomad) wait(r *http.Request) {
+	index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	f.mu.Lock()
+	current := f.index
+	f.mu.Unlock()
+	if index > 0 && index >= current {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func (f *fakeNomad) write(w http.ResponseWriter, v interface{}) {
+	f.mu.Lock()
+	index := f.index
+	f.mu.Unlock()
+	w.Header().Set("X-Nomad-Index", strconv.FormatUint(index, 10))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// jobIDs returns the registered and the deregistered jobs.
+func (f *fakeNomad) jobIDs() (registered, deregistered []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.registered...), append([]string(nil), f.deregistered...)
+}
+
+// registeredJob returns the last registration of the job, nil if it was not registered or was deregistered.
+func (f *fakeNomad) registeredJob(id string) *api.Job {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if j := f.jobs[id]; j != nil {
+		return j.job
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package nomad
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/drone/runner-go/logger"
+	"github.com/hashicorp/nomad/api"
+)
+
+// janitorJobTimeout is the time the janitor job gets to run on all the nodes.
+var janitorJobTimeout = 10 * time.Minute
+
+// CleanupHosts runs a janitor job on every node which removes the VMs of the runner which are neither
+// live instances nor being created, their startup scripts and the containers left behind by removed
+// VMs, which keep holding the ports forwarded to the VMs. The VMs of the other runners sharing the
+// nodes are left alone, see vmPrefix.
+func (p *config) CleanupHosts(ctx context.Context, runnerName, _ string, live []*types.Instance) error {
+	if p.noop {
+		return nil
+	}
+
+	prefix := vmPrefix(runnerName)
+	keep := make(map[string]bool, len(live))
+	for _, inst := range live {
+		// the pools sharing the hosts might not be nomad pools
+		if isVMName(inst.ID) && strings.HasPrefix(inst.ID, prefix) {
+			keep[inst.ID] = true
+		}
+	}
+	// the VMs being created are not in the store yet, their resource jobs are registered first.
+	jobs, _, err := p.client.Jobs().List(&api.QueryOptions{Prefix: resourceJobID(prefix)})
+	if err != nil {
+		return fmt.Errorf("scheduler: could not list the resource jobs: %w", err)
+	}
+	for _, job := range jobs {
+		if job.Status != deadStr {
+			keep[strings.TrimPrefix(job.ID, resourceJobID(""))] = true
+		}
+	}
+
+	job, id := p.janitorJob(prefix, keep)
+	logr := logger.FromContext(ctx).WithField("job_id", id)
+
+	logr.Debugln("scheduler: submitting janitor job")
+	if _, _, err = p.client.Jobs().Register(job, nil); err != nil {
+		return fmt.Errorf("scheduler: could not register janitor job, err: %w", err)
+	}
+	_, err = p.pollForJob(ctx, id, logr, janitorJobTimeout, true, []JobStatus{Dead})
+	if err != nil {
+		return fmt.Errorf("scheduler: janitor job did not complete, err: %w", err)
+	}
+	return p.deregisterJob(logr, id, true)
+}
+
+// janitorJob returns a job which runs the janitor script once on every node.
+func (p *config) janitorJob(prefix string, keep map[string]bool) (job *api.Job, id string) {
+	id = fmt.Sprintf("janitor_job_%s", strings.ToLower(random(10))) //nolint:gomnd
+	job = &api.Job{
+		ID:          &id,
+		Name:        stringToPtr(id),
+		Type:        stringToPtr("sysbatch"),
+		Datacenters: []string{datacenter},
+		TaskGroups: []*api.TaskGroup{
+			{
+				StopAfterClientDisconnect: &clientDisconnectTimeout,
+				RestartPolicy: &api.RestartPolicy{
+					Attempts: intToPtr(0),
+				},
+				Name:  stringToPtr("janitor_task_group"),
+				Count: intToPtr(1),
+				Tasks: []*api.Task{
+					p.vmTask(&api.Task{
+						Name:      "janitor",
+						Resources: minNomadResources(),
+					}, p.janitorCmd(prefix, keep)),
+				},
+			},
+		},
+	}
+	return job, id
+}
+
+// janitorCmd returns the command removing the VMs with the prefix not in the keep list which are older
+// than the grace period, their stale startup scripts and the containers of the removed VMs.
+func (p *config) janitorCmd(prefix string, keep map[string]bool) string {
+	args := make([]string, 0, len(keep)+2) //nolint:gomnd
+	args = append(args, strconv.Itoa(int(drivers.JanitorGracePeriod.Seconds())), prefix)
+	vms := make([]string, 0, len(keep))
+	for vm := range keep {
+		vms = append(vms, vm)
+	}
+	sort.Strings(vms)
+	return p.vmCmd("janitor", append(args, vms...)...)
+}
@@ -0,0 +1,44 @@
+package nomad
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/hashicorp/nomad/api"
+)
+
+func TestCleanupHosts_RunnerVMs(t *testing.T) {
+	mine, other := vmPrefix("runner"), vmPrefix("other")
+	if mine == other || !isVMName(mine+"vm") {
+		t.Fatalf("expected distinct VM prefixes of the runners, got %q and %q", mine, other)
+	}
+
+	var cmd string
+	f := newFakeNomad(t, func(job *api.Job) fakeOutcome {
+		if strings.HasPrefix(*job.ID, "janitor_job_") {
+			cmd = job.TaskGroups[0].Tasks[0].Config["args"].([]interface{})[1].(string)
+		}
+		if strings.HasPrefix(*job.ID, resourceJobID("")) {
+			return fakeOutcome{status: runningStr}
+		}
+		return fakeOutcome{}
+	})
+	p := newFakeDriver(t, f, false)
+	// the VMs being created by both runners
+	for _, vm := range []string{mine + "new", other + "new"} {
+		if _, _, err := p.client.Jobs().Register(&api.Job{ID: stringToPtr(resourceJobID(vm))}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	live := []*types.Instance{{ID: mine + "live"}, {ID: other + "live"}, {ID: "i-0123456789"}}
+	if err := p.CleanupHosts(context.Background(), "runner", "pool", live); err != nil {
+		t.Fatal(err)
+	}
+	want := "/bin/bash ${NOMAD_TASK_DIR}/drone-nomad-vm janitor 900 " + mine + " " + mine + "live " + mine + "new"
+	if cmd != want {
+		t.Errorf("got the janitor command %q, want %q", cmd, want)
+	}
+}
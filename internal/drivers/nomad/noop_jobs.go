@@ -19,7 +19,7 @@ func (p *config) initJobNoop(vm, startupScript string, hostPort int, nodeID stri
 		ID:          &initjobID,
 		Name:        stringToPtr(vm),
 		Type:        stringToPtr("batch"),
-		Datacenters: []string{"dc1"},
+		Datacenters: []string{datacenter},
 		Constraints: []*api.Constraint{
 			{
 				LTarget: "${node.unique.id}",
@@ -40,15 +40,10 @@ func (p *config) initJobNoop(vm, startupScript string, hostPort int, nodeID stri
 				Name:  stringToPtr(initTaskGroup),
 				Count: intToPtr(1),
 				Tasks: []*api.Task{
-					{
+					p.shellTask(&api.Task{
 						Name:      "sleep",
-						Driver:    "raw_exec",
 						Resources: minNomadResources(),
-						Config: map[string]interface{}{
-							"command": "/usr/bin/su",
-							"args":    []string{"-c", "sleep 7"},
-						},
-					},
+					}, "sleep 7"),
 				},
 			},
 		},
@@ -67,7 +62,7 @@ func (p *config) resourceJobNoop(cpus, memGB int, vm string) (job *api.Job, id s
 		ID:          &id,
 		Name:        stringToPtr(id),
 		Type:        stringToPtr("batch"),
-		Datacenters: []string{"dc1"},
+		Datacenters: []string{datacenter},
 		// TODO (Vistaar): This can be updated once we have more data points
 		Reschedule: &api.ReschedulePolicy{
 			Attempts:  intToPtr(0),
@@ -83,16 +78,10 @@ func (p *config) resourceJobNoop(cpus, memGB int, vm string) (job *api.Job, id s
 				Name:  stringToPtr(fmt.Sprintf("init_task_group_resource_%s", vm)),
 				Count: intToPtr(1),
 				Tasks: []*api.Task{
-					{
-
+					p.shellTask(&api.Task{
 						Name:      "sleep_and_ping",
 						Resources: minNomadResources(),
-						Driver:    "raw_exec",
-						Config: map[string]interface{}{
-							"command": "/usr/bin/su",
-							"args":    []string{"-c", "sleep 3"},
-						},
-					},
+					}, "sleep 3"),
 				},
 			},
 		},
@@ -113,7 +102,7 @@ func (p *config) destroyJobNoop(vm, nodeID string) (job *api.Job, id string) {
 		Name: stringToPtr(random(20)), //nolint:gomnd
 
 		Type:        stringToPtr("batch"),
-		Datacenters: []string{"dc1"},
+		Datacenters: []string{datacenter},
 		Constraints: []*api.Constraint{
 			constraint,
 		},
@@ -126,15 +115,10 @@ func (p *config) destroyJobNoop(vm, nodeID string) (job *api.Job, id string) {
 				Name:  stringToPtr(fmt.Sprintf("delete_task_group_%s", vm)),
 				Count: intToPtr(1),
 				Tasks: []*api.Task{
-					{
+					p.shellTask(&api.Task{
 						Name:      "ignite_stop_and_rm",
 						Resources: minNomadResources(),
-						Driver:    "raw_exec",
-						Config: map[string]interface{}{
-							"command": "/usr/bin/su",
-							"args":    []string{"-c", "sleep 2"},
-						},
-					},
+					}, "sleep 2"),
 				},
 			},
 		}}
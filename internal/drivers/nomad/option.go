@@ -1,5 +1,7 @@
 package nomad
 
+import "time"
+
 type Option func(*config)
 
 func WithAddress(s string) Option {
@@ -8,6 +10,20 @@ func WithAddress(s string) Option {
 	}
 }
 
+// WithAddresses sets the servers failed over to when the address is unreachable.
+func WithAddresses(s []string) Option {
+	return func(p *config) {
+		p.addresses = s
+	}
+}
+
+// WithTLSServerName sets the name the certificates of the servers are verified against.
+func WithTLSServerName(s string) Option {
+	return func(p *config) {
+		p.tlsServerName = s
+	}
+}
+
 func WithCaCertPath(s string) Option {
 	return func(p *config) {
 		p.caCertPath = s
@@ -47,6 +63,18 @@ func WithNoop(b bool) Option {
 	}
 }
 
+func WithEnforceLimits(b bool) Option {
+	return func(p *config) {
+		p.enforceLimits = b
+	}
+}
+
+func WithUser(s string) Option {
+	return func(p *config) {
+		p.user = s
+	}
+}
+
 func WithMemory(s string) Option {
 	return func(p *config) {
 		p.vmMemoryGB = s
@@ -73,3 +101,18 @@ func WithDiskSize(s string) Option {
 		}
 	}
 }
+
+// WithTimeouts sets the timeouts of the resource, init and destroy jobs, the defaults are kept for the ones not set.
+func WithTimeouts(resourceJob, init, destroy time.Duration) Option {
+	return func(p *config) {
+		if resourceJob > 0 {
+			p.resourceJobTimeout = resourceJob
+		}
+		if init > 0 {
+			p.initTimeout = init
+		}
+		if destroy > 0 {
+			p.destroyTimeout = destroy
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package nomad
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+	"github.com/hashicorp/nomad/api"
+)
+
+// prefetchJobTimeout is the time the prefetch job gets to import the image on all the nodes.
+var prefetchJobTimeout = 30 * time.Minute
+
+// PrefetchImage runs a prefetch job on every eligible node which imports the VM image into ignite,
+// so the first VM on a fresh node does not wait minutes for the import. The nodes which imported
+// the image already skip it.
+func (p *config) PrefetchImage(ctx context.Context) error {
+	if p.noop {
+		return nil
+	}
+
+	job, id := p.prefetchJob()
+	logr := logger.FromContext(ctx).WithField("job_id", id).WithField("image", p.vmImage)
+
+	logr.Debugln("scheduler: submitting prefetch job")
+	if _, _, err := p.client.Jobs().Register(job, nil); err != nil {
+		return fmt.Errorf("scheduler: could not register prefetch job, err: %w", err)
+	}
+	if _, err := p.pollForJob(ctx, id, logr, prefetchJobTimeout, true, []JobStatus{Dead}); err != nil {
+		return fmt.Errorf("scheduler: prefetch job did not complete, err: %w", err)
+	}
+	return p.deregisterJob(logr, id, true)
+}
+
+// prefetchJob returns a job which imports the VM image once on every eligible node.
+func (p *config) prefetchJob() (job *api.Job, id string) {
+	id = fmt.Sprintf("prefetch_job_%s", strings.ToLower(random(10))) //nolint:gomnd
+	job = &api.Job{
+		ID:          &id,
+		Name:        stringToPtr(id),
+		Type:        stringToPtr("sysbatch"),
+		Datacenters: []string{datacenter},
+		TaskGroups: []*api.TaskGroup{
+			{
+				StopAfterClientDisconnect: &clientDisconnectTimeout,
+				RestartPolicy: &api.RestartPolicy{
+					Attempts: intToPtr(0),
+				},
+				Name:  stringToPtr("prefetch_task_group"),
+				Count: intToPtr(1),
+				Tasks: []*api.Task{
+					p.vmTask(&api.Task{
+						Name:      "prefetch",
+						Resources: minNomadResources(),
+					}, p.vmCmd("prefetch", p.vmImage)),
+				},
+			},
+		},
+	}
+	return job, id
+}
@@ -0,0 +1,21 @@
+package nomad
+
+import (
+	"testing"
+)
+
+func TestPrefetchJob(t *testing.T) {
+	p := &config{vmImage: "harness/vmimage:v1", user: "harness"}
+
+	job, id := p.prefetchJob()
+	if *job.Type != "sysbatch" || len(job.Constraints) != 0 {
+		t.Errorf("expected a sysbatch job on every node, got %+v", job)
+	}
+	if !isRunnerJob(id) {
+		t.Errorf("expected the prefetch job %s to count as a job of the runner", id)
+	}
+	args := job.TaskGroups[0].Tasks[0].Config["args"].([]string)
+	if want := "sudo -n " + vmScriptPath + " prefetch harness/vmimage:v1"; args[1] != want {
+		t.Errorf("got the command %q, want %q", args[1], want)
+	}
+}
@@ -0,0 +1,106 @@
+package nomad
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+	"github.com/hashicorp/nomad/api"
+)
+
+const (
+	// progressPrefix marks the lines of drone-nomad-vm reporting a phase of the VM creation.
+	progressPrefix = "drone-nomad-vm: progress: "
+	// initTask is the task of the init job creating the VM.
+	initTask = "ignite_run"
+)
+
+// watchInitJob reports the progress of the init job in the logs of the setup until the context
+// is done: the events of its tasks and the phases drone-nomad-vm prints while creating the VM, so a
+// stuck phase is visible in the stage logs instead of a silent timeout.
+func (p *config) watchInitJob(ctx context.Context, logr logger.Logger, jobID string) {
+	logged := map[string]int{} // the number of events logged by task
+	tailing := false
+	var waitIndex uint64
+	for ctx.Err() == nil {
+		q := (&api.QueryOptions{WaitTime: 15 * time.Second, WaitIndex: waitIndex}).WithContext(ctx)
+		allocs, qm, err := p.client.Jobs().Allocations(jobID, false, q)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		waitIndex = qm.LastIndex
+		for _, alloc := range allocs {
+			for task, state := range alloc.TaskStates {
+				if state == nil || len(state.Events) <= logged[task] {
+					continue
+				}
+				for _, e := range state.Events[logged[task]:] {
+					logr.WithField("task", task).Infof("scheduler: init job: %s", describeEvent(e))
+				}
+				logged[task] = len(state.Events)
+			}
+			if !tailing && alloc.ClientStatus == api.AllocClientStatusRunning {
+				tailing = true
+				go p.tailProgress(ctx, logr, alloc.ID)
+			}
+		}
+	}
+}
+
+// tailProgress follows the output of the init task and logs the phases reported by drone-nomad-vm.
+func (p *config) tailProgress(ctx context.Context, logr logger.Logger, allocID string) {
+	alloc, _, err := p.client.Allocations().Info(allocID, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		logr.WithError(err).Debugln("scheduler: could not get the allocation of the init job")
+		return
+	}
+	frames, errs := p.client.AllocFS().Logs(alloc, true, initTask, "stdout", "start", 0, ctx.Done(), (&api.QueryOptions{}).WithContext(ctx))
+	var partial []byte
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case streamErr := <-errs:
+			if streamErr != nil && ctx.Err() == nil {
+				logr.WithError(streamErr).Debugln("scheduler: could not follow the output of the init job")
+			}
+			return
+		case frame, ok := <-frames:
+			if !ok {
+				return
+			}
+			if frame == nil {
+				continue
+			}
+			partial = append(partial, frame.Data...)
+			for {
+				i := bytes.IndexByte(partial, '\n')
+				if i < 0 {
+					break
+				}
+				if line := string(partial[:i]); strings.HasPrefix(line, progressPrefix) {
+					logr.Infof("scheduler: %s", strings.TrimPrefix(line, progressPrefix))
+				}
+				partial = partial[i+1:]
+			}
+		}
+	}
+}
+
+// describeEvent returns the message of a task event, prefixed with its type.
+func describeEvent(e *api.TaskEvent) string {
+	msg := e.DisplayMessage
+	if msg == "" {
+		msg = e.Message
+	}
+	if msg == "" || msg == e.Type {
+		return e.Type
+	}
+	return e.Type + ": " + msg
+}
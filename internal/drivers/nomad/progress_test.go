@@ -0,0 +1,102 @@
+package nomad
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+	"github.com/hashicorp/nomad/api"
+	"github.com/sirupsen/logrus"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestWatchInitJob verifies that the events of the init task are logged once.
+func TestWatchInitJob(t *testing.T) {
+	var mu sync.Mutex
+	events := []*api.TaskEvent{{Type: api.TaskReceived, DisplayMessage: "Task received by client"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/job/init-vm/allocations" {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		allocs := []*api.AllocationListStub{{
+			ID:           "alloc-1",
+			ClientStatus: api.AllocClientStatusPending,
+			TaskStates:   map[string]*api.TaskState{initTask: {Events: append([]*api.TaskEvent(nil), events...)}},
+		}}
+		mu.Unlock()
+		w.Header().Set("X-Nomad-Index", "1")
+		time.Sleep(10 * time.Millisecond)
+		_ = json.NewEncoder(w).Encode(allocs)
+	}))
+	defer srv.Close()
+
+	client, err := api.NewClient(&api.Config{Address: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &config{client: client}
+
+	out := &syncBuffer{}
+	log := logrus.New()
+	log.Out = out
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.watchInitJob(ctx, logger.Logrus(logrus.NewEntry(log)), "init-vm")
+		close(done)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	events = append(events, &api.TaskEvent{Type: api.TaskStarted, DisplayMessage: "Task started by client"})
+	mu.Unlock()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	<-done
+
+	logs := out.String()
+	if strings.Count(logs, "Received: Task received by client") != 1 || strings.Count(logs, "Started: Task started by client") != 1 {
+		t.Errorf("unexpected init job logs %q", logs)
+	}
+}
+
+func TestDescribeEvent(t *testing.T) {
+	tests := []struct {
+		event *api.TaskEvent
+		want  string
+	}{
+		{&api.TaskEvent{Type: api.TaskStarted}, "Started"},
+		{&api.TaskEvent{Type: api.TaskTerminated, DisplayMessage: "Exit Code: 1"}, "Terminated: Exit Code: 1"},
+		{&api.TaskEvent{Type: api.TaskSetup, Message: "Building Task Directory"}, "Task Setup: Building Task Directory"},
+	}
+	for _, test := range tests {
+		if got := describeEvent(test.event); got != test.want {
+			t.Errorf("describeEvent(%+v) = %q, want %q", test.event, got, test.want)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package nomad
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+
 	"github.com/dchest/uniuri"
 )
 
@@ -28,7 +31,27 @@ func random(n int) string {
 	return uniuri.NewLen(n)
 }
 
+// isVMName reports whether s is the name of a VM created by the driver, see Create.
+func isVMName(s string) bool {
+	return vmNameRegexp.MatchString(s)
+}
+
+// vmPrefix returns the prefix of the names of the VMs of the runner. It holds a hash of the runner
+// name, so the janitor only removes the VMs of the runner from the nodes shared by several runners.
+func vmPrefix(runner string) string {
+	sum := sha256.Sum256([]byte(runner))
+	return vmNamePrefix + hex.EncodeToString(sum[:])[:runnerTagLen] + "-"
+}
+
 // convert gigs to megs
 func convertGigsToMegs(p int) int {
 	return p * gigsToMegs
 }
+
+// max64 returns the larger of a and b
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -5,7 +5,6 @@ import (
 	"time"
 
 	"github.com/drone-runners/drone-runner-aws/internal/drivers"
-	"github.com/drone-runners/drone-runner-aws/internal/lehelper"
 	"github.com/drone-runners/drone-runner-aws/types"
 	"github.com/google/uuid"
 )
@@ -43,6 +42,7 @@ func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 	return &types.Instance{
 		ID:           id,
 		Name:         id,
+		ProviderID:   id,
 		Provider:     types.Noop, // this is driver, though its the old legacy name of provider
 		State:        types.StateCreated,
 		Pool:         opts.PoolName,
@@ -55,7 +55,7 @@ func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 		Started:      time.Now().Unix(),
 		Updated:      time.Now().Unix(),
 		IsHibernated: false,
-		Port:         lehelper.LiteEnginePort,
+		Port:         int64(opts.LiteEnginePort),
 	}, nil
 }
 
@@ -97,3 +97,8 @@ func (p *config) DriverName() string {
 func (p *config) CanHibernate() bool {
 	return p.hibernate
 }
+
+// Capabilities of the noop driver are simulated.
+func (p *config) Capabilities() drivers.Capabilities {
+	return drivers.Capabilities{Tags: true}
+}
@@ -0,0 +1,35 @@
+package drivers
+
+import (
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// splitOverflow returns the instances of the pool apart from its overflow instances, and the
+// number of the overflow instances.
+func splitOverflow(instances []*types.Instance) (regular []*types.Instance, overflow int) {
+	regular = make([]*types.Instance, 0, len(instances))
+	for _, inst := range instances {
+		if inst.Overflow {
+			overflow++
+			continue
+		}
+		regular = append(regular, inst)
+	}
+	return regular, overflow
+}
+
+// reserve checks an instance can be created for a stage. Once the pool is full, the instance is an
+// overflow instance if the pool has room for one, and release must be called after its creation.
+// It must be called with the pool locked, busy and free are the instances of the pool.
+func reserve(pool *poolEntry, strategy Strategy, busy, free []*types.Instance) (overflow bool, release func(), err error) {
+	regular, overflowCount := splitOverflow(busy)
+	if strategy.CanCreate(pool.MinSize, pool.MaxSize, len(regular), len(free)) {
+		return false, func() {}, nil
+	}
+	// the overflow instances being created are not in the store yet
+	if overflowCount+int(pool.overflowPending.Load()) >= pool.Overflow {
+		return false, nil, ErrorNoInstanceAvailable
+	}
+	pool.overflowPending.Add(1)
+	return true, func() { pool.overflowPending.Add(-1) }, nil
+}
@@ -0,0 +1,66 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// countingDriver creates the instances with sequential ids.
+type countingDriver struct {
+	failingDriver
+	created atomic.Int32
+}
+
+func (d *countingDriver) Create(_ context.Context, opts *types.InstanceCreateOpts) (*types.Instance, error) {
+	return &types.Instance{ID: fmt.Sprintf("instance-%d", d.created.Add(1)), Pool: opts.PoolName}, nil
+}
+
+func TestProvisionOverflow(t *testing.T) {
+	const pool = "linux"
+
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	instanceStore := ldb.NewInstanceStore(db)
+	if err = instanceStore.Create(ctx, &types.Instance{ID: "busy", Pool: pool, State: types.StateInUse}); err != nil {
+		t.Fatal(err)
+	}
+
+	env := &config.EnvConfig{}
+	m := New(ctx, instanceStore, env)
+	m.strategy = MinMax{}
+	if err = m.Add(Pool{Name: pool, MaxSize: 1, Overflow: 1, Driver: &countingDriver{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	inst, err := m.Provision(ctx, pool, "runner", env)
+	if err != nil {
+		t.Fatalf("expected an overflow instance, got %s", err)
+	}
+	if !inst.Overflow {
+		t.Error("expected the instance created over the max size to be an overflow instance")
+	}
+	if _, err = m.Provision(ctx, pool, "runner", env); !errors.Is(err, ErrorNoInstanceAvailable) {
+		t.Errorf("expected the overflow of the pool to be capped, got %v", err)
+	}
+
+	statuses, err := m.PoolsStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := statuses[0]; got.Busy != 2 || got.Overflow != 1 || got.Available != 0 {
+		t.Errorf("expected 2 busy instances with 1 overflow instance, got %d busy and %d overflow", got.Busy, got.Overflow)
+	}
+}
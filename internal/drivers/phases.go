@@ -0,0 +1,106 @@
+package drivers
+
+import (
+	"sort"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// Phases of the setup of a stage, see types.ProvisionTimings.
+const (
+	PhasePoolWait    = "pool_wait"
+	PhaseCreate      = "create"
+	PhaseStart       = "start"
+	PhaseHealthCheck = "health_check"
+	PhaseSetup       = "setup"
+	PhaseWorkspace   = "workspace"
+)
+
+// phaseOrder is the order of the phases in the statuses.
+var phaseOrder = []string{PhasePoolWait, PhaseCreate, PhaseStart, PhaseHealthCheck, PhaseSetup, PhaseWorkspace}
+
+// PhaseBuckets are the upper bounds of the buckets of the phase histograms, in seconds.
+var PhaseBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+// PhaseHistogram is the distribution of the durations of a setup phase of a pool since the
+// runner started. The buckets are cumulative, like the ones of Prometheus.
+type PhaseHistogram struct {
+	Phase      string   `json:"phase"`
+	Buckets    []uint64 `json:"buckets"` // the number of durations up to PhaseBuckets[i]
+	Count      uint64   `json:"count"`
+	SumSeconds float64  `json:"sum_seconds"`
+}
+
+func (h *PhaseHistogram) observe(d time.Duration) {
+	secs := d.Seconds()
+	for i, bound := range PhaseBuckets {
+		if secs <= bound {
+			h.Buckets[i]++
+		}
+	}
+	h.Count++
+	h.SumSeconds += secs
+}
+
+// RecordPhases adds the phase timings of a stage set up on the pool to its histograms. The create
+// and the start phases are only recorded if the instance went through them.
+func (m *Manager) RecordPhases(poolName string, t *types.ProvisionTimings) {
+	pool := m.getPool(poolName)
+	if pool == nil || t == nil {
+		return
+	}
+	durations := map[string]int64{
+		PhasePoolWait:    t.PoolWaitMs,
+		PhaseHealthCheck: t.HealthCheckMs,
+		PhaseSetup:       t.SetupMs,
+	}
+	if t.CreateMs > 0 {
+		durations[PhaseCreate] = t.CreateMs
+	}
+	if t.StartMs > 0 {
+		durations[PhaseStart] = t.StartMs
+	}
+	if t.WorkspaceMs > 0 {
+		durations[PhaseWorkspace] = t.WorkspaceMs
+	}
+
+	s := &pool.stats
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.phases == nil {
+		s.phases = map[string]*PhaseHistogram{}
+	}
+	for phase, ms := range durations {
+		h, ok := s.phases[phase]
+		if !ok {
+			h = &PhaseHistogram{Phase: phase, Buckets: make([]uint64, len(PhaseBuckets))}
+			s.phases[phase] = h
+		}
+		h.observe(time.Duration(ms) * time.Millisecond)
+	}
+}
+
+// phaseHistograms returns a copy of the histograms of the phases recorded, in the order of the phases.
+func (s *poolStats) phaseHistograms() []PhaseHistogram {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]PhaseHistogram, 0, len(s.phases))
+	for _, h := range s.phases {
+		c := *h
+		c.Buckets = append([]uint64(nil), h.Buckets...)
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return phaseIndex(out[i].Phase) < phaseIndex(out[j].Phase) })
+	return out
+}
+
+func phaseIndex(phase string) int {
+	for i, p := range phaseOrder {
+		if p == phase {
+			return i
+		}
+	}
+	return len(phaseOrder)
+}
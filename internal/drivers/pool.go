@@ -3,12 +3,21 @@ package drivers
 import (
 	"context"
 	"errors"
+	"net"
+	"time"
 
+	"github.com/drone-runners/drone-runner-aws/internal/certs"
+	"github.com/drone-runners/drone-runner-aws/internal/lehelper"
 	"github.com/drone-runners/drone-runner-aws/types"
 )
 
 var ErrorNoInstanceAvailable = errors.New("no free instances available")
 var ErrHostIsNotRunning = errors.New("host is not running")
+var ErrPoolDraining = errors.New("pool is draining")
+
+// ErrNotSupported is returned by the drivers for the operations they don't support, the manager
+// skips the operations the capabilities of a driver rule out.
+var ErrNotSupported = errors.New("not supported by the driver")
 
 type Pool struct {
 	RunnerName string
@@ -16,9 +25,80 @@ type Pool struct {
 	// GetMaxSize and GetMinSize should be used for managing pool size: Number of VM instances available in the pool.
 	MaxSize int
 	MinSize int
+	// Overflow is the number of temporary instances created over MaxSize when the pool is full.
+	// They are destroyed after use and never kept free.
+	Overflow int
 
 	Platform types.Platform
 
+	// StartupScript is the provider of the script bootstrapping the lite-engine on the instances.
+	StartupScript string
+	// Slim only installs the lite-engine and its certificates, the image provides the rest.
+	Slim bool
+	// Tuning holds the kernel settings applied by the startup script of the linux instances.
+	Tuning types.Tuning
+	// Checksums verify the binaries downloaded by the startup script.
+	Checksums types.Checksums
+	// WindowsContainers sets up the windows instances for the Windows containers.
+	WindowsContainers types.WindowsContainers
+	// LiteEngineEnv and LiteEngineFeatures are added to the environment of the lite-engine by the startup script.
+	LiteEngineEnv      map[string]string
+	LiteEngineFeatures []string
+	// LeaseTokens gives every instance a lease key its lite-engine verifies the calls of the runner with.
+	LeaseTokens bool
+	// Sizes are the machine types of the resource classes the instances of the pool are resized to in place,
+	// see Manager.Resize. The lite-engine of the instances of the pools with sizes comes back on every boot.
+	Sizes map[string]string
+
+	// Bootstrapper runs the startup script over ssh on instances which can't run user data, nil if not used.
+	Bootstrapper *lehelper.SSHBootstrapper
+
+	// Regions of a multi-region pool. Driver is used for instances not placed in any of the regions.
+	Regions []Region
+
+	// Untrusted is the hardening profile for instances running untrusted builds.
+	Untrusted types.UntrustedProfile
+
+	// LiteEnginePort is the port the lite-engine of the instances listens on, the default port if zero.
+	LiteEnginePort int
+	// CA signs the certificates of the lite-engines, a CA is generated for every instance if nil.
+	CA *certs.CA
+	// Tunnel makes the instances dial the tunnel server of the runner, the lite-engine is reached
+	// through the tunnel instead of the address of the instance.
+	Tunnel bool
+	// Routes are the ways the runner tries to reach the lite-engine of a new instance, in order, the route
+	// found is recorded on the instance. The address set by the driver is used if empty.
+	Routes []types.Route
+	// KeyDelivery is the way the instances fetch their TLS key, the key is in the user data if empty.
+	KeyDelivery string
+	// Preflight creates a test instance before the pool is built and fails if the runner can't reach its lite-engine.
+	Preflight bool
+	// NameTemplate renders the names of the instances, nil if the driver picks the names.
+	NameTemplate *NameTemplate
+	// Rollout is the policy of the rollout of a new image of the pool.
+	Rollout types.RolloutPolicy
+	// Schedule puts the pool to sleep during its off-hours, nil if the pool is always up.
+	Schedule *Schedule
+	// StepTimeout is the timeout of the steps without a timeout of their own, none if zero.
+	StepTimeout time.Duration
+	// Timeouts bound the creation, the destroy, the start and the hibernation of the instances by the driver.
+	Timeouts types.Timeouts
+	// DestroyGracePeriod delays the destroy of the instances of the completed stages, so the
+	// uploads running on them complete, see Manager.Finalize. None if zero.
+	DestroyGracePeriod time.Duration
+	// FinalizeScript runs on the instances of the completed stages before they are destroyed, the
+	// destroy waits until it exits, at most the destroy grace period.
+	FinalizeScript string
+	// DestroyHooks run on the instances of the completed stages right before they are destroyed, after
+	// the destroy grace period, see Manager.RunDestroyHooks.
+	DestroyHooks []DestroyHook
+	// Services are the service containers started on the instances for every stage.
+	Services []types.PoolService
+	// WorkspaceSnapshot is extracted into the workspace for every stage, if set.
+	WorkspaceSnapshot types.WorkspaceSnapshot
+	// Credentials are the short-lived cloud credentials passed to the steps of the stages.
+	Credentials types.PoolCredentials
+
 	Driver Driver
 }
 
@@ -36,3 +116,122 @@ type Driver interface {
 	DriverName() string
 	CanHibernate() bool
 }
+
+// UntrustedCapable is implemented by the drivers which can harden instances with the untrusted profile of the pool.
+type UntrustedCapable interface {
+	CanRunUntrusted() bool
+}
+
+// Imager is implemented by the drivers creating the instances from an image, InstanceType returns
+// the image. A change of the image of a pool is rolled out gradually if the pool has a rollout policy.
+type Imager interface {
+	InstanceType() string
+}
+
+// ImageResolver is implemented by the drivers selecting the image of the instances by a filter,
+// e.g. the latest AMI matching a name pattern or the latest image of a family. ResolveImage looks
+// the image up again, the new instances are created from it and record it.
+type ImageResolver interface {
+	ResolveImage(ctx context.Context) (image string, err error)
+}
+
+// Resizer is implemented by the drivers which can change the machine type of an instance in place,
+// stopping the instance, changing its type and starting it again. It returns the address of the
+// instance once started, the lite-engine of the instance must come back on boot.
+type Resizer interface {
+	Resize(ctx context.Context, instance *types.Instance, size string) (ipAddress string, err error)
+}
+
+// AddressResolver is implemented by the drivers which can look up the current addresses of an
+// instance. The address of an instance might change when it is started again after a hibernation,
+// e.g. the public address of an instance without a static address, and might be assigned late.
+type AddressResolver interface {
+	ResolveAddress(ctx context.Context, instance *types.Instance) (address, privateAddress string, err error)
+}
+
+// Claimer is implemented by the drivers handing out pre-existing machines instead of creating them. The
+// instances in the store are the claims of the machines, the manager restores the claims of the driver
+// from them, so the claims survive restarts and are shared by the runners using the same store.
+type Claimer interface {
+	RestoreClaims(instances []*types.Instance)
+}
+
+// CapacityReporter is implemented by the drivers scheduling the instances on a fleet of nodes, it reports
+// the resources of the nodes so the operators can size the fleet.
+type CapacityReporter interface {
+	Capacity(ctx context.Context) ([]NodeCapacity, error)
+}
+
+// NetworkDiagnoser is implemented by the drivers which can explain why the lite-engine port of an
+// instance is not reachable from the source address of the runner, e.g. by the security groups,
+// the route tables and the network ACLs of the instance. source is nil if the runner can't tell
+// the address the instance sees its connections from.
+type NetworkDiagnoser interface {
+	DiagnoseNetwork(ctx context.Context, instance *types.Instance, source net.IP) (findings []string, err error)
+}
+
+// HealthChecker is implemented by the drivers with a cheap check of the connectivity to their
+// provider which is more telling than Ping, e.g. a query of the leader of the Nomad servers or a
+// refresh of the token of the GCP credentials.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// ProfileChecker is implemented by the drivers attaching a cloud identity to the instances, e.g.
+// an instance profile or a service account. The identity is checked on the startup, a missing
+// identity would fail the creation of every instance of the pool.
+type ProfileChecker interface {
+	CheckProfile(ctx context.Context) error
+}
+
+// Janitor is implemented by the drivers which can leave hosts or artifacts of the destroyed instances behind.
+type Janitor interface {
+	// CleanupHosts removes the hosts and the leftovers of the instances of the pool which are not
+	// in the list of live instances.
+	CleanupHosts(ctx context.Context, runnerName, poolName string, live []*types.Instance) error
+}
+
+// Inventory is implemented by the drivers which can list the instances of a pool in the cloud account, by
+// the tags of the runner and the pool, so the instances are registered again after the loss of the
+// store, see Manager.Import.
+type Inventory interface {
+	// Inventory returns the instances of the pool of opts created by the runner of opts, with the
+	// settings of opts. The stopped or suspended instances are hibernated, the terminated instances
+	// are left out. The certificates of the instances are not set.
+	Inventory(ctx context.Context, opts *types.InstanceCreateOpts) ([]*types.Instance, error)
+}
+
+// MaintenanceWatcher is implemented by the drivers whose provider announces the maintenance of the
+// hosts and the interruptions of the instances ahead of time, e.g. the scheduled events of EC2, the
+// host maintenance of GCP or the interruptions of the spot instances.
+type MaintenanceWatcher interface {
+	// ScheduledEvents returns the upcoming or ongoing events of the instances, the instances without
+	// an event are left out.
+	ScheduledEvents(ctx context.Context, instances []*types.Instance) ([]ScheduledEvent, error)
+}
+
+// ImagePrefetcher is implemented by the drivers whose hosts import the images of the instances,
+// e.g. the ignite images on the Nomad nodes, so the first instance on a fresh host does not wait
+// for the import.
+type ImagePrefetcher interface {
+	// PrefetchImage imports the image of the pool on the hosts which do not have it yet.
+	PrefetchImage(ctx context.Context) error
+}
+
+// JanitorGracePeriod is the minimum age of a host before a janitor removes it, so the hosts of
+// instances still being created are not mistaken for leaked hosts.
+const JanitorGracePeriod = 15 * time.Minute
+
+// IsLeaked returns true if the host is not one of the live instances and is older than the grace period.
+func IsLeaked(id string, created time.Time, live map[string]bool) bool {
+	return !live[id] && time.Since(created) > JanitorGracePeriod
+}
+
+// LiveSet returns the set of the IDs of the instances.
+func LiveSet(live []*types.Instance) map[string]bool {
+	set := make(map[string]bool, len(live))
+	for _, inst := range live {
+		set[inst.ID] = true
+	}
+	return set
+}
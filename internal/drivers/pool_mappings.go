@@ -0,0 +1,213 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	itypes "github.com/drone-runners/drone-runner-aws/internal/types"
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errNoPoolMappingStore is returned when the pool mappings are changed without a store, e.g. with
+// the single instance store.
+var errNoPoolMappingStore = itypes.NewBadRequestError("the pool mappings can not be changed without a database")
+
+// poolMappings caches the pools the stages of the accounts are mapped to: the static mappings of
+// the environment and the ones of the store, which take precedence.
+type poolMappings struct {
+	mu      sync.RWMutex
+	static  map[string]map[string]string // the target pool by account and pool
+	dynamic map[string]map[string]string
+	store   store.PoolMappingStore
+}
+
+func staticPoolMappings(byAccount config.PoolMapperByAccount) map[string]map[string]string {
+	static := make(map[string]map[string]string, len(byAccount))
+	for accountID, pools := range byAccount {
+		static[accountID] = pools
+	}
+	return static
+}
+
+// SetPoolMappingStore sets the store of the pool mappings changed at runtime, see PutPoolMapping.
+func (m *Manager) SetPoolMappingStore(s store.PoolMappingStore) {
+	m.mappings.mu.Lock()
+	defer m.mappings.mu.Unlock()
+	m.mappings.store = s
+}
+
+// MapPool returns the pool the stages of the account for the pool are mapped to, the pool itself
+// if it is not mapped.
+func (m *Manager) MapPool(accountID, poolName string) string {
+	if accountID == "" {
+		return poolName
+	}
+	m.mappings.mu.RLock()
+	target, ok := m.mappings.dynamic[accountID][poolName]
+	if !ok {
+		target, ok = m.mappings.static[accountID][poolName]
+	}
+	m.mappings.mu.RUnlock()
+	if !ok {
+		return poolName
+	}
+
+	logrus.WithField("account_id", accountID).
+		WithField("old_pool", poolName).
+		WithField("updated_pool", target).
+		Info("Updated the pool")
+	return target
+}
+
+// StaticPoolMappings returns the pool mappings of the environment, they can not be changed at runtime.
+func (m *Manager) StaticPoolMappings() []*types.PoolMapping {
+	m.mappings.mu.RLock()
+	defer m.mappings.mu.RUnlock()
+	out := []*types.PoolMapping{}
+	for accountID, pools := range m.mappings.static {
+		for poolName, target := range pools {
+			out = append(out, &types.PoolMapping{AccountID: accountID, PoolName: poolName, TargetPool: target})
+		}
+	}
+	return out
+}
+
+// PoolMappings returns the pool mappings of the store.
+func (m *Manager) PoolMappings(ctx context.Context) ([]*types.PoolMapping, error) {
+	s := m.poolMappingStore()
+	if s == nil {
+		return []*types.PoolMapping{}, nil
+	}
+	return s.List(ctx)
+}
+
+// PutPoolMapping maps the stages of the account for the pool to the target pool. The mapping is
+// stored, so the other replicas pick it up on their next reload, and overrides the static mapping
+// of the pool of the account.
+func (m *Manager) PutPoolMapping(ctx context.Context, accountID, poolName, targetPool string) (*types.PoolMapping, error) {
+	s := m.poolMappingStore()
+	if s == nil {
+		return nil, errNoPoolMappingStore
+	}
+	if accountID == "" || poolName == "" {
+		return nil, itypes.NewBadRequestError("the account and the pool of the mapping are required")
+	}
+	if !m.Exists(targetPool) {
+		return nil, itypes.NewBadRequestError(fmt.Sprintf("the target pool %q does not exist", targetPool))
+	}
+
+	mapping := &types.PoolMapping{
+		AccountID:  accountID,
+		PoolName:   poolName,
+		TargetPool: targetPool,
+		Updated:    time.Now().Unix(),
+	}
+	var err error
+	if _, findErr := s.Find(ctx, accountID, poolName); findErr != nil {
+		err = s.Create(ctx, mapping)
+	} else {
+		err = s.Update(ctx, mapping)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pool mapping: failed to store the mapping of the pool %s of the account %s: %w", poolName, accountID, err)
+	}
+
+	m.mappings.mu.Lock()
+	if m.mappings.dynamic == nil {
+		m.mappings.dynamic = map[string]map[string]string{}
+	}
+	if m.mappings.dynamic[accountID] == nil {
+		m.mappings.dynamic[accountID] = map[string]string{}
+	}
+	m.mappings.dynamic[accountID][poolName] = targetPool
+	m.mappings.mu.Unlock()
+	return mapping, nil
+}
+
+// DeletePoolMapping removes the stored mapping of the pool of the account, the static mapping of
+// the pool applies again if any.
+func (m *Manager) DeletePoolMapping(ctx context.Context, accountID, poolName string) error {
+	s := m.poolMappingStore()
+	if s == nil {
+		return errNoPoolMappingStore
+	}
+	if err := s.Delete(ctx, accountID, poolName); err != nil {
+		return fmt.Errorf("pool mapping: failed to delete the mapping of the pool %s of the account %s: %w", poolName, accountID, err)
+	}
+
+	m.mappings.mu.Lock()
+	delete(m.mappings.dynamic[accountID], poolName)
+	m.mappings.mu.Unlock()
+	return nil
+}
+
+// StartPoolMappingReloader loads the pool mappings of the store and reloads them periodically, so
+// the mappings changed on another replica apply without a redeploy. It runs on every replica.
+func (m *Manager) StartPoolMappingReloader(ctx context.Context, interval time.Duration) {
+	if m.poolMappingStore() == nil {
+		return
+	}
+	if err := m.reloadPoolMappings(ctx); err != nil {
+		logrus.WithError(err).Errorln("pool mapping: failed to load the pool mappings")
+	}
+	if interval <= 0 {
+		return
+	}
+
+	logrus.Infof("Pool mapping reloader started. It will run every %.2f minutes", interval.Minutes())
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							logrus.Errorf("PANIC %v\n%s", r, debug.Stack())
+						}
+					}()
+					if err := m.reloadPoolMappings(ctx); err != nil {
+						logrus.WithError(err).Errorln("pool mapping: failed to reload the pool mappings, keeping the previous ones")
+					}
+				}()
+			}
+		}
+	}()
+}
+
+// reloadPoolMappings replaces the cached mappings of the store.
+func (m *Manager) reloadPoolMappings(ctx context.Context) error {
+	list, err := m.poolMappingStore().List(ctx)
+	if err != nil {
+		return err
+	}
+	dynamic := map[string]map[string]string{}
+	for _, mapping := range list {
+		if dynamic[mapping.AccountID] == nil {
+			dynamic[mapping.AccountID] = map[string]string{}
+		}
+		dynamic[mapping.AccountID][mapping.PoolName] = mapping.TargetPool
+	}
+
+	m.mappings.mu.Lock()
+	m.mappings.dynamic = dynamic
+	m.mappings.mu.Unlock()
+	return nil
+}
+
+func (m *Manager) poolMappingStore() store.PoolMappingStore {
+	m.mappings.mu.RLock()
+	defer m.mappings.mu.RUnlock()
+	return m.mappings.store
+}
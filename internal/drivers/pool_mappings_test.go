@@ -0,0 +1,87 @@
+package drivers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestPoolMappings(t *testing.T) {
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	env := &config.EnvConfig{}
+	env.Dlite.PoolMapByAccount = config.PoolMapperByAccount{"acct": {"linux": "linux-static"}}
+	mappingStore := ldb.NewPoolMappingStore(db)
+
+	// two replicas sharing the store
+	m := New(ctx, ldb.NewInstanceStore(db), env)
+	m.SetPoolMappingStore(mappingStore)
+	replica := New(ctx, ldb.NewInstanceStore(db), env)
+	replica.SetPoolMappingStore(mappingStore)
+	for _, name := range []string{"linux", "linux-static", "linux-dedicated"} {
+		if err = m.Add(Pool{Name: name, Driver: failingDriver{}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := m.MapPool("acct", "linux"); got != "linux-static" {
+		t.Errorf("expected the static mapping, got %q", got)
+	}
+	if got := m.MapPool("other", "linux"); got != "linux" {
+		t.Errorf("expected the pool of an account without mapping, got %q", got)
+	}
+	if got := m.MapPool("", "linux"); got != "linux" {
+		t.Errorf("expected the pool without an account, got %q", got)
+	}
+
+	if _, err = m.PutPoolMapping(ctx, "acct", "linux", "unknown"); err == nil {
+		t.Error("expected an unknown target pool to be rejected")
+	}
+	if _, err = m.PutPoolMapping(ctx, "acct", "linux", "linux-dedicated"); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.MapPool("acct", "linux"); got != "linux-dedicated" {
+		t.Errorf("expected the stored mapping to override the static one, got %q", got)
+	}
+	if got := replica.MapPool("acct", "linux"); got != "linux-static" {
+		t.Errorf("expected the replica to keep its mappings until it reloads, got %q", got)
+	}
+	if err = replica.reloadPoolMappings(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := replica.MapPool("acct", "linux"); got != "linux-dedicated" {
+		t.Errorf("expected the replica to apply the stored mapping, got %q", got)
+	}
+
+	// updating the mapping
+	if _, err = m.PutPoolMapping(ctx, "acct", "linux", "linux"); err != nil {
+		t.Fatal(err)
+	}
+	mappings, err := m.PoolMappings(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mappings) != 1 || mappings[0].TargetPool != "linux" {
+		t.Errorf("expected the updated mapping, got %+v", mappings)
+	}
+
+	if err = m.DeletePoolMapping(ctx, "acct", "linux"); err != nil {
+		t.Fatal(err)
+	}
+	if err = replica.reloadPoolMappings(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, manager := range []*Manager{m, replica} {
+		if got := manager.MapPool("acct", "linux"); got != "linux-static" {
+			t.Errorf("expected the static mapping once the stored one is deleted, got %q", got)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package drivers
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// StartImagePrefetcher asks the drivers implementing ImagePrefetcher to import the images of their
+// pools on their hosts right away and then periodically, so the hosts joining later get them too.
+func (m *Manager) StartImagePrefetcher(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	logrus.Infof("Image prefetcher started. It will run every %.2f minutes", interval.Minutes())
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if m.IsLeader() {
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							logrus.Errorf("PANIC %v\n%s", r, debug.Stack())
+						}
+					}()
+					m.prefetchImages(ctx)
+				}()
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// prefetchImages imports the images of the pools one pool at a time, a failed pool does not stop
+// the others.
+func (m *Manager) prefetchImages(ctx context.Context) {
+	for _, pool := range m.pools() {
+		prefetcher, ok := pool.Driver.(ImagePrefetcher)
+		if !ok {
+			continue
+		}
+		logr := logrus.WithField("pool", pool.Name).WithField("driver", pool.Driver.DriverName())
+		start := time.Now()
+		if err := prefetcher.PrefetchImage(ctx); err != nil {
+			logr.WithError(err).Errorln("prefetch: failed to import the image on the hosts")
+			continue
+		}
+		logr.WithField("duration", time.Since(start)).Debugln("prefetch: imported the image on the hosts")
+	}
+}
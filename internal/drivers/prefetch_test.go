@@ -0,0 +1,42 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+)
+
+// prefetchingDriver counts the prefetches of its image, failing them if err is set.
+type prefetchingDriver struct {
+	failingDriver
+	prefetched *int
+	err        error
+}
+
+func (d prefetchingDriver) PrefetchImage(context.Context) error {
+	*d.prefetched++
+	return d.err
+}
+
+func TestPrefetchImages(t *testing.T) {
+	ctx := context.Background()
+	m := New(ctx, nil, &config.EnvConfig{})
+	var failed, prefetched int
+	pools := []Pool{
+		{Name: "failing", Driver: prefetchingDriver{prefetched: &failed, err: errors.New("no nodes")}},
+		{Name: "other", Driver: failingDriver{}},
+		{Name: "nomad", Driver: prefetchingDriver{prefetched: &prefetched}},
+	}
+	for i := range pools {
+		if err := m.Add(pools[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m.prefetchImages(ctx)
+	if failed != 1 || prefetched != 1 {
+		t.Errorf("expected every prefetching pool to prefetch once despite the failure, got %d and %d", failed, prefetched)
+	}
+}
@@ -0,0 +1,99 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/lehelper"
+	"github.com/drone-runners/drone-runner-aws/internal/route"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/drone/runner-go/logger"
+)
+
+// preflightTimeout is how long the lite-engine of the test instance has to come up.
+const preflightTimeout = 10 * time.Minute
+
+// Preflight creates a test instance in every pool with the preflight enabled and checks the runner
+// reaches its lite-engine, so a networking issue fails the start of the runner instead of every
+// build. The test instance is destroyed afterwards.
+func (m *Manager) Preflight(ctx context.Context) error {
+	for _, pool := range m.pools() {
+		if !pool.Preflight {
+			continue
+		}
+		if err := m.preflight(ctx, pool); err != nil {
+			return fmt.Errorf("preflight of %q pool failed: %w", pool.Name, err)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) preflight(ctx context.Context, pool *poolEntry) error {
+	logr := logger.FromContext(ctx).WithField("pool", pool.Name)
+	logr.Infoln("manager: preflight: creating a test instance")
+
+	inst, err := m.setupInstance(ctx, pool, setupOpts{inuse: true})
+	if err != nil {
+		return fmt.Errorf("could not create the test instance: %w", err)
+	}
+	defer func() {
+		if destroyErr := m.destroyOrRetry(context.Background(), pool, []*types.Instance{inst}, true); destroyErr != nil {
+			logr.WithError(destroyErr).
+				WithField("instance", inst.ID).
+				Errorln("manager: preflight: failed to destroy the test instance")
+		}
+	}()
+
+	port := liteEnginePort(&pool.Pool)
+	client, err := lehelper.GetClient(inst, m.runnerName, int64(port), false, 0)
+	if err != nil {
+		return err
+	}
+	healthCtx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+	if _, err = client.RetryHealth(healthCtx, preflightTimeout); err == nil {
+		logr.WithField("instance", inst.ID).Infoln("manager: preflight: the lite-engine of the test instance is reachable")
+		return nil
+	}
+
+	err = fmt.Errorf("the lite-engine of the test instance %s is not reachable on %s: %w",
+		inst.ID, net.JoinHostPort(route.Address(inst), strconv.Itoa(port)), err)
+	driver, driverErr := driverFor(pool, inst)
+	diagnoser, ok := driver.(NetworkDiagnoser)
+	if driverErr != nil || !ok || inst.Tunnel || (inst.Route != "" && !inst.Route.Direct()) {
+		return err
+	}
+	findings, diagnoseErr := diagnoser.DiagnoseNetwork(ctx, inst, sourceAddress(route.Address(inst), port))
+	if diagnoseErr != nil {
+		logr.WithError(diagnoseErr).Warnln("manager: preflight: could not diagnose the network of the test instance")
+		return err
+	}
+	if len(findings) == 0 {
+		return fmt.Errorf("%w, the network allows the traffic, check the lite-engine logs of the instance", err)
+	}
+	return fmt.Errorf("%w:\n- %s", err, strings.Join(findings, "\n- "))
+}
+
+// sourceAddress returns the address the runner connects to the instance from, nil if the runner is
+// behind a NAT for the instance and the instance sees another address.
+func sourceAddress(address string, port int) net.IP {
+	target := net.ParseIP(address)
+	if target == nil {
+		return nil
+	}
+	// no packet is sent, dialing udp only picks the local address of the route to the instance.
+	conn, err := net.Dial("udp", net.JoinHostPort(address, strconv.Itoa(port)))
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	local := conn.LocalAddr().(*net.UDPAddr).IP
+	if local.IsPrivate() && !target.IsPrivate() {
+		return nil
+	}
+	return local
+}
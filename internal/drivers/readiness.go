@@ -0,0 +1,57 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// readinessTTL is how long the result of a readiness check is reused, so the frequent probes of
+// the load balancers do not hit the database and the APIs of the cloud providers every time.
+const readinessTTL = 30 * time.Second
+
+// readiness caches the result of the last readiness check.
+type readiness struct {
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+// Ready checks the connection to the database and to the drivers of all the pools and of their
+// regions, and the warm-up of the pools if the readiness waits for it. The result is cached for a
+// while.
+func (m *Manager) Ready(ctx context.Context) error {
+	m.readiness.mu.Lock()
+	defer m.readiness.mu.Unlock()
+
+	if time.Since(m.readiness.checked) < readinessTTL {
+		return m.readiness.err
+	}
+	m.readiness.err = m.checkReady(ctx)
+	m.readiness.checked = time.Now()
+	return m.readiness.err
+}
+
+func (m *Manager) checkReady(ctx context.Context) error {
+	if m.instanceStore == nil {
+		return fmt.Errorf("database: the store is not set up")
+	}
+	if err := m.instanceStore.Ping(ctx); err != nil {
+		return fmt.Errorf("database: %w", err)
+	}
+	for _, pool := range m.pools() {
+		if err := pool.Driver.Ping(ctx); err != nil {
+			return fmt.Errorf("pool %q: %w", pool.Name, err)
+		}
+		for _, r := range pool.Regions {
+			if err := r.Driver.Ping(ctx); err != nil {
+				return fmt.Errorf("pool %q, region %q: %w", pool.Name, r.Name, err)
+			}
+		}
+	}
+	if m.warmUpGate {
+		return m.checkWarmUp(ctx)
+	}
+	return nil
+}
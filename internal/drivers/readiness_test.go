@@ -0,0 +1,62 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// unreachableDriver fails to connect to its provider.
+type unreachableDriver struct{ failingDriver }
+
+func (unreachableDriver) Ping(context.Context) error { return errors.New("connection refused") }
+
+func TestReady(t *testing.T) {
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	m := New(ctx, ldb.NewInstanceStore(db), &config.EnvConfig{})
+	if err = m.Add(Pool{Name: "linux", MaxSize: 1, Driver: failingDriver{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Ready(ctx); err != nil {
+		t.Fatalf("expected the manager to be ready, got %s", err)
+	}
+
+	// the result of the check is reused until it expires
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Ready(ctx); err != nil {
+		t.Errorf("expected the cached result, got %s", err)
+	}
+	m.readiness.checked = time.Time{}
+	if err = m.Ready(ctx); err == nil {
+		t.Error("expected the manager not to be ready once the database is closed")
+	}
+}
+
+func TestReadyUnreachableDriver(t *testing.T) {
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	m := New(ctx, ldb.NewInstanceStore(db), &config.EnvConfig{})
+	if err = m.Add(Pool{Name: "linux", MaxSize: 1, Driver: unreachableDriver{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Ready(ctx); err == nil {
+		t.Error("expected the manager not to be ready while a driver is unreachable")
+	}
+}
@@ -0,0 +1,139 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// Region is a region of a multi-region pool. Instances placed in the region are managed by a driver of its own.
+type Region struct {
+	Name string
+	// Zone is set if the driver places all the instances of the region in a single zone.
+	Zone   string
+	Driver Driver
+}
+
+// placement identifies where the region driver places the instances.
+func (r *Region) placement() string {
+	if r.Zone == "" {
+		return r.Name
+	}
+	return r.Name + "/" + r.Zone
+}
+
+// latencyWeight is the weight of the latest sample in the moving average of the create latency of a region.
+const latencyWeight = 0.3
+
+type regionHintKey struct{}
+
+// WithRegionHint returns a context carrying the region closest to the caller. Multi-region
+// pools prefer the region when placing a new instance.
+func WithRegionHint(ctx context.Context, region string) context.Context {
+	return context.WithValue(ctx, regionHintKey{}, region)
+}
+
+func regionHint(ctx context.Context) string {
+	region, _ := ctx.Value(regionHintKey{}).(string)
+	return region
+}
+
+// regionSelector keeps track of the health of the regions of a pool.
+type regionSelector struct {
+	sync.Mutex
+	latency   map[string]time.Duration // moving average of the instance create latency
+	blacklist Blacklist
+}
+
+// candidates returns the regions in order of preference. The region closest to the caller goes first
+// and the rest are ordered by latency. Regions blacklisted after capacity failures go last.
+func (s *regionSelector) candidates(regions []Region, hint string) []Region {
+	s.Lock()
+	defer s.Unlock()
+
+	rank := func(r Region) (blacklisted bool, latency time.Duration) {
+		return s.blacklist.Blacklisted(r.placement()), s.latency[r.placement()]
+	}
+
+	candidates := make([]Region, len(regions))
+	copy(candidates, regions)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		iBlacklisted, iLatency := rank(candidates[i])
+		jBlacklisted, jLatency := rank(candidates[j])
+		if iBlacklisted != jBlacklisted {
+			return !iBlacklisted
+		}
+		iHint := strings.EqualFold(candidates[i].Name, hint)
+		jHint := strings.EqualFold(candidates[j].Name, hint)
+		if iHint != jHint {
+			return iHint
+		}
+		return iLatency < jLatency
+	})
+	return candidates
+}
+
+// succeeded records the latency of a successful instance creation in the region.
+func (s *regionSelector) succeeded(r *Region, latency time.Duration) {
+	region := r.placement()
+	s.blacklist.Succeeded(region)
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.latency == nil {
+		s.latency = make(map[string]time.Duration)
+	}
+	if prev, ok := s.latency[region]; ok {
+		latency = time.Duration(latencyWeight*float64(latency) + (1-latencyWeight)*float64(prev))
+	}
+	s.latency[region] = latency
+}
+
+// failed blacklists the region after repeated failures caused by the region being out of capacity.
+func (s *regionSelector) failed(r *Region, err error) {
+	s.blacklist.Failed(r.placement(), err)
+}
+
+// driverFor returns the driver managing the instance. Instances created before the pool had regions
+// have no region set, they are matched by their zone.
+func driverFor(pool *poolEntry, inst *types.Instance) (Driver, error) {
+	region := regionFor(pool.Regions, inst)
+	if region == nil {
+		if len(pool.Regions) == 0 {
+			return pool.Driver, nil
+		}
+		return nil, fmt.Errorf("no region of pool %q manages instance %s in region %q zone %q",
+			pool.Name, inst.ID, inst.Region, inst.Zone)
+	}
+	return region.Driver, nil
+}
+
+// regionFor returns the region of the instance, nil if none of the regions matches.
+func regionFor(regions []Region, inst *types.Instance) *Region {
+	for i := range regions {
+		if inst.Region != "" && regions[i].Name == inst.Region && (regions[i].Zone == "" || regions[i].Zone == inst.Zone) {
+			return &regions[i]
+		}
+	}
+	if inst.Zone == "" {
+		return nil
+	}
+	for i := range regions {
+		if regions[i].Zone != "" && regions[i].Zone == inst.Zone {
+			return &regions[i]
+		}
+	}
+	// zones are named after their region, e.g. us-east-2c or us-central1-a
+	for i := range regions {
+		if regions[i].Zone == "" && strings.HasPrefix(inst.Zone, regions[i].Name) {
+			return &regions[i]
+		}
+	}
+	return nil
+}
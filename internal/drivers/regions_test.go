@@ -0,0 +1,38 @@
+package drivers
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestRegionFor(t *testing.T) {
+	regions := []Region{
+		{Name: "us-east-2", Zone: "us-east-2c"},
+		{Name: "us-central1", Zone: "us-central1-a"},
+		{Name: "us-central1", Zone: "us-central1-b"},
+		{Name: "europe-west1"},
+	}
+
+	tests := []struct {
+		name string
+		inst types.Instance
+		want string // zone of the expected region, "-" for no region
+	}{
+		{name: "region and zone", inst: types.Instance{Region: "us-central1", Zone: "us-central1-b"}, want: "us-central1-b"},
+		{name: "legacy instance by zone", inst: types.Instance{Zone: "us-east-2c"}, want: "us-east-2c"},
+		{name: "legacy instance by region of the zone", inst: types.Instance{Zone: "europe-west1-d"}, want: ""},
+		{name: "unknown", inst: types.Instance{Zone: "asia-east1-a"}, want: "-"},
+		{name: "no placement", inst: types.Instance{}, want: "-"},
+	}
+	for _, test := range tests {
+		r := regionFor(regions, &test.inst)
+		got := "-"
+		if r != nil {
+			got = r.Zone
+		}
+		if got != test.want {
+			t.Errorf("%s: want region with zone %q, got %q", test.name, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/drone-runners/drone-runner-aws/internal/events"
+	itypes "github.com/drone-runners/drone-runner-aws/internal/types"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/drone/runner-go/logger"
+)
+
+// Resizable reports whether the instances of the pool can be resized in place to the machine type
+// of the resource class.
+func (m *Manager) Resizable(poolName, resourceClass string) bool {
+	pool := m.getPool(poolName)
+	if pool == nil || pool.Sizes[resourceClass] == "" {
+		return false
+	}
+	_, ok := pool.Driver.(Resizer)
+	return ok
+}
+
+// Resize changes the machine type of the instance in use to the one of the resource class in the
+// pool, e.g. for the retry of a stage killed for lack of memory. The instance keeps its disk and
+// stays in use, its address might change. If the driver fails to resize it the instance is destroyed,
+// it might be left stopped.
+func (m *Manager) Resize(ctx context.Context, poolName, instanceID, resourceClass string) (*types.Instance, error) {
+	pool := m.getPool(poolName)
+	if pool == nil {
+		return nil, fmt.Errorf("resize: pool name %q not found", poolName)
+	}
+	size := pool.Sizes[resourceClass]
+	if size == "" {
+		return nil, fmt.Errorf("resize: the pool %s has no size for the resource class %q: %w", poolName, resourceClass, ErrNotSupported)
+	}
+
+	inst, err := m.Find(ctx, instanceID)
+	if err != nil || !Owns(ctx, inst) {
+		return nil, itypes.NewNotFoundError(fmt.Sprintf("instance %s not found", instanceID))
+	}
+	if inst.State != types.StateInUse {
+		return nil, itypes.NewBadRequestError(fmt.Sprintf("instance %s is %s, only the instances in use are resized", instanceID, inst.State))
+	}
+	driver, err := driverFor(pool, inst)
+	if err != nil {
+		return nil, fmt.Errorf("resize: %w", err)
+	}
+	resizer, ok := driver.(Resizer)
+	if !ok {
+		return nil, fmt.Errorf("resize: the %s driver: %w", driver.DriverName(), ErrNotSupported)
+	}
+	if inst.Size == size {
+		return inst, nil
+	}
+
+	logr := logger.FromContext(ctx).
+		WithField("pool", poolName).
+		WithField("instance_id", inst.ID).
+		WithField("from", inst.Size).
+		WithField("to", size)
+	logr.Infoln("resize: resizing the instance")
+
+	resizeCtx, cancel := withTimeout(ctx, pool.Timeouts.CreateSecs)
+	ipAddress, err := resizer.Resize(resizeCtx, inst, size)
+	cancel()
+	if err != nil {
+		logr.WithError(err).Errorln("resize: failed to resize the instance, destroying it")
+		_ = m.destroyOrRetry(context.Background(), pool, []*types.Instance{inst}, true)
+		return nil, fmt.Errorf("resize: failed to resize the instance %s of %q pool: %w", instanceID, poolName, err)
+	}
+
+	inst.Size = size
+	inst.Address = ipAddress
+	if err = m.instanceStore.Update(ctx, inst); err != nil {
+		return nil, fmt.Errorf("resize: failed to update instance store %s of %q pool: %w", instanceID, poolName, err)
+	}
+	m.events.Publish(events.ForInstance(events.InstanceResized, inst))
+	return inst, nil
+}
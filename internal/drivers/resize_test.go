@@ -0,0 +1,90 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// resizingDriver resizes the instances, it fails to resize to the size "unavailable".
+type resizingDriver struct {
+	failingDriver
+	destroyed map[string]bool
+}
+
+func (d resizingDriver) Resize(_ context.Context, _ *types.Instance, size string) (string, error) {
+	if size == "unavailable" {
+		return "", errors.New("no capacity")
+	}
+	return "10.0.0.2", nil
+}
+
+func (d resizingDriver) Destroy(_ context.Context, instances []*types.Instance) error {
+	for _, inst := range instances {
+		d.destroyed[inst.ID] = true
+	}
+	return nil
+}
+
+func TestResize(t *testing.T) {
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	instanceStore := ldb.NewInstanceStore(db)
+	for _, inst := range []*types.Instance{
+		{ID: "inuse", Pool: "linux", State: types.StateInUse, Size: "small", Address: "10.0.0.1", AccountID: "a"},
+		{ID: "free", Pool: "linux", State: types.StateCreated, Size: "small"},
+		{ID: "stuck", Pool: "linux", State: types.StateInUse, Size: "small"},
+	} {
+		if err = instanceStore.Create(ctx, inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	driver := resizingDriver{destroyed: map[string]bool{}}
+	m := New(ctx, instanceStore, &config.EnvConfig{})
+	err = m.Add(
+		Pool{Name: "linux", Driver: driver, Sizes: map[string]string{"large": "big", "huge": "unavailable"}},
+		Pool{Name: "fixed", Driver: failingDriver{}, Sizes: map[string]string{"large": "big"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.Resizable("linux", "large") || m.Resizable("linux", "medium") || m.Resizable("fixed", "large") {
+		t.Error("expected only the pools with a resizing driver and a size of the resource class to be resizable")
+	}
+
+	if _, err = m.Resize(WithAccountID(ctx, "b"), "linux", "inuse", "large"); err == nil {
+		t.Error("expected the instance of another account not to be resized")
+	}
+	if _, err = m.Resize(ctx, "linux", "free", "large"); err == nil {
+		t.Error("expected the free instance not to be resized")
+	}
+	if _, err = m.Resize(ctx, "linux", "inuse", "medium"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("expected the resource class without a size not to be supported, got %v", err)
+	}
+
+	inst, err := m.Resize(WithAccountID(ctx, "a"), "linux", "inuse", "large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := instanceStore.Find(ctx, "inuse"); inst.Size != "big" || stored.Size != "big" || stored.Address != "10.0.0.2" || stored.State != types.StateInUse {
+		t.Errorf("expected the instance to be resized and kept in use, got %+v", stored)
+	}
+
+	if _, err = m.Resize(ctx, "linux", "stuck", "huge"); err == nil {
+		t.Error("expected the failed resize to be reported")
+	}
+	if _, findErr := instanceStore.Find(ctx, "stuck"); findErr == nil || !driver.destroyed["stuck"] {
+		t.Error("expected the instance failed to resize to be destroyed")
+	}
+}
@@ -0,0 +1,340 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/alert"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/sirupsen/logrus"
+)
+
+// defaults of the rollout policy.
+const (
+	defaultRolloutMinStages = 20
+	defaultRolloutDuration  = time.Hour
+)
+
+// rolloutCheckInterval is how often the failure rates of a rollout are compared.
+var rolloutCheckInterval = time.Minute
+
+// RolloutStatus describes the rollout of a new image of a pool.
+type RolloutStatus struct {
+	ShadowPool        string `json:"shadow_pool"`
+	Image             string `json:"image"`
+	Percent           int    `json:"percent"`
+	Started           int64  `json:"started"`
+	BaselineStages    int    `json:"baseline_stages"`
+	BaselineFailures  int    `json:"baseline_failures"`
+	CandidateStages   int    `json:"candidate_stages"`
+	CandidateFailures int    `json:"candidate_failures"`
+}
+
+// rollout sends a share of the new stages of a pool to a shadow pool with the new image, once a
+// canary instance of the shadow pool passed the validation of the pool. Once enough stages ran on the new image, or the rollout lasted long enough, the failure rates of the
+// pools are compared: the pool is cut over to the new image if the new image fails no more often
+// than allowed, otherwise the new image is rolled back. Either way the shadow pool is drained.
+type rollout struct {
+	shadow  string
+	image   string
+	policy  types.RolloutPolicy
+	started time.Time
+	routed  atomic.Int64 // stages of the pool routed so far
+	cancel  context.CancelFunc
+	// validated is set once the canary instance passed, the shadow pool gets no stages before.
+	validated atomic.Bool
+
+	mu        sync.Mutex
+	spec      Pool // the pool with the new image
+	baseline  rolloutOutcomes
+	candidate rolloutOutcomes
+}
+
+type rolloutOutcomes struct {
+	stages   int
+	failures int
+}
+
+func (o rolloutOutcomes) failureRate() float64 {
+	if o.stages == 0 {
+		return 0
+	}
+	return float64(o.failures) / float64(o.stages)
+}
+
+// imageOf returns the image the driver creates the instances from, empty if the driver has none.
+func imageOf(driver Driver) string {
+	if imager, ok := driver.(Imager); ok {
+		return imager.InstanceType()
+	}
+	return ""
+}
+
+// startRollout starts the rollout of the new image of the pool, if the pool has a rollout policy
+// and its image changed. It returns false if the pool should be updated in place instead.
+func (m *Manager) startRollout(entry *poolEntry, pool *Pool) (bool, error) {
+	newImage := imageOf(pool.Driver)
+	if current := entry.rollout.Load(); current != nil {
+		if newImage == current.image {
+			return true, m.updateRollout(current, pool)
+		}
+		m.endRollout(m.globalCtx, entry, current, false)
+	}
+
+	entry.Lock()
+	oldImage := imageOf(entry.Driver)
+	entry.Unlock()
+	if pool.Rollout.Percent <= 0 || newImage == "" || newImage == oldImage {
+		return false, nil
+	}
+
+	// the shadow pool of the previous rollout might still be draining.
+	shadow := fmt.Sprintf("%s-rollout-%s", pool.Name, strconv.FormatInt(time.Now().Unix(), 36)) //nolint:gomnd
+	if err := m.Add(shadowPool(pool, shadow)); err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithCancel(m.globalCtx)
+	r := &rollout{
+		shadow:  shadow,
+		image:   newImage,
+		policy:  pool.Rollout,
+		started: time.Now(),
+		cancel:  cancel,
+		spec:    *pool,
+	}
+	entry.rollout.Store(r)
+	logrus.WithField("pool", pool.Name).
+		WithField("shadow_pool", shadow).
+		WithField("image", newImage).
+		WithField("old_image", oldImage).
+		WithField("percent", pool.Rollout.Percent).
+		Infoln("rollout: started the rollout of the new image")
+
+	go m.runRollout(ctx, entry, r)
+	return true, nil
+}
+
+// updateRollout applies the settings of the pool with the image being rolled out to the shadow pool.
+func (m *Manager) updateRollout(r *rollout, pool *Pool) error {
+	r.mu.Lock()
+	r.spec = *pool
+	r.mu.Unlock()
+	shadow := m.getPool(r.shadow)
+	if shadow == nil {
+		return fmt.Errorf("rollout: shadow pool %q not found", r.shadow)
+	}
+	shadow.Lock()
+	shadow.Pool = shadowPool(pool, r.shadow)
+	shadow.Unlock()
+	return nil
+}
+
+// shadowPool returns the shadow pool of the rollout, it keeps warm the share of the instances
+// of the pool it gets the stages of.
+func shadowPool(pool *Pool, name string) Pool {
+	shadow := *pool
+	shadow.Name = name
+	shadow.MinSize = (pool.MinSize*pool.Rollout.Percent + 99) / 100 //nolint:gomnd
+	return shadow
+}
+
+func (m *Manager) runRollout(ctx context.Context, entry *poolEntry, r *rollout) {
+	if !m.validateRollout(ctx, entry, r) {
+		return
+	}
+	if m.IsLeader() {
+		if err := m.BuildPool(ctx, r.shadow); err != nil {
+			logrus.WithError(err).WithField("pool", r.shadow).Errorln("rollout: unable to build the shadow pool")
+		}
+	}
+
+	ticker := time.NewTicker(rolloutCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if done, promote := r.evaluate(time.Now()); done {
+			m.endRollout(m.globalCtx, entry, r, promote)
+			return
+		}
+	}
+}
+
+// validateRollout runs the canary instance of the rollout. A failed validation blocks the rollout,
+// the new image is rolled back and the failure is alerted.
+func (m *Manager) validateRollout(ctx context.Context, entry *poolEntry, r *rollout) bool {
+	shadow := m.getPool(r.shadow)
+	if shadow == nil {
+		return false
+	}
+	r.mu.Lock()
+	script := r.spec.Rollout.CanaryScript
+	r.mu.Unlock()
+
+	err := m.runCanary(ctx, shadow, script)
+	if ctx.Err() != nil {
+		return false // the rollout was superseded
+	}
+	if err != nil {
+		logrus.WithError(err).
+			WithField("alert", true).
+			WithField("pool", entry.Name).
+			WithField("image", r.image).
+			Errorln("rollout: the canary instance failed the validation, the rollout of the new image is blocked")
+		m.alerter.Raise(alert.Alert{
+			Key:      "rollout:" + entry.Name,
+			Severity: alert.SeverityError,
+			Summary:  fmt.Sprintf("the rollout of image %s to pool %s is blocked by a failed canary", r.image, entry.Name),
+			Pool:     entry.Name,
+			Error:    err.Error(),
+		})
+		if observer, ok := m.observer.(RolloutObserver); ok {
+			observer.RolloutBlocked(entry.Name, err)
+		}
+		m.endRollout(m.globalCtx, entry, r, false)
+		return false
+	}
+
+	// the rollout lasts from the validation on.
+	r.mu.Lock()
+	r.started = time.Now()
+	r.mu.Unlock()
+	r.validated.Store(true)
+	return true
+}
+
+// evaluate returns true once the rollout has enough stages on the new image or lasted long
+// enough, promote is true if the new image fails no more often than allowed.
+func (r *rollout) evaluate(now time.Time) (done, promote bool) {
+	minStages := r.policy.MinStages
+	if minStages <= 0 {
+		minStages = defaultRolloutMinStages
+	}
+	duration := time.Minute * time.Duration(r.policy.DurationMins)
+	if duration <= 0 {
+		duration = defaultRolloutDuration
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.candidate.stages < minStages && now.Sub(r.started) < duration {
+		return false, false
+	}
+	return true, r.candidate.failureRate()-r.baseline.failureRate() <= r.policy.MaxFailureIncrease
+}
+
+// endRollout cuts the pool over to the new image or rolls the new image back, and drains the
+// shadow pool. The free instances of the old image are destroyed on cut over.
+func (m *Manager) endRollout(ctx context.Context, entry *poolEntry, r *rollout, promote bool) {
+	if !entry.rollout.CompareAndSwap(r, nil) {
+		return
+	}
+	r.cancel()
+
+	r.mu.Lock()
+	spec := r.spec
+	logr := logrus.WithField("pool", entry.Name).
+		WithField("image", r.image).
+		WithField("baseline_failure_rate", r.baseline.failureRate()).
+		WithField("candidate_failure_rate", r.candidate.failureRate()).
+		WithField("candidate_stages", r.candidate.stages)
+	r.mu.Unlock()
+
+	if promote {
+		entry.Lock()
+		entry.Pool = spec
+		entry.Unlock()
+		logr.Infoln("rollout: cut the pool over to the new image")
+		if m.IsLeader() {
+			go func() {
+				if err := m.CleanPool(ctx, spec.Name, false, true); err != nil {
+					logr.WithError(err).Errorln("rollout: unable to destroy the free instances of the old image")
+				}
+				if err := m.BuildPool(ctx, spec.Name); err != nil {
+					logr.WithError(err).Errorln("rollout: unable to build the pool")
+				}
+			}()
+		}
+	} else {
+		logr.Warnln("rollout: the new image fails more often than the old one, rolled back")
+	}
+
+	go func() {
+		if err := m.DeletePool(ctx, r.shadow, true, 0); err != nil {
+			logr.WithError(err).Errorln("rollout: unable to delete the shadow pool")
+		}
+	}()
+}
+
+// RolloutTarget returns the pool the next stage of the pool is set up on, the shadow pool for the
+// share of the stages sent to the new image during a rollout.
+func (m *Manager) RolloutTarget(poolName string) string {
+	entry := m.getPool(poolName)
+	if entry == nil {
+		return poolName
+	}
+	r := entry.rollout.Load()
+	if r == nil || !r.validated.Load() || !m.Exists(r.shadow) {
+		return poolName
+	}
+	// exactly the percentage of the stages, spread evenly
+	n := r.routed.Add(1)
+	percent := int64(r.policy.Percent)
+	if n*percent/100 > (n-1)*percent/100 { //nolint:gomnd
+		return r.shadow
+	}
+	return poolName
+}
+
+// recordRolloutOutcome counts a stage set up, or an instance which failed to be created, by a
+// pool being rolled out or by its shadow pool.
+func (m *Manager) recordRolloutOutcome(poolName string, failed bool) {
+	outcome := func(o *rolloutOutcomes) {
+		o.stages++
+		if failed {
+			o.failures++
+		}
+	}
+	for _, entry := range m.pools() {
+		r := entry.rollout.Load()
+		if r == nil {
+			continue
+		}
+		r.mu.Lock()
+		switch poolName {
+		case entry.Name:
+			outcome(&r.baseline)
+		case r.shadow:
+			outcome(&r.candidate)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// rolloutStatus returns the status of the rollout of the pool, nil if none.
+func (e *poolEntry) rolloutStatus() *RolloutStatus {
+	r := e.rollout.Load()
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &RolloutStatus{
+		ShadowPool:        r.shadow,
+		Image:             r.image,
+		Percent:           r.policy.Percent,
+		Started:           r.started.Unix(),
+		BaselineStages:    r.baseline.stages,
+		BaselineFailures:  r.baseline.failures,
+		CandidateStages:   r.candidate.stages,
+		CandidateFailures: r.candidate.failures,
+	}
+}
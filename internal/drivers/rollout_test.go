@@ -0,0 +1,155 @@
+package drivers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// imageDriver creates no instances either, it reports the image of the pool.
+type imageDriver struct {
+	failingDriver
+	image string
+}
+
+func (d imageDriver) InstanceType() string { return d.image }
+
+// rolloutObserver records the blocked rollouts.
+type rolloutObserver struct {
+	blocked chan string
+}
+
+func (o *rolloutObserver) InstanceCreated(string)              {}
+func (o *rolloutObserver) InstanceCreateFailed(string, error)  {}
+func (o *rolloutObserver) RolloutBlocked(pool string, _ error) { o.blocked <- pool }
+
+func TestRolloutCanaryFailure(t *testing.T) {
+	const pool = "linux"
+
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := New(ctx, ldb.NewInstanceStore(db), &config.EnvConfig{})
+	m.SetLeader(func() bool { return false })
+	observer := &rolloutObserver{blocked: make(chan string, 1)}
+	m.SetObserver(observer)
+	policy := types.RolloutPolicy{Percent: 25}
+	if err = m.Upsert(&Pool{Name: pool, MaxSize: 4, Driver: imageDriver{image: "v1"}, Rollout: policy}); err != nil {
+		t.Fatal(err)
+	}
+	// the canary instance of the new image can't be created
+	if err = m.Upsert(&Pool{Name: pool, MaxSize: 4, Driver: imageDriver{image: "v2"}, Rollout: policy}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case blocked := <-observer.blocked:
+		if blocked != pool {
+			t.Errorf("expected the rollout of %s to be blocked, got %s", pool, blocked)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the rollout to be blocked")
+	}
+	entry := m.getPool(pool)
+	if entry.rollout.Load() != nil {
+		t.Error("expected the rollout to be ended")
+	}
+	if got := imageOf(entry.Driver); got != "v1" {
+		t.Errorf("expected the pool to keep the old image, got %s", got)
+	}
+	if m.RolloutTarget(pool) != pool {
+		t.Error("expected the stages to be set up on the pool")
+	}
+}
+
+func TestRolloutCutOver(t *testing.T) {
+	const pool, shadow = "linux", "linux-rollout"
+
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := New(ctx, ldb.NewInstanceStore(db), &config.EnvConfig{})
+	m.SetLeader(func() bool { return false })
+	policy := types.RolloutPolicy{Percent: 25, MinStages: 4, MaxFailureIncrease: 0.1}
+	spec := Pool{Name: pool, MaxSize: 4, Driver: imageDriver{image: "v2"}, Rollout: policy}
+	if err = m.Add(Pool{Name: pool, MaxSize: 4, Driver: imageDriver{image: "v1"}, Rollout: policy}, shadowPool(&spec, shadow)); err != nil {
+		t.Fatal(err)
+	}
+	entry := m.getPool(pool)
+	r := &rollout{shadow: shadow, image: "v2", policy: policy, started: time.Now(), cancel: func() {}, spec: spec}
+	entry.rollout.Store(r)
+
+	if m.RolloutTarget(pool) != pool {
+		t.Error("expected no stage on the shadow pool before the canary passed")
+	}
+	r.validated.Store(true)
+	r.routed.Store(0)
+
+	shadowStages := 0
+	for i := 0; i < 100; i++ {
+		if m.RolloutTarget(pool) == shadow {
+			shadowStages++
+		}
+	}
+	if shadowStages != 25 {
+		t.Errorf("expected 25%% of the stages on the shadow pool, got %d", shadowStages)
+	}
+
+	for i := 0; i < 4; i++ {
+		m.recordRolloutOutcome(pool, false)
+		m.recordRolloutOutcome(shadow, false)
+	}
+	done, promote := r.evaluate(time.Now())
+	if !done || !promote {
+		t.Fatalf("expected the new image to be promoted, done %t promote %t", done, promote)
+	}
+	m.endRollout(ctx, entry, r, promote)
+	if got := imageOf(m.getPool(pool).Driver); got != "v2" {
+		t.Errorf("expected the pool to be cut over to the new image, got %s", got)
+	}
+	if m.RolloutTarget(pool) != pool {
+		t.Error("expected the stages to be set up on the pool after the cut over")
+	}
+}
+
+func TestRolloutEvaluate(t *testing.T) {
+	started := time.Now()
+	tests := []struct {
+		name               string
+		baseline, shadow   rolloutOutcomes
+		now                time.Time
+		wantDone, wantProm bool
+	}{
+		{name: "not enough stages", shadow: rolloutOutcomes{stages: 3}, now: started, wantDone: false},
+		{name: "same failure rate", baseline: rolloutOutcomes{stages: 10, failures: 1}, shadow: rolloutOutcomes{stages: 10, failures: 1}, now: started, wantDone: true, wantProm: true},
+		{name: "more failures", baseline: rolloutOutcomes{stages: 10}, shadow: rolloutOutcomes{stages: 10, failures: 3}, now: started, wantDone: true, wantProm: false},
+		{name: "duration elapsed", shadow: rolloutOutcomes{stages: 1}, now: started.Add(2 * time.Hour), wantDone: true, wantProm: true},
+	}
+	for _, test := range tests {
+		r := &rollout{
+			policy:    types.RolloutPolicy{MinStages: 10, MaxFailureIncrease: 0.05},
+			started:   started,
+			baseline:  test.baseline,
+			candidate: test.shadow,
+		}
+		done, promote := r.evaluate(test.now)
+		if done != test.wantDone || promote != test.wantProm {
+			t.Errorf("%s: expected done %t promote %t, got %t %t", test.name, test.wantDone, test.wantProm, done, promote)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package drivers
+
+import (
+	"sync"
+)
+
+// Route selects the pool of the stages of a platform and resource class whose setup request does
+// not name a pool. The empty fields match any value.
+type Route struct {
+	OS            string
+	Arch          string
+	ResourceClass string
+	Pool          string
+	FallbackPools []string
+}
+
+// routes is the routing table of the manager.
+type routes struct {
+	mu     sync.RWMutex
+	routes []Route
+}
+
+// SetRoutes replaces the routing table of the stages without a pool.
+func (m *Manager) SetRoutes(r []Route) {
+	m.routes.mu.Lock()
+	defer m.routes.mu.Unlock()
+	m.routes.routes = r
+}
+
+// RoutePool returns the pool and the fallback pools of a stage of the platform and resource class,
+// from the most specific matching route, the first one of the routing table if several match
+// equally. It returns false if no route matches.
+func (m *Manager) RoutePool(os, arch, resourceClass string) (pool string, fallbacks []string, ok bool) {
+	m.routes.mu.RLock()
+	defer m.routes.mu.RUnlock()
+
+	best := -1
+	for i := range m.routes.routes {
+		r := &m.routes.routes[i]
+		specificity := 0
+		for _, f := range [][2]string{{r.OS, os}, {r.Arch, arch}, {r.ResourceClass, resourceClass}} {
+			if f[0] == "" {
+				continue
+			}
+			if f[0] != f[1] {
+				specificity = -1
+				break
+			}
+			specificity++
+		}
+		if specificity > best {
+			best = specificity
+			pool, fallbacks, ok = r.Pool, r.FallbackPools, true
+		}
+	}
+	return pool, fallbacks, ok
+}
@@ -0,0 +1,41 @@
+package drivers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+)
+
+func TestRoutePool(t *testing.T) {
+	m := New(context.Background(), nil, &config.EnvConfig{})
+	if _, _, ok := m.RoutePool("linux", "amd64", ""); ok {
+		t.Error("expected no route without a routing table")
+	}
+
+	m.SetRoutes([]Route{
+		{OS: "linux", Arch: "amd64", Pool: "linux-amd64", FallbackPools: []string{"linux-amd64-spot"}},
+		{OS: "linux", Arch: "amd64", ResourceClass: "large", Pool: "linux-amd64-large"},
+		{OS: "linux", Pool: "linux-any"},
+		{OS: "windows", Arch: "amd64", Pool: "windows"},
+	})
+	tests := []struct {
+		os, arch, class string
+		pool            string
+		fallbacks       []string
+	}{
+		{os: "linux", arch: "amd64", pool: "linux-amd64", fallbacks: []string{"linux-amd64-spot"}},
+		{os: "linux", arch: "amd64", class: "large", pool: "linux-amd64-large"},
+		{os: "linux", arch: "amd64", class: "xlarge", pool: "linux-amd64", fallbacks: []string{"linux-amd64-spot"}},
+		{os: "linux", arch: "arm64", pool: "linux-any"},
+		{os: "windows", arch: "amd64", class: "large", pool: "windows"},
+		{os: "darwin", arch: "arm64"},
+	}
+	for _, test := range tests {
+		pool, fallbacks, ok := m.RoutePool(test.os, test.arch, test.class)
+		if ok != (test.pool != "") || pool != test.pool || !reflect.DeepEqual(fallbacks, test.fallbacks) {
+			t.Errorf("%s/%s %q: got %q %v %t, want %q %v", test.os, test.arch, test.class, pool, fallbacks, ok, test.pool, test.fallbacks)
+		}
+	}
+}
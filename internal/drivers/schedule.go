@@ -0,0 +1,156 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/hashicorp/cronexpr"
+	"github.com/sirupsen/logrus"
+)
+
+// Actions on the free instances of a pool going to sleep, see types.PoolSchedule.
+const (
+	ScheduleHibernate = "hibernate"
+	ScheduleDestroy   = "destroy"
+)
+
+// schedulerInterval is how often the pools with a schedule are put to sleep or woken up.
+const schedulerInterval = time.Minute
+
+// Schedule is the parsed off-hours schedule of a pool.
+type Schedule struct {
+	sleep    *cronexpr.Expression
+	wake     *cronexpr.Expression
+	location *time.Location
+	action   string
+}
+
+// ParseSchedule parses the schedule of a pool.
+func ParseSchedule(s types.PoolSchedule) (*Schedule, error) {
+	if s.Sleep == "" || s.Wake == "" {
+		return nil, fmt.Errorf("schedule: both the sleep and the wake times must be set")
+	}
+	sleep, err := cronexpr.Parse(s.Sleep)
+	if err != nil {
+		return nil, fmt.Errorf("schedule: invalid sleep time %q: %w", s.Sleep, err)
+	}
+	wake, err := cronexpr.Parse(s.Wake)
+	if err != nil {
+		return nil, fmt.Errorf("schedule: invalid wake time %q: %w", s.Wake, err)
+	}
+	location, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("schedule: invalid timezone %q: %w", s.Timezone, err)
+	}
+	action := s.Action
+	switch action {
+	case "":
+		action = ScheduleHibernate
+	case ScheduleHibernate, ScheduleDestroy:
+	default:
+		return nil, fmt.Errorf("schedule: unknown action %q, must be %s or %s", s.Action, ScheduleHibernate, ScheduleDestroy)
+	}
+	return &Schedule{sleep: sleep, wake: wake, location: location, action: action}, nil
+}
+
+// Asleep returns true if the pool is in its off-hours at the time, i.e. it wakes up before it
+// goes to sleep next.
+func (s *Schedule) Asleep(now time.Time) bool {
+	now = now.In(s.location)
+	nextWake := s.wake.Next(now)
+	nextSleep := s.sleep.Next(now)
+	if nextWake.IsZero() {
+		return false
+	}
+	return nextSleep.IsZero() || nextWake.Before(nextSleep)
+}
+
+// asleep returns true if the pool has a schedule and is in its off-hours.
+func (p *poolEntry) asleep() bool {
+	return p.Schedule != nil && p.Schedule.Asleep(time.Now())
+}
+
+// StartScheduler puts the pools with a schedule to sleep during their off-hours and refills them
+// once they wake up.
+func (m *Manager) StartScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(schedulerInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !m.IsLeader() {
+					continue
+				}
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							logrus.Errorf("PANIC %v\n%s", r, debug.Stack())
+						}
+					}()
+					m.applySchedules(ctx)
+				}()
+			}
+		}
+	}()
+}
+
+func (m *Manager) applySchedules(ctx context.Context) {
+	for _, pool := range m.pools() {
+		if pool.Schedule == nil {
+			continue
+		}
+		logr := logrus.WithField("pool", pool.Name).WithField("driver", pool.Driver.DriverName())
+		asleep := pool.asleep()
+		wasAsleep := pool.sleeping.Swap(asleep)
+		if asleep {
+			if err := m.sleepPool(ctx, pool); err != nil {
+				logr.WithError(err).Errorln("scheduler: failed to put the pool to sleep")
+			}
+			if !wasAsleep {
+				logr.Infoln("scheduler: the pool went to sleep")
+			}
+			continue
+		}
+		if wasAsleep {
+			logr.Infoln("scheduler: the pool woke up, refilling it")
+			if err := m.buildPoolWithMutex(ctx, pool); err != nil {
+				logr.WithError(err).Errorln("scheduler: failed to refill the pool")
+			}
+		}
+	}
+}
+
+// sleepPool hibernates or destroys the free instances of the pool. The instances are destroyed if
+// the driver can't hibernate them.
+func (m *Manager) sleepPool(ctx context.Context, pool *poolEntry) error {
+	pool.Lock()
+	_, free, _, err := m.List(ctx, pool)
+	if err != nil {
+		pool.Unlock()
+		return err
+	}
+	if pool.Schedule.action == ScheduleDestroy || !pool.Driver.CanHibernate() {
+		defer pool.Unlock()
+		if len(free) == 0 {
+			return nil
+		}
+		return m.destroyOrRetry(ctx, pool, free, true)
+	}
+	pool.Unlock()
+
+	for _, inst := range free {
+		if inst.IsHibernated {
+			continue
+		}
+		if err = m.hibernate(ctx, inst.ID, pool.Name, pool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package drivers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestScheduleAsleep(t *testing.T) {
+	s, err := ParseSchedule(types.PoolSchedule{Sleep: "0 20 * * 1-5", Wake: "0 7 * * 1-5", Timezone: "Europe/Berlin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	tests := []struct {
+		at     time.Time
+		asleep bool
+	}{
+		{time.Date(2023, 6, 5, 10, 0, 0, 0, berlin), false},     // monday morning
+		{time.Date(2023, 6, 5, 21, 0, 0, 0, berlin), true},      // monday night
+		{time.Date(2023, 6, 6, 6, 59, 0, 0, berlin), true},      // before the wake up
+		{time.Date(2023, 6, 10, 12, 0, 0, 0, berlin), true},     // saturday
+		{time.Date(2023, 6, 12, 7, 30, 0, 0, berlin), false},    // monday after the weekend
+		{time.Date(2023, 6, 5, 19, 30, 0, 0, time.UTC), true},   // 21:30 in Berlin
+		{time.Date(2023, 6, 5, 5, 30, 0, 0, time.UTC), false},   // 7:30 in Berlin
+		{time.Date(2023, 6, 9, 17, 59, 0, 0, time.UTC), false},  // friday 19:59 in Berlin
+		{time.Date(2023, 6, 9, 18, 0, 0, 0, time.UTC), true},    // friday 20:00 in Berlin
+		{time.Date(2023, 6, 12, 4, 59, 0, 0, time.UTC), true},   // monday 6:59 in Berlin
+		{time.Date(2023, 6, 12, 5, 0, 0, 0, time.UTC), false},   // monday 7:00 in Berlin
+		{time.Date(2023, 12, 25, 6, 30, 0, 0, time.UTC), false}, // 7:30 in Berlin in winter
+		{time.Date(2023, 12, 25, 5, 30, 0, 0, time.UTC), true},  // 6:30 in Berlin in winter
+	}
+	for _, test := range tests {
+		if got := s.Asleep(test.at); got != test.asleep {
+			t.Errorf("%s: expected asleep %v, got %v", test.at, test.asleep, got)
+		}
+	}
+
+	for _, invalid := range []types.PoolSchedule{
+		{Sleep: "0 20 * * 1-5"},
+		{Sleep: "0 20 * * 1-5", Wake: "not cron"},
+		{Sleep: "0 20 * * 1-5", Wake: "0 7 * * 1-5", Timezone: "Mars/Olympus"},
+		{Sleep: "0 20 * * 1-5", Wake: "0 7 * * 1-5", Action: "stop"},
+	} {
+		if _, err = ParseSchedule(invalid); err == nil {
+			t.Errorf("expected the schedule %+v to be invalid", invalid)
+		}
+	}
+}
+
+func TestSleepPoolDestroysFreeInstances(t *testing.T) {
+	const pool = "linux"
+
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	instanceStore := ldb.NewInstanceStore(db)
+	for _, inst := range []*types.Instance{
+		{ID: "free", Pool: pool, State: types.StateCreated},
+		{ID: "busy", Pool: pool, State: types.StateInUse},
+	} {
+		if err = instanceStore.Create(ctx, inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the schedule is always asleep: it wakes up every minute but never goes to sleep again
+	schedule, err := ParseSchedule(types.PoolSchedule{Sleep: "0 0 1 1 * 2000", Wake: "* * * * *"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &config.EnvConfig{}
+	m := New(ctx, instanceStore, env)
+	driver := &countingDriver{}
+	if err = m.Add(Pool{Name: pool, MinSize: 2, MaxSize: 4, Schedule: schedule, Driver: driver}); err != nil {
+		t.Fatal(err)
+	}
+
+	m.applySchedules(ctx)
+	if _, err = instanceStore.Find(ctx, "free"); err == nil {
+		t.Error("expected the free instance to be destroyed, the driver can't hibernate")
+	}
+	if _, err = instanceStore.Find(ctx, "busy"); err != nil {
+		t.Errorf("expected the busy instance to be kept, got %s", err)
+	}
+	if err = m.BuildPools(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if created := driver.created.Load(); created != 0 {
+		t.Errorf("expected the sleeping pool not to be refilled, created %d instances", created)
+	}
+}
@@ -0,0 +1,161 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// Right-sizing advices.
+const (
+	SizingKeep             = "keep"
+	SizingDownsize         = "downsize"
+	SizingUpsize           = "upsize"
+	SizingInsufficientData = "insufficient_data"
+)
+
+const (
+	// minSizingStages is the number of sampled stages below which no size is recommended.
+	minSizingStages = 10
+	// sizingHeadroom is the share of the utilization added on top of the 90th percentile.
+	sizingHeadroom = 1.25
+	// sizingSaturation is the 90th percentile of the utilization, in percents, above which the
+	// machines are undersized.
+	sizingSaturation = 90
+)
+
+// SizingRecommendation is the peak utilization of the instances of a pool of a machine type and
+// the machine size fitting 90% of the stages, see Manager.Sizing.
+type SizingRecommendation struct {
+	PoolName   string `json:"pool_name"`
+	Size       string `json:"size"` // the machine type, empty if the driver has none
+	Stages     int    `json:"stages"`
+	CPUs       int    `json:"cpus"`
+	MemTotalMB int64  `json:"mem_total_mb"`
+	CPUP50Pct  int64  `json:"cpu_p50_pct"`
+	CPUP90Pct  int64  `json:"cpu_p90_pct"`
+	MemP50MB   int64  `json:"mem_p50_mb"`
+	MemP90MB   int64  `json:"mem_p90_mb"`
+	MemMaxMB   int64  `json:"mem_max_mb"`
+	// Advice is keep, downsize, upsize or insufficient_data, the recommended CPUs and memory fit
+	// the 90th percentile of the utilization with a 25% headroom.
+	Advice           string `json:"advice"`
+	RecommendedCPUs  int    `json:"recommended_cpus,omitempty"`
+	RecommendedMemMB int64  `json:"recommended_mem_mb,omitempty"`
+	Summary          string `json:"summary"`
+}
+
+// Sizing returns the right-sizing recommendations of the pools from the peak utilization of the
+// instances of the stages completed since the given time, per pool and machine type. All the pools
+// are returned if the pool name is empty.
+func (m *Manager) Sizing(ctx context.Context, poolName string, since time.Time) ([]SizingRecommendation, error) {
+	if m.stageRecords == nil {
+		return []SizingRecommendation{}, nil
+	}
+	records, err := m.stageRecords.List(ctx, &types.StageRecordQuery{PoolName: poolName, Since: since.Unix()})
+	if err != nil {
+		return nil, err
+	}
+	return recommendSizes(records), nil
+}
+
+// recommendSizes groups the sampled records by pool and machine type, ordered by pool and type.
+func recommendSizes(records []*types.StageRecord) []SizingRecommendation {
+	type key struct{ pool, size string }
+	type samples struct {
+		cpu, mem        []int64
+		cpus            int
+		memTotalMB      int64
+		latestStartedAt int64
+	}
+	groups := map[key]*samples{}
+	for _, r := range records {
+		if r.Result != types.StageCompleted || r.MemTotalMB == 0 {
+			continue
+		}
+		k := key{pool: r.PoolName, size: r.Size}
+		g, ok := groups[k]
+		if !ok {
+			g = &samples{}
+			groups[k] = g
+		}
+		g.cpu = append(g.cpu, int64(r.PeakCPUPct))
+		g.mem = append(g.mem, r.PeakMemMB)
+		// the resources of the latest instance, a machine type might change its resources
+		if r.Started >= g.latestStartedAt {
+			g.cpus, g.memTotalMB, g.latestStartedAt = r.CPUs, r.MemTotalMB, r.Started
+		}
+	}
+
+	out := make([]SizingRecommendation, 0, len(groups))
+	for k, g := range groups {
+		rec := SizingRecommendation{
+			PoolName:   k.pool,
+			Size:       k.size,
+			Stages:     len(g.mem),
+			CPUs:       g.cpus,
+			MemTotalMB: g.memTotalMB,
+			CPUP50Pct:  percentile(g.cpu, 0.5), //nolint:gomnd
+			CPUP90Pct:  percentile(g.cpu, 0.9), //nolint:gomnd
+			MemP50MB:   percentile(g.mem, 0.5), //nolint:gomnd
+			MemP90MB:   percentile(g.mem, 0.9), //nolint:gomnd
+			MemMaxMB:   percentile(g.mem, 1),
+		}
+		rec.advise()
+		out = append(out, rec)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].PoolName != out[j].PoolName {
+			return out[i].PoolName < out[j].PoolName
+		}
+		return out[i].Size < out[j].Size
+	})
+	return out
+}
+
+// advise sets the advice, the recommended resources and the summary of the recommendation.
+func (r *SizingRecommendation) advise() {
+	r.Summary = fmt.Sprintf("90%% of the %d stages used less than %s of the %s of memory and %d%% of the %d CPUs",
+		r.Stages, formatMB(r.MemP90MB), formatMB(r.MemTotalMB), r.CPUP90Pct, r.CPUs)
+	if r.Stages < minSizingStages || r.CPUs == 0 {
+		r.Advice = SizingInsufficientData
+		r.Summary += fmt.Sprintf(", at least %d stages are needed for a recommendation", minSizingStages)
+		return
+	}
+
+	neededCPUs := float64(r.CPUP90Pct) / 100 * float64(r.CPUs) * sizingHeadroom //nolint:gomnd
+	r.RecommendedCPUs = int(nextPowerOfTwo(neededCPUs))
+	neededGB := float64(r.MemP90MB) / 1024 * sizingHeadroom //nolint:gomnd
+	r.RecommendedMemMB = nextPowerOfTwo(neededGB) * 1024    //nolint:gomnd
+
+	switch {
+	case r.CPUP90Pct >= sizingSaturation || r.MemP90MB*100 >= r.MemTotalMB*sizingSaturation:
+		r.Advice = SizingUpsize
+	case r.RecommendedCPUs < r.CPUs && r.RecommendedMemMB < r.MemTotalMB:
+		r.Advice = SizingDownsize
+	default:
+		r.Advice = SizingKeep
+	}
+	if r.Advice != SizingKeep {
+		r.Summary += fmt.Sprintf(", %s to %d CPUs and %s", r.Advice, r.RecommendedCPUs, formatMB(r.RecommendedMemMB))
+	}
+}
+
+// nextPowerOfTwo returns the smallest power of two not lower than v, at least 1.
+func nextPowerOfTwo(v float64) int64 {
+	if v <= 1 {
+		return 1
+	}
+	return int64(math.Pow(2, math.Ceil(math.Log2(v)))) //nolint:gomnd
+}
+
+func formatMB(mb int64) string {
+	if mb < 1024 { //nolint:gomnd
+		return fmt.Sprintf("%dMB", mb)
+	}
+	return fmt.Sprintf("%.1fGB", float64(mb)/1024) //nolint:gomnd
+}
@@ -0,0 +1,55 @@
+package drivers
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestRecommendSizes(t *testing.T) {
+	var records []*types.StageRecord
+	for i := 0; i < 20; i++ {
+		// 90% of the stages use less than 3GB of the 8GB and 40% of the 4 CPUs
+		mem, cpu := int64(1024+i*100), 20+i
+		if i >= 18 {
+			mem, cpu = 7000, 95
+		}
+		records = append(records,
+			&types.StageRecord{PoolName: "linux", Size: "m5.xlarge", Result: types.StageCompleted, Started: int64(i),
+				CPUs: 4, MemTotalMB: 7800, PeakCPUPct: cpu, PeakMemMB: mem},
+			&types.StageRecord{PoolName: "linux", Size: "m5.large", Result: types.StageCompleted, Started: int64(i),
+				CPUs: 2, MemTotalMB: 7800, PeakCPUPct: 99, PeakMemMB: 7500},
+		)
+	}
+	records = append(records,
+		&types.StageRecord{PoolName: "windows", Size: "m5.xlarge", Result: types.StageCompleted, CPUs: 4, MemTotalMB: 16000, PeakMemMB: 2000},
+		// neither the failed setups nor the stages not sampled count
+		&types.StageRecord{PoolName: "windows", Size: "m5.xlarge", Result: types.StageSetupFailed, CPUs: 4, MemTotalMB: 16000},
+		&types.StageRecord{PoolName: "windows", Size: "m5.xlarge", Result: types.StageCompleted},
+	)
+
+	got := recommendSizes(records)
+	if len(got) != 3 {
+		t.Fatalf("got %d recommendations, want 3: %+v", len(got), got)
+	}
+
+	large, xlarge, windows := got[0], got[1], got[2]
+	if large.Size != "m5.large" || large.Advice != SizingUpsize {
+		t.Errorf("got %+v, want the saturated machines upsized", large)
+	}
+	if xlarge.Size != "m5.xlarge" || xlarge.Stages != 20 || xlarge.Advice != SizingDownsize {
+		t.Errorf("got %+v, want the xlarge machines downsized", xlarge)
+	}
+	if xlarge.MemP90MB != 2724 || xlarge.CPUP90Pct != 37 {
+		t.Errorf("got the 90th percentiles %dMB and %d%%, want 2724MB and 37%%", xlarge.MemP90MB, xlarge.CPUP90Pct)
+	}
+	if xlarge.RecommendedCPUs != 2 || xlarge.RecommendedMemMB != 4096 {
+		t.Errorf("got %d CPUs and %dMB recommended, want 2 CPUs and 4096MB", xlarge.RecommendedCPUs, xlarge.RecommendedMemMB)
+	}
+	if want := "90% of the 20 stages used less than 2.7GB of the 7.6GB of memory and 37% of the 4 CPUs, downsize to 2 CPUs and 4.0GB"; xlarge.Summary != want {
+		t.Errorf("got summary %q, want %q", xlarge.Summary, want)
+	}
+	if windows.Stages != 1 || windows.Advice != SizingInsufficientData {
+		t.Errorf("got %+v, want insufficient data", windows)
+	}
+}
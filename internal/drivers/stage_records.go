@@ -0,0 +1,150 @@
+package drivers
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/drone/runner-go/logger"
+)
+
+// BootTimeStats are the boot and the health check times of the stages of a pool set up within a
+// time bucket.
+type BootTimeStats struct {
+	PoolName         string `json:"pool_name"`
+	Start            int64  `json:"start"` // unix time of the start of the bucket
+	Stages           int    `json:"stages"`
+	SetupFailures    int    `json:"setup_failures"`
+	BootP50Ms        int64  `json:"boot_p50_ms"`
+	BootP95Ms        int64  `json:"boot_p95_ms"`
+	HealthCheckP50Ms int64  `json:"health_check_p50_ms"`
+	HealthCheckP95Ms int64  `json:"health_check_p95_ms"`
+}
+
+// SetStageRecordStore sets the store of the stage records. Without the store the stages are not
+// recorded.
+func (m *Manager) SetStageRecordStore(s store.StageRecordStore) {
+	m.stageRecords = s
+}
+
+// RecordStage stores the placement and the setup durations of a stage, the failures are only
+// logged since the records are only used for analytics.
+func (m *Manager) RecordStage(ctx context.Context, record *types.StageRecord) {
+	m.recordRolloutOutcome(record.PoolName, record.Result == types.StageSetupFailed)
+	if m.stageRecords == nil {
+		return
+	}
+	if err := m.stageRecords.Create(ctx, record); err != nil {
+		logger.FromContext(ctx).WithError(err).
+			WithField("stage_runtime_id", record.StageID).
+			Warnln("manager: failed to store the stage record")
+	}
+}
+
+// CompleteStage records the run time of a stage whose instance is destroyed, and the peak
+// utilization of the instance if it was sampled.
+func (m *Manager) CompleteStage(ctx context.Context, stageID string, usage *types.ResourceUsage) {
+	if m.stageRecords == nil {
+		return
+	}
+	record, err := m.stageRecords.Find(ctx, stageID)
+	if err != nil || record.Result != types.StageRunning {
+		return
+	}
+	record.RunMs = time.Since(time.Unix(record.Started, 0)).Milliseconds()
+	record.Result = types.StageCompleted
+	if usage != nil {
+		record.CPUs = usage.CPUs
+		record.MemTotalMB = usage.MemTotalMB
+		record.PeakCPUPct = usage.PeakCPUPct
+		record.PeakMemMB = usage.PeakMemMB
+	}
+	if err = m.stageRecords.Update(ctx, record); err != nil {
+		logger.FromContext(ctx).WithError(err).
+			WithField("stage_runtime_id", stageID).
+			Warnln("manager: failed to update the stage record")
+	}
+}
+
+// BootTimes returns the boot time percentiles of the stages set up since the given time, per
+// pool and time bucket. All the pools are returned if the pool name is empty.
+func (m *Manager) BootTimes(ctx context.Context, poolName string, since time.Time, bucket time.Duration) ([]BootTimeStats, error) {
+	if m.stageRecords == nil {
+		return []BootTimeStats{}, nil
+	}
+	records, err := m.stageRecords.List(ctx, &types.StageRecordQuery{PoolName: poolName, Since: since.Unix()})
+	if err != nil {
+		return nil, err
+	}
+	return aggregateBootTimes(records, bucket), nil
+}
+
+// aggregateBootTimes groups the records by pool and time bucket, the buckets are ordered by pool
+// and start time. The records of the failed setups only count as failures.
+func aggregateBootTimes(records []*types.StageRecord, bucket time.Duration) []BootTimeStats {
+	type key struct {
+		pool  string
+		start int64
+	}
+	type samples struct {
+		boot, healthCheck []int64
+		failures          int
+	}
+	size := int64(bucket.Seconds())
+	if size <= 0 {
+		size = 1
+	}
+	groups := map[key]*samples{}
+	for _, r := range records {
+		k := key{pool: r.PoolName, start: r.Started - r.Started%size}
+		g, ok := groups[k]
+		if !ok {
+			g = &samples{}
+			groups[k] = g
+		}
+		if r.Result == types.StageSetupFailed {
+			g.failures++
+			continue
+		}
+		g.boot = append(g.boot, r.BootMs)
+		g.healthCheck = append(g.healthCheck, r.HealthCheckMs)
+	}
+
+	stats := make([]BootTimeStats, 0, len(groups))
+	for k, g := range groups {
+		stats = append(stats, BootTimeStats{
+			PoolName:         k.pool,
+			Start:            k.start,
+			Stages:           len(g.boot) + g.failures,
+			SetupFailures:    g.failures,
+			BootP50Ms:        percentile(g.boot, 0.5),         //nolint:gomnd
+			BootP95Ms:        percentile(g.boot, 0.95),        //nolint:gomnd
+			HealthCheckP50Ms: percentile(g.healthCheck, 0.5),  //nolint:gomnd
+			HealthCheckP95Ms: percentile(g.healthCheck, 0.95), //nolint:gomnd
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].PoolName != stats[j].PoolName {
+			return stats[i].PoolName < stats[j].PoolName
+		}
+		return stats[i].Start < stats[j].Start
+	})
+	return stats
+}
+
+// percentile returns the nearest-rank percentile of the samples, 0 if there are none.
+func percentile(samples []int64, p float64) int64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := append([]int64(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,48 @@
+package drivers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestAggregateBootTimes(t *testing.T) {
+	const hour = 3600
+	records := []*types.StageRecord{
+		{PoolName: "linux", Started: 10, BootMs: 100, HealthCheckMs: 10, Result: types.StageCompleted},
+		{PoolName: "linux", Started: 20, BootMs: 300, HealthCheckMs: 30, Result: types.StageRunning},
+		{PoolName: "linux", Started: 30, BootMs: 200, HealthCheckMs: 20, Result: types.StageCompleted},
+		{PoolName: "linux", Started: 40, Result: types.StageSetupFailed},
+		{PoolName: "linux", Started: hour + 5, BootMs: 500, HealthCheckMs: 50, Result: types.StageCompleted},
+		{PoolName: "arm", Started: 50, BootMs: 700, HealthCheckMs: 70, Result: types.StageCompleted},
+	}
+
+	got := aggregateBootTimes(records, time.Hour)
+	want := []BootTimeStats{
+		{PoolName: "arm", Start: 0, Stages: 1, BootP50Ms: 700, BootP95Ms: 700, HealthCheckP50Ms: 70, HealthCheckP95Ms: 70},
+		{PoolName: "linux", Start: 0, Stages: 4, SetupFailures: 1, BootP50Ms: 200, BootP95Ms: 300, HealthCheckP50Ms: 20, HealthCheckP95Ms: 30},
+		{PoolName: "linux", Start: hour, Stages: 1, BootP50Ms: 500, BootP95Ms: 500, HealthCheckP50Ms: 50, HealthCheckP95Ms: 50},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d buckets, got %d: %+v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("bucket %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	if p := percentile(nil, 0.5); p != 0 {
+		t.Errorf("expected 0 without samples, got %d", p)
+	}
+	samples := []int64{5, 1, 4, 2, 3, 6, 7, 8, 9, 10}
+	if p := percentile(samples, 0.5); p != 5 {
+		t.Errorf("expected the median 5, got %d", p)
+	}
+	if p := percentile(samples, 0.95); p != 10 {
+		t.Errorf("expected the 95th percentile 10, got %d", p)
+	}
+}
@@ -0,0 +1,224 @@
+// Package static implements a driver for pools of pre-existing, self-managed machines.
+// The driver never creates or destroys a machine: Create claims a healthy unclaimed
+// machine of the pool and Destroy releases it. The stored instances are the claims of the
+// machines, they are restored by the manager, see drivers.Claimer.
+package static
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dchest/uniuri"
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/internal/lehelper"
+	"github.com/drone-runners/drone-runner-aws/internal/oshelp"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/drone/runner-go/logger"
+)
+
+var (
+	healthTimeout = 10 * time.Second
+	// pendingClaimTimeout is how long a claim is kept before the instance of the machine is stored.
+	pendingClaimTimeout = 10 * time.Minute
+
+	errNoMachineAvailable = errors.New("static: no healthy machine available")
+)
+
+type config struct {
+	rootDir  string
+	machines []Machine
+
+	mu sync.Mutex
+	// claims maps the name of a claimed machine to the claim, see RestoreClaims.
+	claims map[string]claim
+}
+
+type claim struct {
+	instanceID string
+	claimed    time.Time
+	stored     bool // the instance of the claim is in the store
+}
+
+// SetPlatformDefaults comes up with default values of the platform
+// in case they are not set.
+func SetPlatformDefaults(platform *types.Platform) (*types.Platform, error) {
+	if platform.Arch == "" {
+		platform.Arch = oshelp.ArchAMD64
+	}
+	if platform.OS == "" {
+		platform.OS = oshelp.OSLinux
+	}
+	return platform, nil
+}
+
+func New(opts ...Option) (drivers.Driver, error) {
+	p := new(config)
+	for _, opt := range opts {
+		opt(p)
+	}
+	if len(p.machines) == 0 {
+		return nil, errors.New("static: no machines defined")
+	}
+	for i := range p.machines {
+		if p.machines[i].Port == 0 {
+			p.machines[i].Port = lehelper.LiteEnginePort
+		}
+	}
+	p.claims = make(map[string]claim)
+	return p, nil
+}
+
+func (p *config) DriverName() string {
+	return string(types.Static)
+}
+
+func (p *config) RootDir() string {
+	return p.rootDir
+}
+
+func (p *config) CanHibernate() bool {
+	return false
+}
+
+// Create claims an unclaimed machine whose lite-engine is healthy.
+func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (*types.Instance, error) {
+	logr := logger.FromContext(ctx).
+		WithField("driver", types.Static).
+		WithField("pool", opts.PoolName)
+
+	for i := range p.machines {
+		m := &p.machines[i]
+		instanceID := fmt.Sprintf("%s-%s", m.Name, strings.ToLower(uniuri.NewLen(8))) //nolint:gomnd
+		if !p.claim(m.Name, instanceID) {
+			continue
+		}
+
+		inst := &types.Instance{
+			ID:         instanceID,
+			Name:       instanceID,
+			ProviderID: m.Name,
+			Address:    m.Address,
+			Port:       m.Port,
+			Provider:   types.Static,
+			State:      types.StateCreated,
+			Pool:       opts.PoolName,
+			Platform:   opts.Platform,
+			CACert:     m.CACert,
+			TLSCert:    m.TLSCert,
+			TLSKey:     m.TLSKey,
+			Started:    time.Now().Unix(),
+			Updated:    time.Now().Unix(),
+		}
+
+		if err := p.checkHealth(ctx, inst, opts.RunnerName); err != nil {
+			logr.WithError(err).WithField("machine", m.Name).Warnln("static: machine is not healthy, skipping it")
+			p.release(m.Name, instanceID)
+			continue
+		}
+
+		logr.WithField("machine", m.Name).WithField("instance_id", instanceID).Debugln("static: machine claimed")
+		return inst, nil
+	}
+	return nil, errNoMachineAvailable
+}
+
+// Destroy releases the machines, they are never destroyed.
+func (p *config) Destroy(ctx context.Context, instances []*types.Instance) error {
+	for _, inst := range instances {
+		p.release(machineName(inst.ID), inst.ID)
+		logger.FromContext(ctx).
+			WithField("driver", types.Static).
+			WithField("instance_id", inst.ID).
+			Debugln("static: machine released")
+	}
+	return nil
+}
+
+func (p *config) Hibernate(_ context.Context, _, _ string) error {
+	return drivers.ErrNotSupported
+}
+
+func (p *config) Start(_ context.Context, _, _ string) (string, error) {
+	return "", drivers.ErrNotSupported
+}
+
+func (p *config) SetTags(context.Context, *types.Instance, map[string]string) error {
+	return drivers.ErrNotSupported
+}
+
+func (p *config) Logs(context.Context, string) (string, error) {
+	return "", drivers.ErrNotSupported
+}
+
+func (p *config) Ping(_ context.Context) error {
+	return nil
+}
+
+func (p *config) checkHealth(ctx context.Context, inst *types.Instance, runnerName string) error {
+	client, err := lehelper.GetClient(inst, runnerName, inst.Port, false, 0)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+	resp, err := client.Health(ctx)
+	if err != nil {
+		return err
+	}
+	if !resp.OK {
+		return errors.New("lite-engine health check failed")
+	}
+	return nil
+}
+
+// RestoreClaims replaces the claims with the machines of the stored instances. The claims of the
+// machines whose instances are not stored yet are kept until they time out.
+func (p *config) RestoreClaims(instances []*types.Instance) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	claims := make(map[string]claim, len(instances))
+	for _, inst := range instances {
+		claims[inst.ProviderID] = claim{instanceID: inst.ID, claimed: time.Unix(inst.Started, 0), stored: true}
+	}
+	for machine, c := range p.claims {
+		if _, stored := claims[machine]; !stored && !c.stored && time.Since(c.claimed) < pendingClaimTimeout {
+			claims[machine] = c
+		}
+	}
+	p.claims = claims
+}
+
+func (p *config) claim(machine, instanceID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, claimed := p.claims[machine]; claimed {
+		return false
+	}
+	p.claims[machine] = claim{instanceID: instanceID, claimed: time.Now()}
+	return true
+}
+
+// release frees the machine only if it is still claimed by the instance, a stale
+// instance left over from before a restart must not release a machine claimed since.
+func (p *config) release(machine, instanceID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.claims[machine].instanceID == instanceID {
+		delete(p.claims, machine)
+	}
+}
+
+// machineName returns the name of the machine an instance was claimed from.
+func machineName(instanceID string) string {
+	if i := strings.LastIndex(instanceID, "-"); i > 0 {
+		return instanceID[:i]
+	}
+	return instanceID
+}
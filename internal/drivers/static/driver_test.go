@@ -0,0 +1,41 @@
+package static
+
+import (
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestRestoreClaims(t *testing.T) {
+	d, err := New(WithMachines(Machine{Name: "m1"}, Machine{Name: "m2"}, Machine{Name: "m3"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := d.(*config)
+
+	// m1 is claimed by an instance stored by another runner or before a restart
+	p.RestoreClaims([]*types.Instance{{ID: "m1-abc", ProviderID: "m1", Started: time.Now().Unix()}})
+	if p.claim("m1", "m1-def") {
+		t.Error("a machine of a stored instance must not be claimed again")
+	}
+	if !p.claim("m2", "m2-def") {
+		t.Error("an unclaimed machine must be claimed")
+	}
+
+	// m1 is released, the claim of m2 is pending as its instance is not stored yet
+	p.RestoreClaims(nil)
+	if !p.claim("m1", "m1-ghi") {
+		t.Error("a released machine must be claimed")
+	}
+	if p.claim("m2", "m2-ghi") {
+		t.Error("a pending claim must be kept")
+	}
+
+	// a pending claim times out
+	p.claims["m3"] = claim{instanceID: "m3-abc", claimed: time.Now().Add(-2 * pendingClaimTimeout)}
+	p.RestoreClaims(nil)
+	if !p.claim("m3", "m3-def") {
+		t.Error("a timed out pending claim must be dropped")
+	}
+}
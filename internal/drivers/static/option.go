@@ -0,0 +1,54 @@
+package static
+
+import (
+	"os"
+
+	"github.com/drone-runners/drone-runner-aws/internal/oshelp"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+type Option func(*config)
+
+// Machine is a pre-existing machine running the lite-engine.
+type Machine struct {
+	Name    string
+	Address string
+	Port    int64
+	CACert  []byte
+	TLSCert []byte
+	TLSKey  []byte
+}
+
+// WithRootDirectory returns an option to set the OS specific working directory.
+func WithRootDirectory(platform *types.Platform) Option {
+	return func(p *config) {
+		const dir = "static"
+		switch platform.OS {
+		case oshelp.OSWindows:
+			p.rootDir = oshelp.JoinPaths(platform.OS, "C:\\Windows\\Temp", dir)
+		default:
+			p.rootDir = oshelp.JoinPaths(platform.OS, "/tmp", dir)
+		}
+	}
+}
+
+// WithMachines returns an option to set the machines of the pool.
+func WithMachines(machines ...Machine) Option {
+	return func(p *config) {
+		p.machines = machines
+	}
+}
+
+// ReadCerts reads the certificates the runner uses to connect to the lite-engine of a machine.
+func ReadCerts(caCertPath, certPath, keyPath string) (caCert, tlsCert, tlsKey []byte, err error) {
+	if caCert, err = os.ReadFile(caCertPath); err != nil {
+		return nil, nil, nil, err
+	}
+	if tlsCert, err = os.ReadFile(certPath); err != nil {
+		return nil, nil, nil, err
+	}
+	if tlsKey, err = os.ReadFile(keyPath); err != nil {
+		return nil, nil, nil, err
+	}
+	return caCert, tlsCert, tlsKey, nil
+}
@@ -0,0 +1,138 @@
+package drivers
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// statsWindow is the period the failure rate of the instance creation is computed over.
+const statsWindow = 15 * time.Minute
+
+// PoolStatus describes the capacity and the availability of a pool.
+type PoolStatus struct {
+	Name        string         `json:"name"`
+	Driver      string         `json:"driver"`
+	Platform    types.Platform `json:"platform"`
+	MinSize     int            `json:"min_size"` // number of warm instances the pool maintains
+	MaxSize     int            `json:"max_size"`
+	Free        int            `json:"free"`
+	Hibernating int            `json:"hibernating"`
+	Busy        int            `json:"busy"`
+	Available   int            `json:"available"` // number of instances that can still be created
+	Overflow    int            `json:"overflow"`  // number of the busy instances created over the max size
+	QueueDepth  int64          `json:"queue_depth"`
+	// FailureRate is the ratio of failed instance creations in the recent window, out of CreateAttempts.
+	FailureRate    float64 `json:"failure_rate"`
+	CreateAttempts int     `json:"create_attempts"`
+	// DiskWarnings is the number of the disk usage warnings of the stages since the runner started.
+	DiskWarnings int64 `json:"disk_warnings"`
+	// Draining is set while the pool is being deleted, it gets no new stages.
+	Draining bool `json:"draining"`
+	// Asleep is set during the off-hours of the pool, it is not refilled.
+	Asleep bool `json:"asleep"`
+	// Rollout is the rollout of a new image of the pool in progress, if any.
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
+	// Phases are the durations of the setup phases of the stages since the runner started.
+	Phases []PhaseHistogram `json:"phases,omitempty"`
+}
+
+// poolStats keeps track of the provisioning activity of a pool.
+type poolStats struct {
+	pending      atomic.Int64 // requests waiting for an instance
+	diskWarnings atomic.Int64 // disk usage warnings of the stages
+
+	mu       sync.Mutex
+	outcomes []createOutcome            // instance creations within the window, oldest first
+	phases   map[string]*PhaseHistogram // durations of the setup phases by phase
+}
+
+type createOutcome struct {
+	at     time.Time
+	failed bool
+}
+
+func (s *poolStats) record(failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire()
+	s.outcomes = append(s.outcomes, createOutcome{at: time.Now(), failed: failed})
+}
+
+func (s *poolStats) failureRate() (rate float64, attempts int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire()
+	if len(s.outcomes) == 0 {
+		return 0, 0
+	}
+	failures := 0
+	for _, o := range s.outcomes {
+		if o.failed {
+			failures++
+		}
+	}
+	return float64(failures) / float64(len(s.outcomes)), len(s.outcomes)
+}
+
+func (s *poolStats) expire() {
+	cutoff := time.Now().Add(-statsWindow)
+	i := sort.Search(len(s.outcomes), func(i int) bool { return s.outcomes[i].at.After(cutoff) })
+	s.outcomes = s.outcomes[i:]
+}
+
+// RecordDiskWarning counts a disk usage warning of a stage running on the pool.
+func (m *Manager) RecordDiskWarning(poolName string) {
+	if pool := m.getPool(poolName); pool != nil {
+		pool.stats.diskWarnings.Add(1)
+	}
+}
+
+// PoolsStatus returns the status of all the pools ordered by name.
+func (m *Manager) PoolsStatus(ctx context.Context) ([]PoolStatus, error) {
+	pools := m.pools()
+	statuses := make([]PoolStatus, 0, len(pools))
+	for _, pool := range pools {
+		busy, free, hibernating, err := m.List(ctx, pool)
+		if err != nil {
+			return nil, err
+		}
+
+		regular, overflow := splitOverflow(busy)
+		available := pool.MaxSize - len(regular) - len(free) - len(hibernating)
+		if available < 0 {
+			available = 0
+		}
+		rate, attempts := pool.stats.failureRate()
+
+		statuses = append(statuses, PoolStatus{
+			Name:           pool.Name,
+			Driver:         pool.Driver.DriverName(),
+			Platform:       pool.Platform,
+			MinSize:        pool.MinSize,
+			MaxSize:        pool.MaxSize,
+			Free:           len(free),
+			Hibernating:    len(hibernating),
+			Busy:           len(busy),
+			Available:      available,
+			Overflow:       overflow,
+			QueueDepth:     pool.stats.pending.Load(),
+			FailureRate:    rate,
+			CreateAttempts: attempts,
+			DiskWarnings:   pool.stats.diskWarnings.Load(),
+			Phases:         pool.stats.phaseHistograms(),
+			Draining:       pool.draining.Load(),
+			Asleep:         pool.asleep(),
+			Rollout:        pool.rolloutStatus(),
+		})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses, nil
+}
@@ -0,0 +1,55 @@
+package drivers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestPoolStatsFailureRate(t *testing.T) {
+	s := &poolStats{}
+	if rate, attempts := s.failureRate(); rate != 0 || attempts != 0 {
+		t.Errorf("Want no failures without attempts, got rate %f out of %d", rate, attempts)
+	}
+
+	s.record(true)
+	s.record(false)
+	s.record(false)
+	s.record(true)
+	if rate, attempts := s.failureRate(); rate != 0.5 || attempts != 4 {
+		t.Errorf("Want failure rate 0.5 out of 4, got %f out of %d", rate, attempts)
+	}
+
+	// outcomes older than the window are not counted.
+	s.outcomes[0].at = time.Now().Add(-2 * statsWindow)
+	s.outcomes[1].at = time.Now().Add(-2 * statsWindow)
+	if rate, attempts := s.failureRate(); rate != 0.5 || attempts != 2 {
+		t.Errorf("Want failure rate 0.5 out of 2, got %f out of %d", rate, attempts)
+	}
+}
+
+func TestRecordPhases(t *testing.T) {
+	m := &Manager{poolMap: map[string]*poolEntry{"linux": {}}}
+	m.RecordPhases("linux", &types.ProvisionTimings{PoolWaitMs: 50, CreateMs: 90000, HealthCheckMs: 4000, SetupMs: 700})
+	m.RecordPhases("linux", &types.ProvisionTimings{PoolWaitMs: 20, HealthCheckMs: 1500, SetupMs: 300})
+	m.RecordPhases("unknown", &types.ProvisionTimings{PoolWaitMs: 20})
+
+	phases := m.getPool("linux").stats.phaseHistograms()
+	var got []string
+	for _, h := range phases {
+		got = append(got, h.Phase)
+	}
+	if want := []string{PhasePoolWait, PhaseCreate, PhaseHealthCheck, PhaseSetup}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Want the phases %v, got %v", want, got)
+	}
+	create := phases[1]
+	if create.Count != 1 || create.SumSeconds != 90 || create.Buckets[len(PhaseBuckets)-4] != 0 || create.Buckets[len(PhaseBuckets)-3] != 1 {
+		t.Errorf("Unexpected create histogram %+v", create)
+	}
+	health := phases[2]
+	if health.Count != 2 || health.SumSeconds != 5.5 || health.Buckets[3] != 1 || health.Buckets[4] != 2 {
+		t.Errorf("Unexpected health check histogram %+v", health)
+	}
+}
@@ -0,0 +1,25 @@
+package drivers
+
+import (
+	"context"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// Timeouts returns the timeouts of the operations on the instances of the pool.
+func (m *Manager) Timeouts(name string) types.Timeouts {
+	if entry := m.getPool(name); entry != nil {
+		return entry.Timeouts
+	}
+	return types.Timeouts{}
+}
+
+// withTimeout bounds an operation of the driver of a pool, the operation is not bounded if the
+// timeout of the pool is not set.
+func withTimeout(ctx context.Context, secs int64) (context.Context, context.CancelFunc) {
+	if secs <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(secs)*time.Second)
+}
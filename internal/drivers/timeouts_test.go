@@ -0,0 +1,56 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// hangingDriver never completes the creation and the destroy of the instances.
+type hangingDriver struct {
+	failingDriver
+}
+
+func (hangingDriver) Create(ctx context.Context, _ *types.InstanceCreateOpts) (*types.Instance, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (hangingDriver) Destroy(ctx context.Context, _ []*types.Instance) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestTimeouts(t *testing.T) {
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	m := New(ctx, ldb.NewInstanceStore(db), &config.EnvConfig{})
+	err = m.Add(Pool{Name: "linux", Driver: hangingDriver{}, Timeouts: types.Timeouts{CreateSecs: 1, DestroySecs: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := m.getPool("linux")
+
+	if _, err = m.createInstance(ctx, pool, &types.InstanceCreateOpts{PoolName: "linux"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the creation to time out, got %v", err)
+	}
+	if err = m.destroyInstances(ctx, pool, []*types.Instance{{ID: "hanging"}}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the destroy to time out, got %v", err)
+	}
+
+	unbounded, cancel := withTimeout(ctx, 0)
+	defer cancel()
+	if _, ok := unbounded.Deadline(); ok {
+		t.Error("expected the operations without a timeout not to be bounded")
+	}
+}
@@ -0,0 +1,47 @@
+package drivers
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/tunnel"
+	"github.com/drone/runner-go/logger"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// StartTunnel starts the tunnel server the instances of the pools with the tunnel dial, if enabled.
+func (m *Manager) StartTunnel(ctx context.Context, env *config.EnvConfig) error {
+	if env.Tunnel.Bind == "" {
+		return nil
+	}
+	return tunnel.Start(ctx, env.Tunnel.Bind, env.Tunnel.Address, env.Tunnel.HostKeyPath, m.TunnelAuthorized)
+}
+
+// TunnelAuthorized reports whether the key is the key of the lite-engine of an instance of a pool
+// which uses the tunnel, the tunnel server only accepts the instances of the runner.
+func (m *Manager) TunnelAuthorized(key ssh.PublicKey) bool {
+	ctx := context.Background()
+	fingerprint := ssh.FingerprintSHA256(key)
+	for _, pool := range m.pools() {
+		if !pool.Tunnel {
+			continue
+		}
+		instances, err := m.instanceStore.List(ctx, pool.Name, nil)
+		if err != nil {
+			logger.FromContext(ctx).WithError(err).
+				WithField("pool", pool.Name).
+				Errorln("manager: failed to list the instances of the tunnel")
+			continue
+		}
+		for _, inst := range instances {
+			if !inst.Tunnel {
+				continue
+			}
+			if f, fingerprintErr := tunnel.Fingerprint(inst.TLSCert); fingerprintErr == nil && f == fingerprint {
+				return true
+			}
+		}
+	}
+	return false
+}
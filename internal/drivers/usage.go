@@ -0,0 +1,228 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	itypes "github.com/drone-runners/drone-runner-aws/internal/types"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// Pool size advices.
+const (
+	UsageIncrease         = "increase"
+	UsageDecrease         = "decrease"
+	UsageKeep             = "keep"
+	UsageInsufficientData = "insufficient_data"
+)
+
+const (
+	// minUsageStages is the number of stages of a window below which no pool size is recommended.
+	minUsageStages = 10
+	// usageHeadroom is the share of the busy instances added on top of the observed ones.
+	usageHeadroom = 1.25
+	// usageSaturation and usageIdle are the average busy shares of the pool, in percents, above
+	// which the pool is too small and below which it is too large.
+	usageSaturation = 80
+	usageIdle       = 20
+)
+
+// DefaultUsageWindows are the windows of the usage of the pools if none are selected.
+var DefaultUsageWindows = []time.Duration{time.Hour, 24 * time.Hour, 7 * 24 * time.Hour}
+
+// PoolUsage is the utilization of a pool over a window ending now and the pool size fitting it,
+// see Manager.Usage.
+type PoolUsage struct {
+	PoolName    string `json:"pool_name"`
+	WindowSecs  int64  `json:"window_secs"`
+	MinSize     int    `json:"min_size"`
+	MaxSize     int    `json:"max_size"`
+	Stages      int    `json:"stages"`
+	SetupFailed int    `json:"setup_failed"`
+	// FailureRatio is the share of the stages whose setup failed.
+	FailureRatio float64 `json:"failure_ratio"`
+	// AvgBusyPct is the average share of the max size of the pool busy running stages, PeakBusy the
+	// highest number of instances busy at once.
+	AvgBusyPct float64 `json:"avg_busy_pct"`
+	PeakBusy   int     `json:"peak_busy"`
+	// the time the stages waited from their request until their instance was ready
+	QueueWaitP50Ms int64 `json:"queue_wait_p50_ms"`
+	QueueWaitP95Ms int64 `json:"queue_wait_p95_ms"`
+	// Advice is increase, decrease, keep or insufficient_data. The recommended max size fits the
+	// peak of the busy instances and the recommended min size the average, with a 25% headroom.
+	Advice             string `json:"advice"`
+	RecommendedMinSize int    `json:"recommended_min_size,omitempty"`
+	RecommendedMaxSize int    `json:"recommended_max_size,omitempty"`
+	Summary            string `json:"summary"`
+}
+
+// poolSize is the size of a pool the usage is measured against.
+type poolSize struct {
+	name             string
+	minSize, maxSize int
+}
+
+// Usage returns the utilization of the pools over each of the windows ending now, from the stages
+// set up within the windows, with the recommended sizes of the pools. All the pools are returned if
+// the pool name is empty.
+func (m *Manager) Usage(ctx context.Context, poolName string, windows []time.Duration) ([]PoolUsage, error) {
+	var sizes []poolSize
+	for _, pool := range m.pools() {
+		if poolName == "" || pool.Name == poolName {
+			sizes = append(sizes, poolSize{name: pool.Name, minSize: pool.MinSize, maxSize: pool.MaxSize})
+		}
+	}
+	if poolName != "" && len(sizes) == 0 {
+		return nil, itypes.NewNotFoundError(fmt.Sprintf("pool %q not found", poolName))
+	}
+	if m.stageRecords == nil || len(windows) == 0 {
+		return []PoolUsage{}, nil
+	}
+
+	longest := windows[0]
+	for _, w := range windows {
+		if w > longest {
+			longest = w
+		}
+	}
+	now := time.Now()
+	records, err := m.stageRecords.List(ctx, &types.StageRecordQuery{PoolName: poolName, Since: now.Add(-longest).Unix()})
+	if err != nil {
+		return nil, err
+	}
+	return summarizeUsage(records, sizes, windows, now), nil
+}
+
+// summarizeUsage measures the usage of the pools over the windows ending at the given time,
+// ordered by pool and window.
+func summarizeUsage(records []*types.StageRecord, sizes []poolSize, windows []time.Duration, now time.Time) []PoolUsage {
+	byPool := map[string][]*types.StageRecord{}
+	for _, r := range records {
+		byPool[r.PoolName] = append(byPool[r.PoolName], r)
+	}
+	out := make([]PoolUsage, 0, len(sizes)*len(windows))
+	for _, size := range sizes {
+		for _, window := range windows {
+			out = append(out, measureUsage(byPool[size.name], size, window, now))
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].PoolName != out[j].PoolName {
+			return out[i].PoolName < out[j].PoolName
+		}
+		return out[i].WindowSecs < out[j].WindowSecs
+	})
+	return out
+}
+
+// measureUsage measures the usage of a pool over a window from the stages set up within it. The
+// instances of the stages are busy from the request of the stage until their destroy, or until now
+// if the stage is still running.
+func measureUsage(records []*types.StageRecord, size poolSize, window time.Duration, now time.Time) PoolUsage {
+	u := PoolUsage{PoolName: size.name, WindowSecs: int64(window.Seconds()), MinSize: size.minSize, MaxSize: size.maxSize}
+	start := now.Add(-window)
+
+	type edge struct {
+		at    int64
+		delta int
+	}
+	var edges []edge
+	var waits []int64
+	var busyMs int64
+	for _, r := range records {
+		started := time.Unix(r.Started, 0)
+		if started.Before(start) {
+			continue
+		}
+		u.Stages++
+		var end time.Time
+		switch r.Result {
+		case types.StageSetupFailed:
+			u.SetupFailed++
+			continue
+		case types.StageRunning:
+			end = now
+		default:
+			end = started.Add(time.Duration(r.RunMs) * time.Millisecond)
+		}
+		if end.After(now) {
+			end = now
+		}
+		waits = append(waits, r.BootMs)
+		busyMs += end.Sub(started).Milliseconds()
+		edges = append(edges, edge{at: started.UnixMilli(), delta: 1}, edge{at: end.UnixMilli(), delta: -1})
+	}
+
+	// the instances freed at a time are freed before the ones taken at the same time are counted
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].at != edges[j].at {
+			return edges[i].at < edges[j].at
+		}
+		return edges[i].delta < edges[j].delta
+	})
+	busy := 0
+	for _, e := range edges {
+		busy += e.delta
+		if busy > u.PeakBusy {
+			u.PeakBusy = busy
+		}
+	}
+
+	if u.Stages > 0 {
+		u.FailureRatio = float64(u.SetupFailed) / float64(u.Stages)
+	}
+	if u.MaxSize > 0 && window > 0 {
+		u.AvgBusyPct = float64(busyMs) / float64(window.Milliseconds()*int64(u.MaxSize)) * 100 //nolint:gomnd
+	}
+	u.QueueWaitP50Ms = percentile(waits, 0.5)  //nolint:gomnd
+	u.QueueWaitP95Ms = percentile(waits, 0.95) //nolint:gomnd
+	u.advise()
+	return u
+}
+
+// advise sets the advice, the recommended sizes and the summary of the usage.
+func (u *PoolUsage) advise() {
+	u.Summary = fmt.Sprintf("%d stages in %s, %.0f%% of the %d instances busy on average and %d at most, "+
+		"a wait of %s (p95 %s) for an instance, %.0f%% of the setups failed",
+		u.Stages, time.Duration(u.WindowSecs)*time.Second, u.AvgBusyPct, u.MaxSize, u.PeakBusy,
+		time.Duration(u.QueueWaitP50Ms)*time.Millisecond, time.Duration(u.QueueWaitP95Ms)*time.Millisecond,
+		u.FailureRatio*100) //nolint:gomnd
+	if u.Stages < minUsageStages || u.MaxSize == 0 {
+		u.Advice = UsageInsufficientData
+		u.Summary += fmt.Sprintf(", at least %d stages are needed for a recommendation", minUsageStages)
+		return
+	}
+
+	avgBusy := u.AvgBusyPct / 100 * float64(u.MaxSize) //nolint:gomnd
+	switch {
+	case u.PeakBusy >= u.MaxSize || u.AvgBusyPct >= usageSaturation:
+		// the pool was full, the stages needing more instances are not seen
+		u.Advice = UsageIncrease
+		u.RecommendedMaxSize = int(math.Ceil(float64(u.MaxSize) * usageHeadroom))
+		if u.RecommendedMaxSize == u.MaxSize {
+			u.RecommendedMaxSize++
+		}
+	case u.AvgBusyPct < usageIdle && int(math.Ceil(float64(u.PeakBusy)*usageHeadroom)) < u.MaxSize:
+		u.Advice = UsageDecrease
+		u.RecommendedMaxSize = int(math.Ceil(float64(u.PeakBusy) * usageHeadroom))
+	default:
+		u.Advice = UsageKeep
+		u.RecommendedMaxSize = u.MaxSize
+	}
+	if u.RecommendedMaxSize < 1 {
+		u.RecommendedMaxSize = 1
+	}
+	u.RecommendedMinSize = int(math.Ceil(avgBusy * usageHeadroom))
+	if u.RecommendedMinSize > u.RecommendedMaxSize {
+		u.RecommendedMinSize = u.RecommendedMaxSize
+	}
+	if u.Advice != UsageKeep {
+		u.Summary += fmt.Sprintf(", %s the max size to %d", u.Advice, u.RecommendedMaxSize)
+	}
+	if u.RecommendedMinSize != u.MinSize {
+		u.Summary += fmt.Sprintf(", keep %d instances free", u.RecommendedMinSize)
+	}
+}
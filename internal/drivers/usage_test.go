@@ -0,0 +1,68 @@
+package drivers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestSummarizeUsage(t *testing.T) {
+	now := time.Unix(100000, 0)
+	ago := func(d time.Duration) int64 { return now.Add(-d).Unix() }
+
+	var records []*types.StageRecord
+	for i := 0; i < 12; i++ {
+		// the busy pool runs 4 stages of 20 minutes at once for the whole hour
+		records = append(records,
+			&types.StageRecord{PoolName: "busy", Started: ago(time.Duration(i%3+1) * 20 * time.Minute), RunMs: (20 * time.Minute).Milliseconds(),
+				BootMs: 1000, Result: types.StageCompleted},
+		)
+		// the idle pool runs one stage of 6 minutes at a time
+		if i >= 10 {
+			continue
+		}
+		records = append(records,
+			&types.StageRecord{PoolName: "idle", Started: ago(time.Duration(i+1) * 6 * time.Minute), RunMs: (6 * time.Minute).Milliseconds(),
+				BootMs: int64(i) * 100, Result: types.StageCompleted},
+		)
+	}
+	records = append(records,
+		&types.StageRecord{PoolName: "idle", Started: ago(time.Minute), Result: types.StageSetupFailed},
+		// out of the hour window
+		&types.StageRecord{PoolName: "idle", Started: ago(2 * time.Hour), RunMs: 1000, Result: types.StageCompleted},
+		&types.StageRecord{PoolName: "new", Started: ago(time.Minute), Result: types.StageRunning},
+	)
+	sizes := []poolSize{{name: "idle", minSize: 2, maxSize: 10}, {name: "busy", minSize: 1, maxSize: 4}, {name: "new", maxSize: 2}}
+
+	got := summarizeUsage(records, sizes, []time.Duration{24 * time.Hour, time.Hour}, now)
+	if len(got) != 6 {
+		t.Fatalf("got %d usages, want 6: %+v", len(got), got)
+	}
+
+	busy := got[0]
+	if busy.PoolName != "busy" || busy.WindowSecs != 3600 || busy.PeakBusy != 4 || busy.AvgBusyPct != 100 {
+		t.Errorf("got %+v, want the busy pool over the hour", busy)
+	}
+	if busy.Advice != UsageIncrease || busy.RecommendedMaxSize != 5 || busy.RecommendedMinSize != 5 {
+		t.Errorf("got %+v, want the max size of the busy pool increased", busy)
+	}
+
+	idle := got[2]
+	if idle.PoolName != "idle" || idle.Stages != 11 || idle.SetupFailed != 1 || idle.PeakBusy != 1 || idle.AvgBusyPct != 10 {
+		t.Errorf("got %+v, want the idle pool over the hour", idle)
+	}
+	if idle.QueueWaitP50Ms != 400 || idle.QueueWaitP95Ms != 900 {
+		t.Errorf("got the waits %d and %d, want 400 and 900", idle.QueueWaitP50Ms, idle.QueueWaitP95Ms)
+	}
+	if idle.Advice != UsageDecrease || idle.RecommendedMaxSize != 2 || idle.RecommendedMinSize != 2 {
+		t.Errorf("got %+v, want the max size of the idle pool decreased", idle)
+	}
+	if got[3].Stages != 12 {
+		t.Errorf("got %d stages in the day, want 12", got[3].Stages)
+	}
+
+	if running := got[4]; running.PoolName != "new" || running.PeakBusy != 1 || running.Advice != UsageInsufficientData {
+		t.Errorf("got %+v, want insufficient data for the running stage", running)
+	}
+}
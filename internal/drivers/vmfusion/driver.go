@@ -84,11 +84,14 @@ func (p *config) CanHibernate() bool {
 }
 
 func (p *config) Logs(ctx context.Context, instance string) (string, error) {
-	return "", errors.New("Unimplemented")
+	return "", drivers.ErrNotSupported
 }
 
 func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (instance *types.Instance, err error) {
-	uData := lehelper.GenerateUserdata(p.userData, opts)
+	uData, err := lehelper.GenerateUserdata(p.userData, opts)
+	if err != nil {
+		return nil, err
+	}
 	machineName := fmt.Sprintf(opts.RunnerName+"-"+"-%d", time.Now().Unix())
 
 	p.MachineName = machineName
@@ -191,21 +194,22 @@ func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 	startTime := time.Now()
 
 	instance = &types.Instance{
-		ID:       p.vmxPath(),
-		Name:     machineName,
-		Provider: types.VMFusion, // this is driver, though its the old legacy name of provider
-		State:    types.StateCreated,
-		Pool:     opts.PoolName,
-		Image:    p.ISO,
-		Platform: opts.Platform,
-		Address:  instanceIP,
-		CACert:   opts.CACert,
-		CAKey:    opts.CAKey,
-		TLSCert:  opts.TLSCert,
-		TLSKey:   opts.TLSKey,
-		Started:  startTime.Unix(),
-		Updated:  time.Now().Unix(),
-		Port:     lehelper.LiteEnginePort,
+		ID:         p.vmxPath(),
+		Name:       machineName,
+		ProviderID: p.vmxPath(),
+		Provider:   types.VMFusion, // this is driver, though its the old legacy name of provider
+		State:      types.StateCreated,
+		Pool:       opts.PoolName,
+		Image:      p.ISO,
+		Platform:   opts.Platform,
+		Address:    instanceIP,
+		CACert:     opts.CACert,
+		CAKey:      opts.CAKey,
+		TLSCert:    opts.TLSCert,
+		TLSKey:     opts.TLSKey,
+		Started:    startTime.Unix(),
+		Updated:    time.Now().Unix(),
+		Port:       int64(opts.LiteEnginePort),
 	}
 	logr.
 		WithField("ip", instanceIP).
@@ -240,16 +244,16 @@ func (p *config) Destroy(ctx context.Context, instances []*types.Instance) (err
 }
 
 func (p *config) Hibernate(_ context.Context, _, _ string) error {
-	return errors.New("unimplemented")
+	return drivers.ErrNotSupported
 }
 
 func (p *config) Start(_ context.Context, _, _ string) (string, error) {
-	return "", errors.New("unimplemented")
+	return "", drivers.ErrNotSupported
 }
 
 func (p *config) SetTags(ctx context.Context, instance *types.Instance,
 	tags map[string]string) error {
-	return nil
+	return drivers.ErrNotSupported
 }
 
 func commandCopyFileToGuest(ctx context.Context, src, dest, username, password, path string) *exec.Cmd {
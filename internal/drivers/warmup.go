@@ -0,0 +1,59 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// PoolWarmUp is the progress of the warm-up of a pool: how many of the instances the pool keeps
+// around are up, out of its min size. A pool is warm once it reached its min size, it stays warm
+// when its instances are later taken or destroyed so the readiness of the runner does not flap
+// under load. The pools asleep or draining have nothing to warm up.
+type PoolWarmUp struct {
+	Target    int  `json:"target"`
+	Instances int  `json:"instances"`
+	Warm      bool `json:"warm"`
+}
+
+// warmUp counts the instances of the pool created, busy or hibernating, the overflow instances
+// are not part of the pool.
+func (m *Manager) warmUp(ctx context.Context, pool *poolEntry) (PoolWarmUp, error) {
+	w := PoolWarmUp{Target: pool.MinSize}
+	if pool.draining.Load() || pool.asleep() {
+		w.Target = 0
+	}
+	if m.instanceStore == nil {
+		return w, fmt.Errorf("database: the store is not set up")
+	}
+	list, err := m.instanceStore.List(ctx, pool.Name, nil)
+	if err != nil {
+		return w, err
+	}
+	for _, inst := range list {
+		if inst.Overflow || inst.State == types.StateTerminating {
+			continue
+		}
+		w.Instances++
+	}
+	if w.Instances >= w.Target {
+		pool.warm.Store(true)
+	}
+	w.Warm = pool.warm.Load()
+	return w, nil
+}
+
+// checkWarmUp fails while a pool is warming up.
+func (m *Manager) checkWarmUp(ctx context.Context) error {
+	for _, pool := range m.pools() {
+		w, err := m.warmUp(ctx, pool)
+		if err != nil {
+			return fmt.Errorf("pool %q: %w", pool.Name, err)
+		}
+		if !w.Warm {
+			return fmt.Errorf("pool %q: warming up, %d of %d instances", pool.Name, w.Instances, w.Target)
+		}
+	}
+	return nil
+}
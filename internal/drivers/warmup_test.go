@@ -0,0 +1,70 @@
+package drivers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestWarmUp(t *testing.T) {
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	instanceStore := ldb.NewInstanceStore(db)
+	env := &config.EnvConfig{}
+	env.Settings.ReadinessWarmPools = true
+	m := New(ctx, instanceStore, env)
+	if err = m.Add(
+		Pool{Name: "linux", MinSize: 2, MaxSize: 4, Driver: failingDriver{}},
+		Pool{Name: "windows", MaxSize: 1, Driver: failingDriver{}},
+	); err != nil {
+		t.Fatal(err)
+	}
+	for _, inst := range []*types.Instance{
+		{ID: "free", Pool: "linux", State: types.StateCreated},
+		{ID: "overflow", Pool: "linux", State: types.StateInUse, Overflow: true},
+		{ID: "terminating", Pool: "linux", State: types.StateTerminating},
+	} {
+		if err = instanceStore.Create(ctx, inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = m.Ready(ctx); err == nil || !strings.Contains(err.Error(), `pool "linux": warming up, 1 of 2 instances`) {
+		t.Fatalf("expected the readiness to wait for the linux pool, got %v", err)
+	}
+	pools := m.PoolsHealth(ctx)
+	if w := pools[0].WarmUp; w == nil || *w != (PoolWarmUp{Target: 2, Instances: 1}) {
+		t.Errorf("expected the linux pool warming up, got %+v", w)
+	}
+	if w := pools[1].WarmUp; w == nil || !w.Warm {
+		t.Errorf("expected the windows pool without min size to be warm, got %+v", w)
+	}
+
+	if err = instanceStore.Create(ctx, &types.Instance{ID: "busy", Pool: "linux", State: types.StateInUse}); err != nil {
+		t.Fatal(err)
+	}
+	m.readiness.checked = time.Time{}
+	if err = m.Ready(ctx); err != nil {
+		t.Fatalf("expected the manager to be ready once the pools are warm, got %s", err)
+	}
+
+	// a warm pool stays warm once its instances are taken or destroyed
+	if err = instanceStore.Delete(ctx, "busy"); err != nil {
+		t.Fatal(err)
+	}
+	m.readiness.checked = time.Time{}
+	if err = m.Ready(ctx); err != nil {
+		t.Errorf("expected the manager to stay ready, got %s", err)
+	}
+}
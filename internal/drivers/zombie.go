@@ -0,0 +1,308 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/alert"
+	"github.com/drone-runners/drone-runner-aws/internal/events"
+	"github.com/drone-runners/drone-runner-aws/internal/lehelper"
+	"github.com/drone-runners/drone-runner-aws/internal/route"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	zombieProbeTimeout     = 10 * time.Second // bounds a probe of the lite-engine of a busy instance
+	zombieProbeConcurrency = 8                // the instances of a pool probed at once
+	zombieConsoleTail      = 4096             // the end of the console output of an unreachable instance which is logged
+)
+
+// zombies counts the probes the lite-engines of the busy instances missed in a row, by instance.
+type zombies struct {
+	mu     sync.Mutex
+	missed map[string]int
+	pools  map[string]string // the pools of the instances
+}
+
+// record records the outcome of a probe of the instance and returns the probes it missed in a row.
+func (z *zombies) record(pool, instanceID string, ok bool) int {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.missed == nil {
+		z.missed, z.pools = map[string]int{}, map[string]string{}
+	}
+	if ok {
+		delete(z.missed, instanceID)
+		delete(z.pools, instanceID)
+		return 0
+	}
+	z.missed[instanceID]++
+	z.pools[instanceID] = pool
+	return z.missed[instanceID]
+}
+
+// forget drops the counts of the instances of the pool which are no longer busy.
+func (z *zombies) forget(pool string, busy map[string]bool) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	for id, p := range z.pools {
+		if p == pool && !busy[id] {
+			delete(z.missed, id)
+			delete(z.pools, id)
+		}
+	}
+}
+
+// steps holds the steps running on the instances, they are interrupted once their instance is
+// recycled as unreachable.
+type steps struct {
+	mu      sync.Mutex
+	running map[string]map[*trackedStep]struct{}
+}
+
+type trackedStep struct {
+	cancel context.CancelFunc
+	reason atomic.Value // the reason the step was interrupted
+}
+
+type trackedStepKey struct{}
+
+// TrackStep returns a context for a step running on the instance which is cancelled if the
+// instance is recycled as unreachable, see StartZombieDetector. done must be called once the step
+// completes.
+func (m *Manager) TrackStep(ctx context.Context, instanceID string) (stepCtx context.Context, done func()) {
+	step := &trackedStep{}
+	stepCtx, step.cancel = context.WithCancel(context.WithValue(ctx, trackedStepKey{}, step))
+
+	m.steps.mu.Lock()
+	defer m.steps.mu.Unlock()
+	if m.steps.running == nil {
+		m.steps.running = map[string]map[*trackedStep]struct{}{}
+	}
+	if m.steps.running[instanceID] == nil {
+		m.steps.running[instanceID] = map[*trackedStep]struct{}{}
+	}
+	m.steps.running[instanceID][step] = struct{}{}
+	return stepCtx, func() {
+		m.steps.mu.Lock()
+		defer m.steps.mu.Unlock()
+		delete(m.steps.running[instanceID], step)
+		if len(m.steps.running[instanceID]) == 0 {
+			delete(m.steps.running, instanceID)
+		}
+		step.cancel()
+	}
+}
+
+// StepInterruption returns the reason the step of the context was interrupted by the manager,
+// empty if it was not.
+func StepInterruption(stepCtx context.Context) string {
+	step, ok := stepCtx.Value(trackedStepKey{}).(*trackedStep)
+	if !ok {
+		return ""
+	}
+	reason, _ := step.reason.Load().(string)
+	return reason
+}
+
+// interruptSteps cancels the steps running on the instance.
+func (m *Manager) interruptSteps(instanceID, reason string) {
+	m.steps.mu.Lock()
+	defer m.steps.mu.Unlock()
+	for step := range m.steps.running[instanceID] {
+		step.reason.Store(reason)
+		step.cancel()
+	}
+}
+
+// StartZombieDetector periodically probes the lite-engines of the busy instances, the instances
+// whose stage is set up. An instance whose lite-engine misses failures probes in a row is reported
+// as unreachable: an instance.unreachable event is published, an alert is raised and its console
+// output and the diagnosis of its network are logged. The notice is stored as its maintenance, so
+// its failing steps are reported as retryable. With recycle the steps running on the instance are
+// interrupted and the instance is destroyed.
+func (m *Manager) StartZombieDetector(ctx context.Context, interval time.Duration, failures int, recycle bool) {
+	if interval <= 0 {
+		return
+	}
+
+	logrus.Infof("Zombie detector started. It will run every %.2f minutes", interval.Minutes())
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !m.IsLeader() {
+					continue
+				}
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							logrus.Errorf("PANIC %v\n%s", r, debug.Stack())
+						}
+					}()
+					m.checkZombies(ctx, failures, recycle)
+				}()
+			}
+		}
+	}()
+}
+
+func (m *Manager) checkZombies(ctx context.Context, failures int, recycle bool) {
+	for _, pool := range m.pools() {
+		if err := m.checkPoolZombies(ctx, pool, failures, recycle); err != nil {
+			logrus.WithError(err).WithField("pool", pool.Name).
+				Errorln("zombie: failed to probe the busy instances")
+		}
+	}
+}
+
+// checkPoolZombies probes the busy instances of the pool, see StartZombieDetector.
+func (m *Manager) checkPoolZombies(ctx context.Context, pool *poolEntry, failures int, recycle bool) error {
+	busy, _, _, err := m.List(ctx, pool)
+	if err != nil {
+		return fmt.Errorf("failed to list the instances: %w", err)
+	}
+	probed := map[string]bool{}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, zombieProbeConcurrency)
+	for _, inst := range busy {
+		// the instances being set up are checked by their setup
+		if inst.State != types.StateInUse || inst.Stage == "" || inst.Claimed != 0 || inst.IsHibernated {
+			continue
+		}
+		probed[inst.ID] = true
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(inst *types.Instance) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			probeErr := m.probe(ctx, inst)
+			// the instance is reported once, when it reaches the missed probes
+			if m.zombies.record(pool.Name, inst.ID, probeErr == nil) == failures {
+				m.reportZombie(ctx, pool, inst, failures, probeErr, recycle)
+			}
+		}(inst)
+	}
+	wg.Wait()
+	m.zombies.forget(pool.Name, probed)
+	return nil
+}
+
+// probe checks the lite-engine of the instance responds to the health check.
+func (m *Manager) probe(ctx context.Context, inst *types.Instance) error {
+	port := inst.Port
+	if port == 0 {
+		port = lehelper.LiteEnginePort
+	}
+	client, err := lehelper.GetClient(inst, m.runnerName, port, false, 0)
+	if err != nil {
+		return err
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, zombieProbeTimeout)
+	defer cancel()
+	resp, err := client.Health(probeCtx)
+	if err != nil {
+		return err
+	}
+	if !resp.OK {
+		return errors.New("the lite-engine is not healthy")
+	}
+	return nil
+}
+
+// reportZombie reports the unreachable instance, see StartZombieDetector.
+func (m *Manager) reportZombie(ctx context.Context, pool *poolEntry, inst *types.Instance, failures int, probeErr error, recycle bool) {
+	logr := logrus.WithField("pool", pool.Name).
+		WithField("instance_id", inst.ID).
+		WithField("provider_id", inst.ProviderID).
+		WithField("stage", inst.Stage)
+	notice := fmt.Sprintf("unreachable: the lite-engine missed %d probes: %s", failures, probeErr)
+
+	console, findings := m.zombieDiagnostics(ctx, pool, inst)
+	if console != "" {
+		logr.WithField("console", console).Warnln("zombie: the console output of the unreachable instance")
+	}
+	if len(findings) > 0 {
+		notice += ": " + strings.Join(findings, "; ")
+	}
+	logr.WithField("notice", notice).Errorln("zombie: the lite-engine of the busy instance is unreachable")
+
+	e := events.ForInstance(events.InstanceUnreachable, inst)
+	e.Error = notice
+	m.events.Publish(e)
+	m.alerter.Raise(alert.Alert{
+		Key:      "zombie:" + pool.Name,
+		Severity: alert.SeverityError,
+		Summary:  fmt.Sprintf("busy instances of pool %s are unreachable", pool.Name),
+		Pool:     pool.Name,
+		Error:    notice,
+	})
+
+	if inst.Maintenance == "" {
+		inst.Maintenance = notice
+	} else {
+		inst.Maintenance += "; " + notice
+	}
+	if err := m.instanceStore.Update(ctx, inst); err != nil {
+		logr.WithError(err).Errorln("zombie: failed to store the notice of the unreachable instance")
+	}
+	if !recycle {
+		return
+	}
+
+	logr.Warnln("zombie: interrupting the steps of the unreachable instance and destroying it")
+	m.interruptSteps(inst.ID, notice)
+	pool.Lock()
+	defer pool.Unlock()
+	if err := m.destroyOrRetry(ctx, pool, []*types.Instance{inst}, true); err != nil {
+		logr.WithError(err).Errorln("zombie: failed to destroy the unreachable instance")
+	}
+}
+
+// zombieDiagnostics returns the end of the console output of the unreachable instance and the
+// findings of the diagnosis of its network, for the drivers supporting them.
+func (m *Manager) zombieDiagnostics(ctx context.Context, pool *poolEntry, inst *types.Instance) (console string, findings []string) {
+	driver, err := driverFor(pool, inst)
+	if err != nil {
+		return "", nil
+	}
+	logr := logrus.WithField("pool", pool.Name).WithField("instance_id", inst.ID)
+	if capabilitiesOf(driver).ConsoleLogs {
+		out, logErr := driver.Logs(ctx, inst.ID)
+		if logErr != nil {
+			logr.WithError(logErr).Warnln("zombie: could not get the console output of the unreachable instance")
+		}
+		if len(out) > zombieConsoleTail {
+			out = out[len(out)-zombieConsoleTail:]
+		}
+		console = out
+	}
+	diagnoser, ok := driver.(NetworkDiagnoser)
+	if !ok || inst.Tunnel || (inst.Route != "" && !inst.Route.Direct()) {
+		return console, nil
+	}
+	port := inst.Port
+	if port == 0 {
+		port = lehelper.LiteEnginePort
+	}
+	findings, err = diagnoser.DiagnoseNetwork(ctx, inst, sourceAddress(route.Address(inst), int(port)))
+	if err != nil {
+		logr.WithError(err).Warnln("zombie: could not diagnose the network of the unreachable instance")
+	}
+	return console, findings
+}
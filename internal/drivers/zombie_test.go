@@ -0,0 +1,79 @@
+package drivers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestCheckZombies(t *testing.T) {
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	instanceStore := ldb.NewInstanceStore(db)
+	// the instances have no certificates, their lite-engines cannot be reached
+	for _, inst := range []*types.Instance{
+		{ID: "busy", Pool: "linux", State: types.StateInUse, Stage: "stage-1", Address: "127.0.0.1"},
+		{ID: "setting-up", Pool: "linux", State: types.StateInUse, Stage: "stage-2", Address: "127.0.0.1", Claimed: 1},
+		{ID: "free", Pool: "linux", State: types.StateCreated, Address: "127.0.0.1"},
+	} {
+		if err = instanceStore.Create(ctx, inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := New(ctx, instanceStore, &config.EnvConfig{})
+	if err = m.Add(Pool{Name: "linux", MaxSize: 3, Driver: failingDriver{}}); err != nil {
+		t.Fatal(err)
+	}
+	maintenance := func(id string) string {
+		inst, findErr := instanceStore.Find(ctx, id)
+		if findErr != nil {
+			t.Fatal(findErr)
+		}
+		return inst.Maintenance
+	}
+
+	m.checkZombies(ctx, 2, false)
+	if notice := maintenance("busy"); notice != "" {
+		t.Errorf("expected the instance not to be reported after a missed probe, got %q", notice)
+	}
+	m.checkZombies(ctx, 2, false)
+	notice := maintenance("busy")
+	if !strings.HasPrefix(notice, "unreachable: the lite-engine missed 2 probes") {
+		t.Errorf("expected the notice of the unreachable instance, got %q", notice)
+	}
+	for _, id := range []string{"setting-up", "free"} {
+		if got := maintenance(id); got != "" {
+			t.Errorf("expected %s not to be probed, got %q", id, got)
+		}
+	}
+	// the instance is reported once
+	m.checkZombies(ctx, 2, false)
+	if got := maintenance("busy"); got != notice {
+		t.Errorf("expected the instance to be reported once, got %q", got)
+	}
+
+	// recycled, the steps of the instance are interrupted and the instance is destroyed
+	stepCtx, done := m.TrackStep(ctx, "busy")
+	defer done()
+	m.zombies.record("linux", "busy", true)
+	m.checkZombies(ctx, 1, true)
+	if stepCtx.Err() == nil || !strings.Contains(StepInterruption(stepCtx), "unreachable") {
+		t.Errorf("expected the step to be interrupted, got %v %q", stepCtx.Err(), StepInterruption(stepCtx))
+	}
+	if _, err = instanceStore.Find(ctx, "busy"); err == nil {
+		t.Error("expected the unreachable instance to be destroyed")
+	}
+	if StepInterruption(ctx) != "" {
+		t.Error("expected no interruption outside of a step")
+	}
+}
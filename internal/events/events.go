@@ -0,0 +1,307 @@
+// Package events publishes the lifecycle events of the instances and of the stages of the runner to
+// a message bus, so the downstream systems, e.g. the cost pipelines or the security scanners,
+// follow the activity of the runner without polling its database. The events are published from
+// the background: a slow or unavailable bus never delays the stages, the events are dropped once
+// the buffer is full.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Bus types.
+const (
+	TypeKafka  = "kafka"
+	TypeNATS   = "nats"
+	TypeSQS    = "sqs"
+	TypePubSub = "pubsub"
+)
+
+// Serialization formats of the events.
+const (
+	FormatJSON        = "json"        // the event as is
+	FormatCloudEvents = "cloudevents" // the event as the data of a structured CloudEvent 1.0
+)
+
+// Event types.
+const (
+	InstanceCreated      = "instance.created"
+	InstanceCreateFailed = "instance.create_failed"
+	InstanceHibernated   = "instance.hibernated"
+	InstanceStarted      = "instance.started"
+	InstanceResized      = "instance.resized"
+	InstanceDestroyed    = "instance.destroyed"
+	InstanceMaintenance  = "instance.maintenance"
+	InstanceUnreachable  = "instance.unreachable"
+	StageSetup           = "stage.setup"
+	StageSetupFailed     = "stage.setup_failed"
+	StageCompleted       = "stage.completed"
+)
+
+const (
+	defaultBuffer  = 1000
+	publishTimeout = 10 * time.Second
+	closeTimeout   = 30 * time.Second
+)
+
+// Event is a lifecycle event of an instance or of a stage.
+type Event struct {
+	ID             string    `json:"id"`
+	Type           string    `json:"type"`
+	Time           time.Time `json:"time"`
+	Runner         string    `json:"runner"`
+	Pool           string    `json:"pool,omitempty"`
+	InstanceID     string    `json:"instance_id,omitempty"`
+	ProviderID     string    `json:"provider_id,omitempty"`
+	Driver         string    `json:"driver,omitempty"`
+	Region         string    `json:"region,omitempty"`
+	Zone           string    `json:"zone,omitempty"`
+	Size           string    `json:"size,omitempty"`
+	OS             string    `json:"os,omitempty"`
+	Arch           string    `json:"arch,omitempty"`
+	Started        int64     `json:"started,omitempty"` // the unix time the instance was started
+	StageRuntimeID string    `json:"stage_runtime_id,omitempty"`
+	AccountID      string    `json:"account_id,omitempty"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// ForInstance returns the event of the instance, with the stage of the instance if it is in use.
+func ForInstance(eventType string, inst *types.Instance) *Event {
+	return &Event{
+		Type:           eventType,
+		Pool:           inst.Pool,
+		InstanceID:     inst.ID,
+		ProviderID:     inst.ProviderID,
+		Driver:         string(inst.Provider),
+		Region:         inst.Region,
+		Zone:           inst.Zone,
+		Size:           inst.Size,
+		OS:             inst.Platform.OS,
+		Arch:           inst.Platform.Arch,
+		Started:        inst.Started,
+		StageRuntimeID: inst.Stage,
+		AccountID:      inst.AccountID,
+	}
+}
+
+// Message is an event serialized for a bus.
+type Message struct {
+	Topic      string
+	Key        string // the events of an instance, or else of a stage, share their key
+	ID         string
+	Data       []byte
+	Attributes map[string]string
+}
+
+// Publisher sends the messages to a bus.
+type Publisher interface {
+	Publish(ctx context.Context, m *Message) error
+	Close() error
+}
+
+// Config configures the bus of the events.
+type Config struct {
+	Runner   string
+	Type     string // kafka, nats, sqs or pubsub
+	Endpoint string // the brokers of kafka, comma separated, or the url of nats
+	Topic    string // the topic of kafka or of pubsub, the subject of nats or the queue url of sqs
+	Format   string // json if empty
+	Region   string // the region of the sqs queue
+	Buffer   int    // the events waiting to be published, 1000 if zero
+}
+
+// Bus publishes the events from the background.
+type Bus struct {
+	runner    string
+	topic     string
+	format    string
+	publisher Publisher
+	queue     chan *Event
+	dropped   atomic.Int64
+}
+
+// New returns the bus of the config, nil if no type is set.
+func New(ctx context.Context, cfg *Config) (*Bus, error) {
+	var publisher Publisher
+	var err error
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case TypeKafka:
+		publisher, err = newKafka(cfg.Endpoint)
+	case TypeNATS:
+		publisher, err = newNATS(cfg.Endpoint)
+	case TypeSQS:
+		publisher, err = newSQS(cfg.Region)
+	case TypePubSub:
+		publisher, err = newPubSub(ctx)
+	default:
+		return nil, fmt.Errorf("events: unknown bus %q", cfg.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newBus(cfg, publisher), nil
+}
+
+func newBus(cfg *Config, publisher Publisher) *Bus {
+	b := &Bus{
+		runner:    cfg.Runner,
+		topic:     cfg.Topic,
+		format:    cfg.Format,
+		publisher: publisher,
+	}
+	if b.format == "" {
+		b.format = FormatJSON
+	}
+	size := cfg.Buffer
+	if size <= 0 {
+		size = defaultBuffer
+	}
+	b.queue = make(chan *Event, size)
+	return b
+}
+
+// Publish queues the event, it's dropped if the buffer is full. It's a no-op on a nil bus.
+func (b *Bus) Publish(e *Event) {
+	if b == nil {
+		return
+	}
+	if e.ID == "" {
+		e.ID = uuid.NewString()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	e.Runner = b.runner
+	select {
+	case b.queue <- e:
+	default:
+		if n := b.dropped.Add(1); n == 1 || n%100 == 0 {
+			logrus.WithField("dropped", n).Warnln("events: the buffer is full, dropping the events")
+		}
+	}
+}
+
+// Start publishes the queued events until the context is done, the events queued then are
+// published before the publisher is closed.
+func (b *Bus) Start(ctx context.Context) {
+	if b == nil {
+		return
+	}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logrus.Errorf("events: recovered from panic: %v", r)
+			}
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				b.drain()
+				return
+			case e := <-b.queue:
+				b.send(context.Background(), e)
+			}
+		}
+	}()
+}
+
+func (b *Bus) drain() {
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	for {
+		select {
+		case e := <-b.queue:
+			if ctx.Err() == nil {
+				b.send(ctx, e)
+			}
+		default:
+			if err := b.publisher.Close(); err != nil {
+				logrus.WithError(err).Warnln("events: could not close the publisher")
+			}
+			return
+		}
+	}
+}
+
+func (b *Bus) send(ctx context.Context, e *Event) {
+	m, err := b.message(e)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+		err = b.publisher.Publish(ctx, m)
+		cancel()
+	}
+	if err != nil {
+		logrus.WithError(err).
+			WithField("event_id", e.ID).
+			WithField("event_type", e.Type).
+			Errorln("events: could not publish the event")
+	}
+}
+
+// message serializes the event in the format of the bus. The {type} of the topic is replaced with
+// the type of the event, e.g. runner.{type} publishes the events of every type to their topic.
+func (b *Bus) message(e *Event) (*Message, error) {
+	var data []byte
+	var err error
+	contentType := "application/json"
+	switch b.format {
+	case FormatCloudEvents:
+		data, err = json.Marshal(&cloudEvent{
+			SpecVersion:     "1.0",
+			ID:              e.ID,
+			Source:          "drone-runner-aws/" + e.Runner,
+			Type:            "drone.runner." + e.Type,
+			Subject:         subject(e),
+			Time:            e.Time,
+			DataContentType: contentType,
+			Data:            e,
+		})
+		contentType = "application/cloudevents+json"
+	default:
+		data, err = json.Marshal(e)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("events: could not serialize the event: %w", err)
+	}
+	return &Message{
+		Topic: strings.ReplaceAll(b.topic, "{type}", e.Type),
+		Key:   subject(e),
+		ID:    e.ID,
+		Data:  data,
+		Attributes: map[string]string{
+			"type":         e.Type,
+			"content-type": contentType,
+		},
+	}, nil
+}
+
+// subject returns the instance of the event, or else its stage.
+func subject(e *Event) string {
+	if e.InstanceID != "" {
+		return e.InstanceID
+	}
+	return e.StageRuntimeID
+}
+
+type cloudEvent struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject,omitempty"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            *Event    `json:"data"`
+}
@@ -0,0 +1,127 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// memPublisher keeps the messages published.
+type memPublisher struct {
+	mu       sync.Mutex
+	messages []*Message
+	closed   bool
+}
+
+func (p *memPublisher) Publish(_ context.Context, m *Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.messages = append(p.messages, m)
+	return nil
+}
+
+func (p *memPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+func TestBusPublishes(t *testing.T) {
+	p := &memPublisher{}
+	b := newBus(&Config{Runner: "runner-1", Topic: "runner.{type}"}, p)
+	ctx, cancel := context.WithCancel(context.Background())
+	b.Start(ctx)
+
+	inst := &types.Instance{ID: "i-1", Pool: "linux", Provider: types.Amazon, Size: "t3.large", Stage: "stage-1", AccountID: "acct"}
+	b.Publish(ForInstance(InstanceCreated, inst))
+	b.Publish(&Event{Type: StageSetupFailed, Pool: "linux", StageRuntimeID: "stage-2", Error: "no capacity"})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p.mu.Lock()
+		n := len(p.messages)
+		p.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 2 messages, got %d", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	created := p.messages[0]
+	if created.Topic != "runner.instance.created" || created.Key != "i-1" || created.Attributes["type"] != InstanceCreated {
+		t.Errorf("unexpected message %+v", created)
+	}
+	var e Event
+	if err := json.Unmarshal(created.Data, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.ID == "" || e.ID != created.ID || e.Time.IsZero() || e.Runner != "runner-1" || e.Size != "t3.large" || e.StageRuntimeID != "stage-1" {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if failed := p.messages[1]; failed.Key != "stage-2" || failed.Topic != "runner.stage.setup_failed" {
+		t.Errorf("unexpected message %+v", failed)
+	}
+
+	cancel()
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		p.mu.Lock()
+		closed := p.closed
+		p.mu.Unlock()
+		if closed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the publisher to be closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCloudEvents(t *testing.T) {
+	b := newBus(&Config{Runner: "runner-1", Topic: "events", Format: FormatCloudEvents}, &memPublisher{})
+	e := &Event{ID: "1", Type: StageCompleted, Time: time.Now().UTC(), Runner: "runner-1", StageRuntimeID: "stage-1"}
+	m, err := b.message(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ce struct {
+		SpecVersion string `json:"specversion"`
+		Type        string `json:"type"`
+		Source      string `json:"source"`
+		Subject     string `json:"subject"`
+		Data        Event  `json:"data"`
+	}
+	if err = json.Unmarshal(m.Data, &ce); err != nil {
+		t.Fatal(err)
+	}
+	if ce.SpecVersion != "1.0" || ce.Type != "drone.runner.stage.completed" || ce.Source != "drone-runner-aws/runner-1" ||
+		ce.Subject != "stage-1" || ce.Data.StageRuntimeID != "stage-1" {
+		t.Errorf("unexpected cloud event %+v", ce)
+	}
+	if m.Attributes["content-type"] != "application/cloudevents+json" {
+		t.Errorf("unexpected content type %q", m.Attributes["content-type"])
+	}
+}
+
+func TestBusDropsWhenFull(t *testing.T) {
+	p := &memPublisher{}
+	b := newBus(&Config{Buffer: 1}, p)
+	b.Publish(&Event{Type: InstanceCreated})
+	b.Publish(&Event{Type: InstanceDestroyed})
+	if got := b.dropped.Load(); got != 1 {
+		t.Errorf("expected 1 event dropped, got %d", got)
+	}
+
+	var nilBus *Bus
+	nilBus.Publish(&Event{Type: InstanceCreated})
+	nilBus.Start(context.Background())
+}
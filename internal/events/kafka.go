@@ -0,0 +1,50 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaPublisher produces the messages to the topics of the brokers, keyed by instance or by
+// stage so their events are ordered within a partition.
+type kafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+func newKafka(endpoint string) (Publisher, error) {
+	var brokers []string
+	for _, b := range strings.Split(endpoint, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			brokers = append(brokers, b)
+		}
+	}
+	if len(brokers) == 0 {
+		return nil, errors.New("events: the brokers of kafka are not set")
+	}
+	return &kafkaPublisher{writer: &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+	}}, nil
+}
+
+func (p *kafkaPublisher) Publish(ctx context.Context, m *Message) error {
+	headers := make([]kafka.Header, 0, len(m.Attributes)+1)
+	headers = append(headers, kafka.Header{Key: "id", Value: []byte(m.ID)})
+	for k, v := range m.Attributes {
+		headers = append(headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+	return p.writer.WriteMessages(ctx, kafka.Message{
+		Topic:   m.Topic,
+		Key:     []byte(m.Key),
+		Value:   m.Data,
+		Headers: headers,
+	})
+}
+
+func (p *kafkaPublisher) Close() error {
+	return p.writer.Close()
+}
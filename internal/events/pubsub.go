@@ -0,0 +1,41 @@
+package events
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"google.golang.org/api/pubsub/v1"
+)
+
+// pubsubPublisher publishes the messages to the topic, projects/<project>/topics/<topic>, with the
+// application default credentials.
+type pubsubPublisher struct {
+	service *pubsub.Service
+}
+
+func newPubSub(ctx context.Context) (Publisher, error) {
+	service, err := pubsub.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("events: could not create the pubsub client: %w", err)
+	}
+	return &pubsubPublisher{service: service}, nil
+}
+
+func (p *pubsubPublisher) Publish(ctx context.Context, m *Message) error {
+	attributes := map[string]string{"id": m.ID, "key": m.Key}
+	for k, v := range m.Attributes {
+		attributes[k] = v
+	}
+	_, err := p.service.Projects.Topics.Publish(m.Topic, &pubsub.PublishRequest{
+		Messages: []*pubsub.PubsubMessage{{
+			Data:       base64.StdEncoding.EncodeToString(m.Data),
+			Attributes: attributes,
+		}},
+	}).Context(ctx).Do()
+	return err
+}
+
+func (p *pubsubPublisher) Close() error {
+	return nil
+}
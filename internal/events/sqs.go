@@ -0,0 +1,50 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// sqsPublisher sends the messages to the queue of the topic, its url, with the default AWS
+// credentials. The messages of a FIFO queue are grouped by their key.
+type sqsPublisher struct {
+	client *sqs.SQS
+}
+
+func newSQS(region string) (Publisher, error) {
+	cfg := aws.NewConfig()
+	if region != "" {
+		cfg = cfg.WithRegion(region)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{Config: *cfg, SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return nil, fmt.Errorf("events: could not create the aws session: %w", err)
+	}
+	return &sqsPublisher{client: sqs.New(sess)}, nil
+}
+
+func (p *sqsPublisher) Publish(ctx context.Context, m *Message) error {
+	in := &sqs.SendMessageInput{
+		QueueUrl:          aws.String(m.Topic),
+		MessageBody:       aws.String(string(m.Data)),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{},
+	}
+	for k, v := range m.Attributes {
+		in.MessageAttributes[k] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(v)}
+	}
+	if strings.HasSuffix(m.Topic, ".fifo") {
+		in.MessageGroupId = aws.String(m.Key)
+		in.MessageDeduplicationId = aws.String(m.ID)
+	}
+	_, err := p.client.SendMessageWithContext(ctx, in)
+	return err
+}
+
+func (p *sqsPublisher) Close() error {
+	return nil
+}
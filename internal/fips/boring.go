@@ -0,0 +1,8 @@
+//go:build boringcrypto
+
+package fips
+
+// fipsonly restricts crypto/tls to the FIPS approved versions, cipher suites and curves.
+import _ "crypto/tls/fipsonly"
+
+const boringCrypto = true
@@ -0,0 +1,102 @@
+// Package fips restricts the cryptography of the TLS connections of the runner: the minimum
+// version of TLS and the cipher suites of the clients of the lite-engine, of Nomad and of the
+// cloud SDKs, and of the listeners of the runner. The runner built with GOEXPERIMENT=boringcrypto
+// only uses the FIPS 140 validated BoringCrypto module and the FIPS approved TLS settings.
+package fips
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Policy is the TLS policy of the runner.
+type Policy struct {
+	// MinVersion is the lowest TLS version negotiated, TLS 1.2 if zero.
+	MinVersion uint16
+	// CipherSuites are the TLS 1.2 cipher suites allowed, the defaults of Go if empty. The suites of
+	// TLS 1.3 are not configurable.
+	CipherSuites []uint16
+}
+
+var (
+	mu     sync.RWMutex
+	policy = Policy{MinVersion: tls.VersionTLS12}
+)
+
+// Enabled returns whether the runner is built with the FIPS validated BoringCrypto module.
+func Enabled() bool {
+	return boringCrypto
+}
+
+// Configure sets the TLS policy of the runner and applies it to the default HTTP transport, which
+// the cloud SDKs and the sinks use. It must be called before the clients are created.
+func Configure(p Policy) {
+	if p.MinVersion < tls.VersionTLS12 {
+		p.MinVersion = tls.VersionTLS12
+	}
+	mu.Lock()
+	policy = p
+	mu.Unlock()
+
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		t.TLSClientConfig = Apply(t.TLSClientConfig)
+	}
+}
+
+// Apply restricts the TLS configuration to the policy and returns it, a new configuration is
+// returned if it is nil. A higher minimum version of the configuration is kept.
+func Apply(cfg *tls.Config) *tls.Config {
+	if cfg == nil {
+		cfg = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if cfg.MinVersion < policy.MinVersion {
+		cfg.MinVersion = policy.MinVersion
+	}
+	if len(policy.CipherSuites) != 0 {
+		cfg.CipherSuites = append([]uint16(nil), policy.CipherSuites...)
+	}
+	return cfg
+}
+
+// ParseVersion returns the TLS version, 1.2 or 1.3.
+func ParseVersion(s string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(s), "tls") {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q, expected 1.2 or 1.3", s)
+}
+
+// ParseCipherSuites returns the IDs of the cipher suites named as in the crypto/tls package, e.g.
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. The suites with known security issues are rejected.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	secure := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		secure[s.Name] = s.ID
+	}
+	insecure := map[string]bool{}
+	for _, s := range tls.InsecureCipherSuites() {
+		insecure[s.Name] = true
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		id, ok := secure[name]
+		switch {
+		case insecure[name]:
+			return nil, fmt.Errorf("the cipher suite %s is insecure", name)
+		case !ok:
+			return nil, fmt.Errorf("unknown cipher suite %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,43 @@
+package fips
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+)
+
+func TestParseCipherSuites(t *testing.T) {
+	ids, err := ParseCipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", " TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || ids[1] != tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384 {
+		t.Errorf("unexpected cipher suites %v", ids)
+	}
+	for _, name := range []string{"TLS_RSA_WITH_RC4_128_SHA", "TLS_UNKNOWN"} {
+		if _, err := ParseCipherSuites([]string{name}); err == nil {
+			t.Errorf("cipher suite %s is accepted", name)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	defer Configure(Policy{})
+	Configure(Policy{MinVersion: tls.VersionTLS13, CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}})
+
+	cfg := Apply(nil)
+	if cfg.MinVersion != tls.VersionTLS13 || len(cfg.CipherSuites) != 1 {
+		t.Errorf("policy is not applied: %+v", cfg)
+	}
+	if t2 := http.DefaultTransport.(*http.Transport).TLSClientConfig; t2 == nil || t2.MinVersion != tls.VersionTLS13 {
+		t.Error("policy is not applied to the default transport")
+	}
+
+	Configure(Policy{})
+	if cfg := Apply(&tls.Config{MinVersion: tls.VersionTLS13}); cfg.MinVersion != tls.VersionTLS13 || cfg.CipherSuites != nil {
+		t.Errorf("the higher minimum version is not kept: %+v", cfg)
+	}
+	if cfg := Apply(&tls.Config{}); cfg.MinVersion != tls.VersionTLS12 {
+		t.Errorf("TLS 1.2 is not enforced: %+v", cfg)
+	}
+}
@@ -0,0 +1,80 @@
+// Package keydelivery keeps the TLS keys of the lite-engines out of the user data of the instances,
+// which is readable by anyone allowed to describe the instances and often ends up in the logs of
+// the cloud consoles. The key is published to a store the instance fetches it from while it boots,
+// and revoked once the instance is destroyed: a one-time URL served by the runner, an AWS SSM
+// SecureString parameter or a GCP Secret Manager secret. The user data only carries the command
+// fetching the key.
+package keydelivery
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Modes of the delivery of the keys, per pool.
+const (
+	ModeUserData      = ""               // the key is written by the user data, the default
+	ModeURL           = "url"            // the instance fetches the key once from the key server of the runner
+	ModeSSM           = "ssm"            // the instance reads the key from an SSM parameter, amazon only
+	ModeSecretManager = "secret-manager" // the instance reads the key from Secret Manager, google only
+)
+
+const (
+	// ssmPrefix is the path of the SSM parameters of the keys.
+	ssmPrefix = "/drone-runner/keys/"
+	// secretPrefix prefixes the names of the Secret Manager secrets of the keys.
+	secretPrefix = "drone-runner-key-"
+)
+
+// Publisher is implemented by the drivers publishing the keys to the secret store of their provider.
+// PublishKey stores the key under the reference and returns the shell command printing it on the
+// instance, RevokeKey deletes it.
+type Publisher interface {
+	PublishKey(ctx context.Context, ref string, key []byte) (fetch string, err error)
+	RevokeKey(ctx context.Context, ref string) error
+}
+
+// NewRef returns the random reference of the key of a new instance, it is also the token of its URL.
+func NewRef() (string, error) {
+	b := make([]byte, 32) //nolint:gomnd
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SSMParameter returns the name of the SSM parameter of the key.
+func SSMParameter(ref string) string {
+	return ssmPrefix + ref
+}
+
+// SecretID returns the ID of the Secret Manager secret of the key.
+func SecretID(ref string) string {
+	return secretPrefix + ref
+}
+
+// The keys are stored base64 encoded, the commands decode them. The commands are quoted with single
+// quotes by the cloud-init templates, they must not contain any.
+
+// SSMFetch returns the command reading the key from its SSM parameter with the aws CLI of the
+// instance, its instance profile must allow ssm:GetParameter and the decryption with the KMS key.
+func SSMFetch(region, ref string) string {
+	return fmt.Sprintf("aws ssm get-parameter --region %s --name %s --with-decryption --query Parameter.Value --output text | base64 -d",
+		region, SSMParameter(ref))
+}
+
+// SecretManagerFetch returns the command reading the key from its secret with the gcloud CLI of the
+// instance, its service account must be allowed to access the secret.
+func SecretManagerFetch(project, ref string) string {
+	return fmt.Sprintf("gcloud secrets versions access latest --secret=%s --project=%s | base64 -d", SecretID(ref), project)
+}
+
+// URLFetch returns the command downloading the key from the key server, retried while the network of
+// the instance comes up.
+func URLFetch(url, ref string) string {
+	return fmt.Sprintf(`wget -q -O - --tries=20 --retry-connrefused --waitretry=3 "%s/keys/%s" | base64 -d`,
+		strings.TrimSuffix(url, "/"), ref)
+}
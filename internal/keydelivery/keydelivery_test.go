@@ -0,0 +1,72 @@
+package keydelivery
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := NewServer("https://runner.example.com:9443/")
+	s.now = func() time.Time { return now }
+
+	fetch, err := s.PublishKey(context.Background(), "ref", []byte("key\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `wget -q -O - --tries=20 --retry-connrefused --waitretry=3 "https://runner.example.com:9443/keys/ref" | base64 -d`
+	if fetch != want {
+		t.Errorf("expected the fetch command %q, got %q", want, fetch)
+	}
+
+	get := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, http.NoBody))
+		body, _ := io.ReadAll(w.Result().Body)
+		return w.Code, string(body)
+	}
+	if code, _ := get("/keys/other"); code != http.StatusNotFound {
+		t.Errorf("expected an unknown reference to be not found, got %d", code)
+	}
+	if code, body := get("/keys/ref"); code != http.StatusOK || body != "a2V5Cg==" {
+		t.Errorf("expected the encoded key, got %d %q", code, body)
+	}
+	if code, _ := get("/keys/ref"); code != http.StatusNotFound {
+		t.Errorf("expected the key to be fetched once, got %d", code)
+	}
+
+	_, _ = s.PublishKey(context.Background(), "revoked", []byte("key"))
+	_ = s.RevokeKey(context.Background(), "revoked")
+	if code, _ := get("/keys/revoked"); code != http.StatusNotFound {
+		t.Errorf("expected the revoked key to be gone, got %d", code)
+	}
+	_, _ = s.PublishKey(context.Background(), "expired", []byte("key"))
+	now = now.Add(TTL + time.Second)
+	if code, _ := get("/keys/expired"); code != http.StatusNotFound {
+		t.Errorf("expected the expired key to be gone, got %d", code)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	for _, fetch := range []string{
+		SSMFetch("us-east-2", "ref"),
+		SecretManagerFetch("builds", "ref"),
+		URLFetch("http://10.0.0.1:9443", "ref"),
+	} {
+		// the cloud-init templates quote the commands with single quotes.
+		if strings.Contains(fetch, "'") || !strings.HasSuffix(fetch, "| base64 -d") {
+			t.Errorf("unexpected fetch command %q", fetch)
+		}
+	}
+	if got := SSMFetch("us-east-2", "ref"); !strings.Contains(got, "--name /drone-runner/keys/ref --with-decryption") {
+		t.Errorf("unexpected ssm fetch command %q", got)
+	}
+	if got := SecretManagerFetch("builds", "ref"); !strings.Contains(got, "--secret=drone-runner-key-ref --project=builds") {
+		t.Errorf("unexpected secret manager fetch command %q", got)
+	}
+}
@@ -0,0 +1,138 @@
+package keydelivery
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/fips"
+	"github.com/drone/runner-go/logger"
+)
+
+// TTL is how long a published key can be fetched, the instances fetch it while they boot.
+const TTL = 30 * time.Minute
+
+const readHeaderTimeout = 10 * time.Second
+
+var (
+	defaultServer *Server
+	defaultMu     sync.RWMutex
+)
+
+// Server hands out every published key once, on GET /keys/<ref>. The reference is the secret of the
+// URL, a key fetched or expired is gone.
+type Server struct {
+	url string // the base URL the instances fetch the keys from
+
+	mu   sync.Mutex
+	keys map[string]entry
+	now  func() time.Time
+}
+
+type entry struct {
+	key     []byte
+	expires time.Time
+}
+
+// NewServer returns a key server the instances reach on the base URL.
+func NewServer(url string) *Server {
+	return &Server{url: url, keys: make(map[string]entry), now: time.Now}
+}
+
+// SetDefault sets the server the keys of the pools with the url delivery are published to.
+func SetDefault(s *Server) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultServer = s
+}
+
+// Default returns the key server, nil if it is not enabled.
+func Default() *Server {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultServer
+}
+
+// Start starts the key server in the background and sets it as the default server. It serves TLS if
+// the certificate and its key are set.
+func Start(ctx context.Context, bind, url, certFile, keyFile string) error {
+	if url == "" {
+		return errors.New("keydelivery: the URL the instances fetch the keys from is not set")
+	}
+	l, err := net.Listen("tcp", bind)
+	if err != nil {
+		return fmt.Errorf("keydelivery: could not listen on %s: %w", bind, err)
+	}
+
+	s := NewServer(url)
+	srv := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: readHeaderTimeout,
+		TLSConfig:         fips.Apply(nil),
+	}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Shutdown(context.Background())
+	}()
+	go func() {
+		var serveErr error
+		if certFile != "" {
+			serveErr = srv.ServeTLS(l, certFile, keyFile)
+		} else {
+			serveErr = srv.Serve(l)
+		}
+		if serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+			logger.FromContext(ctx).WithError(serveErr).Errorln("keydelivery: server stopped")
+		}
+	}()
+	SetDefault(s)
+	return nil
+}
+
+// PublishKey makes the key fetchable once under the reference, until the TTL.
+func (s *Server) PublishKey(_ context.Context, ref string, key []byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for r, e := range s.keys {
+		if now.After(e.expires) {
+			delete(s.keys, r)
+		}
+	}
+	s.keys[ref] = entry{key: key, expires: now.Add(TTL)}
+	return URLFetch(s.url, ref), nil
+}
+
+// RevokeKey drops the key if it was not fetched yet.
+func (s *Server) RevokeKey(_ context.Context, ref string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, ref)
+	return nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ref := strings.TrimPrefix(r.URL.Path, "/keys/")
+	if r.Method != http.MethodGet || ref == r.URL.Path || ref == "" || strings.Contains(ref, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	e, ok := s.keys[ref]
+	delete(s.keys, ref)
+	s.mu.Unlock()
+	if !ok || s.now().After(e.expires) {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(e.key)))
+}
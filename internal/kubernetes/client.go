@@ -0,0 +1,302 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/fips"
+)
+
+// VMPool custom resource.
+const (
+	Group      = "runner.harness.io"
+	Version    = "v1"
+	Kind       = "VMPool"
+	Resource   = "vmpools"
+	APIVersion = Group + "/" + Version
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	requestTimeout    = 30 * time.Second
+)
+
+var errGone = errors.New("kubernetes: resource version is too old")
+
+type (
+	// VMPool is a pool defined as a kubernetes custom resource. The spec has the
+	// format of a pool of the pool file, the pool is named after the resource.
+	VMPool struct {
+		APIVersion string          `json:"apiVersion"`
+		Kind       string          `json:"kind"`
+		Metadata   ObjectMeta      `json:"metadata"`
+		Spec       json.RawMessage `json:"spec"`
+		Status     VMPoolStatus    `json:"status,omitempty"`
+	}
+
+	ObjectMeta struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace,omitempty"`
+		UID             string `json:"uid,omitempty"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+		Generation      int64  `json:"generation,omitempty"`
+	}
+
+	VMPoolStatus struct {
+		ObservedGeneration int64       `json:"observedGeneration,omitempty"`
+		Conditions         []Condition `json:"conditions,omitempty"`
+	}
+
+	Condition struct {
+		Type               string `json:"type"`
+		Status             string `json:"status"`
+		Reason             string `json:"reason,omitempty"`
+		Message            string `json:"message,omitempty"`
+		LastTransitionTime string `json:"lastTransitionTime,omitempty"`
+	}
+
+	vmPoolList struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []VMPool `json:"items"`
+	}
+
+	watchEvent struct {
+		Type   string          `json:"type"`
+		Object json.RawMessage `json:"object"`
+	}
+
+	status struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+
+	event struct {
+		APIVersion     string          `json:"apiVersion"`
+		Kind           string          `json:"kind"`
+		Metadata       ObjectMeta      `json:"metadata"`
+		InvolvedObject objectReference `json:"involvedObject"`
+		Type           string          `json:"type"`
+		Reason         string          `json:"reason"`
+		Message        string          `json:"message"`
+		Source         eventSource     `json:"source"`
+		FirstTimestamp string          `json:"firstTimestamp"`
+		LastTimestamp  string          `json:"lastTimestamp"`
+		Count          int             `json:"count"`
+	}
+
+	objectReference struct {
+		APIVersion      string `json:"apiVersion"`
+		Kind            string `json:"kind"`
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace"`
+		UID             string `json:"uid"`
+		ResourceVersion string `json:"resourceVersion"`
+	}
+
+	eventSource struct {
+		Component string `json:"component"`
+	}
+)
+
+// client is a minimal client of the kubernetes API server using the in-cluster service account.
+type client struct {
+	host      string
+	namespace string
+	http      *http.Client
+}
+
+func newInClusterClient(namespace string) (*client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("kubernetes: not running in a cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be set")
+	}
+
+	caCert, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes: failed to read the service account ca certificate: %w", err)
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(caCert) {
+		return nil, errors.New("kubernetes: invalid service account ca certificate")
+	}
+
+	if namespace == "" {
+		ns, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes: failed to read the namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+
+	return &client{
+		host:      "https://" + net.JoinHostPort(host, port),
+		namespace: namespace,
+		http: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: fips.Apply(&tls.Config{RootCAs: certPool, MinVersion: tls.VersionTLS12}),
+			},
+		},
+	}, nil
+}
+
+func (c *client) poolsPath() string {
+	return fmt.Sprintf("/apis/%s/%s/namespaces/%s/%s", Group, Version, c.namespace, Resource)
+}
+
+func (c *client) listPools(ctx context.Context) (*vmPoolList, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	body, err := c.do(ctx, http.MethodGet, c.poolsPath(), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	list := new(vmPoolList)
+	if err := json.NewDecoder(body).Decode(list); err != nil {
+		return nil, fmt.Errorf("kubernetes: failed to decode the pools: %w", err)
+	}
+	return list, nil
+}
+
+// watchPools streams the changes of the pools since the resource version until the context
+// is canceled or the server ends the watch.
+func (c *client) watchPools(ctx context.Context, resourceVersion string, handle func(eventType string, pool *VMPool)) (string, error) {
+	query := url.Values{
+		"watch":           {"true"},
+		"resourceVersion": {resourceVersion},
+	}
+	body, err := c.do(ctx, http.MethodGet, c.poolsPath()+"?"+query.Encode(), "", nil)
+	if err != nil {
+		return resourceVersion, err
+	}
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+	for {
+		var e watchEvent
+		if err := dec.Decode(&e); err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return resourceVersion, nil
+			}
+			return resourceVersion, fmt.Errorf("kubernetes: failed to decode the watch event: %w", err)
+		}
+
+		if e.Type == "ERROR" {
+			var s status
+			_ = json.Unmarshal(e.Object, &s)
+			if s.Code == http.StatusGone {
+				return "", errGone
+			}
+			return resourceVersion, fmt.Errorf("kubernetes: watch failed: %s", s.Message)
+		}
+
+		pool := new(VMPool)
+		if err := json.Unmarshal(e.Object, pool); err != nil {
+			return resourceVersion, fmt.Errorf("kubernetes: failed to decode the pool: %w", err)
+		}
+		resourceVersion = pool.Metadata.ResourceVersion
+		handle(e.Type, pool)
+	}
+}
+
+func (c *client) updateStatus(ctx context.Context, name string, poolStatus *VMPoolStatus) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	patch, err := json.Marshal(map[string]interface{}{"status": poolStatus})
+	if err != nil {
+		return err
+	}
+	body, err := c.do(ctx, http.MethodPatch, c.poolsPath()+"/"+name+"/status", "application/merge-patch+json", patch)
+	if err != nil {
+		return err
+	}
+	return body.Close()
+}
+
+func (c *client) createEvent(ctx context.Context, pool *VMPool, eventType, reason, message string) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	e := &event{
+		APIVersion: "v1",
+		Kind:       "Event",
+		Metadata: ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", pool.Metadata.Name, time.Now().UnixNano()),
+			Namespace: c.namespace,
+		},
+		InvolvedObject: objectReference{
+			APIVersion:      APIVersion,
+			Kind:            Kind,
+			Name:            pool.Metadata.Name,
+			Namespace:       c.namespace,
+			UID:             pool.Metadata.UID,
+			ResourceVersion: pool.Metadata.ResourceVersion,
+		},
+		Type:           eventType,
+		Reason:         reason,
+		Message:        message,
+		Source:         eventSource{Component: "drone-runner-aws"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	body, err := c.do(ctx, http.MethodPost, "/api/v1/namespaces/"+c.namespace+"/events", "application/json", b)
+	if err != nil {
+		return err
+	}
+	return body.Close()
+}
+
+func (c *client) do(ctx context.Context, method, path, contentType string, payload []byte) (io.ReadCloser, error) {
+	// the service account token is rotated, it is read on every request.
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes: failed to read the service account token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.host+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096)) //nolint:gomnd
+		if resp.StatusCode == http.StatusGone {
+			return nil, errGone
+		}
+		return nil, fmt.Errorf("kubernetes: %s %s failed with status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,325 @@
+// Package kubernetes manages pools defined as VMPool custom resources. The runner watches the
+// resources of its namespace, adds, updates and removes the pools accordingly and reports
+// the state of every pool in the status conditions and events of its resource.
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/drivers"
+	"github.com/drone-runners/drone-runner-aws/internal/poolfile"
+	"github.com/sirupsen/logrus"
+)
+
+// Condition types and statuses of a pool.
+const (
+	ConditionReady    = "Ready"
+	ConditionDegraded = "Degraded"
+
+	conditionTrue  = "True"
+	conditionFalse = "False"
+)
+
+const (
+	eventNormal  = "Normal"
+	eventWarning = "Warning"
+
+	retryInterval = 5 * time.Second
+	// eventInterval limits the provisioning failure events of a pool.
+	eventInterval = time.Minute
+)
+
+// Controller keeps the pools of the manager in sync with the VMPool resources.
+type Controller struct {
+	client     *client
+	manager    *drivers.Manager
+	runnerName string
+
+	mu    sync.Mutex
+	pools map[string]*poolState
+}
+
+type poolState struct {
+	pool       *VMPool
+	generation int64 // generation of the spec the pool was created from
+	conditions map[string]*Condition
+	lastEvent  time.Time
+}
+
+// New returns a controller watching the VMPool resources of the namespace, the namespace
+// of the runner is used if empty.
+func New(namespace, runnerName string, manager *drivers.Manager) (*Controller, error) {
+	c, err := newInClusterClient(namespace)
+	if err != nil {
+		return nil, err
+	}
+	ctrl := &Controller{
+		client:     c,
+		manager:    manager,
+		runnerName: runnerName,
+		pools:      make(map[string]*poolState),
+	}
+	manager.SetObserver(ctrl)
+	return ctrl, nil
+}
+
+// Run lists and watches the pools until the context is canceled.
+func (c *Controller) Run(ctx context.Context) {
+	var resourceVersion string
+	for {
+		var err error
+		if resourceVersion == "" {
+			resourceVersion, err = c.resync(ctx)
+		}
+		if err == nil {
+			resourceVersion, err = c.client.watchPools(ctx, resourceVersion, func(eventType string, pool *VMPool) {
+				c.handle(ctx, eventType, pool)
+			})
+		}
+		if errors.Is(err, errGone) {
+			resourceVersion = ""
+		} else if err != nil && ctx.Err() == nil {
+			logrus.WithError(err).Warnln("kubernetes: failed to watch the pools")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// resync applies all the pools and removes the pools which were deleted while not watching.
+func (c *Controller) resync(ctx context.Context) (string, error) {
+	list, err := c.client.listPools(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	seen := make(map[string]bool, len(list.Items))
+	for i := range list.Items {
+		seen[list.Items[i].Metadata.Name] = true
+		c.apply(ctx, &list.Items[i])
+	}
+
+	c.mu.Lock()
+	var deleted []string
+	for name := range c.pools {
+		if !seen[name] {
+			deleted = append(deleted, name)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, name := range deleted {
+		c.remove(ctx, name)
+	}
+	return list.Metadata.ResourceVersion, nil
+}
+
+func (c *Controller) handle(ctx context.Context, eventType string, pool *VMPool) {
+	switch eventType {
+	case "ADDED", "MODIFIED":
+		c.apply(ctx, pool)
+	case "DELETED":
+		c.remove(ctx, pool.Metadata.Name)
+	}
+}
+
+// apply creates or updates the pool, unless the spec is unchanged. Status updates change the resource too.
+func (c *Controller) apply(ctx context.Context, pool *VMPool) {
+	name := pool.Metadata.Name
+
+	c.mu.Lock()
+	state, exists := c.pools[name]
+	if exists && state.generation == pool.Metadata.Generation {
+		state.pool = pool
+		c.mu.Unlock()
+		return
+	}
+	if !exists {
+		state = &poolState{conditions: make(map[string]*Condition)}
+		c.pools[name] = state
+	}
+	state.pool = pool
+	state.generation = pool.Metadata.Generation
+	c.mu.Unlock()
+
+	logr := logrus.WithField("pool", name)
+
+	p, err := c.parse(pool)
+	if err == nil {
+		err = p.Driver.Ping(ctx)
+	}
+	if err != nil {
+		logr.WithError(err).Errorln("kubernetes: invalid pool")
+		c.setCondition(name, ConditionReady, conditionFalse, "InvalidSpec", err.Error())
+		c.event(ctx, name, eventWarning, "InvalidSpec", err.Error())
+		return
+	}
+
+	if err = c.manager.Upsert(p); err != nil {
+		logr.WithError(err).Errorln("kubernetes: failed to add the pool")
+		c.setCondition(name, ConditionReady, conditionFalse, "Failed", err.Error())
+		return
+	}
+	logr.Infoln("kubernetes: pool applied")
+	c.setCondition(name, ConditionReady, conditionTrue, "Applied", "")
+	c.event(ctx, name, eventNormal, "Applied", "pool applied")
+
+	if c.manager.IsLeader() {
+		go func() {
+			if err := c.manager.BuildPool(ctx, name); err != nil {
+				logr.WithError(err).Errorln("kubernetes: unable to build pool")
+			}
+		}()
+	}
+}
+
+// remove removes the pool and, on the leader, destroys its instances.
+func (c *Controller) remove(ctx context.Context, name string) {
+	c.mu.Lock()
+	_, exists := c.pools[name]
+	delete(c.pools, name)
+	c.mu.Unlock()
+	if !exists {
+		return
+	}
+
+	logr := logrus.WithField("pool", name)
+	if c.manager.IsLeader() && c.manager.Exists(name) {
+		if err := c.manager.CleanPool(ctx, name, true, true); err != nil {
+			logr.WithError(err).Errorln("kubernetes: unable to clean the removed pool")
+		}
+	}
+	c.manager.Remove(name)
+	logr.Infoln("kubernetes: pool removed")
+}
+
+func (c *Controller) parse(pool *VMPool) (*drivers.Pool, error) {
+	var instance config.Instance
+	if err := json.Unmarshal(pool.Spec, &instance); err != nil {
+		return nil, fmt.Errorf("failed to parse the spec: %w", err)
+	}
+	instance.Name = pool.Metadata.Name
+	if err := instance.Validate(); err != nil {
+		return nil, err
+	}
+
+	pools, err := poolfile.ProcessPool(&config.PoolFile{Instances: []config.Instance{instance}}, c.runnerName)
+	if err != nil {
+		return nil, err
+	}
+	if len(pools) != 1 {
+		return nil, errors.New("the spec must define a single pool")
+	}
+	return &pools[0], nil
+}
+
+// InstanceCreated marks the pool as healthy after a failed provisioning.
+func (c *Controller) InstanceCreated(pool string) {
+	c.mu.Lock()
+	state := c.pools[pool]
+	degraded := state != nil && state.conditions[ConditionDegraded] != nil &&
+		state.conditions[ConditionDegraded].Status == conditionTrue
+	c.mu.Unlock()
+	if !degraded {
+		return
+	}
+
+	if c.setCondition(pool, ConditionDegraded, conditionFalse, "InstanceCreated", "") {
+		c.event(context.Background(), pool, eventNormal, "Recovered", "instance provisioning recovered")
+	}
+}
+
+// InstanceCreateFailed marks the pool as degraded and reports the failure as an event.
+func (c *Controller) InstanceCreateFailed(pool string, err error) {
+	c.setCondition(pool, ConditionDegraded, conditionTrue, "ProvisioningFailed", err.Error())
+
+	c.mu.Lock()
+	state := c.pools[pool]
+	throttled := state == nil || time.Since(state.lastEvent) < eventInterval
+	if !throttled {
+		state.lastEvent = time.Now()
+	}
+	c.mu.Unlock()
+
+	if !throttled {
+		c.event(context.Background(), pool, eventWarning, "ProvisioningFailed", err.Error())
+	}
+}
+
+// RolloutBlocked marks the pool as degraded and reports the failed validation of its new image as an event.
+func (c *Controller) RolloutBlocked(pool string, err error) {
+	c.setCondition(pool, ConditionDegraded, conditionTrue, "CanaryFailed", err.Error())
+	c.event(context.Background(), pool, eventWarning, "CanaryFailed", err.Error())
+}
+
+// setCondition updates the condition of the pool, the status is written only if it changed.
+// It returns true if the condition status changed.
+func (c *Controller) setCondition(pool, conditionType, conditionStatus, reason, message string) bool {
+	c.mu.Lock()
+	state := c.pools[pool]
+	if state == nil {
+		c.mu.Unlock()
+		return false
+	}
+
+	cond := state.conditions[conditionType]
+	if cond != nil && cond.Status == conditionStatus && cond.Reason == reason && cond.Message == message {
+		c.mu.Unlock()
+		return false
+	}
+	transitioned := cond == nil || cond.Status != conditionStatus
+	if cond == nil {
+		cond = &Condition{Type: conditionType}
+		state.conditions[conditionType] = cond
+	}
+	if transitioned {
+		cond.LastTransitionTime = time.Now().UTC().Format(time.RFC3339)
+	}
+	cond.Status = conditionStatus
+	cond.Reason = reason
+	cond.Message = message
+
+	status := &VMPoolStatus{ObservedGeneration: state.generation}
+	for _, t := range []string{ConditionReady, ConditionDegraded} {
+		if cond := state.conditions[t]; cond != nil {
+			status.Conditions = append(status.Conditions, *cond)
+		}
+	}
+	c.mu.Unlock()
+
+	go func() {
+		if err := c.client.updateStatus(context.Background(), pool, status); err != nil {
+			logrus.WithError(err).WithField("pool", pool).Warnln("kubernetes: failed to update the pool status")
+		}
+	}()
+	return transitioned
+}
+
+func (c *Controller) event(ctx context.Context, pool, eventType, reason, message string) {
+	c.mu.Lock()
+	state := c.pools[pool]
+	var resource *VMPool
+	if state != nil {
+		resource = state.pool
+	}
+	c.mu.Unlock()
+	if resource == nil {
+		return
+	}
+
+	go func() {
+		if err := c.client.createEvent(ctx, resource, eventType, reason, message); err != nil {
+			logrus.WithError(err).WithField("pool", pool).Warnln("kubernetes: failed to create event")
+		}
+	}()
+}
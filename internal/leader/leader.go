@@ -0,0 +1,157 @@
+// Package leader elects a single replica among the runners sharing a database to run the
+// background jobs, like the instance purger and the pool builder.
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	_ "github.com/lib/pq" // required for postgres
+)
+
+// High availability modes.
+const (
+	// ModeElection runs the background jobs on the replica elected as the leader.
+	ModeElection = "election"
+	// ModeFollower never runs the background jobs, the replica only serves the API traffic.
+	ModeFollower = "follower"
+)
+
+// Elector elects the leader using a postgres session level advisory lock. The lock is held
+// for as long as the connection it was acquired on is alive, so a replica that crashes or
+// loses the database connection gives up the leadership automatically.
+type Elector struct {
+	db       *sql.DB
+	lockID   int64
+	interval time.Duration
+	leader   atomic.Bool
+
+	// connect opens a session to campaign for the lock on, it's replaced in the tests.
+	connect func(ctx context.Context) (session, error)
+}
+
+// session is a database connection the lock is acquired on.
+type session interface {
+	TryLock(ctx context.Context) (bool, error)
+	Unlock(ctx context.Context) error
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// New returns an elector using the postgres database.
+func New(datasource string, lockID int64, interval time.Duration) (*Elector, error) {
+	db, err := sql.Open("postgres", datasource)
+	if err != nil {
+		return nil, err
+	}
+	// connections must not be reused, an idle connection would keep holding the lock.
+	db.SetMaxIdleConns(0)
+	e := &Elector{
+		db:       db,
+		lockID:   lockID,
+		interval: interval,
+	}
+	e.connect = e.connectPostgres
+	return e, nil
+}
+
+// IsLeader returns true if the replica is the leader.
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Run campaigns for the leadership until the context is canceled. Every time the replica is elected
+// onElected is called with a context which is canceled once the leadership is lost.
+func (e *Elector) Run(ctx context.Context, onElected func(ctx context.Context)) {
+	if e.db != nil {
+		defer e.db.Close()
+	}
+
+	for {
+		if err := e.campaign(ctx, onElected); err != nil && ctx.Err() == nil {
+			logrus.WithError(err).Warnln("leader: election failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(e.interval):
+		}
+	}
+}
+
+// campaign tries to acquire the lock and, if acquired, holds it for as long as the connection is alive.
+func (e *Elector) campaign(ctx context.Context, onElected func(ctx context.Context)) error {
+	conn, err := e.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	acquired, err := conn.TryLock(ctx)
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		logrus.Traceln("leader: another replica is the leader")
+		return nil
+	}
+
+	logrus.Infoln("leader: elected, running the background jobs")
+	e.leader.Store(true)
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		e.leader.Store(false)
+	}()
+	go onElected(leaderCtx)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// release the lock so another replica takes over right away.
+			_ = conn.Unlock(context.Background())
+			return nil
+		case <-ticker.C:
+			if err := conn.Ping(ctx); err != nil {
+				logrus.WithError(err).Errorln("leader: lost the database connection, stepping down")
+				return err
+			}
+		}
+	}
+}
+
+// pgSession is a postgres connection holding a session level advisory lock.
+type pgSession struct {
+	*sql.Conn
+	lockID int64
+}
+
+func (e *Elector) connectPostgres(ctx context.Context) (session, error) {
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pgSession{Conn: conn, lockID: e.lockID}, nil
+}
+
+func (s *pgSession) TryLock(ctx context.Context) (acquired bool, err error) {
+	err = s.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", s.lockID).Scan(&acquired)
+	return acquired, err
+}
+
+func (s *pgSession) Unlock(ctx context.Context) error {
+	_, err := s.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", s.lockID)
+	return err
+}
+
+func (s *pgSession) Ping(ctx context.Context) error {
+	return s.PingContext(ctx)
+}
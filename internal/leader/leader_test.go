@@ -0,0 +1,115 @@
+package leader
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeLock is an advisory lock shared by the replicas, it's held by a session until unlocked or closed.
+type fakeLock struct {
+	mu    sync.Mutex
+	owner *fakeSession
+}
+
+type fakeSession struct {
+	lock *fakeLock
+}
+
+func (s *fakeSession) TryLock(context.Context) (bool, error) {
+	s.lock.mu.Lock()
+	defer s.lock.mu.Unlock()
+	if s.lock.owner != nil && s.lock.owner != s {
+		return false, nil
+	}
+	s.lock.owner = s
+	return true, nil
+}
+
+func (s *fakeSession) Unlock(context.Context) error {
+	return s.Close()
+}
+
+func (s *fakeSession) Ping(context.Context) error {
+	return nil
+}
+
+func (s *fakeSession) Close() error {
+	s.lock.mu.Lock()
+	defer s.lock.mu.Unlock()
+	if s.lock.owner == s {
+		s.lock.owner = nil
+	}
+	return nil
+}
+
+func newFakeElector(lock *fakeLock) *Elector {
+	return &Elector{
+		interval: 10 * time.Millisecond,
+		connect: func(context.Context) (session, error) {
+			return &fakeSession{lock: lock}, nil
+		},
+	}
+}
+
+func TestElector_SingleLeader(t *testing.T) {
+	lock := &fakeLock{}
+	var elected int32
+
+	electors := make([]*Elector, 3)
+	cancels := make([]context.CancelFunc, len(electors))
+	for i := range electors {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		electors[i], cancels[i] = newFakeElector(lock), cancel
+		go electors[i].Run(ctx, func(context.Context) {
+			atomic.AddInt32(&elected, 1)
+		})
+	}
+
+	leaders := func() (leaders []int) {
+		for i, e := range electors {
+			if e.IsLeader() {
+				leaders = append(leaders, i)
+			}
+		}
+		return leaders
+	}
+	waitForLeader := func() int {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if l := leaders(); len(l) == 1 {
+				return l[0]
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("expected a single leader, got %v", leaders())
+		return -1
+	}
+
+	first := waitForLeader()
+	// the followers keep campaigning, none of them must be elected while the leader is alive.
+	time.Sleep(100 * time.Millisecond)
+	if l := leaders(); len(l) != 1 || l[0] != first {
+		t.Fatalf("expected replica %d to remain the only leader, got %v", first, l)
+	}
+	if n := atomic.LoadInt32(&elected); n != 1 {
+		t.Fatalf("expected the leader work to run once, ran %d times", n)
+	}
+
+	// once the leader is gone, exactly one of the followers takes over.
+	cancels[first]()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&elected) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if second := waitForLeader(); second == first {
+		t.Fatalf("expected another replica to take over the leadership")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&elected); n != 2 {
+		t.Fatalf("expected the leader work to run once per election, ran %d times", n)
+	}
+}
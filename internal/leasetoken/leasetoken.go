@@ -0,0 +1,118 @@
+// Package leasetoken signs the calls of the runner to the lite-engines with short-lived tokens bound
+// to the instance. The certificates of the instances of a pool might share their CA, the tokens keep
+// a certificate leaked from an instance from being replayed against the other instances.
+//
+// Every instance has its own lease key, delivered in the environment of its lite-engine by the
+// startup script and rotated with the setup of every stage through the key file. The lite-engine
+// verifies the token of every call against its key and its own clock, see Verify.
+package leasetoken
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/oshelp"
+)
+
+// Header is the header of the lite-engine calls carrying the token.
+const Header = "X-Lite-Engine-Lease"
+
+// KeyEnv is the variable of the environment of the lite-engine holding the key of the instance set by
+// the startup script. KeyFileEnv is the file of the key rotated by the setups, it takes precedence
+// once written.
+const (
+	KeyEnv     = "LEASE_KEY"
+	KeyFileEnv = "LEASE_KEY_FILE"
+)
+
+const (
+	// TTL is the lifetime of the tokens, they are signed for every call.
+	TTL = 5 * time.Minute
+	// MaxSkew is the drift tolerated between the clocks of the runner and of the instances.
+	MaxSkew = time.Minute
+)
+
+const version = "v1"
+
+var (
+	ErrMalformed = errors.New("malformed lease token")
+	ErrExpired   = errors.New("the lease token is expired")
+	ErrSignature = errors.New("the lease token is not signed with the key of the instance")
+)
+
+// NewKey returns a random lease key.
+func NewKey() (string, error) {
+	b := make([]byte, 32) //nolint:gomnd
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// KeyFile returns the path of the file of the rotated key on the instances of the OS.
+func KeyFile(os string) string {
+	switch os {
+	case oshelp.OSWindows:
+		return `C:\Program Files\lite-engine\lease.key`
+	case oshelp.OSMac:
+		return "/tmp/lite-engine/lease.key"
+	default:
+		return "/etc/lite-engine/lease.key"
+	}
+}
+
+// Token returns a token signed with the key, valid until it expires.
+func Token(key string, expires time.Time) string {
+	payload := version + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + sign(key, payload)
+}
+
+// Verify checks the token is signed with the key and valid at the time. The tokens expiring further
+// than the TTL in the future are rejected, a leaked token can't outlive its TTL.
+func Verify(key, token string, now time.Time) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != version { //nolint:gomnd
+		return ErrMalformed
+	}
+	unix, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return ErrMalformed
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(sign(key, parts[0]+"."+parts[1]))) {
+		return ErrSignature
+	}
+	expires := time.Unix(unix, 0)
+	if now.After(expires.Add(MaxSkew)) || expires.After(now.Add(TTL+MaxSkew)) {
+		return ErrExpired
+	}
+	return nil
+}
+
+func sign(key, payload string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Transport returns a transport adding a token signed with the key to every request.
+func Transport(base http.RoundTripper, key string) http.RoundTripper {
+	return &transport{base: base, key: key}
+}
+
+type transport struct {
+	base http.RoundTripper
+	key  string
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(Header, Token(t.key, time.Now().Add(TTL)))
+	return t.base.RoundTrip(req)
+}
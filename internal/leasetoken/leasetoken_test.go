@@ -0,0 +1,77 @@
+package leasetoken
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	valid := Token(key, now.Add(TTL))
+
+	tests := []struct {
+		name  string
+		key   string
+		token string
+		err   error
+	}{
+		{name: "valid", key: key, token: valid},
+		{name: "expired within the skew", key: key, token: Token(key, now.Add(-MaxSkew/2))},
+		{name: "expired", key: key, token: Token(key, now.Add(-MaxSkew-time.Second)), err: ErrExpired},
+		{name: "beyond the ttl", key: key, token: Token(key, now.Add(TTL+MaxSkew+time.Second)), err: ErrExpired},
+		{name: "key of another instance", key: key, token: Token(other, now.Add(TTL)), err: ErrSignature},
+		{name: "tampered expiry", key: key, token: strings.Replace(valid, "1700000300", "1700000200", 1), err: ErrSignature},
+		{name: "unknown version", key: key, token: "v2" + strings.TrimPrefix(valid, "v1"), err: ErrMalformed},
+		{name: "malformed", key: key, token: "token", err: ErrMalformed},
+		{name: "empty", key: key, err: ErrMalformed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := Verify(test.key, test.token, now); !errors.Is(err, test.err) {
+				t.Errorf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestTransport(t *testing.T) {
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := Verify(key, r.Header.Get(Header), time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: Transport(http.DefaultTransport, key)}
+	req, err := http.NewRequest(http.MethodGet, srv.URL, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected the request to carry a valid token, got status %d", res.StatusCode)
+	}
+	if req.Header.Get(Header) != "" {
+		t.Error("expected the request of the caller not to be modified")
+	}
+}
@@ -0,0 +1,90 @@
+package lehelper
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// The markers of the host keys cloud-init prints to the console once the ssh server is set up.
+const (
+	consoleHostKeysBegin = "-----BEGIN SSH HOST KEY KEYS-----"
+	consoleHostKeysEnd   = "-----END SSH HOST KEY KEYS-----"
+)
+
+// ConsoleFunc returns the console output of an instance.
+type ConsoleFunc func(ctx context.Context) (string, error)
+
+// ParseConsoleHostKeys returns the host keys the instance printed to its console, the last ones
+// if it printed them on several boots. Some providers prefix the lines of the console, e.g. with
+// timestamps, the prefixes are skipped.
+func ParseConsoleHostKeys(console string) []ssh.PublicKey {
+	begin := strings.LastIndex(console, consoleHostKeysBegin)
+	if begin < 0 {
+		return nil
+	}
+	block := console[begin+len(consoleHostKeysBegin):]
+	end := strings.Index(block, consoleHostKeysEnd)
+	if end < 0 {
+		// the instance is still printing its keys
+		return nil
+	}
+	var keys []ssh.PublicKey
+	for _, line := range strings.Split(block[:end], "\n") {
+		fields := strings.Fields(line)
+		for i := range fields {
+			if key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.Join(fields[i:], " "))); err == nil {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+	return keys
+}
+
+// MarshalHostKeys returns the host keys in the authorized_keys format, one per line, as they are
+// stored as the host key of the instance.
+func MarshalHostKeys(keys ...ssh.PublicKey) string {
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		lines = append(lines, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ParseHostKeys parses the host keys stored for an instance, see MarshalHostKeys.
+func ParseHostKeys(hostKey string) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	for _, line := range strings.Split(hostKey, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("invalid host key %q: %w", line, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// HostKeyFingerprints returns the SHA256 fingerprints of the host keys, comma separated.
+func HostKeyFingerprints(keys []ssh.PublicKey) string {
+	fingerprints := make([]string, 0, len(keys))
+	for _, key := range keys {
+		fingerprints = append(fingerprints, ssh.FingerprintSHA256(key))
+	}
+	return strings.Join(fingerprints, ",")
+}
+
+func containsKey(keys []ssh.PublicKey, key ssh.PublicKey) bool {
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
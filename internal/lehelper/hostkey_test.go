@@ -0,0 +1,60 @@
+package lehelper
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseConsoleHostKeys(t *testing.T) {
+	var keys []ssh.PublicKey
+	for i := 0; i < 2; i++ {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := ssh.NewPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	line := func(key ssh.PublicKey) string {
+		return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + " root@vm-1"
+	}
+
+	// the keys of the last boot are used, the lines may carry the timestamps of the console
+	console := strings.Join([]string{
+		"[    5.1] cloud-init: starting",
+		consoleHostKeysBegin,
+		line(keys[1]),
+		consoleHostKeysEnd,
+		"[   42.0] reboot: Restarting system",
+		consoleHostKeysBegin,
+		"2024-01-01T00:00:00Z " + line(keys[0]),
+		"not a key",
+		consoleHostKeysEnd,
+	}, "\n")
+	got := ParseConsoleHostKeys(console)
+	if len(got) != 1 || !containsKey(got, keys[0]) {
+		t.Errorf("expected the host key of the last boot, got %s", HostKeyFingerprints(got))
+	}
+	if got = ParseConsoleHostKeys(consoleHostKeysBegin + "\n" + line(keys[0])); len(got) != 0 {
+		t.Errorf("expected no host keys until they are all printed, got %s", HostKeyFingerprints(got))
+	}
+
+	// the recorded host keys are parsed back
+	parsed, err := ParseHostKeys(MarshalHostKeys(keys...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 2 || !containsKey(parsed, keys[0]) || !containsKey(parsed, keys[1]) {
+		t.Errorf("expected the recorded host keys, got %s", HostKeyFingerprints(parsed))
+	}
+	if _, err = ParseHostKeys("ssh-ed25519 invalid"); err == nil {
+		t.Error("expected an invalid host key to be rejected")
+	}
+}
@@ -1,21 +1,30 @@
 package lehelper
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/drone-runners/drone-runner-aws/internal/cloudinit"
-	"github.com/drone-runners/drone-runner-aws/internal/oshelp"
+	"github.com/drone-runners/drone-runner-aws/internal/fips"
+	"github.com/drone-runners/drone-runner-aws/internal/leasetoken"
+	"github.com/drone-runners/drone-runner-aws/internal/route"
+	"github.com/drone-runners/drone-runner-aws/internal/tunnel"
 	"github.com/drone-runners/drone-runner-aws/types"
 	"github.com/harness/lite-engine/api"
 	lehttp "github.com/harness/lite-engine/cli/client"
 )
 
 const (
-	LiteEnginePort = 9079
+	LiteEnginePort = cloudinit.DefaultLiteEnginePort
 )
 
-func GenerateUserdata(userdata string, opts *types.InstanceCreateOpts) string {
+// GenerateUserdata returns the startup script of the instance, the custom user data template if set.
+func GenerateUserdata(userdata string, opts *types.InstanceCreateOpts) (string, error) {
 	var params = cloudinit.Params{
 		Platform:             opts.Platform,
 		CACert:               string(opts.CACert),
@@ -25,28 +34,73 @@ func GenerateUserdata(userdata string, opts *types.InstanceCreateOpts) string {
 		HarnessTestBinaryURI: opts.HarnessTestBinaryURI,
 		PluginBinaryURI:      opts.PluginBinaryURI,
 		Tmate:                opts.Tmate,
+		Persistent:           opts.Persistent,
+		LiteEnginePort:       opts.LiteEnginePort,
+		Tunnel:               opts.Tunnel,
+		Slim:                 opts.Slim,
+		Tuning:               opts.Tuning,
+		Checksums:            opts.Checksums,
+		WindowsContainers:    opts.WindowsContainers,
+		LiteEngineEnv:        opts.LiteEngineEnv,
+		LiteEngineFeatures:   opts.LiteEngineFeatures,
+		LeaseKey:             opts.LeaseKey,
+		KeyFetch:             opts.KeyFetch,
+	}
+	if params.KeyFetch != "" {
+		// the key is fetched by the instance, it's not even handed to a custom template.
+		params.TLSKey = ""
 	}
 
-	if userdata == "" {
-		if opts.OS == oshelp.OSWindows {
-			userdata = cloudinit.Windows(&params)
-		} else if opts.OS == oshelp.OSMac {
-			userdata = cloudinit.Mac(&params)
-		} else {
-			userdata = cloudinit.Linux(&params)
-		}
-	} else {
-		userdata, _ = cloudinit.Custom(userdata, &params)
+	provider, err := cloudinit.NewProvider(opts.StartupScript, userdata)
+	if err != nil {
+		return "", err
+	}
+	userdata, err = provider.Generate(&params)
+	if err != nil {
+		return "", fmt.Errorf("could not generate the startup script: %w", err)
 	}
-	return userdata
+	return userdata, nil
 }
 
 func GetClient(instance *types.Instance, runnerName string, liteEnginePort int64, mock bool, mockTimeoutSecs int) (lehttp.Client, error) {
-	leURL := fmt.Sprintf("https://%s:%d/", instance.Address, liteEnginePort)
+	// the address is bracketed if it's an IPv6 address.
+	leURL := (&url.URL{Scheme: "https", Host: net.JoinHostPort(route.Address(instance), strconv.FormatInt(liteEnginePort, 10)), Path: "/"}).String()
 	if mock {
 		return lehttp.NewNoopClient(&api.PollStepResponse{}, nil, time.Duration(mockTimeoutSecs)*time.Second, 0, 0), nil
 	}
-	return lehttp.NewHTTPClient(leURL,
+	client, err := lehttp.NewHTTPClient(leURL,
 		runnerName, string(instance.CACert),
 		string(instance.TLSCert), string(instance.TLSKey))
+	if err != nil {
+		return nil, err
+	}
+	transport, ok := client.Client.Transport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("unexpected transport %T of the lite-engine client", client.Client.Transport)
+	}
+	transport.TLSClientConfig = fips.Apply(transport.TLSClientConfig)
+	// the lite-engine only accepts the calls signed with the lease key of the instance.
+	if instance.LeaseKey != "" {
+		client.Client.Transport = leasetoken.Transport(transport, instance.LeaseKey)
+	}
+	if instance.Route == types.RouteSSM || instance.Route == types.RouteIAP {
+		// the runner has no route to the instance, the connections go through the forward of its proxied route.
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return route.Dial(ctx, instance, int(liteEnginePort))
+		}
+		return client, nil
+	}
+	if !instance.Tunnel {
+		return client, nil
+	}
+
+	// the instance is not reachable, the connections go through the tunnel the instance dialed.
+	srv := tunnel.Default()
+	if srv == nil {
+		return nil, fmt.Errorf("the tunnel of instance %s is not enabled on the runner", instance.ID)
+	}
+	transport.DialContext = func(_ context.Context, _, _ string) (net.Conn, error) {
+		return srv.Dial(instance)
+	}
+	return client, nil
 }
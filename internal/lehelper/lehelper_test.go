@@ -0,0 +1,51 @@
+package lehelper
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/internal/certs"
+	"github.com/drone-runners/drone-runner-aws/internal/cloudinit"
+	"github.com/drone-runners/drone-runner-aws/internal/oshelp"
+	"github.com/drone-runners/drone-runner-aws/types"
+	lehttp "github.com/harness/lite-engine/cli/client"
+)
+
+func TestGenerateUserdata(t *testing.T) {
+	opts := &types.InstanceCreateOpts{
+		Platform:      types.Platform{OS: oshelp.OSLinux, Arch: oshelp.ArchAMD64},
+		StartupScript: cloudinit.ProviderIgnition,
+	}
+	if userdata, err := GenerateUserdata("", opts); err != nil || userdata == "" {
+		t.Errorf("expected ignition config on linux, got %q, %v", userdata, err)
+	}
+
+	opts.Platform.OS = oshelp.OSWindows
+	if _, err := GenerateUserdata("", opts); err == nil {
+		t.Error("expected an error generating an ignition config on windows")
+	}
+
+	opts.StartupScript = "unknown"
+	if _, err := GenerateUserdata("", opts); err == nil {
+		t.Error("expected an error for an unknown startup script provider")
+	}
+}
+
+func TestGetClientIPv6(t *testing.T) {
+	opts, err := certs.Generate("runner", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for address, want := range map[string]string{
+		"10.0.0.1":     "https://10.0.0.1:9079/",
+		"2600:1f18::1": "https://[2600:1f18::1]:9079/",
+	} {
+		instance := &types.Instance{Address: address, CACert: opts.CACert, TLSCert: opts.TLSCert, TLSKey: opts.TLSKey}
+		client, clientErr := GetClient(instance, "runner", LiteEnginePort, false, 0)
+		if clientErr != nil {
+			t.Fatal(clientErr)
+		}
+		if endpoint := client.(*lehttp.HTTPClient).Endpoint; endpoint != want {
+			t.Errorf("want endpoint %s, got %s", want, endpoint)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package lehelper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/cloudinit"
+	"github.com/drone-runners/drone-runner-aws/internal/oshelp"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/drone/runner-go/logger"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Bootstrap modes.
+const (
+	BootstrapUserData = "user-data"
+	BootstrapSSH      = "ssh"
+)
+
+const (
+	sshDefaultUser    = "root"
+	sshDefaultPort    = 22
+	sshDefaultTimeout = 5 * time.Minute
+	sshRetryInterval  = 5 * time.Second
+	sshDialTimeout    = 10 * time.Second
+	sshScriptTemplate = "/tmp/lite-engine-bootstrap.XXXXXX"
+)
+
+var errHostKeyMismatch = errors.New("ssh: host key of the instance changed")
+
+// SSHBootstrapper installs the lite-engine on instances which can't run user data, by connecting
+// to the instance over ssh and running the startup script.
+type SSHBootstrapper struct {
+	user            string
+	port            int
+	timeout         time.Duration
+	signer          ssh.Signer
+	knownHostsCheck ssh.HostKeyCallback
+	consoleHostKeys bool // the host key must be one the instance printed to its console
+}
+
+// NewBootstrapper returns the bootstrapper for the pool, nil if the startup script is passed as user data.
+func NewBootstrapper(conf *types.Bootstrap, platform *types.Platform) (*SSHBootstrapper, error) {
+	switch conf.Mode {
+	case "", BootstrapUserData:
+		return nil, nil
+	case BootstrapSSH:
+	default:
+		return nil, fmt.Errorf("unknown bootstrap mode %q", conf.Mode)
+	}
+
+	if platform.OS == oshelp.OSWindows {
+		return nil, errors.New("ssh bootstrap is not supported on windows")
+	}
+	if conf.PrivateKeyPath == "" {
+		return nil, errors.New("ssh bootstrap requires a private key")
+	}
+	key, err := os.ReadFile(conf.PrivateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the ssh private key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the ssh private key: %w", err)
+	}
+
+	b := &SSHBootstrapper{
+		user:            conf.User,
+		port:            conf.Port,
+		timeout:         time.Duration(conf.TimeoutSecs) * time.Second,
+		signer:          signer,
+		consoleHostKeys: conf.ConsoleHostKeys,
+	}
+	if b.user == "" {
+		b.user = sshDefaultUser
+	}
+	if b.port == 0 {
+		b.port = sshDefaultPort
+	}
+	if b.timeout <= 0 {
+		b.timeout = sshDefaultTimeout
+	}
+	if conf.KnownHostsPath != "" {
+		b.knownHostsCheck, err = knownhosts.New(conf.KnownHostsPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the known hosts file: %w", err)
+		}
+	}
+	return b, nil
+}
+
+// GenerateStartupScript generates the startup script the bootstrapper runs on the instance. Unlike
+// user data it must be executable by a shell, so the shell startup script is always used.
+func GenerateStartupScript(opts *types.InstanceCreateOpts) (string, error) {
+	return (&cloudinit.ShellProvider{}).Generate(&cloudinit.Params{
+		Platform:             opts.Platform,
+		CACert:               string(opts.CACert),
+		TLSCert:              string(opts.TLSCert),
+		TLSKey:               string(opts.TLSKey),
+		LiteEnginePath:       opts.LiteEnginePath,
+		HarnessTestBinaryURI: opts.HarnessTestBinaryURI,
+		PluginBinaryURI:      opts.PluginBinaryURI,
+		Tmate:                opts.Tmate,
+		LiteEnginePort:       opts.LiteEnginePort,
+		Tunnel:               opts.Tunnel,
+		Slim:                 opts.Slim,
+		Tuning:               opts.Tuning,
+		Checksums:            opts.Checksums,
+		WindowsContainers:    opts.WindowsContainers,
+		LiteEngineEnv:        opts.LiteEngineEnv,
+		LiteEngineFeatures:   opts.LiteEngineFeatures,
+		LeaseKey:             opts.LeaseKey,
+	})
+}
+
+// Bootstrap connects to the instance and runs the startup script. The instance might still be
+// booting, so connecting is retried until the timeout expires. The host key of the instance is
+// verified against the host keys recorded for the instance, if any, and it is recorded as the
+// host key of the instance once the script has run. console returns the console output of the
+// instance, nil if the driver doesn't read it.
+func (b *SSHBootstrapper) Bootstrap(ctx context.Context, instance *types.Instance, script string, console ConsoleFunc) error {
+	logr := logger.FromContext(ctx).
+		WithField("instance", instance.ID).
+		WithField("address", instance.Address)
+
+	recorded, err := ParseHostKeys(instance.HostKey)
+	if err != nil {
+		return fmt.Errorf("ssh bootstrap: %w", err)
+	}
+	if b.consoleHostKeys && len(recorded) == 0 && console == nil {
+		return errors.New("ssh bootstrap: the console output of the instance is not available to verify its host key")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+
+	hostKey := b.hostKeyCallback(recorded)
+	addr := net.JoinHostPort(instance.Address, strconv.Itoa(b.port))
+
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		err = b.trustConsoleKeys(ctx, hostKey, console)
+		if err == nil {
+			var client *ssh.Client
+			if client, err = b.dial(ctx, addr, hostKey); err == nil {
+				err = b.run(client, script)
+				client.Close()
+				if err != nil {
+					// the script failed to run, retrying it is not safe.
+					return err
+				}
+				accepted := hostKey.accepted()
+				instance.HostKey = MarshalHostKeys(accepted)
+				logr.WithField("attempt", attempt).
+					WithField("host_key", ssh.FingerprintSHA256(accepted)).
+					Debugln("ssh bootstrap: startup script executed")
+				return nil
+			}
+			if errors.Is(hostKey.err(), errHostKeyMismatch) || isKeyError(hostKey.err()) {
+				return fmt.Errorf("ssh bootstrap: host key verification failed: %w", hostKey.err())
+			}
+		}
+
+		lastErr = err
+		logr.WithError(err).WithField("attempt", attempt).Traceln("ssh bootstrap: instance not reachable yet")
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("ssh bootstrap: failed to connect to the instance: %w", lastErr)
+		case <-time.After(sshRetryInterval):
+		}
+	}
+}
+
+// trustConsoleKeys reads the host keys the instance printed to its console, with the console host
+// keys and until the instance printed them, the host key of the instance must be one of them.
+func (b *SSHBootstrapper) trustConsoleKeys(ctx context.Context, hostKey *hostKeyChecker, console ConsoleFunc) error {
+	if !b.consoleHostKeys || hostKey.hasTrusted() {
+		return nil
+	}
+	out, err := console(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read the console output: %w", err)
+	}
+	keys := ParseConsoleHostKeys(out)
+	if len(keys) == 0 {
+		return errors.New("the instance has not printed its host keys to its console yet")
+	}
+	hostKey.trust(keys)
+	return nil
+}
+
+func (b *SSHBootstrapper) dial(ctx context.Context, addr string, hostKey *hostKeyChecker) (*ssh.Client, error) {
+	dialer := net.Dialer{Timeout: sshDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	config := &ssh.ClientConfig{
+		User:            b.user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(b.signer)},
+		HostKeyCallback: hostKey.check,
+		Timeout:         sshDialTimeout,
+	}
+	// the handshake doesn't honor the context.
+	_ = conn.SetDeadline(time.Now().Add(sshDialTimeout))
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// run uploads the script and runs it. The script starts the lite-engine in the background, so it is
+// run from a file with stdin detached, otherwise the session would wait for the lite-engine to exit.
+// The script holds the certificates of the lite-engine, the file is only readable by the user and
+// it is removed once the script has run.
+func (b *SSHBootstrapper) run(client *ssh.Client, script string) error {
+	sudo := ""
+	if b.user != sshDefaultUser {
+		sudo = "sudo -n "
+	}
+
+	cmd := fmt.Sprintf(`umask 077 && f=$(mktemp %s) || exit 1
+if ! cat > "$f"; then rm -f "$f"; exit 1; fi
+%sbash "$f" < /dev/null
+rc=$?
+rm -f "$f"
+exit $rc`, sshScriptTemplate, sudo)
+	if err := b.exec(client, cmd, strings.NewReader(script)); err != nil {
+		return fmt.Errorf("ssh bootstrap: failed to run the startup script: %w", err)
+	}
+	return nil
+}
+
+func (b *SSHBootstrapper) exec(client *ssh.Client, cmd string, stdin *strings.Reader) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	if stdin != nil {
+		session.Stdin = stdin
+	}
+	if err := session.Run(cmd); err != nil {
+		if stderr.Len() > 0 {
+			return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return err
+	}
+	return nil
+}
+
+// hostKeyChecker verifies the host key against the known hosts file. Without one, the host key must
+// be one of the trusted keys, the keys recorded for the instance or printed to its console, or else
+// the first host key presented by the instance is trusted. The key must not change on the retries
+// that follow.
+type hostKeyChecker struct {
+	mu         sync.Mutex
+	knownHosts ssh.HostKeyCallback
+	trusted    []ssh.PublicKey
+	pinned     ssh.PublicKey
+	lastErr    error
+}
+
+func (b *SSHBootstrapper) hostKeyCallback(trusted []ssh.PublicKey) *hostKeyChecker {
+	return &hostKeyChecker{knownHosts: b.knownHostsCheck, trusted: trusted}
+}
+
+func (c *hostKeyChecker) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.knownHosts != nil {
+		if c.lastErr = c.knownHosts(hostname, remote, key); c.lastErr == nil {
+			c.pinned = key
+		}
+		return c.lastErr
+	}
+	if c.pinned == nil {
+		if len(c.trusted) > 0 && !containsKey(c.trusted, key) {
+			c.lastErr = fmt.Errorf("%w: want one of %s, got %s", errHostKeyMismatch,
+				HostKeyFingerprints(c.trusted), ssh.FingerprintSHA256(key))
+			return c.lastErr
+		}
+		c.pinned = key
+		return nil
+	}
+	if !bytes.Equal(c.pinned.Marshal(), key.Marshal()) {
+		c.lastErr = fmt.Errorf("%w: want %s, got %s", errHostKeyMismatch,
+			ssh.FingerprintSHA256(c.pinned), ssh.FingerprintSHA256(key))
+		return c.lastErr
+	}
+	return nil
+}
+
+func (c *hostKeyChecker) trust(keys []ssh.PublicKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.trusted = keys
+}
+
+func (c *hostKeyChecker) hasTrusted() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.trusted) > 0 || c.knownHosts != nil
+}
+
+// accepted returns the host key the instance presented.
+func (c *hostKeyChecker) accepted() ssh.PublicKey {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pinned
+}
+
+func (c *hostKeyChecker) err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastErr
+}
+
+func isKeyError(err error) bool {
+	var keyErr *knownhosts.KeyError
+	return errors.As(err, &keyErr)
+}
@@ -0,0 +1,51 @@
+package lehelper
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestHostKeyChecker(t *testing.T) {
+	key := func() ssh.PublicKey {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sshKey, err := ssh.NewPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sshKey
+	}
+	first, second := key(), key()
+
+	c := (&SSHBootstrapper{}).hostKeyCallback(nil)
+	if err := c.check("10.0.0.1:22", nil, first); err != nil {
+		t.Errorf("the first host key must be trusted, got %s", err)
+	}
+	if err := c.check("10.0.0.1:22", nil, first); err != nil {
+		t.Errorf("the pinned host key must be trusted, got %s", err)
+	}
+	if err := c.check("10.0.0.1:22", nil, second); !errors.Is(err, errHostKeyMismatch) {
+		t.Errorf("a changed host key must be rejected, got %v", err)
+	}
+	if !errors.Is(c.err(), errHostKeyMismatch) {
+		t.Errorf("the host key mismatch must be recorded")
+	}
+
+	// the recorded host keys or the ones printed to the console are the only ones trusted
+	c = (&SSHBootstrapper{}).hostKeyCallback([]ssh.PublicKey{second})
+	if err := c.check("10.0.0.1:22", nil, first); !errors.Is(err, errHostKeyMismatch) {
+		t.Errorf("a host key which is not trusted must be rejected, got %v", err)
+	}
+	if err := c.check("10.0.0.1:22", nil, second); err != nil {
+		t.Errorf("the trusted host key must be accepted, got %s", err)
+	}
+	if accepted := c.accepted(); accepted == nil || ssh.FingerprintSHA256(accepted) != ssh.FingerprintSHA256(second) {
+		t.Errorf("the accepted host key must be recorded")
+	}
+}
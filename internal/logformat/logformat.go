@@ -0,0 +1,82 @@
+// Package logformat formats the logs of the runner for the log pipelines, e.g. a SIEM.
+package logformat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Formats of the logs of the runner.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// The fields identifying the objects a log line is about, the JSON logs use them whatever the
+// name the line was logged with.
+const (
+	FieldStage    = "stage_id"
+	FieldPool     = "pool"
+	FieldDriver   = "driver"
+	FieldInstance = "instance_id"
+	FieldAccount  = "account_id"
+)
+
+// aliases are the names of the fields logged before the names were made consistent.
+var aliases = map[string]string{
+	"stage_runtime_id": FieldStage,
+	"stageId":          FieldStage,
+	"pool_id":          FieldPool,
+	"instance":         FieldInstance,
+	"instanceID":       FieldInstance,
+	"accountID":        FieldAccount,
+}
+
+// JSONFormatter writes a JSON object per line, with the consistent names of the fields and the
+// timestamps in RFC 3339 with nanoseconds.
+type JSONFormatter struct {
+	logrus.JSONFormatter
+}
+
+// NewJSON returns the JSON formatter.
+func NewJSON() *JSONFormatter {
+	return &JSONFormatter{JSONFormatter: logrus.JSONFormatter{TimestampFormat: "2006-01-02T15:04:05.000000000Z07:00"}}
+}
+
+func (f *JSONFormatter) Format(e *logrus.Entry) ([]byte, error) {
+	renamed := false
+	for k := range e.Data {
+		if _, ok := aliases[k]; ok {
+			renamed = true
+			break
+		}
+	}
+	if !renamed {
+		return f.JSONFormatter.Format(e)
+	}
+
+	data := make(logrus.Fields, len(e.Data))
+	for k, v := range e.Data {
+		if name, ok := aliases[k]; ok {
+			if _, set := e.Data[name]; set {
+				continue // the field logged with its consistent name wins
+			}
+			k = name
+		}
+		data[k] = v
+	}
+	c := *e
+	c.Data = data
+	return f.JSONFormatter.Format(&c)
+}
+
+// ParseLevel returns the log level, e.g. debug.
+func ParseLevel(s string) (logrus.Level, error) {
+	level, err := logrus.ParseLevel(strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("unknown log level %q, expected one of panic, fatal, error, warn, info, debug or trace", s)
+	}
+	return level, nil
+}
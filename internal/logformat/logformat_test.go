@@ -0,0 +1,53 @@
+package logformat
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestJSONFormatter(t *testing.T) {
+	e := logrus.NewEntry(logrus.New()).WithFields(logrus.Fields{
+		"stage_runtime_id": "stage-1",
+		"pool_id":          "linux",
+		"instanceID":       "i-123",
+		"instance":         "i-456",
+		FieldInstance:      "i-789",
+		FieldDriver:        "amazon",
+	})
+	e.Message = "provisioned"
+	e.Level = logrus.InfoLevel
+
+	b, err := NewJSON().Format(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var line map[string]interface{}
+	if err = json.Unmarshal(b, &line); err != nil {
+		t.Fatalf("the log line is not JSON: %s", b)
+	}
+	want := map[string]string{FieldStage: "stage-1", FieldPool: "linux", FieldInstance: "i-789", FieldDriver: "amazon", "msg": "provisioned", "level": "info"}
+	for k, v := range want {
+		if line[k] != v {
+			t.Errorf("field %s is %v, want %s", k, line[k], v)
+		}
+	}
+	for _, alias := range []string{"stage_runtime_id", "pool_id", "instanceID", "instance"} {
+		if _, ok := line[alias]; ok {
+			t.Errorf("field %s is not renamed", alias)
+		}
+	}
+	if e.Data["pool_id"] != "linux" {
+		t.Error("the fields of the entry are modified")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if level, err := ParseLevel(" debug"); err != nil || level != logrus.DebugLevel {
+		t.Errorf("unexpected level %v, %v", level, err)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("unknown level is accepted")
+	}
+}
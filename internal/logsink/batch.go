@@ -0,0 +1,180 @@
+package logsink
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// BatchOptions configures how the remote sinks send the lines.
+type BatchOptions struct {
+	FlushInterval time.Duration // how often the pending lines are sent
+	BatchSize     int           // the maximum number of lines sent at once
+	MaxInFlight   int           // the maximum number of batches being sent at once
+	MaxBuffered   int           // the maximum number of pending lines, the oldest are dropped beyond
+	Gzip          bool          // compress the batches, if the sink supports it
+}
+
+// DefaultBatchOptions are used for the options which are not set.
+var DefaultBatchOptions = BatchOptions{
+	FlushInterval: time.Second,
+	BatchSize:     500,
+	MaxInFlight:   2,
+	MaxBuffered:   10000,
+}
+
+func (o BatchOptions) withDefaults() BatchOptions {
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = DefaultBatchOptions.FlushInterval
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = DefaultBatchOptions.BatchSize
+	}
+	if o.MaxInFlight <= 0 {
+		o.MaxInFlight = DefaultBatchOptions.MaxInFlight
+	}
+	if o.MaxBuffered < o.BatchSize {
+		o.MaxBuffered = DefaultBatchOptions.MaxBuffered
+		if o.MaxBuffered < o.BatchSize {
+			o.MaxBuffered = o.BatchSize
+		}
+	}
+	return o
+}
+
+// batcher buffers the lines and sends them in batches from the background, so a slow remote
+// never holds the writer. When the sends can't keep up and MaxBuffered lines are pending, the
+// oldest lines are dropped.
+type batcher[T any] struct {
+	opts BatchOptions
+	send func(ctx context.Context, batch []T) error
+
+	mu      sync.Mutex
+	pending []T
+	dropped int
+	err     error // the last send error
+
+	inflight chan struct{}
+	wg       sync.WaitGroup
+	kick     chan struct{}
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+func newBatcher[T any](opts BatchOptions, send func(ctx context.Context, batch []T) error) *batcher[T] {
+	opts = opts.withDefaults()
+	b := &batcher[T]{
+		opts:     opts,
+		send:     send,
+		inflight: make(chan struct{}, opts.MaxInFlight),
+		kick:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// add queues the lines, dropping the oldest pending lines if the buffer is full.
+func (b *batcher[T]) add(lines ...T) {
+	b.mu.Lock()
+	b.pending = append(b.pending, lines...)
+	if over := len(b.pending) - b.opts.MaxBuffered; over > 0 {
+		b.pending = append(b.pending[:0:0], b.pending[over:]...)
+		b.dropped += over
+	}
+	full := len(b.pending) >= b.opts.BatchSize
+	b.mu.Unlock()
+	if full {
+		b.nudge()
+	}
+}
+
+func (b *batcher[T]) nudge() {
+	select {
+	case b.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (b *batcher[T]) run() {
+	defer close(b.stopped)
+	ticker := time.NewTicker(b.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		case <-b.kick:
+		}
+		b.dispatch(false)
+	}
+}
+
+// dispatch sends the pending lines in batches, it leaves them pending when MaxInFlight batches are
+// being sent unless wait is set.
+func (b *batcher[T]) dispatch(wait bool) {
+	for {
+		b.mu.Lock()
+		if len(b.pending) == 0 {
+			b.mu.Unlock()
+			return
+		}
+		if wait {
+			b.mu.Unlock()
+			b.inflight <- struct{}{}
+			b.mu.Lock()
+		} else {
+			select {
+			case b.inflight <- struct{}{}:
+			default:
+				b.mu.Unlock()
+				return
+			}
+		}
+		n := len(b.pending)
+		if n == 0 {
+			<-b.inflight
+			b.mu.Unlock()
+			return
+		}
+		if n > b.opts.BatchSize {
+			n = b.opts.BatchSize
+		}
+		batch := b.pending[:n:n]
+		b.pending = b.pending[n:]
+		b.mu.Unlock()
+
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			defer func() {
+				<-b.inflight
+				b.nudge()
+			}()
+			if err := b.send(context.Background(), batch); err != nil {
+				b.mu.Lock()
+				b.err = err
+				b.mu.Unlock()
+			}
+		}()
+	}
+}
+
+// close sends the pending lines and waits for the sends, it returns the last send error.
+func (b *batcher[T]) close() error {
+	close(b.done)
+	<-b.stopped
+	b.dispatch(true)
+	b.wg.Wait()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dropped > 0 {
+		logrus.WithField("dropped", b.dropped).Warnln("logsink: dropped the oldest log lines, the sink could not keep up")
+	}
+	return b.err
+}
@@ -0,0 +1,66 @@
+package logsink
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatcher(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]int
+	b := newBatcher(BatchOptions{FlushInterval: time.Hour, BatchSize: 2, MaxBuffered: 10}, func(_ context.Context, batch []int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, batch)
+		return nil
+	})
+	b.add(1, 2, 3)
+	if err := b.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var lines, maxBatch int
+	for _, batch := range batches {
+		lines += len(batch)
+		if len(batch) > maxBatch {
+			maxBatch = len(batch)
+		}
+	}
+	if lines != 3 || maxBatch > 2 {
+		t.Errorf("expected 3 lines in batches of 2 at most, got %v", batches)
+	}
+}
+
+func TestBatcherDropsOldest(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var sent []int
+	b := newBatcher(BatchOptions{FlushInterval: time.Hour, BatchSize: 1, MaxInFlight: 1, MaxBuffered: 2}, func(_ context.Context, batch []int) error {
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, batch...)
+		return nil
+	})
+	// the first line is being sent and holds the only slot, the next lines pile up.
+	b.add(1)
+	time.Sleep(50 * time.Millisecond)
+	b.add(2, 3, 4, 5)
+	close(release)
+	if err := b.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sent) != 3 || sent[0] != 1 || sent[1] != 4 || sent[2] != 5 {
+		t.Errorf("expected the oldest pending lines to be dropped, got %v", sent)
+	}
+	if b.dropped != 2 {
+		t.Errorf("expected 2 dropped lines, got %d", b.dropped)
+	}
+}
@@ -0,0 +1,25 @@
+package logsink
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// fileSink writes the logs of every key to a file of the directory.
+type fileSink struct {
+	dir string
+}
+
+func (s *fileSink) Open(_ context.Context, key, _ string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("logsink: could not create the log directory: %w", err)
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, objectName(key)), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("logsink: could not open the log file: %w", err)
+	}
+	return f, nil
+}
@@ -0,0 +1,63 @@
+package logsink
+
+import (
+	"context"
+	"io"
+
+	leapi "github.com/harness/lite-engine/api"
+	lelivelog "github.com/harness/lite-engine/livelog"
+	"github.com/harness/lite-engine/logstream"
+	lestream "github.com/harness/lite-engine/logstream/remote"
+	"github.com/sirupsen/logrus"
+)
+
+// harnessSink streams the logs to the Harness log service of the log config of the request.
+type harnessSink struct {
+	cfg  leapi.LogConfig
+	opts BatchOptions
+}
+
+// NewHarness returns the sink streaming to the Harness log service of the log config.
+func NewHarness(cfg leapi.LogConfig, opts BatchOptions) Sink {
+	return &harnessSink{cfg: cfg, opts: opts}
+}
+
+func (s *harnessSink) Open(_ context.Context, key, correlationID string) (io.WriteCloser, error) {
+	client := newBatchingClient(lestream.NewHTTPClient(s.cfg.URL, s.cfg.AccountID,
+		s.cfg.Token, s.cfg.IndirectUpload, false), key, s.opts)
+	wc := lelivelog.New(client, key, correlationID, nil)
+	go func() {
+		if err := wc.Open(); err != nil {
+			logrus.WithError(err).Debugln("failed to open log stream")
+		}
+	}()
+	return wc, nil
+}
+
+// batchingClient sends the lines of the live stream from the background in batches, so a slow log
+// service doesn't hold the setup. The full history is still uploaded when the stream is closed.
+type batchingClient struct {
+	logstream.Client
+	batcher *batcher[*logstream.Line]
+}
+
+func newBatchingClient(client logstream.Client, key string, opts BatchOptions) *batchingClient {
+	return &batchingClient{
+		Client: client,
+		batcher: newBatcher(opts, func(ctx context.Context, lines []*logstream.Line) error {
+			return client.Write(ctx, key, lines)
+		}),
+	}
+}
+
+func (c *batchingClient) Write(_ context.Context, _ string, lines []*logstream.Line) error {
+	c.batcher.add(lines...)
+	return nil
+}
+
+func (c *batchingClient) Close(ctx context.Context, key string) error {
+	if err := c.batcher.close(); err != nil {
+		logrus.WithError(err).WithField("key", key).Debugln("failed to stream log lines")
+	}
+	return c.Client.Close(ctx, key)
+}
@@ -0,0 +1,95 @@
+package logsink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const lokiPushTimeout = 10 * time.Second
+
+// lokiSink pushes the logs to the push api of loki, a stream per key.
+type lokiSink struct {
+	url    string
+	labels map[string]string
+	opts   BatchOptions
+	client *http.Client
+}
+
+func newLokiSink(url string, labels map[string]string, opts BatchOptions) *lokiSink {
+	return &lokiSink{url: url, labels: labels, opts: opts, client: &http.Client{Timeout: lokiPushTimeout}}
+}
+
+func (s *lokiSink) Open(_ context.Context, key, correlationID string) (io.WriteCloser, error) {
+	labels := map[string]string{"job": "drone-runner-aws", "key": key}
+	if correlationID != "" {
+		labels["correlation_id"] = correlationID
+	}
+	for k, v := range s.labels {
+		labels[k] = v
+	}
+	b := newBatcher(s.opts, func(ctx context.Context, entries [][2]string) error {
+		return s.push(ctx, labels, entries)
+	})
+	w := &lokiWriter{batcher: b}
+	w.lineWriter = &lineWriter{handler: func(line string) error {
+		b.add([2]string{strconv.FormatInt(time.Now().UnixNano(), 10), line})
+		return nil
+	}}
+	return w, nil
+}
+
+// push sends the lines of a stream, gzipped if enabled.
+func (s *lokiSink) push(ctx context.Context, labels map[string]string, entries [][2]string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"streams": []map[string]interface{}{{"stream": labels, "values": entries}},
+	})
+	if err != nil {
+		return err
+	}
+	if s.opts.Gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err = zw.Write(body); err != nil {
+			return err
+		}
+		if err = zw.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.opts.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("logsink: could not push the logs: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("logsink: could not push the logs: loki responded with %s", resp.Status)
+	}
+	return nil
+}
+
+type lokiWriter struct {
+	*lineWriter
+	batcher *batcher[[2]string]
+}
+
+func (w *lokiWriter) Close() error {
+	_ = w.flush()
+	return w.batcher.close()
+}
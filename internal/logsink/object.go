@@ -0,0 +1,103 @@
+package logsink
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"google.golang.org/api/storage/v1"
+)
+
+// uploadTimeout bounds the upload of the logs of a stage, the logs are uploaded when the setup ends.
+const uploadTimeout = 5 * time.Minute
+
+// uploader uploads an object to a bucket.
+type uploader interface {
+	Upload(ctx context.Context, name string, r io.Reader) error
+}
+
+func newUploader(ctx context.Context, cfg *Config) (uploader, error) {
+	if cfg.Type == TypeGCS {
+		service, err := storage.NewService(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("logsink: could not create the gcs client: %w", err)
+		}
+		return &gcsUploader{service: service, bucket: cfg.Bucket}, nil
+	}
+	awsConfig := aws.NewConfig()
+	if cfg.Region != "" {
+		awsConfig = awsConfig.WithRegion(cfg.Region)
+	}
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("logsink: could not create the s3 session: %w", err)
+	}
+	return &s3Uploader{uploader: s3manager.NewUploader(sess), bucket: cfg.Bucket}, nil
+}
+
+// objectSink uploads the logs of every stage as an object, the logs are kept in a temporary file
+// until the writer is closed.
+type objectSink struct {
+	uploader uploader
+	prefix   string
+}
+
+func (s *objectSink) Open(_ context.Context, key, _ string) (io.WriteCloser, error) {
+	f, err := os.CreateTemp("", "logsink-*.log")
+	if err != nil {
+		return nil, fmt.Errorf("logsink: could not create the log buffer: %w", err)
+	}
+	return &objectWriter{File: f, uploader: s.uploader, name: path.Join(s.prefix, objectName(key))}, nil
+}
+
+type objectWriter struct {
+	*os.File
+	uploader uploader
+	name     string
+}
+
+func (w *objectWriter) Close() error {
+	defer os.Remove(w.File.Name())
+	defer w.File.Close()
+	if _, err := w.File.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+	if err := w.uploader.Upload(ctx, w.name, w.File); err != nil {
+		return fmt.Errorf("logsink: could not upload %s: %w", w.name, err)
+	}
+	return nil
+}
+
+type s3Uploader struct {
+	uploader *s3manager.Uploader
+	bucket   string
+}
+
+func (u *s3Uploader) Upload(ctx context.Context, name string, r io.Reader) error {
+	_, err := u.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(u.bucket),
+		Key:         aws.String(name),
+		Body:        r,
+		ContentType: aws.String("text/plain"),
+	})
+	return err
+}
+
+type gcsUploader struct {
+	service *storage.Service
+	bucket  string
+}
+
+func (u *gcsUploader) Upload(ctx context.Context, name string, r io.Reader) error {
+	_, err := u.service.Objects.Insert(u.bucket, &storage.Object{Name: name, ContentType: "text/plain"}).
+		Media(r).Context(ctx).Do()
+	return err
+}
@@ -0,0 +1,120 @@
+// Package logsink streams the logs of the setup of a stage to a configurable destination, the Harness
+// log service when the request carries a log config, the sink configured on the runner otherwise.
+package logsink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Sink types.
+const (
+	TypeStdout     = "stdout"
+	TypeStdoutJSON = "stdout-json"
+	TypeFile       = "file"
+	TypeS3         = "s3"
+	TypeGCS        = "gcs"
+	TypeLoki       = "loki"
+)
+
+// Sink streams the logs of a stage.
+type Sink interface {
+	// Open returns the writer of the logs of the key, the logs are flushed when the writer is closed.
+	Open(ctx context.Context, key, correlationID string) (io.WriteCloser, error)
+}
+
+// Config configures the sink of the runner.
+type Config struct {
+	Type   string
+	Path   string            // the directory of the file sink
+	Bucket string            // the bucket of the s3 and gcs sinks
+	Prefix string            // the prefix of the objects of the s3 and gcs sinks
+	Region string            // the region of the s3 bucket
+	URL    string            // the push endpoint of the loki sink
+	Labels map[string]string // the labels of the loki streams
+	Batch  BatchOptions      // how the remote sinks send the lines
+}
+
+// New returns the sink of the config, the stdout sink if no type is set.
+func New(ctx context.Context, cfg *Config) (Sink, error) {
+	switch cfg.Type {
+	case "", TypeStdout:
+		return &stdoutSink{}, nil
+	case TypeStdoutJSON:
+		return &jsonSink{}, nil
+	case TypeFile:
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("logsink: the directory of the %s sink is not set", cfg.Type)
+		}
+		return &fileSink{dir: cfg.Path}, nil
+	case TypeS3, TypeGCS:
+		if cfg.Bucket == "" {
+			return nil, fmt.Errorf("logsink: the bucket of the %s sink is not set", cfg.Type)
+		}
+		uploader, err := newUploader(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &objectSink{uploader: uploader, prefix: cfg.Prefix}, nil
+	case TypeLoki:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("logsink: the url of the %s sink is not set", cfg.Type)
+		}
+		return newLokiSink(cfg.URL, cfg.Labels, cfg.Batch), nil
+	default:
+		return nil, fmt.Errorf("logsink: unknown sink %q", cfg.Type)
+	}
+}
+
+// objectName returns a name for the key safe to use as a file or object name.
+func objectName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	return path.Clean(strings.TrimLeft(name, ".")) + ".log"
+}
+
+// lineWriter calls the handler with every complete line written, the writes don't have to be aligned on lines.
+type lineWriter struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	handler func(line string) error
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := string(w.buf.Next(i + 1))
+		if err := w.handler(strings.TrimRight(line, "\r\n")); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// flush handles the last line if it's not terminated.
+func (w *lineWriter) flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	line := w.buf.String()
+	w.buf.Reset()
+	return w.handler(line)
+}
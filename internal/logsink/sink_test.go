@@ -0,0 +1,137 @@
+package logsink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	for _, cfg := range []*Config{
+		{Type: "unknown"},
+		{Type: TypeFile},
+		{Type: TypeS3},
+		{Type: TypeLoki},
+	} {
+		if _, err := New(context.Background(), cfg); err == nil {
+			t.Errorf("expected an error for %+v", cfg)
+		}
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir := t.TempDir()
+	w, err := (&fileSink{dir: dir}).Open(context.Background(), "../account/stage:1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "hello\n") //nolint:errcheck
+	w.Close()
+
+	b, err := os.ReadFile(filepath.Join(dir, "_account_stage_1.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello\n" {
+		t.Errorf("unexpected logs %q", b)
+	}
+}
+
+func TestJSONSink(t *testing.T) {
+	var out bytes.Buffer
+	w, _ := (&jsonSink{out: &out}).Open(context.Background(), "key", "correlation")
+	io.WriteString(w, "first\nsec") //nolint:errcheck
+	io.WriteString(w, "ond\nlast")  //nolint:errcheck
+	w.Close()
+
+	var lines []string
+	for _, s := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var line jsonLine
+		if err := json.Unmarshal([]byte(s), &line); err != nil {
+			t.Fatal(err)
+		}
+		if line.Key != "key" || line.CorrelationID != "correlation" {
+			t.Errorf("unexpected line %+v", line)
+		}
+		lines = append(lines, line.Line)
+	}
+	if strings.Join(lines, ",") != "first,second,last" {
+		t.Errorf("unexpected lines %v", lines)
+	}
+}
+
+type memoryUploader map[string]string
+
+func (u memoryUploader) Upload(_ context.Context, name string, r io.Reader) error {
+	b, err := io.ReadAll(r)
+	u[name] = string(b)
+	return err
+}
+
+func TestObjectSink(t *testing.T) {
+	uploaded := memoryUploader{}
+	w, err := (&objectSink{uploader: uploaded, prefix: "logs"}).Open(context.Background(), "stage", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "hello\n") //nolint:errcheck
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if uploaded["logs/stage.log"] != "hello\n" {
+		t.Errorf("unexpected uploads %v", uploaded)
+	}
+}
+
+func TestLokiSink(t *testing.T) {
+	var mu sync.Mutex
+	var values []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Streams []struct {
+				Stream map[string]string `json:"stream"`
+				Values [][2]string       `json:"values"`
+			} `json:"streams"`
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err = json.NewDecoder(zr).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, stream := range body.Streams {
+			if stream.Stream["key"] != "stage" || stream.Stream["env"] != "test" {
+				t.Errorf("unexpected labels %v", stream.Stream)
+			}
+			for _, v := range stream.Values {
+				values = append(values, v[1])
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	w, _ := newLokiSink(server.URL, map[string]string{"env": "test"}, BatchOptions{Gzip: true}).Open(context.Background(), "stage", "")
+	io.WriteString(w, "one\ntwo\n") //nolint:errcheck
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(values, ",") != "one,two" {
+		t.Errorf("unexpected pushed lines %v", values)
+	}
+}
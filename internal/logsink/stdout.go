@@ -0,0 +1,59 @@
+package logsink
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// stdoutSink writes the logs to the stdout of the runner as they are.
+type stdoutSink struct{}
+
+func (s *stdoutSink) Open(_ context.Context, _, _ string) (io.WriteCloser, error) {
+	return nopCloser{os.Stdout}, nil
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// jsonSink writes every line to the stdout of the runner as a json object, for the log collectors
+// of the platform the runner is deployed on.
+type jsonSink struct {
+	out io.Writer // stdout if nil
+}
+
+var stdoutMu sync.Mutex
+
+type jsonLine struct {
+	Time          time.Time `json:"time"`
+	Key           string    `json:"key"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	Line          string    `json:"line"`
+}
+
+func (s *jsonSink) Open(_ context.Context, key, correlationID string) (io.WriteCloser, error) {
+	out := s.out
+	if out == nil {
+		out = os.Stdout
+	}
+	encoder := json.NewEncoder(out)
+	w := &lineWriter{handler: func(line string) error {
+		stdoutMu.Lock()
+		defer stdoutMu.Unlock()
+		return encoder.Encode(jsonLine{Time: time.Now().UTC(), Key: key, CorrelationID: correlationID, Line: line})
+	}}
+	return &flushCloser{lineWriter: w}, nil
+}
+
+// flushCloser flushes the last line of the writer on close.
+type flushCloser struct {
+	*lineWriter
+}
+
+func (w *flushCloser) Close() error {
+	return w.flush()
+}
@@ -0,0 +1,166 @@
+package objectstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// azureSASVersion is the version of the storage service the shared access signatures are signed for.
+const azureSASVersion = "2020-12-06"
+
+// azureListTTL bounds the signatures of the requests of the runner, listing and deleting the entries.
+const azureListTTL = 5 * time.Minute
+
+// azureBackend stores the entries in a container of an azure storage account, the urls carry a
+// service shared access signature signed with the access key of the account.
+type azureBackend struct {
+	client    *http.Client
+	account   string
+	container string
+	key       []byte
+}
+
+func newAzure(cfg *Config) (Backend, error) {
+	if cfg.AzureAccount == "" || cfg.AzureKey == "" {
+		return nil, fmt.Errorf("objectstore: the storage account and the access key of the %s store are not set", cfg.Type)
+	}
+	key, err := base64.StdEncoding.DecodeString(cfg.AzureKey)
+	if err != nil {
+		return nil, fmt.Errorf("objectstore: invalid access key of the storage account: %w", err)
+	}
+	return &azureBackend{client: http.DefaultClient, account: cfg.AzureAccount, container: cfg.Bucket, key: key}, nil
+}
+
+func (b *azureBackend) SignGet(_ context.Context, name string, ttl time.Duration) (*Request, error) {
+	return &Request{URL: b.blobURL(name) + "?" + b.sas("b", "r", name, time.Now().Add(ttl))}, nil
+}
+
+func (b *azureBackend) SignPut(_ context.Context, name string, ttl time.Duration) (*Request, error) {
+	return &Request{
+		URL:     b.blobURL(name) + "?" + b.sas("b", "cw", name, time.Now().Add(ttl)),
+		Headers: map[string]string{"x-ms-blob-type": "BlockBlob"},
+	}, nil
+}
+
+// azureBlobList is the response of the list blobs operation.
+type azureBlobList struct {
+	Blobs []struct {
+		Name       string `xml:"Name"`
+		Properties struct {
+			LastModified  string `xml:"Last-Modified"`
+			ContentLength int64  `xml:"Content-Length"`
+		} `xml:"Properties"`
+	} `xml:"Blobs>Blob"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+func (b *azureBackend) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	marker := ""
+	for {
+		query := b.sas("c", "l", "", time.Now().Add(azureListTTL)) +
+			"&restype=container&comp=list&prefix=" + url.QueryEscape(prefix)
+		if marker != "" {
+			query += "&marker=" + url.QueryEscape(marker)
+		}
+		body, err := b.do(ctx, http.MethodGet, b.containerURL()+"?"+query)
+		if err != nil {
+			return nil, err
+		}
+		var list azureBlobList
+		if err = xml.Unmarshal(body, &list); err != nil {
+			return nil, fmt.Errorf("could not decode the blob list: %w", err)
+		}
+		for i := range list.Blobs {
+			blob := &list.Blobs[i]
+			modified, _ := time.Parse(http.TimeFormat, blob.Properties.LastModified)
+			objects = append(objects, Object{Name: blob.Name, Size: blob.Properties.ContentLength, Modified: modified})
+		}
+		if list.NextMarker == "" {
+			return objects, nil
+		}
+		marker = list.NextMarker
+	}
+}
+
+func (b *azureBackend) Delete(ctx context.Context, name string) error {
+	_, err := b.do(ctx, http.MethodDelete, b.blobURL(name)+"?"+b.sas("b", "d", name, time.Now().Add(azureListTTL)))
+	return err
+}
+
+func (b *azureBackend) do(ctx context.Context, method, rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", azureSASVersion)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: unexpected status %d: %s", method, b.containerURL(), resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+func (b *azureBackend) containerURL() string {
+	return "https://" + b.account + ".blob.core.windows.net/" + b.container
+}
+
+func (b *azureBackend) blobURL(name string) string {
+	segments := strings.Split(name, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	return b.containerURL() + "/" + strings.Join(segments, "/")
+}
+
+// sas returns the query of a service shared access signature of the container (resource c) or of
+// a blob (resource b) with the permissions until the expiry.
+func (b *azureBackend) sas(resource, permissions, name string, expiry time.Time) string {
+	se := expiry.UTC().Format("2006-01-02T15:04:05Z")
+	canonicalResource := "/blob/" + b.account + "/" + b.container
+	if resource == "b" {
+		canonicalResource += "/" + name
+	}
+	stringToSign := strings.Join([]string{
+		permissions,
+		"", // signed start
+		se,
+		canonicalResource,
+		"", // signed identifier
+		"", // signed ip
+		"https",
+		azureSASVersion,
+		resource,
+		"",                 // signed snapshot time
+		"",                 // signed encryption scope
+		"", "", "", "", "", // the response headers, not overridden
+	}, "\n")
+	mac := hmac.New(sha256.New, b.key)
+	mac.Write([]byte(stringToSign))
+
+	query := url.Values{}
+	query.Set("sv", azureSASVersion)
+	query.Set("sr", resource)
+	query.Set("sp", permissions)
+	query.Set("se", se)
+	query.Set("spr", "https")
+	query.Set("sig", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return query.Encode()
+}
@@ -0,0 +1,63 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Backend types.
+const (
+	TypeS3    = "s3"
+	TypeGCS   = "gcs"
+	TypeAzure = "azure"
+)
+
+// Config configures the backend of a bucket.
+type Config struct {
+	Type              string
+	Bucket            string // the container with azure
+	Region            string // the region of the s3 bucket
+	GCSServiceAccount string // the service account signing the gcs urls
+	AzureAccount      string // the storage account of the azure container
+	AzureKey          string // the access key of the storage account
+}
+
+// Request is a signed request the instances send to download or to upload an object.
+type Request struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"` // the headers the request must be sent with
+}
+
+// Object is an object of a bucket.
+type Object struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+}
+
+// Backend signs the requests of the instances and manages the objects of a bucket.
+type Backend interface {
+	SignGet(ctx context.Context, name string, ttl time.Duration) (*Request, error)
+	SignPut(ctx context.Context, name string, ttl time.Duration) (*Request, error)
+	// List returns the objects whose names start with the prefix.
+	List(ctx context.Context, prefix string) ([]Object, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// NewBackend returns the backend of the config.
+func NewBackend(ctx context.Context, cfg *Config) (Backend, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("objectstore: the bucket of the %s store is not set", cfg.Type)
+	}
+	switch cfg.Type {
+	case TypeS3:
+		return newS3(cfg)
+	case TypeGCS:
+		return newGCS(ctx, cfg)
+	case TypeAzure:
+		return newAzure(cfg)
+	default:
+		return nil, fmt.Errorf("objectstore: unknown store %q", cfg.Type)
+	}
+}
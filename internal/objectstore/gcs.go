@@ -0,0 +1,117 @@
+package objectstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/api/storage/v1"
+)
+
+const gcsHost = "storage.googleapis.com"
+
+// gcsBackend stores the entries in a gcs bucket, the urls are signed with the v4 signature of a
+// service account the runner can sign blobs for, without a key of the service account.
+type gcsBackend struct {
+	storage        *storage.Service
+	bucket         string
+	serviceAccount string
+	sign           func(ctx context.Context, payload []byte) ([]byte, error)
+}
+
+func newGCS(ctx context.Context, cfg *Config) (Backend, error) {
+	if cfg.GCSServiceAccount == "" {
+		return nil, fmt.Errorf("objectstore: the service account of the %s store is not set", cfg.Type)
+	}
+	storageService, err := storage.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("objectstore: could not create the gcs client: %w", err)
+	}
+	iamService, err := iamcredentials.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("objectstore: could not create the iam credentials client: %w", err)
+	}
+	name := "projects/-/serviceAccounts/" + cfg.GCSServiceAccount
+	sign := func(ctx context.Context, payload []byte) ([]byte, error) {
+		resp, signErr := iamService.Projects.ServiceAccounts.SignBlob(name, &iamcredentials.SignBlobRequest{
+			Payload: base64.StdEncoding.EncodeToString(payload),
+		}).Context(ctx).Do()
+		if signErr != nil {
+			return nil, signErr
+		}
+		return base64.StdEncoding.DecodeString(resp.SignedBlob)
+	}
+	return &gcsBackend{storage: storageService, bucket: cfg.Bucket, serviceAccount: cfg.GCSServiceAccount, sign: sign}, nil
+}
+
+func (b *gcsBackend) SignGet(ctx context.Context, name string, ttl time.Duration) (*Request, error) {
+	return b.signURL(ctx, "GET", name, ttl, time.Now())
+}
+
+func (b *gcsBackend) SignPut(ctx context.Context, name string, ttl time.Duration) (*Request, error) {
+	return b.signURL(ctx, "PUT", name, ttl, time.Now())
+}
+
+// signURL returns the url signed with the v4 signing process of gcs, the only signed header is
+// the host.
+func (b *gcsBackend) signURL(ctx context.Context, method, name string, ttl time.Duration, now time.Time) (*Request, error) {
+	now = now.UTC()
+	datetime := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/auto/storage/goog4_request"
+
+	segments := strings.Split(name, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	canonicalPath := "/" + b.bucket + "/" + strings.Join(segments, "/")
+
+	query := url.Values{}
+	query.Set("X-Goog-Algorithm", "GOOG4-RSA-SHA256")
+	query.Set("X-Goog-Credential", b.serviceAccount+"/"+scope)
+	query.Set("X-Goog-Date", datetime)
+	query.Set("X-Goog-Expires", strconv.Itoa(int(ttl.Seconds())))
+	query.Set("X-Goog-SignedHeaders", "host")
+	canonicalQuery := query.Encode()
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		canonicalPath,
+		canonicalQuery,
+		"host:" + gcsHost + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	digest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"GOOG4-RSA-SHA256", datetime, scope, hex.EncodeToString(digest[:])}, "\n")
+
+	signature, err := b.sign(ctx, []byte(stringToSign))
+	if err != nil {
+		return nil, fmt.Errorf("could not sign the url: %w", err)
+	}
+	return &Request{
+		URL: "https://" + gcsHost + canonicalPath + "?" + canonicalQuery + "&X-Goog-Signature=" + hex.EncodeToString(signature),
+	}, nil
+}
+
+func (b *gcsBackend) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	err := b.storage.Objects.List(b.bucket).Prefix(prefix).Pages(ctx, func(page *storage.Objects) error {
+		for _, obj := range page.Items {
+			updated, _ := time.Parse(time.RFC3339, obj.Updated)
+			objects = append(objects, Object{Name: obj.Name, Size: int64(obj.Size), Modified: updated})
+		}
+		return nil
+	})
+	return objects, err
+}
+
+func (b *gcsBackend) Delete(ctx context.Context, name string) error {
+	return b.storage.Objects.Delete(b.bucket, name).Context(ctx).Do()
+}
@@ -0,0 +1,80 @@
+// Package objectstore signs the requests the instances send to the buckets, e.g. the downloads of
+// the workspace snapshots and the uploads of the artifacts, so the instances need no credentials.
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Schemes of the object URLs.
+const (
+	SchemeS3    = "s3"
+	SchemeGCS   = "gs"
+	SchemeHTTP  = "http"
+	SchemeHTTPS = "https"
+)
+
+// Location is the location of an object of a bucket, or of a file of a web server.
+type Location struct {
+	Scheme string
+	Bucket string // the host of the http objects
+	Key    string
+}
+
+// Parse parses the URL of an object, e.g. s3://bucket/path/to/workspace.tar.gz.
+func Parse(rawURL string) (Location, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Location{}, fmt.Errorf("objectstore: invalid url %q: %w", rawURL, err)
+	}
+	obj := Location{Scheme: u.Scheme, Bucket: u.Host, Key: strings.TrimPrefix(u.Path, "/")}
+	switch obj.Scheme {
+	case SchemeS3, SchemeGCS, SchemeHTTP, SchemeHTTPS:
+	default:
+		return Location{}, fmt.Errorf("objectstore: unsupported scheme %q, expected one of s3, gs, http or https", u.Scheme)
+	}
+	if obj.Bucket == "" || obj.Key == "" {
+		return Location{}, fmt.Errorf("objectstore: url %q has no bucket or no key", rawURL)
+	}
+	return obj, nil
+}
+
+// DownloadURL returns the URL the instances download the object from. The s3 objects are
+// presigned for ttl with the credentials of the runner, the instances need no access to the
+// bucket. The gs objects are copied by the instances with gsutil and their service account,
+// they are returned as is like the http objects.
+func DownloadURL(ctx context.Context, rawURL string, ttl time.Duration) (string, error) {
+	obj, err := Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if obj.Scheme != SchemeS3 {
+		return rawURL, nil
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return "", fmt.Errorf("objectstore: could not create the s3 session: %w", err)
+	}
+	region, err := s3manager.GetBucketRegion(ctx, sess, obj.Bucket, "us-east-1")
+	if err != nil {
+		return "", fmt.Errorf("objectstore: could not find the region of the bucket %s: %w", obj.Bucket, err)
+	}
+	req, _ := s3.New(sess, aws.NewConfig().WithRegion(region)).GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(obj.Bucket),
+		Key:    aws.String(obj.Key),
+	})
+	signed, err := req.Presign(ttl)
+	if err != nil {
+		return "", fmt.Errorf("objectstore: could not presign %s: %w", rawURL, err)
+	}
+	return signed, nil
+}
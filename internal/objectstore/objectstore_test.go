@@ -0,0 +1,49 @@
+package objectstore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		url  string
+		want Location
+		fail bool
+	}{
+		{url: "s3://bucket/path/to/workspace.tar.gz", want: Location{Scheme: SchemeS3, Bucket: "bucket", Key: "path/to/workspace.tar.gz"}},
+		{url: "gs://bucket/workspace.tar", want: Location{Scheme: SchemeGCS, Bucket: "bucket", Key: "workspace.tar"}},
+		{url: "https://example.com/snapshots/ws.tgz", want: Location{Scheme: SchemeHTTPS, Bucket: "example.com", Key: "snapshots/ws.tgz"}},
+		{url: "ftp://example.com/ws.tgz", fail: true},
+		{url: "s3://bucket", fail: true},
+		{url: "s3:///key", fail: true},
+		{url: "workspace.tar.gz", fail: true},
+	}
+	for _, test := range tests {
+		got, err := Parse(test.url)
+		if test.fail {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.url)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.url, err)
+		} else if got != test.want {
+			t.Errorf("%s: expected %+v, got %+v", test.url, test.want, got)
+		}
+	}
+}
+
+func TestDownloadURL(t *testing.T) {
+	for _, url := range []string{"gs://bucket/workspace.tar", "https://example.com/ws.tgz"} {
+		got, err := DownloadURL(context.Background(), url, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != url {
+			t.Errorf("expected %s to be downloaded as is, got %s", url, got)
+		}
+	}
+}
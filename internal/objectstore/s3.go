@@ -0,0 +1,71 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// s3Backend stores the entries in an s3 bucket, the urls are presigned with the credentials of
+// the runner.
+type s3Backend struct {
+	client *s3.S3
+	bucket string
+}
+
+func newS3(cfg *Config) (Backend, error) {
+	awsConfig := aws.NewConfig()
+	if cfg.Region != "" {
+		awsConfig = awsConfig.WithRegion(cfg.Region)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{Config: *awsConfig, SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return nil, fmt.Errorf("objectstore: could not create the s3 session: %w", err)
+	}
+	return &s3Backend{client: s3.New(sess), bucket: cfg.Bucket}, nil
+}
+
+func (b *s3Backend) SignGet(_ context.Context, name string, ttl time.Duration) (*Request, error) {
+	req, _ := b.client.GetObjectRequest(&s3.GetObjectInput{Bucket: aws.String(b.bucket), Key: aws.String(name)})
+	url, err := req.Presign(ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &Request{URL: url}, nil
+}
+
+func (b *s3Backend) SignPut(_ context.Context, name string, ttl time.Duration) (*Request, error) {
+	req, _ := b.client.PutObjectRequest(&s3.PutObjectInput{Bucket: aws.String(b.bucket), Key: aws.String(name)})
+	url, err := req.Presign(ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &Request{URL: url}, nil
+}
+
+func (b *s3Backend) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	err := b.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range page.Contents {
+			objects = append(objects, Object{
+				Name:     aws.StringValue(obj.Key),
+				Size:     aws.Int64Value(obj.Size),
+				Modified: aws.TimeValue(obj.LastModified),
+			})
+		}
+		return true
+	})
+	return objects, err
+}
+
+func (b *s3Backend) Delete(ctx context.Context, name string) error {
+	_, err := b.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: aws.String(b.bucket), Key: aws.String(name)})
+	return err
+}
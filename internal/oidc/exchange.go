@@ -0,0 +1,131 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// Default audiences of the tokens exchanged with the clouds.
+const (
+	AudienceAWS   = "sts.amazonaws.com"
+	AudienceAzure = "api://AzureADTokenExchange"
+)
+
+// The endpoints of the gcp exchanges, variables for the tests.
+var (
+	gcpSTSURL            = "https://sts.googleapis.com/v1/token"
+	gcpIAMCredentialsURL = "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/"
+)
+
+const gcpScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// AWSCredentials are the temporary credentials of an assumed role.
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiry          time.Time
+}
+
+// AssumeAWSRole exchanges the token for the credentials of the role, the role trusts the issuer.
+func AssumeAWSRole(ctx context.Context, token, roleARN, sessionName string, ttl time.Duration) (*AWSCredentials, error) {
+	// the call is authenticated by the token
+	sess, err := session.NewSession(aws.NewConfig().WithCredentials(credentials.AnonymousCredentials))
+	if err != nil {
+		return nil, fmt.Errorf("oidc: could not create the sts session: %w", err)
+	}
+	out, err := sts.New(sess).AssumeRoleWithWebIdentityWithContext(ctx, &sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(roleARN),
+		RoleSessionName:  aws.String(sessionName),
+		WebIdentityToken: aws.String(token),
+		DurationSeconds:  aws.Int64(int64(ttl.Seconds())),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("oidc: could not assume the role %s: %w", roleARN, err)
+	}
+	c := out.Credentials
+	return &AWSCredentials{
+		AccessKeyID:     aws.StringValue(c.AccessKeyId),
+		SecretAccessKey: aws.StringValue(c.SecretAccessKey),
+		SessionToken:    aws.StringValue(c.SessionToken),
+		Expiry:          aws.TimeValue(c.Expiration),
+	}, nil
+}
+
+// ExchangeGCP exchanges the token for an access token of the workload identity provider, the
+// audience is the resource name of the provider. The access token is the one of the service
+// account if set, impersonated with the federated token.
+func ExchangeGCP(ctx context.Context, token, audience, serviceAccount string, ttl time.Duration) (accessToken string, expiry time.Time, err error) {
+	var sts struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	err = postJSON(ctx, gcpSTSURL, "", map[string]string{
+		"grantType":          "urn:ietf:params:oauth:grant-type:token-exchange",
+		"audience":           audience,
+		"scope":              gcpScope,
+		"requestedTokenType": "urn:ietf:params:oauth:token-type:access_token",
+		"subjectToken":       token,
+		"subjectTokenType":   "urn:ietf:params:oauth:token-type:jwt",
+	}, &sts)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("oidc: could not exchange the token with gcp sts: %w", err)
+	}
+	if serviceAccount == "" {
+		return sts.AccessToken, time.Now().Add(time.Duration(sts.ExpiresIn) * time.Second), nil
+	}
+
+	var impersonated struct {
+		AccessToken string `json:"accessToken"`
+		ExpireTime  string `json:"expireTime"`
+	}
+	err = postJSON(ctx, gcpIAMCredentialsURL+serviceAccount+":generateAccessToken", sts.AccessToken, map[string]interface{}{
+		"scope":    []string{gcpScope},
+		"lifetime": strconv.FormatInt(int64(ttl.Seconds()), 10) + "s",
+	}, &impersonated)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("oidc: could not impersonate the service account %s: %w", serviceAccount, err)
+	}
+	expiry, _ = time.Parse(time.RFC3339, impersonated.ExpireTime)
+	return impersonated.AccessToken, expiry, nil
+}
+
+func postJSON(ctx context.Context, url, bearer string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, out)
+}
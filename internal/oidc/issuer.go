@@ -0,0 +1,172 @@
+// Package oidc issues the identity tokens of the stages, OpenID Connect tokens signed by the
+// runner, and exchanges them for the short-lived credentials of the clouds trusting the runner.
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Paths of the documents the clouds fetch to verify the tokens, relative to the issuer.
+const (
+	DiscoveryPath = "/.well-known/openid-configuration"
+	JWKSPath      = "/.well-known/jwks"
+)
+
+// Issuer signs the tokens of the stages with its RSA key.
+type Issuer struct {
+	url string
+	key *rsa.PrivateKey
+	kid string
+}
+
+// NewIssuer returns the issuer of the url signing with the key of the PEM file, or with a random
+// key if the path is empty: the tokens can't be verified once the runner restarts then.
+func NewIssuer(issuerURL, keyPath string) (*Issuer, error) {
+	var key *rsa.PrivateKey
+	var err error
+	if keyPath == "" {
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	} else {
+		key, err = readKey(keyPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("oidc: could not load the signing key: %w", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: could not encode the public key: %w", err)
+	}
+	sum := sha256.Sum256(der)
+	return &Issuer{
+		url: strings.TrimSuffix(issuerURL, "/"),
+		key: key,
+		kid: base64.RawURLEncoding.EncodeToString(sum[:12]),
+	}, nil
+}
+
+func readKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block in %s", path)
+	}
+	if key, pkcs1Err := x509.ParsePKCS1PrivateKey(block.Bytes); pkcs1Err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the key of %s is not an RSA key", path)
+	}
+	return key, nil
+}
+
+// Identity is the identity of a stage, the subject of its tokens.
+type Identity struct {
+	AccountID string
+	Pool      string
+	StageID   string
+}
+
+// Subject returns the subject of the tokens of the identity, e.g. account:abc:pool:linux:stage:123.
+// The trust policies of the clouds match the tokens on their subject.
+func (id Identity) Subject() string {
+	return "account:" + id.AccountID + ":pool:" + id.Pool + ":stage:" + id.StageID
+}
+
+// Sign returns the token of the identity for the audience, valid for ttl. The claims are added to
+// the claims of the identity.
+func (i *Issuer) Sign(id Identity, audience string, claims map[string]string, ttl time.Duration) (token string, expiry time.Time, err error) {
+	now := time.Now()
+	expiry = now.Add(ttl)
+	mc := jwt.MapClaims{}
+	for k, v := range claims {
+		mc[k] = v
+	}
+	mc["iss"] = i.url
+	mc["sub"] = id.Subject()
+	mc["aud"] = audience
+	mc["iat"] = now.Unix()
+	mc["nbf"] = now.Add(-time.Minute).Unix() // tolerates the clock skews
+	mc["exp"] = expiry.Unix()
+	mc["account_id"] = id.AccountID
+	mc["pool"] = id.Pool
+	mc["stage_id"] = id.StageID
+
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, mc)
+	t.Header["kid"] = i.kid
+	if token, err = t.SignedString(i.key); err != nil {
+		return "", time.Time{}, fmt.Errorf("oidc: could not sign the token: %w", err)
+	}
+	return token, expiry, nil
+}
+
+// Discovery is the OpenID provider configuration of the issuer.
+type Discovery struct {
+	Issuer            string   `json:"issuer"`
+	JWKSURI           string   `json:"jwks_uri"`
+	ResponseTypes     []string `json:"response_types_supported"`
+	SubjectTypes      []string `json:"subject_types_supported"`
+	SigningAlgorithms []string `json:"id_token_signing_alg_values_supported"`
+	ClaimsSupported   []string `json:"claims_supported"`
+	ScopesSupported   []string `json:"scopes_supported"`
+}
+
+// Discovery returns the provider configuration served at DiscoveryPath.
+func (i *Issuer) Discovery() *Discovery {
+	return &Discovery{
+		Issuer:            i.url,
+		JWKSURI:           i.url + JWKSPath,
+		ResponseTypes:     []string{"id_token"},
+		SubjectTypes:      []string{"public"},
+		SigningAlgorithms: []string{"RS256"},
+		ClaimsSupported:   []string{"iss", "sub", "aud", "iat", "nbf", "exp", "account_id", "pool", "stage_id"},
+		ScopesSupported:   []string{"openid"},
+	}
+}
+
+// JWK is the public key of the issuer.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+// JWKS is the key set served at JWKSPath.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the key set of the issuer.
+func (i *Issuer) JWKS() *JWKS {
+	pub := &i.key.PublicKey
+	return &JWKS{Keys: []JWK{{
+		KeyType:   "RSA",
+		Use:       "sig",
+		Algorithm: "RS256",
+		KeyID:     i.kid,
+		N:         base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}}
+}
@@ -0,0 +1,100 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestSign(t *testing.T) {
+	issuer, err := NewIssuer("https://runner.example.com/", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := Identity{AccountID: "acct", Pool: "linux", StageID: "stage"}
+	token, expiry, err := issuer.Sign(id, AudienceAWS, map[string]string{"team": "payments", "sub": "ignored"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(expiry) <= 59*time.Minute {
+		t.Errorf("unexpected expiry %s", expiry)
+	}
+
+	// the token is verified with the key set, like the clouds do
+	jwk := issuer.JWKS().Keys[0]
+	n, _ := base64.RawURLEncoding.DecodeString(jwk.N)
+	e, _ := base64.RawURLEncoding.DecodeString(jwk.E)
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(parsed *jwt.Token) (interface{}, error) {
+		if parsed.Header["kid"] != jwk.KeyID {
+			return nil, errors.New("unknown key")
+		}
+		return pub, nil
+	})
+	if err != nil || !parsed.Valid {
+		t.Fatalf("expected a valid token, got %v", err)
+	}
+	for claim, want := range map[string]string{
+		"iss":  "https://runner.example.com",
+		"sub":  "account:acct:pool:linux:stage:stage",
+		"aud":  AudienceAWS,
+		"team": "payments",
+		"pool": "linux",
+	} {
+		if claims[claim] != want {
+			t.Errorf("expected the claim %s %q, got %v", claim, want, claims[claim])
+		}
+	}
+	if d := issuer.Discovery(); d.JWKSURI != "https://runner.example.com"+JWKSPath {
+		t.Errorf("unexpected jwks uri %s", d.JWKSURI)
+	}
+}
+
+func TestExchangeGCP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		switch {
+		case r.URL.Path == "/sts":
+			if body["subjectToken"] != "id-token" || body["audience"] != "//iam.googleapis.com/provider" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_token": "federated", "expires_in": 3600}`))
+		case strings.HasSuffix(r.URL.Path, "/ci@project.iam.gserviceaccount.com:generateAccessToken"):
+			if r.Header.Get("Authorization") != "Bearer federated" || body["lifetime"] != "1800s" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte(`{"accessToken": "impersonated", "expireTime": "2030-01-01T00:00:00Z"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	gcpSTSURL, gcpIAMCredentialsURL = server.URL+"/sts", server.URL+"/sa/"
+
+	ctx := context.Background()
+	token, _, err := ExchangeGCP(ctx, "id-token", "//iam.googleapis.com/provider", "", time.Hour)
+	if err != nil || token != "federated" {
+		t.Errorf("expected the federated token, got %q, %v", token, err)
+	}
+	token, expiry, err := ExchangeGCP(ctx, "id-token", "//iam.googleapis.com/provider", "ci@project.iam.gserviceaccount.com", 30*time.Minute)
+	if err != nil || token != "impersonated" || expiry.Year() != 2030 {
+		t.Errorf("expected the token of the service account, got %q, %v", token, err)
+	}
+	if _, _, err = ExchangeGCP(ctx, "other", "//iam.googleapis.com/provider", "", time.Hour); err == nil {
+		t.Error("expected the exchange of an unknown token to fail")
+	}
+}
@@ -22,6 +22,7 @@ const ArchARM64 = "arm64"
 
 const Ubuntu = "ubuntu"
 const AmazonLinux = "amazon-linux"
+const RHEL = "rhel"
 
 // JoinPaths helper function joins the file paths.
 func JoinPaths(os string, paths ...string) string {
@@ -1,13 +1,17 @@
 package poolfile
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/certs"
+	"github.com/drone-runners/drone-runner-aws/internal/cloudinit"
 	"github.com/drone-runners/drone-runner-aws/internal/drivers"
 	"github.com/drone-runners/drone-runner-aws/internal/drivers/amazon"
 	"github.com/drone-runners/drone-runner-aws/internal/drivers/anka"
@@ -17,7 +21,10 @@ import (
 	"github.com/drone-runners/drone-runner-aws/internal/drivers/google"
 	"github.com/drone-runners/drone-runner-aws/internal/drivers/nomad"
 	"github.com/drone-runners/drone-runner-aws/internal/drivers/noop"
+	"github.com/drone-runners/drone-runner-aws/internal/drivers/static"
 	"github.com/drone-runners/drone-runner-aws/internal/drivers/vmfusion"
+	"github.com/drone-runners/drone-runner-aws/internal/keydelivery"
+	"github.com/drone-runners/drone-runner-aws/internal/lehelper"
 	"github.com/drone-runners/drone-runner-aws/internal/oshelp"
 	"github.com/drone-runners/drone-runner-aws/types"
 	"github.com/sirupsen/logrus"
@@ -34,6 +41,24 @@ func ProcessPool(poolFile *config.PoolFile, runnerName string) ([]drivers.Pool,
 	for i := range poolFile.Instances {
 		instance := poolFile.Instances[i]
 		logrus.Infoln(fmt.Sprintf("Parsing pool '%s', of type '%s'", instance.Name, instance.Type))
+		if _, err := cloudinit.NewProvider(instance.StartupScript, ""); err != nil {
+			return nil, fmt.Errorf("%s pool parsing failed: %w", instance.Name, err)
+		}
+		if instance.StartupScript == cloudinit.ProviderIgnition && instance.Platform.OS != "" && instance.Platform.OS != oshelp.OSLinux {
+			return nil, fmt.Errorf("%s pool parsing failed: ignition startup script is not supported on %s", instance.Name, instance.Platform.OS)
+		}
+		if port := instance.LiteEngine.Port; port < 0 || port > 65535 {
+			return nil, fmt.Errorf("%s pool parsing failed: invalid lite-engine port %d", instance.Name, port)
+		}
+		if err := validateTunnel(&instance); err != nil {
+			return nil, fmt.Errorf("%s pool parsing failed: %w", instance.Name, err)
+		}
+		if err := validateRoutes(&instance); err != nil {
+			return nil, fmt.Errorf("%s pool parsing failed: %w", instance.Name, err)
+		}
+		if err := validateKeyDelivery(&instance); err != nil {
+			return nil, fmt.Errorf("%s pool parsing failed: %w", instance.Name, err)
+		}
 		switch instance.Type {
 		case string(types.VMFusion):
 			var v, ok = instance.Spec.(*config.VMFusion)
@@ -76,7 +101,14 @@ func ProcessPool(poolFile *config.PoolFile, runnerName string) ([]drivers.Pool,
 				return nil, platformErr
 			}
 			instance.Platform = *platform
-			var driver, err = amazon.New(
+			if err := checkStandby(a.Standby, &instance, a.UserData, a.UserDataPath); err != nil {
+				return nil, fmt.Errorf("%s pool parsing failed: %w", instance.Name, err)
+			}
+			ipFamily, ipErr := parseIPFamily(a.Network.IPFamily)
+			if ipErr != nil {
+				return nil, fmt.Errorf("%s pool parsing failed: %w", instance.Name, ipErr)
+			}
+			opts := []amazon.Option{
 				amazon.WithAccessKeyID(a.Account.AccessKeyID),
 				amazon.WithSecretAccessKey(a.Account.AccessKeySecret),
 				amazon.WithSessionToken(a.Account.SessionToken),
@@ -85,11 +117,13 @@ func ProcessPool(poolFile *config.PoolFile, runnerName string) ([]drivers.Pool,
 				amazon.WithDeviceName(a.DeviceName, instance.Platform.OSName),
 				amazon.WithRootDirectory(a.RootDirectory),
 				amazon.WithAMI(a.AMI),
+				amazon.WithAMIFilter(a.AMIFilter.Name, a.AMIFilter.Owners),
 				amazon.WithVpc(a.VPC),
 				amazon.WithUser(a.User, instance.Platform.OS),
 				amazon.WithRegion(a.Account.Region, a.Account.Region),
 				amazon.WithRetries(a.Account.Retries),
 				amazon.WithPrivateIP(a.Network.PrivateIP),
+				amazon.WithIPFamily(ipFamily),
 				amazon.WithSecurityGroup(a.Network.SecurityGroups...),
 				amazon.WithSize(a.Size, instance.Platform.Arch),
 				amazon.WithSizeAlt(a.SizeAlt),
@@ -103,12 +137,43 @@ func ProcessPool(poolFile *config.PoolFile, runnerName string) ([]drivers.Pool,
 				amazon.WithMarketType(a.MarketType),
 				amazon.WithTags(a.Tags),
 				amazon.WithHibernate(a.Hibernate),
-			)
+				amazon.WithStandby(a.Standby),
+				amazon.WithInstanceProfile(instance.Profile.AWS),
+			}
+			var driver, err = amazon.New(opts...)
 			if err != nil {
 				return nil, fmt.Errorf("unable to create %s pool '%s': %v", instance.Type, instance.Name, err)
 			}
 			pool := mapPool(&instance, runnerName)
 			pool.Driver = driver
+			if len(a.Regions) > 0 {
+				pool.Regions = []drivers.Region{{Name: a.Account.Region, Zone: a.Account.AvailabilityZone, Driver: driver}}
+			}
+			for _, r := range a.Regions {
+				// region specific settings override the ones of the pool.
+				regionOpts := append(opts[:len(opts):len(opts)],
+					amazon.WithRegion(r.Region, r.Region),
+					amazon.WithZone(r.AvailabilityZone),
+					amazon.WithSubnet(r.SubnetID),
+				)
+				if r.AMI != "" {
+					regionOpts = append(regionOpts, amazon.WithAMI(r.AMI), amazon.WithAMIFilter("", nil))
+				}
+				if len(r.SecurityGroups) > 0 {
+					regionOpts = append(regionOpts, amazon.WithSecurityGroup(r.SecurityGroups...))
+				}
+				if r.KeyPairName != "" {
+					regionOpts = append(regionOpts, amazon.WithKeyPair(r.KeyPairName))
+				}
+				regionDriver, regionErr := amazon.New(regionOpts...)
+				if regionErr != nil {
+					return nil, fmt.Errorf("unable to create %s pool '%s' in region '%s': %v", instance.Type, instance.Name, r.Region, regionErr)
+				}
+				pool.Regions = append(pool.Regions, drivers.Region{Name: r.Region, Zone: r.AvailabilityZone, Driver: regionDriver})
+			}
+			if dupErr := checkRegions(pool.Regions); dupErr != nil {
+				return nil, fmt.Errorf("%s pool '%s': %w", instance.Type, instance.Name, dupErr)
+			}
 			pools = append(pools, pool)
 		case string(types.Azure):
 			var az, ok = instance.Spec.(*config.Azure)
@@ -138,6 +203,7 @@ func ProcessPool(poolFile *config.PoolFile, runnerName string) ([]drivers.Pool,
 				azure.WithZones(az.Zones...),
 				azure.WithTags(az.Tags),
 				azure.WithSecurityGroupName(az.SecurityGroupName),
+				azure.WithManagedIdentity(instance.Profile.Azure),
 			)
 			if err != nil {
 				return nil, fmt.Errorf("unable to create %s pool '%s': %v", instance.Type, instance.Name, err)
@@ -156,15 +222,24 @@ func ProcessPool(poolFile *config.PoolFile, runnerName string) ([]drivers.Pool,
 				return nil, platformErr
 			}
 			instance.Platform = *platform
-			var driver, err = google.New(
+			if err := checkStandby(g.Standby, &instance, g.UserData, g.UserDataPath); err != nil {
+				return nil, fmt.Errorf("%s pool parsing failed: %w", instance.Name, err)
+			}
+			ipFamily, ipErr := parseIPFamily(g.IPFamily)
+			if ipErr != nil {
+				return nil, fmt.Errorf("%s pool parsing failed: %w", instance.Name, ipErr)
+			}
+			opts := []google.Option{
 				google.WithRootDirectory(&instance.Platform),
 				google.WithDiskSize(g.Disk.Size),
 				google.WithDiskType(g.Disk.Type),
 				google.WithMachineImage(g.Image),
+				google.WithImageFamily(g.ImageFamily),
 				google.WithSize(g.MachineType),
 				google.WithNetwork(g.Network),
 				google.WithSubnetwork(g.Subnetwork),
 				google.WithPrivateIP(g.PrivateIP),
+				google.WithIPFamily(ipFamily),
 				google.WithServiceAccountEmail(g.Account.ServiceAccountEmail),
 				google.WithNoServiceAccount(g.Account.NoServiceAccount),
 				google.WithProject(g.Account.ProjectID),
@@ -175,12 +250,38 @@ func ProcessPool(poolFile *config.PoolFile, runnerName string) ([]drivers.Pool,
 				google.WithZones(g.Zone...),
 				google.WithUserDataKey(g.UserDataKey, instance.Platform.OS),
 				google.WithHibernate(g.Hibernate),
-			)
+				google.WithStandby(g.Standby),
+			}
+			if instance.Profile.GCP != "" {
+				opts = append(opts, google.WithServiceAccountEmail(instance.Profile.GCP))
+			}
+			var driver, err = google.New(opts...)
 			if err != nil {
 				return nil, err
 			}
 			pool := mapPool(&instance, runnerName)
 			pool.Driver = driver
+			if len(g.Regions) > 0 {
+				pool.Regions = []drivers.Region{{Name: googleRegion(g.Zone), Zone: googleZone(g.Zone), Driver: driver}}
+			}
+			for _, r := range g.Regions {
+				// region specific settings override the ones of the pool.
+				regionOpts := append(opts[:len(opts):len(opts)],
+					google.WithZones(r.Zone...),
+					google.WithSubnetwork(r.Subnetwork),
+				)
+				if r.Network != "" {
+					regionOpts = append(regionOpts, google.WithNetwork(r.Network))
+				}
+				regionDriver, regionErr := google.New(regionOpts...)
+				if regionErr != nil {
+					return nil, regionErr
+				}
+				pool.Regions = append(pool.Regions, drivers.Region{Name: googleRegion(r.Zone), Zone: googleZone(r.Zone), Driver: regionDriver})
+			}
+			if dupErr := checkRegions(pool.Regions); dupErr != nil {
+				return nil, fmt.Errorf("%s pool '%s': %w", instance.Type, instance.Name, dupErr)
+			}
 			pools = append(pools, pool)
 		case string(types.Anka):
 			var ak, ok = instance.Spec.(*config.Anka)
@@ -276,12 +377,57 @@ func ProcessPool(poolFile *config.PoolFile, runnerName string) ([]drivers.Pool,
 			pool := mapPool(&instance, runnerName)
 			pool.Driver = driver
 			pools = append(pools, pool)
+		case string(types.Static):
+			var s, ok = instance.Spec.(*config.Static)
+			if !ok {
+				return nil, fmt.Errorf("%s pool parsing failed", instance.Name)
+			}
+			// set platform defaults
+			platform, platformErr := static.SetPlatformDefaults(&instance.Platform)
+			if platformErr != nil {
+				return nil, platformErr
+			}
+			instance.Platform = *platform
+			machines := make([]static.Machine, 0, len(s.Machines))
+			for _, m := range s.Machines {
+				caCert, tlsCert, tlsKey, certErr := static.ReadCerts(m.CACertPath, m.CertPath, m.KeyPath)
+				if certErr != nil {
+					return nil, fmt.Errorf("unable to read certificates of machine '%s' in pool '%s': %w", m.Name, instance.Name, certErr)
+				}
+				machines = append(machines, static.Machine{
+					Name:    m.Name,
+					Address: m.Address,
+					Port:    m.Port,
+					CACert:  caCert,
+					TLSCert: tlsCert,
+					TLSKey:  tlsKey,
+				})
+			}
+			driver, err := static.New(
+				static.WithRootDirectory(&instance.Platform),
+				static.WithMachines(machines...),
+			)
+			if err != nil {
+				return nil, fmt.Errorf("unable to create %s pool '%s': %v", instance.Type, instance.Name, err)
+			}
+			pool := mapPool(&instance, runnerName)
+			// the pool can't grow beyond the number of machines.
+			if pool.MaxSize > len(machines) {
+				pool.MaxSize = len(machines)
+			}
+			if pool.MinSize > pool.MaxSize {
+				pool.MinSize = pool.MaxSize
+			}
+			pool.Driver = driver
+			pools = append(pools, pool)
 		case string(types.Nomad):
 			var nomadConfig, ok = instance.Spec.(*config.Nomad)
 			if !ok {
 				return nil, fmt.Errorf("%s pool parsing failed", instance.Name)
 			}
 			driver, err := nomad.New(nomad.WithAddress(nomadConfig.Server.Address),
+				nomad.WithAddresses(nomadConfig.Server.Addresses),
+				nomad.WithTLSServerName(nomadConfig.Server.TLSServerName),
 				nomad.WithCaCertPath(nomadConfig.Server.CaCertPath),
 				nomad.WithClientCertPath(nomadConfig.Server.ClientCertPath),
 				nomad.WithClientKeyPath(nomadConfig.Server.ClientKeyPath),
@@ -290,12 +436,15 @@ func ProcessPool(poolFile *config.PoolFile, runnerName string) ([]drivers.Pool,
 				nomad.WithDiskSize(nomadConfig.VM.DiskSize),
 				nomad.WithMemory(nomadConfig.VM.MemoryGB),
 				nomad.WithImage(nomadConfig.VM.Image),
-				nomad.WithNoop(nomadConfig.VM.Noop))
+				nomad.WithNoop(nomadConfig.VM.Noop),
+				nomad.WithEnforceLimits(nomadConfig.VM.EnforceLimits),
+				nomad.WithUser(nomadConfig.VM.User),
+				nomad.WithTimeouts(
+					time.Duration(nomadConfig.VM.Timeouts.ResourceSecs)*time.Second,
+					time.Duration(nomadConfig.VM.Timeouts.InitSecs)*time.Second,
+					time.Duration(nomadConfig.VM.Timeouts.DestroySecs)*time.Second))
 			if err != nil {
-				// TODO: We should return error here once bare metal has been tested on production
-				// Ignoring errors here for now to not cause production outages in case of nomad connectivity issues
-				logrus.WithError(err).Errorf("unable to create %s pool '%s'", instance.Type, instance.Name)
-				return nil, nil
+				return nil, fmt.Errorf("unable to create %s pool '%s': %w", instance.Type, instance.Name, err)
 			}
 			pool := mapPool(&instance, runnerName)
 			pool.Driver = driver
@@ -303,10 +452,48 @@ func ProcessPool(poolFile *config.PoolFile, runnerName string) ([]drivers.Pool,
 		default:
 			return nil, fmt.Errorf("unknown instance tip %s", instance.Type)
 		}
+
+		// the platform defaults are set by the driver specific parsing above.
+		pool := &pools[len(pools)-1]
+		bootstrapper, err := lehelper.NewBootstrapper(&instance.Bootstrap, &pool.Platform)
+		if err != nil {
+			return nil, fmt.Errorf("%s pool parsing failed: %w", instance.Name, err)
+		}
+		pool.Bootstrapper = bootstrapper
+		if pool.NameTemplate, err = parseNameTemplate(&instance); err != nil {
+			return nil, fmt.Errorf("%s pool parsing failed: %w", instance.Name, err)
+		}
+		if pool.DestroyHooks, err = drivers.ParseDestroyHooks(instance.DestroyHooks); err != nil {
+			return nil, fmt.Errorf("%s pool parsing failed: %w", instance.Name, err)
+		}
+		if instance.Schedule.IsSet() {
+			if pool.Schedule, err = drivers.ParseSchedule(instance.Schedule); err != nil {
+				return nil, fmt.Errorf("%s pool parsing failed: %w", instance.Name, err)
+			}
+		}
+		if pool.CA, err = loadCA(&instance.LiteEngine); err != nil {
+			return nil, fmt.Errorf("%s pool parsing failed: %w", instance.Name, err)
+		}
 	}
 	return pools, nil
 }
 
+// ProcessRoutes returns the routing table of the setup requests without a pool.
+func ProcessRoutes(poolFile *config.PoolFile) []drivers.Route {
+	routes := make([]drivers.Route, 0, len(poolFile.Routes))
+	for i := range poolFile.Routes {
+		r := &poolFile.Routes[i]
+		routes = append(routes, drivers.Route{
+			OS:            r.OS,
+			Arch:          r.Arch,
+			ResourceClass: r.ResourceClass,
+			Pool:          r.Pool,
+			FallbackPools: r.FallbackPools,
+		})
+	}
+	return routes
+}
+
 func mapPool(instance *config.Instance, runnerName string) (pool drivers.Pool) {
 	// set pool defaults
 	if instance.Pool < 0 {
@@ -320,15 +507,166 @@ func mapPool(instance *config.Instance, runnerName string) (pool drivers.Pool) {
 	}
 
 	pool = drivers.Pool{
-		RunnerName: runnerName,
-		Name:       instance.Name,
-		MaxSize:    instance.Limit,
-		MinSize:    instance.Pool,
-		Platform:   instance.Platform,
+		RunnerName:    runnerName,
+		Name:          instance.Name,
+		MaxSize:       instance.Limit,
+		MinSize:       instance.Pool,
+		Overflow:      instance.Overflow,
+		Platform:      instance.Platform,
+		Untrusted:     instance.Untrusted,
+		Rollout:       instance.Rollout,
+		StartupScript: instance.StartupScript,
+		Slim:          instance.Bootstrap.Slim,
+		Tuning:        instance.Bootstrap.Tuning,
+		Checksums:     instance.Bootstrap.Checksums,
+		StepTimeout:   time.Duration(instance.StepTimeout) * time.Second,
+		Timeouts:      instance.Timeouts,
+		// the static machines run their own lite-engine, their ports are set per machine.
+		LiteEnginePort: instance.LiteEngine.Port,
+		Tunnel:         instance.LiteEngine.Tunnel,
+		Preflight:      instance.Preflight && instance.Type != string(types.Static), // the static machines are checked when claimed
+
+		LiteEngineEnv:      instance.LiteEngine.Env,
+		LiteEngineFeatures: instance.LiteEngine.Features,
+		LeaseTokens:        instance.LiteEngine.LeaseTokens,
+		Routes:             instance.LiteEngine.Routes,
+		KeyDelivery:        instance.LiteEngine.KeyDelivery,
+		Sizes:              instance.Sizes,
+		WindowsContainers:  instance.Bootstrap.WindowsContainers,
+
+		DestroyGracePeriod: time.Duration(instance.DestroyGracePeriod) * time.Second,
+		FinalizeScript:     instance.FinalizeScript,
+		Services:           instance.Services,
+		WorkspaceSnapshot:  instance.WorkspaceSnapshot,
+		Credentials:        instance.Credentials,
 	}
 	return pool
 }
 
+// parseNameTemplate returns the name template of the instances of a pool, nil if not set. Only
+// the drivers creating the instances name them.
+func parseNameTemplate(instance *config.Instance) (*drivers.NameTemplate, error) {
+	if instance.NameTemplate == "" {
+		return nil, nil
+	}
+	switch types.DriverType(instance.Type) {
+	case types.Amazon, types.Google, types.Azure, types.DigitalOcean, types.Nomad:
+		return drivers.ParseNameTemplate(instance.NameTemplate)
+	default:
+		return nil, fmt.Errorf("name template is not supported by the %s driver", instance.Type)
+	}
+}
+
+// parseIPFamily returns the IP stack of the instances of a pool, IPv4 if not set.
+func parseIPFamily(family string) (types.IPFamily, error) {
+	switch f := types.IPFamily(family); f {
+	case "", types.IPv4:
+		return types.IPv4, nil
+	case types.DualStack, types.IPv6:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown ip family %q, expected %s, %s or %s", family, types.IPv4, types.DualStack, types.IPv6)
+	}
+}
+
+// validateTunnel checks the startup script of the pool starts the tunnel, only the generated linux
+// scripts do, a custom template starts it with the TunnelArgs of the params.
+func validateTunnel(instance *config.Instance) error {
+	if !instance.LiteEngine.Tunnel {
+		return nil
+	}
+	if instance.Type == string(types.Static) {
+		return errors.New("the lite-engine tunnel is not supported by static pools")
+	}
+	if instance.Platform.OS != "" && instance.Platform.OS != oshelp.OSLinux {
+		return fmt.Errorf("the lite-engine tunnel is not supported on %s", instance.Platform.OS)
+	}
+	if instance.StartupScript == cloudinit.ProviderIgnition {
+		return errors.New("the lite-engine tunnel is not supported with the ignition startup script")
+	}
+	return nil
+}
+
+// validateRoutes checks the lite-engine routes of the pool. Only the amazon and google drivers record the
+// private addresses of the instances, and the forward of their proxied route is the last resort.
+func validateRoutes(instance *config.Instance) error {
+	routes := instance.LiteEngine.Routes
+	if len(routes) == 0 {
+		return nil
+	}
+	var proxied types.Route
+	switch types.DriverType(instance.Type) {
+	case types.Amazon:
+		proxied = types.RouteSSM
+	case types.Google:
+		proxied = types.RouteIAP
+	default:
+		return fmt.Errorf("the lite-engine routes are not supported by the %s driver", instance.Type)
+	}
+	if instance.LiteEngine.Tunnel {
+		return errors.New("the lite-engine routes can't be combined with the lite-engine tunnel")
+	}
+	seen := make(map[types.Route]bool)
+	for i, r := range routes {
+		switch {
+		case seen[r]:
+			return fmt.Errorf("the lite-engine route %q is repeated", r)
+		case r.Direct():
+		case r == proxied:
+			if i != len(routes)-1 {
+				return fmt.Errorf("the lite-engine route %q must be the last route", r)
+			}
+		case r == types.RouteSSM || r == types.RouteIAP:
+			return fmt.Errorf("the lite-engine route %q is not supported by the %s driver", r, instance.Type)
+		default:
+			return fmt.Errorf("unknown lite-engine route %q, expected %s, %s, %s or %s",
+				r, types.RoutePrivate, types.RoutePublic, types.RouteSSM, types.RouteIAP)
+		}
+		seen[r] = true
+	}
+	return nil
+}
+
+// validateKeyDelivery checks the instances of the pool can fetch their TLS key. Only the generated linux
+// scripts fetch it, a custom template fetches it with the KeyFetch of the params. The keys are published
+// to the secret store of the provider of the driver, the url delivery needs a driver running user data.
+func validateKeyDelivery(instance *config.Instance) error {
+	mode := instance.LiteEngine.KeyDelivery
+	driver := types.DriverType(instance.Type)
+	switch mode {
+	case keydelivery.ModeUserData:
+		return nil
+	case keydelivery.ModeURL:
+		switch driver {
+		case types.Amazon, types.Google, types.Azure, types.DigitalOcean:
+		default:
+			return fmt.Errorf("the url key delivery is not supported by the %s driver", instance.Type)
+		}
+	case keydelivery.ModeSSM:
+		if driver != types.Amazon {
+			return fmt.Errorf("the ssm key delivery is not supported by the %s driver", instance.Type)
+		}
+	case keydelivery.ModeSecretManager:
+		if driver != types.Google {
+			return fmt.Errorf("the secret-manager key delivery is not supported by the %s driver", instance.Type)
+		}
+	default:
+		return fmt.Errorf("unknown key delivery %q, expected %s, %s or %s",
+			mode, keydelivery.ModeURL, keydelivery.ModeSSM, keydelivery.ModeSecretManager)
+	}
+	switch {
+	case instance.Platform.OS != "" && instance.Platform.OS != oshelp.OSLinux:
+		return fmt.Errorf("the %s key delivery is not supported on %s", mode, instance.Platform.OS)
+	case instance.StartupScript == cloudinit.ProviderIgnition:
+		return fmt.Errorf("the %s key delivery is not supported with the ignition startup script", mode)
+	case instance.Bootstrap.Mode == lehelper.BootstrapSSH:
+		return fmt.Errorf("the %s key delivery is not supported with the ssh bootstrap, the key is not in the user data", mode)
+	case instance.LiteEngine.Tunnel:
+		return fmt.Errorf("the %s key delivery can't be combined with the lite-engine tunnel, the tunnel authenticates with the key", mode)
+	}
+	return nil
+}
+
 func ConfigPoolFile(path string, conf *config.EnvConfig) (pool *config.PoolFile, err error) {
 	if path == "" {
 		logrus.Infof("no pool file provided")
@@ -557,3 +895,81 @@ func createAzurePool(clientID, clientSecret, subscriptionID, tenantID string, mi
 
 	return &poolFile
 }
+
+// googleZone returns the zone of a google region placing all the instances in a single zone.
+func googleZone(zones []string) string {
+	if len(zones) == 1 {
+		return zones[0]
+	}
+	return ""
+}
+
+// checkStandby verifies that the lite-engine of a standby pool is started on every boot, the instances
+// are stopped and started again and only the generated cloud-init and ignition scripts install it as a service.
+func checkStandby(standby bool, instance *config.Instance, userData, userDataPath string) error {
+	if !standby {
+		return nil
+	}
+	if userData != "" || userDataPath != "" {
+		return errors.New("standby is not supported with custom user data")
+	}
+	if instance.Platform.OS != oshelp.OSLinux && instance.Platform.OS != oshelp.OSWindows {
+		return fmt.Errorf("standby is not supported on %s", instance.Platform.OS)
+	}
+	switch instance.StartupScript {
+	case "", cloudinit.ProviderCloudInit, cloudinit.ProviderIgnition:
+		return nil
+	default:
+		return fmt.Errorf("standby is not supported with the %s startup script", instance.StartupScript)
+	}
+}
+
+// loadCA reads the CA signing the certificates of the lite-engines of a pool, nil if the pool doesn't set one.
+func loadCA(le *types.LiteEngine) (*certs.CA, error) {
+	if le.CACertPath == "" && le.CAKeyPath == "" {
+		return nil, nil
+	}
+	if le.CACertPath == "" || le.CAKeyPath == "" {
+		return nil, errors.New("both the lite-engine ca_cert_path and ca_key_path must be set")
+	}
+	cert, err := os.ReadFile(le.CACertPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the lite-engine CA certificate: %w", err)
+	}
+	key, err := os.ReadFile(le.CAKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the lite-engine CA key: %w", err)
+	}
+	if _, err = tls.X509KeyPair(cert, key); err != nil {
+		return nil, fmt.Errorf("invalid lite-engine CA: %w", err)
+	}
+	return &certs.CA{Cert: cert, Key: key}, nil
+}
+
+// checkRegions verifies that every region of a pool places its instances in a distinct region or zone.
+func checkRegions(regions []drivers.Region) error {
+	seen := make(map[string]bool, len(regions))
+	for _, r := range regions {
+		key := r.Name + "/" + r.Zone
+		if seen[key] {
+			if r.Zone == "" {
+				return fmt.Errorf("region %s is defined more than once, set a single zone for each of its definitions", r.Name)
+			}
+			return fmt.Errorf("zone %s is defined more than once", r.Zone)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// googleRegion returns the region of the zones of a google pool.
+func googleRegion(zones []string) string {
+	zone := "us-central1-a"
+	if len(zones) > 0 {
+		zone = zones[0]
+	}
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		return zone[:i]
+	}
+	return zone
+}
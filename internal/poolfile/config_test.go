@@ -0,0 +1,118 @@
+package poolfile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/internal/oshelp"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestProcessPool_NomadError(t *testing.T) {
+	poolFile := &config.PoolFile{
+		Instances: []config.Instance{
+			{
+				Name: "bare-metal",
+				Type: string(types.Nomad),
+				Spec: &config.Nomad{
+					VM: config.NomadVM{Cpus: "two"},
+				},
+			},
+		},
+	}
+
+	pools, err := ProcessPool(poolFile, "runner")
+	if err == nil {
+		t.Fatalf("expected an error, got pools %v", pools)
+	}
+	if !strings.Contains(err.Error(), "pool 'bare-metal'") || !strings.Contains(err.Error(), `invalid VM cpus "two"`) {
+		t.Errorf("expected the error to name the pool and its cause, got %q", err)
+	}
+}
+
+func TestMapPool_OverflowDefaultLimit(t *testing.T) {
+	pool := mapPool(&config.Instance{Name: "pool", Overflow: 5}, "runner")
+	if pool.MaxSize != 100 || pool.Overflow != 5 {
+		t.Errorf("expected the overflow of 5 instances over the default limit of 100, got limit %d overflow %d", pool.MaxSize, pool.Overflow)
+	}
+}
+
+func TestValidateRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		driver types.DriverType
+		routes []types.Route
+		tunnel bool
+		err    string
+	}{
+		{name: "none", driver: types.Static},
+		{name: "amazon", driver: types.Amazon, routes: []types.Route{types.RoutePrivate, types.RoutePublic, types.RouteSSM}},
+		{name: "google", driver: types.Google, routes: []types.Route{types.RoutePublic, types.RouteIAP}},
+		{name: "driver", driver: types.Azure, routes: []types.Route{types.RoutePrivate}, err: "not supported by the azure driver"},
+		{name: "tunnel", driver: types.Amazon, routes: []types.Route{types.RoutePrivate}, tunnel: true, err: "can't be combined"},
+		{name: "repeated", driver: types.Amazon, routes: []types.Route{types.RoutePrivate, types.RoutePrivate}, err: "is repeated"},
+		{name: "proxied not last", driver: types.Amazon, routes: []types.Route{types.RouteSSM, types.RoutePublic}, err: "must be the last"},
+		{name: "proxied of another driver", driver: types.Google, routes: []types.Route{types.RouteSSM}, err: "not supported by the google driver"},
+		{name: "unknown", driver: types.Amazon, routes: []types.Route{"vpn"}, err: `unknown lite-engine route "vpn"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := &config.Instance{Type: string(test.driver)}
+			instance.LiteEngine.Routes = test.routes
+			instance.LiteEngine.Tunnel = test.tunnel
+			err := validateRoutes(instance)
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestValidateKeyDelivery(t *testing.T) {
+	tests := []struct {
+		name   string
+		driver types.DriverType
+		mode   string
+		os     string
+		script string
+		tunnel bool
+		err    string
+	}{
+		{name: "user data", driver: types.Static},
+		{name: "url", driver: types.Azure, mode: "url"},
+		{name: "ssm", driver: types.Amazon, mode: "ssm", os: oshelp.OSLinux},
+		{name: "secret manager", driver: types.Google, mode: "secret-manager"},
+		{name: "url driver", driver: types.Nomad, mode: "url", err: "not supported by the nomad driver"},
+		{name: "ssm driver", driver: types.Google, mode: "ssm", err: "not supported by the google driver"},
+		{name: "secret manager driver", driver: types.Amazon, mode: "secret-manager", err: "not supported by the amazon driver"},
+		{name: "unknown", driver: types.Amazon, mode: "vault", err: `unknown key delivery "vault"`},
+		{name: "windows", driver: types.Amazon, mode: "ssm", os: oshelp.OSWindows, err: "not supported on windows"},
+		{name: "ignition", driver: types.Google, mode: "url", script: "ignition", err: "ignition startup script"},
+		{name: "tunnel", driver: types.Amazon, mode: "url", tunnel: true, err: "can't be combined"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := &config.Instance{Type: string(test.driver), StartupScript: test.script}
+			instance.Platform.OS = test.os
+			instance.LiteEngine.KeyDelivery = test.mode
+			instance.LiteEngine.Tunnel = test.tunnel
+			err := validateKeyDelivery(instance)
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
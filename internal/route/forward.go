@@ -0,0 +1,138 @@
+package route
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// forwardTimeout bounds the start of a port forward, the CLIs open the session before they listen.
+const forwardTimeout = 30 * time.Second
+
+// the CLIs starting the port forwards, variables for the tests.
+var (
+	ssmCommand = "aws"
+	iapCommand = "gcloud"
+)
+
+// forward is a port forward of the lite-engine port of an instance to a local port.
+type forward struct {
+	cmd  *exec.Cmd
+	addr string        // the local address of the forward
+	done chan struct{} // closed once the CLI exited
+}
+
+func (f *forward) exited() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *forward) stop() {
+	if !f.exited() {
+		_ = f.cmd.Process.Kill()
+	}
+	<-f.done
+}
+
+// startForward starts the CLI forwarding the port of the lite-engine of the instance and waits until
+// it accepts connections. The CLI outlives the context, it's stopped with the instance.
+func startForward(ctx context.Context, instance *types.Instance, port int) (*forward, error) {
+	localPort, err := freePort()
+	if err != nil {
+		return nil, fmt.Errorf("route: failed to pick the local port of the forward: %w", err)
+	}
+	name, args, err := forwardCommand(instance, port, localPort)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(name, args...) //nolint:gosec
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("route: failed to start the %s forward of instance %s: %w", instance.Route, instance.ID, err)
+	}
+	f := &forward{cmd: cmd, addr: net.JoinHostPort("localhost", strconv.Itoa(localPort)), done: make(chan struct{})}
+	go func() {
+		_ = cmd.Wait()
+		close(f.done)
+	}()
+
+	timeout := time.NewTimer(forwardTimeout)
+	defer timeout.Stop()
+	for {
+		var d net.Dialer
+		if conn, dialErr := d.DialContext(ctx, "tcp", f.addr); dialErr == nil {
+			conn.Close()
+			return f, nil
+		}
+		select {
+		case <-ctx.Done():
+			f.stop()
+			return nil, ctx.Err()
+		case <-f.done:
+			return nil, fmt.Errorf("route: the %s forward of instance %s exited: %s", instance.Route, instance.ID, cmd.ProcessState)
+		case <-timeout.C:
+			f.stop()
+			return nil, fmt.Errorf("route: the %s forward of instance %s did not start within %s", instance.Route, instance.ID, forwardTimeout)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// forwardCommand returns the CLI command forwarding the port of the instance to the local port.
+func forwardCommand(instance *types.Instance, port, localPort int) (name string, args []string, err error) {
+	switch instance.Route {
+	case types.RouteSSM:
+		args = []string{"ssm", "start-session",
+			"--target", instance.ID,
+			"--document-name", "AWS-StartPortForwardingSession",
+			"--parameters", fmt.Sprintf("portNumber=%d,localPortNumber=%d", port, localPort)}
+		if instance.Region != "" {
+			args = append(args, "--region", instance.Region)
+		}
+		return ssmCommand, args, nil
+	case types.RouteIAP:
+		args = []string{"compute", "start-iap-tunnel", instance.Name, strconv.Itoa(port),
+			"--local-host-port", net.JoinHostPort("localhost", strconv.Itoa(localPort)),
+			"--zone", instance.Zone}
+		if project := project(instance.ProviderID); project != "" {
+			args = append(args, "--project", project)
+		}
+		return iapCommand, args, nil
+	default:
+		return "", nil, fmt.Errorf("route: instance %s is not reached through a forward", instance.ID)
+	}
+}
+
+// project returns the project of the self link of a GCP instance.
+func project(selfLink string) string {
+	parts := strings.Split(selfLink, "/")
+	for i := 0; i < len(parts)-1; i++ {
+		if parts[i] == "projects" {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	addr, ok := l.Addr().(*net.TCPAddr)
+	if !ok {
+		return 0, errors.New("unexpected address of the listener")
+	}
+	return addr.Port, nil
+}
@@ -0,0 +1,168 @@
+// Package route picks the way the runner reaches the lite-engine of an instance among the routes
+// of its pool: its private address from a runner in the same VPC, its public address, or a port
+// forward through AWS Systems Manager or the GCP Identity-Aware Proxy when the runner has no
+// route to the instance. The forwards are the port forwarding sessions of the aws and gcloud
+// CLIs, which must be installed on the runner host with the session manager plugin for SSM.
+package route
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+const (
+	// probeTimeout bounds a connection attempt to an address of an instance.
+	probeTimeout = 3 * time.Second
+	// probeInterval is the pause between the rounds of probes of the direct routes.
+	probeInterval = 5 * time.Second
+)
+
+var (
+	// window is how long the direct routes are probed while the instance boots, before the
+	// proxied route is used, a variable for the tests.
+	window = 2 * time.Minute
+	// probe reports whether the address is reachable, a variable for the tests.
+	probe = dialProbe
+)
+
+// ErrUnreachable is returned when none of the routes of a pool reaches an instance.
+var ErrUnreachable = errors.New("route: none of the routes reaches the instance")
+
+// Select returns the first of the routes reaching the lite-engine of the instance on the port. The
+// direct routes are probed in order until one of them answers, the instances are still booting
+// when they are created. A proxied route, which can't be probed before its forward is started, is
+// used if none of the direct routes before it answered within the window.
+func Select(ctx context.Context, instance *types.Instance, routes []types.Route, port int) (types.Route, error) {
+	var direct []types.Route
+	for _, r := range routes {
+		if !r.Direct() {
+			if len(direct) == 0 {
+				return r, nil
+			}
+			break
+		}
+		direct = append(direct, r)
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, window)
+	defer cancel()
+	for {
+		for _, r := range direct {
+			if addr := address(instance, r); addr != "" && probe(probeCtx, net.JoinHostPort(addr, strconv.Itoa(port))) {
+				return r, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-probeCtx.Done():
+			if len(direct) < len(routes) {
+				return routes[len(direct)], nil
+			}
+			return "", fmt.Errorf("%w %s within %s, tried %s", ErrUnreachable, instance.ID, window, join(direct))
+		case <-time.After(probeInterval):
+		}
+	}
+}
+
+// Address returns the address the runner connects to the lite-engine of the instance on, the
+// address of a proxied route is only used as the host of the URL of the lite-engine.
+func Address(instance *types.Instance) string {
+	if addr := address(instance, instance.Route); addr != "" {
+		return addr
+	}
+	return instance.Address
+}
+
+// address returns the address of the instance on the direct route, empty if it has none.
+func address(instance *types.Instance, r types.Route) string {
+	switch r {
+	case types.RoutePrivate:
+		return instance.PrivateAddress
+	case types.RoutePublic:
+		return instance.Address
+	default:
+		return ""
+	}
+}
+
+// dialProbe reports whether the runner has a route to the address. A refused connection is answered
+// by the instance, its lite-engine is not listening yet.
+func dialProbe(ctx context.Context, addr string) bool {
+	d := net.Dialer{Timeout: probeTimeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return errors.Is(err, syscall.ECONNREFUSED)
+	}
+	conn.Close()
+	return true
+}
+
+func join(routes []types.Route) string {
+	names := make([]string, len(routes))
+	for i, r := range routes {
+		names[i] = string(r)
+	}
+	return strings.Join(names, ", ")
+}
+
+// forwards are the running port forwards by the ID of their instance.
+var forwards = struct {
+	sync.Mutex
+	m map[string]*forward
+}{m: make(map[string]*forward)}
+
+// Dial connects to the lite-engine of the instance through the port forward of its proxied route,
+// the forward is started by the first dial and reused until the instance is destroyed.
+func Dial(ctx context.Context, instance *types.Instance, port int) (net.Conn, error) {
+	f, err := forwardOf(ctx, instance, port)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", f.addr)
+}
+
+// Close stops the port forward of the instance, if any.
+func Close(instanceID string) {
+	forwards.Lock()
+	f := forwards.m[instanceID]
+	delete(forwards.m, instanceID)
+	forwards.Unlock()
+	if f != nil {
+		f.stop()
+	}
+}
+
+// forwardOf returns the running port forward of the instance, started if needed.
+func forwardOf(ctx context.Context, instance *types.Instance, port int) (*forward, error) {
+	forwards.Lock()
+	f := forwards.m[instance.ID]
+	forwards.Unlock()
+	if f != nil && !f.exited() {
+		return f, nil
+	}
+
+	f, err := startForward(ctx, instance, port)
+	if err != nil {
+		return nil, err
+	}
+	forwards.Lock()
+	defer forwards.Unlock()
+	// a concurrent dial may have started a forward of the instance meanwhile.
+	if running := forwards.m[instance.ID]; running != nil && !running.exited() {
+		f.stop()
+		return running, nil
+	}
+	forwards.m[instance.ID] = f
+	return f, nil
+}
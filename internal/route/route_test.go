@@ -0,0 +1,138 @@
+package route
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestSelect(t *testing.T) {
+	defer func(w time.Duration, p func(context.Context, string) bool) { window, probe = w, p }(window, probe)
+	window = 10 * time.Millisecond
+
+	instance := &types.Instance{ID: "instance", Address: "203.0.113.1", PrivateAddress: "10.0.0.1"}
+	tests := []struct {
+		name      string
+		routes    []types.Route
+		reachable map[string]bool
+		want      types.Route
+		err       error
+	}{
+		{
+			name:      "private first",
+			routes:    []types.Route{types.RoutePrivate, types.RoutePublic},
+			reachable: map[string]bool{"10.0.0.1:9079": true, "203.0.113.1:9079": true},
+			want:      types.RoutePrivate,
+		},
+		{
+			name:      "public fallback",
+			routes:    []types.Route{types.RoutePrivate, types.RoutePublic},
+			reachable: map[string]bool{"203.0.113.1:9079": true},
+			want:      types.RoutePublic,
+		},
+		{
+			name:   "proxied fallback",
+			routes: []types.Route{types.RoutePrivate, types.RoutePublic, types.RouteSSM},
+			want:   types.RouteSSM,
+		},
+		{
+			name:      "proxied only",
+			routes:    []types.Route{types.RouteIAP},
+			reachable: map[string]bool{"10.0.0.1:9079": true},
+			want:      types.RouteIAP,
+		},
+		{
+			name:   "unreachable",
+			routes: []types.Route{types.RoutePrivate},
+			err:    ErrUnreachable,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			probe = func(_ context.Context, addr string) bool { return test.reachable[addr] }
+			got, err := Select(context.Background(), instance, test.routes, 9079)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if got != test.want {
+				t.Errorf("expected route %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestAddress(t *testing.T) {
+	instance := &types.Instance{Address: "203.0.113.1", PrivateAddress: "10.0.0.1"}
+	for r, want := range map[types.Route]string{
+		"":                 "203.0.113.1",
+		types.RoutePrivate: "10.0.0.1",
+		types.RoutePublic:  "203.0.113.1",
+		types.RouteSSM:     "203.0.113.1",
+	} {
+		instance.Route = r
+		if got := Address(instance); got != want {
+			t.Errorf("route %q: expected address %s, got %s", r, want, got)
+		}
+	}
+}
+
+func TestDialProbe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	if !dialProbe(context.Background(), addr) {
+		t.Errorf("expected the listening address to be reachable")
+	}
+	// the instance refusing the connection is reachable, its lite-engine is not started yet.
+	l.Close()
+	if !dialProbe(context.Background(), addr) {
+		t.Errorf("expected the refusing address to be reachable")
+	}
+}
+
+func TestForwardCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		instance *types.Instance
+		command  string
+		args     []string
+	}{
+		{
+			name:     "ssm",
+			instance: &types.Instance{ID: "i-0123", Region: "us-east-2", Route: types.RouteSSM},
+			command:  "aws",
+			args: []string{"ssm", "start-session", "--target", "i-0123", "--document-name", "AWS-StartPortForwardingSession",
+				"--parameters", "portNumber=9079,localPortNumber=40000", "--region", "us-east-2"},
+		},
+		{
+			name: "iap",
+			instance: &types.Instance{ID: "123", Name: "runner-1", Zone: "us-central1-a", Route: types.RouteIAP,
+				ProviderID: "https://www.googleapis.com/compute/v1/projects/builds/zones/us-central1-a/instances/runner-1"},
+			command: "gcloud",
+			args: []string{"compute", "start-iap-tunnel", "runner-1", "9079", "--local-host-port", "localhost:40000",
+				"--zone", "us-central1-a", "--project", "builds"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			command, args, err := forwardCommand(test.instance, 9079, 40000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if command != test.command || !reflect.DeepEqual(args, test.args) {
+				t.Errorf("unexpected command %s %q", command, args)
+			}
+		})
+	}
+
+	if _, _, err := forwardCommand(&types.Instance{Route: types.RoutePrivate}, 9079, 40000); err == nil {
+		t.Errorf("expected an error for a direct route")
+	}
+}
@@ -0,0 +1,59 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+// awsProvider reads the secrets of AWS Secrets Manager with the default AWS credentials. The name
+// might be the ARN of the secret, the key selects a field of a JSON secret.
+type awsProvider struct {
+	once   sync.Once
+	client *secretsmanager.SecretsManager
+	err    error
+}
+
+func (p *awsProvider) Fetch(ctx context.Context, ref string) (string, error) {
+	p.once.Do(func() {
+		sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+		if err != nil {
+			p.err = fmt.Errorf("awssm: failed to create the aws session: %w", err)
+			return
+		}
+		p.client = secretsmanager.New(sess)
+	})
+	if p.err != nil {
+		return "", p.err
+	}
+
+	name, key := splitKey(ref)
+	out, err := p.client.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(name)})
+	if err != nil {
+		return "", err
+	}
+	if out.SecretString == nil {
+		return "", errors.New("awssm: binary secrets are not supported")
+	}
+	if key == "" {
+		return *out.SecretString, nil
+	}
+	var fields map[string]interface{}
+	if err = json.Unmarshal([]byte(*out.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("awssm: the secret %s is not a JSON object: %w", name, err)
+	}
+	value, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("awssm: the secret %s has no key %s", name, key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
@@ -0,0 +1,69 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/secretmanager/v1"
+)
+
+// gcpProvider reads the secrets of GCP Secret Manager with the application default credentials.
+// The reference is the project and the name of the secret, and optionally its version, the latest
+// if not set. The key selects a field of a JSON secret.
+type gcpProvider struct {
+	once    sync.Once
+	service *secretmanager.Service
+	err     error
+}
+
+func (p *gcpProvider) Fetch(ctx context.Context, ref string) (string, error) {
+	p.once.Do(func() {
+		// the client outlives the context of the first fetch
+		p.service, p.err = secretmanager.NewService(context.Background())
+		if p.err != nil {
+			p.err = fmt.Errorf("gcpsm: failed to create the secret manager client: %w", p.err)
+		}
+	})
+	if p.err != nil {
+		return "", p.err
+	}
+
+	name, key := splitKey(ref)
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	if len(parts) != 2 && len(parts) != 3 {
+		return "", fmt.Errorf("gcpsm: invalid secret %q, e.g. gcpsm://project/name or gcpsm://project/name/version", name)
+	}
+	version := "latest"
+	if len(parts) == 3 {
+		version = parts[2]
+	}
+	resp, err := p.service.Projects.Secrets.Versions.
+		Access(fmt.Sprintf("projects/%s/secrets/%s/versions/%s", parts[0], parts[1], version)).
+		Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("gcpsm: failed to decode the secret %s: %w", name, err)
+	}
+	if key == "" {
+		return string(data), nil
+	}
+	var fields map[string]interface{}
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return "", fmt.Errorf("gcpsm: the secret %s is not a JSON object: %w", name, err)
+	}
+	value, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("gcpsm: the secret %s has no key %s", name, key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
@@ -0,0 +1,123 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolver resolves the references to the secrets of the stores in the strings of the steps, e.g.
+// ${vault://secret/data/ci#token} in their environment. Unlike the expander it leaves the other
+// references as they are, the environment of the runner is not passed to the steps, and the
+// secrets expire from its cache so the rotated secrets are fetched again.
+type Resolver struct {
+	providers map[string]Provider
+	ttl       time.Duration
+	now       func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]cachedSecret
+}
+
+type cachedSecret struct {
+	value  string
+	expiry time.Time
+}
+
+// Secret is a secret resolved, with the reference to it.
+type Secret struct {
+	Ref   string
+	Value string
+}
+
+// NewResolver returns a resolver with the providers of the schemes, the secrets are cached for ttl
+// and not cached if it is zero.
+func NewResolver(schemes []string, ttl time.Duration) (*Resolver, error) {
+	all := newProviders()
+	providers := make(map[string]Provider, len(schemes))
+	for _, scheme := range schemes {
+		provider, ok := all[scheme]
+		if !ok {
+			return nil, fmt.Errorf("unknown secret scheme %q", scheme)
+		}
+		providers[scheme] = provider
+	}
+	return &Resolver{
+		providers: providers,
+		ttl:       ttl,
+		now:       time.Now,
+		cache:     map[string]cachedSecret{},
+	}, nil
+}
+
+// Resolve returns the string with the references to the secrets replaced, and the secrets
+// resolved. $${ escapes the references to the secrets, the other $${ are left as they are.
+func (r *Resolver) Resolve(ctx context.Context, s string) (value string, resolved []Secret, err error) {
+	if !strings.Contains(s, "${") {
+		return s, nil, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), resolved, nil
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			b.WriteString(s)
+			return b.String(), resolved, nil
+		}
+		ref := s[i+2 : i+end]
+		provider, rest := r.provider(ref)
+		switch {
+		case provider == nil:
+			b.WriteString(s[:i+end+1])
+		case i > 0 && s[i-1] == '$':
+			b.WriteString(s[:i-1] + "${" + ref + "}")
+		default:
+			secret, fetchErr := r.fetch(ctx, provider, ref, rest)
+			if fetchErr != nil {
+				return "", resolved, fetchErr
+			}
+			b.WriteString(s[:i] + secret)
+			resolved = append(resolved, Secret{Ref: ref, Value: secret})
+		}
+		s = s[i+end+1:]
+	}
+}
+
+// provider returns the provider of the reference and the reference without its scheme, nil if
+// the reference is not one to a secret of the resolver.
+func (r *Resolver) provider(ref string) (Provider, string) {
+	scheme, rest, ok := strings.Cut(ref, "://")
+	if !ok {
+		return nil, ""
+	}
+	return r.providers[scheme], rest
+}
+
+func (r *Resolver) fetch(ctx context.Context, provider Provider, ref, rest string) (string, error) {
+	r.mu.Lock()
+	cached, ok := r.cache[ref]
+	r.mu.Unlock()
+	if ok && r.now().Before(cached.expiry) {
+		return cached.value, nil
+	}
+
+	// the lock is not held during the fetch, the secrets of the other steps are not delayed
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	value, err := provider.Fetch(ctx, rest)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch the secret %s: %w", ref, err)
+	}
+	if r.ttl > 0 {
+		r.mu.Lock()
+		r.cache[ref] = cachedSecret{value: value, expiry: r.now().Add(r.ttl)}
+		r.mu.Unlock()
+	}
+	return value, nil
+}
@@ -0,0 +1,147 @@
+// Package secrets expands the references to the environment variables and to the secrets in the
+// settings of the pool files, so the credentials are not committed with them, and resolves the
+// references to the secrets in the environment of the steps.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fetchTimeout bounds the fetch of a secret from a secret store.
+const fetchTimeout = 30 * time.Second
+
+// Provider fetches the secrets of a scheme, the reference is the part after the scheme.
+type Provider interface {
+	Fetch(ctx context.Context, ref string) (string, error)
+}
+
+// Expander expands the references of the strings:
+//
+//	${NAME}              the environment variable, which must be set
+//	${NAME:-default}     the environment variable, or the default if it is not set or empty
+//	${file:///path}      the content of the file, without the trailing newline
+//	${vault://path#key}  the key of the secret of Vault, at VAULT_ADDR with VAULT_TOKEN
+//	${awssm://name#key}  the secret of AWS Secrets Manager, or the key of the JSON secret
+//	${gcpsm://project/name#key}  the latest version of the secret of GCP Secret Manager
+//	${sops:///path#key}  the key of the file encrypted with SOPS, decrypted by the sops binary
+//
+// $${ escapes a literal ${. The secrets are fetched once per expander.
+type Expander struct {
+	lookupEnv func(string) (string, bool)
+	providers map[string]Provider
+
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+// New returns an expander with the environment of the process and all the secret providers.
+func New() *Expander {
+	return &Expander{
+		lookupEnv: os.LookupEnv,
+		providers: newProviders(),
+		cache:     map[string]string{},
+	}
+}
+
+// Schemes are the schemes of the secret providers.
+var Schemes = []string{"file", "vault", "awssm", "gcpsm", "sops"}
+
+func newProviders() map[string]Provider {
+	return map[string]Provider{
+		"file":  fileProvider{},
+		"vault": &vaultProvider{},
+		"awssm": &awsProvider{},
+		"gcpsm": &gcpProvider{},
+		"sops":  sopsProvider{},
+	}
+}
+
+// Expand returns the string with its references replaced.
+func (e *Expander) Expand(ctx context.Context, s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1] + "${")
+			s = s[i+2:]
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated reference %q", s[i:])
+		}
+		value, err := e.resolve(ctx, s[i+2:i+end])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s[:i] + value)
+		s = s[i+end+1:]
+	}
+}
+
+func (e *Expander) resolve(ctx context.Context, ref string) (string, error) {
+	if scheme, rest, ok := strings.Cut(ref, "://"); ok {
+		provider, known := e.providers[scheme]
+		if !known {
+			return "", fmt.Errorf("unknown secret scheme %q in ${%s}", scheme, ref)
+		}
+		return e.fetch(ctx, provider, ref, rest)
+	}
+	name, def, hasDefault := strings.Cut(ref, ":-")
+	if name == "" {
+		return "", errors.New("empty reference ${}")
+	}
+	if value, ok := e.lookupEnv(name); ok && (value != "" || !hasDefault) {
+		return value, nil
+	}
+	if hasDefault {
+		return def, nil
+	}
+	return "", fmt.Errorf("environment variable %s is not set", name)
+}
+
+func (e *Expander) fetch(ctx context.Context, provider Provider, ref, rest string) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if value, ok := e.cache[ref]; ok {
+		return value, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	value, err := provider.Fetch(ctx, rest)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch the secret %s: %w", ref, err)
+	}
+	e.cache[ref] = value
+	return value, nil
+}
+
+// splitKey splits the reference of a secret and the key of the secret after #.
+func splitKey(ref string) (path, key string) {
+	path, key, _ = strings.Cut(ref, "#")
+	return path, key
+}
+
+type fileProvider struct{}
+
+func (fileProvider) Fetch(_ context.Context, path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
@@ -0,0 +1,183 @@
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// countingProvider returns the reference and counts the fetches.
+type countingProvider struct{ fetches int }
+
+func (p *countingProvider) Fetch(_ context.Context, ref string) (string, error) {
+	p.fetches++
+	return "secret-" + ref, nil
+}
+
+func TestExpand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("s3cr3t\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"REGION": "us-east-2", "EMPTY": ""}
+	e := New()
+	e.lookupEnv = func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	tests := []struct {
+		in, want string
+		err      bool
+	}{
+		{in: "no references", want: "no references"},
+		{in: "${REGION}", want: "us-east-2"},
+		{in: "region-${REGION}-a", want: "region-us-east-2-a"},
+		{in: "${EMPTY}", want: ""},
+		{in: "${EMPTY:-fallback}", want: "fallback"},
+		{in: "${MISSING:-fallback}", want: "fallback"},
+		{in: "$${REGION} and $HOME", want: "${REGION} and $HOME"},
+		{in: "${file://" + path + "}", want: "s3cr3t"},
+		{in: "${MISSING}", err: true},
+		{in: "${REGION", err: true},
+		{in: "${ftp://host/secret}", err: true},
+		{in: "${file:///does/not/exist}", err: true},
+	}
+	for _, test := range tests {
+		got, err := e.Expand(context.Background(), test.in)
+		if test.err {
+			if err == nil {
+				t.Errorf("expected an error expanding %q, got %q", test.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error expanding %q: %s", test.in, err)
+		} else if got != test.want {
+			t.Errorf("expected %q to expand to %q, got %q", test.in, test.want, got)
+		}
+	}
+}
+
+func TestExpandCachesSecrets(t *testing.T) {
+	p := &countingProvider{}
+	e := New()
+	e.providers = map[string]Provider{"test": p}
+	for i := 0; i < 2; i++ {
+		got, err := e.Expand(context.Background(), "${test://a}:${test://b}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "secret-a:secret-b" {
+			t.Errorf("unexpected expansion %q", got)
+		}
+	}
+	if p.fetches != 2 {
+		t.Errorf("expected every secret to be fetched once, got %d fetches", p.fetches)
+	}
+}
+
+func TestVault(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/ci": // key/value version 2
+			w.Write([]byte(`{"data":{"data":{"token":"v2-token"},"metadata":{"version":3}}}`)) //nolint:errcheck
+		case "/v1/kv/ci": // key/value version 1
+			w.Write([]byte(`{"data":{"token":"v1-token"}}`)) //nolint:errcheck
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "root")
+
+	p := &vaultProvider{client: srv.Client()}
+	for ref, want := range map[string]string{"secret/data/ci#token": "v2-token", "kv/ci#token": "v1-token"} {
+		got, err := p.Fetch(context.Background(), ref)
+		if err != nil {
+			t.Errorf("unexpected error reading %s: %s", ref, err)
+		} else if got != want {
+			t.Errorf("expected %s to be %q, got %q", ref, want, got)
+		}
+	}
+	for _, ref := range []string{"secret/data/ci", "secret/data/ci#missing", "secret/data/other#token"} {
+		if _, err := p.Fetch(context.Background(), ref); err == nil {
+			t.Errorf("expected an error reading %s", ref)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	p := &countingProvider{}
+	r, err := NewResolver(nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.providers = map[string]Provider{"test": p}
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	tests := []struct {
+		in, want string
+		refs     int
+	}{
+		{in: "no references", want: "no references"},
+		{in: "token=${test://a}", want: "token=secret-a", refs: 1},
+		{in: "${test://a}:${test://b}", want: "secret-a:secret-b", refs: 2},
+		{in: "${HOME} ${file:///etc/passwd} ${test://a", want: "${HOME} ${file:///etc/passwd} ${test://a"},
+		{in: "$${test://a} $${HOME}", want: "${test://a} $${HOME}"},
+	}
+	for _, test := range tests {
+		got, resolved, resolveErr := r.Resolve(context.Background(), test.in)
+		if resolveErr != nil {
+			t.Errorf("unexpected error resolving %q: %s", test.in, resolveErr)
+		} else if got != test.want || len(resolved) != test.refs {
+			t.Errorf("expected %q to resolve to %q with %d secrets, got %q with %v", test.in, test.want, test.refs, got, resolved)
+		}
+	}
+	if p.fetches != 2 {
+		t.Errorf("expected every secret to be fetched once, got %d fetches", p.fetches)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, _, err = r.Resolve(context.Background(), "${test://a}"); err != nil {
+		t.Fatal(err)
+	}
+	if p.fetches != 3 {
+		t.Errorf("expected the expired secret to be fetched again, got %d fetches", p.fetches)
+	}
+
+	if _, err = NewResolver([]string{"vault", "ftp"}, 0); err == nil {
+		t.Errorf("expected an error with an unknown scheme")
+	}
+}
+
+func TestSOPS(t *testing.T) {
+	// the fake sops prints its arguments
+	bin := filepath.Join(t.TempDir(), "sops")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\necho \"$@\"\n"), 0o700); err != nil { //nolint:gosec
+		t.Fatal(err)
+	}
+	defer func(cmd string) { sopsCommand = cmd }(sopsCommand)
+	sopsCommand = bin
+
+	got, err := sopsProvider{}.Fetch(context.Background(), "/etc/secrets.yaml#db.password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `--decrypt --extract ["db"]["password"] /etc/secrets.yaml`; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if _, err = (sopsProvider{}).Fetch(context.Background(), "/etc/secrets.yaml"); err == nil {
+		t.Errorf("expected an error without a key")
+	}
+}
@@ -0,0 +1,37 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// sopsCommand is the binary decrypting the SOPS files, a variable for the tests.
+var sopsCommand = "sops"
+
+// sopsProvider reads the keys of the files encrypted with SOPS. The files are decrypted by the sops
+// binary with the keys it finds, e.g. the KMS keys of the default AWS credentials or the age key
+// of SOPS_AGE_KEY_FILE. The key is the path of the value, its components separated by dots.
+type sopsProvider struct{}
+
+func (sopsProvider) Fetch(ctx context.Context, ref string) (string, error) {
+	path, key := splitKey(ref)
+	if key == "" {
+		return "", errors.New("sops: the key of the secret is not set, e.g. sops:///etc/secrets.yaml#db.password")
+	}
+	var extract strings.Builder
+	for _, k := range strings.Split(key, ".") {
+		extract.WriteString("[" + strconv.Quote(k) + "]")
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, sopsCommand, "--decrypt", "--extract", extract.String(), path)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("sops: failed to decrypt %s: %w: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
@@ -0,0 +1,69 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// vaultProvider reads the secrets of Vault through its HTTP API, the address and the token are
+// the VAULT_ADDR and VAULT_TOKEN environment variables. Both the versions of the key/value
+// engine are supported.
+type vaultProvider struct {
+	client *http.Client // the default client if nil
+}
+
+func (p *vaultProvider) Fetch(ctx context.Context, ref string) (string, error) {
+	addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return "", errors.New("vault: VAULT_ADDR and VAULT_TOKEN must be set")
+	}
+	path, key := splitKey(ref)
+	if key == "" {
+		return "", errors.New("vault: the key of the secret is not set, e.g. vault://secret/data/ci#token")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), http.NoBody)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	client := p.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault: unexpected status %s reading %s", resp.Status, path)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("vault: failed to decode the secret: %w", err)
+	}
+	data := body.Data
+	// the key/value engine version 2 nests the secret under data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, found := data[key]; !found {
+			data = nested
+		}
+	}
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("vault: the secret %s has no key %s", path, key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
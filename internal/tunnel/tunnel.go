@@ -0,0 +1,303 @@
+// Package tunnel lets the runner reach the lite-engine of instances which are not reachable from the
+// runner, e.g. behind a NAT. The instances dial out to an ssh server embedded in the runner and
+// request a remote port forward of the lite-engine port, the runner opens the connections to the
+// lite-engine through the forward.
+package tunnel
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/drone/runner-go/logger"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// User is the ssh user the instances connect as.
+const User = "tunnel"
+
+const (
+	handshakeTimeout = 30 * time.Second
+	fingerprintExt   = "fingerprint"
+)
+
+var (
+	defaultServer *Server
+	defaultMu     sync.RWMutex
+)
+
+// ErrNotConnected is returned when the tunnel of an instance is not connected.
+var ErrNotConnected = errors.New("tunnel: the instance is not connected")
+
+// AuthorizeFunc reports whether the key belongs to an instance of the runner.
+type AuthorizeFunc func(key ssh.PublicKey) bool
+
+// Server accepts the tunnels of the instances and dials the lite-engines through them.
+type Server struct {
+	address   string // the address the instances dial
+	hostKey   ssh.Signer
+	authorize AuthorizeFunc
+
+	mu      sync.Mutex
+	tunnels map[string]*forward // by the fingerprint of the key of the instance
+}
+
+// forward is the remote port forward requested by an instance.
+type forward struct {
+	conn *ssh.ServerConn
+	addr string
+	port uint32
+}
+
+// New returns a tunnel server dialed by the instances on the address with the host key.
+func New(address string, hostKey ssh.Signer, authorize AuthorizeFunc) *Server {
+	return &Server{
+		address:   address,
+		hostKey:   hostKey,
+		authorize: authorize,
+		tunnels:   make(map[string]*forward),
+	}
+}
+
+// SetDefault sets the server the lite-engine clients dial the tunneled instances through.
+func SetDefault(s *Server) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultServer = s
+}
+
+// Default returns the server the tunneled instances connect to, nil if the tunnel is not enabled.
+func Default() *Server {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultServer
+}
+
+// ReadHostKey reads the private host key of the server.
+func ReadHostKey(path string) (ssh.Signer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("tunnel: could not read the host key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("tunnel: could not parse the host key: %w", err)
+	}
+	return signer, nil
+}
+
+// Start starts the tunnel server in the background and sets it as the default server.
+func Start(ctx context.Context, bind, address, hostKeyPath string, authorize AuthorizeFunc) error {
+	if address == "" {
+		return errors.New("tunnel: the address the instances dial is not set")
+	}
+	if _, _, err := SplitAddress(address); err != nil {
+		return fmt.Errorf("tunnel: invalid address %q: %w", address, err)
+	}
+	hostKey, err := ReadHostKey(hostKeyPath)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", bind)
+	if err != nil {
+		return fmt.Errorf("tunnel: could not listen on %s: %w", bind, err)
+	}
+
+	s := New(address, hostKey, authorize)
+	go func() {
+		if serveErr := s.Serve(ctx, l); serveErr != nil {
+			logger.FromContext(ctx).WithError(serveErr).Errorln("tunnel: server stopped")
+		}
+	}()
+	SetDefault(s)
+	return nil
+}
+
+// Address returns the address the instances dial.
+func (s *Server) Address() string {
+	return s.address
+}
+
+// KnownHosts returns the known_hosts line of the server, the instances verify the server with it.
+func (s *Server) KnownHosts() string {
+	return knownhosts.Line([]string{knownhosts.Normalize(s.address)}, s.hostKey.PublicKey())
+}
+
+// Serve accepts the tunnels on the listener until the context is canceled.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if meta.User() != User || !s.authorize(key) {
+				return nil, errors.New("tunnel: unknown key")
+			}
+			return &ssh.Permissions{Extensions: map[string]string{fingerprintExt: ssh.FingerprintSHA256(key)}}, nil
+		},
+	}
+	config.AddHostKey(s.hostKey)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("tunnel: accept failed: %w", err)
+		}
+		go s.handle(ctx, conn, config)
+	}
+}
+
+func (s *Server) handle(ctx context.Context, conn net.Conn, config *ssh.ServerConfig) {
+	logr := logger.FromContext(ctx).WithField("remote", conn.RemoteAddr().String())
+
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		logr.WithError(err).Debugln("tunnel: handshake failed")
+		conn.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	fingerprint := sconn.Permissions.Extensions[fingerprintExt]
+	logr = logr.WithField("fingerprint", fingerprint)
+	logr.Debugln("tunnel: instance connected")
+
+	// the instances only forward, they can't open channels to the runner.
+	go func() {
+		for ch := range chans {
+			_ = ch.Reject(ssh.Prohibited, "tunnel: channels are not allowed")
+		}
+	}()
+
+	for req := range reqs {
+		switch req.Type {
+		case "tcpip-forward":
+			var payload struct {
+				Addr string
+				Port uint32
+			}
+			// nothing listens on the runner, the forward is only recorded so the runner can open the
+			// channels of the forward. The port must be explicit, it identifies the forward.
+			if err = ssh.Unmarshal(req.Payload, &payload); err != nil || payload.Port == 0 {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			s.mu.Lock()
+			s.tunnels[fingerprint] = &forward{conn: sconn, addr: payload.Addr, port: payload.Port}
+			s.mu.Unlock()
+			_ = req.Reply(true, nil)
+		case "cancel-tcpip-forward":
+			s.remove(fingerprint, sconn)
+			_ = req.Reply(true, nil)
+		default:
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+		}
+	}
+
+	s.remove(fingerprint, sconn)
+	logr.Debugln("tunnel: instance disconnected")
+}
+
+// remove removes the forward of the connection, a newer connection of the instance is kept.
+func (s *Server) remove(fingerprint string, conn *ssh.ServerConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.tunnels[fingerprint]; ok && f.conn == conn {
+		delete(s.tunnels, fingerprint)
+	}
+}
+
+// Dial opens a connection to the lite-engine of the instance through its tunnel.
+func (s *Server) Dial(instance *types.Instance) (net.Conn, error) {
+	fingerprint, err := Fingerprint(instance.TLSCert)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	f, ok := s.tunnels[fingerprint]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrNotConnected
+	}
+
+	// the origin is the address of the runner end of the tunnel.
+	origin, _ := f.conn.LocalAddr().(*net.TCPAddr)
+	if origin == nil {
+		origin = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	}
+	payload := ssh.Marshal(struct {
+		Addr       string
+		Port       uint32
+		OriginAddr string
+		OriginPort uint32
+	}{Addr: f.addr, Port: f.port, OriginAddr: origin.IP.String(), OriginPort: uint32(origin.Port)})
+	ch, reqs, err := f.conn.OpenChannel("forwarded-tcpip", payload)
+	if err != nil {
+		return nil, fmt.Errorf("tunnel: could not open a channel to the instance: %w", err)
+	}
+	go ssh.DiscardRequests(reqs)
+	return &channelConn{Channel: ch, local: f.conn.LocalAddr(), remote: f.conn.RemoteAddr()}, nil
+}
+
+// Fingerprint returns the fingerprint of the key of the certificate of an instance, the instance
+// authenticates with the key of its lite-engine certificate.
+func Fingerprint(certPEM []byte) (string, error) {
+	key, err := PublicKey(certPEM)
+	if err != nil {
+		return "", err
+	}
+	return ssh.FingerprintSHA256(key), nil
+}
+
+// PublicKey returns the ssh public key of the certificate of an instance.
+func PublicKey(certPEM []byte) (ssh.PublicKey, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, errors.New("tunnel: invalid certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("tunnel: invalid certificate: %w", err)
+	}
+	return ssh.NewPublicKey(cert.PublicKey)
+}
+
+// SplitAddress returns the host and the port of the address the instances dial.
+func SplitAddress(address string) (host string, port int, err error) {
+	host, p, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err = strconv.Atoi(p)
+	return host, port, err
+}
+
+// channelConn is a connection over an ssh channel, the deadlines are not supported.
+type channelConn struct {
+	ssh.Channel
+	local, remote net.Addr
+}
+
+func (c *channelConn) LocalAddr() net.Addr                { return c.local }
+func (c *channelConn) RemoteAddr() net.Addr               { return c.remote }
+func (c *channelConn) SetDeadline(_ time.Time) error      { return nil }
+func (c *channelConn) SetReadDeadline(_ time.Time) error  { return nil }
+func (c *channelConn) SetWriteDeadline(_ time.Time) error { return nil }
@@ -0,0 +1,112 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/harness/lite-engine/cli/certs"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestTunnel(t *testing.T) {
+	ca, err := certs.GenerateCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := certs.GenerateCert("runner", ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := &types.Instance{ID: "instance", TLSCert: cert.Cert, TLSKey: cert.Key}
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instanceKey, err := PublicKey(instance.TLSCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(l.Addr().String(), hostKey, func(key ssh.PublicKey) bool {
+		return ssh.FingerprintSHA256(key) == ssh.FingerprintSHA256(instanceKey)
+	})
+	go s.Serve(ctx, l) //nolint:errcheck
+
+	if _, err = s.Dial(instance); err != ErrNotConnected {
+		t.Errorf("expected the instance not to be connected, got %v", err)
+	}
+
+	// the instance connects with the key of its certificate and forwards the lite-engine port.
+	signer, err := ssh.ParsePrivateKey(instance.TLSKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := ssh.Dial("tcp", s.Address(), &ssh.ClientConfig{
+		User:            User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	forwarded, err := client.Listen("tcp", "127.0.0.1:9079")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn, acceptErr := forwarded.Accept()
+		if acceptErr != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn) //nolint:errcheck
+	}()
+
+	conn, err := s.Dial(instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("expected the echo of the lite-engine, got %q", buf)
+	}
+
+	// a key of another instance is rejected.
+	_, otherPriv, _ := ed25519.GenerateKey(rand.Reader)
+	otherSigner, _ := ssh.NewSignerFromKey(otherPriv)
+	if _, err = ssh.Dial("tcp", s.Address(), &ssh.ClientConfig{
+		User:            User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(otherSigner)},
+		HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+		Timeout:         5 * time.Second,
+	}); err == nil {
+		t.Error("expected an unknown key to be rejected")
+	}
+}
@@ -6,6 +6,12 @@ type RetryableError struct {
 
 func (e *RetryableError) Error() string { return e.Msg }
 
+// NewRetryableError returns the error of an operation which failed for a reason outside of the
+// request, e.g. the interruption of the instance, and succeeds if retried.
+func NewRetryableError(msg string) *RetryableError {
+	return &RetryableError{Msg: msg}
+}
+
 type InternalError struct {
 	Msg string
 }
@@ -35,3 +41,26 @@ func NewNotFoundError(msg string) *NotFoundError {
 }
 
 func (e *NotFoundError) Error() string { return e.Msg }
+
+// UnauthorizedError is returned when the credentials of a request are missing or invalid.
+type UnauthorizedError struct {
+	Msg string
+}
+
+func NewUnauthorizedError(msg string) *UnauthorizedError {
+	return &UnauthorizedError{Msg: msg}
+}
+
+func (e *UnauthorizedError) Error() string { return e.Msg }
+
+// TimeoutError is returned when a step does not complete in time, with the end of its output.
+type TimeoutError struct {
+	Msg    string
+	Output string
+}
+
+func NewTimeoutError(msg, output string) *TimeoutError {
+	return &TimeoutError{Msg: msg, Output: output}
+}
+
+func (e *TimeoutError) Error() string { return e.Msg }
@@ -11,5 +11,7 @@ set -x
 
 # linux
 go build -ldflags "-extldflags \"-static\"" -o release/linux/amd64/drone-runner-aws
+# linux with the FIPS validated BoringCrypto module, run it with DRONE_CRYPTO_FIPS=true
+GOEXPERIMENT=boringcrypto go build -ldflags "-extldflags \"-static\"" -o release/linux/amd64-fips/drone-runner-aws
 # darwin
 #GOARCH=amd64 go build -o release/darwin/amd64/drone-runner-aws
@@ -0,0 +1,449 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/store/database/migrate"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Checks of the doctor.
+const (
+	CheckMigrations = "migrations"
+	CheckSchema     = "schema"
+	CheckIndexes    = "indexes"
+	CheckOrphans    = "orphans"
+	CheckBloat      = "bloat"
+)
+
+// The tables are bloated beyond these dead rows or free pages, and this ratio of them.
+const (
+	bloatMinRows  = 1000
+	bloatMinPages = 1000
+	bloatRatio    = 0.2
+)
+
+// tables are the tables of the stores and the rows they store, their columns are the db tags.
+var tables = map[string]interface{}{
+	"instances":       types.Instance{},
+	"stage_owner":     types.StageOwner{},
+	"destroy_retries": types.DestroyRetry{},
+	"stage_records":   types.StageRecord{},
+	"pool_mappings":   types.PoolMapping{},
+}
+
+// indexes are the indexes the queries of the stores rely on, see the migrations.
+var indexes = map[string]string{
+	"ix_instances_pool_state":       "CREATE INDEX IF NOT EXISTS ix_instances_pool_state ON instances (instance_pool, instance_state)",
+	"ix_instances_stage":            "CREATE INDEX IF NOT EXISTS ix_instances_stage ON instances (instance_stage)",
+	"ix_stage_records_pool_started": "CREATE INDEX IF NOT EXISTS ix_stage_records_pool_started ON stage_records (pool_name, started)",
+}
+
+// Finding is a problem of the database found by the doctor.
+type Finding struct {
+	Check   string `json:"check"`
+	Table   string `json:"table,omitempty"`
+	Message string `json:"message"`
+	Fixable bool   `json:"fixable"`
+	Fixed   bool   `json:"fixed,omitempty"`
+
+	fix func(ctx context.Context) error
+}
+
+// Diagnose checks the database of the stores: its migrations, the columns of its tables against
+// the stores, the indexes the queries of the stores rely on, the orphaned rows and the bloat of
+// the tables. The instances of the pools not in the pools are orphaned, unless the pools are nil.
+func Diagnose(ctx context.Context, db *sqlx.DB, pools []string) ([]*Finding, error) {
+	var findings []*Finding
+	migrated, err := diagnoseMigrations(ctx, db, &findings)
+	if err != nil {
+		return nil, err
+	}
+	// the tables and the indexes of the pending migrations are created by the migrations, the
+	// database is checked again once migrated
+	if migrated {
+		if err = diagnoseSchema(ctx, db, &findings); err != nil {
+			return nil, err
+		}
+		if err = diagnoseIndexes(ctx, db, &findings); err != nil {
+			return nil, err
+		}
+		if err = diagnoseOrphans(ctx, db, pools, &findings); err != nil {
+			return nil, err
+		}
+	}
+	if err = diagnoseBloat(ctx, db, &findings); err != nil {
+		return nil, err
+	}
+	return findings, nil
+}
+
+// Fix repairs the fixable findings, in order. It stops at the first repair failing.
+func Fix(ctx context.Context, findings []*Finding) error {
+	for _, f := range findings {
+		if !f.Fixable || f.Fixed {
+			continue
+		}
+		if err := f.fix(ctx); err != nil {
+			return fmt.Errorf("could not fix %q: %w", f.Message, err)
+		}
+		f.Fixed = true
+	}
+	return nil
+}
+
+// diagnoseMigrations reports whether the database is at the last migration of the runner.
+func diagnoseMigrations(ctx context.Context, db *sqlx.DB, findings *[]*Finding) (bool, error) {
+	latest, err := migrate.Latest(db)
+	if err != nil {
+		return false, err
+	}
+	// the table of the migrations is missing until the first migration
+	version, err := migrate.Version(ctx, db)
+	if err != nil || version == "" {
+		*findings = append(*findings, &Finding{
+			Check:   CheckMigrations,
+			Message: "the database was never migrated",
+			Fixable: true,
+			fix:     func(context.Context) error { return migrate.Migrate(db) },
+		})
+		return false, nil
+	}
+	switch {
+	case version < latest:
+		*findings = append(*findings, &Finding{
+			Check:   CheckMigrations,
+			Message: fmt.Sprintf("the database is at the migration %q, the runner at %q", version, latest),
+			Fixable: true,
+			fix:     func(context.Context) error { return migrate.Migrate(db) },
+		})
+		return false, nil
+	case version > latest:
+		*findings = append(*findings, &Finding{
+			Check:   CheckMigrations,
+			Message: fmt.Sprintf("the database is at the migration %q, newer than the runner at %q: upgrade the runner", version, latest),
+		})
+	}
+	return true, nil
+}
+
+// diagnoseSchema reports the tables and the columns the stores query and the database lacks.
+func diagnoseSchema(ctx context.Context, db *sqlx.DB, findings *[]*Finding) error {
+	for _, table := range sortedKeys(tables) {
+		actual, err := columns(ctx, db, table)
+		if err != nil {
+			return err
+		}
+		if len(actual) == 0 {
+			*findings = append(*findings, &Finding{Check: CheckSchema, Table: table, Message: "the table is missing"})
+			continue
+		}
+		for _, column := range dbColumns(reflect.TypeOf(tables[table])) {
+			if !actual[column] {
+				*findings = append(*findings, &Finding{
+					Check:   CheckSchema,
+					Table:   table,
+					Message: fmt.Sprintf("the column %s is missing", column),
+				})
+			}
+		}
+	}
+	return nil
+}
+
+func diagnoseIndexes(ctx context.Context, db *sqlx.DB, findings *[]*Finding) error {
+	for _, name := range sortedKeys(indexes) {
+		var query string
+		if db.DriverName() == "postgres" {
+			query = "SELECT COUNT(*) FROM pg_indexes WHERE schemaname = current_schema() AND indexname = $1"
+		} else {
+			query = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = $1"
+		}
+		var n int
+		if err := db.GetContext(ctx, &n, query, name); err != nil {
+			return fmt.Errorf("could not list the indexes: %w", err)
+		}
+		if n > 0 {
+			continue
+		}
+		stmt := indexes[name]
+		*findings = append(*findings, &Finding{
+			Check:   CheckIndexes,
+			Message: fmt.Sprintf("the index %s is missing", name),
+			Fixable: true,
+			fix: func(ctx context.Context) error {
+				_, err := db.ExecContext(ctx, stmt)
+				return err
+			},
+		})
+	}
+	return nil
+}
+
+// diagnoseOrphans reports the rows of the stores referencing no instance, and the instances of
+// the pools the runner doesn't know.
+func diagnoseOrphans(ctx context.Context, db *sqlx.DB, pools []string, findings *[]*Finding) error {
+	orphans := []struct {
+		table, message, query, prune string
+	}{
+		{
+			// a stage owner is created before the instance of the stage: the fix is for a stopped runner
+			table:   "stage_owner",
+			message: "%d stage owners have no instance",
+			query:   "SELECT COUNT(*) FROM stage_owner WHERE stage_id NOT IN (SELECT CAST(instance_stage AS TEXT) FROM instances WHERE instance_stage IS NOT NULL)",
+			prune:   "DELETE FROM stage_owner WHERE stage_id NOT IN (SELECT CAST(instance_stage AS TEXT) FROM instances WHERE instance_stage IS NOT NULL)",
+		},
+		{
+			table:   "destroy_retries",
+			message: "%d destroy retries have no instance",
+			query:   "SELECT COUNT(*) FROM destroy_retries WHERE instance_id NOT IN (SELECT instance_id FROM instances)",
+			prune:   "DELETE FROM destroy_retries WHERE instance_id NOT IN (SELECT instance_id FROM instances)",
+		},
+	}
+	for _, o := range orphans {
+		var n int
+		if err := db.GetContext(ctx, &n, o.query); err != nil {
+			return fmt.Errorf("could not count the orphaned rows of %s: %w", o.table, err)
+		}
+		if n == 0 {
+			continue
+		}
+		prune := o.prune
+		*findings = append(*findings, &Finding{
+			Check:   CheckOrphans,
+			Table:   o.table,
+			Message: fmt.Sprintf(o.message, n),
+			Fixable: true,
+			fix: func(ctx context.Context) error {
+				_, err := db.ExecContext(ctx, prune)
+				return err
+			},
+		})
+	}
+
+	if pools == nil {
+		return nil
+	}
+	var counts []poolCount
+	if err := db.SelectContext(ctx, &counts, "SELECT instance_pool, COUNT(*) AS n FROM instances GROUP BY instance_pool ORDER BY instance_pool"); err != nil {
+		return fmt.Errorf("could not count the instances of the pools: %w", err)
+	}
+	diagnoseUnknownPools(counts, pools, findings)
+	return nil
+}
+
+// poolCount is the number of instances of a pool, ordered by pool.
+type poolCount struct {
+	Pool  string `db:"instance_pool"`
+	Count int    `db:"n"`
+}
+
+// diagnoseUnknownPools reports the instances of the pools not in the pools.
+func diagnoseUnknownPools(counts []poolCount, pools []string, findings *[]*Finding) {
+	// the machines of the instances might still run, their rows are not pruned
+	known := make(map[string]bool, len(pools))
+	for _, p := range pools {
+		known[p] = true
+	}
+	for _, c := range counts {
+		if !known[c.Pool] {
+			*findings = append(*findings, &Finding{
+				Check:   CheckOrphans,
+				Table:   "instances",
+				Message: fmt.Sprintf("%d instances belong to the pool %q which is not in the pool file, destroy their machines before deleting them", c.Count, c.Pool),
+			})
+		}
+	}
+}
+
+// DiagnoseLevelDB checks the leveldb database of the stores for the orphaned rows, like Diagnose. The
+// leveldb database has neither migrations, a schema nor indexes, and compacts itself.
+func DiagnoseLevelDB(ctx context.Context, db *leveldb.DB, pools []string) ([]*Finding, error) {
+	instances, err := ldb.NewInstanceStore(db).ListAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not list the instances: %w", err)
+	}
+	ids := make(map[string]bool, len(instances))
+	stages := make(map[string]bool, len(instances))
+	perPool := map[string]int{}
+	for _, inst := range instances {
+		ids[inst.ID] = true
+		if inst.Stage != "" {
+			stages[inst.Stage] = true
+		}
+		perPool[inst.Pool]++
+	}
+
+	var findings []*Finding
+	owners := ldb.NewStageOwnerStore(db)
+	list, err := owners.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not list the stage owners: %w", err)
+	}
+	var orphanedOwners []string
+	for _, o := range list {
+		if !stages[o.StageID] {
+			orphanedOwners = append(orphanedOwners, o.StageID)
+		}
+	}
+	if len(orphanedOwners) > 0 {
+		findings = append(findings, &Finding{
+			Check:   CheckOrphans,
+			Table:   "stage_owner",
+			Message: fmt.Sprintf("%d stage owners have no instance", len(orphanedOwners)),
+			Fixable: true,
+			fix: func(ctx context.Context) error {
+				for _, id := range orphanedOwners {
+					if err := owners.Delete(ctx, id); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		})
+	}
+
+	retries := ldb.NewDestroyRetryStore(db)
+	pending, err := retries.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not list the destroy retries: %w", err)
+	}
+	var orphanedRetries []string
+	for _, r := range pending {
+		if !ids[r.InstanceID] {
+			orphanedRetries = append(orphanedRetries, r.InstanceID)
+		}
+	}
+	if len(orphanedRetries) > 0 {
+		findings = append(findings, &Finding{
+			Check:   CheckOrphans,
+			Table:   "destroy_retries",
+			Message: fmt.Sprintf("%d destroy retries have no instance", len(orphanedRetries)),
+			Fixable: true,
+			fix: func(ctx context.Context) error {
+				for _, id := range orphanedRetries {
+					if err := retries.Delete(ctx, id); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		})
+	}
+
+	if pools != nil {
+		counts := make([]poolCount, 0, len(perPool))
+		for _, pool := range sortedKeys(perPool) {
+			counts = append(counts, poolCount{Pool: pool, Count: perPool[pool]})
+		}
+		diagnoseUnknownPools(counts, pools, &findings)
+	}
+	return findings, nil
+}
+
+// diagnoseBloat reports the tables whose dead rows, or the database whose free pages, slow the
+// queries of the stores down.
+func diagnoseBloat(ctx context.Context, db *sqlx.DB, findings *[]*Finding) error {
+	if db.DriverName() != "postgres" {
+		var pages, free int64
+		if err := db.GetContext(ctx, &pages, "PRAGMA page_count"); err != nil {
+			return fmt.Errorf("could not read the page count: %w", err)
+		}
+		if err := db.GetContext(ctx, &free, "PRAGMA freelist_count"); err != nil {
+			return fmt.Errorf("could not read the free page count: %w", err)
+		}
+		if free >= bloatMinPages && float64(free) > bloatRatio*float64(pages) {
+			*findings = append(*findings, &Finding{
+				Check:   CheckBloat,
+				Message: fmt.Sprintf("%d of the %d pages of the database are free", free, pages),
+				Fixable: true,
+				fix: func(ctx context.Context) error {
+					_, err := db.ExecContext(ctx, "VACUUM")
+					return err
+				},
+			})
+		}
+		return nil
+	}
+
+	var stats []struct {
+		Table string `db:"relname"`
+		Live  int64  `db:"n_live_tup"`
+		Dead  int64  `db:"n_dead_tup"`
+	}
+	if err := db.SelectContext(ctx, &stats, "SELECT relname, n_live_tup, n_dead_tup FROM pg_stat_user_tables WHERE schemaname = current_schema() ORDER BY relname"); err != nil {
+		return fmt.Errorf("could not read the statistics of the tables: %w", err)
+	}
+	for _, s := range stats {
+		if _, ok := tables[s.Table]; !ok || s.Dead < bloatMinRows || float64(s.Dead) <= bloatRatio*float64(s.Live+s.Dead) {
+			continue
+		}
+		table := s.Table
+		*findings = append(*findings, &Finding{
+			Check:   CheckBloat,
+			Table:   table,
+			Message: fmt.Sprintf("%d of the %d rows of the table are dead", s.Dead, s.Live+s.Dead),
+			Fixable: true,
+			fix: func(ctx context.Context) error {
+				if _, err := db.ExecContext(ctx, "VACUUM ANALYZE "+table); err != nil {
+					return err
+				}
+				_, err := db.ExecContext(ctx, "REINDEX TABLE "+table)
+				return err
+			},
+		})
+	}
+	return nil
+}
+
+// columns returns the columns of the table, none if the table doesn't exist.
+func columns(ctx context.Context, db *sqlx.DB, table string) (map[string]bool, error) {
+	var names []string
+	var err error
+	if db.DriverName() == "postgres" {
+		err = db.SelectContext(ctx, &names, "SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1", table)
+	} else {
+		err = db.SelectContext(ctx, &names, "SELECT name FROM pragma_table_info($1)", table)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not list the columns of %s: %w", table, err)
+	}
+	out := make(map[string]bool, len(names))
+	for _, name := range names {
+		out[name] = true
+	}
+	return out, nil
+}
+
+// dbColumns returns the db tags of the struct and of its embedded structs.
+func dbColumns(t reflect.Type) []string {
+	var out []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("db")
+		switch {
+		case tag == "-":
+		case tag != "":
+			out = append(out, tag)
+		case field.Anonymous && field.Type.Kind() == reflect.Struct:
+			out = append(out, dbColumns(field.Type)...)
+		}
+	}
+	return out
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/store/database/migrate"
+	"github.com/drone-runners/drone-runner-aws/store/database/sql"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// seedOrphans stores an instance of a pool missing from the pool file, a stage owner without an
+// instance and a destroy retry without an instance.
+func seedOrphans(t *testing.T, instances store.InstanceStore, owners store.StageOwnerStore, retries store.DestroyRetryStore) {
+	t.Helper()
+	ctx := context.Background()
+	if err := instances.Create(ctx, &types.Instance{ID: "inst", Name: "inst", Pool: "gone", Stage: "stage", State: types.StateInUse}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"stage", "orphan"} {
+		if err := owners.Create(ctx, &types.StageOwner{StageID: id, PoolName: "gone"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"inst", "destroyed"} {
+		if err := retries.Create(ctx, &types.DestroyRetry{InstanceID: id, PoolName: "gone"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkFindings checks the messages of the findings and whether they are fixable.
+func checkFindings(t *testing.T, findings []*Finding, want map[string]bool) {
+	t.Helper()
+	got := map[string]bool{}
+	for _, f := range findings {
+		got[f.Message] = f.Fixable
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected the findings %v, got %v", want, got)
+	}
+	for message, fixable := range want {
+		if f, ok := got[message]; !ok || f != fixable {
+			t.Errorf("expected the finding %q, fixable %v, got %v", message, fixable, got)
+		}
+	}
+}
+
+const unknownPool = `1 instances belong to the pool "gone" which is not in the pool file, destroy their machines before deleting them`
+
+func TestDiagnose_SQLite(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenSQL("sqlite3", filepath.Join(t.TempDir(), "runner.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	findings, err := Diagnose(ctx, db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFindings(t, findings, map[string]bool{"the database was never migrated": true})
+	if err = Fix(ctx, findings); err != nil {
+		t.Fatal(err)
+	}
+	if findings, err = Diagnose(ctx, db, nil); err != nil || len(findings) != 0 {
+		t.Fatalf("expected the migrated database to be healthy, got %v %v", findings, err)
+	}
+
+	if _, err = db.Exec("DROP INDEX ix_instances_stage"); err != nil {
+		t.Fatal(err)
+	}
+	owners := sql.NewStageOwnerStore(db)
+	seedOrphans(t, sql.NewInstanceStore(db), owners, sql.NewDestroyRetryStore(db))
+
+	findings, err = Diagnose(ctx, db, []string{"linux"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFindings(t, findings, map[string]bool{
+		"the index ix_instances_stage is missing": true,
+		"1 stage owners have no instance":         true,
+		"1 destroy retries have no instance":      true,
+		unknownPool:                               false,
+	})
+	if err = Fix(ctx, findings); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range findings {
+		if f.Fixed != f.Fixable {
+			t.Errorf("expected the fixable findings to be fixed, got %+v", f)
+		}
+	}
+
+	findings, err = Diagnose(ctx, db, []string{"linux"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFindings(t, findings, map[string]bool{unknownPool: false})
+	if _, err = owners.Find(ctx, "stage"); err != nil {
+		t.Errorf("expected the stage owner of the instance to be kept, got %v", err)
+	}
+	if version, _ := migrate.Version(ctx, db); version == "" {
+		t.Errorf("expected the database to be migrated")
+	}
+}
+
+func TestDiagnose_LevelDB(t *testing.T) {
+	ctx := context.Background()
+	db, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	findings, err := DiagnoseLevelDB(ctx, db, nil)
+	if err != nil || len(findings) != 0 {
+		t.Fatalf("expected the empty database to be healthy, got %v %v", findings, err)
+	}
+
+	owners, retries := ldb.NewStageOwnerStore(db), ldb.NewDestroyRetryStore(db)
+	seedOrphans(t, ldb.NewInstanceStore(db), owners, retries)
+
+	findings, err = DiagnoseLevelDB(ctx, db, []string{"linux"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFindings(t, findings, map[string]bool{
+		"1 stage owners have no instance":    true,
+		"1 destroy retries have no instance": true,
+		unknownPool:                          false,
+	})
+	if err = Fix(ctx, findings); err != nil {
+		t.Fatal(err)
+	}
+
+	findings, err = DiagnoseLevelDB(ctx, db, []string{"linux"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFindings(t, findings, map[string]bool{unknownPool: false})
+	if _, err = owners.Find(ctx, "stage"); err != nil {
+		t.Errorf("expected the stage owner of the instance to be kept, got %v", err)
+	}
+	if _, err = owners.Find(ctx, "orphan"); err == nil {
+		t.Errorf("expected the orphaned stage owner to be pruned")
+	}
+	if _, err = retries.Find(ctx, "destroyed"); err == nil {
+		t.Errorf("expected the orphaned destroy retry to be pruned")
+	}
+	if _, err = retries.Find(ctx, "inst"); err != nil {
+		t.Errorf("expected the destroy retry of the instance to be kept, got %v", err)
+	}
+}
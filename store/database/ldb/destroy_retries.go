@@ -0,0 +1,88 @@
+package ldb
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"sort"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var _ store.DestroyRetryStore = (*DestroyRetryStore)(nil)
+
+const drKeyPrefix = "destroy-retry-"
+
+func NewDestroyRetryStore(db *leveldb.DB) *DestroyRetryStore {
+	return &DestroyRetryStore{db}
+}
+
+type DestroyRetryStore struct {
+	db *leveldb.DB
+}
+
+func (s DestroyRetryStore) getKey(id string) string {
+	return drKeyPrefix + id
+}
+
+func (s DestroyRetryStore) Find(_ context.Context, instanceID string) (*types.DestroyRetry, error) {
+	key := s.getKey(instanceID)
+	data, err := s.db.Get([]byte(key), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	dst := new(types.DestroyRetry)
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(dst); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+func (s DestroyRetryStore) List(_ context.Context) ([]*types.DestroyRetry, error) {
+	retries := make([]*types.DestroyRetry, 0)
+
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(drKeyPrefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		retry := new(types.DestroyRetry)
+		if err := gob.NewDecoder(bytes.NewReader(iter.Value())).Decode(retry); err != nil {
+			return nil, err
+		}
+		retries = append(retries, retry)
+	}
+
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(retries, func(i, j int) bool {
+		return retries[i].NextAttempt < retries[j].NextAttempt
+	})
+
+	return retries, nil
+}
+
+func (s DestroyRetryStore) Create(ctx context.Context, retry *types.DestroyRetry) error {
+	return s.Update(ctx, retry)
+}
+
+func (s DestroyRetryStore) Update(_ context.Context, retry *types.DestroyRetry) error {
+	key := s.getKey(retry.InstanceID)
+	var data bytes.Buffer
+	enc := gob.NewEncoder(&data)
+	if err := enc.Encode(retry); err != nil {
+		return err
+	}
+
+	return s.db.Put([]byte(key), data.Bytes(), nil)
+}
+
+func (s DestroyRetryStore) Delete(_ context.Context, instanceID string) error {
+	key := s.getKey(instanceID)
+	return s.db.Delete([]byte(key), nil)
+}
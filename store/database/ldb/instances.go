@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/gob"
+	"errors"
 	"sort"
 	"time"
 
@@ -73,6 +74,22 @@ func (s InstanceStore) List(_ context.Context, pool string, params *types.QueryP
 	return instances, nil
 }
 
+// ListAll returns the instances of all the pools, for the db doctor.
+func (s InstanceStore) ListAll(_ context.Context) ([]*types.Instance, error) {
+	instances := make([]*types.Instance, 0)
+
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(keyPrefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		inst := new(types.Instance)
+		if err := gob.NewDecoder(bytes.NewReader(iter.Value())).Decode(inst); err != nil {
+			return nil, err
+		}
+		instances = append(instances, inst)
+	}
+	return instances, iter.Error()
+}
+
 func (s InstanceStore) Create(ctx context.Context, instance *types.Instance) error {
 	return s.Update(ctx, instance)
 }
@@ -93,10 +110,49 @@ func (s InstanceStore) Update(_ context.Context, instance *types.Instance) error
 	return s.db.Put([]byte(key), data.Bytes(), nil)
 }
 
+// CompareAndUpdate runs in a transaction, which blocks the other writes until it is committed.
+func (s InstanceStore) CompareAndUpdate(_ context.Context, instance *types.Instance, state types.InstanceState) (bool, error) {
+	tr, err := s.db.OpenTransaction()
+	if err != nil {
+		return false, err
+	}
+	defer tr.Discard()
+
+	key := []byte(s.getKey(instance.ID))
+	data, err := tr.Get(key, nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	current := new(types.Instance)
+	if err = gob.NewDecoder(bytes.NewReader(data)).Decode(current); err != nil {
+		return false, err
+	}
+	if current.State != state {
+		return false, nil
+	}
+
+	var buf bytes.Buffer
+	if err = gob.NewEncoder(&buf).Encode(instance); err != nil {
+		return false, err
+	}
+	if err = tr.Put(key, buf.Bytes(), nil); err != nil {
+		return false, err
+	}
+	return true, tr.Commit()
+}
+
 func (s InstanceStore) Purge(ctx context.Context) error {
 	panic("implement me")
 }
 
+// Ping fails once the database is closed.
+func (s InstanceStore) Ping(_ context.Context) error {
+	_, err := s.db.GetProperty("leveldb.num-files-at-level0")
+	return err
+}
+
 func (s InstanceStore) satisfy(inst *types.Instance, pool string, params *types.QueryParams) bool {
 	if inst.Pool != pool {
 		return false
@@ -113,6 +169,9 @@ func (s InstanceStore) satisfy(inst *types.Instance, pool string, params *types.
 				return false
 			}
 		}
+		if params.AccountID != "" && inst.AccountID != "" && inst.AccountID != params.AccountID {
+			return false
+		}
 	}
 	return true
 }
@@ -0,0 +1,83 @@
+package ldb
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var _ store.PoolMappingStore = (*PoolMappingStore)(nil)
+
+const pmKeyPrefix = "pool-mapping-"
+
+func NewPoolMappingStore(db *leveldb.DB) *PoolMappingStore {
+	return &PoolMappingStore{db}
+}
+
+type PoolMappingStore struct {
+	db *leveldb.DB
+}
+
+// getKey returns the key of the mapping, the keys are ordered by account and pool.
+func (s PoolMappingStore) getKey(accountID, poolName string) string {
+	return pmKeyPrefix + accountID + "/" + poolName
+}
+
+func (s PoolMappingStore) Find(_ context.Context, accountID, poolName string) (*types.PoolMapping, error) {
+	key := s.getKey(accountID, poolName)
+	data, err := s.db.Get([]byte(key), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	dst := new(types.PoolMapping)
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(dst); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+func (s PoolMappingStore) List(_ context.Context) ([]*types.PoolMapping, error) {
+	mappings := make([]*types.PoolMapping, 0)
+
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(pmKeyPrefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		mapping := new(types.PoolMapping)
+		if err := gob.NewDecoder(bytes.NewReader(iter.Value())).Decode(mapping); err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, mapping)
+	}
+
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return mappings, nil
+}
+
+func (s PoolMappingStore) Create(ctx context.Context, mapping *types.PoolMapping) error {
+	return s.Update(ctx, mapping)
+}
+
+func (s PoolMappingStore) Update(_ context.Context, mapping *types.PoolMapping) error {
+	key := s.getKey(mapping.AccountID, mapping.PoolName)
+	var data bytes.Buffer
+	enc := gob.NewEncoder(&data)
+	if err := enc.Encode(mapping); err != nil {
+		return err
+	}
+
+	return s.db.Put([]byte(key), data.Bytes(), nil)
+}
+
+func (s PoolMappingStore) Delete(_ context.Context, accountID, poolName string) error {
+	key := s.getKey(accountID, poolName)
+	return s.db.Delete([]byte(key), nil)
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/drone-runners/drone-runner-aws/store"
 	"github.com/drone-runners/drone-runner-aws/types"
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 var _ store.StageOwnerStore = (*StageOwnerStore)(nil)
@@ -40,6 +41,22 @@ func (s StageOwnerStore) Find(_ context.Context, id string) (*types.StageOwner,
 	return dst, nil
 }
 
+// List returns all the stage owners, for the db doctor.
+func (s StageOwnerStore) List(_ context.Context) ([]*types.StageOwner, error) {
+	owners := make([]*types.StageOwner, 0)
+
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(ssKeyPrefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		owner := new(types.StageOwner)
+		if err := gob.NewDecoder(bytes.NewReader(iter.Value())).Decode(owner); err != nil {
+			return nil, err
+		}
+		owners = append(owners, owner)
+	}
+	return owners, iter.Error()
+}
+
 func (s StageOwnerStore) Create(_ context.Context, stageOwner *types.StageOwner) error {
 	key := s.getKey(stageOwner.StageID)
 	var data bytes.Buffer
@@ -0,0 +1,91 @@
+package ldb
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"sort"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var _ store.StageRecordStore = (*StageRecordStore)(nil)
+
+const srKeyPrefix = "stage-record-"
+
+func NewStageRecordStore(db *leveldb.DB) *StageRecordStore {
+	return &StageRecordStore{db}
+}
+
+type StageRecordStore struct {
+	db *leveldb.DB
+}
+
+func (s StageRecordStore) getKey(id string) string {
+	return srKeyPrefix + id
+}
+
+func (s StageRecordStore) Find(_ context.Context, stageID string) (*types.StageRecord, error) {
+	key := s.getKey(stageID)
+	data, err := s.db.Get([]byte(key), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	dst := new(types.StageRecord)
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(dst); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+func (s StageRecordStore) List(_ context.Context, params *types.StageRecordQuery) ([]*types.StageRecord, error) {
+	records := make([]*types.StageRecord, 0)
+
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(srKeyPrefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		record := new(types.StageRecord)
+		if err := gob.NewDecoder(bytes.NewReader(iter.Value())).Decode(record); err != nil {
+			return nil, err
+		}
+		if params != nil {
+			if params.PoolName != "" && record.PoolName != params.PoolName {
+				continue
+			}
+			if record.Started < params.Since {
+				continue
+			}
+		}
+		records = append(records, record)
+	}
+
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Started < records[j].Started
+	})
+
+	return records, nil
+}
+
+func (s StageRecordStore) Create(ctx context.Context, record *types.StageRecord) error {
+	return s.Update(ctx, record)
+}
+
+func (s StageRecordStore) Update(_ context.Context, record *types.StageRecord) error {
+	key := s.getKey(record.StageID)
+	var data bytes.Buffer
+	enc := gob.NewEncoder(&data)
+	if err := enc.Encode(record); err != nil {
+		return err
+	}
+
+	return s.db.Put([]byte(key), data.Bytes(), nil)
+}
@@ -0,0 +1,73 @@
+package ldb
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"sort"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var _ store.TaskJournalStore = (*TaskJournalStore)(nil)
+
+const tjKeyPrefix = "task-journal-"
+
+func NewTaskJournalStore(db *leveldb.DB) *TaskJournalStore {
+	return &TaskJournalStore{db}
+}
+
+type TaskJournalStore struct {
+	db *leveldb.DB
+}
+
+func (s TaskJournalStore) getKey(taskID string) string {
+	return tjKeyPrefix + taskID
+}
+
+// List returns the entries of the runner ordered by creation.
+func (s TaskJournalStore) List(_ context.Context, runner string) ([]*types.TaskJournalEntry, error) {
+	entries := make([]*types.TaskJournalEntry, 0)
+
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(tjKeyPrefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		entry := new(types.TaskJournalEntry)
+		if err := gob.NewDecoder(bytes.NewReader(iter.Value())).Decode(entry); err != nil {
+			return nil, err
+		}
+		if entry.Runner == runner {
+			entries = append(entries, entry)
+		}
+	}
+
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Created < entries[j].Created })
+	return entries, nil
+}
+
+func (s TaskJournalStore) Create(ctx context.Context, entry *types.TaskJournalEntry) error {
+	return s.Update(ctx, entry)
+}
+
+func (s TaskJournalStore) Update(_ context.Context, entry *types.TaskJournalEntry) error {
+	key := s.getKey(entry.TaskID)
+	var data bytes.Buffer
+	enc := gob.NewEncoder(&data)
+	if err := enc.Encode(entry); err != nil {
+		return err
+	}
+
+	return s.db.Put([]byte(key), data.Bytes(), nil)
+}
+
+func (s TaskJournalStore) Delete(_ context.Context, taskID string) error {
+	key := s.getKey(taskID)
+	return s.db.Delete([]byte(key), nil)
+}
@@ -0,0 +1,229 @@
+// Package memory provides stores keeping their data in memory, snapshotted to a JSON file so the
+// data survives the restarts of the runner. It needs neither cgo nor a database server, for the
+// runners on laptops and the small deployments of a single runner.
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNotFound is returned by the stores for the rows they don't have.
+var ErrNotFound = errors.New("not found")
+
+// snapshotVersion is the version of the format of the snapshots.
+const snapshotVersion = 1
+
+// DB holds the rows of all the stores. The writes are snapshotted to the file of the DB from the
+// background, at most once per snapshot interval, and on Close.
+type DB struct {
+	mu             sync.RWMutex
+	instances      map[string]*types.Instance
+	stageOwners    map[string]*types.StageOwner
+	destroyRetries map[string]*types.DestroyRetry
+	stageRecords   map[string]*types.StageRecord
+	poolMappings   map[poolMappingKey]*types.PoolMapping
+	taskJournal    map[string]*types.TaskJournalEntry
+
+	path   string
+	dirty  bool
+	closed bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+type poolMappingKey struct{ accountID, poolName string }
+
+// snapshot is the content of the file of the DB.
+type snapshot struct {
+	Version        int                       `json:"version"`
+	Instances      []*types.Instance         `json:"instances"`
+	StageOwners    []*types.StageOwner       `json:"stage_owners"`
+	DestroyRetries []*types.DestroyRetry     `json:"destroy_retries"`
+	StageRecords   []*types.StageRecord      `json:"stage_records"`
+	PoolMappings   []*types.PoolMapping      `json:"pool_mappings"`
+	TaskJournal    []*types.TaskJournalEntry `json:"task_journal"`
+}
+
+// Open loads the DB from its snapshot file, if the file exists, and snapshots the writes to the file
+// every interval. The DB is only kept in memory if the path is empty.
+func Open(path string, interval time.Duration) (*DB, error) {
+	db := &DB{
+		instances:      map[string]*types.Instance{},
+		stageOwners:    map[string]*types.StageOwner{},
+		destroyRetries: map[string]*types.DestroyRetry{},
+		stageRecords:   map[string]*types.StageRecord{},
+		poolMappings:   map[poolMappingKey]*types.PoolMapping{},
+		taskJournal:    map[string]*types.TaskJournalEntry{},
+		path:           path,
+	}
+	if path == "" {
+		return db, nil
+	}
+	if err := db.load(); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		db.stop = make(chan struct{})
+		db.done = make(chan struct{})
+		go db.snapshotLoop(interval)
+	}
+	return db, nil
+}
+
+// Close stops the snapshots from the background and writes the last one.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return nil
+	}
+	db.closed = true
+	db.mu.Unlock()
+
+	if db.stop != nil {
+		close(db.stop)
+		<-db.done
+	}
+	return db.Snapshot()
+}
+
+// Snapshot writes the DB to its file if it changed since the last snapshot. The file is replaced
+// atomically, a crash during the snapshot leaves the previous one.
+func (db *DB) Snapshot() error {
+	if db.path == "" {
+		return nil
+	}
+	db.mu.Lock()
+	if !db.dirty {
+		db.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(db.snapshot())
+	db.dirty = false
+	db.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(db.path), filepath.Base(db.path)+".*.tmp")
+	if err == nil {
+		_, err = tmp.Write(data)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), db.path)
+		}
+		if err != nil {
+			os.Remove(tmp.Name()) //nolint:errcheck
+		}
+	}
+	if err != nil {
+		db.touch()
+		return fmt.Errorf("could not write the snapshot of the database to %s: %w", db.path, err)
+	}
+	return nil
+}
+
+// Ping fails once the DB is closed.
+func (db *DB) Ping(_ context.Context) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return errors.New("the database is closed")
+	}
+	return nil
+}
+
+func (db *DB) snapshotLoop(interval time.Duration) {
+	defer close(db.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.stop:
+			return
+		case <-ticker.C:
+			if err := db.Snapshot(); err != nil {
+				logrus.WithError(err).Errorln("memory store: failed to snapshot the database")
+			}
+		}
+	}
+}
+
+// touch marks the DB as changed since the last snapshot.
+func (db *DB) touch() {
+	db.mu.Lock()
+	db.dirty = true
+	db.mu.Unlock()
+}
+
+// snapshot returns the rows of the DB, the caller holds the lock.
+func (db *DB) snapshot() *snapshot {
+	s := &snapshot{Version: snapshotVersion}
+	for _, v := range db.instances {
+		s.Instances = append(s.Instances, v)
+	}
+	for _, v := range db.stageOwners {
+		s.StageOwners = append(s.StageOwners, v)
+	}
+	for _, v := range db.destroyRetries {
+		s.DestroyRetries = append(s.DestroyRetries, v)
+	}
+	for _, v := range db.stageRecords {
+		s.StageRecords = append(s.StageRecords, v)
+	}
+	for _, v := range db.poolMappings {
+		s.PoolMappings = append(s.PoolMappings, v)
+	}
+	for _, v := range db.taskJournal {
+		s.TaskJournal = append(s.TaskJournal, v)
+	}
+	return s
+}
+
+func (db *DB) load() error {
+	data, err := os.ReadFile(db.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not read the snapshot of the database: %w", err)
+	}
+	s := &snapshot{}
+	if err = json.Unmarshal(data, s); err != nil {
+		return fmt.Errorf("could not parse the snapshot of the database %s: %w", db.path, err)
+	}
+	if s.Version > snapshotVersion {
+		return fmt.Errorf("the snapshot of the database %s is at version %d, newer than the runner at %d: upgrade the runner",
+			db.path, s.Version, snapshotVersion)
+	}
+	for _, v := range s.Instances {
+		db.instances[v.ID] = v
+	}
+	for _, v := range s.StageOwners {
+		db.stageOwners[v.StageID] = v
+	}
+	for _, v := range s.DestroyRetries {
+		db.destroyRetries[v.InstanceID] = v
+	}
+	for _, v := range s.StageRecords {
+		db.stageRecords[v.StageID] = v
+	}
+	for _, v := range s.PoolMappings {
+		db.poolMappings[poolMappingKey{v.AccountID, v.PoolName}] = v
+	}
+	for _, v := range s.TaskJournal {
+		db.taskJournal[v.TaskID] = v
+	}
+	return nil
+}
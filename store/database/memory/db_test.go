@@ -0,0 +1,132 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDB_CloseAndLoad(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "runner.json")
+
+	db, err := Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := &types.Instance{ID: "instance", Pool: "linux", State: types.StateInUse, Stage: "stage"}
+	owner := &types.StageOwner{StageID: "stage", PoolName: "linux"}
+	retry := &types.DestroyRetry{InstanceID: "gone", PoolName: "linux", Attempts: 2}
+	record := &types.StageRecord{StageID: "stage", PoolName: "linux", InstanceID: "instance"}
+	mapping := &types.PoolMapping{AccountID: "account", PoolName: "linux", TargetPool: "linux-large"}
+	for _, err = range []error{
+		NewInstanceStore(db).Create(ctx, instance),
+		NewStageOwnerStore(db).Create(ctx, owner),
+		NewDestroyRetryStore(db).Create(ctx, retry),
+		NewStageRecordStore(db).Create(ctx, record),
+		NewPoolMappingStore(db).Create(ctx, mapping),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected no snapshot before the close without an interval, got %v", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Close(); err != nil {
+		t.Errorf("expected the second close to be a no-op, got %v", err)
+	}
+	if err = db.Ping(ctx); err == nil {
+		t.Error("expected the closed database to fail the ping")
+	}
+
+	db, err = Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	gotInstance, err := NewInstanceStore(db).Find(ctx, "instance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotOwner, err := NewStageOwnerStore(db).Find(ctx, "stage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotRetry, err := NewDestroyRetryStore(db).Find(ctx, "gone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotRecord, err := NewStageRecordStore(db).Find(ctx, "stage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotMapping, err := NewPoolMappingStore(db).Find(ctx, "account", "linux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{instance, owner, retry, record, mapping}
+	got := []interface{}{gotInstance, gotOwner, gotRetry, gotRecord, gotMapping}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("expected the rows to survive the restart, diff %s", diff)
+	}
+}
+
+func TestDB_SnapshotInterval(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "runner.json")
+
+	db, err := Open(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err = NewStageOwnerStore(db).Create(ctx, &types.StageOwner{StageID: "stage", PoolName: "linux"}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		loaded, openErr := Open(path, 0)
+		if openErr != nil {
+			t.Fatal(openErr)
+		}
+		if _, err = NewStageOwnerStore(loaded).Find(ctx, "stage"); err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the change to be snapshotted from the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDB_LoadErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		snapshot string
+	}{
+		{name: "corrupt", snapshot: `{"instances": [`},
+		{name: "newer version", snapshot: `{"version": 2}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "runner.json")
+			if err := os.WriteFile(path, []byte(test.snapshot), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Open(path, 0); err == nil {
+				t.Error("expected the snapshot not to be loaded")
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+var _ store.DestroyRetryStore = (*DestroyRetryStore)(nil)
+
+func NewDestroyRetryStore(db *DB) *DestroyRetryStore {
+	return &DestroyRetryStore{db}
+}
+
+type DestroyRetryStore struct {
+	db *DB
+}
+
+func (s DestroyRetryStore) Find(_ context.Context, instanceID string) (*types.DestroyRetry, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	retry, ok := s.db.destroyRetries[instanceID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	dst := *retry
+	return &dst, nil
+}
+
+func (s DestroyRetryStore) List(_ context.Context) ([]*types.DestroyRetry, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	retries := make([]*types.DestroyRetry, 0, len(s.db.destroyRetries))
+	for _, retry := range s.db.destroyRetries {
+		dst := *retry
+		retries = append(retries, &dst)
+	}
+	sort.Slice(retries, func(i, j int) bool {
+		return retries[i].NextAttempt < retries[j].NextAttempt
+	})
+	return retries, nil
+}
+
+func (s DestroyRetryStore) Create(ctx context.Context, retry *types.DestroyRetry) error {
+	return s.Update(ctx, retry)
+}
+
+func (s DestroyRetryStore) Update(_ context.Context, retry *types.DestroyRetry) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	dst := *retry
+	s.db.destroyRetries[retry.InstanceID] = &dst
+	s.db.dirty = true
+	return nil
+}
+
+func (s DestroyRetryStore) Delete(_ context.Context, instanceID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.destroyRetries, instanceID)
+	s.db.dirty = true
+	return nil
+}
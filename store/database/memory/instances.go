@@ -0,0 +1,112 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+var _ store.InstanceStore = (*InstanceStore)(nil)
+
+func NewInstanceStore(db *DB) *InstanceStore {
+	return &InstanceStore{db}
+}
+
+type InstanceStore struct {
+	db *DB
+}
+
+func (s InstanceStore) Find(_ context.Context, id string) (*types.Instance, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	inst, ok := s.db.instances[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	dst := *inst
+	return &dst, nil
+}
+
+func (s InstanceStore) List(_ context.Context, pool string, params *types.QueryParams) ([]*types.Instance, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	instances := make([]*types.Instance, 0)
+	for _, inst := range s.db.instances {
+		if satisfy(inst, pool, params) {
+			dst := *inst
+			instances = append(instances, &dst)
+		}
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].Started < instances[j].Started
+	})
+	return instances, nil
+}
+
+func (s InstanceStore) Create(ctx context.Context, instance *types.Instance) error {
+	return s.Update(ctx, instance)
+}
+
+func (s InstanceStore) Delete(_ context.Context, id string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.instances, id)
+	s.db.dirty = true
+	return nil
+}
+
+func (s InstanceStore) Update(_ context.Context, instance *types.Instance) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	dst := *instance
+	s.db.instances[instance.ID] = &dst
+	s.db.dirty = true
+	return nil
+}
+
+func (s InstanceStore) CompareAndUpdate(_ context.Context, instance *types.Instance, state types.InstanceState) (bool, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	current, ok := s.db.instances[instance.ID]
+	if !ok || current.State != state {
+		return false, nil
+	}
+	dst := *instance
+	s.db.instances[instance.ID] = &dst
+	s.db.dirty = true
+	return true, nil
+}
+
+func (s InstanceStore) Purge(ctx context.Context) error {
+	panic("implement me")
+}
+
+func (s InstanceStore) Ping(ctx context.Context) error {
+	return s.db.Ping(ctx)
+}
+
+func satisfy(inst *types.Instance, pool string, params *types.QueryParams) bool {
+	if inst.Pool != pool {
+		return false
+	}
+	if params != nil {
+		if params.Stage != "" && inst.Stage != params.Stage {
+			return false
+		}
+		if params.Status != "" && inst.State != params.Status {
+			return false
+		}
+		// the instances stamped with no account predate the segregation of the accounts
+		if params.AccountID != "" && inst.AccountID != "" && inst.AccountID != params.AccountID {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+var _ store.PoolMappingStore = (*PoolMappingStore)(nil)
+
+func NewPoolMappingStore(db *DB) *PoolMappingStore {
+	return &PoolMappingStore{db}
+}
+
+type PoolMappingStore struct {
+	db *DB
+}
+
+func (s PoolMappingStore) Find(_ context.Context, accountID, poolName string) (*types.PoolMapping, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	mapping, ok := s.db.poolMappings[poolMappingKey{accountID, poolName}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	dst := *mapping
+	return &dst, nil
+}
+
+// List returns the mappings ordered by account and pool.
+func (s PoolMappingStore) List(_ context.Context) ([]*types.PoolMapping, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	mappings := make([]*types.PoolMapping, 0, len(s.db.poolMappings))
+	for _, mapping := range s.db.poolMappings {
+		dst := *mapping
+		mappings = append(mappings, &dst)
+	}
+	sort.Slice(mappings, func(i, j int) bool {
+		if mappings[i].AccountID != mappings[j].AccountID {
+			return mappings[i].AccountID < mappings[j].AccountID
+		}
+		return mappings[i].PoolName < mappings[j].PoolName
+	})
+	return mappings, nil
+}
+
+func (s PoolMappingStore) Create(ctx context.Context, mapping *types.PoolMapping) error {
+	return s.Update(ctx, mapping)
+}
+
+func (s PoolMappingStore) Update(_ context.Context, mapping *types.PoolMapping) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	dst := *mapping
+	s.db.poolMappings[poolMappingKey{mapping.AccountID, mapping.PoolName}] = &dst
+	s.db.dirty = true
+	return nil
+}
+
+func (s PoolMappingStore) Delete(_ context.Context, accountID, poolName string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.poolMappings, poolMappingKey{accountID, poolName})
+	s.db.dirty = true
+	return nil
+}
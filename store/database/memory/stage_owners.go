@@ -0,0 +1,49 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+var _ store.StageOwnerStore = (*StageOwnerStore)(nil)
+
+func NewStageOwnerStore(db *DB) *StageOwnerStore {
+	return &StageOwnerStore{db}
+}
+
+type StageOwnerStore struct {
+	db *DB
+}
+
+func (s StageOwnerStore) Find(_ context.Context, id string) (*types.StageOwner, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	owner, ok := s.db.stageOwners[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	dst := *owner
+	return &dst, nil
+}
+
+func (s StageOwnerStore) Create(_ context.Context, stageOwner *types.StageOwner) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	dst := *stageOwner
+	s.db.stageOwners[stageOwner.StageID] = &dst
+	s.db.dirty = true
+	return nil
+}
+
+func (s StageOwnerStore) Delete(_ context.Context, id string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.stageOwners, id)
+	s.db.dirty = true
+	return nil
+}
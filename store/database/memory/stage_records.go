@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+var _ store.StageRecordStore = (*StageRecordStore)(nil)
+
+func NewStageRecordStore(db *DB) *StageRecordStore {
+	return &StageRecordStore{db}
+}
+
+type StageRecordStore struct {
+	db *DB
+}
+
+func (s StageRecordStore) Find(_ context.Context, stageID string) (*types.StageRecord, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	record, ok := s.db.stageRecords[stageID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	dst := *record
+	return &dst, nil
+}
+
+func (s StageRecordStore) List(_ context.Context, params *types.StageRecordQuery) ([]*types.StageRecord, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	records := make([]*types.StageRecord, 0)
+	for _, record := range s.db.stageRecords {
+		if params != nil {
+			if params.PoolName != "" && record.PoolName != params.PoolName {
+				continue
+			}
+			if record.Started < params.Since {
+				continue
+			}
+		}
+		dst := *record
+		records = append(records, &dst)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Started < records[j].Started
+	})
+	return records, nil
+}
+
+func (s StageRecordStore) Create(ctx context.Context, record *types.StageRecord) error {
+	return s.Update(ctx, record)
+}
+
+func (s StageRecordStore) Update(_ context.Context, record *types.StageRecord) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	dst := *record
+	s.db.stageRecords[record.StageID] = &dst
+	s.db.dirty = true
+	return nil
+}
@@ -0,0 +1,58 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+var _ store.TaskJournalStore = (*TaskJournalStore)(nil)
+
+func NewTaskJournalStore(db *DB) *TaskJournalStore {
+	return &TaskJournalStore{db}
+}
+
+type TaskJournalStore struct {
+	db *DB
+}
+
+// List returns the entries of the runner ordered by creation.
+func (s TaskJournalStore) List(_ context.Context, runner string) ([]*types.TaskJournalEntry, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	entries := make([]*types.TaskJournalEntry, 0)
+	for _, entry := range s.db.taskJournal {
+		if entry.Runner == runner {
+			dst := *entry
+			entries = append(entries, &dst)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Created < entries[j].Created })
+	return entries, nil
+}
+
+func (s TaskJournalStore) Create(ctx context.Context, entry *types.TaskJournalEntry) error {
+	return s.Update(ctx, entry)
+}
+
+func (s TaskJournalStore) Update(_ context.Context, entry *types.TaskJournalEntry) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	dst := *entry
+	s.db.taskJournal[entry.TaskID] = &dst
+	s.db.dirty = true
+	return nil
+}
+
+func (s TaskJournalStore) Delete(_ context.Context, taskID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.taskJournal, taskID)
+	s.db.dirty = true
+	return nil
+}
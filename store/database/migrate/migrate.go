@@ -9,6 +9,8 @@ import (
 	"database/sql"
 	"embed"
 	"io/fs"
+	"sort"
+	"strings"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/maragudk/migrate"
@@ -24,6 +26,40 @@ var postgres embed.FS
 //go:embed sqlite/*.sql
 var sqlite embed.FS
 
+// migrations returns the migrations of the driver of the database.
+func migrations(db *sqlx.DB) fs.FS {
+	if db.DriverName() == "postgres" {
+		folder, _ := fs.Sub(postgres, "postgres")
+		return folder
+	}
+	folder, _ := fs.Sub(sqlite, "sqlite")
+	return folder
+}
+
+// Latest returns the version of the last migration of the driver of the database, the version
+// the database is at once migrated.
+func Latest(db *sqlx.DB) (string, error) {
+	names, err := fs.Glob(migrations(db), "*.up.sql")
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return "", nil
+	}
+	return strings.TrimSuffix(names[len(names)-1], ".up.sql"), nil
+}
+
+// Version returns the version the database is at, empty if it was never migrated.
+func Version(ctx context.Context, db *sqlx.DB) (string, error) {
+	var version string
+	err := db.QueryRowContext(ctx, "SELECT version FROM migrations").Scan(&version)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	return version, nil
+}
+
 // Migrate performs the database migration.
 func Migrate(db *sqlx.DB) error {
 	before := func(_ context.Context, _ *sql.Tx, version string) error {
@@ -40,19 +76,8 @@ func Migrate(db *sqlx.DB) error {
 		After:  after,
 		Before: before,
 		DB:     db.DB,
-		FS:     sqlite,
+		FS:     migrations(db),
 		Table:  "migrations",
 	}
-
-	switch db.DriverName() {
-	case "postgres":
-		folder, _ := fs.Sub(postgres, "postgres")
-		opts.FS = folder
-
-	default:
-		folder, _ := fs.Sub(sqlite, "sqlite")
-		opts.FS = folder
-	}
-
 	return migrate.New(opts).MigrateUp(noContext)
 }
@@ -0,0 +1 @@
+ALTER TABLE instances ADD COLUMN instance_untrusted BOOLEAN NOT NULL DEFAULT FALSE;
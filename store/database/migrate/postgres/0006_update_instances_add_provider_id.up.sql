@@ -0,0 +1 @@
+ALTER TABLE instances ADD COLUMN instance_provider_id TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS destroy_retries (
+     instance_id       VARCHAR(250) PRIMARY KEY
+    ,pool_name         VARCHAR(250)
+    ,attempts          INTEGER NOT NULL DEFAULT 0
+    ,last_error        TEXT NOT NULL DEFAULT ''
+    ,next_attempt      BIGINT
+    ,created           BIGINT
+);
@@ -0,0 +1 @@
+ALTER TABLE instances ADD COLUMN instance_tunnel BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS stage_records (
+     stage_id          VARCHAR(250) PRIMARY KEY
+    ,pool_name         VARCHAR(250)
+    ,driver            VARCHAR(50)
+    ,instance_id       VARCHAR(250)
+    ,region            VARCHAR(250) NOT NULL DEFAULT ''
+    ,hibernated        BOOLEAN NOT NULL DEFAULT FALSE
+    ,boot_ms           BIGINT NOT NULL DEFAULT 0
+    ,health_check_ms   BIGINT NOT NULL DEFAULT 0
+    ,run_ms            BIGINT NOT NULL DEFAULT 0
+    ,result            VARCHAR(50)
+    ,started           BIGINT
+);
+
+CREATE INDEX IF NOT EXISTS ix_stage_records_pool_started ON stage_records (pool_name, started);
@@ -0,0 +1 @@
+ALTER TABLE instances ADD COLUMN instance_overflow BOOLEAN NOT NULL DEFAULT FALSE;
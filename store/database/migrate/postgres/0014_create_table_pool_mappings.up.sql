@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS pool_mappings (
+     account_id        VARCHAR(250)
+    ,pool_name         VARCHAR(250)
+    ,target_pool       VARCHAR(250) NOT NULL
+    ,updated           BIGINT
+    ,PRIMARY KEY (account_id, pool_name)
+);
@@ -0,0 +1 @@
+ALTER TABLE instances ADD COLUMN instance_account_id VARCHAR(250) NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS ix_instances_pool_state ON instances (instance_pool, instance_state);
+CREATE INDEX IF NOT EXISTS ix_instances_stage ON instances (instance_stage);
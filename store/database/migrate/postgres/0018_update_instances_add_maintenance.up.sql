@@ -0,0 +1 @@
+ALTER TABLE instances ADD COLUMN instance_maintenance TEXT NOT NULL DEFAULT '';
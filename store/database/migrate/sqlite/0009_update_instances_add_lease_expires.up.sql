@@ -0,0 +1 @@
+ALTER TABLE instances ADD COLUMN instance_lease_expires INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE instances ADD COLUMN instance_claimed INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+ALTER TABLE stage_records ADD COLUMN size VARCHAR(250) NOT NULL DEFAULT '';
+ALTER TABLE stage_records ADD COLUMN cpus INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE stage_records ADD COLUMN mem_total_mb INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE stage_records ADD COLUMN peak_cpu_pct INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE stage_records ADD COLUMN peak_mem_mb INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE instances ADD COLUMN instance_lite_engine_env TEXT NOT NULL DEFAULT '';
+ALTER TABLE instances ADD COLUMN instance_lite_engine_features TEXT NOT NULL DEFAULT '';
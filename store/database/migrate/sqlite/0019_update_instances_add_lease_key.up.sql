@@ -0,0 +1 @@
+ALTER TABLE instances ADD COLUMN instance_lease_key TEXT NOT NULL DEFAULT '';
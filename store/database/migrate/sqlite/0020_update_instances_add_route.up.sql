@@ -0,0 +1,2 @@
+ALTER TABLE instances ADD COLUMN instance_private_address TEXT NOT NULL DEFAULT '';
+ALTER TABLE instances ADD COLUMN instance_route TEXT NOT NULL DEFAULT '';
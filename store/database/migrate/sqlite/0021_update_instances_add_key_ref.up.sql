@@ -0,0 +1 @@
+ALTER TABLE instances ADD COLUMN instance_key_ref TEXT NOT NULL DEFAULT '';
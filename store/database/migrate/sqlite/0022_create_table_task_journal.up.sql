@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS task_journal (
+     task_id           VARCHAR(250) PRIMARY KEY
+    ,task_type         VARCHAR(250) NOT NULL
+    ,runner            VARCHAR(250) NOT NULL
+    ,stage_id          VARCHAR(250) NOT NULL DEFAULT ''
+    ,pool_id           VARCHAR(250) NOT NULL DEFAULT ''
+    ,account_id        VARCHAR(250) NOT NULL DEFAULT ''
+    ,state             VARCHAR(50) NOT NULL
+    ,task              TEXT NOT NULL DEFAULT ''
+    ,response          TEXT NOT NULL DEFAULT ''
+    ,created           INTEGER
+    ,updated           INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS ix_task_journal_runner ON task_journal (runner);
@@ -0,0 +1 @@
+ALTER TABLE instances ADD COLUMN instance_host_key TEXT NOT NULL DEFAULT '';
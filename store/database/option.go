@@ -0,0 +1,94 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+)
+
+type Option func(*options)
+
+// defaultSnapshotInterval is the interval of the snapshots of the memory store if none is set.
+const defaultSnapshotInterval = time.Second
+
+type options struct {
+	replicaDatasource string
+	statementTimeout  time.Duration
+	retries           int
+	snapshotInterval  time.Duration
+}
+
+// EnvOptions returns the options of the database set in the environment of the runner.
+func EnvOptions(env *config.EnvConfig) []Option {
+	return []Option{
+		WithReplica(env.Database.ReplicaDatasource),
+		WithStatementTimeout(time.Duration(env.Database.StatementTimeoutMs) * time.Millisecond),
+		WithSerializationRetries(env.Database.SerializationRetries),
+		WithSnapshotInterval(time.Duration(env.Database.SnapshotIntervalMs) * time.Millisecond),
+	}
+}
+
+// WithReplica sets the datasource of a read replica of the postgres database. Only the list of the
+// stage records goes to the replica, see sql.NewStageRecordStoreWithReplica. The other queries stay
+// on the primary: the instances, the stage owners and the pool mappings are read right after they
+// are written, e.g. to claim a free instance, and the lag of the replica would hand out stale rows.
+func WithReplica(datasource string) Option {
+	return func(o *options) {
+		o.replicaDatasource = datasource
+	}
+}
+
+// WithStatementTimeout makes postgres abort the statements of the stores running longer than the
+// timeout, on the primary and on the replica.
+func WithStatementTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.statementTimeout = d
+	}
+}
+
+// WithSerializationRetries sets how many times the writes of the postgres stores aborted on a
+// serialization failure or a deadlock are retried.
+func WithSerializationRetries(n int) Option {
+	return func(o *options) {
+		o.retries = n
+	}
+}
+
+// WithSnapshotInterval sets how often the changes of the memory store are snapshotted to its file,
+// the changes since the last snapshot are lost if the runner crashes.
+func WithSnapshotInterval(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.snapshotInterval = d
+		}
+	}
+}
+
+// withStatementTimeout adds the statement timeout to the parameters of the postgres datasource,
+// either a URL or a list of key=value pairs. The other runtime parameters of postgres are kept.
+func withStatementTimeout(datasource string, timeout time.Duration) (string, error) {
+	if timeout <= 0 {
+		return datasource, nil
+	}
+	ms := fmt.Sprint(timeout.Milliseconds())
+	if !strings.HasPrefix(datasource, "postgres://") && !strings.HasPrefix(datasource, "postgresql://") {
+		return strings.TrimSpace(datasource + " statement_timeout=" + ms), nil
+	}
+	u, err := url.Parse(datasource)
+	if err != nil {
+		// the error of the URL holds the password of the datasource
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return "", fmt.Errorf("invalid postgres datasource: %w", err)
+	}
+	query := u.Query()
+	query.Set("statement_timeout", ms)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,105 @@
+package sql
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var _ store.DestroyRetryStore = (*DestroyRetryStore)(nil)
+
+func NewDestroyRetryStore(db *sqlx.DB) *DestroyRetryStore {
+	return &DestroyRetryStore{db}
+}
+
+type DestroyRetryStore struct {
+	db *sqlx.DB
+}
+
+func (s DestroyRetryStore) Find(_ context.Context, instanceID string) (*types.DestroyRetry, error) {
+	dst := new(types.DestroyRetry)
+	err := s.db.Get(dst, destroyRetryFindByID, instanceID)
+	return dst, err
+}
+
+func (s DestroyRetryStore) List(_ context.Context) ([]*types.DestroyRetry, error) {
+	dst := []*types.DestroyRetry{}
+	err := s.db.Select(&dst, destroyRetryList)
+	return dst, err
+}
+
+func (s DestroyRetryStore) Create(_ context.Context, retry *types.DestroyRetry) error {
+	query, arg, err := s.db.BindNamed(destroyRetryInsert, retry)
+	if err != nil {
+		return err
+	}
+	return s.db.QueryRow(query, arg...).Scan(&retry.InstanceID)
+}
+
+func (s DestroyRetryStore) Update(_ context.Context, retry *types.DestroyRetry) error {
+	query, arg, err := s.db.BindNamed(destroyRetryUpdate, retry)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(query, arg...)
+	return err
+}
+
+func (s DestroyRetryStore) Delete(_ context.Context, instanceID string) error {
+	_, err := s.db.Exec(destroyRetryDelete, instanceID)
+	return err
+}
+
+const destroyRetryBase = `
+SELECT
+ instance_id
+,pool_name
+,attempts
+,last_error
+,next_attempt
+,created
+FROM destroy_retries
+`
+
+const destroyRetryFindByID = destroyRetryBase + `
+WHERE instance_id = $1
+`
+
+const destroyRetryList = destroyRetryBase + `
+ORDER BY next_attempt ASC
+`
+
+const destroyRetryInsert = `
+INSERT INTO destroy_retries (
+ instance_id
+,pool_name
+,attempts
+,last_error
+,next_attempt
+,created
+) values (
+ :instance_id
+,:pool_name
+,:attempts
+,:last_error
+,:next_attempt
+,:created
+) RETURNING instance_id
+`
+
+const destroyRetryUpdate = `
+UPDATE destroy_retries
+SET
+ attempts     = :attempts
+,last_error   = :last_error
+,next_attempt = :next_attempt
+WHERE instance_id = :instance_id
+`
+
+const destroyRetryDelete = `
+DELETE FROM destroy_retries
+WHERE instance_id = $1
+`
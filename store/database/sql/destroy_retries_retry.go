@@ -0,0 +1,40 @@
+package sql
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+var _ store.DestroyRetryStore = (*DestroyRetryStoreRetry)(nil)
+
+// NewDestroyRetryStoreRetry retries the writes of the destroy retry store aborted on a conflict, see retryOnConflict.
+func NewDestroyRetryStoreRetry(destroyRetryStore store.DestroyRetryStore, retries int) *DestroyRetryStoreRetry {
+	return &DestroyRetryStoreRetry{destroyRetryStore, retries}
+}
+
+type DestroyRetryStoreRetry struct {
+	base    store.DestroyRetryStore
+	retries int
+}
+
+func (s DestroyRetryStoreRetry) Find(ctx context.Context, instanceID string) (*types.DestroyRetry, error) {
+	return s.base.Find(ctx, instanceID)
+}
+
+func (s DestroyRetryStoreRetry) List(ctx context.Context) ([]*types.DestroyRetry, error) {
+	return s.base.List(ctx)
+}
+
+func (s DestroyRetryStoreRetry) Create(ctx context.Context, retry *types.DestroyRetry) error {
+	return retryOnConflict(ctx, s.retries, func() error { return s.base.Create(ctx, retry) })
+}
+
+func (s DestroyRetryStoreRetry) Update(ctx context.Context, retry *types.DestroyRetry) error {
+	return retryOnConflict(ctx, s.retries, func() error { return s.base.Update(ctx, retry) })
+}
+
+func (s DestroyRetryStoreRetry) Delete(ctx context.Context, instanceID string) error {
+	return retryOnConflict(ctx, s.retries, func() error { return s.base.Delete(ctx, instanceID) })
+}
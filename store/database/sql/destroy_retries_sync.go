@@ -0,0 +1,48 @@
+package sql
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-aws/store/database/mutex"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+var _ store.DestroyRetryStore = (*DestroyRetryStoreSync)(nil)
+
+func NewDestroyRetryStoreSync(destroyRetryStore *DestroyRetryStore) *DestroyRetryStoreSync {
+	return &DestroyRetryStoreSync{destroyRetryStore}
+}
+
+type DestroyRetryStoreSync struct{ base *DestroyRetryStore }
+
+func (i DestroyRetryStoreSync) Find(ctx context.Context, instanceID string) (*types.DestroyRetry, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return i.base.Find(ctx, instanceID)
+}
+
+func (i DestroyRetryStoreSync) List(ctx context.Context) ([]*types.DestroyRetry, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return i.base.List(ctx)
+}
+
+func (i DestroyRetryStoreSync) Create(ctx context.Context, retry *types.DestroyRetry) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return i.base.Create(ctx, retry)
+}
+
+func (i DestroyRetryStoreSync) Update(ctx context.Context, retry *types.DestroyRetry) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return i.base.Update(ctx, retry)
+}
+
+func (i DestroyRetryStoreSync) Delete(ctx context.Context, instanceID string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return i.base.Delete(ctx, instanceID)
+}
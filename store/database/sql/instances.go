@@ -43,6 +43,11 @@ func (s InstanceStore) List(_ context.Context, pool string, params *types.QueryP
 			stmt = stmt.Where(squirrel.Eq{"instance_state": params.Status})
 			args = append(args, params.Status)
 		}
+		// the instances stamped with no account predate the segregation of the accounts
+		if params.AccountID != "" {
+			stmt = stmt.Where(squirrel.Or{squirrel.Eq{"instance_account_id": params.AccountID}, squirrel.Eq{"instance_account_id": ""}})
+			args = append(args, params.AccountID, "")
+		}
 	}
 	stmt = stmt.OrderBy("instance_started " + "ASC")
 	sql, _, _ := stmt.ToSql()
@@ -79,10 +84,30 @@ func (s InstanceStore) Update(_ context.Context, instance *types.Instance) error
 	return err
 }
 
+func (s InstanceStore) CompareAndUpdate(_ context.Context, instance *types.Instance, state types.InstanceState) (bool, error) {
+	query, arg, err := s.db.BindNamed(instanceCompareAndUpdate, struct {
+		types.Instance
+		ExpectedState types.InstanceState `db:"expected_state"`
+	}{*instance, state})
+	if err != nil {
+		return false, err
+	}
+	res, err := s.db.Exec(query, arg...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
 func (s InstanceStore) Purge(ctx context.Context) error {
 	panic("implement me")
 }
 
+func (s InstanceStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
 const instanceColumns = `
  instance_name
 ,instance_id
@@ -109,6 +134,21 @@ const instanceColumns = `
 ,instance_updated
 ,is_hibernated
 ,instance_port
+,instance_untrusted
+,instance_provider_id
+,instance_tunnel
+,instance_lease_expires
+,instance_overflow
+,instance_claimed
+,instance_account_id
+,instance_lite_engine_env
+,instance_lite_engine_features
+,instance_maintenance
+,instance_lease_key
+,instance_private_address
+,instance_route
+,instance_key_ref
+,instance_host_key
 `
 
 const instanceFindByID = `SELECT ` + instanceColumns + `
@@ -143,6 +183,21 @@ INSERT INTO instances (
 ,instance_updated
 ,is_hibernated
 ,instance_port
+,instance_untrusted
+,instance_provider_id
+,instance_tunnel
+,instance_lease_expires
+,instance_overflow
+,instance_claimed
+,instance_account_id
+,instance_lite_engine_env
+,instance_lite_engine_features
+,instance_maintenance
+,instance_lease_key
+,instance_private_address
+,instance_route
+,instance_key_ref
+,instance_host_key
 ) values (
  :instance_id
 ,:instance_node_id
@@ -169,6 +224,21 @@ INSERT INTO instances (
 ,:instance_updated
 ,:is_hibernated
 ,:instance_port
+,:instance_untrusted
+,:instance_provider_id
+,:instance_tunnel
+,:instance_lease_expires
+,:instance_overflow
+,:instance_claimed
+,:instance_account_id
+,:instance_lite_engine_env
+,:instance_lite_engine_features
+,:instance_maintenance
+,:instance_lease_key
+,:instance_private_address
+,:instance_route
+,:instance_key_ref
+,:instance_host_key
 ) RETURNING instance_id
 `
 
@@ -185,5 +255,37 @@ SET
  ,instance_updated  = :instance_updated
  ,is_hibernated 	= :is_hibernated
  ,instance_address  = :instance_address
+ ,instance_lease_expires = :instance_lease_expires
+ ,instance_claimed  = :instance_claimed
+ ,instance_account_id = :instance_account_id
+ ,instance_size     = :instance_size
+ ,instance_maintenance = :instance_maintenance
+ ,instance_lease_key = :instance_lease_key
+ ,instance_private_address = :instance_private_address
+ ,instance_route = :instance_route
+ ,instance_key_ref = :instance_key_ref
+ ,instance_host_key = :instance_host_key
+WHERE instance_id   = :instance_id
+`
+
+const instanceCompareAndUpdate = `
+UPDATE instances
+SET
+  instance_state    = :instance_state
+ ,instance_stage	= :instance_stage
+ ,instance_updated  = :instance_updated
+ ,is_hibernated 	= :is_hibernated
+ ,instance_address  = :instance_address
+ ,instance_lease_expires = :instance_lease_expires
+ ,instance_claimed  = :instance_claimed
+ ,instance_account_id = :instance_account_id
+ ,instance_size     = :instance_size
+ ,instance_maintenance = :instance_maintenance
+ ,instance_lease_key = :instance_lease_key
+ ,instance_private_address = :instance_private_address
+ ,instance_route = :instance_route
+ ,instance_key_ref = :instance_key_ref
+ ,instance_host_key = :instance_host_key
 WHERE instance_id   = :instance_id
+  AND instance_state = :expected_state
 `
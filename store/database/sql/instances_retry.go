@@ -0,0 +1,57 @@
+package sql
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+var _ store.InstanceStore = (*InstanceStoreRetry)(nil)
+
+// NewInstanceStoreRetry retries the writes of the instance store aborted on a conflict, see retryOnConflict.
+func NewInstanceStoreRetry(instanceStore store.InstanceStore, retries int) *InstanceStoreRetry {
+	return &InstanceStoreRetry{instanceStore, retries}
+}
+
+type InstanceStoreRetry struct {
+	base    store.InstanceStore
+	retries int
+}
+
+func (i InstanceStoreRetry) Find(ctx context.Context, s string) (*types.Instance, error) {
+	return i.base.Find(ctx, s)
+}
+
+func (i InstanceStoreRetry) List(ctx context.Context, pool string, params *types.QueryParams) ([]*types.Instance, error) {
+	return i.base.List(ctx, pool, params)
+}
+
+func (i InstanceStoreRetry) Create(ctx context.Context, instance *types.Instance) error {
+	return retryOnConflict(ctx, i.retries, func() error { return i.base.Create(ctx, instance) })
+}
+
+func (i InstanceStoreRetry) Delete(ctx context.Context, s string) error {
+	return retryOnConflict(ctx, i.retries, func() error { return i.base.Delete(ctx, s) })
+}
+
+func (i InstanceStoreRetry) Update(ctx context.Context, instance *types.Instance) error {
+	return retryOnConflict(ctx, i.retries, func() error { return i.base.Update(ctx, instance) })
+}
+
+func (i InstanceStoreRetry) CompareAndUpdate(ctx context.Context, instance *types.Instance, state types.InstanceState) (bool, error) {
+	var updated bool
+	err := retryOnConflict(ctx, i.retries, func() (err error) {
+		updated, err = i.base.CompareAndUpdate(ctx, instance, state)
+		return err
+	})
+	return updated, err
+}
+
+func (i InstanceStoreRetry) Purge(ctx context.Context) error {
+	return i.base.Purge(ctx)
+}
+
+func (i InstanceStoreRetry) Ping(ctx context.Context) error {
+	return i.base.Ping(ctx)
+}
@@ -47,8 +47,18 @@ func (i InstanceStoreSync) Update(ctx context.Context, instance *types.Instance)
 	return i.base.Update(ctx, instance)
 }
 
+func (i InstanceStoreSync) CompareAndUpdate(ctx context.Context, instance *types.Instance, state types.InstanceState) (bool, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return i.base.CompareAndUpdate(ctx, instance, state)
+}
+
 func (i InstanceStoreSync) Purge(ctx context.Context) error {
 	mutex.Lock()
 	defer mutex.Unlock()
 	panic("implement me")
 }
+
+func (i InstanceStoreSync) Ping(ctx context.Context) error {
+	return i.base.Ping(ctx)
+}
@@ -0,0 +1,99 @@
+package sql
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var _ store.PoolMappingStore = (*PoolMappingStore)(nil)
+
+func NewPoolMappingStore(db *sqlx.DB) *PoolMappingStore {
+	return &PoolMappingStore{db}
+}
+
+type PoolMappingStore struct {
+	db *sqlx.DB
+}
+
+func (s PoolMappingStore) Find(_ context.Context, accountID, poolName string) (*types.PoolMapping, error) {
+	dst := new(types.PoolMapping)
+	err := s.db.Get(dst, poolMappingFind, accountID, poolName)
+	return dst, err
+}
+
+func (s PoolMappingStore) List(_ context.Context) ([]*types.PoolMapping, error) {
+	dst := []*types.PoolMapping{}
+	err := s.db.Select(&dst, poolMappingList)
+	return dst, err
+}
+
+func (s PoolMappingStore) Create(_ context.Context, mapping *types.PoolMapping) error {
+	query, arg, err := s.db.BindNamed(poolMappingInsert, mapping)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(query, arg...)
+	return err
+}
+
+func (s PoolMappingStore) Update(_ context.Context, mapping *types.PoolMapping) error {
+	query, arg, err := s.db.BindNamed(poolMappingUpdate, mapping)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(query, arg...)
+	return err
+}
+
+func (s PoolMappingStore) Delete(_ context.Context, accountID, poolName string) error {
+	_, err := s.db.Exec(poolMappingDelete, accountID, poolName)
+	return err
+}
+
+const poolMappingBase = `
+SELECT
+ account_id
+,pool_name
+,target_pool
+,updated
+FROM pool_mappings
+`
+
+const poolMappingFind = poolMappingBase + `
+WHERE account_id = $1 AND pool_name = $2
+`
+
+const poolMappingList = poolMappingBase + `
+ORDER BY account_id ASC, pool_name ASC
+`
+
+const poolMappingInsert = `
+INSERT INTO pool_mappings (
+ account_id
+,pool_name
+,target_pool
+,updated
+) values (
+ :account_id
+,:pool_name
+,:target_pool
+,:updated
+)
+`
+
+const poolMappingUpdate = `
+UPDATE pool_mappings
+SET
+ target_pool = :target_pool
+,updated     = :updated
+WHERE account_id = :account_id AND pool_name = :pool_name
+`
+
+const poolMappingDelete = `
+DELETE FROM pool_mappings
+WHERE account_id = $1 AND pool_name = $2
+`
@@ -0,0 +1,40 @@
+package sql
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+var _ store.PoolMappingStore = (*PoolMappingStoreRetry)(nil)
+
+// NewPoolMappingStoreRetry retries the writes of the pool mapping store aborted on a conflict, see retryOnConflict.
+func NewPoolMappingStoreRetry(poolMappingStore store.PoolMappingStore, retries int) *PoolMappingStoreRetry {
+	return &PoolMappingStoreRetry{poolMappingStore, retries}
+}
+
+type PoolMappingStoreRetry struct {
+	base    store.PoolMappingStore
+	retries int
+}
+
+func (s PoolMappingStoreRetry) Find(ctx context.Context, accountID, poolName string) (*types.PoolMapping, error) {
+	return s.base.Find(ctx, accountID, poolName)
+}
+
+func (s PoolMappingStoreRetry) List(ctx context.Context) ([]*types.PoolMapping, error) {
+	return s.base.List(ctx)
+}
+
+func (s PoolMappingStoreRetry) Create(ctx context.Context, mapping *types.PoolMapping) error {
+	return retryOnConflict(ctx, s.retries, func() error { return s.base.Create(ctx, mapping) })
+}
+
+func (s PoolMappingStoreRetry) Update(ctx context.Context, mapping *types.PoolMapping) error {
+	return retryOnConflict(ctx, s.retries, func() error { return s.base.Update(ctx, mapping) })
+}
+
+func (s PoolMappingStoreRetry) Delete(ctx context.Context, accountID, poolName string) error {
+	return retryOnConflict(ctx, s.retries, func() error { return s.base.Delete(ctx, accountID, poolName) })
+}
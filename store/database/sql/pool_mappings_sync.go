@@ -0,0 +1,48 @@
+package sql
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-aws/store/database/mutex"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+var _ store.PoolMappingStore = (*PoolMappingStoreSync)(nil)
+
+func NewPoolMappingStoreSync(poolMappingStore *PoolMappingStore) *PoolMappingStoreSync {
+	return &PoolMappingStoreSync{poolMappingStore}
+}
+
+type PoolMappingStoreSync struct{ base *PoolMappingStore }
+
+func (i PoolMappingStoreSync) Find(ctx context.Context, accountID, poolName string) (*types.PoolMapping, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return i.base.Find(ctx, accountID, poolName)
+}
+
+func (i PoolMappingStoreSync) List(ctx context.Context) ([]*types.PoolMapping, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return i.base.List(ctx)
+}
+
+func (i PoolMappingStoreSync) Create(ctx context.Context, mapping *types.PoolMapping) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return i.base.Create(ctx, mapping)
+}
+
+func (i PoolMappingStoreSync) Update(ctx context.Context, mapping *types.PoolMapping) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return i.base.Update(ctx, mapping)
+}
+
+func (i PoolMappingStoreSync) Delete(ctx context.Context, accountID, poolName string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return i.base.Delete(ctx, accountID, poolName)
+}
@@ -0,0 +1,44 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The postgres errors aborting a statement without side effects, the statement can run again.
+const (
+	codeSerializationFailure = "40001"
+	codeDeadlockDetected     = "40P01"
+)
+
+// retryDelay is the delay before the first retry of a statement, doubled at every retry.
+const retryDelay = 50 * time.Millisecond
+
+// retryOnConflict runs the statement and runs it again if postgres aborted it on a serialization
+// failure or a deadlock, at most the given number of retries.
+func retryOnConflict(ctx context.Context, retries int, fn func() error) error {
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= retries || !isConflict(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func isConflict(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == codeSerializationFailure || pqErr.Code == codeDeadlockDetected
+}
@@ -0,0 +1,75 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+	"github.com/lib/pq"
+)
+
+// conflictingStageRecordStore aborts the first writes on the given postgres error.
+type conflictingStageRecordStore struct {
+	store.StageRecordStore
+	err       error
+	conflicts int // the number of writes aborted before one succeeds
+	calls     int
+}
+
+func (s *conflictingStageRecordStore) Create(context.Context, *types.StageRecord) error {
+	s.calls++
+	if s.calls <= s.conflicts {
+		return s.err
+	}
+	return nil
+}
+
+func TestRetryOnConflict(t *testing.T) {
+	serialization := &pq.Error{Code: codeSerializationFailure}
+	tests := []struct {
+		name      string
+		err       error
+		conflicts int
+		retries   int
+		calls     int
+		failed    bool
+	}{
+		{name: "serialization failure retried", err: serialization, conflicts: 2, retries: 3, calls: 3},
+		{name: "deadlock retried", err: &pq.Error{Code: codeDeadlockDetected}, conflicts: 1, retries: 3, calls: 2},
+		{name: "stops at the limit", err: serialization, conflicts: 5, retries: 2, calls: 3, failed: true},
+		{name: "no retries", err: serialization, conflicts: 1, calls: 1, failed: true},
+		{name: "unique violation not retried", err: &pq.Error{Code: "23505"}, conflicts: 1, retries: 3, calls: 1, failed: true},
+		{name: "other error not retried", err: errors.New("connection refused"), conflicts: 1, retries: 3, calls: 1, failed: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := &conflictingStageRecordStore{err: test.err, conflicts: test.conflicts}
+			err := NewStageRecordStoreRetry(base, test.retries).Create(context.Background(), &types.StageRecord{})
+			if test.failed != (err != nil) {
+				t.Errorf("expected failure %t, got %v", test.failed, err)
+			}
+			if test.failed && !errors.Is(err, test.err) {
+				t.Errorf("expected the error of the last attempt, got %v", err)
+			}
+			if base.calls != test.calls {
+				t.Errorf("expected %d attempts, got %d", test.calls, base.calls)
+			}
+		})
+	}
+}
+
+func TestRetryOnConflict_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := 0
+	err := retryOnConflict(ctx, 3, func() error {
+		calls++
+		return &pq.Error{Code: codeSerializationFailure}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("expected the canceled context to stop the retries, got %v after %d attempts", err, calls)
+	}
+}
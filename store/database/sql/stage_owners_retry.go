@@ -0,0 +1,32 @@
+package sql
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+var _ store.StageOwnerStore = (*StageOwnerStoreRetry)(nil)
+
+// NewStageOwnerStoreRetry retries the writes of the stage owner store aborted on a conflict, see retryOnConflict.
+func NewStageOwnerStoreRetry(stageOwnerStore store.StageOwnerStore, retries int) *StageOwnerStoreRetry {
+	return &StageOwnerStoreRetry{stageOwnerStore, retries}
+}
+
+type StageOwnerStoreRetry struct {
+	base    store.StageOwnerStore
+	retries int
+}
+
+func (s StageOwnerStoreRetry) Find(ctx context.Context, id string) (*types.StageOwner, error) {
+	return s.base.Find(ctx, id)
+}
+
+func (s StageOwnerStoreRetry) Create(ctx context.Context, owner *types.StageOwner) error {
+	return retryOnConflict(ctx, s.retries, func() error { return s.base.Create(ctx, owner) })
+}
+
+func (s StageOwnerStoreRetry) Delete(ctx context.Context, id string) error {
+	return retryOnConflict(ctx, s.retries, func() error { return s.base.Delete(ctx, id) })
+}
@@ -0,0 +1,146 @@
+package sql
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+)
+
+var _ store.StageRecordStore = (*StageRecordStore)(nil)
+
+func NewStageRecordStore(db *sqlx.DB) *StageRecordStore {
+	return &StageRecordStore{db: db, replica: db}
+}
+
+// NewStageRecordStoreWithReplica lists the records from a read replica of the database, the
+// analytics scan many records. The records are found and written on the primary.
+func NewStageRecordStoreWithReplica(db, replica *sqlx.DB) *StageRecordStore {
+	return &StageRecordStore{db: db, replica: replica}
+}
+
+type StageRecordStore struct {
+	db      *sqlx.DB
+	replica *sqlx.DB
+}
+
+func (s StageRecordStore) Find(_ context.Context, stageID string) (*types.StageRecord, error) {
+	dst := new(types.StageRecord)
+	err := s.db.Get(dst, stageRecordFindByID, stageID)
+	return dst, err
+}
+
+func (s StageRecordStore) List(_ context.Context, params *types.StageRecordQuery) ([]*types.StageRecord, error) {
+	dst := []*types.StageRecord{}
+	var args []interface{}
+
+	stmt := builder.Select(stageRecordColumns).From("stage_records")
+	if params != nil {
+		if params.PoolName != "" {
+			stmt = stmt.Where(squirrel.Eq{"pool_name": params.PoolName})
+			args = append(args, params.PoolName)
+		}
+		if params.Since != 0 {
+			stmt = stmt.Where(squirrel.GtOrEq{"started": params.Since})
+			args = append(args, params.Since)
+		}
+	}
+	stmt = stmt.OrderBy("started ASC")
+	sql, _, _ := stmt.ToSql()
+	err := s.replica.Select(&dst, sql, args...)
+	return dst, err
+}
+
+func (s StageRecordStore) Create(_ context.Context, record *types.StageRecord) error {
+	query, arg, err := s.db.BindNamed(stageRecordInsert, record)
+	if err != nil {
+		return err
+	}
+	return s.db.QueryRow(query, arg...).Scan(&record.StageID)
+}
+
+func (s StageRecordStore) Update(_ context.Context, record *types.StageRecord) error {
+	query, arg, err := s.db.BindNamed(stageRecordUpdate, record)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(query, arg...)
+	return err
+}
+
+const stageRecordColumns = `
+ stage_id
+,pool_name
+,driver
+,instance_id
+,region
+,hibernated
+,boot_ms
+,health_check_ms
+,run_ms
+,result
+,started
+,size
+,cpus
+,mem_total_mb
+,peak_cpu_pct
+,peak_mem_mb
+`
+
+const stageRecordFindByID = `SELECT ` + stageRecordColumns + `
+FROM stage_records
+WHERE stage_id = $1
+`
+
+const stageRecordInsert = `
+INSERT INTO stage_records (
+ stage_id
+,pool_name
+,driver
+,instance_id
+,region
+,hibernated
+,boot_ms
+,health_check_ms
+,run_ms
+,result
+,started
+,size
+,cpus
+,mem_total_mb
+,peak_cpu_pct
+,peak_mem_mb
+) values (
+ :stage_id
+,:pool_name
+,:driver
+,:instance_id
+,:region
+,:hibernated
+,:boot_ms
+,:health_check_ms
+,:run_ms
+,:result
+,:started
+,:size
+,:cpus
+,:mem_total_mb
+,:peak_cpu_pct
+,:peak_mem_mb
+) RETURNING stage_id
+`
+
+const stageRecordUpdate = `
+UPDATE stage_records
+SET
+ run_ms = :run_ms
+,result = :result
+,cpus = :cpus
+,mem_total_mb = :mem_total_mb
+,peak_cpu_pct = :peak_cpu_pct
+,peak_mem_mb = :peak_mem_mb
+WHERE stage_id = :stage_id
+`
@@ -0,0 +1,36 @@
+package sql
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+var _ store.StageRecordStore = (*StageRecordStoreRetry)(nil)
+
+// NewStageRecordStoreRetry retries the writes of the stage record store aborted on a conflict, see retryOnConflict.
+func NewStageRecordStoreRetry(stageRecordStore store.StageRecordStore, retries int) *StageRecordStoreRetry {
+	return &StageRecordStoreRetry{stageRecordStore, retries}
+}
+
+type StageRecordStoreRetry struct {
+	base    store.StageRecordStore
+	retries int
+}
+
+func (s StageRecordStoreRetry) Find(ctx context.Context, stageID string) (*types.StageRecord, error) {
+	return s.base.Find(ctx, stageID)
+}
+
+func (s StageRecordStoreRetry) List(ctx context.Context, params *types.StageRecordQuery) ([]*types.StageRecord, error) {
+	return s.base.List(ctx, params)
+}
+
+func (s StageRecordStoreRetry) Create(ctx context.Context, record *types.StageRecord) error {
+	return retryOnConflict(ctx, s.retries, func() error { return s.base.Create(ctx, record) })
+}
+
+func (s StageRecordStoreRetry) Update(ctx context.Context, record *types.StageRecord) error {
+	return retryOnConflict(ctx, s.retries, func() error { return s.base.Update(ctx, record) })
+}
@@ -0,0 +1,42 @@
+package sql
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-aws/store/database/mutex"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+var _ store.StageRecordStore = (*StageRecordStoreSync)(nil)
+
+func NewStageRecordStoreSync(stageRecordStore *StageRecordStore) *StageRecordStoreSync {
+	return &StageRecordStoreSync{stageRecordStore}
+}
+
+type StageRecordStoreSync struct{ base *StageRecordStore }
+
+func (i StageRecordStoreSync) Find(ctx context.Context, stageID string) (*types.StageRecord, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return i.base.Find(ctx, stageID)
+}
+
+func (i StageRecordStoreSync) List(ctx context.Context, params *types.StageRecordQuery) ([]*types.StageRecord, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return i.base.List(ctx, params)
+}
+
+func (i StageRecordStoreSync) Create(ctx context.Context, record *types.StageRecord) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return i.base.Create(ctx, record)
+}
+
+func (i StageRecordStoreSync) Update(ctx context.Context, record *types.StageRecord) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return i.base.Update(ctx, record)
+}
@@ -0,0 +1,109 @@
+package sql
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var _ store.TaskJournalStore = (*TaskJournalStore)(nil)
+
+func NewTaskJournalStore(db *sqlx.DB) *TaskJournalStore {
+	return &TaskJournalStore{db}
+}
+
+type TaskJournalStore struct {
+	db *sqlx.DB
+}
+
+func (s TaskJournalStore) List(_ context.Context, runner string) ([]*types.TaskJournalEntry, error) {
+	dst := []*types.TaskJournalEntry{}
+	err := s.db.Select(&dst, taskJournalList, runner)
+	return dst, err
+}
+
+func (s TaskJournalStore) Create(_ context.Context, entry *types.TaskJournalEntry) error {
+	query, arg, err := s.db.BindNamed(taskJournalInsert, entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(query, arg...)
+	return err
+}
+
+func (s TaskJournalStore) Update(_ context.Context, entry *types.TaskJournalEntry) error {
+	query, arg, err := s.db.BindNamed(taskJournalUpdate, entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(query, arg...)
+	return err
+}
+
+func (s TaskJournalStore) Delete(_ context.Context, taskID string) error {
+	_, err := s.db.Exec(taskJournalDelete, taskID)
+	return err
+}
+
+const taskJournalList = `
+SELECT
+ task_id
+,task_type
+,runner
+,stage_id
+,pool_id
+,account_id
+,state
+,task
+,response
+,created
+,updated
+FROM task_journal
+WHERE runner = $1
+ORDER BY created ASC
+`
+
+const taskJournalInsert = `
+INSERT INTO task_journal (
+ task_id
+,task_type
+,runner
+,stage_id
+,pool_id
+,account_id
+,state
+,task
+,response
+,created
+,updated
+) values (
+ :task_id
+,:task_type
+,:runner
+,:stage_id
+,:pool_id
+,:account_id
+,:state
+,:task
+,:response
+,:created
+,:updated
+)
+`
+
+const taskJournalUpdate = `
+UPDATE task_journal
+SET
+ state    = :state
+,response = :response
+,updated  = :updated
+WHERE task_id = :task_id
+`
+
+const taskJournalDelete = `
+DELETE FROM task_journal
+WHERE task_id = $1
+`
@@ -0,0 +1,36 @@
+package sql
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+var _ store.TaskJournalStore = (*TaskJournalStoreRetry)(nil)
+
+// NewTaskJournalStoreRetry retries the writes of the task journal store aborted on a conflict, see retryOnConflict.
+func NewTaskJournalStoreRetry(taskJournalStore store.TaskJournalStore, retries int) *TaskJournalStoreRetry {
+	return &TaskJournalStoreRetry{taskJournalStore, retries}
+}
+
+type TaskJournalStoreRetry struct {
+	base    store.TaskJournalStore
+	retries int
+}
+
+func (s TaskJournalStoreRetry) List(ctx context.Context, runner string) ([]*types.TaskJournalEntry, error) {
+	return s.base.List(ctx, runner)
+}
+
+func (s TaskJournalStoreRetry) Create(ctx context.Context, entry *types.TaskJournalEntry) error {
+	return retryOnConflict(ctx, s.retries, func() error { return s.base.Create(ctx, entry) })
+}
+
+func (s TaskJournalStoreRetry) Update(ctx context.Context, entry *types.TaskJournalEntry) error {
+	return retryOnConflict(ctx, s.retries, func() error { return s.base.Update(ctx, entry) })
+}
+
+func (s TaskJournalStoreRetry) Delete(ctx context.Context, taskID string) error {
+	return retryOnConflict(ctx, s.retries, func() error { return s.base.Delete(ctx, taskID) })
+}
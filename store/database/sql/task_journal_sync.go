@@ -0,0 +1,42 @@
+package sql
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-aws/store/database/mutex"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+var _ store.TaskJournalStore = (*TaskJournalStoreSync)(nil)
+
+func NewTaskJournalStoreSync(taskJournalStore *TaskJournalStore) *TaskJournalStoreSync {
+	return &TaskJournalStoreSync{taskJournalStore}
+}
+
+type TaskJournalStoreSync struct{ base *TaskJournalStore }
+
+func (i TaskJournalStoreSync) List(ctx context.Context, runner string) ([]*types.TaskJournalEntry, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return i.base.List(ctx, runner)
+}
+
+func (i TaskJournalStoreSync) Create(ctx context.Context, entry *types.TaskJournalEntry) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return i.base.Create(ctx, entry)
+}
+
+func (i TaskJournalStoreSync) Update(ctx context.Context, entry *types.TaskJournalEntry) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return i.base.Update(ctx, entry)
+}
+
+func (i TaskJournalStoreSync) Delete(ctx context.Context, taskID string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return i.base.Delete(ctx, taskID)
+}
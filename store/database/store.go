@@ -16,15 +16,24 @@ var _ = squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
 
 // ConnectSQL to a database and verify with a ping.
 func ConnectSQL(driver, datasource string) (*sqlx.DB, error) {
-	db, err := sql.Open(driver, datasource)
+	dbx, err := OpenSQL(driver, datasource)
 	if err != nil {
 		return nil, err
 	}
-	dbx := sqlx.NewDb(db, driver)
-	if err := pingDatabase(dbx); err != nil {
+	if err = setupDatabase(dbx); err != nil {
+		return nil, err
+	}
+	return dbx, nil
+}
+
+// OpenSQL connects to a database and verifies with a ping, without migrating it.
+func OpenSQL(driver, datasource string) (*sqlx.DB, error) {
+	db, err := sql.Open(driver, datasource)
+	if err != nil {
 		return nil, err
 	}
-	if err := setupDatabase(dbx); err != nil {
+	dbx := sqlx.NewDb(db, driver)
+	if err = pingDatabase(dbx); err != nil {
 		return nil, err
 	}
 	return dbx, nil
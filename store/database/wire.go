@@ -5,14 +5,19 @@
 package database
 
 import (
+	"fmt"
+	"io"
+
 	"github.com/drone-runners/drone-runner-aws/store"
 	"github.com/drone-runners/drone-runner-aws/store/database/ldb"
+	"github.com/drone-runners/drone-runner-aws/store/database/memory"
 	"github.com/drone-runners/drone-runner-aws/store/database/sql"
 	"github.com/drone-runners/drone-runner-aws/store/singleinstance"
 	"github.com/syndtr/goleveldb/leveldb"
 
 	"github.com/google/wire"
 	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
 )
 
 // WireSet provides a wire set for this package
@@ -23,6 +28,9 @@ var WireSet = wire.NewSet(
 
 const SingleInstance = "singleinstance"
 
+// Memory keeps the data in memory, snapshotted to the file of the datasource if set, see memory.Open.
+const Memory = "memory"
+
 // ProvideSQLDatabase provides a database connection.
 func ProvideSQLDatabase(driver, datasource string) (*sqlx.DB, error) {
 	switch driver {
@@ -66,18 +74,161 @@ func ProvideSQLStageOwnerStore(db *sqlx.DB) store.StageOwnerStore {
 	}
 }
 
-func ProvideStore(driver, datasource string) (store.InstanceStore, store.StageOwnerStore, error) {
+// ProvideSQLDestroyRetryStore provides a destroy retry store. There is no retry store
+// for the single instance store.
+func ProvideSQLDestroyRetryStore(db *sqlx.DB) store.DestroyRetryStore {
+	switch db.DriverName() {
+	case "postgres":
+		return sql.NewDestroyRetryStore(db)
+	case SingleInstance:
+		return nil
+	default:
+		return sql.NewDestroyRetryStoreSync(
+			sql.NewDestroyRetryStore(db),
+		)
+	}
+}
+
+// ProvideSQLStageRecordStore provides a stage record store. There is no record store for the
+// single instance store.
+func ProvideSQLStageRecordStore(db *sqlx.DB) store.StageRecordStore {
+	switch db.DriverName() {
+	case "postgres":
+		return sql.NewStageRecordStore(db)
+	case SingleInstance:
+		return nil
+	default:
+		return sql.NewStageRecordStoreSync(
+			sql.NewStageRecordStore(db),
+		)
+	}
+}
+
+// ProvideSQLPoolMappingStore provides a pool mapping store. There is no mapping store for the
+// single instance store.
+func ProvideSQLPoolMappingStore(db *sqlx.DB) store.PoolMappingStore {
+	switch db.DriverName() {
+	case "postgres":
+		return sql.NewPoolMappingStore(db)
+	case SingleInstance:
+		return nil
+	default:
+		return sql.NewPoolMappingStoreSync(
+			sql.NewPoolMappingStore(db),
+		)
+	}
+}
+
+// ProvideSQLTaskJournalStore provides a task journal store. There is no journal store for the
+// single instance store.
+func ProvideSQLTaskJournalStore(db *sqlx.DB) store.TaskJournalStore {
+	switch db.DriverName() {
+	case "postgres":
+		return sql.NewTaskJournalStore(db)
+	case SingleInstance:
+		return nil
+	default:
+		return sql.NewTaskJournalStoreSync(
+			sql.NewTaskJournalStore(db),
+		)
+	}
+}
+
+// ProvideStore provides the stores of the database and the cleanup closing the database, which the
+// commands call on their shutdown: the memory store writes its last snapshot on close. The options
+// only apply to postgres, but the snapshot interval which applies to the memory store.
+func ProvideStore(driver, datasource string, opts ...Option) (store.InstanceStore, store.StageOwnerStore, store.DestroyRetryStore, store.StageRecordStore, store.PoolMappingStore, store.TaskJournalStore, func(), error) {
+	if driver == Memory {
+		o := &options{snapshotInterval: defaultSnapshotInterval}
+		for _, opt := range opts {
+			opt(o)
+		}
+		db, err := memory.Open(datasource, o.snapshotInterval)
+		if err != nil {
+			return nil, nil, nil, nil, nil, nil, nil, err
+		}
+		return memory.NewInstanceStore(db), memory.NewStageOwnerStore(db), memory.NewDestroyRetryStore(db), memory.NewStageRecordStore(db), memory.NewPoolMappingStore(db), memory.NewTaskJournalStore(db), closeFunc(db), nil
+	}
 	if driver == "leveldb" {
 		db, err := leveldb.OpenFile(datasource, nil)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, nil, nil, nil, nil, err
 		}
-		return ldb.NewInstanceStore(db), ldb.NewStageOwnerStore(db), nil
+		return ldb.NewInstanceStore(db), ldb.NewStageOwnerStore(db), ldb.NewDestroyRetryStore(db), ldb.NewStageRecordStore(db), ldb.NewPoolMappingStore(db), ldb.NewTaskJournalStore(db), closeFunc(db), nil
+	}
+	if driver == "postgres" {
+		return providePostgresStore(datasource, opts...)
 	}
 
 	db, err := ProvideSQLDatabase(driver, datasource)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
+	}
+	cleanup := func() {}
+	if driver != SingleInstance {
+		cleanup = closeFunc(db)
+	}
+	return ProvideSQLInstanceStore(db), ProvideSQLStageOwnerStore(db), ProvideSQLDestroyRetryStore(db), ProvideSQLStageRecordStore(db), ProvideSQLPoolMappingStore(db), ProvideSQLTaskJournalStore(db), cleanup, nil
+}
+
+// providePostgresStore provides the stores of a postgres database, with the statement timeout, the
+// read replica of the stage records and the retries of the writes aborted on a conflict.
+func providePostgresStore(datasource string, opts ...Option) (store.InstanceStore, store.StageOwnerStore, store.DestroyRetryStore, store.StageRecordStore, store.PoolMappingStore, store.TaskJournalStore, func(), error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	datasource, err := withStatementTimeout(datasource, o.statementTimeout)
+	if err != nil {
+		return nil, nil, nil, nil, nil, nil, nil, err
+	}
+	db, err := ConnectSQL("postgres", datasource)
+	if err != nil {
+		return nil, nil, nil, nil, nil, nil, nil, err
+	}
+	replica := db
+	if o.replicaDatasource != "" {
+		var replicaDatasource string
+		if replicaDatasource, err = withStatementTimeout(o.replicaDatasource, o.statementTimeout); err != nil {
+			return nil, nil, nil, nil, nil, nil, nil, err
+		}
+		// the replica follows the primary, it is not migrated
+		if replica, err = OpenSQL("postgres", replicaDatasource); err != nil {
+			db.Close() //nolint:errcheck
+			return nil, nil, nil, nil, nil, nil, nil, fmt.Errorf("could not connect to the replica: %w", err)
+		}
+	}
+	cleanup := closeFunc(db)
+	if replica != db {
+		cleanup = closeFunc(db, replica)
+	}
+
+	var (
+		instanceStore    store.InstanceStore     = sql.NewInstanceStore(db)
+		stageOwnerStore  store.StageOwnerStore   = sql.NewStageOwnerStore(db)
+		destroyRetry     store.DestroyRetryStore = sql.NewDestroyRetryStore(db)
+		stageRecordStore store.StageRecordStore  = sql.NewStageRecordStoreWithReplica(db, replica)
+		poolMappingStore store.PoolMappingStore  = sql.NewPoolMappingStore(db)
+		taskJournalStore store.TaskJournalStore  = sql.NewTaskJournalStore(db)
+	)
+	if o.retries > 0 {
+		instanceStore = sql.NewInstanceStoreRetry(instanceStore, o.retries)
+		stageOwnerStore = sql.NewStageOwnerStoreRetry(stageOwnerStore, o.retries)
+		destroyRetry = sql.NewDestroyRetryStoreRetry(destroyRetry, o.retries)
+		stageRecordStore = sql.NewStageRecordStoreRetry(stageRecordStore, o.retries)
+		poolMappingStore = sql.NewPoolMappingStoreRetry(poolMappingStore, o.retries)
+		taskJournalStore = sql.NewTaskJournalStoreRetry(taskJournalStore, o.retries)
+	}
+	return instanceStore, stageOwnerStore, destroyRetry, stageRecordStore, poolMappingStore, taskJournalStore, cleanup, nil
+}
+
+// closeFunc returns the cleanup closing the databases, the errors are logged.
+func closeFunc(dbs ...io.Closer) func() {
+	return func() {
+		for _, db := range dbs {
+			if err := db.Close(); err != nil {
+				logrus.WithError(err).Errorln("database: failed to close the database")
+			}
+		}
 	}
-	return ProvideSQLInstanceStore(db), ProvideSQLStageOwnerStore(db), nil
 }
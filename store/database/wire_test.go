@@ -0,0 +1,32 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestProvideStore_MemoryCleanup(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "runner.json")
+
+	instanceStore, _, _, _, _, _, cleanup, err := ProvideStore(Memory, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = instanceStore.Create(ctx, &types.Instance{ID: "instance", Pool: "linux"}); err != nil {
+		t.Fatal(err)
+	}
+	cleanup()
+
+	instanceStore, _, _, _, _, _, cleanup, err = ProvideStore(Memory, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	if _, err = instanceStore.Find(ctx, "instance"); err != nil {
+		t.Errorf("expected the cleanup to snapshot the memory store, got %v", err)
+	}
+}
@@ -37,6 +37,10 @@ func (s InstanceStore) Update(_ context.Context, instance *types.Instance) error
 	return nil
 }
 
+func (s InstanceStore) CompareAndUpdate(_ context.Context, instance *types.Instance, state types.InstanceState) (bool, error) {
+	return true, nil
+}
+
 func (s InstanceStore) Delete(_ context.Context, id string) error {
 	return nil
 }
@@ -48,3 +52,7 @@ func (s InstanceStore) List(_ context.Context, pool string, params *types.QueryP
 func (s InstanceStore) Purge(ctx context.Context) error {
 	return nil
 }
+
+func (s InstanceStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
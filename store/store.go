@@ -12,7 +12,12 @@ type InstanceStore interface {
 	Create(context.Context, *types.Instance) error
 	Delete(context.Context, string) error
 	Update(context.Context, *types.Instance) error
+	// CompareAndUpdate updates the instance only if it is still in the given state in the store,
+	// it reports whether the instance was updated.
+	CompareAndUpdate(ctx context.Context, instance *types.Instance, state types.InstanceState) (bool, error)
 	Purge(context.Context) error
+	// Ping checks the connection to the database.
+	Ping(context.Context) error
 }
 
 type StageOwnerStore interface {
@@ -20,3 +25,34 @@ type StageOwnerStore interface {
 	Create(context.Context, *types.StageOwner) error
 	Delete(context.Context, string) error
 }
+
+type StageRecordStore interface {
+	Find(ctx context.Context, stageID string) (*types.StageRecord, error)
+	// List returns the records ordered by start time.
+	List(context.Context, *types.StageRecordQuery) ([]*types.StageRecord, error)
+	Create(context.Context, *types.StageRecord) error
+	Update(context.Context, *types.StageRecord) error
+}
+
+type DestroyRetryStore interface {
+	Find(ctx context.Context, instanceID string) (*types.DestroyRetry, error)
+	List(context.Context) ([]*types.DestroyRetry, error)
+	Create(context.Context, *types.DestroyRetry) error
+	Update(context.Context, *types.DestroyRetry) error
+	Delete(context.Context, string) error
+}
+
+type PoolMappingStore interface {
+	Find(ctx context.Context, accountID, poolName string) (*types.PoolMapping, error)
+	List(context.Context) ([]*types.PoolMapping, error)
+	Create(context.Context, *types.PoolMapping) error
+	Update(context.Context, *types.PoolMapping) error
+	Delete(ctx context.Context, accountID, poolName string) error
+}
+
+type TaskJournalStore interface {
+	List(ctx context.Context, runner string) ([]*types.TaskJournalEntry, error)
+	Create(context.Context, *types.TaskJournalEntry) error
+	Update(context.Context, *types.TaskJournalEntry) error
+	Delete(ctx context.Context, taskID string) error
+}
@@ -26,6 +26,7 @@ const (
 	VMFusion     = DriverType("vmfusion")
 	Noop         = DriverType("noop")
 	Nomad        = DriverType("nomad")
+	Static       = DriverType("static")
 )
 
 // InstanceState type enumeration.
@@ -33,6 +34,7 @@ const (
 	StateCreated     = InstanceState("created")
 	StateInUse       = InstanceState("inuse")
 	StateHibernating = InstanceState("hibernating")
+	StateTerminating = InstanceState("terminating") // the instance is queued for destruction
 )
 
 type Instance struct {
@@ -57,6 +59,54 @@ type Instance struct {
 	Started      int64  `db:"instance_started" json:"started"`
 	IsHibernated bool   `db:"is_hibernated" json:"is_hibernated"`
 	Port         int64  `db:"instance_port" json:"port"`
+	Untrusted    bool   `db:"instance_untrusted" json:"untrusted"`
+	// ProviderID identifies the instance in the cloud console, e.g. the EC2 instance id,
+	// the self link of a GCP instance or the allocation id of the nomad resource job.
+	ProviderID string `db:"instance_provider_id" json:"provider_id"`
+	// Tunnel is set if the lite-engine of the instance is reached through the tunnel it dials to the runner.
+	Tunnel bool `db:"instance_tunnel" json:"tunnel"`
+	// LeaseExpires is the unix time until which the busy instance is kept past its max age, see drivers.Manager.ExtendLease.
+	LeaseExpires int64 `db:"instance_lease_expires" json:"lease_expires"`
+	// Overflow is set if the instance was created over the max size of its pool, see drivers.Pool.Overflow.
+	Overflow bool `db:"instance_overflow" json:"overflow"`
+	// Claimed is the unix time the instance was claimed by the setup of a stage, zero once the setup completed, see
+	// drivers.Manager.StartClaimReleaser.
+	Claimed int64 `db:"instance_claimed" json:"claimed"`
+	// AccountID is the account of the stage the instance was claimed for, the instance is never handed to another account.
+	// It is empty for the free instances.
+	AccountID string `db:"instance_account_id" json:"account_id"`
+	// LiteEngineEnv are the names of the variables the startup script added to the environment of the lite-engine,
+	// comma separated, their values are not stored. LiteEngineFeatures are its feature flags, comma separated.
+	LiteEngineEnv      string `db:"instance_lite_engine_env" json:"lite_engine_env"`
+	LiteEngineFeatures string `db:"instance_lite_engine_features" json:"lite_engine_features"`
+	// Maintenance is the upcoming maintenance or interruption of the instance announced by its provider, e.g. a
+	// scheduled reboot of its host or the interruption of a spot instance, empty if none, see drivers.MaintenanceWatcher.
+	Maintenance string `db:"instance_maintenance" json:"maintenance"`
+	// LeaseKey signs the calls of the runner to the lite-engine of the instance, empty if its pool doesn't use the
+	// lease tokens. It is rotated with the setup of every stage, see leasetoken.
+	LeaseKey string `db:"instance_lease_key" json:"lease_key"`
+	// PrivateAddress is the address of the instance in its network, set by the drivers of the pools with routes. Route
+	// is the way the runner reaches the lite-engine of the instance, empty if it uses the address, see route.Select.
+	PrivateAddress string `db:"instance_private_address" json:"private_address"`
+	Route          Route  `db:"instance_route" json:"route"`
+	// KeyRef is the reference of the TLS key of the instance published out of its user data, revoked once the
+	// instance is destroyed, empty if the key is in the user data, see keydelivery.
+	KeyRef string `db:"instance_key_ref" json:"key_ref"`
+	// HostKey are the ssh host keys of the instance in the authorized_keys format, one per line, recorded by the ssh
+	// bootstrap or read from its console, empty if unknown. The later ssh connections verify the instance with them.
+	HostKey string `db:"instance_host_key" json:"host_key"`
+	// Timings are the phases of the provisioning of the instance for the current stage, they are not stored.
+	Timings *ProvisionTimings `db:"-" json:"-"`
+}
+
+// ProvisionTimings breaks the setup of a stage down into its phases, in milliseconds.
+type ProvisionTimings struct {
+	PoolWaitMs    int64 `json:"pool_wait_ms"`           // waiting for the pool and claiming an instance
+	CreateMs      int64 `json:"create_ms,omitempty"`    // the driver creating the instance, zero for a warm instance
+	StartMs       int64 `json:"start_ms,omitempty"`     // starting the hibernated instance
+	HealthCheckMs int64 `json:"health_check_ms"`        // from the instance until its lite-engine was healthy
+	SetupMs       int64 `json:"setup_ms"`               // the setup call of the lite-engine
+	WorkspaceMs   int64 `json:"workspace_ms,omitempty"` // restoring the workspace snapshot
 }
 
 type Tmate struct {
@@ -82,6 +132,306 @@ type InstanceCreateOpts struct {
 	HarnessTestBinaryURI string
 	PluginBinaryURI      string
 	Tmate                Tmate
+	Untrusted            *UntrustedProfile
+	StartupScript        string
+	// Name is the name rendered from the name template of the pool, empty if the driver picks the name.
+	Name string
+	// Persistent requires the lite-engine to be started on every boot, e.g. instances stopped in standby.
+	Persistent bool
+	// LiteEnginePort is the port the lite-engine of the instance listens on.
+	LiteEnginePort int
+	// Tunnel is the tunnel server the instance dials, nil if the lite-engine is reached directly.
+	Tunnel *Tunnel
+	// Slim only installs the lite-engine and its certificates on the instance.
+	Slim bool
+	// Tuning holds the kernel settings of the linux instances.
+	Tuning Tuning
+	// Checksums are the digests the downloaded binaries are verified against.
+	Checksums Checksums
+	// WindowsContainers sets up the windows instances for the Windows containers.
+	WindowsContainers WindowsContainers
+	// LiteEngineEnv and LiteEngineFeatures are the environment and the feature flags of the lite-engine.
+	LiteEngineEnv      map[string]string
+	LiteEngineFeatures []string
+	// LeaseKey is the key of the lease tokens the lite-engine verifies, empty if not used.
+	LeaseKey string
+	// KeyFetch is the command fetching the TLS key on the instance, the key is left out of the user data
+	// if set.
+	KeyFetch string
+}
+
+// IPFamily is the IP stack of the instances of a pool.
+type IPFamily string
+
+const (
+	// IPv4 instances only get an IPv4 address, the default.
+	IPv4 IPFamily = "ipv4"
+	// DualStack instances get an IPv4 and an IPv6 address, the lite-engine is reached over IPv6
+	// unless the zone of the instance has no IPv6 subnet.
+	DualStack IPFamily = "dual"
+	// IPv6 instances get no public IPv4 address, the lite-engine is reached over IPv6.
+	IPv6 IPFamily = "ipv6"
+)
+
+// Route is a way the runner reaches the lite-engine of an instance.
+type Route string
+
+const (
+	// RoutePrivate reaches the instance on its private address, e.g. from a runner in the same VPC.
+	RoutePrivate Route = "private"
+	// RoutePublic reaches the instance on the address set by its driver, its public address if it has one.
+	RoutePublic Route = "public"
+	// RouteSSM forwards the port of the lite-engine through an AWS Systems Manager session.
+	RouteSSM Route = "ssm"
+	// RouteIAP forwards the port of the lite-engine through the GCP Identity-Aware Proxy.
+	RouteIAP Route = "iap"
+)
+
+// Direct reports whether the route connects to an address of the instance rather than forwarding through a proxy.
+func (r Route) Direct() bool {
+	return r == RoutePrivate || r == RoutePublic
+}
+
+// Tunnel is the server the instances behind a NAT dial to let the runner reach their lite-engine.
+type Tunnel struct {
+	Address    string // host:port of the server
+	KnownHosts string // known_hosts line of the server
+}
+
+// LiteEngine configures the lite-engine of the instances of a pool.
+type LiteEngine struct {
+	// Port the lite-engine listens on, for images which already use the default port.
+	Port int `json:"port,omitempty" yaml:"port,omitempty"`
+	// CACertPath and CAKeyPath are the PEM files of a CA signing the certificates of the lite-engines,
+	// for networks which only trust known CAs. A CA is generated for every instance if not set.
+	CACertPath string `json:"ca_cert_path,omitempty" yaml:"ca_cert_path,omitempty"`
+	CAKeyPath  string `json:"ca_key_path,omitempty" yaml:"ca_key_path,omitempty"`
+	// Tunnel makes the instances dial the runner, for instances the runner can't reach, e.g. behind a NAT.
+	Tunnel bool `json:"tunnel,omitempty" yaml:"tunnel,omitempty"`
+	// Env is added to the environment file of the lite-engine by the startup script, e.g. its DNS servers.
+	Env map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	// Features are the feature flags of the lite-engine, e.g. its experimental containerd mode, passed as
+	// the comma separated FEATURE_FLAGS variable of its environment.
+	Features []string `json:"features,omitempty" yaml:"features,omitempty"`
+	// LeaseTokens makes the runner sign its calls to the lite-engines with short-lived tokens bound to the
+	// instances, verified by the lite-engines supporting them, see leasetoken.
+	LeaseTokens bool `json:"lease_tokens,omitempty" yaml:"lease_tokens,omitempty"`
+	// Routes are the ways the runner tries to reach the lite-engines, in order, e.g. the private address, the
+	// public address and then a port forward through AWS SSM or GCP IAP. The address set by the driver if empty.
+	Routes []Route `json:"routes,omitempty" yaml:"routes,omitempty"`
+	// KeyDelivery keeps the TLS key of the lite-engines out of the user data, the instances fetch it while they
+	// boot: url from the key server of the runner, ssm from an AWS SSM parameter or secret-manager from a GCP
+	// secret. The key is in the user data if empty.
+	KeyDelivery string `json:"key_delivery,omitempty" yaml:"key_delivery,omitempty"`
+}
+
+// UntrustedProfile defines the hardening applied to instances running untrusted
+// builds, e.g. pull requests from forks.
+type UntrustedProfile struct {
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// MaxAgeMins limits the lifetime of an untrusted instance, the purger default applies if unset.
+	MaxAgeMins int64 `json:"max_age_mins,omitempty" yaml:"max_age_mins,omitempty"`
+	// SecurityGroups restrict the egress traffic of an untrusted instance. These are
+	// security group IDs on amazon and network tags on google.
+	SecurityGroups []string `json:"security_groups,omitempty" yaml:"security_groups,omitempty"`
+}
+
+// RolloutPolicy defines the blue/green rollout of a new image of a pool: once a canary instance
+// with the new image passes the validation, a shadow pool with the new image gets a share of the
+// new stages, and the pool is cut over to the new image unless the image fails more often than the old one.
+type RolloutPolicy struct {
+	// Percent of the new stages set up on the new image, 0 updates the image of the pool at once.
+	Percent int `json:"percent,omitempty" yaml:"percent,omitempty"`
+	// MinStages set up on the new image before the failure rates are compared, 20 if unset.
+	MinStages int `json:"min_stages,omitempty" yaml:"min_stages,omitempty"`
+	// DurationMins after which the failure rates are compared regardless of the stages, 60 if unset.
+	DurationMins int64 `json:"duration_mins,omitempty" yaml:"duration_mins,omitempty"`
+	// MaxFailureIncrease is the increase of the failure rate, e.g. 0.05, above which the new image is rolled back.
+	MaxFailureIncrease float64 `json:"max_failure_increase,omitempty" yaml:"max_failure_increase,omitempty"`
+	// CanaryScript validates a canary instance with the new image before the rollout, by default it
+	// checks docker and git work and the names resolve.
+	CanaryScript string `json:"canary_script,omitempty" yaml:"canary_script,omitempty"`
+}
+
+// PoolService is a service container started on the instances of a pool for every stage, e.g. a
+// docker daemon, a cache proxy or a test database, shared by the steps of the stage. The steps
+// reach it by its name on the network of the stage.
+type PoolService struct {
+	Name       string            `json:"name" yaml:"name"`
+	Image      string            `json:"image" yaml:"image"`
+	Entrypoint []string          `json:"entrypoint,omitempty" yaml:"entrypoint,omitempty"`
+	Command    []string          `json:"command,omitempty" yaml:"command,omitempty"`
+	Envs       map[string]string `json:"envs,omitempty" yaml:"envs,omitempty"`
+	Ports      map[string]string `json:"ports,omitempty" yaml:"ports,omitempty"` // the container port by host port
+	// Privileged services, e.g. a docker daemon, are not started on the instances of untrusted builds.
+	Privileged bool `json:"privileged,omitempty" yaml:"privileged,omitempty"`
+}
+
+// DestroyHook is a script run on the instances of a pool through their lite-engine right before
+// they are destroyed, e.g. to deactivate a license or to push the test insights of the stage. The
+// script is a text/template, see drivers.HookData. Its output is streamed to the logs of the stage.
+type DestroyHook struct {
+	Name        string `json:"name" yaml:"name"`
+	Script      string `json:"script" yaml:"script"`
+	TimeoutSecs int64  `json:"timeout_secs,omitempty" yaml:"timeout_secs,omitempty"` // 5 minutes by default
+	// ContinueOnFailure runs the next hooks when the hook fails or times out, they are skipped otherwise.
+	// The instance is destroyed in any case.
+	ContinueOnFailure bool `json:"continue_on_failure,omitempty" yaml:"continue_on_failure,omitempty"`
+}
+
+// WorkspaceSnapshot is a tarball of a workspace, e.g. a checkout of a monorepo with its
+// dependencies installed, extracted into the workspace before the first step of a stage. The URL
+// is an s3://, a gs:// or an http(s):// URL.
+type WorkspaceSnapshot struct {
+	URL  string `json:"url,omitempty" yaml:"url,omitempty"`
+	Path string `json:"path,omitempty" yaml:"path,omitempty"` // the workspace directory on the instance
+}
+
+// IsSet returns true if a snapshot is set.
+func (s *WorkspaceSnapshot) IsSet() bool {
+	return s != nil && s.URL != ""
+}
+
+// PoolCredentials are the short-lived cloud credentials passed to the steps of the stages of a
+// pool. The runner exchanges the identity token it issues to the stage for them, the clouds trust
+// the runner as an OpenID Connect provider.
+type PoolCredentials struct {
+	// Audience is the audience of the identity token passed to the steps as is, e.g. for vault.
+	// No token is passed if empty.
+	Audience string            `json:"audience,omitempty" yaml:"audience,omitempty"`
+	Claims   map[string]string `json:"claims,omitempty" yaml:"claims,omitempty"`     // added to the claims of the tokens
+	TTLMins  int64             `json:"ttl_mins,omitempty" yaml:"ttl_mins,omitempty"` // 60 if zero
+	AWS      *AWSCredentials   `json:"aws,omitempty" yaml:"aws,omitempty"`
+	GCP      *GCPCredentials   `json:"gcp,omitempty" yaml:"gcp,omitempty"`
+	Azure    *AzureCredentials `json:"azure,omitempty" yaml:"azure,omitempty"`
+}
+
+// IsSet returns true if the pool passes credentials to the steps.
+func (c *PoolCredentials) IsSet() bool {
+	return c.Audience != "" || c.AWS != nil || c.GCP != nil || c.Azure != nil
+}
+
+// AWSCredentials are the credentials of a role assumed with the identity token.
+type AWSCredentials struct {
+	RoleARN  string `json:"role_arn" yaml:"role_arn"`
+	Region   string `json:"region,omitempty" yaml:"region,omitempty"`
+	Audience string `json:"audience,omitempty" yaml:"audience,omitempty"` // sts.amazonaws.com if empty
+}
+
+// GCPCredentials are the credentials of a workload identity provider, or of a service account
+// impersonated through it.
+type GCPCredentials struct {
+	// Provider is the resource name of the provider, e.g.
+	// //iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/ci/providers/runner
+	Provider       string `json:"provider" yaml:"provider"`
+	ServiceAccount string `json:"service_account,omitempty" yaml:"service_account,omitempty"`
+	Audience       string `json:"audience,omitempty" yaml:"audience,omitempty"` // https: and the provider if empty
+}
+
+// AzureCredentials pass the identity token to the steps as the federated token of an application.
+type AzureCredentials struct {
+	TenantID string `json:"tenant_id" yaml:"tenant_id"`
+	ClientID string `json:"client_id" yaml:"client_id"`
+	Audience string `json:"audience,omitempty" yaml:"audience,omitempty"` // api://AzureADTokenExchange if empty
+}
+
+// InstanceProfile is the cloud identity attached to the instances of a pool, the steps reach the
+// cloud with the credentials of the metadata service of the instance instead of injected keys.
+// Only the identity of the provider of the pool is used, a template shared by the pools of several
+// clouds can set the identities of all of them. The instances of the untrusted stages get no identity.
+type InstanceProfile struct {
+	AWS   string `json:"aws,omitempty" yaml:"aws,omitempty"`     // the name or the arn of an instance profile
+	GCP   string `json:"gcp,omitempty" yaml:"gcp,omitempty"`     // the email of a service account
+	Azure string `json:"azure,omitempty" yaml:"azure,omitempty"` // the resource ID of a user-assigned managed identity
+}
+
+// PoolSchedule puts a pool to sleep during the off-hours: its free instances are hibernated or
+// destroyed and it is not refilled until it wakes up. The times are cron expressions, e.g. the
+// pool sleeps at night and during the weekends with sleep "0 20 * * 1-5" and wake "0 7 * * 1-5".
+type PoolSchedule struct {
+	Sleep    string `json:"sleep,omitempty" yaml:"sleep,omitempty"`
+	Wake     string `json:"wake,omitempty" yaml:"wake,omitempty"`
+	Timezone string `json:"timezone,omitempty" yaml:"timezone,omitempty"` // e.g. Europe/Berlin, UTC if unset
+	Action   string `json:"action,omitempty" yaml:"action,omitempty"`     // hibernate (default) or destroy
+}
+
+// IsSet returns true if the pool has a schedule.
+func (s PoolSchedule) IsSet() bool {
+	return s.Sleep != "" || s.Wake != ""
+}
+
+// Timeouts bound the operations of the driver of a pool on its instances, e.g. for slow storage
+// backends or big Windows images. The operations without a timeout run until the driver gives up.
+type Timeouts struct {
+	CreateSecs    int64 `json:"create_secs,omitempty" yaml:"create_secs,omitempty"` // the creation and the resize of an instance
+	DestroySecs   int64 `json:"destroy_secs,omitempty" yaml:"destroy_secs,omitempty"`
+	StartSecs     int64 `json:"start_secs,omitempty" yaml:"start_secs,omitempty"`
+	HibernateSecs int64 `json:"hibernate_secs,omitempty" yaml:"hibernate_secs,omitempty"`
+	// BootSecs bounds the boot of a new or a started instance until its lite-engine is healthy, 10 minutes if unset.
+	BootSecs int64 `json:"boot_secs,omitempty" yaml:"boot_secs,omitempty"`
+}
+
+// Bootstrap defines how the lite-engine is installed on a new instance. By default the
+// startup script is passed as user data, with the ssh mode the runner connects to the
+// instance and runs the startup script itself.
+type Bootstrap struct {
+	Mode           string `json:"mode,omitempty" yaml:"mode,omitempty"` // user-data (default) or ssh
+	User           string `json:"user,omitempty" yaml:"user,omitempty"`
+	Port           int    `json:"port,omitempty" yaml:"port,omitempty"`
+	PrivateKeyPath string `json:"private_key_path,omitempty" yaml:"private_key_path,omitempty"`
+	// KnownHostsPath is a known_hosts file the host key of the instance is verified against.
+	// If not set the first host key presented by the instance is trusted, unless ConsoleHostKeys is set.
+	KnownHostsPath string `json:"known_hosts_path,omitempty" yaml:"known_hosts_path,omitempty"`
+	TimeoutSecs    int64  `json:"timeout_secs,omitempty" yaml:"timeout_secs,omitempty"`
+	// ConsoleHostKeys verifies the host key of the instance against the host keys it printed to its console, as
+	// cloud-init does, instead of trusting the first host key, for the drivers reading the console output.
+	ConsoleHostKeys bool `json:"console_host_keys,omitempty" yaml:"console_host_keys,omitempty"`
+	// Slim only installs the lite-engine and its certificates, for the images with docker, git and
+	// the plugin binary pre-installed.
+	Slim bool `json:"slim,omitempty" yaml:"slim,omitempty"`
+	// Tuning adjusts the kernel of the linux instances before the lite-engine is started.
+	Tuning Tuning `json:"tuning,omitempty" yaml:"tuning,omitempty"`
+	// Checksums verify the binaries downloaded by the startup script, the boot fails on a mismatch.
+	Checksums Checksums `json:"checksums,omitempty" yaml:"checksums,omitempty"`
+	// WindowsContainers sets up the windows instances for the Windows containers.
+	WindowsContainers WindowsContainers `json:"windows_containers,omitempty" yaml:"windows_containers,omitempty"`
+}
+
+// WindowsContainers installs the Containers feature and docker on the windows instances lacking them,
+// and configures docker for the isolation of the containers. With the process isolation the containers
+// share the kernel of the host, their base images must be built for its version: the base images are
+// pulled with the tag matching the build of the host, which is also set in DRONE_WINDOWS_IMAGE_TAG.
+type WindowsContainers struct {
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// Isolation is the default isolation of the containers, process (default) or hyperv.
+	Isolation string `json:"isolation,omitempty" yaml:"isolation,omitempty"`
+	// DockerVersion is the version of docker installed on the instances without docker.
+	DockerVersion string `json:"docker_version,omitempty" yaml:"docker_version,omitempty"`
+	// BaseImages are pulled once docker runs, so the first stages don't wait for them. The images
+	// without a tag are pulled with the tag matching the host, e.g. mcr.microsoft.com/windows/servercore.
+	BaseImages []string `json:"base_images,omitempty" yaml:"base_images,omitempty"`
+}
+
+// Checksums are the hex encoded SHA256 digests of the binaries downloaded on the instances, the
+// binaries without a digest are not verified.
+type Checksums struct {
+	LiteEngine string `json:"lite_engine,omitempty" yaml:"lite_engine,omitempty"`
+	Plugin     string `json:"plugin,omitempty" yaml:"plugin,omitempty"`
+	SplitTests string `json:"split_tests,omitempty" yaml:"split_tests,omitempty"`
+}
+
+// Tuning holds the kernel settings of the linux instances, the settings not set are left to the image.
+type Tuning struct {
+	SwapSizeMB  int64 `json:"swap_size_mb,omitempty" yaml:"swap_size_mb,omitempty"`
+	MaxMapCount int64 `json:"max_map_count,omitempty" yaml:"max_map_count,omitempty"` // vm.max_map_count
+	// NoFile is the limit of open file descriptors of the lite-engine and of the login sessions.
+	NoFile               int64  `json:"nofile,omitempty" yaml:"nofile,omitempty"`
+	TransparentHugepages string `json:"transparent_hugepages,omitempty" yaml:"transparent_hugepages,omitempty"` // always, madvise or never
+}
+
+// IsSet returns whether a kernel setting is set.
+func (t Tuning) IsSet() bool {
+	return t.SwapSizeMB > 0 || t.MaxMapCount > 0 || t.NoFile > 0 || t.TransparentHugepages != ""
 }
 
 // Platform defines the target platform.
@@ -97,9 +447,101 @@ type QueryParams struct {
 	Status   InstanceState
 	Stage    string
 	Platform *Platform
+	// AccountID excludes the instances of the other accounts, the instances of no account are included.
+	AccountID string
 }
 
 type StageOwner struct {
 	StageID  string `db:"stage_id" json:"stage_id"`
 	PoolName string `db:"pool_name" json:"pool_name"`
 }
+
+// Results of the stages kept for analytics.
+const (
+	StageRunning     = "running"
+	StageCompleted   = "completed"
+	StageSetupFailed = "setup_failed"
+)
+
+// StageRecord is the placement and the durations of a stage, kept for analytics.
+type StageRecord struct {
+	StageID       string `db:"stage_id" json:"stage_id"`
+	PoolName      string `db:"pool_name" json:"pool_name"`
+	Driver        string `db:"driver" json:"driver"`
+	InstanceID    string `db:"instance_id" json:"instance_id"`
+	Region        string `db:"region" json:"region"`
+	Hibernated    bool   `db:"hibernated" json:"hibernated"`
+	BootMs        int64  `db:"boot_ms" json:"boot_ms"`                 // from the request until the lite-engine was healthy
+	HealthCheckMs int64  `db:"health_check_ms" json:"health_check_ms"` // spent waiting for the lite-engine to be healthy
+	RunMs         int64  `db:"run_ms" json:"run_ms"`                   // from the request until the instance was destroyed
+	Result        string `db:"result" json:"result"`
+	Started       int64  `db:"started" json:"started"`
+	Size          string `db:"size" json:"size"` // the machine type of the instance
+	// the resources of the instance and their peak utilization during the stage, zero if the stage was not sampled
+	CPUs       int   `db:"cpus" json:"cpus"`
+	MemTotalMB int64 `db:"mem_total_mb" json:"mem_total_mb"`
+	PeakCPUPct int   `db:"peak_cpu_pct" json:"peak_cpu_pct"`
+	PeakMemMB  int64 `db:"peak_mem_mb" json:"peak_mem_mb"`
+}
+
+// ResourceUsage is the peak utilization of the instance of a stage, sampled through its lite-engine.
+type ResourceUsage struct {
+	CPUs       int
+	MemTotalMB int64
+	PeakCPUPct int // of all the CPUs
+	PeakMemMB  int64
+}
+
+// StageRecordQuery filters the stage records, the zero values match all the records.
+type StageRecordQuery struct {
+	PoolName string
+	Since    int64 // unix time
+}
+
+// DestroyRetry is a failed instance destroy queued to be retried.
+type DestroyRetry struct {
+	InstanceID  string `db:"instance_id" json:"instance_id"`
+	PoolName    string `db:"pool_name" json:"pool_name"`
+	Attempts    int    `db:"attempts" json:"attempts"`
+	LastError   string `db:"last_error" json:"last_error"`
+	NextAttempt int64  `db:"next_attempt" json:"next_attempt"`
+	Created     int64  `db:"created" json:"created"`
+}
+
+// PoolMapping routes the stages of an account for a pool to another pool, e.g. to move an account
+// to a dedicated pool without a redeploy of the runners.
+type PoolMapping struct {
+	AccountID  string `db:"account_id" json:"account_id"`
+	PoolName   string `db:"pool_name" json:"pool_name"`
+	TargetPool string `db:"target_pool" json:"target_pool"`
+	Updated    int64  `db:"updated" json:"updated"`
+}
+
+// TaskState is the progress of a dlite task recorded in the task journal.
+type TaskState string
+
+// States of the dlite tasks in the task journal.
+const (
+	TaskAcquired TaskState = "acquired" // the task is acquired, its handler runs
+	TaskExecuted TaskState = "executed" // the handler completed, its response is not sent yet
+)
+
+// TaskJournalEntry records a dlite task accepted by a runner until its status is sent to the
+// manager, so the tasks interrupted by a restart of the runner are settled on its startup rather
+// than left running.
+type TaskJournalEntry struct {
+	TaskID    string    `db:"task_id" json:"task_id"`
+	TaskType  string    `db:"task_type" json:"task_type"`
+	Runner    string    `db:"runner" json:"runner"`
+	StageID   string    `db:"stage_id" json:"stage_id"`
+	PoolID    string    `db:"pool_id" json:"pool_id"`
+	AccountID string    `db:"account_id" json:"account_id"`
+	State     TaskState `db:"state" json:"state"`
+	// Task is the task, encoded, of the tasks executed again on the startup, the destroys. It is not
+	// kept for the other tasks, their data carries the secrets of the steps.
+	Task string `db:"task" json:"task"`
+	// Response is the response of the executed task, sent again on the startup.
+	Response string `db:"response" json:"response"`
+	Created  int64  `db:"created" json:"created"`
+	Updated  int64  `db:"updated" json:"updated"`
+}